@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Achievement metrics computed from a user's session history.
+const (
+	metricSessions        = "sessions"
+	metricTotalExercises  = "total_exercises"
+	metricStreakDays      = "streak_days"
+	metricPerfectSessions = "perfect_sessions"
+)
+
+type Achievement struct {
+	Key         string     `json:"key"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Metric      string     `json:"metric"`
+	Threshold   int        `json:"threshold"`
+	Progress    int        `json:"progress"`
+	UnlockedAt  *time.Time `json:"unlocked_at,omitempty"`
+}
+
+var defaultAchievements = []Achievement{
+	{Key: "first_session", Name: "First Steps", Description: "Complete your first practice session.", Metric: metricSessions, Threshold: 1},
+	{Key: "exercises_100", Name: "Century", Description: "Complete 100 exercises.", Metric: metricTotalExercises, Threshold: 100},
+	{Key: "exercises_1000", Name: "Grammar Machine", Description: "Complete 1000 exercises.", Metric: metricTotalExercises, Threshold: 1000},
+	{Key: "streak_7", Name: "Week Warrior", Description: "Practice 7 days in a row.", Metric: metricStreakDays, Threshold: 7},
+	{Key: "streak_30", Name: "Unstoppable", Description: "Practice 30 days in a row.", Metric: metricStreakDays, Threshold: 30},
+	{Key: "perfect_session", Name: "Flawless", Description: "Finish a session without any mistakes.", Metric: metricPerfectSessions, Threshold: 1},
+}
+
+// Initialize the achievement definitions table with the built-in badges
+func initializeDefaultAchievements() {
+	table := airtableClient.GetTable(airtableBaseID, achievementsTableName)
+	existing, err := table.GetRecords().MaxRecords(1).Do()
+	if err != nil {
+		log.Printf("Warning: Could not check existing achievements, using built-in definitions: %v", err)
+		return
+	}
+	if len(existing.Records) > 0 {
+		return
+	}
+
+	var records []*airtable.Record
+	for _, a := range defaultAchievements {
+		records = append(records, &airtable.Record{
+			Fields: map[string]any{
+				"Key":         a.Key,
+				"Name":        a.Name,
+				"Description": a.Description,
+				"Metric":      a.Metric,
+				"Threshold":   a.Threshold,
+			},
+		})
+	}
+
+	// Airtable accepts at most 10 records per request
+	for start := 0; start < len(records); start += 10 {
+		end := min(start+10, len(records))
+		if _, err := table.AddRecords(&airtable.Records{Records: records[start:end]}); err != nil {
+			log.Printf("Warning: Could not create default achievements: %v", err)
+			return
+		}
+	}
+	log.Printf("Created %d default achievements", len(records))
+}
+
+// getAchievementDefinitions loads the badge definitions, falling back to the built-in set.
+func getAchievementDefinitions() []Achievement {
+	table := airtableClient.GetTable(airtableBaseID, achievementsTableName)
+	records, err := getAllRecords(table.GetRecords())
+	if err != nil || len(records.Records) == 0 {
+		return defaultAchievements
+	}
+
+	var definitions []Achievement
+	for _, record := range records.Records {
+		var a Achievement
+		if val, ok := record.Fields["Key"].(string); ok {
+			a.Key = val
+		}
+		if val, ok := record.Fields["Name"].(string); ok {
+			a.Name = val
+		}
+		if val, ok := record.Fields["Description"].(string); ok {
+			a.Description = val
+		}
+		if val, ok := record.Fields["Metric"].(string); ok {
+			a.Metric = val
+		}
+		if val, ok := record.Fields["Threshold"].(float64); ok {
+			a.Threshold = int(val)
+		}
+		if a.Key != "" && a.Metric != "" {
+			definitions = append(definitions, a)
+		}
+	}
+	return definitions
+}
+
+// getUserUnlocks returns the unlock time of each achievement the user has earned.
+func getUserUnlocks(userID string) (map[string]time.Time, error) {
+	table := airtableClient.GetTable(airtableBaseID, userAchievementsTableName)
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(fmt.Sprintf("{UserID} = '%s'", userID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user achievements from Airtable: %v", err)
+	}
+
+	unlocks := make(map[string]time.Time)
+	for _, record := range records.Records {
+		key, _ := record.Fields["AchievementKey"].(string)
+		if key == "" {
+			continue
+		}
+		var unlockedAt time.Time
+		if val, ok := record.Fields["UnlockedAt"].(string); ok {
+			unlockedAt, _ = time.Parse(time.RFC3339, val)
+		}
+		unlocks[key] = unlockedAt
+	}
+	return unlocks, nil
+}
+
+func addUserUnlocks(userID string, keys []string, unlockedAt time.Time) error {
+	table := airtableClient.GetTable(airtableBaseID, userAchievementsTableName)
+	var records []*airtable.Record
+	for _, key := range keys {
+		records = append(records, &airtable.Record{
+			Fields: map[string]any{
+				"UserID":         userID,
+				"AchievementKey": key,
+				"UnlockedAt":     unlockedAt.Format(time.RFC3339),
+			},
+		})
+	}
+
+	for start := 0; start < len(records); start += 10 {
+		end := min(start+10, len(records))
+		if _, err := table.AddRecords(&airtable.Records{Records: records[start:end]}); err != nil {
+			return fmt.Errorf("failed to save user achievements: %v", err)
+		}
+	}
+	return nil
+}
+
+// practiceDays returns the set of calendar days (UTC) on which the user completed a session.
+func practiceDays(sessions []*Session) map[string]bool {
+	days := make(map[string]bool)
+	for _, s := range sessions {
+		days[s.CompletedAt.UTC().Format(time.DateOnly)] = true
+	}
+	return days
+}
+
+// currentStreak counts consecutive practice days ending today, or yesterday if the
+// user has not practised yet today.
+func currentStreak(sessions []*Session, now time.Time) int {
+	days := practiceDays(sessions)
+	day := now.UTC()
+	if !days[day.Format(time.DateOnly)] {
+		day = day.AddDate(0, 0, -1)
+	}
+
+	streak := 0
+	for days[day.Format(time.DateOnly)] {
+		streak++
+		day = day.AddDate(0, 0, -1)
+	}
+	return streak
+}
+
+func computeAchievementMetrics(sessions []*Session, now time.Time) map[string]int {
+	metrics := map[string]int{
+		metricSessions:   len(sessions),
+		metricStreakDays: currentStreak(sessions, now),
+	}
+	for _, s := range sessions {
+		metrics[metricTotalExercises] += s.Exercises
+		if s.Exercises > 0 && s.Mistakes == 0 {
+			metrics[metricPerfectSessions]++
+		}
+	}
+	return metrics
+}
+
+// getUserAchievements returns every achievement with the user's progress and unlock time.
+func getUserAchievements(userID string) ([]*Achievement, error) {
+	sessions, err := getUserSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	unlocks, err := getUserUnlocks(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := computeAchievementMetrics(sessions, time.Now())
+	var achievements []*Achievement
+	for _, definition := range getAchievementDefinitions() {
+		a := definition
+		a.Progress = min(metrics[a.Metric], a.Threshold)
+		if unlockedAt, ok := unlocks[a.Key]; ok {
+			a.UnlockedAt = &unlockedAt
+		}
+		achievements = append(achievements, &a)
+	}
+	return achievements, nil
+}
+
+// evaluateAchievements unlocks any achievement whose threshold the user has reached
+// and returns the newly unlocked ones.
+func evaluateAchievements(userID string) ([]*Achievement, error) {
+	achievements, err := getUserAchievements(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	unlocked := []*Achievement{}
+	var keys []string
+	for _, a := range achievements {
+		if a.UnlockedAt == nil && a.Threshold > 0 && a.Progress >= a.Threshold {
+			a.UnlockedAt = &now
+			unlocked = append(unlocked, a)
+			keys = append(keys, a.Key)
+		}
+	}
+
+	if len(keys) > 0 {
+		if err := addUserUnlocks(userID, keys, now); err != nil {
+			return nil, err
+		}
+	}
+	return unlocked, nil
+}
+
+func handleUserAchievements(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	achievements, err := getUserAchievements(userID)
+	if err != nil {
+		writeError(w, "Failed to get achievements", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]*Achievement{"achievements": achievements})
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+const (
+	analyticsFlushInterval = 10 * time.Second
+	maxQueuedAnalytics     = 10000  // events waiting to be written; more are dropped
+	maxMemoryAnalytics     = 100000 // events kept with in-memory storage; the oldest are dropped
+	defaultAnalyticsDays   = 7
+	maxAnalyticsDays       = 366
+	analyticsDateLayout    = "2006-01-02"
+)
+
+// Analytics event types
+const (
+	eventExercisesServed  = "exercises_served"  // one POST /api/exercises; Count exercises were served
+	eventGeneration       = "generation"        // one successful generation call; Count exercises were generated
+	eventGenerationFailed = "generation_failed" // a generation call that failed
+)
+
+// AnalyticsEvent is one usage event. The handlers record events as they happen and the
+// admin analytics endpoints aggregate them by day and by topic.
+type AnalyticsEvent struct {
+	Type      string
+	UserID    string // the progress owner, so guests count as users too
+	TopicID   string
+	Count     int
+	CacheHit  bool // exercises_served: the cache held enough eligible exercises for the request
+	CreatedAt time.Time
+}
+
+var (
+	analyticsMutex  sync.Mutex
+	analyticsQueue  []AnalyticsEvent // waiting for the next flush to the AnalyticsEvents table
+	analyticsMemory []AnalyticsEvent // every event, with in-memory storage
+)
+
+// recordEvent records an analytics event. With Airtable the event is queued and written
+// in batches by the flusher, so handlers never wait on it.
+func recordEvent(event AnalyticsEvent) {
+	event.CreatedAt = time.Now().UTC()
+
+	analyticsMutex.Lock()
+	defer analyticsMutex.Unlock()
+	if airtableBaseID == "" {
+		analyticsMemory = append(analyticsMemory, event)
+		if len(analyticsMemory) > maxMemoryAnalytics {
+			analyticsMemory = analyticsMemory[len(analyticsMemory)-maxMemoryAnalytics:]
+		}
+		return
+	}
+	if len(analyticsQueue) >= maxQueuedAnalytics {
+		log.Printf("Warning: analytics queue is full, dropping %s event", event.Type)
+		return
+	}
+	analyticsQueue = append(analyticsQueue, event)
+}
+
+// startAnalyticsFlusher writes queued events to the AnalyticsEvents table every analyticsFlushInterval.
+func startAnalyticsFlusher() {
+	if airtableBaseID == "" {
+		return // In-memory storage
+	}
+	go func() {
+		for {
+			time.Sleep(analyticsFlushInterval)
+			flushAnalyticsEvents()
+		}
+	}()
+}
+
+func flushAnalyticsEvents() {
+	analyticsMutex.Lock()
+	events := analyticsQueue
+	analyticsQueue = nil
+	analyticsMutex.Unlock()
+
+	table := airtableClient.GetTable(airtableBaseID, analyticsEventsTableName)
+	for start := 0; start < len(events); start += 10 {
+		end := min(start+10, len(events))
+		records := &airtable.Records{}
+		for _, event := range events[start:end] {
+			records.Records = append(records.Records, &airtable.Record{
+				Fields: map[string]any{
+					"Type":      event.Type,
+					"UserID":    event.UserID,
+					"TopicID":   event.TopicID,
+					"Count":     event.Count,
+					"CacheHit":  event.CacheHit,
+					"CreatedAt": event.CreatedAt.Format(time.RFC3339),
+				},
+			})
+		}
+		if _, err := table.AddRecords(records); err != nil {
+			log.Printf("Warning: failed to store %d analytics events: %v", end-start, err)
+		}
+	}
+}
+
+// getAnalyticsEvents returns the events created in [from, to).
+func getAnalyticsEvents(from, to time.Time) ([]AnalyticsEvent, error) {
+	if airtableBaseID == "" {
+		analyticsMutex.Lock()
+		defer analyticsMutex.Unlock()
+		var events []AnalyticsEvent
+		for _, event := range analyticsMemory {
+			if !event.CreatedAt.Before(from) && event.CreatedAt.Before(to) {
+				events = append(events, event)
+			}
+		}
+		return events, nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, analyticsEventsTableName)
+	formula := fmt.Sprintf("AND(NOT(IS_BEFORE({CreatedAt}, '%s')), IS_BEFORE({CreatedAt}, '%s'))",
+		from.Format(time.RFC3339), to.Format(time.RFC3339))
+	records, err := getAllRecords(table.GetRecords().
+		ReturnFields("Type", "UserID", "TopicID", "Count", "CacheHit", "CreatedAt").
+		WithFilterFormula(formula))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get analytics events from Airtable: %v", err)
+	}
+
+	var events []AnalyticsEvent
+	for _, record := range records.Records {
+		events = append(events, analyticsEventFromRecord(record))
+	}
+	return events, nil
+}
+
+func analyticsEventFromRecord(record *airtable.Record) AnalyticsEvent {
+	var event AnalyticsEvent
+	if val, ok := record.Fields["Type"].(string); ok {
+		event.Type = val
+	}
+	if val, ok := record.Fields["UserID"].(string); ok {
+		event.UserID = val
+	}
+	if val, ok := record.Fields["TopicID"].(string); ok {
+		event.TopicID = val
+	}
+	if val, ok := record.Fields["Count"].(float64); ok {
+		event.Count = int(val)
+	}
+	if val, ok := record.Fields["CacheHit"].(bool); ok {
+		event.CacheHit = val
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			event.CreatedAt = t.UTC()
+		}
+	}
+	return event
+}
+
+// AnalyticsTotals aggregates the events of a day, a topic or the whole range.
+type AnalyticsTotals struct {
+	ActiveUsers        int     `json:"active_users"`
+	Requests           int     `json:"requests"`
+	ExercisesServed    int     `json:"exercises_served"`
+	CacheHits          int     `json:"cache_hits"`
+	CacheMisses        int     `json:"cache_misses"`
+	CacheHitRate       float64 `json:"cache_hit_rate"`
+	Generations        int     `json:"generations"`
+	ExercisesGenerated int     `json:"exercises_generated"`
+	GenerationFailures int     `json:"generation_failures"`
+
+	users map[string]bool
+}
+
+func (t *AnalyticsTotals) add(event AnalyticsEvent) {
+	switch event.Type {
+	case eventExercisesServed:
+		t.Requests++
+		t.ExercisesServed += event.Count
+		if event.CacheHit {
+			t.CacheHits++
+		} else {
+			t.CacheMisses++
+		}
+		if event.UserID != "" {
+			if t.users == nil {
+				t.users = make(map[string]bool)
+			}
+			t.users[event.UserID] = true
+			t.ActiveUsers = len(t.users)
+		}
+	case eventGeneration:
+		t.Generations++
+		t.ExercisesGenerated += event.Count
+	case eventGenerationFailed:
+		t.GenerationFailures++
+	}
+	if t.CacheHits+t.CacheMisses > 0 {
+		t.CacheHitRate = float64(t.CacheHits) / float64(t.CacheHits+t.CacheMisses)
+	}
+}
+
+type AnalyticsDay struct {
+	Date string `json:"date"`
+	AnalyticsTotals
+}
+
+type AnalyticsTopic struct {
+	TopicID   string `json:"topic_id"`
+	TopicName string `json:"topic_name,omitempty"`
+	AnalyticsTotals
+}
+
+// parseAnalyticsRange reads the time range from ?days=7 (the last N days including today)
+// or ?from=2024-01-01&to=2024-01-31 (both inclusive, UTC). It returns [from, to).
+func parseAnalyticsRange(r *http.Request, now time.Time) (time.Time, time.Time, error) {
+	query := r.URL.Query()
+	today := now.UTC().Truncate(24 * time.Hour)
+
+	if query.Get("from") != "" || query.Get("to") != "" {
+		if query.Get("days") != "" {
+			return time.Time{}, time.Time{}, fmt.Errorf("use either days or from/to")
+		}
+		from, err := time.Parse(analyticsDateLayout, query.Get("from"))
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from must be a date like 2024-01-31")
+		}
+		to := today
+		if value := query.Get("to"); value != "" {
+			if to, err = time.Parse(analyticsDateLayout, value); err != nil {
+				return time.Time{}, time.Time{}, fmt.Errorf("to must be a date like 2024-01-31")
+			}
+		}
+		to = to.AddDate(0, 0, 1)
+		if !from.Before(to) {
+			return time.Time{}, time.Time{}, fmt.Errorf("from must not be after to")
+		}
+		if to.Sub(from) > maxAnalyticsDays*24*time.Hour {
+			return time.Time{}, time.Time{}, fmt.Errorf("the range can be at most %d days", maxAnalyticsDays)
+		}
+		return from, to, nil
+	}
+
+	days := defaultAnalyticsDays
+	if value := query.Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxAnalyticsDays {
+			return time.Time{}, time.Time{}, fmt.Errorf("days must be between 1 and %d", maxAnalyticsDays)
+		}
+		days = n
+	}
+	return today.AddDate(0, 0, 1-days), today.AddDate(0, 0, 1), nil
+}
+
+// Handle analytics (admin), over ?days=7 or ?from=&to=:
+// GET /api/admin/analytics returns the totals for the range,
+// GET /api/admin/analytics/daily one entry per day,
+// GET /api/admin/analytics/topics the topics by exercises served (?limit=20).
+func handleAdminAnalytics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	view := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/analytics"), "/")
+	if view != "" && view != "daily" && view != "topics" {
+		writeError(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	from, to, err := parseAnalyticsRange(r, time.Now())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" && view == "topics" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 200 {
+			writeError(w, "limit must be between 1 and 200", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	events, err := getAnalyticsEvents(from, to)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get analytics events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]any{
+		"from": from.Format(analyticsDateLayout),
+		"to":   to.AddDate(0, 0, -1).Format(analyticsDateLayout),
+	}
+	switch view {
+	case "":
+		var totals AnalyticsTotals
+		for _, event := range events {
+			totals.add(event)
+		}
+		response["totals"] = totals
+
+	case "daily":
+		byDate := make(map[string]*AnalyticsDay)
+		days := []*AnalyticsDay{}
+		for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+			entry := &AnalyticsDay{Date: day.Format(analyticsDateLayout)}
+			byDate[entry.Date] = entry
+			days = append(days, entry)
+		}
+		for _, event := range events {
+			if entry, ok := byDate[event.CreatedAt.Format(analyticsDateLayout)]; ok {
+				entry.add(event)
+			}
+		}
+		response["days"] = days
+
+	case "topics":
+		byTopic := make(map[string]*AnalyticsTopic)
+		for _, event := range events {
+			if event.TopicID == "" {
+				continue
+			}
+			entry, ok := byTopic[event.TopicID]
+			if !ok {
+				entry = &AnalyticsTopic{TopicID: event.TopicID}
+				byTopic[event.TopicID] = entry
+			}
+			entry.add(event)
+		}
+		if topics, err := dataStore.GetAllTopics(); err == nil {
+			for _, topic := range topics {
+				if entry, ok := byTopic[topic.ID]; ok {
+					entry.TopicName = topic.Name
+				}
+			}
+		} else {
+			log.Printf("Warning: failed to get topic names for analytics: %v", err)
+		}
+
+		topics := []*AnalyticsTopic{}
+		for _, entry := range byTopic {
+			topics = append(topics, entry)
+		}
+		sort.Slice(topics, func(i, j int) bool {
+			if topics[i].ExercisesServed != topics[j].ExercisesServed {
+				return topics[i].ExercisesServed > topics[j].ExercisesServed
+			}
+			return topics[i].TopicID < topics[j].TopicID
+		})
+		if len(topics) > limit {
+			topics = topics[:limit]
+		}
+		response["topics"] = topics
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Answers are checked on the server, so exercises served to the browser carry their words
+// in random order instead of the correct sentence. A word ordering is accepted if it
+// matches the sentence, one of the exercise's alternative_sentences, or the sentence with
+// its subordinate clause moved to the front (see frontedClauseOrdering). Words are compared
+// ignoring case, since a fronted clause starts with what was a lowercase conjunction.
+//
+// Clients authenticated with a personal access token (the CLI, the Go client, gRPC) still
+// get the full exercises, because their drills check answers themselves.
+
+const (
+	maxAnswerWords  = 100
+	answerCheckKeep = 24 * time.Hour // how long a checked answer allows an explanation
+)
+
+// answerChecks holds when each owner last checked an answer to an exercise, keyed by owner
+// and exercise ID. Explanations give the sentence away, so they need a checked answer first.
+var (
+	answerChecksMu     sync.Mutex
+	answerChecks       = make(map[string]time.Time)
+	answerChecksPruned time.Time
+)
+
+// Subordinating conjunctions whose clause can move in front of the main clause
+var subordinatingConjunctions = map[string]bool{
+	"weil": true, "da": true, "obwohl": true, "obgleich": true, "wenn": true, "falls": true,
+	"als": true, "dass": true, "ob": true, "bevor": true, "nachdem": true, "während": true,
+	"damit": true, "sobald": true, "solange": true, "seit": true, "seitdem": true, "bis": true,
+	"indem": true, "sodass": true,
+}
+
+// Subjects that swap places with the verb when the main clause follows its subordinate clause
+var pronounSubjects = map[string]bool{
+	"ich": true, "du": true, "er": true, "sie": true, "es": true, "wir": true, "ihr": true, "man": true,
+}
+
+// AnswerWord is a submitted word and whether it is in the right place.
+type AnswerWord struct {
+	Word    string `json:"word"`
+	Correct bool   `json:"correct"`
+}
+
+// AnswerCheck is the result of POST /api/exercises/{id}/check. The sentence is only
+// included once the answer is correct.
+type AnswerCheck struct {
+	Correct  bool         `json:"correct"`
+	Words    []AnswerWord `json:"words"`
+	Sentence string       `json:"sentence,omitempty"`
+}
+
+// AnswerHint is the next word to place, at its position counting words only.
+type AnswerHint struct {
+	Position int    `json:"position"`
+	Word     string `json:"word"`
+}
+
+// exerciseByID looks up a cached exercise, with its typed fields set.
+func exerciseByID(exerciseID string) (*Exercise, error) {
+	ex, err := dataStore.GetExercise(exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	if ex.Sentence == "" {
+		if content, err := parseExerciseContent(ex.ExerciseJSON); err == nil {
+			ex.setContent(content)
+		}
+	}
+	return ex, nil
+}
+
+// sameWords reports whether two orderings hold the same words, ignoring case.
+func sameWords(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int)
+	for _, word := range a {
+		counts[strings.ToLower(word)]++
+	}
+	for _, word := range b {
+		counts[strings.ToLower(word)]--
+		if counts[strings.ToLower(word)] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// frontedClauseOrdering turns "Ich lerne, weil ich will." into "Weil ich will, lerne ich":
+// a main clause starting with a pronoun subject, followed by one subordinate clause. It
+// returns nil for sentences of any other shape.
+func frontedClauseOrdering(tokens []string) []string {
+	comma := -1
+	for i, token := range tokens {
+		if token == "," {
+			if comma >= 0 {
+				return nil // more than two clauses
+			}
+			comma = i
+		}
+	}
+	if comma < 0 {
+		return nil
+	}
+	main := sentenceWords(strings.Join(tokens[:comma], " "))
+	sub := sentenceWords(strings.Join(tokens[comma+1:], " "))
+	if len(main) < 2 || len(sub) < 2 || !subordinatingConjunctions[strings.ToLower(sub[0])] || !pronounSubjects[strings.ToLower(main[0])] {
+		return nil
+	}
+	// In the main clause after a fronted clause, the verb comes first, then the subject
+	ordering := append([]string{}, sub...)
+	ordering = append(ordering, main[1], main[0])
+	return append(ordering, main[2:]...)
+}
+
+// acceptedOrderings returns the word orderings accepted for an exercise, the sentence's first.
+func acceptedOrderings(ex *Exercise) [][]string {
+	orderings := [][]string{sentenceWords(ex.Sentence)}
+	if fronted := frontedClauseOrdering(tokenizeSentence(ex.Sentence)); fronted != nil {
+		orderings = append(orderings, fronted)
+	}
+	for _, sentence := range ex.alternativeSentences() {
+		orderings = append(orderings, sentenceWords(sentence))
+	}
+	return orderings
+}
+
+// checkAnswer compares a word ordering with the accepted ones. Per-word correctness is
+// against the accepted ordering the answer is closest to.
+func checkAnswer(ex *Exercise, words []string) AnswerCheck {
+	var best []AnswerWord
+	bestMatches := -1
+	for _, ordering := range acceptedOrderings(ex) {
+		result := make([]AnswerWord, len(words))
+		matches := 0
+		for i, word := range words {
+			result[i] = AnswerWord{Word: word, Correct: i < len(ordering) && strings.EqualFold(word, ordering[i])}
+			if result[i].Correct {
+				matches++
+			}
+		}
+		if matches == len(words) && len(words) == len(ordering) {
+			return AnswerCheck{Correct: true, Words: result, Sentence: ex.Sentence}
+		}
+		if matches > bestMatches {
+			best, bestMatches = result, matches
+		}
+	}
+	return AnswerCheck{Words: best}
+}
+
+// nextHint returns the next word after the longest correct start of the placed words.
+// The position is where the placed words stop being right, so later ones should be removed.
+// It returns false once the words are a complete accepted ordering.
+func nextHint(ex *Exercise, words []string) (AnswerHint, bool) {
+	var hint AnswerHint
+	found := false
+	for _, ordering := range acceptedOrderings(ex) {
+		prefix := 0
+		for prefix < len(words) && prefix < len(ordering) && strings.EqualFold(words[prefix], ordering[prefix]) {
+			prefix++
+		}
+		if prefix == len(ordering) && len(words) == len(ordering) {
+			return AnswerHint{}, false
+		}
+		if prefix < len(ordering) && (!found || prefix > hint.Position) {
+			hint, found = AnswerHint{Position: prefix, Word: ordering[prefix]}, true
+		}
+	}
+	return hint, found
+}
+
+// withoutAnswer returns a served exercise without its correct sentence and alternatives,
+// and with its words shuffled in their place, mixed with its distractors if it was served
+// with them. word_count is the number of words in the answer. Malformed JSON is returned as it is.
+func withoutAnswer(raw json.RawMessage) json.RawMessage {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return raw
+	}
+	var sentence string
+	json.Unmarshal(fields["correct_german_sentence"], &sentence)
+	delete(fields, "correct_german_sentence")
+	delete(fields, "alternative_sentences")
+	delete(fields, "scrambled_words") // some models add these, with the sentence's punctuation
+
+	words := sentenceWords(sentence)
+	fields["word_count"], _ = json.Marshal(len(words))
+	words = append(words, parseStringList(fields["distractors"])...)
+	delete(fields, "distractors")
+	rand.Shuffle(len(words), func(i, j int) { words[i], words[j] = words[j], words[i] })
+	fields["words"], _ = json.Marshal(words)
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return raw
+	}
+	return data
+}
+
+// servesAnswers reports whether a request gets exercises with their answers: only clients
+// using a valid personal access token do. Any other Authorization header is ignored.
+func servesAnswers(r *http.Request) bool {
+	secret, ok := bearerToken(r)
+	return ok && authenticateAPIToken(secret) != ""
+}
+
+// exercisesForClient strips the answers from served exercises unless the client gets them.
+func exercisesForClient(r *http.Request, exercises []json.RawMessage) []json.RawMessage {
+	if servesAnswers(r) {
+		return exercises
+	}
+	stripped := make([]json.RawMessage, len(exercises))
+	for i, raw := range exercises {
+		stripped[i] = withoutAnswer(raw)
+	}
+	return stripped
+}
+
+// exerciseInPlay reports whether an exercise is part of a duel the user is playing or a
+// challenge they are running. Checks, hints, explanations and spoken answers would give
+// its sentence away there, so they wait until the game is over. Guests play neither.
+func exerciseInPlay(userID, exerciseID string, now time.Time) (bool, error) {
+	inPlay, err := exercisesInPlay(userID, now)
+	return inPlay[exerciseID], err
+}
+
+// exercisesInPlay returns the IDs of the exercises in the user's running duels and challenges.
+func exercisesInPlay(userID string, now time.Time) (map[string]bool, error) {
+	inPlay := make(map[string]bool)
+	if userID == "" {
+		return inPlay, nil
+	}
+	duels, err := getActiveDuels(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, duel := range duels {
+		if now.Sub(duel.StartedAt) <= duelTimeLimit {
+			for _, id := range duel.ExerciseIDs {
+				inPlay[id] = true
+			}
+		}
+	}
+	challenges, err := getUnfinishedChallenges(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, challenge := range challenges {
+		if now.Before(challenge.EndsAt.Add(challengeGrace)) {
+			for _, id := range challenge.ExerciseIDs {
+				inPlay[id] = true
+			}
+		}
+	}
+	return inPlay, nil
+}
+
+// recordAnswerCheck notes that the owner checked an answer to the exercise. Checks older than
+// answerCheckKeep are dropped at most once an hour.
+func recordAnswerCheck(ownerID, exerciseID string, now time.Time) {
+	answerChecksMu.Lock()
+	defer answerChecksMu.Unlock()
+	if now.Sub(answerChecksPruned) > time.Hour {
+		for key, at := range answerChecks {
+			if now.Sub(at) > answerCheckKeep {
+				delete(answerChecks, key)
+			}
+		}
+		answerChecksPruned = now
+	}
+	answerChecks[ownerID+"/"+exerciseID] = now
+}
+
+// answerChecked reports whether the owner checked an answer to the exercise in the last
+// answerCheckKeep, or has answered it before and so has it in their reviews.
+func answerChecked(ownerID, exerciseID string, now time.Time) (bool, error) {
+	answerChecksMu.Lock()
+	at, ok := answerChecks[ownerID+"/"+exerciseID]
+	answerChecksMu.Unlock()
+	if ok && now.Sub(at) <= answerCheckKeep {
+		return true, nil
+	}
+	views, err := dataStore.GetUserExerciseViews(ownerID)
+	if err != nil {
+		return false, err
+	}
+	return views[exerciseID] != nil, nil
+}
+
+// Handle answers to one exercise, for users and guests:
+// POST /api/exercises/{id}/check with {"words": ["Ich", "lerne", ...]} checks a word ordering
+// and returns an AnswerCheck.
+// POST /api/exercises/{id}/hint with the words placed so far returns the next word to place.
+// Punctuation in the submitted words is ignored.
+// POST /api/exercises/{id}/flag reports a broken exercise (see exercise_quality.go).
+func handleExerciseAnswer(w http.ResponseWriter, r *http.Request) {
+	if exerciseID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/exercises/"), "/"); exerciseID != "" && action == "image" {
+		handleExerciseImage(w, r) // see exercise_images.go
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	exerciseID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/exercises/"), "/")
+	if exerciseID != "" && slices.Contains([]string{"check", "hint", "explain", "speak"}, action) {
+		inPlay, err := exerciseInPlay(getUserIDFromRequest(r), exerciseID, time.Now())
+		if err != nil {
+			log.Printf("Error checking running duels and challenges: %v", err)
+			writeError(w, "Failed to check running duels and challenges", http.StatusInternalServerError)
+			return
+		}
+		if inPlay {
+			writeError(w, "This exercise is in your running duel or challenge", http.StatusConflict)
+			return
+		}
+	}
+	if exerciseID != "" && action == "explain" {
+		rateLimited("explain", handleExerciseExplain)(w, r) // see explanations.go
+		return
+	}
+	if exerciseID != "" && action == "speak" {
+		rateLimited("speech", handleExerciseSpeech)(w, r) // see speech.go
+		return
+	}
+	if exerciseID != "" && action == "flag" {
+		rateLimited("answers", handleExerciseFlag)(w, r) // see exercise_quality.go
+		return
+	}
+	if exerciseID == "" || (action != "check" && action != "hint") {
+		writeError(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Words []string `json:"words"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Words) > maxAnswerWords {
+		writeError(w, fmt.Sprintf("words can hold at most %d words", maxAnswerWords), http.StatusBadRequest)
+		return
+	}
+	words := sentenceWords(strings.Join(req.Words, " "))
+
+	ex, err := exerciseByID(exerciseID)
+	if err != nil {
+		writeError(w, "Exercise not found", http.StatusNotFound)
+		return
+	}
+
+	if action == "check" {
+		if len(words) == 0 {
+			writeError(w, "words is required", http.StatusBadRequest)
+			return
+		}
+		recordAnswerCheck(getProgressOwnerID(w, r), ex.AirtableID, time.Now())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(checkAnswer(ex, words))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	hint, ok := nextHint(ex, words)
+	if !ok {
+		writeError(w, "The sentence is already complete", http.StatusConflict)
+		return
+	}
+	json.NewEncoder(w).Encode(hint)
+}
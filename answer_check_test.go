@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFrontedClauseOrdering(t *testing.T) {
+	tests := []struct {
+		sentence string
+		want     string
+	}{
+		{"Ich lerne Deutsch, weil ich in Berlin wohne.", "weil ich in Berlin wohne lerne Ich Deutsch"},
+		{"Er bleibt zu Hause, obwohl die Sonne scheint.", "obwohl die Sonne scheint bleibt Er zu Hause"},
+		{"Wir gehen, wenn es regnet.", "wenn es regnet gehen Wir"},
+		{"Ich lerne Deutsch.", ""},                            // one clause
+		{"Anna lernt Deutsch, weil sie in Berlin wohnt.", ""}, // the subject isn't a pronoun
+		{"Ich lerne Deutsch, und ich wohne in Berlin.", ""},   // a coordinating conjunction
+		{"Ich lerne, weil ich will, dass du stolz bist.", ""}, // more than two clauses
+		{"Weil ich in Berlin wohne, lerne ich Deutsch.", ""},  // already fronted
+	}
+	for _, tt := range tests {
+		t.Run(tt.sentence, func(t *testing.T) {
+			got := strings.Join(frontedClauseOrdering(tokenizeSentence(tt.sentence)), " ")
+			if got != tt.want {
+				t.Errorf("frontedClauseOrdering(%q) = %q, want %q", tt.sentence, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckAnswer(t *testing.T) {
+	ex := &Exercise{
+		Sentence:     "Ich lerne Deutsch, weil ich in Berlin wohne.",
+		ExerciseJSON: `{"alternative_sentences": ["Deutsch lerne ich, weil ich in Berlin wohne."]}`,
+	}
+	tests := []struct {
+		name    string
+		answer  string
+		correct bool
+		words   []bool // per-word correctness, when not correct
+	}{
+		{name: "the sentence", answer: "Ich lerne Deutsch weil ich in Berlin wohne", correct: true},
+		{name: "case is ignored", answer: "ich lerne deutsch Weil Ich in berlin wohne", correct: true},
+		{name: "fronted clause", answer: "Weil ich in Berlin wohne lerne ich Deutsch", correct: true},
+		{name: "alternative sentence", answer: "Deutsch lerne ich weil ich in Berlin wohne", correct: true},
+		{
+			name:   "verb in the wrong place",
+			answer: "Ich lerne Deutsch weil ich wohne in Berlin",
+			words:  []bool{true, true, true, true, true, false, false, false},
+		},
+		{
+			name:   "closest to the fronted clause",
+			answer: "Weil ich in Berlin wohne ich lerne Deutsch",
+			words:  []bool{true, true, true, true, true, false, false, true},
+		},
+		{
+			name:   "words missing",
+			answer: "Ich lerne Deutsch",
+			words:  []bool{true, true, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := checkAnswer(ex, strings.Fields(tt.answer))
+			if check.Correct != tt.correct {
+				t.Fatalf("Correct = %v, want %v", check.Correct, tt.correct)
+			}
+			if tt.correct {
+				if check.Sentence != ex.Sentence {
+					t.Errorf("Sentence = %q, want %q", check.Sentence, ex.Sentence)
+				}
+				return
+			}
+			if check.Sentence != "" {
+				t.Errorf("a wrong answer gave the sentence away: %q", check.Sentence)
+			}
+			got := make([]bool, len(check.Words))
+			for i, word := range check.Words {
+				got[i] = word.Correct
+			}
+			if !slices.Equal(got, tt.words) {
+				t.Errorf("word correctness = %v, want %v", got, tt.words)
+			}
+		})
+	}
+}
+
+func TestHandleExerciseAnswer(t *testing.T) {
+	useMemoryStore(t)
+	user := createTestUser(t, "learner-google-id")
+	topic, err := dataStore.InsertTopic("Weil", "Sentences with weil", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex, err := dataStore.CreateExercise(topic.ID, "hash", "", `{"correct_german_sentence": "Ich lerne Deutsch, weil ich in Berlin wohne.", "english_hint": "I learn German because I live in Berlin."}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := "/api/exercises/" + ex.AirtableID + "/check"
+	hint := "/api/exercises/" + ex.AirtableID + "/hint"
+
+	rec := serve(handleExerciseAnswer, nil, http.MethodPost, check, `{"words": ["Ich", "lerne", "Deutsch", "weil", "ich", "in", "Berlin", "wohne"]}`)
+	var result AnswerCheck
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &result) != nil || !result.Correct {
+		t.Fatalf("correct answer: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(handleExerciseAnswer, user, http.MethodPost, hint, `{"words": ["Ich", "lerne", "wohne"]}`)
+	var next AnswerHint
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &next) != nil || next != (AnswerHint{Position: 2, Word: "Deutsch"}) {
+		t.Fatalf("hint: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		target string
+		body   string
+		status int
+	}{
+		{"unknown exercise", "/api/exercises/recMissing/check", `{"words": ["Ich"]}`, http.StatusNotFound},
+		{"no words", check, `{"words": []}`, http.StatusBadRequest},
+		{"complete sentence", hint, `{"words": ["Ich", "lerne", "Deutsch", "weil", "ich", "in", "Berlin", "wohne"]}`, http.StatusConflict},
+		{"unknown action", "/api/exercises/" + ex.AirtableID + "/solve", `{}`, http.StatusNotFound},
+	} {
+		if rec := serve(handleExerciseAnswer, user, http.MethodPost, tt.target, tt.body); rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.status)
+		}
+	}
+
+}
+
+func TestExerciseInPlay(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		duel      *Duel
+		challenge *Challenge
+		userID    string
+		want      bool
+	}{
+		{name: "nothing running", userID: "recUser"},
+		{
+			name:   "active duel",
+			duel:   &Duel{Status: duelStatusActive, StartedAt: now.Add(-time.Minute)},
+			userID: "recUser",
+			want:   true,
+		},
+		{
+			name:   "duel whose time is up",
+			duel:   &Duel{Status: duelStatusActive, StartedAt: now.Add(-duelTimeLimit - time.Second)},
+			userID: "recUser",
+		},
+		{
+			name:   "finished duel",
+			duel:   &Duel{Status: duelStatusFinished, StartedAt: now.Add(-time.Minute)},
+			userID: "recUser",
+		},
+		{
+			name:   "someone else's duel",
+			duel:   &Duel{Status: duelStatusActive, StartedAt: now.Add(-time.Minute)},
+			userID: "recOther",
+		},
+		{
+			name:      "running challenge",
+			challenge: &Challenge{StartedAt: now.Add(-time.Minute), EndsAt: now.Add(time.Minute)},
+			userID:    "recUser",
+			want:      true,
+		},
+		{
+			name:      "challenge in its grace period",
+			challenge: &Challenge{StartedAt: now.Add(-time.Minute), EndsAt: now.Add(-challengeGrace / 2)},
+			userID:    "recUser",
+			want:      true,
+		},
+		{
+			name:      "challenge whose time is up",
+			challenge: &Challenge{StartedAt: now.Add(-time.Minute), EndsAt: now.Add(-challengeGrace - time.Second)},
+			userID:    "recUser",
+		},
+		{
+			name:      "finished challenge",
+			challenge: &Challenge{StartedAt: now.Add(-time.Minute), EndsAt: now.Add(time.Minute), Finished: true},
+			userID:    "recUser",
+		},
+		{
+			name:      "guest",
+			challenge: &Challenge{StartedAt: now.Add(-time.Minute), EndsAt: now.Add(time.Minute)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStore(t)
+			if tt.duel != nil {
+				tt.duel.Players = []*DuelPlayer{{UserID: "recUser"}, {UserID: "recOpponent"}}
+				tt.duel.ExerciseIDs = []string{"recExercise"}
+				if err := saveDuel(tt.duel); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.challenge != nil {
+				tt.challenge.OwnerID = "recUser"
+				tt.challenge.ExerciseIDs = []string{"recExercise"}
+				if err := saveChallenge(tt.challenge); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := exerciseInPlay(tt.userID, "recExercise", now)
+			if err != nil || got != tt.want {
+				t.Errorf("exerciseInPlay() = %v, %v; want %v", got, err, tt.want)
+			}
+			if other, _ := exerciseInPlay(tt.userID, "recUnrelated", now); other {
+				t.Error("an exercise outside the game is in play")
+			}
+		})
+	}
+}
+
+func TestHandleExerciseAnswerDuringChallenge(t *testing.T) {
+	useMemoryStore(t)
+	user := createTestUser(t, "learner-google-id")
+	ex, err := dataStore.CreateExercise("recTopic", "hash", "", `{"correct_german_sentence": "Ich lerne Deutsch, weil ich in Berlin wohne.", "english_hint": "I learn German because I live in Berlin."}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := saveChallenge(&Challenge{OwnerID: user.ID, ExerciseIDs: []string{ex.AirtableID}, StartedAt: now, EndsAt: now.Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, action := range []string{"check", "hint"} {
+		target := "/api/exercises/" + ex.AirtableID + "/" + action
+		if rec := serve(handleExerciseAnswer, user, http.MethodPost, target, `{"words": ["Ich"]}`); rec.Code != http.StatusConflict {
+			t.Errorf("%s during a challenge: status %d, want %d", action, rec.Code, http.StatusConflict)
+		}
+		if rec := serve(handleExerciseAnswer, nil, http.MethodPost, target, `{"words": ["Ich"]}`); rec.Code != http.StatusOK {
+			t.Errorf("guest %s during someone else's challenge: status %d, want %d", action, rec.Code, http.StatusOK)
+		}
+	}
+}
@@ -1,4 +1,14 @@
 document.addEventListener('DOMContentLoaded', () => {
+    // --- CSRF ---
+    // State-changing API requests must echo the csrf_token cookie in a header
+    function withCSRF(options) {
+        const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]+)/);
+        return {
+            ...options,
+            headers: { ...(options.headers || {}), 'X-CSRF-Token': match ? decodeURIComponent(match[1]) : '' },
+        };
+    }
+
     // --- DOM Elements ---
     const settingsBtn = document.getElementById('settings-btn');
     const settingsModal = document.getElementById('settings-modal');
@@ -7,18 +17,29 @@ document.addEventListener('DOMContentLoaded', () => {
     const topicDropdown = document.getElementById('topic-dropdown');
 
     const generateBtn = document.getElementById('generate-btn');
+    const dailyMixBtn = document.getElementById('daily-mix-btn');
     const hintBtn = document.getElementById('hint-btn');
     const loadingSpinner = document.getElementById('loading-spinner');
     const timer = document.getElementById('timer');
+    const loadingStatus = document.getElementById('loading-status');
     const exerciseContent = document.getElementById('exercise-content');
 
     const englishHintEl = document.getElementById('english-hint');
+    const nativeHintEl = document.getElementById('native-hint');
+    const exerciseImageEl = document.getElementById('exercise-image');
+    const difficultySelect = document.getElementById('difficulty-select');
+    const grammarTagSelect = document.getElementById('grammar-tag-select');
+    const escalateCheckbox = document.getElementById('escalate-checkbox');
     const answerArea = document.getElementById('answer-area');
     const answerPrompt = document.getElementById('answer-prompt');
     const constructedSentenceEl = document.getElementById('constructed-sentence');
     const scrambledWordsContainer = document.getElementById('scrambled-words-container');
     const feedbackArea = document.getElementById('feedback-area');
     const correctSentenceDisplay = document.getElementById('correct-sentence-display');
+    const explainBtn = document.getElementById('explain-btn');
+    const flagBtn = document.getElementById('flag-btn');
+    const offlineBadge = document.getElementById('offline-badge');
+    const explanationDisplay = document.getElementById('explanation-display');
     const exerciseCounter = document.getElementById('exercise-counter');
     const progressBar = document.getElementById('progress-bar');
     const progressPercentage = document.getElementById('progress-percentage');
@@ -41,6 +62,9 @@ document.addEventListener('DOMContentLoaded', () => {
     const currentTopicName = document.getElementById('current-topic-name');
     const promptTextarea = document.getElementById('prompt-textarea');
     const savePromptBtn = document.getElementById('save-prompt-btn');
+    const refinementEnabledCheckbox = document.getElementById('refinement-enabled-checkbox');
+    const metaPromptTextarea = document.getElementById('meta-prompt-textarea');
+    const vocabularyBandInput = document.getElementById('vocabulary-band-input');
     const cancelEditBtn = document.getElementById('cancel-edit-btn');
     
     // Version history elements
@@ -62,6 +86,7 @@ document.addEventListener('DOMContentLoaded', () => {
     // --- Application State ---
     let state = {
         currentTopicId: '',
+        mix: false, // the set is a daily mix across topics
         topics: [],
         exercises: [],
         currentExerciseIndex: 0,
@@ -77,7 +102,12 @@ document.addEventListener('DOMContentLoaded', () => {
         timerInterval: null,
         isLoggedIn: false,
         userId: null,
-        isAdmin: false
+        isAdmin: false,
+        offline: false,
+        exerciseStart: { exercise: null, mistakes: 0, hints: 0, time: 0, hintPositions: [] },
+        answered: [], // IDs of the exercises answered in the current set
+        statsKey: '', // idempotency key for saving the current set's stats
+        sessionActive: false // whether the set is kept server-side for resuming
     };
 
     // --- Sample Data ---
@@ -111,17 +141,54 @@ document.addEventListener('DOMContentLoaded', () => {
         return /^[^\p{L}\p{N}]+$/u.test(token);
     }
 
-    function addPunctuationIfNeeded(exercise, userSentence) {
-        const correctWordArray = exercise.correct_german_sentence.match(/[\p{L}\p{N}']+|[^\s\p{L}\p{N}]/gu) || [];
-        
-        while (userSentence.length < correctWordArray.length) {
-            const nextToken = correctWordArray[userSentence.length];
-            if (isPunctuation(nextToken)) {
-                userSentence.push(nextToken);
-            } else {
-                break;
-            }
+    const tokenPattern = /[\p{L}\p{N}']+|[^\s\p{L}\p{N}]/gu;
+
+    // The words to arrange. Served exercises carry them shuffled, without the answer;
+    // the built-in samples only have their sentence.
+    function exerciseWords(exercise) {
+        if (exercise.words) return [...exercise.words];
+        return (exercise.correct_german_sentence.match(tokenPattern) || []).filter(token => !isPunctuation(token));
+    }
+
+    // The number of words in the answer, which is fewer than the words offered if
+    // the learner opted in to distractors
+    function answerLength(exercise) {
+        return exercise.word_count || exerciseWords(exercise).length;
+    }
+
+    // Checks a word ordering: on the server for served exercises, locally for the samples.
+    // Resolves to { correct, words: [{ word, correct }], sentence }.
+    async function checkAnswer(exercise, words) {
+        if (exercise.id) {
+            const response = await fetch(`/api/exercises/${encodeURIComponent(exercise.id)}/check`, withCSRF({
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ words }),
+            }));
+            if (!response.ok) throw new Error('Failed to check the answer');
+            return response.json();
+        }
+        const expected = exerciseWords(exercise);
+        const result = words.map((word, i) => ({ word, correct: word === expected[i] }));
+        const correct = result.every(w => w.correct) && words.length === expected.length;
+        return { correct, words: result, sentence: correct ? exercise.correct_german_sentence : undefined };
+    }
+
+    // The next word to place after the correct start of the placed words: { position, word }
+    async function fetchHint(exercise, words) {
+        if (exercise.id) {
+            const response = await fetch(`/api/exercises/${encodeURIComponent(exercise.id)}/hint`, withCSRF({
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ words }),
+            }));
+            if (!response.ok) return null;
+            return response.json();
         }
+        const expected = exerciseWords(exercise);
+        let position = 0;
+        while (position < words.length && words[position] === expected[position]) position++;
+        return position < expected.length ? { position, word: expected[position] } : null;
     }
 
     function updateStats() {
@@ -148,8 +215,15 @@ document.addEventListener('DOMContentLoaded', () => {
         hintBtn.classList.remove('hidden');
 
         const exercise = state.exercises[state.currentExerciseIndex];
-
-        addPunctuationIfNeeded(exercise, state.userSentence);
+        if (state.exerciseStart.exercise !== exercise) {
+            // Retries re-render the same exercise; only a new one restarts its grading counters
+            state.exerciseStart = { exercise, mistakes: state.mistakes, hints: state.hintsUsed, time: Date.now(), hintPositions: [] };
+            // An explanation stays visible while the learner retries, until the next exercise
+            explainBtn.classList.add('hidden');
+            flagBtn.classList.add('hidden');
+            explanationDisplay.classList.add('hidden');
+            explanationDisplay.textContent = '';
+        }
 
         exerciseCounter.textContent = `${state.currentExerciseIndex + 1} / ${state.exercises.length}`;
         
@@ -164,26 +238,27 @@ document.addEventListener('DOMContentLoaded', () => {
 
         // Reset UI
         englishHintEl.textContent = exercise.english_hint;
+        // The hint in the learner's native language, if they set one in their profile
+        nativeHintEl.textContent = exercise.native_hint || '';
+        nativeHintEl.lang = exercise.native_language || '';
+        nativeHintEl.classList.toggle('hidden', !exercise.native_hint);
+        // The exercise's illustration, shown once it loads; exercises without one answer 404
+        const imageSrc = exercise.id ? `/api/exercises/${encodeURIComponent(exercise.id)}/image` : '';
+        if (exerciseImageEl.getAttribute('src') !== imageSrc) {
+            exerciseImageEl.classList.add('hidden');
+            if (imageSrc) {
+                exerciseImageEl.src = imageSrc;
+            } else {
+                exerciseImageEl.removeAttribute('src');
+            }
+        }
         scrambledWordsContainer.innerHTML = '';
         constructedSentenceEl.innerHTML = '';
         correctSentenceDisplay.textContent = '';
+        answerPrompt.classList.remove('hidden');
 
-        // Display initial punctuation if any
-        if (state.userSentence.length > 0) {
-            answerPrompt.classList.add('hidden');
-            state.userSentence.forEach(w => {
-                const span = document.createElement('span');
-                span.textContent = w;
-                span.className = 'px-3 py-2 bg-white/80 backdrop-blur-sm rounded-lg mr-2 font-medium text-gray-700 shadow-sm';
-                constructedSentenceEl.appendChild(span);
-            });
-        } else {
-            answerPrompt.classList.remove('hidden');
-        }
-
-        // Tokenize the correct sentence to create word buttons, then shuffle them.
-        const allTokens = exercise.correct_german_sentence.match(/[\p{L}\p{N}']+|[^\s\p{L}\p{N}]/gu) || [];
-        const wordsToDisplay = allTokens.filter(token => !isPunctuation(token));
+        // Shuffle the words again, so a retry doesn't start from the same order
+        const wordsToDisplay = exerciseWords(exercise);
         for (let i = wordsToDisplay.length - 1; i > 0; i--) {
             const j = Math.floor(Math.random() * (i + 1));
             [wordsToDisplay[i], wordsToDisplay[j]] = [wordsToDisplay[j], wordsToDisplay[i]];
@@ -214,57 +289,64 @@ document.addEventListener('DOMContentLoaded', () => {
         });
     }
 
+    // Shows the placed words. Clicking one takes it (and the words after it) back.
+    function renderConstructedSentence(results) {
+        constructedSentenceEl.innerHTML = '';
+        answerPrompt.classList.toggle('hidden', state.userSentence.length > 0);
+
+        state.userSentence.forEach((placed, index) => {
+            const span = document.createElement('span');
+            span.textContent = placed.word;
+            span.className = 'px-3 py-2 bg-white/80 backdrop-blur-sm rounded-lg mr-2 font-medium text-gray-700 shadow-sm cursor-pointer';
+            if (results) {
+                span.classList.add(results[index].correct ? 'text-green-700' : 'text-red-600');
+            }
+            span.addEventListener('click', () => removeWordsFrom(index));
+            constructedSentenceEl.appendChild(span);
+        });
+    }
+
+    function removeWordsFrom(index) {
+        if (state.isLocked) return;
+        state.userSentence.splice(index).forEach(placed => placed.button.classList.remove('hidden'));
+        renderConstructedSentence();
+    }
+
     function handleWordClick(word, button) {
         if (state.isLocked) return;
 
         const exercise = state.exercises[state.currentExerciseIndex];
-        const correctWordArray = exercise.correct_german_sentence.match(/[\p{L}\p{N}']+|[^\s\p{L}\p{N}]/gu) || [];
-        const nonPunctuationWords = correctWordArray.filter(token => !isPunctuation(token));
-        
-        const userWords = state.userSentence.filter(token => !isPunctuation(token));
-        const nextCorrectWord = nonPunctuationWords[userWords.length];
-
-        if (word === nextCorrectWord) {
-            // Correct word
-            state.userSentence.push(word);
-            addPunctuationIfNeeded(exercise, state.userSentence);
-
-            // Hide the clicked button
-            button.classList.add('hidden');
-
-            // Update constructed sentence display
-            constructedSentenceEl.innerHTML = '';
-            answerPrompt.classList.add('hidden');
-
-            state.userSentence.forEach(w => {
-                const span = document.createElement('span');
-                span.textContent = w;
-                span.className = 'px-3 py-2 bg-white/80 backdrop-blur-sm rounded-lg mr-2 font-medium text-gray-700 shadow-sm';
-                constructedSentenceEl.appendChild(span);
-            });
-
-            // Check if sentence is complete
-            if (state.userSentence.length === correctWordArray.length) {
-                handleSentenceCompletion(exercise, correctWordArray);
-            }
-        } else {
-            // Incorrect word
-            state.mistakes++;
-            updateStats();
-
-            button.classList.add('incorrect-answer-feedback');
-            setTimeout(() => {
-                button.classList.remove('incorrect-answer-feedback');
-            }, 500);
+        state.userSentence.push({ word, button });
+        button.classList.add('hidden');
+        renderConstructedSentence();
+
+        // Once the sentence has all its words, the order is checked; distractors in the
+        // word bank (see answerLength) are left over
+        if (state.userSentence.length === answerLength(exercise)) {
+            handleSentenceCompletion(exercise);
         }
     }
 
-    function handleSentenceCompletion(exercise, correctWordArray) {
+    async function handleSentenceCompletion(exercise) {
         state.isLocked = true;
-        const isCorrect = state.userSentence.join(' ') === correctWordArray.join(' ');
+        const words = state.userSentence.map(placed => placed.word);
+
+        let result;
+        try {
+            result = await checkAnswer(exercise, words);
+        } catch (error) {
+            console.error('Error checking answer:', error);
+            // Let the learner try submitting again
+            state.isLocked = false;
+            removeWordsFrom(words.length - 1);
+            return;
+        }
         
-        if (isCorrect) {
-            correctSentenceDisplay.textContent = `Correct! "${exercise.correct_german_sentence}"`;
+        if (result.correct) {
+            correctSentenceDisplay.textContent = `Correct! "${result.sentence}"`;
+            submitReviewGrade(exercise, words.length);
+            if (exercise.id) state.answered.push(exercise.id);
+            saveSessionProgress();
             
             setTimeout(() => {
                 if (state.currentExerciseIndex < state.exercises.length - 1) {
@@ -278,14 +360,12 @@ document.addEventListener('DOMContentLoaded', () => {
             state.mistakes++;
             updateStats();
             
-            // Show incorrect feedback
-            const wrongWords = scrambledWordsContainer.querySelectorAll('.btn-word.hidden');
-            wrongWords.forEach(btn => {
-                btn.classList.add('incorrect-answer-feedback');
-                setTimeout(() => {
-                    btn.classList.remove('incorrect-answer-feedback');
-                }, 500);
-            });
+            // Show which words are in the wrong place
+            renderConstructedSentence(result.words);
+            if (exercise.id && explanationDisplay.classList.contains('hidden')) {
+                explainBtn.classList.remove('hidden');
+            }
+            if (exercise.id) flagBtn.classList.remove('hidden');
             
             // Reset for another try
             setTimeout(() => {
@@ -295,28 +375,108 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
 
-    function handleHintClick() {
+    // Grades an answered exercise for spaced repetition from how it went: again, hard, good or easy
+    function gradeExercise(wordCount) {
+        const mistakes = Math.max(0, state.mistakes - state.exerciseStart.mistakes);
+        const hints = Math.max(0, state.hintsUsed - state.exerciseStart.hints);
+        const seconds = (Date.now() - state.exerciseStart.time) / 1000;
+        if (mistakes >= 3) return 'again';
+        if (mistakes > 0 || hints > 0) return 'hard';
+        if (seconds <= wordCount * 2) return 'easy';
+        return 'good';
+    }
+
+    async function submitReviewGrade(exercise, wordCount) {
+        if (!exercise.id) return; // Sample exercises aren't scheduled
+        try {
+            await fetch('/api/exercises/reviews', withCSRF({
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    reviews: [{
+                        exercise_id: exercise.id,
+                        grade: gradeExercise(wordCount),
+                        hint_positions: state.exerciseStart.hintPositions,
+                    }],
+                })
+            }));
+        } catch (error) {
+            console.error('Error submitting review grade:', error);
+        }
+    }
+
+    // Shows the grammar explanation of the current exercise, generated on the server on first request
+    async function handleExplainClick() {
+        const exercise = state.exercises[state.currentExerciseIndex];
+        if (!exercise || !exercise.id) return;
+        explainBtn.disabled = true;
+        try {
+            const response = await fetch(`/api/exercises/${encodeURIComponent(exercise.id)}/explain`, withCSRF({ method: 'POST' }));
+            if (response.status === 503 && state.offline) {
+                alert('Explanations that were not stored before are not available offline.');
+                explainBtn.classList.add('hidden');
+                return;
+            }
+            if (!response.ok) throw new Error('Failed to get an explanation');
+            const result = await response.json();
+            if (state.exercises[state.currentExerciseIndex] !== exercise) return; // the learner moved on
+            explanationDisplay.textContent = result.explanation;
+            explanationDisplay.classList.remove('hidden');
+            explainBtn.classList.add('hidden');
+        } catch (error) {
+            console.error('Error getting explanation:', error);
+            alert('Could not get an explanation right now. Please try again later.');
+        } finally {
+            explainBtn.disabled = false;
+        }
+    }
+
+    // Reports the current exercise as wrong, for admins to review in the exercise quality report
+    async function handleFlagClick() {
+        const exercise = state.exercises[state.currentExerciseIndex];
+        if (!exercise || !exercise.id) return;
+        const comment = prompt('What was wrong with this exercise? (optional)');
+        if (comment === null) return;
+        flagBtn.disabled = true;
+        try {
+            const response = await fetch(`/api/exercises/${encodeURIComponent(exercise.id)}/flag`, withCSRF({
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ reason: 'wrong_answer', comment: comment.slice(0, 500) })
+            }));
+            if (!response.ok) throw new Error('Failed to flag the exercise');
+            flagBtn.classList.add('hidden');
+            alert('Thanks! The exercise will be reviewed.');
+        } catch (error) {
+            console.error('Error flagging exercise:', error);
+            alert('Could not report the exercise right now. Please try again later.');
+        } finally {
+            flagBtn.disabled = false;
+        }
+    }
+
+    async function handleHintClick() {
         if (state.isLocked || state.exercises.length === 0) return;
 
         const exercise = state.exercises[state.currentExerciseIndex];
-        const correctWordArray = exercise.correct_german_sentence.match(/[\p{L}\p{N}']+|[^\s\p{L}\p{N}]/gu) || [];
-        const nonPunctuationWords = correctWordArray.filter(token => !isPunctuation(token));
-        
-        if (state.userSentence.length < nonPunctuationWords.length) {
-            const nextCorrectWord = nonPunctuationWords[state.userSentence.length];
-            const availableButtons = scrambledWordsContainer.querySelectorAll('.btn-word:not(.hidden)');
-            
-            for (const button of availableButtons) {
-                if (button.dataset.word === nextCorrectWord) {
-                    button.classList.add('hint-word');
-                    state.hintsUsed++;
-                    updateStats();
-                    
-                    setTimeout(() => {
-                        button.classList.remove('hint-word');
-                    }, 2000);
-                    break;
-                }
+        const hint = await fetchHint(exercise, state.userSentence.map(placed => placed.word));
+        if (!hint) return;
+
+        // Take back the words placed after the correct start, then point at the next one
+        removeWordsFrom(hint.position);
+        const availableButtons = scrambledWordsContainer.querySelectorAll('.btn-word:not(.hidden)');
+        for (const button of availableButtons) {
+            if (button.dataset.word === hint.word) {
+                button.classList.add('hint-word');
+                state.hintsUsed++;
+                // Recorded with the answer, so hinted exercises come back sooner
+                state.exerciseStart.hintPositions.push(hint.position);
+                updateStats();
+                
+                setTimeout(() => {
+                    button.classList.remove('hint-word');
+                }, 2000);
+                break;
             }
         }
     }
@@ -326,8 +486,11 @@ document.addEventListener('DOMContentLoaded', () => {
         const endTime = Date.now();
         state.sessionTime = Math.floor((endTime - state.startTime) / 1000);
 
+        // Guest stats are kept server-side and merged into the account on login
+        saveUserStats();
+        discardCurrentSession();
         if (state.isLoggedIn) {
-            saveUserStats();
+            recordSession();
         }
         
         const accuracy = state.exercises.length > 0 ? 
@@ -424,6 +587,7 @@ document.addEventListener('DOMContentLoaded', () => {
         state.sessionTime = 0;
         state.isSessionComplete = false;
         state.startTime = Date.now();
+        state.statsKey = newIdempotencyKey();
         
         updateStats();
         renderExercise();
@@ -433,6 +597,11 @@ document.addEventListener('DOMContentLoaded', () => {
         if (state.isLocked) return;
         
         const key = event.key.toLowerCase();
+        // Backspace takes back the last word, unless it is editing a text field
+        if (key === 'backspace' && state.userSentence.length > 0 && !event.target.closest('input, textarea')) {
+            removeWordsFrom(state.userSentence.length - 1);
+            return;
+        }
         const wordButtons = scrambledWordsContainer.querySelectorAll('.btn-word:not(.hidden)');
         
         for (const button of wordButtons) {
@@ -446,11 +615,18 @@ document.addEventListener('DOMContentLoaded', () => {
     // --- Topics API Functions ---
     async function loadTopics() {
         try {
-            const response = await fetch('/api/topics');
-            if (!response.ok) throw new Error('Failed to load topics');
-            
-            const data = await response.json();
-            state.topics = data.topics || [];
+            // The topics list is paginated; follow next_cursor until every topic is loaded
+            const topics = [];
+            let cursor = '';
+            do {
+                const response = await fetch(`/api/topics?limit=200${cursor ? `&cursor=${encodeURIComponent(cursor)}` : ''}`);
+                if (!response.ok) throw new Error('Failed to load topics');
+
+                const data = await response.json();
+                topics.push(...(data.items || []));
+                cursor = data.next_cursor || '';
+            } while (cursor);
+            state.topics = topics;
             
             renderTopicsList();
             
@@ -466,6 +642,7 @@ document.addEventListener('DOMContentLoaded', () => {
             if (currentTopic) {
                 topicSearch.value = currentTopic.name;
             }
+            loadGrammarTags();
             
         } catch (error) {
             console.error('Error loading topics:', error);
@@ -512,11 +689,11 @@ document.addEventListener('DOMContentLoaded', () => {
 
     async function createTopic(name, prompt) {
         try {
-            const response = await fetch('/api/topics', {
+            const response = await fetch('/api/topics', withCSRF({
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
                 body: JSON.stringify({ name, prompt })
-            });
+            }));
             
             if (!response.ok) throw new Error('Failed to create topic');
             
@@ -530,14 +707,14 @@ document.addEventListener('DOMContentLoaded', () => {
     }
 
     async function deleteTopic(topicId) {
-        if (!confirm('Are you sure you want to delete this topic? This action cannot be undone.')) {
+        if (!confirm('Are you sure you want to archive this topic? An admin can restore it later.')) {
             return;
         }
         
         try {
-            const response = await fetch(`/api/topics/${topicId}`, {
+            const response = await fetch(`/api/topics/${topicId}`, withCSRF({
                 method: 'DELETE'
-            });
+            }));
             
             if (!response.ok) throw new Error('Failed to delete topic');
             
@@ -555,15 +732,36 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
 
-    async function updateTopicPrompt(topicId, name, prompt) {
+    async function updateTopicPrompt(topicId, name, prompt, refinement, vocabularyBand) {
         try {
-            const response = await fetch(`/api/topics/${topicId}`, {
+            const topic = state.topics.find(t => t.id === topicId);
+            const response = await fetch(`/api/topics/${topicId}`, withCSRF({
                 method: 'PUT',
                 headers: { 'Content-Type': 'application/json' },
                 body: JSON.stringify({ name, prompt })
-            });
+            }));
             
             if (!response.ok) throw new Error('Failed to update prompt');
+
+            if (topic && (!!topic.refinement_disabled !== refinement.refinement_disabled || (topic.meta_prompt || '') !== refinement.meta_prompt)) {
+                const refinementResponse = await fetch(`/api/topics/${topicId}/refinement`, withCSRF({
+                    method: 'PUT',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(refinement)
+                }));
+
+                if (!refinementResponse.ok) throw new Error('Failed to update refinement settings');
+            }
+
+            if (topic && (topic.vocabulary_band || 0) !== vocabularyBand) {
+                const vocabularyResponse = await fetch(`/api/topics/${topicId}/vocabulary`, withCSRF({
+                    method: 'PUT',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ band: vocabularyBand })
+                }));
+
+                if (!vocabularyResponse.ok) throw new Error('Failed to update vocabulary band');
+            }
             
             await loadTopics(); // Refresh the topics list
             hidePromptEditor();
@@ -574,6 +772,73 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
 
+    // Shows live generation progress ("Refining prompt", "Cached 4/10", ...) instead of a static
+    // message. Only logged-in users trigger generation; resolves to null if the socket can't open.
+    function openProgressSocket() {
+        if (!state.isLoggedIn || !window.WebSocket) return Promise.resolve(null);
+        return new Promise(resolve => {
+            const protocol = location.protocol === 'https:' ? 'wss:' : 'ws:';
+            const socket = new WebSocket(`${protocol}//${location.host}/ws`);
+            const timeout = setTimeout(() => resolve(null), 1000); // don't hold up the request
+            socket.onopen = () => { clearTimeout(timeout); resolve(socket); };
+            socket.onerror = () => { clearTimeout(timeout); resolve(null); };
+            socket.onmessage = (message) => {
+                const event = JSON.parse(message.data);
+                loadingStatus.textContent = `${event.message}...`;
+            };
+        });
+    }
+
+    // Starts practising a served set; a daily mix spans topics, so its stats have none
+    function startSet(exercises, mix) {
+        state.exercises = exercises;
+        state.mix = mix;
+        state.currentExerciseIndex = 0;
+        state.mistakes = 0;
+        state.hintsUsed = 0;
+        state.sessionTime = 0;
+        state.isSessionComplete = false;
+        state.startTime = Date.now();
+        state.answered = [];
+        state.sessionActive = true;
+        state.statsKey = newIdempotencyKey();
+        updateStats();
+        renderExercise();
+    }
+
+    // Due reviews from all the topics practised so far, interleaved by topic
+    async function fetchDailyMix() {
+        loadingSpinner.classList.remove('hidden');
+        exerciseContent.classList.add('hidden');
+        dailyMixBtn.disabled = true;
+        loadingStatus.textContent = 'Collecting due reviews...';
+        try {
+            const response = await fetch('/api/exercises/mix', withCSRF({
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({})
+            }));
+            if (!response.ok) {
+                const errorData = await response.json().catch(() => ({}));
+                throw new Error(errorData.error?.message || response.statusText);
+            }
+            const data = await response.json();
+            if (data.exercises && data.exercises.length > 0) {
+                startSet(data.exercises, true);
+            } else {
+                alert('Nothing is due for review in your topics right now. Come back later!');
+                renderExercise();
+            }
+        } catch (error) {
+            console.error('Error fetching daily mix:', error);
+            alert(`Failed to fetch the daily mix.\nError: ${error.message}`);
+            renderExercise();
+        } finally {
+            loadingSpinner.classList.add('hidden');
+            dailyMixBtn.disabled = false;
+        }
+    }
+
     async function fetchExercises() {
         if (!state.currentTopicId) {
             alert('Please select a topic first.');
@@ -583,6 +848,8 @@ document.addEventListener('DOMContentLoaded', () => {
         loadingSpinner.classList.remove('hidden');
         exerciseContent.classList.add('hidden');
         generateBtn.disabled = true;
+        loadingStatus.textContent = 'Generating new exercises...';
+        const progressSocket = await openProgressSocket();
         state.timer = 60;
         timer.textContent = state.timer;
         state.timerInterval = setInterval(() => {
@@ -594,15 +861,21 @@ document.addEventListener('DOMContentLoaded', () => {
         }, 1000);
 
         try {
-            const response = await fetch('/api/exercises', {
+            const response = await fetch('/api/exercises', withCSRF({
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json'
                 },
                 body: JSON.stringify({
-                    topic_id: state.currentTopicId
+                    topic_id: state.currentTopicId,
+                    // Empty for the difficulty set in the user's profile (normal for guests)
+                    difficulty: difficultySelect.value || undefined,
+                    // e.g. "conjunction:obwohl" for only sentences with obwohl
+                    grammar_tags: grammarTagSelect.value ? [grammarTagSelect.value] : undefined,
+                    // Harder exercises for the rest of the set after a perfect start
+                    escalate: escalateCheckbox.checked || undefined
                 })
-            });
+            }));
 
             if (!response.ok) {
                 const errorData = await response.json().catch(() => ({})); // Gracefully handle non-JSON error bodies
@@ -615,15 +888,11 @@ document.addEventListener('DOMContentLoaded', () => {
             const data = await response.json();
 
             if (data.exercises && data.exercises.length > 0) {
-                state.exercises = data.exercises;
-                state.currentExerciseIndex = 0;
-                state.mistakes = 0;
-                state.hintsUsed = 0;
-                state.sessionTime = 0;
-                state.isSessionComplete = false;
-                state.startTime = Date.now();
-                updateStats();
-                renderExercise();
+                startSet(data.exercises, false);
+            } else if (data.limits && data.limits.new_remaining === 0) {
+                // Today's new exercises are used up and nothing is due for review
+                alert("You've finished today's exercises. Come back tomorrow!");
+                renderExercise(); // Render empty state
             } else {
                 // This can happen if generation fails or cache is empty and generation is disabled
                 alert('No exercises could be retrieved for this topic. Please try another topic or contact support.');
@@ -639,6 +908,7 @@ document.addEventListener('DOMContentLoaded', () => {
             }
             renderExercise();
         } finally {
+            if (progressSocket) progressSocket.close();
             loadingSpinner.classList.add('hidden');
             clearInterval(state.timerInterval);
             // Keep button disabled and re-enable after 5 seconds
@@ -667,6 +937,9 @@ document.addEventListener('DOMContentLoaded', () => {
         state.editingTopicId = topicId;
         currentTopicName.textContent = topic.name;
         promptTextarea.value = topic.prompt;
+        refinementEnabledCheckbox.checked = !topic.refinement_disabled;
+        metaPromptTextarea.value = topic.meta_prompt || '';
+        vocabularyBandInput.value = topic.vocabulary_band || '';
         promptEditor.classList.remove('hidden');
         versionHistory.classList.add('hidden');
     }
@@ -678,31 +951,50 @@ document.addEventListener('DOMContentLoaded', () => {
 
     async function showVersionHistory(topicId) {
         try {
-            const response = await fetch(`/api/versions/${topicId}`);
+            const response = await fetch(`/api/versions/${topicId}?sort=-version&limit=200`);
             if (!response.ok) throw new Error('Failed to load versions');
             
             const data = await response.json();
-            const versions = data.versions || [];
+            const versions = data.items || [];
             
             const topic = state.topics.find(t => t.id === topicId);
             versionTopicName.textContent = topic ? topic.name : 'Unknown Topic';
             
             versionsList.innerHTML = '';
             
-            versions.reverse().forEach(version => {
+            versions.forEach(version => {
                 const versionDiv = document.createElement('div');
                 versionDiv.className = 'flex justify-between items-center p-2 border rounded text-sm';
                 
                 versionDiv.innerHTML = `
                     <div>
-                        <div class="font-medium">Version ${version.version}</div>
-                        <div class="text-gray-500">${new Date(version.created_at).toLocaleString()}</div>
+                        <div class="font-medium">Version ${version.version}${version.pinned ? ' 📌' : ''} <span class="version-label text-gray-600"></span></div>
+                        <div class="text-gray-500">${new Date(version.created_at).toLocaleString()}<span class="version-name"></span></div>
+                    </div>
+                    <div class="flex gap-3">
+                        <button class="label-version-btn text-gray-600 hover:text-gray-800"
+                                data-topic-id="${topicId}" data-version-id="${version.id}">
+                            Label
+                        </button>
+                        <button class="pin-version-btn text-gray-600 hover:text-gray-800"
+                                data-topic-id="${topicId}" data-version-id="${version.id}" data-pinned="${version.pinned}">
+                            ${version.pinned ? 'Unpin' : 'Pin'}
+                        </button>
+                        <button class="restore-version-btn text-blue-600 hover:text-blue-800" 
+                                data-topic-id="${topicId}" data-version-id="${version.id}">
+                            Restore
+                        </button>
                     </div>
-                    <button class="restore-version-btn text-blue-600 hover:text-blue-800" 
-                            data-topic-id="${topicId}" data-version-id="${version.id}">
-                        Restore
-                    </button>
                 `;
+                // Labels and names are user text, so they are set as text, not HTML
+                if (version.label) {
+                    versionDiv.querySelector('.version-label').textContent = `– ${version.label}`;
+                }
+                if (version.name && version.name !== versionTopicName.textContent) {
+                    versionDiv.querySelector('.version-name').textContent = ` · named "${version.name}"`;
+                }
+                versionDiv.querySelector('.label-version-btn').dataset.label = version.label || '';
+                versionDiv.querySelector('.restore-version-btn').dataset.name = version.name || '';
                 
                 versionsList.appendChild(versionDiv);
             });
@@ -710,9 +1002,26 @@ document.addEventListener('DOMContentLoaded', () => {
             // Add event listeners for restore buttons
             versionsList.querySelectorAll('.restore-version-btn').forEach(btn => {
                 btn.addEventListener('click', async (e) => {
-                    const topicId = e.target.dataset.topicId;
-                    const versionId = e.target.dataset.versionId;
-                    await restoreVersion(topicId, versionId);
+                    const { topicId, versionId, name } = e.target.dataset;
+                    await restoreVersion(topicId, versionId, name);
+                });
+            });
+
+            // Pinned versions are never removed by the version cleanup
+            versionsList.querySelectorAll('.pin-version-btn').forEach(btn => {
+                btn.addEventListener('click', async (e) => {
+                    const { topicId, versionId, pinned } = e.target.dataset;
+                    await setVersionPinned(topicId, versionId, pinned !== 'true');
+                });
+            });
+
+            versionsList.querySelectorAll('.label-version-btn').forEach(btn => {
+                btn.addEventListener('click', async (e) => {
+                    const { topicId, versionId, label } = e.target.dataset;
+                    const newLabel = prompt('Label for this version (empty to remove):', label);
+                    if (newLabel !== null) {
+                        await setVersionLabel(topicId, versionId, newLabel);
+                    }
                 });
             });
             
@@ -725,15 +1034,19 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
 
-    async function restoreVersion(topicId, versionId) {
+    async function restoreVersion(topicId, versionId, name) {
         if (!confirm('Are you sure you want to restore this version? This will create a new version with this content.')) {
             return;
         }
+        const currentName = versionTopicName.textContent;
+        const restoreName = name && name !== currentName &&
+            confirm(`This version belonged to "${name}". Rename the topic back from "${currentName}"?`);
         
         try {
-            const response = await fetch(`/api/versions/${topicId}/restore/${versionId}`, {
+            const query = restoreName ? '?restore_name=true' : '';
+            const response = await fetch(`/api/versions/${topicId}/restore/${versionId}${query}`, withCSRF({
                 method: 'POST'
-            });
+            }));
             
             if (!response.ok) throw new Error('Failed to restore version');
             
@@ -747,6 +1060,46 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
 
+    async function setVersionPinned(topicId, versionId, pinned) {
+        try {
+            const action = pinned ? 'pin' : 'unpin';
+            const response = await fetch(`/api/versions/${topicId}/${action}/${versionId}`, withCSRF({
+                method: 'POST'
+            }));
+
+            if (!response.ok) {
+                const errorData = await response.json().catch(() => ({}));
+                throw new Error(errorData.error?.message || response.statusText);
+            }
+
+            await showVersionHistory(topicId);
+
+        } catch (error) {
+            console.error('Error pinning version:', error);
+            alert(`Failed to ${pinned ? 'pin' : 'unpin'} version: ${error.message}`);
+        }
+    }
+
+    async function setVersionLabel(topicId, versionId, label) {
+        try {
+            const response = await fetch(`/api/versions/${topicId}/label/${versionId}`, withCSRF({
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ label })
+            }));
+
+            if (!response.ok) {
+                const errorData = await response.json().catch(() => ({}));
+                throw new Error(errorData.error?.message || response.statusText);
+            }
+
+            await showVersionHistory(topicId);
+        } catch (error) {
+            console.error('Error labelling version:', error);
+            alert(`Failed to label version: ${error.message}`);
+        }
+    }
+
     // --- Observability Functions ---
     async function showLastRefinedPrompt() {
         try {
@@ -804,7 +1157,10 @@ document.addEventListener('DOMContentLoaded', () => {
             return;
         }
         
-        updateTopicPrompt(state.editingTopicId, name, prompt);
+        updateTopicPrompt(state.editingTopicId, name, prompt, {
+            refinement_disabled: !refinementEnabledCheckbox.checked,
+            meta_prompt: metaPromptTextarea.value.trim()
+        }, parseInt(vocabularyBandInput.value, 10) || 0);
     });
 
     viewVersionsBtn.addEventListener('click', () => {
@@ -819,7 +1175,12 @@ document.addEventListener('DOMContentLoaded', () => {
     });
 
     generateBtn.addEventListener('click', fetchExercises);
+    dailyMixBtn.addEventListener('click', fetchDailyMix);
     hintBtn.addEventListener('click', handleHintClick);
+    explainBtn.addEventListener('click', handleExplainClick);
+    flagBtn.addEventListener('click', handleFlagClick);
+    exerciseImageEl.addEventListener('load', () => exerciseImageEl.classList.remove('hidden'));
+    exerciseImageEl.addEventListener('error', () => exerciseImageEl.classList.add('hidden'));
     document.addEventListener('keydown', handleKeyPress);
 
     viewLastRefinedPromptBtn.addEventListener('click', showLastRefinedPrompt);
@@ -854,6 +1215,27 @@ document.addEventListener('DOMContentLoaded', () => {
         if (state.isLoggedIn) {
             saveUserSettings();
         }
+        loadGrammarTags();
+    }
+
+    // Offers the grammar features of the topic's cached exercises, like "obwohl" or the perfect tense
+    async function loadGrammarTags() {
+        grammarTagSelect.innerHTML = '<option value="">All features</option>';
+        if (!state.currentTopicId) return;
+        try {
+            const response = await fetch(`/api/topics/${encodeURIComponent(state.currentTopicId)}/grammar-tags`);
+            if (!response.ok) return;
+            const data = await response.json();
+            for (const { tag, exercises } of data.tags || []) {
+                const [kind, value] = tag.split(':');
+                const option = document.createElement('option');
+                option.value = tag;
+                option.textContent = `${value} (${kind}, ${exercises})`;
+                grammarTagSelect.appendChild(option);
+            }
+        } catch (error) {
+            console.error('Error loading grammar features:', error);
+        }
     }
 
     // Position dropdown function
@@ -924,6 +1306,18 @@ document.addEventListener('DOMContentLoaded', () => {
         window.location.href = '/auth/logout';
     });
 
+    // Offline servers serve stored exercises only; the badge tells learners why nothing new appears
+    async function loadMode() {
+        try {
+            const response = await fetch('/api/mode');
+            const mode = await response.json();
+            state.offline = mode.offline;
+            offlineBadge.classList.toggle('hidden', !mode.offline);
+        } catch (error) {
+            console.error('Error loading server mode:', error);
+        }
+    }
+
     async function checkAuthStatus() {
         try {
             const response = await fetch('/api/auth/status');
@@ -980,41 +1374,139 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
 
+    function newIdempotencyKey() {
+        const bytes = crypto.getRandomValues(new Uint8Array(16));
+        return Array.from(bytes, b => b.toString(16).padStart(2, '0')).join('');
+    }
+
     async function saveUserStats() {
         try {
-            await fetch('/api/user/stats', {
+            // The server adds the set to the totals; the key makes a repeated save count once
+            await fetch('/api/user/stats/increment', withCSRF({
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
                 body: JSON.stringify({
-                    total_exercises: state.exercises.length,
-                    total_mistakes: state.mistakes,
-                    total_hints: state.hintsUsed,
-                    total_time: state.sessionTime,
+                    idempotency_key: state.statsKey,
+                    topic_id: state.mix ? undefined : state.currentTopicId,
+                    exercises: state.exercises.length,
+                    mistakes: state.mistakes,
+                    hints: state.hintsUsed,
+                    time_spent: state.sessionTime,
                 })
-            });
+            }));
         } catch (error) {
             console.error('Error saving user stats:', error);
         }
     }
 
+    async function recordSession() {
+        try {
+            const response = await fetch('/api/user/sessions', withCSRF({
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    topic_id: state.mix ? undefined : state.currentTopicId,
+                    exercises: state.exercises.length,
+                    // The server takes at most one mistake per exercise
+                    mistakes: Math.min(state.mistakes, state.exercises.length),
+                    hints: state.hintsUsed,
+                    time_spent: state.sessionTime,
+                })
+            }));
+            if (!response.ok) return;
+            const data = await response.json();
+            (data.unlocked_achievements || []).forEach(a => {
+                console.log(`Achievement unlocked: ${a.name}`);
+            });
+        } catch (error) {
+            console.error('Error recording session:', error);
+        }
+    }
+
     async function saveUserSettings() {
         try {
-            await fetch('/api/user/settings', {
+            await fetch('/api/user/settings', withCSRF({
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
                 body: JSON.stringify({
                     last_topic_id: state.currentTopicId,
                 })
-            });
+            }));
         } catch (error) {
             console.error('Error saving user settings:', error);
         }
     }
 
+    async function saveSessionProgress() {
+        if (!state.sessionActive) return;
+        try {
+            const response = await fetch('/api/sessions/current', withCSRF({
+                method: 'PUT',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    answered: state.answered,
+                    mistakes: state.mistakes,
+                    hints: state.hintsUsed,
+                    time_spent: Math.floor((Date.now() - state.startTime) / 1000),
+                })
+            }));
+            if (!response.ok) return;
+            const session = await response.json();
+            // The rest of the set stepped up to harder exercises; answered ones are unchanged
+            if (session.escalated) state.exercises = session.exercises;
+        } catch (error) {
+            console.error('Error saving session progress:', error);
+        }
+    }
+
+    async function discardCurrentSession() {
+        if (!state.sessionActive) return;
+        state.sessionActive = false;
+        try {
+            await fetch('/api/sessions/current', withCSRF({ method: 'DELETE' }));
+        } catch (error) {
+            console.error('Error discarding session:', error);
+        }
+    }
+
+    // Continues a set left unfinished, e.g. after closing the tab, with the same exercises
+    async function resumeCurrentSession() {
+        try {
+            const response = await fetch('/api/sessions/current');
+            if (!response.ok) return; // 404: nothing to resume
+            const session = await response.json();
+            const index = session.exercises.findIndex(ex => !session.answered.includes(ex.id));
+            if (index < 0) return;
+
+            if (state.topics.find(t => t.id === session.topic_id)) {
+                state.currentTopicId = session.topic_id;
+                localStorage.setItem('selectedTopicId', session.topic_id);
+                topicSearch.value = state.topics.find(t => t.id === session.topic_id).name;
+            }
+            state.exercises = session.exercises;
+            state.mix = !session.topic_id; // daily mixes have no topic
+            state.currentExerciseIndex = index;
+            state.mistakes = session.mistakes;
+            state.hintsUsed = session.hints;
+            state.sessionTime = 0;
+            state.isSessionComplete = false;
+            state.startTime = Date.now() - session.time_spent * 1000;
+            state.answered = session.answered;
+            state.statsKey = newIdempotencyKey();
+            state.sessionActive = true;
+            state.userSentence = [];
+            updateStats();
+            renderExercise();
+        } catch (error) {
+            console.error('Error resuming session:', error);
+        }
+    }
+
     // --- Initialization ---
     function init() {
+        loadMode();
         checkAuthStatus();
-        loadTopics();
+        loadTopics().then(resumeCurrentSession);
         
         // Start with sample exercises for testing
         state.exercises = sampleExercises.exercises;
@@ -1024,6 +1516,7 @@ document.addEventListener('DOMContentLoaded', () => {
         state.sessionTime = 0;
         state.isSessionComplete = false;
         state.startTime = Date.now();
+        state.statsKey = newIdempotencyKey();
         
         updateStats();
         renderExercise();
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+const maxAssignmentExercises = 1000
+
+const (
+	assignmentPending   = "pending"
+	assignmentCompleted = "completed"
+	assignmentOverdue   = "overdue"
+)
+
+// Assignment asks a class to complete a number of exercises on a topic by a due date.
+type Assignment struct {
+	ID            string    `json:"id"`
+	ClassID       string    `json:"class_id"`
+	TopicID       string    `json:"topic_id"`
+	Title         string    `json:"title,omitempty"`
+	ExerciseCount int       `json:"exercise_count"`
+	DueAt         time.Time `json:"due_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// AssignmentProgress is an assignment with a student's progress towards it.
+type AssignmentProgress struct {
+	*Assignment
+	ClassName   string     `json:"class_name,omitempty"`
+	Completed   int        `json:"completed_exercises"`
+	Status      string     `json:"status"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// AssignmentSummary is an assignment with class-wide completion, for the teacher.
+type AssignmentSummary struct {
+	*Assignment
+	Students          int `json:"students"`
+	CompletedStudents int `json:"completed_students"`
+}
+
+type AssignmentRequest struct {
+	TopicID       string `json:"topic_id"`
+	Title         string `json:"title"`
+	ExerciseCount int    `json:"exercise_count"`
+	DueAt         string `json:"due_at"`
+}
+
+func assignmentFromRecord(record *airtable.Record) *Assignment {
+	a := &Assignment{
+		ID: record.ID,
+	}
+	if val, ok := record.Fields["ClassID"].(string); ok {
+		a.ClassID = val
+	}
+	if val, ok := record.Fields["TopicID"].(string); ok {
+		a.TopicID = val
+	}
+	if val, ok := record.Fields["Title"].(string); ok {
+		a.Title = val
+	}
+	if val, ok := record.Fields["ExerciseCount"].(float64); ok {
+		a.ExerciseCount = int(val)
+	}
+	if val, ok := record.Fields["DueAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			a.DueAt = t
+		}
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			a.CreatedAt = t
+		}
+	}
+	return a
+}
+
+func getClassAssignments(classIDs []string) ([]*Assignment, error) {
+	if len(classIDs) == 0 {
+		return []*Assignment{}, nil
+	}
+	var conditions []string
+	for _, id := range classIDs {
+		conditions = append(conditions, fmt.Sprintf("{ClassID} = '%s'", id))
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, assignmentsTableName)
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula("OR(" + strings.Join(conditions, ", ") + ")"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get assignments from Airtable: %v", err)
+	}
+
+	assignments := []*Assignment{}
+	for _, record := range records.Records {
+		assignments = append(assignments, assignmentFromRecord(record))
+	}
+	sort.Slice(assignments, func(i, j int) bool {
+		return assignments[i].DueAt.Before(assignments[j].DueAt)
+	})
+	return assignments, nil
+}
+
+func createAssignment(a *Assignment) (*Assignment, error) {
+	table := airtableClient.GetTable(airtableBaseID, assignmentsTableName)
+	fields := map[string]any{
+		"ClassID":       a.ClassID,
+		"TopicID":       a.TopicID,
+		"ExerciseCount": a.ExerciseCount,
+		"DueAt":         a.DueAt.Format(time.RFC3339),
+		"CreatedAt":     a.CreatedAt.Format(time.RFC3339),
+	}
+	if a.Title != "" {
+		fields["Title"] = a.Title
+	}
+
+	result, err := table.AddRecords(&airtable.Records{Records: []*airtable.Record{{Fields: fields}}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create assignment: %v", err)
+	}
+	if len(result.Records) == 0 {
+		return nil, fmt.Errorf("no records returned from Airtable")
+	}
+	return assignmentFromRecord(result.Records[0]), nil
+}
+
+// parseDueDate accepts an RFC 3339 timestamp or a plain date, which means the end of that day (UTC).
+func parseDueDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("due_at must be a date (YYYY-MM-DD) or RFC 3339 timestamp")
+	}
+	return day.Add(24*time.Hour - time.Second), nil
+}
+
+// assignmentProgress counts exercises a student completed on the assignment's topic since it
+// was set. Sessions must be sorted oldest first. Completion is recorded even after the due date.
+func assignmentProgress(a *Assignment, sessions []*Session, now time.Time) *AssignmentProgress {
+	progress := &AssignmentProgress{Assignment: a, Status: assignmentPending}
+	for _, s := range sessions {
+		if s.TopicID != a.TopicID || s.CompletedAt.Before(a.CreatedAt) {
+			continue
+		}
+		progress.Completed += s.Exercises
+		if progress.Completed >= a.ExerciseCount {
+			completedAt := s.CompletedAt
+			progress.CompletedAt = &completedAt
+			progress.Completed = a.ExerciseCount
+			progress.Status = assignmentCompleted
+			return progress
+		}
+	}
+	if now.After(a.DueAt) {
+		progress.Status = assignmentOverdue
+	}
+	return progress
+}
+
+// getUserAssignments returns assignments from all classes the user belongs to, with their progress.
+func getUserAssignments(userID string, includeCompleted bool, now time.Time) ([]*AssignmentProgress, error) {
+	memberships, err := findClassMembers(fmt.Sprintf("{UserID} = '%s'", userID))
+	if err != nil {
+		return nil, err
+	}
+
+	classNames := make(map[string]string)
+	var classIDs []string
+	for _, m := range memberships {
+		class, err := getClass(m.ClassID)
+		if err != nil {
+			return nil, err
+		}
+		if class != nil {
+			classNames[class.ID] = class.Name
+			classIDs = append(classIDs, class.ID)
+		}
+	}
+
+	assignments, err := getClassAssignments(classIDs)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := getUserSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*AssignmentProgress{}
+	for _, a := range assignments {
+		progress := assignmentProgress(a, sessions, now)
+		if progress.Status == assignmentCompleted && !includeCompleted {
+			continue
+		}
+		progress.ClassName = classNames[a.ClassID]
+		result = append(result, progress)
+	}
+	return result, nil
+}
+
+// summarizeAssignments reports how many of the class's students have completed each assignment.
+func summarizeAssignments(class *Class, assignments []*Assignment, now time.Time) ([]*AssignmentSummary, error) {
+	members, err := getClassMembers(class.ID)
+	if err != nil {
+		return nil, err
+	}
+	studentSessions := make([][]*Session, 0, len(members))
+	for _, m := range members {
+		sessions, err := getUserSessions(m.UserID)
+		if err != nil {
+			return nil, err
+		}
+		studentSessions = append(studentSessions, sessions)
+	}
+
+	summaries := []*AssignmentSummary{}
+	for _, a := range assignments {
+		summary := &AssignmentSummary{Assignment: a, Students: len(members)}
+		for _, sessions := range studentSessions {
+			if assignmentProgress(a, sessions, now).Status == assignmentCompleted {
+				summary.CompletedStudents++
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// Handle a class's assignments. Access to the class has already been checked by handleClassByID.
+func handleClassAssignments(w http.ResponseWriter, r *http.Request, class *Class, parts []string) {
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		assignments, err := getClassAssignments([]string{class.ID})
+		if err != nil {
+			writeError(w, "Failed to get assignments", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !class.IsTeacher {
+			json.NewEncoder(w).Encode(assignments)
+			return
+		}
+		summaries, err := summarizeAssignments(class, assignments, time.Now())
+		if err != nil {
+			writeError(w, "Failed to get assignment progress", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(summaries)
+
+	case len(parts) == 0 && r.Method == http.MethodPost && class.IsTeacher:
+		var req AssignmentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.ExerciseCount < 1 || req.ExerciseCount > maxAssignmentExercises {
+			writeError(w, fmt.Sprintf("exercise_count must be between 1 and %d", maxAssignmentExercises), http.StatusBadRequest)
+			return
+		}
+		dueAt, err := parseDueDate(req.DueAt)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		if !dueAt.After(now) {
+			writeError(w, "due_at must be in the future", http.StatusBadRequest)
+			return
+		}
+		topic, err := dataStore.GetTopic(req.TopicID)
+		if err != nil || !topicInTenant(r.Context(), topic) || topic.Archived {
+			writeError(w, "Topic not found", http.StatusNotFound)
+			return
+		}
+
+		assignment, err := createAssignment(&Assignment{
+			ClassID:       class.ID,
+			TopicID:       topic.ID,
+			Title:         strings.TrimSpace(req.Title),
+			ExerciseCount: req.ExerciseCount,
+			DueAt:         dueAt,
+			CreatedAt:     now,
+		})
+		if err != nil {
+			writeError(w, "Failed to create assignment", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(assignment)
+
+	case len(parts) == 1 && r.Method == http.MethodDelete && class.IsTeacher:
+		assignments, err := getClassAssignments([]string{class.ID})
+		if err != nil {
+			writeError(w, "Failed to get assignments", http.StatusInternalServerError)
+			return
+		}
+		for _, a := range assignments {
+			if a.ID == parts[0] {
+				table := airtableClient.GetTable(airtableBaseID, assignmentsTableName)
+				if _, err := table.DeleteRecords([]string{a.ID}); err != nil {
+					writeError(w, "Failed to delete assignment", http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeError(w, "Assignment not found", http.StatusNotFound)
+
+	default:
+		writeError(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// Handle the student's assignments: GET /api/user/assignments?include_completed=true
+func handleUserAssignments(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	includeCompleted, _ := strconv.ParseBool(r.URL.Query().Get("include_completed"))
+	assignments, err := getUserAssignments(userID, includeCompleted, time.Now())
+	if err != nil {
+		writeError(w, "Failed to get assignments", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(assignments)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Audited admin actions
+const (
+	auditTopicCreate            = "topic.create"
+	auditTopicUpdate            = "topic.update"
+	auditTopicArchive           = "topic.archive"
+	auditTopicDelete            = "topic.delete"
+	auditTopicRestore           = "topic.restore"
+	auditTopicRefinement        = "topic.refinement"
+	auditTopicVocabulary        = "topic.vocabulary"
+	auditTopicRegenerate        = "topic.regenerate"
+	auditTopicDuplicate         = "topic.duplicate"
+	auditTopicsImport           = "topics.import"
+	auditVersionRestore         = "version.restore"
+	auditVersionPin             = "version.pin"
+	auditVersionUnpin           = "version.unpin"
+	auditVersionLabel           = "version.label"
+	auditExerciseCreate         = "exercise.create"
+	auditExerciseUpdate         = "exercise.update"
+	auditExerciseDelete         = "exercise.delete"
+	auditExerciseImageSet       = "exercise.image_set"
+	auditExerciseImageDelete    = "exercise.image_delete"
+	auditExercisesPurge         = "exercises.purge"
+	auditExercisesRetire        = "exercises.retire"
+	auditExerciseRetire         = "exercise.retire"
+	auditExerciseKeep           = "exercise.keep"
+	auditExerciseReinstate      = "exercise.reinstate"
+	auditBackupRestore          = "backup.restore"
+	auditContentPackLoad        = "content_pack.load"
+	auditFeatureFlagSet         = "feature_flag.set"
+	auditFeatureFlagReset       = "feature_flag.reset"
+	auditWebhookCreate          = "webhook.create"
+	auditWebhookUpdate          = "webhook.update"
+	auditWebhookDelete          = "webhook.delete"
+	auditTopicSuggestionDismiss = "topic_suggestion.dismiss"
+	auditTenantCreate           = "tenant.create"
+	auditTenantUpdate           = "tenant.update"
+	auditTenantDelete           = "tenant.delete"
+	auditUserQuotaSet           = "user_quota.set"
+	auditSecretsReencrypt       = "secrets.reencrypt"
+)
+
+// AuditEntry records one admin mutation with snapshots of the target before and after it.
+// The AuditLog table is append-only: nothing in the app updates or deletes its records,
+// and restoring a backup with replace=true leaves it alone.
+type AuditEntry struct {
+	ID         string          `json:"id"`
+	ActorID    string          `json:"actor_id"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+var (
+	auditMutex       sync.Mutex
+	memoryAuditLog   []*AuditEntry // with in-memory storage
+	auditFormulaText = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+)
+
+// recordAudit appends an entry for an admin mutation made by the request's user. before and
+// after are snapshots of the target, or nil. The mutation has already happened, so a failure
+// to store the entry is only logged.
+func recordAudit(r *http.Request, action, targetType, targetID string, before, after any) {
+	entry := &AuditEntry{
+		ActorID:    getUserIDFromRequest(r),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     auditSnapshot(before),
+		After:      auditSnapshot(after),
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	if airtableBaseID == "" {
+		auditMutex.Lock()
+		entry.ID = fmt.Sprintf("audit%d", len(memoryAuditLog)+1)
+		memoryAuditLog = append(memoryAuditLog, entry)
+		auditMutex.Unlock()
+		return
+	}
+
+	fields := map[string]any{
+		"ActorID":    entry.ActorID,
+		"Action":     entry.Action,
+		"TargetType": entry.TargetType,
+		"TargetID":   entry.TargetID,
+		"CreatedAt":  entry.CreatedAt.Format(time.RFC3339),
+	}
+	if entry.Before != nil {
+		fields["Before"] = string(entry.Before)
+	}
+	if entry.After != nil {
+		fields["After"] = string(entry.After)
+	}
+	table := airtableClient.GetTable(airtableBaseID, auditLogTableName)
+	records := &airtable.Records{Records: []*airtable.Record{{Fields: fields}}}
+	if _, err := table.AddRecords(records); err != nil {
+		log.Printf("Warning: failed to store audit entry %s %s %s: %v", action, targetType, targetID, err)
+	}
+}
+
+// Airtable long text fields hold at most 100,000 characters.
+const maxAuditSnapshotSize = 90000
+
+func auditSnapshot(value any) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil || string(data) == "null" {
+		return nil
+	}
+	if len(data) > maxAuditSnapshotSize {
+		data, _ = json.Marshal(map[string]any{"truncated": true, "size": len(data)})
+	}
+	return data
+}
+
+func auditEntryFromRecord(record *airtable.Record) *AuditEntry {
+	entry := &AuditEntry{ID: record.ID}
+	if val, ok := record.Fields["ActorID"].(string); ok {
+		entry.ActorID = val
+	}
+	if val, ok := record.Fields["Action"].(string); ok {
+		entry.Action = val
+	}
+	if val, ok := record.Fields["TargetType"].(string); ok {
+		entry.TargetType = val
+	}
+	if val, ok := record.Fields["TargetID"].(string); ok {
+		entry.TargetID = val
+	}
+	if val, ok := record.Fields["Before"].(string); ok && json.Valid([]byte(val)) {
+		entry.Before = json.RawMessage(val)
+	}
+	if val, ok := record.Fields["After"].(string); ok && json.Valid([]byte(val)) {
+		entry.After = json.RawMessage(val)
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			entry.CreatedAt = t
+		}
+	}
+	return entry
+}
+
+// AuditFilter selects audit entries; empty fields match everything.
+type AuditFilter struct {
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      time.Time
+}
+
+func (f AuditFilter) matches(entry *AuditEntry) bool {
+	return (f.ActorID == "" || entry.ActorID == f.ActorID) &&
+		(f.Action == "" || entry.Action == f.Action) &&
+		(f.TargetType == "" || entry.TargetType == f.TargetType) &&
+		(f.TargetID == "" || entry.TargetID == f.TargetID) &&
+		(f.Since.IsZero() || !entry.CreatedAt.Before(f.Since))
+}
+
+func (f AuditFilter) formula() string {
+	var conditions []string
+	for _, field := range [][2]string{
+		{"ActorID", f.ActorID}, {"Action", f.Action}, {"TargetType", f.TargetType}, {"TargetID", f.TargetID},
+	} {
+		if field[1] != "" {
+			conditions = append(conditions, fmt.Sprintf("{%s} = '%s'", field[0], auditFormulaText.Replace(field[1])))
+		}
+	}
+	if !f.Since.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("NOT(IS_BEFORE({CreatedAt}, '%s'))", f.Since.UTC().Format(time.RFC3339)))
+	}
+	if len(conditions) == 0 {
+		return ""
+	}
+	return "AND(" + strings.Join(conditions, ", ") + ")"
+}
+
+func getAuditEntries(filter AuditFilter) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+	if airtableBaseID == "" {
+		auditMutex.Lock()
+		defer auditMutex.Unlock()
+		for _, entry := range memoryAuditLog {
+			if filter.matches(entry) {
+				entries = append(entries, entry)
+			}
+		}
+		return entries, nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, auditLogTableName)
+	query := table.GetRecords()
+	if formula := filter.formula(); formula != "" {
+		query = query.WithFilterFormula(formula)
+	}
+	records, err := getAllRecords(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit log from Airtable: %v", err)
+	}
+	for _, record := range records.Records {
+		entries = append(entries, auditEntryFromRecord(record))
+	}
+	return entries, nil
+}
+
+// Handle the audit log (admin):
+// GET /api/admin/audit?actor_id=&action=&target_type=&target_id=&since=2024-01-31T00:00:00Z
+// returns entries newest first, paginated with limit and cursor.
+func handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	params, err := parseListParams(r, []string{"created_at"}, "-created_at")
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := AuditFilter{
+		ActorID:    query.Get("actor_id"),
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		TargetID:   query.Get("target_id"),
+	}
+	if value := query.Get("since"); value != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, value); err != nil {
+			writeError(w, "since must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := getAuditEntries(filter)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sortItems(entries, params, map[string]func(a, b *AuditEntry) bool{
+		"created_at": func(a, b *AuditEntry) bool { return a.CreatedAt.Before(b.CreatedAt) },
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(paginate(entries, params))
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+const (
+	backupFormatVersion = 1
+	maxBackupUploadSize = 100 << 20 // 100 MB
+)
+
+// Backup is a snapshot of every table in schema.json.
+type Backup struct {
+	Version   int                       `json:"version"`
+	CreatedAt time.Time                 `json:"created_at"`
+	Tables    map[string][]BackupRecord `json:"tables"`
+}
+
+type BackupRecord struct {
+	ID     string         `json:"id"`
+	Fields map[string]any `json:"fields"`
+}
+
+type RestoreResult struct {
+	Restored map[string]int `json:"restored"`
+	Skipped  []string       `json:"skipped"`
+}
+
+func backupsSupported() error {
+	if airtableBaseID == "" {
+		return fmt.Errorf("backups require Airtable storage")
+	}
+	return nil
+}
+
+// createBackup reads every record of every table. Optional tables that are missing or
+// unreadable are left out of the snapshot.
+func createBackup() (*Backup, error) {
+	if err := backupsSupported(); err != nil {
+		return nil, err
+	}
+
+	backup := &Backup{
+		Version:   backupFormatVersion,
+		CreatedAt: time.Now().UTC(),
+		Tables:    make(map[string][]BackupRecord),
+	}
+	for _, schema := range airtableSchema {
+		if schema.Name == currentSessionsTableName {
+			continue // sets in progress are short-lived and reference exercise IDs inside JSON
+		}
+		table := airtableClient.GetTable(airtableBaseID, schema.Name)
+		records, err := getAllRecords(table.GetRecords())
+		if err != nil {
+			if schema.Required {
+				return nil, fmt.Errorf("failed to back up table %s: %v", schema.Name, err)
+			}
+			log.Printf("Warning: skipping table %s in backup: %v", schema.Name, err)
+			continue
+		}
+
+		rows := []BackupRecord{}
+		for _, record := range records.Records {
+			rows = append(rows, BackupRecord{ID: record.ID, Fields: record.Fields})
+		}
+		backup.Tables[schema.Name] = rows
+	}
+	return backup, nil
+}
+
+// writableFields drops fields Airtable computes itself (created time, formulas),
+// which reject writes.
+func writableFields(schema TableSchema, fields map[string]any) map[string]any {
+	computed := make(map[string]bool)
+	for _, f := range schema.Fields {
+		if f.Type == "Created time" || f.Type == "Formula" {
+			computed[f.Name] = true
+		}
+	}
+	result := make(map[string]any, len(fields))
+	for name, value := range fields {
+		if !computed[name] {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+// remapIDs rewrites field values that refer to backed-up record IDs, including
+// comma-separated ID lists, to the IDs the records got on restore. It reports whether
+// anything changed.
+func remapIDs(fields map[string]any, idMap map[string]string) bool {
+	changed := false
+	for name, value := range fields {
+		s, ok := value.(string)
+		if !ok || s == "" {
+			continue
+		}
+		parts := strings.Split(s, ",")
+		replaced := false
+		for i, part := range parts {
+			if newID, ok := idMap[strings.TrimSpace(part)]; ok {
+				parts[i] = newID
+				replaced = true
+			}
+		}
+		if replaced {
+			fields[name] = strings.Join(parts, ",")
+			changed = true
+		}
+	}
+	return changed
+}
+
+func deleteAllRecords(table *airtable.Table) error {
+	records, err := getAllRecords(table.GetRecords())
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(records.Records); start += 10 {
+		var ids []string
+		for _, record := range records.Records[start:min(start+10, len(records.Records))] {
+			ids = append(ids, record.ID)
+		}
+		if _, err := table.DeleteRecords(ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreBackup recreates the snapshot's records. Airtable assigns new record IDs, so
+// references between tables are rewritten in a second pass. Tables that already contain
+// records are skipped unless replace is set, in which case their records are deleted first.
+func restoreBackup(backup *Backup, replace bool) (*RestoreResult, error) {
+	if err := backupsSupported(); err != nil {
+		return nil, err
+	}
+	if backup.Version != backupFormatVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", backup.Version)
+	}
+
+	result := &RestoreResult{Restored: make(map[string]int), Skipped: []string{}}
+	idMap := make(map[string]string)
+	restored := make(map[string][]*airtable.Record)
+
+	for _, schema := range airtableSchema {
+		rows, ok := backup.Tables[schema.Name]
+		if !ok {
+			continue
+		}
+		table := airtableClient.GetTable(airtableBaseID, schema.Name)
+
+		existing, err := table.GetRecords().MaxRecords(1).Do()
+		if err != nil {
+			return result, fmt.Errorf("failed to read table %s: %v", schema.Name, err)
+		}
+		if len(existing.Records) > 0 {
+			if !replace || schema.Name == auditLogTableName { // the audit log is append-only
+				result.Skipped = append(result.Skipped, schema.Name)
+				continue
+			}
+			if err := deleteAllRecords(table); err != nil {
+				return result, fmt.Errorf("failed to clear table %s: %v", schema.Name, err)
+			}
+		}
+
+		for start := 0; start < len(rows); start += 10 {
+			chunk := rows[start:min(start+10, len(rows))]
+			records := &airtable.Records{}
+			for _, row := range chunk {
+				records.Records = append(records.Records, &airtable.Record{Fields: writableFields(schema, row.Fields)})
+			}
+			created, err := table.AddRecords(records)
+			if err != nil {
+				return result, fmt.Errorf("failed to restore table %s: %v", schema.Name, err)
+			}
+			for i, record := range created.Records {
+				idMap[chunk[i].ID] = record.ID
+				record.Fields = records.Records[i].Fields
+				restored[schema.Name] = append(restored[schema.Name], record)
+			}
+		}
+		result.Restored[schema.Name] = len(rows)
+	}
+
+	// Point references at the new record IDs
+	for name, records := range restored {
+		var updates []*airtable.Record
+		for _, record := range records {
+			bound := boundSecretFields(name, record.Fields)
+			if remapIDs(record.Fields, idMap) {
+				resealRemappedSecrets(name, bound, record.Fields)
+				updates = append(updates, &airtable.Record{ID: record.ID, Fields: record.Fields})
+			}
+		}
+		table := airtableClient.GetTable(airtableBaseID, name)
+		for start := 0; start < len(updates); start += 10 {
+			chunk := &airtable.Records{Records: updates[start:min(start+10, len(updates))]}
+			if _, err := table.UpdateRecordsPartial(chunk); err != nil {
+				return result, fmt.Errorf("failed to update references in table %s: %v", name, err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// Uploaded backups are gzipped and, when BACKUP_ENCRYPTION_KEY is set, encrypted with
+// AES-256-GCM. Encrypted files start with backupMagic followed by the nonce.
+var backupMagic = []byte("GCTB1")
+
+var (
+	backupEncryptionKey []byte
+	backupSchedule      *cronSchedule
+	backupKeepDaily     int
+	backupKeepWeekly    int
+)
+
+// initBackups applies the backup settings, which loadConfig has already validated.
+func initBackups() {
+	if appConfig.BackupEncryptionKey != "" {
+		backupEncryptionKey, _ = base64.StdEncoding.DecodeString(appConfig.BackupEncryptionKey)
+	}
+	if appConfig.BackupSchedule != "" {
+		backupSchedule, _ = parseCronSchedule(appConfig.BackupSchedule)
+	}
+	backupKeepDaily = appConfig.BackupKeepDaily
+	backupKeepWeekly = appConfig.BackupKeepWeekly
+}
+
+func backupFilename(createdAt time.Time) string {
+	return fmt.Sprintf("backup-%s.json", createdAt.Format("20060102-150405"))
+}
+
+// encodeBackup serializes a backup for upload and returns it with its file extension.
+func encodeBackup(backup *Backup) ([]byte, string, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(backup); err != nil {
+		return nil, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", err
+	}
+	if backupEncryptionKey == nil {
+		return buf.Bytes(), ".gz", nil
+	}
+
+	block, err := aes.NewCipher(backupEncryptionKey)
+	if err != nil {
+		return nil, "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, "", err
+	}
+	out := append(append([]byte{}, backupMagic...), nonce...)
+	return gcm.Seal(out, nonce, buf.Bytes(), backupMagic), ".gz.enc", nil
+}
+
+// decodeBackup reads a backup file: plain JSON, gzipped, or encrypted and gzipped.
+func decodeBackup(data []byte) (*Backup, error) {
+	if bytes.HasPrefix(data, backupMagic) {
+		if backupEncryptionKey == nil {
+			return nil, fmt.Errorf("backup is encrypted but BACKUP_ENCRYPTION_KEY is not set")
+		}
+		block, err := aes.NewCipher(backupEncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		data = data[len(backupMagic):]
+		if len(data) < gcm.NonceSize() {
+			return nil, fmt.Errorf("backup file is truncated")
+		}
+		data, err = gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], backupMagic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt backup (wrong key?)")
+		}
+	}
+
+	var reader io.Reader = bytes.NewReader(data)
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = io.LimitReader(gz, maxBackupUploadSize*10)
+	}
+
+	var backup Backup
+	if err := json.NewDecoder(reader).Decode(&backup); err != nil {
+		return nil, fmt.Errorf("invalid backup file: %v", err)
+	}
+	return &backup, nil
+}
+
+// uploadBackup creates a snapshot, stores it in the S3 bucket and applies the retention
+// policy. It returns the new object's key.
+func uploadBackup() (string, error) {
+	backup, err := createBackup()
+	if err != nil {
+		return "", err
+	}
+	data, ext, err := encodeBackup(backup)
+	if err != nil {
+		return "", err
+	}
+	key := s3Prefix + backupFilename(backup.CreatedAt) + ext
+	if err := s3PutObject(key, data, "application/octet-stream"); err != nil {
+		return "", err
+	}
+
+	if err := pruneBackups(); err != nil {
+		log.Printf("Warning: failed to apply backup retention: %v", err)
+	}
+	return key, nil
+}
+
+// backupTime parses the creation time from a backup object key. Objects that weren't
+// written by uploadBackup return false and are never pruned.
+func backupTime(key string) (time.Time, bool) {
+	name := strings.TrimPrefix(key, s3Prefix)
+	if !strings.HasPrefix(name, "backup-") || len(name) < len("backup-20060102-150405") {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("20060102-150405", name[len("backup-"):len("backup-20060102-150405")])
+	return t, err == nil
+}
+
+// backupsToDelete applies the retention policy: the newest backup of each of the last
+// keepDaily days and of each of the last keepWeekly ISO weeks is kept, as is the newest
+// backup overall.
+func backupsToDelete(keys []string, keepDaily, keepWeekly int) []string {
+	type dated struct {
+		key string
+		at  time.Time
+	}
+	var backups []dated
+	for _, key := range keys {
+		if t, ok := backupTime(key); ok {
+			backups = append(backups, dated{key, t})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].at.After(backups[j].at) })
+
+	keep := make(map[string]bool)
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	for i, b := range backups {
+		day := b.at.Format(time.DateOnly)
+		year, week := b.at.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%02d", year, week)
+		if i == 0 {
+			keep[b.key] = true
+		}
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep[b.key] = true
+		}
+		if !weeks[weekKey] && len(weeks) < keepWeekly {
+			weeks[weekKey] = true
+			keep[b.key] = true
+		}
+	}
+
+	var remove []string
+	for _, b := range backups {
+		if !keep[b.key] {
+			remove = append(remove, b.key)
+		}
+	}
+	return remove
+}
+
+func pruneBackups() error {
+	objects, err := s3ListObjects(s3Prefix)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	for _, key := range backupsToDelete(keys, backupKeepDaily, backupKeepWeekly) {
+		if err := s3DeleteObject(key); err != nil {
+			return err
+		}
+		log.Printf("Deleted expired backup %s", key)
+	}
+	return nil
+}
+
+// startBackupScheduler uploads a backup to S3 on the BACKUP_SCHEDULE cron expression (UTC).
+func startBackupScheduler() {
+	if backupSchedule == nil || !s3Enabled() {
+		return
+	}
+	if backupEncryptionKey == nil {
+		log.Printf("Warning: BACKUP_ENCRYPTION_KEY is not set, scheduled backups are uploaded unencrypted")
+	}
+
+	log.Printf("Scheduled backups to S3 enabled (%s UTC)", appConfig.BackupSchedule)
+	go func() {
+		for {
+			next := backupSchedule.Next(time.Now().UTC())
+			if next.IsZero() {
+				log.Printf("Warning: BACKUP_SCHEDULE never matches, scheduled backups stopped")
+				return
+			}
+			time.Sleep(time.Until(next))
+
+			key, err := uploadBackup()
+			if err != nil {
+				log.Printf("Error uploading scheduled backup: %v", err)
+				continue
+			}
+			log.Printf("Uploaded backup to S3: %s", key)
+		}
+	}()
+}
+
+// Handle backups (admin):
+//
+//	GET  /api/admin/backup    - download a fresh snapshot
+//	GET  /api/admin/backup/s3 - list backups stored in the S3 bucket
+//	POST /api/admin/backup/s3 - upload a fresh snapshot to the S3 bucket and apply retention
+func handleAdminBackup(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/api/admin/backup" && r.Method == http.MethodGet:
+		backup, err := createBackup()
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to create backup: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", backupFilename(backup.CreatedAt)))
+		json.NewEncoder(w).Encode(backup)
+
+	case r.URL.Path == "/api/admin/backup/s3" && r.Method == http.MethodGet:
+		if !s3Enabled() {
+			writeError(w, "S3 backups are not configured", http.StatusServiceUnavailable)
+			return
+		}
+		objects, err := s3ListObjects(s3Prefix)
+		if err != nil {
+			log.Printf("Error listing backups: %v", err)
+			writeError(w, "Failed to list backups", http.StatusBadGateway)
+			return
+		}
+		sort.Slice(objects, func(i, j int) bool { return objects[i].Key > objects[j].Key })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(objects)
+
+	case r.URL.Path == "/api/admin/backup/s3" && r.Method == http.MethodPost:
+		if !s3Enabled() {
+			writeError(w, "S3 backups are not configured", http.StatusServiceUnavailable)
+			return
+		}
+		key, err := uploadBackup()
+		if err != nil {
+			log.Printf("Error uploading backup: %v", err)
+			writeError(w, fmt.Sprintf("Failed to upload backup: %v", err), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"key": key})
+
+	default:
+		writeError(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// Handle restore (admin): POST /api/admin/restore?replace=true with a backup file,
+// either as the request body or as the "file" field of a multipart form. Downloaded
+// snapshots and files uploaded to S3 (gzipped, optionally encrypted) are both accepted.
+func handleAdminRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBackupUploadSize)
+
+	body := r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, "Missing backup file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		writeError(w, "Failed to read backup file", http.StatusBadRequest)
+		return
+	}
+	backup, err := decodeBackup(data)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := restoreBackup(backup, r.URL.Query().Get("replace") == "true")
+	invalidateTopicCache() // topics are restored straight into Airtable
+	if result != nil {
+		recordAudit(r, auditBackupRestore, "backup", "", nil, result)
+	}
+	if err != nil {
+		// Report what was restored before the failure
+		writeAPIError(w, http.StatusInternalServerError, APIError{
+			Code:    "restore_failed",
+			Message: fmt.Sprintf("Failed to restore backup: %v", err),
+			Details: result,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
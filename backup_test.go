@@ -0,0 +1,67 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBackupsToDelete(t *testing.T) {
+	tests := []struct {
+		name       string
+		keys       []string
+		keepDaily  int
+		keepWeekly int
+		want       []string
+	}{
+		{
+			name: "no backups",
+		},
+		{
+			name: "the newest backup is always kept",
+			keys: []string{"backup-20240115-030000.json.gz", "backup-20240114-030000.json.gz"},
+			want: []string{"backup-20240114-030000.json.gz"},
+		},
+		{
+			name: "newest backup of each day",
+			keys: []string{
+				"backup-20240114-020000.json.gz",
+				"backup-20240115-130000.json.gz",
+				"backup-20240114-120000.json.gz",
+				"backup-20240115-010000.json.gz",
+			},
+			keepDaily: 7,
+			want:      []string{"backup-20240115-010000.json.gz", "backup-20240114-020000.json.gz"},
+		},
+		{
+			name: "days and ISO weeks",
+			keys: []string{
+				"backup-20231227-030000.json.gz", // 2023-W52
+				"backup-20240103-030000.json.gz", // 2024-W01
+				"backup-20240110-030000.json.gz", // 2024-W02
+				"backup-20240114-030000.json.gz", // 2024-W02
+				"backup-20240115-030000.json.gz", // 2024-W03
+			},
+			keepDaily:  1,
+			keepWeekly: 2,
+			want: []string{
+				"backup-20240110-030000.json.gz",
+				"backup-20240103-030000.json.gz",
+				"backup-20231227-030000.json.gz",
+			},
+		},
+		{
+			name:      "other objects are never pruned",
+			keys:      []string{"notes.txt", "backup-latest.json", "backup-20240115-030000.json", "backup-20240101-030000.json"},
+			keepDaily: 1,
+			want:      []string{"backup-20240101-030000.json"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := backupsToDelete(tt.keys, tt.keepDaily, tt.keepWeekly)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("backupsToDelete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Number of days ahead covered by the review calendar feed
+const calendarHorizonDays = 30
+
+// nextReviewAt returns when an exercise view next becomes due for review.
+func nextReviewAt(view *UserExerciseView) time.Time {
+	return view.LastViewed.Add(reviewInterval(view))
+}
+
+// reviewLoadByDay counts reviews due on each UTC day from today until the horizon.
+// Overdue reviews are counted on today.
+func reviewLoadByDay(views map[string]*UserExerciseView, now time.Time) map[string]int {
+	today := now.UTC().Truncate(24 * time.Hour)
+	horizon := today.AddDate(0, 0, calendarHorizonDays)
+
+	load := make(map[string]int)
+	for _, view := range views {
+		due := nextReviewAt(view).UTC()
+		if due.Before(today) {
+			due = today
+		}
+		if !due.Before(horizon) {
+			continue
+		}
+		load[due.Format(time.DateOnly)]++
+	}
+	return load
+}
+
+// escapeICalText escapes a value for use in an iCalendar TEXT property.
+func escapeICalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// renderReviewCalendar builds an iCalendar document with one all-day event per day with reviews due.
+func renderReviewCalendar(userID string, load map[string]int, now time.Time) string {
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&b, format+"\r\n", args...)
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//German Conjunctions Trainer//Review Schedule//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:German reviews")
+	line("REFRESH-INTERVAL;VALUE=DURATION:PT6H")
+
+	stamp := now.UTC().Format("20060102T150405Z")
+	today := now.UTC().Truncate(24 * time.Hour)
+	for i := 0; i < calendarHorizonDays; i++ {
+		day := today.AddDate(0, 0, i)
+		count := load[day.Format(time.DateOnly)]
+		if count == 0 {
+			continue
+		}
+
+		summary := fmt.Sprintf("%d German reviews due", count)
+		if count == 1 {
+			summary = "1 German review due"
+		}
+		line("BEGIN:VEVENT")
+		line("UID:reviews-%s-%s@german-trainer", userID, day.Format("20060102"))
+		line("DTSTAMP:%s", stamp)
+		line("DTSTART;VALUE=DATE:%s", day.Format("20060102"))
+		line("DTEND;VALUE=DATE:%s", day.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY:%s", escapeICalText(summary))
+		line("DESCRIPTION:%s", escapeICalText("Practice now: "+appBaseURL+"/"))
+		line("TRANSP:TRANSPARENT")
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+	return b.String()
+}
+
+func calendarFeedURL(token string) string {
+	return fmt.Sprintf("%s/api/user/%s/reviews.ics", appBaseURL, token)
+}
+
+// getUserByCalendarToken looks up the owner of a calendar feed token.
+func getUserByCalendarToken(token string) (*User, error) {
+	// Tokens are hex, so anything else can't match and must not reach the formula
+	if _, err := hex.DecodeString(token); err != nil || token == "" {
+		return nil, nil
+	}
+	users, err := listUsers(fmt.Sprintf("{CalendarToken} = '%s'", token))
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil // Not found
+	}
+	return users[0], nil
+}
+
+// Handle the user's calendar feed link: GET returns it (creating a token on first use), POST rotates the token
+func handleUserCalendar(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := dataStore.GetUserByID(userID)
+	if err != nil || user == nil {
+		writeError(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+
+	token := user.CalendarToken
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		token = ""
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if token == "" {
+		token = newRandomToken()
+		if _, err := updateUserFields(userID, map[string]any{"CalendarToken": token}); err != nil {
+			writeError(w, "Failed to create calendar link", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"url": calendarFeedURL(token)})
+}
+
+// Handle the tokenized review calendar feed: GET /api/user/{token}/reviews.ics
+func handleReviewCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/user/"), "/")
+	if len(parts) != 2 || parts[1] != "reviews.ics" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := getUserByCalendarToken(parts[0])
+	if err != nil {
+		writeError(w, "Failed to get calendar", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	views, err := dataStore.GetUserExerciseViews(user.ID)
+	if err != nil {
+		writeError(w, "Failed to get review schedule", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="reviews.ics"`)
+	w.Write([]byte(renderReviewCalendar(user.ID, reviewLoadByDay(views, now), now)))
+}
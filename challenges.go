@@ -0,0 +1,577 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Challenge mode: a race against the clock on one topic's cached exercises. The server
+// issues the exercises one at a time for a fixed window (five minutes by default), checks
+// each answer itself and times it by its own clock, so the score can't be faked by the
+// client. Each exercise gets one try: a correct answer scores challengeCorrectPoints plus a
+// bonus for every second under challengeBonusSeconds it took, a wrong or skipped one
+// nothing. Answers arriving after the window (plus a little grace for the network) are not
+// counted. Finished challenges are kept per user in the Challenges table, and the best
+// score on each topic and duration is the user's personal best. Challenges don't count for
+// SRS or the daily limits.
+const (
+	defaultChallengeDuration = 300 // seconds
+	minChallengeDuration     = 60
+	maxChallengeDuration     = 900
+	minChallengeExercises    = 5
+	maxChallengeExercises    = 100
+	challengeCorrectPoints   = 10
+	challengeBonusSeconds    = 10
+	challengeGrace           = 2 * time.Second
+)
+
+// Challenge is a user's timed run through a topic's exercises.
+type Challenge struct {
+	ID           string             `json:"id"`
+	OwnerID      string             `json:"owner_id"`
+	TopicID      string             `json:"topic_id"`
+	Level        string             `json:"level"`
+	Duration     int                `json:"duration"` // seconds
+	ExerciseIDs  []string           `json:"-"`        // the exercises to issue, in order
+	Answers      []*ChallengeAnswer `json:"answers"`
+	Score        int                `json:"score"`
+	Correct      int                `json:"correct"`
+	Finished     bool               `json:"finished"`
+	PersonalBest bool               `json:"personal_best"` // the best score so far on the topic and duration
+	StartedAt    time.Time          `json:"started_at"`
+	EndsAt       time.Time          `json:"ends_at"`
+
+	// Filled in for responses only
+	Remaining int             `json:"remaining"`          // seconds left
+	Exercise  json.RawMessage `json:"exercise,omitempty"` // the exercise to answer now, without its answer
+}
+
+// ChallengeAnswer is one issued exercise's outcome, timed by the server.
+type ChallengeAnswer struct {
+	ExerciseID string    `json:"exercise_id"`
+	Correct    bool      `json:"correct"`
+	Skipped    bool      `json:"skipped,omitempty"`
+	Points     int       `json:"points"`
+	Seconds    float64   `json:"seconds"` // since the exercise was issued
+	AnsweredAt time.Time `json:"answered_at"`
+}
+
+// PersonalBest is a user's best finished challenge on a topic and duration.
+type PersonalBest struct {
+	TopicID     string    `json:"topic_id"`
+	Duration    int       `json:"duration"`
+	Score       int       `json:"score"`
+	Correct     int       `json:"correct"`
+	ChallengeID string    `json:"challenge_id"`
+	AchievedAt  time.Time `json:"achieved_at"`
+}
+
+var (
+	challengesMutex  sync.Mutex
+	memoryChallenges = make(map[string]*Challenge) // with in-memory storage
+)
+
+// Challenges with an answer being checked, so two answers can't score the same exercise.
+var answeringChallenges sync.Map
+
+// issuedAt returns when the current exercise was issued: when the previous one was
+// answered, or when the challenge started.
+func (c *Challenge) issuedAt() time.Time {
+	if len(c.Answers) == 0 {
+		return c.StartedAt
+	}
+	return c.Answers[len(c.Answers)-1].AnsweredAt
+}
+
+// challengePoints scores a correct answer that took the given time.
+func challengePoints(elapsed time.Duration) int {
+	return challengeCorrectPoints + max(0, challengeBonusSeconds-int(elapsed.Seconds()))
+}
+
+func challengeFromRecord(record *airtable.Record) *Challenge {
+	challenge := &Challenge{ID: record.ID, Answers: []*ChallengeAnswer{}}
+	if val, ok := record.Fields["OwnerID"].(string); ok {
+		challenge.OwnerID = val
+	}
+	if val, ok := record.Fields["TopicID"].(string); ok {
+		challenge.TopicID = val
+	}
+	if val, ok := record.Fields["Level"].(string); ok {
+		challenge.Level = val
+	}
+	if val, ok := record.Fields["Duration"].(float64); ok {
+		challenge.Duration = int(val)
+	}
+	if val, ok := record.Fields["Exercises"].(string); ok && val != "" {
+		challenge.ExerciseIDs = strings.Split(val, ",")
+	}
+	if val, ok := record.Fields["Answers"].(string); ok {
+		if err := json.Unmarshal([]byte(val), &challenge.Answers); err != nil {
+			log.Printf("Warning: invalid answers in challenge %s: %v", record.ID, err)
+		}
+	}
+	if val, ok := record.Fields["Score"].(float64); ok {
+		challenge.Score = int(val)
+	}
+	if val, ok := record.Fields["Correct"].(float64); ok {
+		challenge.Correct = int(val)
+	}
+	if val, ok := record.Fields["Finished"].(bool); ok {
+		challenge.Finished = val
+	}
+	if val, ok := record.Fields["PersonalBest"].(bool); ok {
+		challenge.PersonalBest = val
+	}
+	if val, ok := record.Fields["StartedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, val); err == nil {
+			challenge.StartedAt = t
+		}
+	}
+	if val, ok := record.Fields["EndsAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, val); err == nil {
+			challenge.EndsAt = t
+		}
+	}
+	return challenge
+}
+
+// copyChallenge copies a challenge and its answers, so stored ones are not shared.
+func copyChallenge(challenge *Challenge) *Challenge {
+	c := *challenge
+	c.ExerciseIDs = slices.Clone(challenge.ExerciseIDs)
+	c.Answers = make([]*ChallengeAnswer, len(challenge.Answers))
+	for i, answer := range challenge.Answers {
+		a := *answer
+		c.Answers[i] = &a
+	}
+	return &c
+}
+
+// findChallenges returns the challenges matching an Airtable formula, or the predicate
+// in memory, newest first.
+func findChallenges(formula string, match func(*Challenge) bool) ([]*Challenge, error) {
+	challenges := []*Challenge{}
+	if airtableBaseID == "" {
+		challengesMutex.Lock()
+		for _, challenge := range memoryChallenges {
+			if match(challenge) {
+				challenges = append(challenges, copyChallenge(challenge))
+			}
+		}
+		challengesMutex.Unlock()
+	} else {
+		records, err := getAllRecords(airtableClient.GetTable(airtableBaseID, challengesTableName).GetRecords().WithFilterFormula(formula))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get challenges from Airtable: %v", err)
+		}
+		for _, record := range records.Records {
+			challenges = append(challenges, challengeFromRecord(record))
+		}
+	}
+	slices.SortFunc(challenges, func(a, b *Challenge) int { return b.StartedAt.Compare(a.StartedAt) })
+	return challenges, nil
+}
+
+// getChallenges returns the owner's challenges, newest first.
+func getChallenges(ownerID string) ([]*Challenge, error) {
+	return findChallenges(fmt.Sprintf("{OwnerID} = '%s'", ownerID), func(c *Challenge) bool { return c.OwnerID == ownerID })
+}
+
+// getUnfinishedChallenges returns the owner's challenges not yet marked finished, newest first.
+func getUnfinishedChallenges(ownerID string) ([]*Challenge, error) {
+	return findChallenges(fmt.Sprintf("AND({OwnerID} = '%s', NOT({Finished}))", ownerID), func(c *Challenge) bool {
+		return c.OwnerID == ownerID && !c.Finished
+	})
+}
+
+func getChallenge(id string) (*Challenge, error) {
+	if airtableBaseID == "" {
+		challengesMutex.Lock()
+		defer challengesMutex.Unlock()
+		if challenge, ok := memoryChallenges[id]; ok {
+			return copyChallenge(challenge), nil
+		}
+		return nil, fmt.Errorf("challenge %s not found", id)
+	}
+
+	record, err := airtableClient.GetTable(airtableBaseID, challengesTableName).GetRecord(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get challenge from Airtable: %v", err)
+	}
+	return challengeFromRecord(record), nil
+}
+
+// saveChallenge creates a challenge, or updates it if it has an ID.
+func saveChallenge(challenge *Challenge) error {
+	if airtableBaseID == "" {
+		challengesMutex.Lock()
+		defer challengesMutex.Unlock()
+		if challenge.ID == "" {
+			challenge.ID = fmt.Sprintf("challenge%d", time.Now().UnixNano())
+		}
+		memoryChallenges[challenge.ID] = copyChallenge(challenge)
+		return nil
+	}
+
+	answers, err := json.Marshal(challenge.Answers)
+	if err != nil {
+		return fmt.Errorf("failed to encode challenge answers: %v", err)
+	}
+	fields := map[string]any{
+		"OwnerID":      challenge.OwnerID,
+		"TopicID":      challenge.TopicID,
+		"Level":        challenge.Level,
+		"Duration":     challenge.Duration,
+		"Exercises":    strings.Join(challenge.ExerciseIDs, ","),
+		"Answers":      string(answers),
+		"Score":        challenge.Score,
+		"Correct":      challenge.Correct,
+		"Finished":     challenge.Finished,
+		"PersonalBest": challenge.PersonalBest,
+		// Answers are timed to the millisecond
+		"StartedAt": challenge.StartedAt.Format(time.RFC3339Nano),
+		"EndsAt":    challenge.EndsAt.Format(time.RFC3339Nano),
+	}
+	table := airtableClient.GetTable(airtableBaseID, challengesTableName)
+	records := &airtable.Records{Records: []*airtable.Record{{ID: challenge.ID, Fields: fields}}}
+	if challenge.ID != "" {
+		if _, err := table.UpdateRecordsPartial(records); err != nil {
+			return fmt.Errorf("failed to update challenge in Airtable: %v", err)
+		}
+		return nil
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return fmt.Errorf("failed to create challenge in Airtable: %v", err)
+	}
+	if len(result.Records) > 0 {
+		challenge.ID = result.Records[0].ID
+	}
+	return nil
+}
+
+// finishChallenge ends a challenge and records whether it is the owner's personal best.
+func finishChallenge(challenge *Challenge) error {
+	challenge.Finished = true
+	challenges, err := getChallenges(challenge.OwnerID)
+	if err != nil {
+		return err
+	}
+	challenge.PersonalBest = challenge.Score > 0
+	for _, other := range challenges {
+		if other.ID != challenge.ID && other.Finished && other.TopicID == challenge.TopicID &&
+			other.Duration == challenge.Duration && other.Score >= challenge.Score {
+			challenge.PersonalBest = false
+		}
+	}
+	return saveChallenge(challenge)
+}
+
+// finishIfOver finishes a challenge whose time is up, or that has issued every exercise.
+func finishIfOver(challenge *Challenge, now time.Time) error {
+	if challenge.Finished || (now.Before(challenge.EndsAt.Add(challengeGrace)) && len(challenge.Answers) < len(challenge.ExerciseIDs)) {
+		return nil
+	}
+	return finishChallenge(challenge)
+}
+
+// personalBests returns the best score on each topic and duration of finished challenges.
+func personalBests(challenges []*Challenge) []*PersonalBest {
+	var keys []string
+	byKey := make(map[string]*PersonalBest)
+	// Oldest first, so ties go to whoever scored first
+	for _, challenge := range slices.Backward(challenges) {
+		key := fmt.Sprintf("%s/%d", challenge.TopicID, challenge.Duration)
+		if best, ok := byKey[key]; !challenge.Finished || (ok && best.Score >= challenge.Score) {
+			continue
+		} else if !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = &PersonalBest{
+			TopicID:     challenge.TopicID,
+			Duration:    challenge.Duration,
+			Score:       challenge.Score,
+			Correct:     challenge.Correct,
+			ChallengeID: challenge.ID,
+			AchievedAt:  challenge.StartedAt,
+		}
+	}
+	bests := []*PersonalBest{}
+	for _, key := range keys {
+		bests = append(bests, byKey[key])
+	}
+	return bests
+}
+
+// challengeForClient fills in the time left and the exercise to answer now, without its
+// answer: challenges are scored by the server, so no client gets answers.
+func challengeForClient(challenge *Challenge, user *User, now time.Time) *Challenge {
+	c := copyChallenge(challenge)
+	if c.Finished {
+		return c
+	}
+	c.Remaining = max(0, int(c.EndsAt.Sub(now).Seconds()))
+	if len(c.Answers) < len(c.ExerciseIDs) {
+		ex, err := exerciseByID(c.ExerciseIDs[len(c.Answers)])
+		if err != nil {
+			log.Printf("Warning: failed to get exercise of challenge %s: %v", c.ID, err)
+			return c
+		}
+		difficulty, _ := requestDifficulty("", user)
+		c.Exercise = withoutAnswer(servedExercise(ex, user, nil, difficulty))
+	}
+	return c
+}
+
+// Handle challenges, for logged-in users:
+// GET /api/challenges lists the user's challenges, newest first, with their personal_bests;
+// ?topic_id= limits both to one topic.
+// POST /api/challenges with {"topic_id": "rec...", "duration": 300} starts one and returns it
+// with its first exercise. level, theme and difficulty work as for /api/exercises.
+// GET /api/challenges/{id} returns one, with the exercise to answer now.
+// POST /api/challenges/{id}/answers with {"words": ["Ich", ...]} answers the current exercise
+// (no words skips it) and returns the outcome and the challenge with the next exercise.
+func handleChallenges(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, err := dataStore.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Warning: failed to get user %s for a challenge: %v", userID, err)
+	}
+	id, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/challenges"), "/"), "/")
+
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			listChallenges(w, r, userID)
+		case http.MethodPost:
+			rateLimited("exercises", func(w http.ResponseWriter, r *http.Request) {
+				startChallenge(w, r, user, userID)
+			})(w, r)
+		default:
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	challenge, err := getChallenge(id)
+	if err != nil || challenge.OwnerID != userID {
+		writeError(w, "Challenge not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		now := time.Now()
+		if err := finishIfOver(challenge, now); err != nil {
+			writeError(w, fmt.Sprintf("Failed to finish challenge: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(challengeForClient(challenge, user, now))
+
+	case action == "answers" && r.Method == http.MethodPost:
+		rateLimited("answers", func(w http.ResponseWriter, r *http.Request) {
+			answerChallenge(w, r, challenge, user)
+		})(w, r)
+
+	case action == "" || action == "answers":
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		writeError(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// listChallenges returns the user's challenges and personal bests, finishing the ones whose
+// time is up.
+func listChallenges(w http.ResponseWriter, r *http.Request, userID string) {
+	challenges, err := getChallenges(userID)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get challenges: %v", err), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	topicID := strings.TrimSpace(r.URL.Query().Get("topic_id"))
+	filtered := []*Challenge{}
+	for _, challenge := range challenges {
+		if err := finishIfOver(challenge, now); err != nil {
+			log.Printf("Warning: failed to finish challenge %s: %v", challenge.ID, err)
+		}
+		if topicID == "" || challenge.TopicID == topicID {
+			filtered = append(filtered, challenge)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"challenges":     filtered,
+		"personal_bests": personalBests(filtered),
+	})
+}
+
+// startChallenge starts a challenge on a topic of the request's tenant, with its cached
+// exercises in random order. A challenge still running is finished first.
+func startChallenge(w http.ResponseWriter, r *http.Request, user *User, userID string) {
+	var req struct {
+		GenerateRequest
+		Duration int `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Duration == 0 {
+		req.Duration = defaultChallengeDuration
+	}
+	if req.Duration < minChallengeDuration || req.Duration > maxChallengeDuration {
+		writeError(w, fmt.Sprintf("duration must be between %d and %d seconds", minChallengeDuration, maxChallengeDuration), http.StatusBadRequest)
+		return
+	}
+	topic, err := dataStore.GetTopic(strings.TrimSpace(req.TopicID))
+	if err != nil || !topicInTenant(r.Context(), topic) {
+		writeError(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+	if req.Difficulty, err = requestDifficulty(req.Difficulty, user); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	vars := promptVarsFromRequest(req.GenerateRequest)
+	exercises, err := dataStore.GetExercisesForTopic(topic.ID, getCacheHash(topic.Prompt, vars))
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get exercises: %v", err), http.StatusInternalServerError)
+		return
+	}
+	exercises = withoutRetired(filterExercisesByDifficulty(filterExercisesByTheme(exercises, vars.Theme), vars.Difficulty))
+	if len(exercises) < minChallengeExercises {
+		writeError(w, fmt.Sprintf("A challenge needs at least %d cached exercises; practise the topic first", minChallengeExercises), http.StatusConflict)
+		return
+	}
+	rand.Shuffle(len(exercises), func(i, j int) { exercises[i], exercises[j] = exercises[j], exercises[i] })
+	exercises = exercises[:min(len(exercises), maxChallengeExercises)]
+
+	challenges, err := getChallenges(userID)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get challenges: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for _, running := range challenges {
+		if !running.Finished {
+			if err := finishChallenge(running); err != nil {
+				log.Printf("Warning: failed to finish challenge %s: %v", running.ID, err)
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	challenge := &Challenge{
+		OwnerID:   userID,
+		TopicID:   topic.ID,
+		Level:     vars.Level,
+		Duration:  req.Duration,
+		Answers:   []*ChallengeAnswer{},
+		StartedAt: now,
+		EndsAt:    now.Add(time.Duration(req.Duration) * time.Second),
+	}
+	for _, ex := range exercises {
+		challenge.ExerciseIDs = append(challenge.ExerciseIDs, ex.AirtableID)
+	}
+	if err := saveChallenge(challenge); err != nil {
+		writeError(w, fmt.Sprintf("Failed to save challenge: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(challengeForClient(challenge, user, now))
+}
+
+// answerChallenge checks the answer to a challenge's current exercise and issues the next.
+func answerChallenge(w http.ResponseWriter, r *http.Request, challenge *Challenge, user *User) {
+	now := time.Now().UTC() // the answer's time, before anything else is done
+	var req struct {
+		Words []string `json:"words"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Words) > maxAnswerWords {
+		writeError(w, fmt.Sprintf("words can hold at most %d words", maxAnswerWords), http.StatusBadRequest)
+		return
+	}
+	if _, busy := answeringChallenges.LoadOrStore(challenge.ID, true); busy {
+		writeError(w, "The previous answer is still being checked", http.StatusConflict)
+		return
+	}
+	defer answeringChallenges.Delete(challenge.ID)
+	// Read it again, in case an answer was saved since the request began
+	challenge, err := getChallenge(challenge.ID)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get challenge: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := finishIfOver(challenge, now); err != nil {
+		writeError(w, fmt.Sprintf("Failed to finish challenge: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if challenge.Finished {
+		writeError(w, "The challenge is over; start a new one", http.StatusConflict)
+		return
+	}
+
+	ex, err := exerciseByID(challenge.ExerciseIDs[len(challenge.Answers)])
+	if err != nil {
+		writeError(w, "Exercise not found", http.StatusNotFound)
+		return
+	}
+	answer := &ChallengeAnswer{
+		ExerciseID: ex.AirtableID,
+		Seconds:    now.Sub(challenge.issuedAt()).Round(time.Millisecond).Seconds(),
+		AnsweredAt: now,
+	}
+	check := AnswerCheck{Words: []AnswerWord{}}
+	if words := sentenceWords(strings.Join(req.Words, " ")); len(words) == 0 {
+		answer.Skipped = true
+	} else {
+		check = checkAnswer(ex, words)
+		answer.Correct = check.Correct
+	}
+	if answer.Correct {
+		answer.Points = challengePoints(now.Sub(challenge.issuedAt()))
+		challenge.Correct++
+		challenge.Score += answer.Points
+	}
+	check.Sentence = ex.Sentence // shown whatever the outcome, as the exercise won't come back
+	challenge.Answers = append(challenge.Answers, answer)
+
+	if len(challenge.Answers) == len(challenge.ExerciseIDs) {
+		err = finishChallenge(challenge)
+	} else {
+		err = saveChallenge(challenge)
+	}
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to save challenge: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"answer":    answer,
+		"check":     check,
+		"challenge": challengeForClient(challenge, user, now),
+	})
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mehanizm/airtable"
+)
+
+const (
+	joinCodeLength  = 6
+	maxClassNameLen = 80
+	// Unambiguous characters for codes that are read aloud or copied from a board
+	joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// Class is a group of students managed by a teacher.
+type Class struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	TeacherID      string    `json:"teacher_id"`
+	JoinCode       string    `json:"join_code,omitempty"`
+	AssignedTopics []string  `json:"assigned_topics"`
+	CreatedAt      time.Time `json:"created_at"`
+	IsTeacher      bool      `json:"is_teacher"`
+}
+
+// ClassMember is a student's membership in a class.
+type ClassMember struct {
+	ID       string    `json:"-"`
+	ClassID  string    `json:"class_id"`
+	UserID   string    `json:"user_id"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// StudentReport summarises a student's practice for the class dashboard.
+type StudentReport struct {
+	UserID       string     `json:"user_id"`
+	DisplayName  string     `json:"display_name"`
+	Sessions     int        `json:"sessions"`
+	Exercises    int        `json:"exercises"`
+	Accuracy     float64    `json:"accuracy"`
+	Streak       int        `json:"streak"`
+	TimeSpent    int        `json:"time_spent"`
+	LastActiveAt *time.Time `json:"last_active_at,omitempty"`
+}
+
+func newJoinCode() string {
+	b := make([]byte, joinCodeLength)
+	rand.Read(b)
+	for i := range b {
+		b[i] = joinCodeAlphabet[int(b[i])%len(joinCodeAlphabet)]
+	}
+	return string(b)
+}
+
+func splitIDs(value string) []string {
+	ids := []string{}
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func classFromRecord(record *airtable.Record) *Class {
+	class := &Class{
+		ID:             record.ID,
+		AssignedTopics: []string{},
+	}
+	if val, ok := record.Fields["Name"].(string); ok {
+		class.Name = val
+	}
+	if val, ok := record.Fields["TeacherID"].(string); ok {
+		class.TeacherID = val
+	}
+	if val, ok := record.Fields["JoinCode"].(string); ok {
+		class.JoinCode = val
+	}
+	if val, ok := record.Fields["AssignedTopics"].(string); ok {
+		class.AssignedTopics = splitIDs(val)
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			class.CreatedAt = t
+		}
+	}
+	return class
+}
+
+func classMemberFromRecord(record *airtable.Record) *ClassMember {
+	member := &ClassMember{
+		ID: record.ID,
+	}
+	if val, ok := record.Fields["ClassID"].(string); ok {
+		member.ClassID = val
+	}
+	if val, ok := record.Fields["UserID"].(string); ok {
+		member.UserID = val
+	}
+	if val, ok := record.Fields["JoinedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			member.JoinedAt = t
+		}
+	}
+	return member
+}
+
+func findClasses(formula string) ([]*Class, error) {
+	table := airtableClient.GetTable(airtableBaseID, classesTableName)
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(formula))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get classes from Airtable: %v", err)
+	}
+
+	classes := []*Class{}
+	for _, record := range records.Records {
+		classes = append(classes, classFromRecord(record))
+	}
+	return classes, nil
+}
+
+func getClass(classID string) (*Class, error) {
+	table := airtableClient.GetTable(airtableBaseID, classesTableName)
+	record, err := table.GetRecord(classID)
+	if err != nil {
+		if strings.Contains(err.Error(), "NOT_FOUND") || strings.Contains(err.Error(), "status 404") {
+			return nil, nil // Not found
+		}
+		return nil, fmt.Errorf("failed to get class from Airtable: %v", err)
+	}
+	return classFromRecord(record), nil
+}
+
+func findClassMembers(formula string) ([]*ClassMember, error) {
+	table := airtableClient.GetTable(airtableBaseID, classMembersTableName)
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(formula))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get class members from Airtable: %v", err)
+	}
+
+	members := []*ClassMember{}
+	for _, record := range records.Records {
+		members = append(members, classMemberFromRecord(record))
+	}
+	return members, nil
+}
+
+func getClassMembers(classID string) ([]*ClassMember, error) {
+	return findClassMembers(fmt.Sprintf("{ClassID} = '%s'", classID))
+}
+
+func isClassMember(classID, userID string) (bool, error) {
+	members, err := findClassMembers(fmt.Sprintf("AND({ClassID} = '%s', {UserID} = '%s')", classID, userID))
+	if err != nil {
+		return false, err
+	}
+	return len(members) > 0, nil
+}
+
+// getUserClasses returns the classes a user teaches or belongs to.
+func getUserClasses(userID string) ([]*Class, error) {
+	classes, err := findClasses(fmt.Sprintf("{TeacherID} = '%s'", userID))
+	if err != nil {
+		return nil, err
+	}
+	memberships, err := findClassMembers(fmt.Sprintf("{UserID} = '%s'", userID))
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range memberships {
+		class, err := getClass(m.ClassID)
+		if err != nil {
+			return nil, err
+		}
+		if class != nil {
+			classes = append(classes, class)
+		}
+	}
+
+	for _, class := range classes {
+		class.IsTeacher = class.TeacherID == userID
+		if !class.IsTeacher {
+			class.JoinCode = "" // Only the teacher shares the code
+		}
+	}
+	return classes, nil
+}
+
+func createClass(name, teacherID string) (*Class, error) {
+	table := airtableClient.GetTable(airtableBaseID, classesTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				Fields: map[string]any{
+					"Name":      name,
+					"TeacherID": teacherID,
+					"JoinCode":  newJoinCode(),
+					"CreatedAt": time.Now().Format(time.RFC3339),
+				},
+			},
+		},
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create class: %v", err)
+	}
+	if len(result.Records) == 0 {
+		return nil, fmt.Errorf("no records returned from Airtable")
+	}
+	class := classFromRecord(result.Records[0])
+	class.IsTeacher = true
+	return class, nil
+}
+
+func updateClassFields(classID string, fields map[string]any) (*Class, error) {
+	table := airtableClient.GetTable(airtableBaseID, classesTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				ID:     classID,
+				Fields: fields,
+			},
+		},
+	}
+	result, err := table.UpdateRecordsPartial(records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update class: %v", err)
+	}
+	if len(result.Records) == 0 {
+		return nil, fmt.Errorf("no records returned from Airtable")
+	}
+	return classFromRecord(result.Records[0]), nil
+}
+
+// joinClass adds the user to the class with the given join code.
+func joinClass(code, userID string) (*Class, error) {
+	classes, err := findClasses(fmt.Sprintf("{JoinCode} = '%s'", code))
+	if err != nil {
+		return nil, err
+	}
+	if len(classes) == 0 {
+		return nil, nil // Not found
+	}
+	class := classes[0]
+	if class.TeacherID == userID {
+		class.IsTeacher = true
+		return class, nil
+	}
+	class.JoinCode = ""
+
+	member, err := isClassMember(class.ID, userID)
+	if err != nil || member {
+		return class, err
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, classMembersTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				Fields: map[string]any{
+					"ClassID":  class.ID,
+					"UserID":   userID,
+					"JoinedAt": time.Now().Format(time.RFC3339),
+				},
+			},
+		},
+	}
+	if _, err := table.AddRecords(records); err != nil {
+		return nil, fmt.Errorf("failed to join class: %v", err)
+	}
+	return class, nil
+}
+
+func removeClassMember(classID, userID string) (bool, error) {
+	members, err := getClassMembers(classID)
+	if err != nil {
+		return false, err
+	}
+	var ids []string
+	for _, m := range members {
+		if m.UserID == userID {
+			ids = append(ids, m.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return false, nil
+	}
+	table := airtableClient.GetTable(airtableBaseID, classMembersTableName)
+	if _, err := table.DeleteRecords(ids); err != nil {
+		return false, fmt.Errorf("failed to remove class member: %v", err)
+	}
+	return true, nil
+}
+
+// getClassReport builds a per-student practice summary, limited to the class's
+// assigned topics when it has any.
+func getClassReport(class *Class, now time.Time) ([]*StudentReport, error) {
+	members, err := getClassMembers(class.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []*StudentReport{}
+	for _, m := range members {
+		report := &StudentReport{UserID: m.UserID}
+		if user, err := dataStore.GetUserByID(m.UserID); err == nil && user != nil {
+			report.DisplayName = user.DisplayName
+		}
+
+		sessions, err := getUserSessions(m.UserID)
+		if err != nil {
+			return nil, err
+		}
+		report.Streak = currentStreak(sessions, now)
+
+		var topicSessions []*Session
+		for _, s := range sessions {
+			if len(class.AssignedTopics) == 0 || contains(class.AssignedTopics, s.TopicID) {
+				topicSessions = append(topicSessions, s)
+			}
+		}
+		report.Sessions = len(topicSessions)
+		report.Accuracy = accuracy(topicSessions)
+		for _, s := range topicSessions {
+			report.Exercises += s.Exercises
+			report.TimeSpent += s.TimeSpent
+		}
+		if len(sessions) > 0 {
+			last := sessions[len(sessions)-1].CompletedAt
+			report.LastActiveAt = &last
+		}
+		reports = append(reports, report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return strings.ToLower(reports[i].DisplayName) < strings.ToLower(reports[j].DisplayName)
+	})
+	return reports, nil
+}
+
+// Handle classes:
+//
+//	GET    /api/classes                        - classes the user teaches or belongs to
+//	POST   /api/classes                        - create a class { "name" }
+//	POST   /api/classes/join                   - join with a code { "code" }
+//	GET    /api/classes/{id}                   - class details (teacher or member)
+//	PUT    /api/classes/{id}/topics            - assign topics { "topic_ids" } (teacher)
+//	POST   /api/classes/{id}/join-code         - issue a new join code (teacher)
+//	GET    /api/classes/{id}/report            - per-student dashboard (teacher)
+//	DELETE /api/classes/{id}/members/{userId}  - remove a student (teacher)
+//	GET    /api/classes/{id}/assignments       - list assignments (teacher or member)
+//	POST   /api/classes/{id}/assignments       - create an assignment (teacher)
+//	DELETE /api/classes/{id}/assignments/{aid} - delete an assignment (teacher)
+func handleClasses(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/classes"), "/")
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		classes, err := getUserClasses(userID)
+		if err != nil {
+			writeError(w, "Failed to get classes", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(classes)
+
+	case len(parts) == 0 && r.Method == http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || utf8.RuneCountInString(req.Name) > maxClassNameLen {
+			writeError(w, fmt.Sprintf("Class name is required and must be at most %d characters", maxClassNameLen), http.StatusBadRequest)
+			return
+		}
+		class, err := createClass(req.Name, userID)
+		if err != nil {
+			writeError(w, "Failed to create class", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(class)
+
+	case len(parts) == 1 && parts[0] == "join" && r.Method == http.MethodPost:
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		code := strings.ToUpper(strings.TrimSpace(req.Code))
+		if len(code) != joinCodeLength || strings.Trim(code, joinCodeAlphabet) != "" {
+			writeError(w, "Invalid join code", http.StatusBadRequest)
+			return
+		}
+		class, err := joinClass(code, userID)
+		if err != nil {
+			writeError(w, "Failed to join class", http.StatusInternalServerError)
+			return
+		}
+		if class == nil {
+			writeError(w, "No class found with that code", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(class)
+
+	case len(parts) >= 1 && parts[0] != "join":
+		handleClassByID(w, r, userID, parts)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleClassByID(w http.ResponseWriter, r *http.Request, userID string, parts []string) {
+	class, err := getClass(parts[0])
+	if err != nil {
+		writeError(w, "Failed to get class", http.StatusInternalServerError)
+		return
+	}
+	if class == nil {
+		writeError(w, "Class not found", http.StatusNotFound)
+		return
+	}
+	class.IsTeacher = class.TeacherID == userID
+
+	// Students may view the class and its assignments; everything else is for the teacher only
+	studentView := r.Method == http.MethodGet && (len(parts) == 1 || (len(parts) == 2 && parts[1] == "assignments"))
+	if !class.IsTeacher {
+		if !studentView {
+			writeError(w, "Only the class teacher can do this", http.StatusForbidden)
+			return
+		}
+		member, err := isClassMember(class.ID, userID)
+		if err != nil {
+			writeError(w, "Failed to get class", http.StatusInternalServerError)
+			return
+		}
+		if !member {
+			writeError(w, "Class not found", http.StatusNotFound)
+			return
+		}
+		class.JoinCode = ""
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(class)
+
+	case len(parts) >= 2 && parts[1] == "assignments":
+		handleClassAssignments(w, r, class, parts[2:])
+
+	case len(parts) == 2 && parts[1] == "topics" && r.Method == http.MethodPut:
+		var req struct {
+			TopicIDs []string `json:"topic_ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		for _, id := range req.TopicIDs {
+			if strings.Contains(id, ",") {
+				writeError(w, fmt.Sprintf("Topic not found: %s", id), http.StatusNotFound)
+				return
+			}
+			if topic, err := dataStore.GetTopic(id); err != nil || !topicInTenant(r.Context(), topic) {
+				writeError(w, fmt.Sprintf("Topic not found: %s", id), http.StatusNotFound)
+				return
+			}
+		}
+		updated, err := updateClassFields(class.ID, map[string]any{"AssignedTopics": strings.Join(req.TopicIDs, ",")})
+		if err != nil {
+			writeError(w, "Failed to assign topics", http.StatusInternalServerError)
+			return
+		}
+		updated.IsTeacher = true
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(updated)
+
+	case len(parts) == 2 && parts[1] == "join-code" && r.Method == http.MethodPost:
+		updated, err := updateClassFields(class.ID, map[string]any{"JoinCode": newJoinCode()})
+		if err != nil {
+			writeError(w, "Failed to update join code", http.StatusInternalServerError)
+			return
+		}
+		updated.IsTeacher = true
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(updated)
+
+	case len(parts) == 2 && parts[1] == "report" && r.Method == http.MethodGet:
+		report, err := getClassReport(class, time.Now())
+		if err != nil {
+			writeError(w, "Failed to build class report", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"class":    class,
+			"students": report,
+		})
+
+	case len(parts) == 3 && parts[1] == "members" && r.Method == http.MethodDelete:
+		removed, err := removeClassMember(class.ID, parts[2])
+		if err != nil {
+			writeError(w, "Failed to remove student", http.StatusInternalServerError)
+			return
+		}
+		if !removed {
+			writeError(w, "Student not found in class", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, "Not found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,530 @@
+// Package client is a typed Go client for the German Conjunctions Trainer API, for tools
+// such as a CLI, a chat bot or integration tests:
+//
+//	c := client.New("https://trainer.example.com", "gct_...")
+//	set, err := c.Exercises(ctx, client.ExercisesRequest{TopicID: topicID})
+//
+// Requests authenticate with a personal access token (POST /api/user/tokens), so they
+// need no CSRF token. Without a token, requests are anonymous and progress is not kept.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls the API of one deployment. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	token      string
+	HTTPClient *http.Client
+}
+
+// New returns a client for the deployment at baseURL, authenticated with token (empty for
+// anonymous access).
+func New(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		HTTPClient: &http.Client{Timeout: 2 * time.Minute}, // generating exercises can take a while
+	}
+}
+
+// Error is an error response from the API.
+type Error struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RequestID  string `json:"request_id"`
+}
+
+func (e *Error) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("%s (%d %s, request %s)", e.Message, e.StatusCode, e.Code, e.RequestID)
+	}
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+// IsNotFound reports whether err is a 404 from the API.
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+type Topic struct {
+	ID                 string    `json:"id"`
+	Name               string    `json:"name"`
+	Prompt             string    `json:"prompt"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+	Archived           bool      `json:"archived"`
+	RefinementDisabled bool      `json:"refinement_disabled"`
+	MetaPrompt         string    `json:"meta_prompt,omitempty"`
+}
+
+// ExercisesRequest asks for a set of exercises. Level, Theme and Count are optional.
+type ExercisesRequest struct {
+	TopicID string `json:"topic_id"`
+	Level   string `json:"level,omitempty"`
+	Theme   string `json:"theme,omitempty"`
+	Count   int    `json:"count,omitempty"`
+	// Difficulty is easy, normal or hard; empty for the user's default
+	Difficulty string `json:"difficulty,omitempty"`
+	// GrammarTags limits the set to exercises with all these tags, e.g. "conjunction:obwohl"
+	GrammarTags []string `json:"grammar_tags,omitempty"`
+	// Escalate swaps the rest of the set for harder exercises after a perfect start
+	Escalate bool `json:"escalate,omitempty"`
+}
+
+type Exercise struct {
+	ID                    string `json:"id"`
+	TopicID               string `json:"topic_id,omitempty"` // set in a daily mix
+	CorrectGermanSentence string `json:"correct_german_sentence"`
+	EnglishHint           string `json:"english_hint"`
+	ConjunctionTopic      string `json:"conjunction_topic,omitempty"`
+	NativeHint            string `json:"native_hint,omitempty"`     // the hint in the user's native language, if set
+	NativeLanguage        string `json:"native_language,omitempty"` // its ISO 639-1 code
+	// Other valid orderings of the sentence's words, accepted as answers too
+	AlternativeSentences []string `json:"alternative_sentences,omitempty"`
+}
+
+// DailyLimits is what is left of today's new exercises and reviews.
+type DailyLimits struct {
+	NewLimit         int `json:"new_limit"`
+	ReviewLimit      int `json:"review_limit"`
+	NewRemaining     int `json:"new_remaining"`
+	ReviewsRemaining int `json:"reviews_remaining"`
+}
+
+type ExerciseSet struct {
+	Exercises []Exercise  `json:"exercises"`
+	Limits    DailyLimits `json:"limits"`
+}
+
+// Review grades
+const (
+	GradeAgain = "again"
+	GradeHard  = "hard"
+	GradeGood  = "good"
+	GradeEasy  = "easy"
+)
+
+// Review is the answer to one exercise. HintPositions are the positions of the words (from
+// 0, not counting punctuation) the learner needed hints for.
+type Review struct {
+	ExerciseID    string `json:"exercise_id"`
+	Grade         string `json:"grade"`
+	HintPositions []int  `json:"hint_positions,omitempty"`
+}
+
+// ReviewResult is an exercise's schedule after answering it.
+type ReviewResult struct {
+	ExerciseID        string    `json:"exercise_id"`
+	Grade             string    `json:"grade"`
+	RepetitionCounter int       `json:"repetition_counter"`
+	NextReview        time.Time `json:"next_review"`
+}
+
+// CurrentSession is the unfinished set last served by Exercises.
+type CurrentSession struct {
+	TopicID   string     `json:"topic_id"`
+	Exercises []Exercise `json:"exercises"`
+	Answered  []string   `json:"answered"`
+	Mistakes  int        `json:"mistakes"`
+	Hints     int        `json:"hints"`
+	TimeSpent int        `json:"time_spent"`
+	StartedAt time.Time  `json:"started_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// SessionProgress is saved to the current session after each answer.
+type SessionProgress struct {
+	Answered  []string `json:"answered"`
+	Mistakes  int      `json:"mistakes"`
+	Hints     int      `json:"hints"`
+	TimeSpent int      `json:"time_spent"`
+}
+
+// Stats are a learner's totals. TotalTime is in seconds. TopicID is only sent with AddStats;
+// the XP and level fields are only returned by Stats. LevelXP and NextLevelXP are the XP
+// the current level starts at and the next one starts at.
+type Stats struct {
+	TopicID        string `json:"topic_id,omitempty"`
+	TotalExercises int    `json:"total_exercises"`
+	TotalMistakes  int    `json:"total_mistakes"`
+	TotalHints     int    `json:"total_hints"`
+	TotalTime      int    `json:"total_time"`
+	LastTopicID    string `json:"last_topic_id"`
+	XP             int    `json:"xp,omitempty"`
+	Level          int    `json:"level,omitempty"`
+	LevelXP        int    `json:"level_xp,omitempty"`
+	NextLevelXP    int    `json:"next_level_xp,omitempty"`
+}
+
+// StatsIncrement is one finished set. The idempotency key makes retries count once.
+type StatsIncrement struct {
+	IdempotencyKey string `json:"idempotency_key"`
+	TopicID        string `json:"topic_id,omitempty"`
+	Exercises      int    `json:"exercises"`
+	Mistakes       int    `json:"mistakes"`
+	Hints          int    `json:"hints"`
+	TimeSpent      int    `json:"time_spent"`
+}
+
+// StatsHistoryRow is one finished set, or the sets of a day, week or month. Date is the
+// set's time or the period's first day; Accuracy is the share of answers right first time.
+type StatsHistoryRow struct {
+	Date      string  `json:"date"`
+	Sets      int     `json:"sets"`
+	Exercises int     `json:"exercises"`
+	Mistakes  int     `json:"mistakes"`
+	Hints     int     `json:"hints"`
+	TimeSpent int     `json:"time_spent"`
+	Accuracy  float64 `json:"accuracy"`
+}
+
+// TopicStats is the sum of a learner's finished sets in one topic. TimeSpent is in seconds.
+type TopicStats struct {
+	TopicID   string  `json:"topic_id"`
+	TopicName string  `json:"topic_name"`
+	Sets      int     `json:"sets"`
+	Exercises int     `json:"exercises"`
+	Mistakes  int     `json:"mistakes"`
+	Hints     int     `json:"hints"`
+	TimeSpent int     `json:"time_spent"`
+	Accuracy  float64 `json:"accuracy"`
+}
+
+// HintPattern is a grammar pattern (a conjunction within a topic) and how much the learner
+// relied on hints for it. HintRate is hints per answered exercise.
+type HintPattern struct {
+	TopicID     string  `json:"topic_id"`
+	TopicName   string  `json:"topic_name"`
+	Conjunction string  `json:"conjunction"`
+	Hints       int     `json:"hints"`
+	Exercises   int     `json:"exercises"`
+	Answered    int     `json:"answered"`
+	HintRate    float64 `json:"hint_rate"`
+}
+
+// TagMastery is the learner's mastery of a grammar tag, such as "conjunction:weil". Score
+// runs from 0 to 100 and Level is weak, learning or mastered.
+type TagMastery struct {
+	Tag      string `json:"tag"`
+	Kind     string `json:"kind"`
+	Value    string `json:"value"`
+	Answered int    `json:"answered"`
+	Failing  int    `json:"failing"`
+	Mastered int    `json:"mastered"`
+	Score    int    `json:"score"`
+	Level    string `json:"level"`
+}
+
+// Explanation is the grammar explanation of an exercise.
+type Explanation struct {
+	ExerciseID  string    `json:"exercise_id"`
+	Sentence    string    `json:"sentence"`
+	Explanation string    `json:"explanation"`
+	Model       string    `json:"model"`
+	CreatedAt   time.Time `json:"created_at"`
+	Cached      bool      `json:"cached"`
+}
+
+// HintedExercise is an exercise the learner needed hints for, with the hinted words.
+type HintedExercise struct {
+	ExerciseID  string `json:"exercise_id"`
+	TopicID     string `json:"topic_id"`
+	Sentence    string `json:"sentence"`
+	Conjunction string `json:"conjunction"`
+	Hints       int    `json:"hints"`
+	Words       []struct {
+		Position int    `json:"position"`
+		Word     string `json:"word"`
+		Hints    int    `json:"hints"`
+	} `json:"words"`
+}
+
+// TopicProgress is a learner's SRS state for one topic.
+type TopicProgress struct {
+	TopicID   string `json:"topic_id"`
+	TopicName string `json:"topic_name"`
+	Total     int    `json:"total"`
+	Seen      int    `json:"seen"`
+	Due       int    `json:"due"`
+	New       int    `json:"new"`
+	Mastered  int    `json:"mastered"`
+}
+
+// Topics returns every active topic, following pagination.
+func (c *Client) Topics(ctx context.Context) ([]Topic, error) {
+	topics := []Topic{}
+	cursor := ""
+	for {
+		query := url.Values{"limit": {"200"}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		var page struct {
+			Items      []Topic `json:"items"`
+			NextCursor string  `json:"next_cursor"`
+		}
+		if err := c.do(ctx, http.MethodGet, "/api/topics?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		topics = append(topics, page.Items...)
+		if page.NextCursor == "" {
+			return topics, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func (c *Client) Topic(ctx context.Context, id string) (*Topic, error) {
+	var topic Topic
+	if err := c.do(ctx, http.MethodGet, "/api/topics/"+url.PathEscape(id), nil, &topic); err != nil {
+		return nil, err
+	}
+	return &topic, nil
+}
+
+// CreateTopic creates a topic (admin).
+func (c *Client) CreateTopic(ctx context.Context, name, prompt string) (*Topic, error) {
+	var topic Topic
+	body := map[string]string{"name": name, "prompt": prompt}
+	if err := c.do(ctx, http.MethodPost, "/api/topics", body, &topic); err != nil {
+		return nil, err
+	}
+	return &topic, nil
+}
+
+// UpdateTopic changes a topic's name and prompt (admin).
+func (c *Client) UpdateTopic(ctx context.Context, id, name, prompt string) (*Topic, error) {
+	var topic Topic
+	body := map[string]string{"name": name, "prompt": prompt}
+	if err := c.do(ctx, http.MethodPut, "/api/topics/"+url.PathEscape(id), body, &topic); err != nil {
+		return nil, err
+	}
+	return &topic, nil
+}
+
+// ArchiveTopic archives a topic (admin). It can be restored later.
+func (c *Client) ArchiveTopic(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/topics/"+url.PathEscape(id), nil, nil)
+}
+
+// Exercises returns a set of exercises, due reviews first, and starts it as the current session.
+func (c *Client) Exercises(ctx context.Context, req ExercisesRequest) (*ExerciseSet, error) {
+	var set ExerciseSet
+	if err := c.do(ctx, http.MethodPost, "/api/exercises", req, &set); err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+// DailyMix returns up to count (0 for the default of 10) due reviews from all the learner's
+// topics, interleaved by topic. Each exercise has its TopicID. It generates nothing, so the
+// set is empty when nothing is due.
+func (c *Client) DailyMix(ctx context.Context, count int) (*ExerciseSet, error) {
+	var set ExerciseSet
+	if err := c.do(ctx, http.MethodPost, "/api/exercises/mix", map[string]int{"count": count}, &set); err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+// SubmitReviews records answers, which advances the exercises' SRS schedules.
+func (c *Client) SubmitReviews(ctx context.Context, reviews ...Review) ([]ReviewResult, error) {
+	var result struct {
+		Reviews []ReviewResult `json:"reviews"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/exercises/reviews", map[string]any{"reviews": reviews}, &result); err != nil {
+		return nil, err
+	}
+	return result.Reviews, nil
+}
+
+// CurrentSession returns the unfinished set, or nil if there is nothing to resume.
+func (c *Client) CurrentSession(ctx context.Context) (*CurrentSession, error) {
+	var session CurrentSession
+	if err := c.do(ctx, http.MethodGet, "/api/sessions/current", nil, &session); err != nil {
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (c *Client) SaveSessionProgress(ctx context.Context, progress SessionProgress) error {
+	return c.do(ctx, http.MethodPut, "/api/sessions/current", progress, nil)
+}
+
+func (c *Client) DiscardSession(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/api/sessions/current", nil, nil)
+}
+
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var stats Stats
+	if err := c.do(ctx, http.MethodGet, "/api/user/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// AddStats records a finished set's exercises, mistakes, hints and time, which are added
+// to the totals and the history.
+//
+// Deprecated: use IncrementStats, which is safe to retry.
+func (c *Client) AddStats(ctx context.Context, stats Stats) error {
+	return c.do(ctx, http.MethodPost, "/api/user/stats", stats, nil)
+}
+
+// IncrementStats adds a finished set to the totals and the history, and returns the new
+// totals. Retrying with the same idempotency key doesn't count the set again.
+func (c *Client) IncrementStats(ctx context.Context, increment StatsIncrement) (*Stats, error) {
+	var stats Stats
+	if err := c.do(ctx, http.MethodPost, "/api/user/stats/increment", increment, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// StatsHistory returns the learner's finished sets, oldest first, grouped by "day", "week"
+// or "month" (empty for one row per set).
+func (c *Client) StatsHistory(ctx context.Context, group string) ([]StatsHistoryRow, error) {
+	path := "/api/user/stats/history"
+	if group != "" {
+		path += "?group=" + url.QueryEscape(group)
+	}
+	var result struct {
+		History []StatsHistoryRow `json:"history"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.History, nil
+}
+
+// TopicStats returns the learner's stats for each topic they practised, most time spent first.
+func (c *Client) TopicStats(ctx context.Context) ([]TopicStats, error) {
+	var result struct {
+		Topics []TopicStats `json:"topics"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/user/stats/topics", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Topics, nil
+}
+
+// Hints returns the learner's grammar patterns, most hints first, and the limit exercises
+// (0 for the default of 10) they needed most hints for.
+func (c *Client) Hints(ctx context.Context, limit int) ([]HintPattern, []HintedExercise, error) {
+	path := "/api/user/hints"
+	if limit > 0 {
+		path += fmt.Sprintf("?limit=%d", limit)
+	}
+	var result struct {
+		Patterns  []HintPattern    `json:"patterns"`
+		Exercises []HintedExercise `json:"exercises"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, nil, err
+	}
+	return result.Patterns, result.Exercises, nil
+}
+
+// Mastery returns the learner's mastery of each grammar tag, weakest first, for one kind of
+// tag (conjunction, tense, mood or preposition; empty for all).
+func (c *Client) Mastery(ctx context.Context, kind string) ([]TagMastery, error) {
+	path := "/api/user/mastery"
+	if kind != "" {
+		path += "?kind=" + url.QueryEscape(kind)
+	}
+	var result struct {
+		Tags []TagMastery `json:"tags"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Tags, nil
+}
+
+// Explain returns a short explanation of the grammar of an exercise's sentence. It is
+// generated on first request and cached on the server.
+func (c *Client) Explain(ctx context.Context, exerciseID string) (*Explanation, error) {
+	var explanation Explanation
+	if err := c.do(ctx, http.MethodPost, "/api/exercises/"+url.PathEscape(exerciseID)+"/explain", nil, &explanation); err != nil {
+		return nil, err
+	}
+	return &explanation, nil
+}
+
+// Progress returns the learner's SRS state per topic at a level (empty for B1).
+func (c *Client) Progress(ctx context.Context, level string) ([]TopicProgress, error) {
+	path := "/api/user/progress"
+	if level != "" {
+		path += "?level=" + url.QueryEscape(level)
+	}
+	var result struct {
+		Topics []TopicProgress `json:"topics"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Topics, nil
+}
+
+// do sends a request with body encoded as JSON and decodes the response into out, if not nil.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		var envelope struct {
+			Error *Error `json:"error"`
+		}
+		envelope.Error = apiErr
+		if json.NewDecoder(resp.Body).Decode(&envelope) != nil || apiErr.Message == "" {
+			apiErr.Message = resp.Status
+		}
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
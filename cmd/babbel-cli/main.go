@@ -0,0 +1,311 @@
+// Command babbel-cli practices exercises in the terminal. It fetches a set for a topic,
+// drills it interactively, and sends each answer to the review API so the spaced
+// repetition schedule is shared with the web app.
+//
+//	babbel-cli -url https://trainer.example.com -token gct_... -topic "Subordinating conjunctions"
+//
+// The URL and token can also be set with BABBEL_URL and BABBEL_TOKEN.
+package main
+
+import (
+	"bufio"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"german-conjunctions-trainer/client"
+)
+
+var (
+	tokenPattern  = regexp.MustCompile(`[\p{L}\p{N}']+|[^\s\p{L}\p{N}]`) // as in app.js
+	letterPattern = regexp.MustCompile(`[\p{L}\p{N}]`)
+)
+
+const maxAttempts = 3 // wrong answers before the solution is shown
+
+// drill holds the progress of a set, as saved to the current session.
+type drill struct {
+	c        *client.Client
+	in       *bufio.Reader
+	mode     string
+	started  time.Time
+	answered []string
+	mistakes int
+	hints    int
+}
+
+func main() {
+	baseURL := flag.String("url", envOr("BABBEL_URL", "http://localhost:8080"), "base URL of the trainer")
+	token := flag.String("token", os.Getenv("BABBEL_TOKEN"), "personal access token (gct_...)")
+	topicArg := flag.String("topic", "", "topic ID or name; lists the topics when empty")
+	level := flag.String("level", "", "CEFR level, A1-C2 (default B1)")
+	count := flag.Int("count", 0, "number of exercises, 5-30 (default 10)")
+	difficulty := flag.String("difficulty", "", "easy, normal or hard (default from your profile)")
+	mode := flag.String("mode", "order", "drill: order (put the words in order) or fill (type the missing word)")
+	flag.Parse()
+
+	if *token == "" {
+		log.Fatal("An API token is required: pass -token or set BABBEL_TOKEN")
+	}
+	if *mode != "order" && *mode != "fill" {
+		log.Fatal("-mode must be order or fill")
+	}
+
+	ctx := context.Background()
+	c := client.New(*baseURL, *token)
+	topics, err := c.Topics(ctx)
+	if err != nil {
+		log.Fatalf("Failed to load topics: %v", err)
+	}
+	if *topicArg == "" {
+		for _, t := range topics {
+			fmt.Printf("%s  %s\n", t.ID, t.Name)
+		}
+		return
+	}
+	topic := findTopic(topics, *topicArg)
+	if topic == nil {
+		log.Fatalf("Topic %q not found", *topicArg)
+	}
+
+	d := &drill{c: c, in: bufio.NewReader(os.Stdin), mode: *mode, started: time.Now()}
+	exercises, err := d.load(ctx, topic, *level, *difficulty, *count)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(exercises) == 0 {
+		fmt.Println("Nothing to practice right now. Come back tomorrow!")
+		return
+	}
+
+	fmt.Printf("%s: %d exercises. Type ? for a hint, or an empty line to give up.\n", topic.Name, len(exercises))
+	for i, ex := range exercises {
+		if slices.Contains(d.answered, ex.ID) {
+			continue
+		}
+		fmt.Printf("\n[%d/%d] %s\n", i+1, len(exercises), ex.EnglishHint)
+		if ex.NativeHint != "" {
+			fmt.Printf("      %s\n", ex.NativeHint)
+		}
+		grade, hinted, err := d.practice(ex)
+		if errors.Is(err, io.EOF) {
+			fmt.Println("\nStopped. Run again to resume this set.")
+			return
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		d.submit(ctx, ex, grade, hinted)
+	}
+
+	elapsed := int(time.Since(d.started).Seconds())
+	fmt.Printf("\nDone: %d exercises, %d mistakes, %d hints, %ds.\n", len(exercises), d.mistakes, d.hints, elapsed)
+	increment := client.StatsIncrement{
+		IdempotencyKey: newIdempotencyKey(),
+		TopicID:        topic.ID,
+		Exercises:      len(exercises),
+		Mistakes:       d.mistakes,
+		Hints:          d.hints,
+		TimeSpent:      elapsed,
+	}
+	if _, err := c.IncrementStats(ctx, increment); err != nil {
+		log.Printf("Warning: failed to save stats: %v", err)
+	}
+	if err := c.DiscardSession(ctx); err != nil {
+		log.Printf("Warning: failed to close the session: %v", err)
+	}
+}
+
+// load resumes the unfinished set for the topic, or fetches a new one.
+func (d *drill) load(ctx context.Context, topic *client.Topic, level, difficulty string, count int) ([]client.Exercise, error) {
+	session, err := d.c.CurrentSession(ctx)
+	if err != nil {
+		log.Printf("Warning: failed to check for an unfinished set: %v", err)
+	}
+	if session != nil && session.TopicID == topic.ID {
+		fmt.Printf("Resuming your unfinished set (%d of %d answered).\n", len(session.Answered), len(session.Exercises))
+		d.answered = session.Answered
+		d.mistakes = session.Mistakes
+		d.hints = session.Hints
+		d.started = time.Now().Add(-time.Duration(session.TimeSpent) * time.Second)
+		return session.Exercises, nil
+	}
+
+	set, err := d.c.Exercises(ctx, client.ExercisesRequest{TopicID: topic.ID, Level: level, Difficulty: difficulty, Count: count})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch exercises: %v", err)
+	}
+	return set.Exercises, nil
+}
+
+// practice asks for one exercise until it is answered correctly, the learner gives up, or
+// they run out of attempts, and returns the grade for the answer and the positions of the
+// words hints were shown for.
+func (d *drill) practice(ex client.Exercise) (string, []int, error) {
+	tokens := tokenPattern.FindAllString(ex.CorrectGermanSentence, -1)
+	words := wordsOf(tokens)
+	if len(words) == 0 {
+		return "", nil, fmt.Errorf("exercise %s has no sentence", ex.ID)
+	}
+	expected := []string{strings.Join(words, " ")}
+	hintWords := words
+	hintOffset := 0 // position of the first hint word in the sentence
+	if d.mode == "fill" {
+		blank := blankIndex(words, ex.ConjunctionTopic)
+		expected = []string{words[blank]}
+		hintWords = []string{words[blank]}
+		hintOffset = blank
+		fmt.Println("  " + withBlank(tokens, blank))
+	} else {
+		for _, alternative := range ex.AlternativeSentences {
+			expected = append(expected, strings.Join(wordsOf(tokenPattern.FindAllString(alternative, -1)), " "))
+		}
+		shuffled := append([]string{}, words...)
+		rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		fmt.Println("  " + strings.Join(shuffled, " / "))
+	}
+
+	start := time.Now()
+	mistakes, hints := 0, 0
+	var hinted []int
+	for {
+		fmt.Print("> ")
+		line, err := d.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", nil, err
+		}
+		answer := strings.Join(wordsOf(tokenPattern.FindAllString(line, -1)), " ")
+
+		switch {
+		case strings.TrimSpace(line) == "?":
+			if hints < len(hintWords) {
+				hinted = append(hinted, hintOffset+hints)
+				hints++
+				d.hints++
+			}
+			fmt.Printf("  Starts with: %s\n", strings.Join(hintWords[:hints], " "))
+			continue
+		case answer == "":
+			fmt.Printf("  Solution: %s\n", ex.CorrectGermanSentence)
+			return client.GradeAgain, hinted, nil
+		case slices.Contains(expected, answer):
+			fmt.Printf("  Correct! %s\n", ex.CorrectGermanSentence)
+			return gradeAnswer(mistakes, hints, time.Since(start), len(words)), hinted, nil
+		}
+
+		mistakes++
+		d.mistakes++
+		if mistakes >= maxAttempts {
+			fmt.Printf("  Solution: %s\n", ex.CorrectGermanSentence)
+			return client.GradeAgain, hinted, nil
+		}
+		fmt.Println("  Not quite, try again.")
+	}
+}
+
+// submit sends the answer to the review API and saves the set's progress. Failures are
+// reported but don't stop the drill.
+func (d *drill) submit(ctx context.Context, ex client.Exercise, grade string, hinted []int) {
+	if _, err := d.c.SubmitReviews(ctx, client.Review{ExerciseID: ex.ID, Grade: grade, HintPositions: hinted}); err != nil {
+		log.Printf("Warning: failed to submit the answer: %v", err)
+	}
+	d.answered = append(d.answered, ex.ID)
+	progress := client.SessionProgress{
+		Answered:  d.answered,
+		Mistakes:  d.mistakes,
+		Hints:     d.hints,
+		TimeSpent: int(time.Since(d.started).Seconds()),
+	}
+	if err := d.c.SaveSessionProgress(ctx, progress); err != nil {
+		log.Printf("Warning: failed to save progress: %v", err)
+	}
+}
+
+// gradeAnswer grades a correct answer the way the web app does, allowing more time for typing.
+func gradeAnswer(mistakes, hints int, took time.Duration, words int) string {
+	switch {
+	case mistakes > 0 || hints > 0:
+		return client.GradeHard
+	case took <= time.Duration(words)*3*time.Second:
+		return client.GradeEasy
+	default:
+		return client.GradeGood
+	}
+}
+
+// wordsOf drops punctuation tokens.
+func wordsOf(tokens []string) []string {
+	var words []string
+	for _, token := range tokens {
+		if letterPattern.MatchString(token) {
+			words = append(words, token)
+		}
+	}
+	return words
+}
+
+// withBlank renders the sentence's tokens with the given word replaced by a blank.
+func withBlank(tokens []string, blank int) string {
+	var b strings.Builder
+	word := 0
+	for _, token := range tokens {
+		if !letterPattern.MatchString(token) {
+			b.WriteString(token) // punctuation follows the previous word
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(" ")
+		}
+		if word == blank {
+			token = "____"
+		}
+		b.WriteString(token)
+		word++
+	}
+	return b.String()
+}
+
+// blankIndex picks the word to leave out in fill mode: the exercise's conjunction, or a
+// random word when it has none.
+func blankIndex(words []string, conjunction string) int {
+	for i, word := range words {
+		if conjunction != "" && strings.EqualFold(word, conjunction) {
+			return i
+		}
+	}
+	return rand.Intn(len(words))
+}
+
+func findTopic(topics []client.Topic, arg string) *client.Topic {
+	for i, t := range topics {
+		if t.ID == arg || strings.EqualFold(t.Name, arg) {
+			return &topics[i]
+		}
+	}
+	return nil
+}
+
+// newIdempotencyKey returns a random key for saving a finished set's stats.
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func envOr(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Responses are compressed with brotli or gzip, whichever the client prefers in
+// Accept-Encoding (brotli on a tie). Only text formats are compressed, and only bodies of
+// at least minCompressSize bytes: below that the headers outweigh the savings. WebSocket
+// upgrades are passed through untouched.
+const minCompressSize = 1024
+
+// Content types worth compressing; images other than SVG are already compressed
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"image/svg+xml":          true,
+	"text/calendar":          true,
+	"text/css":               true,
+	"text/csv":               true,
+	"text/html":              true,
+	"text/javascript":        true,
+	"text/plain":             true,
+}
+
+// withCompression compresses responses for clients that accept it.
+func withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: negotiateEncoding(r.Header.Get("Accept-Encoding"))}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks br or gzip from an Accept-Encoding header, or "" for neither.
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if name == "*" {
+			name = "br"
+		}
+		if (name != "br" && name != "gzip") || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == "br") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter holds back the start of the body until it knows whether compressing it
+// is worthwhile, then writes it through an encoder or as it is.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string // negotiated with the client; "" writes every response as it is
+
+	status   int
+	checked  bool // whether compress is known
+	compress bool
+	decided  bool
+	buf      []byte
+	encoder  io.WriteCloser // set once the body is being compressed
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.decided {
+		if !cw.compressible() {
+			cw.decide(false)
+		} else if len(cw.buf)+len(p) < minCompressSize {
+			cw.buf = append(cw.buf, p...)
+			return len(p), nil
+		} else {
+			cw.decide(true)
+		}
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// compressible reports whether the response may be compressed, judging by its headers.
+func (cw *compressWriter) compressible() bool {
+	if cw.checked {
+		return cw.compress
+	}
+	cw.checked = true
+	h := cw.Header()
+	switch {
+	case h.Get("Content-Encoding") != "", h.Get("Content-Range") != "":
+		return false
+	case cw.status < 200, cw.status == http.StatusNoContent, cw.status == http.StatusPartialContent, cw.status == http.StatusNotModified:
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if !compressibleTypes[mediaType] {
+		return false
+	}
+	// Caches must keep the variants apart, even for clients that get it uncompressed
+	h.Add("Vary", "Accept-Encoding")
+	cw.compress = cw.encoding != ""
+	return cw.compress
+}
+
+// decide sends the headers, and any body held back, compressed or not.
+func (cw *compressWriter) decide(compress bool) {
+	cw.decided = true
+	if compress {
+		h := cw.Header()
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		// The compressed body differs byte for byte, so a strong ETag becomes a weak one
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+		if cw.encoding == "br" {
+			cw.encoder = brotli.NewWriterLevel(cw.ResponseWriter, 5)
+		} else {
+			cw.encoder, _ = gzip.NewWriterLevel(cw.ResponseWriter, gzip.DefaultCompression)
+		}
+	}
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	if len(cw.buf) > 0 {
+		buf := cw.buf
+		cw.buf = nil
+		if cw.encoder != nil {
+			cw.encoder.Write(buf)
+		} else {
+			cw.ResponseWriter.Write(buf)
+		}
+	}
+}
+
+// Close sends what is still held back and finishes the compressed stream.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Close()
+	}
+	return nil
+}
+
+// Flush sends what has been written so far, deciding about compression if it hasn't yet.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(cw.status != 0 && cw.compressible())
+	}
+	if flusher, ok := cw.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := cw.ResponseWriter.(http.Hijacker); ok && !cw.decided {
+		cw.decided = true
+		return hijacker.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response cannot be hijacked")
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
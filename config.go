@@ -0,0 +1,483 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Config holds every setting read from the environment. It is loaded and validated once at
+// startup, so a misconfigured deployment refuses to start with a list of what is wrong
+// instead of failing requests later. Secrets are redacted in the admin view (see redacted).
+type Config struct {
+	Port      string `json:"port"`
+	GRPCPort  string `json:"grpc_port"`
+	Storage   string `json:"storage"`
+	StaticDir string `json:"static_dir"`
+
+	AirtableToken  string `json:"airtable_token"`
+	AirtableBaseID string `json:"airtable_base_id"`
+
+	OpenAIAPIKey    string `json:"openai_api_key"`
+	OpenAIURL       string `json:"openai_url"`
+	ModelName       string `json:"model_name"`
+	MockLLM         bool   `json:"mock_llm"`
+	MockLLMFixtures string `json:"mock_llm_fixtures"`
+	ContentPacks    string `json:"content_packs"`
+	OfflineMode     bool   `json:"offline_mode"`
+	ImageModel      string `json:"image_model"`
+	ImageSize       string `json:"image_size"`
+	ImageS3Prefix   string `json:"image_s3_prefix"`
+	STTURL          string `json:"stt_url"`
+	STTAPIKey       string `json:"stt_api_key"`
+	STTModel        string `json:"stt_model"`
+
+	LanguageToolURL      string `json:"languagetool_url"`
+	LanguageToolUsername string `json:"languagetool_username"`
+	LanguageToolAPIKey   string `json:"languagetool_api_key"`
+
+	GeminiAPIKey string   `json:"gemini_api_key"`
+	GeminiURL    string   `json:"gemini_url"`
+	GeminiModel  string   `json:"gemini_model"`
+	LLMFallback  []string `json:"llm_fallback"`
+
+	GoogleClientID     string   `json:"google_client_id"`
+	GoogleClientSecret string   `json:"google_client_secret"`
+	GoogleRedirectURL  string   `json:"google_redirect_url"`
+	GoogleAdminID      string   `json:"google_admin_id"`
+	GoogleExtraScopes  []string `json:"google_extra_scopes"` // asked for at login, for integrations (see google_tokens.go)
+
+	AppBaseURL            string   `json:"app_base_url"`
+	SessionSecret         string   `json:"session_secret"`
+	SessionSecretPrevious []string `json:"session_secret_previous"`
+	CookieSecure          bool     `json:"cookie_secure"`
+	CookieSameSite        string   `json:"cookie_samesite"`
+
+	// Master key for secrets stored in Airtable (see secrets.go)
+	SecretsEncryptionKey         string   `json:"secrets_encryption_key"`
+	SecretsEncryptionKeyPrevious []string `json:"secrets_encryption_key_previous"`
+
+	SMTPHost     string `json:"smtp_host"`
+	SMTPPort     string `json:"smtp_port"`
+	SMTPUsername string `json:"smtp_username"`
+	SMTPPassword string `json:"smtp_password"`
+	SMTPFrom     string `json:"smtp_from"`
+
+	VAPIDPublicKey  string `json:"vapid_public_key"`
+	VAPIDPrivateKey string `json:"vapid_private_key"`
+	VAPIDSubject    string `json:"vapid_subject"`
+
+	MarketplaceURL string            `json:"marketplace_url"`
+	RedisURL       string            `json:"redis_url"`
+	RateLimits     map[string]string `json:"rate_limits"`
+	TrustedProxies []string          `json:"trusted_proxies"` // addresses and CIDR ranges whose X-Forwarded-For is believed
+
+	BackupS3Endpoint    string `json:"backup_s3_endpoint"`
+	BackupS3Bucket      string `json:"backup_s3_bucket"`
+	BackupS3Region      string `json:"backup_s3_region"`
+	BackupS3AccessKey   string `json:"backup_s3_access_key"`
+	BackupS3SecretKey   string `json:"backup_s3_secret_key"`
+	BackupS3Prefix      string `json:"backup_s3_prefix"`
+	BackupEncryptionKey string `json:"backup_encryption_key"`
+	BackupSchedule      string `json:"backup_schedule"`
+	BackupKeepDaily     int    `json:"backup_keep_daily"`
+	BackupKeepWeekly    int    `json:"backup_keep_weekly"`
+
+	OTLPEndpoint    string `json:"otel_exporter_otlp_endpoint"`
+	OTelServiceName string `json:"otel_service_name"`
+
+	ReadinessCheckOpenAI bool `json:"readiness_check_openai"`
+
+	DailyNewLimit    int `json:"daily_new_limit"`
+	DailyReviewLimit int `json:"daily_review_limit"`
+
+	UserDailyGenerations   int `json:"user_daily_generations"`
+	UserMonthlyGenerations int `json:"user_monthly_generations"`
+
+	PromptVersionsKeep    int            `json:"prompt_versions_keep"`
+	ExerciseRetentionDays int            `json:"exercise_retention_days"`
+	SlowQueryThreshold    configDuration `json:"slow_query_threshold"`
+
+	// Thresholds of the exercise retirement policy; 0 is off (see exercise_retirement.go)
+	ExerciseRetireFlags       int  `json:"exercise_retire_flags"`
+	ExerciseRetireWrongRate   int  `json:"exercise_retire_wrong_rate"`
+	ExerciseRetireMinLearners int  `json:"exercise_retire_min_learners"`
+	ExerciseRetireReplace     bool `json:"exercise_retire_replace"`
+}
+
+// configDuration is shown as "500ms" rather than nanoseconds in the admin view.
+type configDuration time.Duration
+
+func (d configDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+var appConfig *Config
+
+// configLoader reads environment variables, collecting every problem instead of stopping at the first.
+type configLoader struct {
+	getenv func(string) string
+	errs   []error
+}
+
+func (l *configLoader) fail(format string, args ...any) {
+	l.errs = append(l.errs, fmt.Errorf(format, args...))
+}
+
+func (l *configLoader) str(name, def string) string {
+	if value := strings.TrimSpace(l.getenv(name)); value != "" {
+		return value
+	}
+	return def
+}
+
+func (l *configLoader) int(name string, def, least int) int {
+	value := l.getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < least {
+		l.fail("%s must be a number of at least %d, got %q", name, least, value)
+		return def
+	}
+	return n
+}
+
+func (l *configLoader) bool(name string, def bool) bool {
+	value := l.getenv(name)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		l.fail("%s must be true or false, got %q", name, value)
+		return def
+	}
+	return b
+}
+
+func (l *configLoader) duration(name string, def time.Duration) time.Duration {
+	value := l.getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		l.fail("%s must be a positive duration such as 500ms, got %q", name, value)
+		return def
+	}
+	return d
+}
+
+// url reads an absolute http(s) URL without a trailing slash.
+func (l *configLoader) url(name, def string) string {
+	value := strings.TrimSuffix(l.str(name, def), "/")
+	if value == "" {
+		return ""
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		l.fail("%s must be an http(s) URL, got %q", name, value)
+	}
+	return value
+}
+
+// fallbackChain reads the generation providers in order (see llm_fallback.go).
+func (l *configLoader) fallbackChain(name string, c *Config) []string {
+	var chain []string
+	for _, provider := range strings.Split(l.str(name, providerOpenAI), ",") {
+		provider = strings.ToLower(strings.TrimSpace(provider))
+		switch {
+		case provider == "":
+			continue
+		case provider != providerOpenAI && provider != providerGemini && provider != providerCached:
+			l.fail("%s: unknown provider %q (use %s, %s or %s)", name, provider, providerOpenAI, providerGemini, providerCached)
+		case slices.Contains(chain, provider):
+			l.fail("%s lists %s twice", name, provider)
+		case len(chain) > 0 && chain[len(chain)-1] == providerCached:
+			l.fail("%s: %s must come last", name, providerCached)
+		case provider == providerGemini && c.GeminiAPIKey == "" && !c.MockLLM:
+			l.fail("%s includes %s, which needs GEMINI_API_KEY", name, providerGemini)
+		}
+		chain = append(chain, provider)
+	}
+	if len(chain) == 0 || chain[0] == providerCached {
+		l.fail("%s must start with a model provider, got %q", name, l.getenv(name))
+	}
+	return chain
+}
+
+// loadConfig reads the configuration using getenv and returns all validation errors together.
+func loadConfig(getenv func(string) string) (*Config, error) {
+	l := &configLoader{getenv: getenv}
+	c := &Config{}
+
+	c.Port = l.str("PORT", "8080")
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		l.fail("PORT must be a port number, got %q", c.Port)
+	}
+	c.GRPCPort = l.str("GRPC_PORT", "")
+	if c.GRPCPort != "" {
+		if port, err := strconv.Atoi(c.GRPCPort); err != nil || port < 1 || port > 65535 || c.GRPCPort == c.Port {
+			l.fail("GRPC_PORT must be a port number other than PORT, got %q", c.GRPCPort)
+		}
+	}
+
+	c.Storage = strings.ToLower(l.str("STORAGE", "airtable"))
+	c.StaticDir = l.str("STATIC_DIR", "")
+	if c.StaticDir != "" {
+		if _, err := os.Stat(filepath.Join(c.StaticDir, "index.html")); err != nil {
+			l.fail("STATIC_DIR must be a directory with an index.html: %v", err)
+		}
+	}
+
+	c.AirtableToken = l.str("AIRTABLE_TOKEN", "")
+	c.AirtableBaseID = l.str("AIRTABLE_BASE_ID", "")
+	switch c.Storage {
+	case "airtable":
+		if c.AirtableToken == "" {
+			l.fail("AIRTABLE_TOKEN is required (or set STORAGE=memory)")
+		}
+		if c.AirtableBaseID == "" {
+			l.fail("AIRTABLE_BASE_ID is required (or set STORAGE=memory)")
+		}
+	case "memory":
+	default:
+		l.fail("STORAGE must be airtable or memory, got %q", c.Storage)
+	}
+
+	c.MockLLM = l.bool("MOCK_LLM", false)
+	c.MockLLMFixtures = l.str("MOCK_LLM_FIXTURES", defaultMockLLMFixtures)
+	c.ContentPacks = l.str("CONTENT_PACKS", "")
+	c.OfflineMode = l.bool("OFFLINE_MODE", false)
+	c.OpenAIAPIKey = l.str("OPENAI_API_KEY", "")
+	c.OpenAIURL = l.url("OPENAI_URL", "https://api.openai.com/v1")
+	c.ModelName = l.str("MODEL_NAME", "gpt-3.5-turbo-1106")
+	c.ImageModel = l.str("IMAGE_MODEL", "")
+	c.ImageSize = l.str("IMAGE_SIZE", "1024x1024")
+	c.ImageS3Prefix = l.str("IMAGE_S3_PREFIX", "images/")
+	c.STTURL = l.url("STT_URL", "")
+	c.STTAPIKey = l.str("STT_API_KEY", "")
+	c.STTModel = l.str("STT_MODEL", "whisper-1")
+	c.LanguageToolURL = l.url("LANGUAGETOOL_URL", "")
+	c.LanguageToolUsername = l.str("LANGUAGETOOL_USERNAME", "")
+	c.LanguageToolAPIKey = l.str("LANGUAGETOOL_API_KEY", "")
+	if c.OpenAIAPIKey == "" && !c.MockLLM && !c.OfflineMode && c.ContentPacks == "" {
+		l.fail("OPENAI_API_KEY is required (or set MOCK_LLM=true, or OFFLINE_MODE=true or CONTENT_PACKS to run offline)")
+	}
+	c.GeminiAPIKey = l.str("GEMINI_API_KEY", "")
+	c.GeminiURL = l.url("GEMINI_URL", "https://generativelanguage.googleapis.com/v1beta/openai")
+	c.GeminiModel = l.str("GEMINI_MODEL", "gemini-2.0-flash")
+	c.LLMFallback = l.fallbackChain("LLM_FALLBACK", c)
+
+	c.GoogleClientID = l.str("GOOGLE_CLIENT_ID", "")
+	c.GoogleClientSecret = l.str("GOOGLE_CLIENT_SECRET", "")
+	c.GoogleRedirectURL = l.url("GOOGLE_REDIRECT_URL", "")
+	c.GoogleAdminID = l.str("GOOGLE_ADMIN_ID", "")
+	c.GoogleExtraScopes = strings.FieldsFunc(l.getenv("GOOGLE_EXTRA_SCOPES"), func(r rune) bool { return r == ',' || r == ' ' })
+	if set := countSet(c.GoogleClientID, c.GoogleClientSecret, c.GoogleRedirectURL); set > 0 && set < 3 {
+		l.fail("GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET and GOOGLE_REDIRECT_URL must be set together")
+	}
+
+	c.AppBaseURL = l.url("APP_BASE_URL", "http://localhost:8080")
+	c.SessionSecret = l.str("SESSION_SECRET", "")
+	for _, secret := range strings.Split(l.getenv("SESSION_SECRET_PREVIOUS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			c.SessionSecretPrevious = append(c.SessionSecretPrevious, secret)
+		}
+	}
+	c.SecretsEncryptionKey = l.str("SECRETS_ENCRYPTION_KEY", "")
+	if file := l.str("SECRETS_ENCRYPTION_KEY_FILE", ""); file != "" {
+		if c.SecretsEncryptionKey != "" {
+			l.fail("set SECRETS_ENCRYPTION_KEY or SECRETS_ENCRYPTION_KEY_FILE, not both")
+		} else if data, err := os.ReadFile(file); err != nil {
+			l.fail("SECRETS_ENCRYPTION_KEY_FILE can't be read: %v", err)
+		} else {
+			c.SecretsEncryptionKey = strings.TrimSpace(string(data))
+		}
+	}
+	for _, key := range strings.Split(l.getenv("SECRETS_ENCRYPTION_KEY_PREVIOUS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			c.SecretsEncryptionKeyPrevious = append(c.SecretsEncryptionKeyPrevious, key)
+		}
+	}
+	if c.SecretsEncryptionKey == "" && len(c.SecretsEncryptionKeyPrevious) > 0 {
+		l.fail("SECRETS_ENCRYPTION_KEY_PREVIOUS requires SECRETS_ENCRYPTION_KEY")
+	}
+	for _, key := range append([]string{c.SecretsEncryptionKey}, c.SecretsEncryptionKeyPrevious...) {
+		if decoded, err := base64.StdEncoding.DecodeString(key); key != "" && (err != nil || len(decoded) != 32) {
+			l.fail("secrets encryption keys must be 32 bytes, base64-encoded (e.g. openssl rand -base64 32)")
+			break
+		}
+	}
+	// Secure by default whenever the app is served over HTTPS
+	c.CookieSecure = l.bool("COOKIE_SECURE", strings.HasPrefix(c.AppBaseURL, "https://"))
+	c.CookieSameSite = strings.ToLower(l.str("COOKIE_SAMESITE", "lax"))
+	switch c.CookieSameSite {
+	case "lax", "strict":
+	case "none":
+		c.CookieSecure = true // Browsers reject SameSite=None cookies without Secure
+	default:
+		l.fail("COOKIE_SAMESITE must be lax, strict or none, got %q", c.CookieSameSite)
+	}
+
+	c.SMTPHost = l.str("SMTP_HOST", "")
+	c.SMTPPort = l.str("SMTP_PORT", "587")
+	c.SMTPUsername = l.str("SMTP_USERNAME", "")
+	c.SMTPPassword = l.str("SMTP_PASSWORD", "")
+	c.SMTPFrom = l.str("SMTP_FROM", c.SMTPUsername)
+	if port, err := strconv.Atoi(c.SMTPPort); err != nil || port < 1 || port > 65535 {
+		l.fail("SMTP_PORT must be a port number, got %q", c.SMTPPort)
+	}
+
+	c.VAPIDPublicKey = l.str("VAPID_PUBLIC_KEY", "")
+	c.VAPIDPrivateKey = l.str("VAPID_PRIVATE_KEY", "")
+	c.VAPIDSubject = l.str("VAPID_SUBJECT", c.SMTPFrom)
+	if countSet(c.VAPIDPublicKey, c.VAPIDPrivateKey) == 1 {
+		l.fail("VAPID_PUBLIC_KEY and VAPID_PRIVATE_KEY must be set together")
+	}
+
+	c.MarketplaceURL = l.url("MARKETPLACE_URL", "")
+	c.RedisURL = l.str("REDIS_URL", "")
+	if c.RedisURL != "" {
+		if _, err := redis.ParseURL(c.RedisURL); err != nil {
+			l.fail("REDIS_URL is invalid: %v", err)
+		}
+	}
+	c.RateLimits = make(map[string]string)
+	for name := range rateLimitPolicies {
+		key := "RATE_LIMIT_" + strings.ToUpper(name)
+		if value := l.str(key, ""); value != "" {
+			if _, err := parseRateLimitPolicy(value); err != nil {
+				l.fail("%s: %v", key, err)
+			}
+			c.RateLimits[name] = value
+		}
+	}
+
+	for _, value := range strings.Split(l.getenv("TRUSTED_PROXIES"), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		if _, err := parseTrustedProxy(value); err != nil {
+			l.fail("TRUSTED_PROXIES: %q is not an IP address or CIDR range", value)
+		}
+		c.TrustedProxies = append(c.TrustedProxies, value)
+	}
+
+	c.BackupS3Endpoint = l.url("BACKUP_S3_ENDPOINT", "https://s3.amazonaws.com")
+	c.BackupS3Bucket = l.str("BACKUP_S3_BUCKET", "")
+	c.BackupS3Region = l.str("BACKUP_S3_REGION", "us-east-1")
+	c.BackupS3AccessKey = l.str("BACKUP_S3_ACCESS_KEY", "")
+	c.BackupS3SecretKey = l.str("BACKUP_S3_SECRET_KEY", "")
+	c.BackupS3Prefix = l.str("BACKUP_S3_PREFIX", "backups/")
+	if c.BackupS3Bucket != "" && (c.BackupS3AccessKey == "" || c.BackupS3SecretKey == "") {
+		l.fail("BACKUP_S3_BUCKET requires BACKUP_S3_ACCESS_KEY and BACKUP_S3_SECRET_KEY")
+	}
+	c.BackupEncryptionKey = l.str("BACKUP_ENCRYPTION_KEY", "")
+	if c.BackupEncryptionKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(c.BackupEncryptionKey); err != nil || len(key) != 32 {
+			l.fail("BACKUP_ENCRYPTION_KEY must be 32 bytes, base64-encoded (e.g. openssl rand -base64 32)")
+		}
+	}
+	c.BackupSchedule = l.str("BACKUP_SCHEDULE", "")
+	if c.BackupSchedule != "" {
+		if _, err := parseCronSchedule(c.BackupSchedule); err != nil {
+			l.fail("BACKUP_SCHEDULE %q is invalid: %v", c.BackupSchedule, err)
+		}
+	}
+	c.BackupKeepDaily = l.int("BACKUP_KEEP_DAILY", 7, 0)
+	c.BackupKeepWeekly = l.int("BACKUP_KEEP_WEEKLY", 4, 0)
+
+	// Tracing is on when an OTLP endpoint is set; the exporter reads the other OTEL_* variables itself
+	c.OTLPEndpoint = l.url("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", l.url("OTEL_EXPORTER_OTLP_ENDPOINT", ""))
+	c.OTelServiceName = l.str("OTEL_SERVICE_NAME", "german-conjunctions-trainer")
+
+	c.ReadinessCheckOpenAI = l.bool("READINESS_CHECK_OPENAI", false)
+
+	c.DailyNewLimit = l.int("DAILY_NEW_LIMIT", 20, 0)
+	c.DailyReviewLimit = l.int("DAILY_REVIEW_LIMIT", 100, 0)
+
+	// Generation calls per user; 0 is unlimited (see generation_quotas.go)
+	c.UserDailyGenerations = l.int("USER_DAILY_GENERATIONS", 0, 0)
+	c.UserMonthlyGenerations = l.int("USER_MONTHLY_GENERATIONS", 0, 0)
+
+	c.PromptVersionsKeep = l.int("PROMPT_VERSIONS_KEEP", 10, 0)
+	c.ExerciseRetentionDays = l.int("EXERCISE_RETENTION_DAYS", 0, 0)
+	c.ExerciseRetireFlags = l.int("EXERCISE_RETIRE_FLAGS", 0, 0)
+	c.ExerciseRetireWrongRate = l.int("EXERCISE_RETIRE_WRONG_RATE", 0, 0)
+	if c.ExerciseRetireWrongRate > 100 {
+		l.fail("EXERCISE_RETIRE_WRONG_RATE is a percentage and must be at most 100, got %d", c.ExerciseRetireWrongRate)
+	}
+	c.ExerciseRetireMinLearners = l.int("EXERCISE_RETIRE_MIN_LEARNERS", 5, minQualityLearners)
+	c.ExerciseRetireReplace = l.bool("EXERCISE_RETIRE_REPLACE", false)
+	c.SlowQueryThreshold = configDuration(l.duration("SLOW_QUERY_THRESHOLD", 500*time.Millisecond))
+
+	return c, errors.Join(l.errs...)
+}
+
+func countSet(values ...string) int {
+	n := 0
+	for _, value := range values {
+		if value != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// initConfig loads the configuration and exits listing every problem if it is invalid.
+func initConfig() {
+	config, err := loadConfig(os.Getenv)
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	appConfig = config
+}
+
+// redacted returns a copy of the configuration that is safe to show: secrets that are set
+// are replaced by a placeholder, so admins can still see whether they are configured.
+func (c *Config) redacted() *Config {
+	r := *c
+	for _, secret := range []*string{
+		&r.AirtableToken, &r.OpenAIAPIKey, &r.GeminiAPIKey, &r.GoogleClientSecret, &r.SessionSecret, &r.SMTPPassword,
+		&r.VAPIDPrivateKey, &r.RedisURL, &r.BackupS3AccessKey, &r.BackupS3SecretKey, &r.BackupEncryptionKey,
+		&r.SecretsEncryptionKey, &r.STTAPIKey, &r.LanguageToolAPIKey,
+	} {
+		if *secret != "" {
+			*secret = "[redacted]"
+		}
+	}
+	r.SessionSecretPrevious = nil
+	for range c.SessionSecretPrevious {
+		r.SessionSecretPrevious = append(r.SessionSecretPrevious, "[redacted]")
+	}
+	r.SecretsEncryptionKeyPrevious = nil
+	for range c.SecretsEncryptionKeyPrevious {
+		r.SecretsEncryptionKeyPrevious = append(r.SecretsEncryptionKeyPrevious, "[redacted]")
+	}
+	return &r
+}
+
+// Handle the configuration view (admin): GET /api/admin/config
+func handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(appConfig.redacted())
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Content packs are curated, human-written exercises loaded straight into the exercise
+// cache, without the model, for deployments that want vetted content or run offline. A pack
+// is a JSON or YAML file:
+//
+//	name: Vetted B1 conjunctions
+//	topics:
+//	  - topic: Conjunctions   # created if missing, which needs a prompt
+//	    level: B1             # default B1
+//	    theme: travel         # optional
+//	    difficulty: normal    # optional
+//	    exercises:
+//	      - correct_german_sentence: Wir bleiben zu Hause, weil es regnet.
+//	        english_hint: We are staying at home because it is raining.
+//	        conjunction_topic: weil
+//
+// Exercises are cached under the topic's current prompt and the level, the key generation
+// uses, so they are served like generated ones. Sentences already cached are skipped, so
+// loading a pack again adds only what is new. CONTENT_PACKS names a pack file or a directory
+// of them, loaded at startup; POST /api/admin/content-packs loads an uploaded one.
+const maxContentPackSize = 10 << 20
+
+// ContentPack is a file of curated exercises, grouped by topic.
+type ContentPack struct {
+	Name   string             `json:"name" yaml:"name"`
+	Topics []ContentPackTopic `json:"topics" yaml:"topics"`
+}
+
+// ContentPackTopic is the exercises of a pack for one topic, level, theme and difficulty.
+type ContentPackTopic struct {
+	Topic      string           `json:"topic" yaml:"topic"`
+	Prompt     string           `json:"prompt,omitempty" yaml:"prompt"` // only used to create a missing topic
+	Level      string           `json:"level,omitempty" yaml:"level"`
+	Theme      string           `json:"theme,omitempty" yaml:"theme"`
+	Difficulty string           `json:"difficulty,omitempty" yaml:"difficulty"`
+	Exercises  []map[string]any `json:"exercises" yaml:"exercises"`
+}
+
+// ContentPackReport is what loading a pack did, in total and per topic.
+type ContentPackReport struct {
+	Pack       string                    `json:"pack"`
+	Added      int                       `json:"added"`
+	Duplicates int                       `json:"duplicates"`
+	Invalid    int                       `json:"invalid"`
+	Topics     []*ContentPackTopicReport `json:"topics"`
+}
+
+type ContentPackTopicReport struct {
+	Topic      string   `json:"topic"`
+	TopicID    string   `json:"topic_id,omitempty"`
+	Created    bool     `json:"created,omitempty"`
+	Added      int      `json:"added"`
+	Duplicates int      `json:"duplicates"`
+	Invalid    int      `json:"invalid"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+// decodeContentPack reads a pack from JSON, or from YAML if it isn't a JSON object.
+func decodeContentPack(data []byte) (*ContentPack, error) {
+	var pack ContentPack
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		if err := json.Unmarshal(data, &pack); err != nil {
+			return nil, fmt.Errorf("invalid content pack JSON: %v", err)
+		}
+	} else if err := yaml.Unmarshal(data, &pack); err != nil {
+		return nil, fmt.Errorf("invalid content pack YAML: %v", err)
+	}
+
+	if len(pack.Topics) == 0 {
+		return nil, fmt.Errorf("content pack has no topics")
+	}
+	for i, section := range pack.Topics {
+		if strings.TrimSpace(section.Topic) == "" {
+			return nil, fmt.Errorf("topic %d of the content pack has no name", i+1)
+		}
+		if _, err := validateDifficulty(section.Difficulty); err != nil {
+			return nil, fmt.Errorf("topic %q of the content pack: %v", section.Topic, err)
+		}
+	}
+	return &pack, nil
+}
+
+// loadContentPack adds a pack's exercises to the cache of the request's tenant's topics.
+func loadContentPack(ctx context.Context, pack *ContentPack) (*ContentPackReport, error) {
+	topics, err := tenantTopics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*Topic)
+	for _, topic := range topics {
+		if existing, ok := byName[strings.ToLower(topic.Name)]; !ok || existing.Archived {
+			byName[strings.ToLower(topic.Name)] = topic
+		}
+	}
+
+	report := &ContentPackReport{Pack: pack.Name, Topics: []*ContentPackTopicReport{}}
+	for _, section := range pack.Topics {
+		name := strings.TrimSpace(section.Topic)
+		topicReport := &ContentPackTopicReport{Topic: name}
+		report.Topics = append(report.Topics, topicReport)
+
+		topic := byName[strings.ToLower(name)]
+		if topic == nil {
+			if strings.TrimSpace(section.Prompt) == "" {
+				topicReport.Errors = append(topicReport.Errors, "topic does not exist, and the pack gives no prompt to create it")
+				topicReport.Invalid = len(section.Exercises)
+				report.Invalid += topicReport.Invalid
+				continue
+			}
+			if topic, err = createTopic(name, section.Prompt, tenantIDFromContext(ctx)); err != nil {
+				return report, err
+			}
+			byName[strings.ToLower(name)] = topic
+			topicReport.Created = true
+		}
+		topicReport.TopicID = topic.ID
+
+		level := strings.TrimSpace(section.Level)
+		if level == "" {
+			level = defaultLevel
+		}
+		difficulty, _ := validateDifficulty(section.Difficulty)
+		promptHash := getCacheHash(topic.Prompt, PromptVars{Level: level})
+
+		cached, err := dataStore.GetExercisesForTopic(topic.ID, promptHash)
+		if err != nil {
+			return report, err
+		}
+		seen := make(map[string]bool)
+		for _, ex := range cached {
+			seen[ex.content().dedupKey()] = true
+		}
+
+		for i, fields := range section.Exercises {
+			exJSON, err := json.Marshal(fields)
+			if err != nil {
+				topicReport.Invalid++
+				topicReport.Errors = append(topicReport.Errors, fmt.Sprintf("exercise %d: %v", i+1, err))
+				continue
+			}
+			content, err := parseExerciseContent(string(exJSON))
+			if err != nil {
+				topicReport.Invalid++
+				topicReport.Errors = append(topicReport.Errors, fmt.Sprintf("exercise %d: %v", i+1, err))
+				continue
+			}
+			if seen[content.dedupKey()] {
+				topicReport.Duplicates++
+				continue
+			}
+			seen[content.dedupKey()] = true
+
+			if _, err := dataStore.CreateExercise(topic.ID, promptHash, strings.TrimSpace(section.Theme), withDifficulty(string(exJSON), difficulty)); err != nil {
+				return report, err
+			}
+			topicReport.Added++
+		}
+		report.Added += topicReport.Added
+		report.Duplicates += topicReport.Duplicates
+		report.Invalid += topicReport.Invalid
+	}
+	return report, nil
+}
+
+// contentPackFiles returns the pack files at path: the file itself, or the *.json, *.yaml
+// and *.yml files of a directory in name order.
+func contentPackFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	for _, pattern := range []string{"*.json", "*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(path, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// initContentPacks loads the packs in CONTENT_PACKS into the instance's topics. Packs that
+// don't parse stop the startup; individual exercises that are invalid are only logged.
+func initContentPacks() {
+	if appConfig.ContentPacks == "" {
+		return
+	}
+	files, err := contentPackFiles(appConfig.ContentPacks)
+	if err != nil {
+		log.Fatalf("Failed to read CONTENT_PACKS: %v", err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("Failed to read content pack %s: %v", file, err)
+		}
+		pack, err := decodeContentPack(data)
+		if err != nil {
+			log.Fatalf("Failed to load content pack %s: %v", file, err)
+		}
+		report, err := loadContentPack(context.Background(), pack)
+		if err != nil {
+			log.Printf("Warning: failed to load content pack %s: %v", file, err)
+			continue
+		}
+		log.Printf("Content pack %s: %d exercises added, %d already cached, %d invalid", file, report.Added, report.Duplicates, report.Invalid)
+		for _, topic := range report.Topics {
+			for _, message := range topic.Errors {
+				log.Printf("Warning: content pack %s, topic %q: %s", file, topic.Topic, message)
+			}
+		}
+	}
+}
+
+// Handle content pack upload (admin): POST /api/admin/content-packs with a JSON or YAML pack,
+// either as the request body or as the "file" field of a multipart form.
+func handleAdminContentPacks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxContentPackSize)
+
+	body := r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, "Missing content pack file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		writeError(w, "Failed to read content pack", http.StatusBadRequest)
+		return
+	}
+	pack, err := decodeContentPack(data)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := loadContentPack(r.Context(), pack)
+	if report != nil {
+		recordAudit(r, auditContentPackLoad, "content_pack", pack.Name, nil, report)
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, APIError{Code: "internal_error", Message: fmt.Sprintf("Failed to load content pack: %v", err), Details: report})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Conversation practice: the model role-plays a short German conversation with the learner,
+// about a topic and optional theme, at their level. Each reply of the learner comes back
+// with a correction when it has mistakes, so grammar is practised in free writing, not just
+// in word ordering. A conversation ends after maxConversationTurns replies of the learner;
+// conversations are kept per user in the Conversations table, with their turns.
+const (
+	maxConversationTurns   = 10
+	maxConversationMessage = 500
+)
+
+// conversationPrompt starts the instructions of every conversation; the mock LLM recognizes
+// conversations by this.
+const conversationPrompt = "You are a friendly conversation partner for a German learner, chatting in German."
+
+const conversationInstructions = `
+
+The learner's level is %s (CEFR). Keep the conversation on %s, and use the grammar of the topic %q naturally where it fits. Write at the learner's level: each reply is one to three short sentences and asks something back, so the learner has to answer.
+
+Reply with a JSON object {"correction": {"corrected": "...", "explanation": "..."}, "reply": "..."}. If the learner's last message has grammar or spelling mistakes, "corrected" is the whole message, fixed, and "explanation" explains the mistakes in one or two English sentences. Set "correction" to null if there is nothing to correct, and in your opening message. "reply" continues the conversation in German.`
+
+const (
+	conversationOpening = "(Open the conversation with a greeting and a question.)"
+	conversationClosing = " This is the learner's last message: answer it and say goodbye, without asking anything back."
+)
+
+// Conversation is a learner's role-play conversation, with all its turns.
+type Conversation struct {
+	ID        string              `json:"id"`
+	OwnerID   string              `json:"owner_id"`
+	TopicID   string              `json:"topic_id"`
+	TopicName string              `json:"topic_name"`
+	Level     string              `json:"level"`
+	Theme     string              `json:"theme,omitempty"`
+	Turns     []*ConversationTurn `json:"turns"`
+	Finished  bool                `json:"finished"`
+	CreatedAt time.Time           `json:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// ConversationTurn is a message of the model ("assistant") or of the learner ("user").
+type ConversationTurn struct {
+	Role       string                  `json:"role"`
+	Text       string                  `json:"text"`
+	Correction *ConversationCorrection `json:"correction,omitempty"` // of a learner's message with mistakes
+	CreatedAt  time.Time               `json:"created_at"`
+}
+
+// ConversationCorrection is a learner's message, fixed, and what was wrong with it.
+type ConversationCorrection struct {
+	Corrected   string `json:"corrected"`
+	Explanation string `json:"explanation"`
+}
+
+var (
+	conversationsMutex  sync.Mutex
+	memoryConversations = make(map[string]*Conversation) // with in-memory storage
+)
+
+// Conversations waiting for the model's reply, so a learner can't send two messages at once.
+var replyingConversations sync.Map
+
+// learnerTurns returns the number of the learner's messages.
+func (c *Conversation) learnerTurns() int {
+	n := 0
+	for _, turn := range c.Turns {
+		if turn.Role == "user" {
+			n++
+		}
+	}
+	return n
+}
+
+func conversationFromRecord(record *airtable.Record) *Conversation {
+	conversation := &Conversation{ID: record.ID, Turns: []*ConversationTurn{}}
+	if val, ok := record.Fields["OwnerID"].(string); ok {
+		conversation.OwnerID = val
+	}
+	if val, ok := record.Fields["TopicID"].(string); ok {
+		conversation.TopicID = val
+	}
+	if val, ok := record.Fields["TopicName"].(string); ok {
+		conversation.TopicName = val
+	}
+	if val, ok := record.Fields["Level"].(string); ok {
+		conversation.Level = val
+	}
+	if val, ok := record.Fields["Theme"].(string); ok {
+		conversation.Theme = val
+	}
+	if val, ok := record.Fields["Turns"].(string); ok {
+		if err := json.Unmarshal([]byte(val), &conversation.Turns); err != nil {
+			log.Printf("Warning: invalid turns in conversation %s: %v", record.ID, err)
+		}
+	}
+	if val, ok := record.Fields["Finished"].(bool); ok {
+		conversation.Finished = val
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			conversation.CreatedAt = t
+		}
+	}
+	if val, ok := record.Fields["UpdatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			conversation.UpdatedAt = t
+		}
+	}
+	return conversation
+}
+
+// copyConversation copies a conversation and its turns, so stored ones are not shared.
+func copyConversation(conversation *Conversation) *Conversation {
+	c := *conversation
+	c.Turns = make([]*ConversationTurn, len(conversation.Turns))
+	for i, turn := range conversation.Turns {
+		t := *turn
+		c.Turns[i] = &t
+	}
+	return &c
+}
+
+// getConversations returns the owner's conversations, most recently active first.
+func getConversations(ownerID string) ([]*Conversation, error) {
+	conversations := []*Conversation{}
+	if airtableBaseID == "" {
+		conversationsMutex.Lock()
+		for _, conversation := range memoryConversations {
+			if conversation.OwnerID == ownerID {
+				conversations = append(conversations, copyConversation(conversation))
+			}
+		}
+		conversationsMutex.Unlock()
+	} else {
+		records, err := getAllRecords(airtableClient.GetTable(airtableBaseID, conversationsTableName).GetRecords().
+			WithFilterFormula(fmt.Sprintf("{OwnerID} = '%s'", ownerID)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get conversations from Airtable: %v", err)
+		}
+		for _, record := range records.Records {
+			conversations = append(conversations, conversationFromRecord(record))
+		}
+	}
+	slices.SortFunc(conversations, func(a, b *Conversation) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+	return conversations, nil
+}
+
+func getConversation(id string) (*Conversation, error) {
+	if airtableBaseID == "" {
+		conversationsMutex.Lock()
+		defer conversationsMutex.Unlock()
+		if conversation, ok := memoryConversations[id]; ok {
+			return copyConversation(conversation), nil
+		}
+		return nil, fmt.Errorf("conversation %s not found", id)
+	}
+
+	record, err := airtableClient.GetTable(airtableBaseID, conversationsTableName).GetRecord(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation from Airtable: %v", err)
+	}
+	return conversationFromRecord(record), nil
+}
+
+// saveConversation creates a conversation, or updates it if it has an ID.
+func saveConversation(conversation *Conversation) error {
+	if airtableBaseID == "" {
+		conversationsMutex.Lock()
+		defer conversationsMutex.Unlock()
+		if conversation.ID == "" {
+			conversation.ID = fmt.Sprintf("conversation%d", time.Now().UnixNano())
+		}
+		memoryConversations[conversation.ID] = copyConversation(conversation)
+		return nil
+	}
+
+	turns, err := json.Marshal(conversation.Turns)
+	if err != nil {
+		return fmt.Errorf("failed to encode conversation turns: %v", err)
+	}
+	fields := map[string]any{
+		"OwnerID":   conversation.OwnerID,
+		"TopicID":   conversation.TopicID,
+		"TopicName": conversation.TopicName,
+		"Level":     conversation.Level,
+		"Theme":     conversation.Theme,
+		"Turns":     string(turns),
+		"Finished":  conversation.Finished,
+		"CreatedAt": conversation.CreatedAt.Format(time.RFC3339),
+		"UpdatedAt": conversation.UpdatedAt.Format(time.RFC3339),
+	}
+	table := airtableClient.GetTable(airtableBaseID, conversationsTableName)
+	records := &airtable.Records{Records: []*airtable.Record{{ID: conversation.ID, Fields: fields}}}
+	if conversation.ID != "" {
+		if _, err := table.UpdateRecordsPartial(records); err != nil {
+			return fmt.Errorf("failed to update conversation in Airtable: %v", err)
+		}
+		return nil
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return fmt.Errorf("failed to create conversation in Airtable: %v", err)
+	}
+	if len(result.Records) > 0 {
+		conversation.ID = result.Records[0].ID
+	}
+	return nil
+}
+
+func deleteConversation(id string) error {
+	if airtableBaseID == "" {
+		conversationsMutex.Lock()
+		delete(memoryConversations, id)
+		conversationsMutex.Unlock()
+		return nil
+	}
+	if _, err := airtableClient.GetTable(airtableBaseID, conversationsTableName).DeleteRecords([]string{id}); err != nil {
+		return fmt.Errorf("failed to delete conversation from Airtable: %v", err)
+	}
+	return nil
+}
+
+// converse asks the model for its next turn: the opening message of a new conversation, or
+// the correction of the learner's last message and a reply to it. Each turn counts against
+// the quota of the conversation's owner.
+func converse(ctx context.Context, conversation *Conversation) (turn *ConversationTurn, correction *ConversationCorrection, err error) {
+	if offlineMode() {
+		return nil, nil, errOfflineMode
+	}
+	if err := useModelQuota(ctx, conversation.OwnerID); err != nil {
+		return nil, nil, err
+	}
+	llm := llmProviderFor(ctx)
+	ctx, end := startSpan(ctx, "converse", attribute.String("conversation.id", conversation.ID), attribute.String("llm.model", llm.Model))
+	defer func() { end(err) }()
+
+	subject := "everyday life"
+	if conversation.Theme != "" {
+		subject = fmt.Sprintf("the theme %q", conversation.Theme)
+	}
+	instructions := conversationPrompt + fmt.Sprintf(conversationInstructions, conversation.Level, subject, conversation.TopicName)
+	if conversation.learnerTurns() >= maxConversationTurns {
+		instructions += conversationClosing
+	}
+	messages := []Message{{Role: "system", Content: instructions}}
+	for _, turn := range conversation.Turns {
+		messages = append(messages, Message{Role: turn.Role, Content: turn.Text})
+	}
+	if len(conversation.Turns) == 0 {
+		messages = append(messages, Message{Role: "user", Content: conversationOpening})
+	}
+
+	content, _, err := complete(ctx, llm, OpenAIRequest{Messages: messages, ResponseFormat: &ResponseFormat{Type: "json_object"}})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get conversation reply: %w", err)
+	}
+	var output struct {
+		Correction *ConversationCorrection `json:"correction"`
+		Reply      string                  `json:"reply"`
+	}
+	if err := json.Unmarshal([]byte(content), &output); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse conversation reply: %w", err)
+	}
+	if strings.TrimSpace(output.Reply) == "" {
+		return nil, nil, fmt.Errorf("received an empty conversation reply")
+	}
+	if output.Correction != nil {
+		output.Correction.Corrected = strings.TrimSpace(output.Correction.Corrected)
+		output.Correction.Explanation = strings.TrimSpace(output.Correction.Explanation)
+		if output.Correction.Corrected == "" {
+			output.Correction = nil
+		}
+	}
+	return &ConversationTurn{Role: "assistant", Text: strings.TrimSpace(output.Reply), CreatedAt: time.Now().UTC()}, output.Correction, nil
+}
+
+// writeConversationError answers for a failed model call.
+func writeConversationError(w http.ResponseWriter, id string, err error) {
+	var statusErr *statusError
+	if errors.Is(err, errOfflineMode) {
+		writeOfflineError(w)
+		return
+	}
+	if errors.As(err, &statusErr) {
+		writeStatusError(w, err)
+		return
+	}
+	log.Printf("Error in conversation %s: %v", id, err)
+	writeError(w, "Failed to get a reply", http.StatusBadGateway)
+}
+
+// Handle conversations, for logged-in users:
+// GET /api/conversations lists the user's conversations, most recently active first.
+// POST /api/conversations with {"topic_id": "rec...", "level": "B1", "theme": "travel"}
+// starts one; the model opens it.
+// GET /api/conversations/{id} returns one, and DELETE deletes it.
+// POST /api/conversations/{id}/turns with {"text": "..."} sends the learner's message and
+// returns the conversation with the correction and the model's reply.
+func handleConversations(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/conversations"), "/"), "/")
+
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			conversations, err := getConversations(userID)
+			if err != nil {
+				writeError(w, fmt.Sprintf("Failed to get conversations: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"conversations": conversations})
+		case http.MethodPost:
+			rateLimited("conversation", func(w http.ResponseWriter, r *http.Request) {
+				startConversation(w, r, userID)
+			})(w, r)
+		default:
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	conversation, err := getConversation(id)
+	if err != nil || conversation.OwnerID != userID {
+		writeError(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conversation)
+
+	case action == "" && r.Method == http.MethodDelete:
+		if err := deleteConversation(id); err != nil {
+			writeError(w, fmt.Sprintf("Failed to delete conversation: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case action == "turns" && r.Method == http.MethodPost:
+		rateLimited("conversation", func(w http.ResponseWriter, r *http.Request) {
+			replyInConversation(w, r, conversation)
+		})(w, r)
+
+	case action == "" || action == "turns":
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		writeError(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// startConversation starts a conversation on a topic of the request's tenant.
+func startConversation(w http.ResponseWriter, r *http.Request, userID string) {
+	var req struct {
+		TopicID string `json:"topic_id"`
+		Level   string `json:"level"`
+		Theme   string `json:"theme"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	topic, err := dataStore.GetTopic(strings.TrimSpace(req.TopicID))
+	if err != nil || !topicInTenant(r.Context(), topic) {
+		writeError(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+	level := strings.ToUpper(strings.TrimSpace(req.Level))
+	if level == "" {
+		level = defaultLevel
+	}
+	if !slices.Contains(retentionLevels, level) {
+		writeError(w, "level must be one of A1, A2, B1, B2, C1 or C2", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	conversation := &Conversation{
+		OwnerID:   userID,
+		TopicID:   topic.ID,
+		TopicName: topic.Name,
+		Level:     level,
+		Theme:     strings.TrimSpace(req.Theme),
+		Turns:     []*ConversationTurn{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	opening, _, err := converse(r.Context(), conversation)
+	if err != nil {
+		writeConversationError(w, "for topic "+topic.ID, err)
+		return
+	}
+	conversation.Turns = append(conversation.Turns, opening)
+	if err := saveConversation(conversation); err != nil {
+		writeError(w, fmt.Sprintf("Failed to save conversation: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// replyInConversation adds the learner's message and the model's reply to a conversation.
+func replyInConversation(w http.ResponseWriter, r *http.Request, conversation *Conversation) {
+	var req struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		writeError(w, "text is required", http.StatusBadRequest)
+		return
+	}
+	if len([]rune(text)) > maxConversationMessage {
+		writeError(w, fmt.Sprintf("text must be at most %d characters", maxConversationMessage), http.StatusBadRequest)
+		return
+	}
+	if _, busy := replyingConversations.LoadOrStore(conversation.ID, true); busy {
+		writeError(w, "The previous message is still being answered", http.StatusConflict)
+		return
+	}
+	defer replyingConversations.Delete(conversation.ID)
+	// Read it again, in case a reply was saved since the request began
+	conversation, err := getConversation(conversation.ID)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get conversation: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if conversation.Finished {
+		writeError(w, "The conversation is over; start a new one", http.StatusConflict)
+		return
+	}
+
+	turn := &ConversationTurn{Role: "user", Text: text, CreatedAt: time.Now().UTC()}
+	conversation.Turns = append(conversation.Turns, turn)
+	reply, correction, err := converse(r.Context(), conversation)
+	if err != nil {
+		writeConversationError(w, conversation.ID, err)
+		return
+	}
+	turn.Correction = correction
+	conversation.Turns = append(conversation.Turns, reply)
+	conversation.Finished = conversation.learnerTurns() >= maxConversationTurns
+	conversation.UpdatedAt = reply.CreatedAt
+	if err := saveConversation(conversation); err != nil {
+		writeError(w, fmt.Sprintf("Failed to save conversation: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	mrand "math/rand"
+	"time"
+)
+
+const maxDailyLimit = 1000
+
+// DailyLimits caps how many new exercises and reviews are served per UTC day, so learners
+// aren't buried under a growing review pile. A new exercise is one the learner has never
+// answered; any other exercise served is a review. Users can set their own limits in their
+// profile; guests and users without one get DAILY_NEW_LIMIT and DAILY_REVIEW_LIMIT.
+type DailyLimits struct {
+	NewLimit         int `json:"new_limit"`
+	ReviewLimit      int `json:"review_limit"`
+	NewRemaining     int `json:"new_remaining"`
+	ReviewsRemaining int `json:"reviews_remaining"`
+}
+
+// getDailyLimits returns the owner's limits and what is left of them today. Today's usage is
+// read from the views, which are recorded when an exercise is answered: an exercise first
+// answered today has a view created today, and one reviewed today an older view. This is
+// exact because selectExercises never serves an exercise already answered today.
+func getDailyLimits(user *User, views map[string]*UserExerciseView, now time.Time) DailyLimits {
+	limits := DailyLimits{NewLimit: appConfig.DailyNewLimit, ReviewLimit: appConfig.DailyReviewLimit}
+	if user != nil && user.DailyNewLimit != nil {
+		limits.NewLimit = *user.DailyNewLimit
+	}
+	if user != nil && user.DailyReviewLimit != nil {
+		limits.ReviewLimit = *user.DailyReviewLimit
+	}
+
+	newToday, reviewsToday := 0, 0
+	for _, view := range views {
+		if !viewedToday(view, now) {
+			continue
+		}
+		if firstViewedToday(view, now) {
+			newToday++
+		} else {
+			reviewsToday++
+		}
+	}
+	limits.NewRemaining = max(0, limits.NewLimit-newToday)
+	limits.ReviewsRemaining = max(0, limits.ReviewLimit-reviewsToday)
+	return limits
+}
+
+func viewedToday(view *UserExerciseView, now time.Time) bool {
+	return !view.LastViewed.Before(now.UTC().Truncate(24 * time.Hour))
+}
+
+// firstViewedToday reports whether the view was created today. A view that hasn't been
+// stored yet was just created.
+func firstViewedToday(view *UserExerciseView, now time.Time) bool {
+	return view.CreatedAt.IsZero() || !view.CreatedAt.Before(now.UTC().Truncate(24*time.Hour))
+}
+
+// splitNewExercises separates exercises the owner has never answered from those they could
+// review, leaving out those already answered today.
+func splitNewExercises(exercises []*Exercise, views map[string]*UserExerciseView, now time.Time) (unseen, seen []*Exercise) {
+	for _, ex := range exercises {
+		view, ok := views[ex.AirtableID]
+		if !ok {
+			unseen = append(unseen, ex)
+		} else if !viewedToday(view, now) {
+			seen = append(seen, ex)
+		}
+	}
+	return unseen, seen
+}
+
+// selectExercises picks up to count of the eligible exercises within the day's limits:
+// due reviews first, then new exercises. When both run short, the rest are the seen
+// exercises due soonest, still within the review limit. The result goes from easiest to
+// hardest (see difficulty.go).
+func selectExercises(allExercises, eligible []*Exercise, views map[string]*UserExerciseView, count int, limits DailyLimits, now time.Time) []*Exercise {
+	unseen, due := splitNewExercises(eligible, views, now)
+
+	selected := getRandomExercises(due, min(count, limits.ReviewsRemaining))
+	reviews := len(selected)
+	selected = append(selected, getRandomExercises(unseen, min(count-len(selected), limits.NewRemaining))...)
+
+	if short := min(count-len(selected), limits.ReviewsRemaining-reviews); short > 0 {
+		// Not enough are due: top up with those due soonest rather than the same random ones
+		_, seen := splitNewExercises(allExercises, views, now)
+		selected = append(selected, getSoonestDueExercises(seen, selected, views, short)...)
+	}
+
+	// Shuffled first, so exercises of the same difficulty come in random order
+	mrand.Shuffle(len(selected), func(i, j int) {
+		selected[i], selected[j] = selected[j], selected[i]
+	})
+	orderByDifficulty(selected)
+	return selected
+}
+
+// newExercisesWanted is how many new exercises a request could use today, so generation
+// is skipped once the new-exercise limit is reached.
+func newExercisesWanted(eligible []*Exercise, views map[string]*UserExerciseView, count int, limits DailyLimits, now time.Time) int {
+	_, due := splitNewExercises(eligible, views, now)
+	return min(count-min(len(due), limits.ReviewsRemaining, count), limits.NewRemaining)
+}
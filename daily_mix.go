@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// The daily mix is a set of due reviews from every topic the learner has answered exercises
+// of, instead of one topic at a time. Reviews are taken from the topics in turn, each topic's
+// most overdue first, starting with the topic whose review is most overdue, so consecutive
+// exercises practise different grammar. Mixing only reviews, it generates nothing and serves
+// no new exercises. Served exercises carry their topic_id.
+
+// MixRequest is the body of POST /api/exercises/mix.
+type MixRequest struct {
+	Count int `json:"count,omitempty"` // 5 to 30, default 10
+}
+
+// interleaveByTopic orders due exercises for a mix: one from each topic in turn, each
+// topic's most overdue first, topics in order of their most overdue exercise.
+func interleaveByTopic(exercises []*Exercise, views map[string]*UserExerciseView) []*Exercise {
+	sorted := append([]*Exercise{}, exercises...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return nextReviewAt(views[sorted[i].AirtableID]).Before(nextReviewAt(views[sorted[j].AirtableID]))
+	})
+	var topicOrder []string
+	byTopic := make(map[string][]*Exercise)
+	for _, ex := range sorted {
+		if byTopic[ex.TopicID] == nil {
+			topicOrder = append(topicOrder, ex.TopicID)
+		}
+		byTopic[ex.TopicID] = append(byTopic[ex.TopicID], ex)
+	}
+
+	interleaved := make([]*Exercise, 0, len(sorted))
+	for round := 0; len(interleaved) < len(sorted); round++ {
+		for _, topicID := range topicOrder {
+			if round < len(byTopic[topicID]) {
+				interleaved = append(interleaved, byTopic[topicID][round])
+			}
+		}
+	}
+	return interleaved
+}
+
+// withTopicID adds the topic an exercise belongs to to its served JSON. Malformed JSON is
+// returned as it is.
+func withTopicID(raw json.RawMessage, topicID string) json.RawMessage {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return raw
+	}
+	fields["topic_id"], _ = json.Marshal(topicID)
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return raw
+	}
+	return data
+}
+
+// serveDailyMix picks up to count due reviews across the owner's active topics, within the
+// day's review limit, and starts them as the owner's current session.
+func serveDailyMix(ctx context.Context, count int, userID, ownerID string) ([]json.RawMessage, DailyLimits, error) {
+	var user *User
+	if userID != "" {
+		var err error
+		if user, err = dataStore.GetUserByID(userID); err != nil {
+			log.Printf("Warning: failed to get user %s for daily limits: %v", userID, err)
+		}
+	}
+	topics, err := getActiveTopics(ctx)
+	if err != nil {
+		return nil, DailyLimits{}, fmt.Errorf("Failed to get topics: %v", err)
+	}
+	active := make(map[string]bool)
+	for _, topic := range topics {
+		active[topic.ID] = true
+	}
+	exercises, err := dataStore.ListExercises("")
+	if err != nil {
+		return nil, DailyLimits{}, fmt.Errorf("Failed to get exercises: %v", err)
+	}
+	views, err := dataStore.GetUserExerciseViews(ownerID)
+	if err != nil {
+		return nil, DailyLimits{}, fmt.Errorf("Failed to get user views: %v", err)
+	}
+	applyHintBoost(ownerID, views)
+
+	now := time.Now()
+	limits := getDailyLimits(user, views, now)
+	var due []*Exercise
+	for _, ex := range withoutRetired(exercises) {
+		if view, ok := views[ex.AirtableID]; ok && active[ex.TopicID] && !viewedToday(view, now) && isDueForReview(view, now) {
+			due = append(due, ex)
+		}
+	}
+	selected := interleaveByTopic(due, views)
+	selected = selected[:min(len(selected), count, limits.ReviewsRemaining)]
+
+	recordEvent(AnalyticsEvent{Type: eventExercisesServed, UserID: ownerID, Count: len(selected), CacheHit: true})
+
+	var hints map[string]string
+	if user != nil && user.NativeLanguage != "" && len(selected) > 0 {
+		hints = nativeHints(ctx, user, selected)
+	}
+	difficulty, _ := requestDifficulty("", user)
+	responseExercises := []json.RawMessage{}
+	for _, ex := range selected {
+		responseExercises = append(responseExercises, withTopicID(servedExercise(ex, user, hints, difficulty), ex.TopicID))
+	}
+	// The set has no single topic, so the session's topic is left empty
+	if len(responseExercises) > 0 {
+		if err := startCurrentSession(ownerID, "", responseExercises, false); err != nil {
+			log.Printf("Warning: failed to save current session: %v", err)
+		}
+	}
+	return responseExercises, limits, nil
+}
+
+// Handle the daily mix: POST /api/exercises/mix with {"count": 10} returns due reviews from
+// all the owner's topics, interleaved, like POST /api/exercises does for one topic. Works for
+// guests too.
+func handleDailyMix(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req MixRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	count := promptVarsFromRequest(GenerateRequest{Count: req.Count}).Count
+
+	exercises, limits, err := serveDailyMix(r.Context(), count, getUserIDFromRequest(r), getProgressOwnerID(w, r))
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"exercises": exercisesForClient(r, exercises),
+		"limits":    limits,
+	})
+}
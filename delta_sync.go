@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// GET /api/sync keeps a mobile client's copy of the topics, the cached exercises and the
+// user's SRS state up to date by sending only what changed since its last sync. Each
+// response carries a sync_token, which the next sync sends back; without one (a first sync,
+// or after a reset) everything is sent. Large syncs come in pages: has_more asks the client
+// to call again with the new token right away. Changes are found by time:
+//   - topics, when their list changed at all, are sent complete, and replace the client's;
+//   - exercises cached or edited since the last sync, and every exercise of a topic whose
+//     prompt changed, since that replaces its exercises;
+//   - the user's exercise views (SRS state) answered since the last sync;
+//   - deleted exercises, from the DeletedExercises table, which keeps them syncDeletionsKeep.
+//
+// Only exercises for a topic's current prompt are sent. Clients drop exercises whose
+// prompt_hash isn't one of their topic's prompt_hashes, and those of topics that are gone.
+// A token older than syncDeletionsKeep, or any sync while deleted exercises can't be read,
+// gets everything again with reset set, and the client replaces what it has.
+const (
+	defaultSyncLimit  = 500
+	maxSyncLimit      = 2000
+	syncOverlap       = time.Minute // changes are read again from a bit before the last sync, for clock skew and caches
+	syncDeletionsKeep = 90 * 24 * time.Hour
+)
+
+// syncCursor is what a sync token encodes.
+type syncCursor struct {
+	Since  time.Time `json:"s,omitempty"` // zero for a full sync
+	Until  time.Time `json:"u,omitempty"` // end of the sync in progress, set while paging
+	After  string    `json:"a,omitempty"` // key of the last change sent, set while paging
+	Topics string    `json:"t,omitempty"` // hash of the topics the client has
+}
+
+func encodeSyncToken(cursor syncCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSyncToken(token string) (syncCursor, error) {
+	var cursor syncCursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || json.Unmarshal(data, &cursor) != nil {
+		return cursor, fmt.Errorf("invalid sync token")
+	}
+	return cursor, nil
+}
+
+// SyncTopic is a topic with the prompt hashes its exercises are cached under, one per level.
+type SyncTopic struct {
+	*Topic
+	PromptHashes []string `json:"prompt_hashes"`
+}
+
+type SyncCachedExercise struct {
+	ID         string          `json:"id"`
+	TopicID    string          `json:"topic_id"`
+	PromptHash string          `json:"prompt_hash"`
+	Theme      string          `json:"theme,omitempty"`
+	Exercise   json.RawMessage `json:"exercise"`
+}
+
+// SyncReview is the user's SRS state for an exercise.
+type SyncReview struct {
+	ExerciseID        string    `json:"exercise_id"`
+	RepetitionCounter int       `json:"repetition_counter"`
+	Grade             string    `json:"grade,omitempty"`
+	LastViewed        time.Time `json:"last_viewed"`
+	NextReview        time.Time `json:"next_review"`
+}
+
+// DeltaSync is what GET /api/sync returns. Topics is only set when the topics changed.
+type DeltaSync struct {
+	SyncToken        string                `json:"sync_token"`
+	HasMore          bool                  `json:"has_more"`
+	Reset            bool                  `json:"reset"`
+	Topics           []*SyncTopic          `json:"topics,omitempty"`
+	Exercises        []*SyncCachedExercise `json:"exercises"`
+	Reviews          []*SyncReview         `json:"reviews"`
+	DeletedExercises []string              `json:"deleted_exercises"`
+}
+
+// syncChange is one entry of a sync, ordered by key for paging.
+type syncChange struct {
+	key      string
+	exercise *SyncCachedExercise
+	review   *SyncReview
+	deleted  string
+}
+
+// Deleted exercises are remembered, so syncs can tell clients to drop them
+var (
+	deletedExercisesMutex  sync.Mutex
+	memoryDeletedExercises = make(map[string]time.Time)
+)
+
+// recordDeletedExercises remembers exercises that were deleted. Failures are only logged:
+// the exercises are gone either way.
+func recordDeletedExercises(exerciseIDs []string) {
+	if len(exerciseIDs) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	if airtableBaseID == "" {
+		deletedExercisesMutex.Lock()
+		for _, id := range exerciseIDs {
+			memoryDeletedExercises[id] = now
+		}
+		deletedExercisesMutex.Unlock()
+		return
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, deletedExercisesTableName)
+	for start := 0; start < len(exerciseIDs); start += 10 {
+		var records []*airtable.Record
+		for _, id := range exerciseIDs[start:min(start+10, len(exerciseIDs))] {
+			records = append(records, &airtable.Record{Fields: map[string]any{"ExerciseID": id, "DeletedAt": now.Format(time.RFC3339)}})
+		}
+		if _, err := table.AddRecords(&airtable.Records{Records: records}); err != nil {
+			log.Printf("Warning: failed to record deleted exercises in Airtable: %v", err)
+			return
+		}
+	}
+}
+
+// getDeletedExercises returns the IDs of the exercises deleted after since and until at most.
+func getDeletedExercises(since, until time.Time) ([]string, error) {
+	var ids []string
+	if airtableBaseID == "" {
+		deletedExercisesMutex.Lock()
+		for id, deletedAt := range memoryDeletedExercises {
+			if deletedAt.After(since) && !deletedAt.After(until) {
+				ids = append(ids, id)
+			}
+		}
+		deletedExercisesMutex.Unlock()
+		return ids, nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, deletedExercisesTableName)
+	formula := fmt.Sprintf("AND(IS_AFTER({DeletedAt}, '%s'), NOT(IS_AFTER({DeletedAt}, '%s')))",
+		since.UTC().Format(time.RFC3339), until.UTC().Format(time.RFC3339))
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(formula).ReturnFields("ExerciseID"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deleted exercises from Airtable: %v", err)
+	}
+	for _, record := range records.Records {
+		if id, ok := record.Fields["ExerciseID"].(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// pruneDeletedExercises forgets deletions older than syncDeletionsKeep; tokens that old get
+// a full sync anyway.
+func pruneDeletedExercises(now time.Time) error {
+	cutoff := now.Add(-syncDeletionsKeep)
+	if airtableBaseID == "" {
+		deletedExercisesMutex.Lock()
+		defer deletedExercisesMutex.Unlock()
+		for id, deletedAt := range memoryDeletedExercises {
+			if deletedAt.Before(cutoff) {
+				delete(memoryDeletedExercises, id)
+			}
+		}
+		return nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, deletedExercisesTableName)
+	formula := fmt.Sprintf("IS_BEFORE({DeletedAt}, '%s')", cutoff.UTC().Format(time.RFC3339))
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(formula).ReturnFields("ExerciseID"))
+	if err != nil {
+		return fmt.Errorf("failed to get deleted exercises from Airtable: %v", err)
+	}
+	var ids []string
+	for _, record := range records.Records {
+		ids = append(ids, record.ID)
+	}
+	for start := 0; start < len(ids); start += 10 {
+		if _, err := table.DeleteRecords(ids[start:min(start+10, len(ids))]); err != nil {
+			return fmt.Errorf("failed to prune deleted exercises in Airtable: %v", err)
+		}
+	}
+	return nil
+}
+
+// startDeletedExercisesPruner prunes old deletions once a day.
+func startDeletedExercisesPruner() {
+	go func() {
+		for {
+			if err := pruneDeletedExercises(time.Now()); err != nil {
+				log.Printf("Error pruning deleted exercises: %v", err)
+			}
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
+
+// syncTopicList returns the tenant's topics with their current prompt hashes, and a hash of
+// the list that changes whenever anything in it does.
+func syncTopicList(topics []*Topic) ([]*SyncTopic, string) {
+	hashes := currentCacheHashes(topics)
+	list := []*SyncTopic{}
+	for _, topic := range topics {
+		synced := &SyncTopic{Topic: topic}
+		for hash := range hashes[topic.ID] {
+			synced.PromptHashes = append(synced.PromptHashes, hash)
+		}
+		sort.Strings(synced.PromptHashes)
+		list = append(list, synced)
+	}
+	data, _ := json.Marshal(list)
+	sum := sha256.Sum256(data)
+	return list, hex.EncodeToString(sum[:8])
+}
+
+// collectSyncChanges returns the exercises, reviews and deletions changed after since, in
+// key order. A zero since collects everything. Exercises come without their answers unless
+// withAnswers is set.
+func collectSyncChanges(ctx context.Context, userID string, topics []*Topic, since, until time.Time, withAnswers bool) ([]*syncChange, error) {
+	hashes := currentCacheHashes(topics)
+	var exercises []*Exercise
+	var views []*UserExerciseView
+	var deleted []string
+	var err error
+
+	if since.IsZero() {
+		if exercises, err = dataStore.ListExercises(""); err != nil {
+			return nil, err
+		}
+		all, err := dataStore.GetUserExerciseViews(userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, view := range all {
+			views = append(views, view)
+		}
+	} else {
+		if exercises, err = dataStore.ListExercisesChangedSince(since); err != nil {
+			return nil, err
+		}
+		// A new prompt brings its own exercises, which may have been cached long ago
+		for _, topic := range topics {
+			if topic.UpdatedAt.After(since) {
+				all, err := dataStore.ListExercises(topic.ID)
+				if err != nil {
+					return nil, err
+				}
+				exercises = append(exercises, all...)
+			}
+		}
+		if views, err = dataStore.GetUserExerciseViewsChangedSince(userID, since); err != nil {
+			return nil, err
+		}
+		if deleted, err = getDeletedExercises(since, until); err != nil {
+			return nil, err
+		}
+	}
+
+	var changes []*syncChange
+	seen := make(map[string]bool)
+	for _, ex := range exercises {
+		if seen[ex.AirtableID] || !hashes[ex.TopicID][ex.PromptHash] {
+			continue
+		}
+		seen[ex.AirtableID] = true
+		raw := exerciseWithID(ex)
+		if !withAnswers {
+			raw = withoutAnswer(raw)
+		}
+		changes = append(changes, &syncChange{key: "e:" + ex.AirtableID, exercise: &SyncCachedExercise{
+			ID:         ex.AirtableID,
+			TopicID:    ex.TopicID,
+			PromptHash: ex.PromptHash,
+			Theme:      ex.Theme,
+			Exercise:   raw,
+		}})
+	}
+	for _, view := range views {
+		changes = append(changes, &syncChange{key: "r:" + view.ExerciseID, review: &SyncReview{
+			ExerciseID:        view.ExerciseID,
+			RepetitionCounter: view.RepetitionCounter,
+			Grade:             view.Grade,
+			LastViewed:        view.LastViewed.UTC(),
+			NextReview:        nextReviewAt(view).UTC(),
+		}})
+	}
+	for _, id := range deleted {
+		changes = append(changes, &syncChange{key: "x:" + id, deleted: id})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].key < changes[j].key })
+	return slices.CompactFunc(changes, func(a, b *syncChange) bool { return a.key == b.key }), nil
+}
+
+// Handle delta sync: GET /api/sync?sync_token=&limit=500
+func handleSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultSyncLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSyncLimit {
+			writeError(w, fmt.Sprintf("limit must be between 1 and %d", maxSyncLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	now := time.Now()
+	var cursor syncCursor
+	if token := strings.TrimSpace(query.Get("sync_token")); token != "" {
+		var err error
+		if cursor, err = decodeSyncToken(token); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if cursor.Until.IsZero() {
+		// A new sync; one whose deletions may have been pruned starts over
+		cursor.Until, cursor.After = now, ""
+		if now.Sub(cursor.Since) > syncDeletionsKeep {
+			cursor.Since = time.Time{}
+		}
+	}
+
+	topics, err := tenantTopics(r.Context())
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get topics: %v", err), http.StatusInternalServerError)
+		return
+	}
+	withAnswers := servesAnswers(r)
+	changes, err := collectSyncChanges(r.Context(), userID, topics, cursor.Since, cursor.Until, withAnswers)
+	if err != nil && !cursor.Since.IsZero() {
+		log.Printf("Warning: delta sync for %s failed, sending everything: %v", userID, err)
+		cursor = syncCursor{Until: cursor.Until, Topics: cursor.Topics}
+		changes, err = collectSyncChanges(r.Context(), userID, topics, cursor.Since, cursor.Until, withAnswers)
+	}
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to sync: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	result := &DeltaSync{
+		Reset:            cursor.Since.IsZero() && cursor.After == "",
+		Exercises:        []*SyncCachedExercise{},
+		Reviews:          []*SyncReview{},
+		DeletedExercises: []string{},
+	}
+	// Topics are sent on the first page of a sync, if the client's differ
+	if cursor.After == "" {
+		list, hash := syncTopicList(topics)
+		if result.Reset || hash != cursor.Topics {
+			result.Topics = list
+		}
+		cursor.Topics = hash
+	}
+
+	start := sort.Search(len(changes), func(i int) bool { return changes[i].key > cursor.After })
+	page := changes[start:min(start+limit, len(changes))]
+	for _, change := range page {
+		switch {
+		case change.exercise != nil:
+			result.Exercises = append(result.Exercises, change.exercise)
+		case change.review != nil:
+			result.Reviews = append(result.Reviews, change.review)
+		default:
+			result.DeletedExercises = append(result.DeletedExercises, change.deleted)
+		}
+	}
+
+	if start+len(page) < len(changes) {
+		result.HasMore = true
+		cursor.After = page[len(page)-1].key
+	} else {
+		cursor = syncCursor{Since: cursor.Until.Add(-syncOverlap), Topics: cursor.Topics}
+	}
+	result.SyncToken = encodeSyncToken(cursor)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
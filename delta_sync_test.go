@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHandleSyncStripsAnswers(t *testing.T) {
+	useMemoryStore(t)
+	user := createTestUser(t, "learner-google-id")
+	topic, err := dataStore.InsertTopic("Weil", "Sentences with weil", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := getCacheHash(topic.Prompt, PromptVars{Level: "B1"})
+	if _, err := dataStore.CreateExercise(topic.ID, hash, "", `{"correct_german_sentence": "Ich lerne Deutsch, weil ich in Berlin wohne.", "english_hint": "I learn German because I live in Berlin."}`); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(handleSync, user, http.MethodGet, "/api/sync", "")
+	var result DeltaSync
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &result) != nil || len(result.Exercises) != 1 {
+		t.Fatalf("sync: status %d, body %s", rec.Code, rec.Body)
+	}
+	if exercise := string(result.Exercises[0].Exercise); strings.Contains(exercise, "correct_german_sentence") || !strings.Contains(exercise, "words") {
+		t.Errorf("a cookie session got the answer: %s", exercise)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Difficulty adjusts exercises on top of the CEFR level: how long and involved the
+// generated sentences are, and whether distractors are mixed into the word bank. Users set
+// a default in their profile, and each /api/exercises request can override it. Generated
+// exercises are tagged with the difficulty they were generated for ("difficulty" in their
+// JSON; untagged ones are normal), and sets are only served exercises of their difficulty,
+// the way themes work.
+const (
+	difficultyEasy   = "easy"
+	difficultyNormal = "normal"
+	difficultyHard   = "hard"
+)
+
+// Within a set, exercises go from easiest to hardest by their difficulty score (see
+// exerciseDifficultyScore). Sets requested with "escalate" step up further: once the first
+// escalateAfterAnswers are answered without mistakes or hints, the rest of the set is swapped
+// for harder cached exercises (see escalateSession).
+const (
+	escalateAfterAnswers = 3
+	rareWordRank         = 2000 // words less frequent than this make a sentence harder
+)
+
+// Appended to the generation prompt, unless it uses the {{difficulty}} placeholder
+var difficultyInstructions = map[string]string{
+	difficultyEasy: "Keep the sentences short and simple: at most 8 words, one main clause and one subordinate clause, " +
+		"with everyday vocabulary and the present tense.",
+	difficultyHard: "Make the sentences long and demanding: 12 to 20 words, with two subordinate clauses or a fronted " +
+		"subordinate clause, separable or modal verbs and less common vocabulary.",
+}
+
+// validateDifficulty normalizes a difficulty. An empty one is returned as it is.
+func validateDifficulty(difficulty string) (string, error) {
+	difficulty = strings.ToLower(strings.TrimSpace(difficulty))
+	switch difficulty {
+	case "", difficultyEasy, difficultyNormal, difficultyHard:
+		return difficulty, nil
+	}
+	return "", fmt.Errorf("difficulty must be %s, %s or %s", difficultyEasy, difficultyNormal, difficultyHard)
+}
+
+// requestDifficulty returns the difficulty of a set: the request's, else the user's, else normal.
+func requestDifficulty(requested string, user *User) (string, error) {
+	difficulty, err := validateDifficulty(requested)
+	if err != nil {
+		return "", err
+	}
+	if difficulty == "" && user != nil {
+		difficulty = user.Difficulty
+	}
+	if difficulty == "" {
+		difficulty = difficultyNormal
+	}
+	return difficulty, nil
+}
+
+// difficulty returns the difficulty an exercise was generated for.
+func (e *Exercise) difficulty() string {
+	var ex struct {
+		Difficulty string `json:"difficulty"`
+	}
+	json.Unmarshal([]byte(e.ExerciseJSON), &ex)
+	if difficulty, err := validateDifficulty(ex.Difficulty); err == nil && difficulty != "" {
+		return difficulty
+	}
+	return difficultyNormal
+}
+
+// filterExercisesByDifficulty returns the exercises generated for a difficulty.
+func filterExercisesByDifficulty(exercises []*Exercise, difficulty string) []*Exercise {
+	var filtered []*Exercise
+	for _, ex := range exercises {
+		if ex.difficulty() == difficulty {
+			filtered = append(filtered, ex)
+		}
+	}
+	return filtered
+}
+
+// withDifficulty tags a generated exercise's JSON with its difficulty. Normal exercises
+// are left untagged, like the ones generated before difficulties existed.
+func withDifficulty(exerciseJSON, difficulty string) string {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(exerciseJSON), &fields); err != nil {
+		return exerciseJSON
+	}
+	if difficulty == difficultyNormal || difficulty == "" {
+		delete(fields, "difficulty")
+	} else {
+		fields["difficulty"], _ = json.Marshal(difficulty)
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return exerciseJSON
+	}
+	return string(data)
+}
+
+// servesDistractors reports whether a set gets distractors: always when hard, never when
+// easy, and otherwise if the user opted in.
+func servesDistractors(user *User, difficulty string) bool {
+	switch difficulty {
+	case difficultyHard:
+		return true
+	case difficultyEasy:
+		return false
+	}
+	return user != nil && user.Distractors
+}
+
+// exerciseDifficultyScore rates how hard an exercise's sentence is to build, from 0 to 100:
+// longer sentences, more clauses, a fronted subordinate clause and rarer words are harder.
+func exerciseDifficultyScore(ex *Exercise) int {
+	words := sentenceWords(ex.Sentence)
+	if len(words) == 0 {
+		return 0
+	}
+	commas := 0
+	for _, token := range tokenizeSentence(ex.Sentence) {
+		if token == "," {
+			commas++
+		}
+	}
+	rare := 0
+	for _, word := range words {
+		if utf8.RuneCountInString(word) < 2 || strings.ContainsAny(word, "0123456789") {
+			continue
+		}
+		if rank := wordRank(word); rank == 0 || rank > rareWordRank {
+			rare++
+		}
+	}
+
+	score := 40*min(len(words), 20)/20 + 10*min(commas, 2) + 30*rare/len(words)
+	if subordinatingConjunctions[strings.ToLower(words[0])] {
+		score += 10 // a fronted subordinate clause
+	}
+	return min(score, 100)
+}
+
+// orderByDifficulty sorts a set from easiest to hardest. Exercises of the same score keep
+// their order.
+func orderByDifficulty(exercises []*Exercise) {
+	scores := make(map[string]int, len(exercises))
+	for _, ex := range exercises {
+		scores[ex.AirtableID] = exerciseDifficultyScore(ex)
+	}
+	sort.SliceStable(exercises, func(i, j int) bool {
+		return scores[exercises[i].AirtableID] < scores[exercises[j].AirtableID]
+	})
+}
+
+// difficultyRank orders the difficulties, easy first.
+func difficultyRank(difficulty string) int {
+	return slices.Index([]string{difficultyEasy, difficultyNormal, difficultyHard}, difficulty)
+}
+
+// escalateSession swaps the unanswered exercises of a session that started perfectly for
+// harder ones. Replacements come from the same topic, prompt and theme, at the set's
+// difficulty or a harder one, and must score harder than both the exercise they replace and
+// every exercise answered so far. New exercises are replaced by new ones and reviews by due
+// reviews, so the day's limits still hold. It reports whether any exercise was swapped.
+func escalateSession(ctx context.Context, session *CurrentSession, user *User, now time.Time) (bool, error) {
+	exercises, err := dataStore.ListExercises(session.TopicID)
+	if err != nil {
+		return false, err
+	}
+	views, err := dataStore.GetUserExerciseViews(session.OwnerID)
+	if err != nil {
+		return false, err
+	}
+	applyHintBoost(session.OwnerID, views)
+	byID := make(map[string]*Exercise, len(exercises))
+	for _, ex := range exercises {
+		byID[ex.AirtableID] = ex
+	}
+
+	ids := session.exerciseIDs()
+	var first *Exercise
+	hardest := 0
+	for _, id := range session.Answered {
+		if ex, ok := byID[id]; ok {
+			hardest = max(hardest, exerciseDifficultyScore(ex))
+			if first == nil {
+				first = ex
+			}
+		}
+	}
+	if first == nil {
+		return false, nil
+	}
+
+	var candidates []*Exercise
+	for _, ex := range withoutRetired(exercises) {
+		view, seen := views[ex.AirtableID]
+		if slices.Contains(ids, ex.AirtableID) || ex.PromptHash != first.PromptHash || !strings.EqualFold(ex.Theme, first.Theme) ||
+			difficultyRank(ex.difficulty()) < difficultyRank(first.difficulty()) || (seen && (viewedToday(view, now) || !isDueForReview(view, now))) {
+			continue
+		}
+		if exerciseDifficultyScore(ex) > hardest {
+			candidates = append(candidates, ex)
+		}
+	}
+	orderByDifficulty(candidates)
+
+	replacements := make(map[int]*Exercise)
+	var replaced []*Exercise
+	for i, id := range ids {
+		current, ok := byID[id]
+		if !ok || slices.Contains(session.Answered, id) {
+			continue
+		}
+		_, currentSeen := views[id]
+		for j, candidate := range candidates {
+			if _, seen := views[candidate.AirtableID]; seen != currentSeen || exerciseDifficultyScore(candidate) <= exerciseDifficultyScore(current) {
+				continue
+			}
+			replacements[i] = candidate
+			replaced = append(replaced, candidate)
+			candidates = slices.Delete(candidates, j, j+1)
+			break
+		}
+	}
+	if len(replaced) == 0 {
+		return false, nil
+	}
+
+	var hints map[string]string
+	if user != nil && user.NativeLanguage != "" {
+		hints = nativeHints(ctx, user, replaced)
+	}
+	session.Exercises = slices.Clone(session.Exercises)
+	for i, ex := range replacements {
+		session.Exercises[i] = servedExercise(ex, user, hints, ex.difficulty())
+	}
+	return true, nil
+}
@@ -0,0 +1,817 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Duels: two logged-in users race through the same set of a topic's cached exercises. A
+// user asking for a duel joins the oldest one waiting on the same topic, level and size,
+// or opens one for someone else to join, e.g. by sharing its ID. Once joined, both get the
+// same exercises at once and poll the duel to follow each other's progress. The server
+// checks every answer and times it from the duel's start. The player with more correct
+// answers wins, and of two equal scores the one who finished sooner; a duel ends when both
+// have answered everything, or duelTimeLimit after it started. Each user has an Elo rating,
+// kept in the DuelRatings table, which finished duels move up or down. Duels don't count
+// for SRS or the daily limits.
+const (
+	duelStatusWaiting  = "waiting"
+	duelStatusActive   = "active"
+	duelStatusFinished = "finished"
+	duelStatusExpired  = "expired" // nobody joined in time
+)
+
+const (
+	defaultDuelExercises = 5
+	maxDuelExercises     = 10
+	duelTimeLimit        = 5 * time.Minute
+	duelWaitTimeout      = 10 * time.Minute
+	initialDuelRating    = 1200
+	duelRatingK          = 32 // the most a rating moves in one duel
+)
+
+// Duel is a race between two users through the same exercises.
+type Duel struct {
+	ID          string        `json:"id"`
+	TopicID     string        `json:"topic_id"`
+	Level       string        `json:"level"`
+	Status      string        `json:"status"`
+	Players     []*DuelPlayer `json:"players"` // the one who opened it first
+	ExerciseIDs []string      `json:"-"`
+	WinnerID    string        `json:"winner_id,omitempty"` // empty for a draw
+	CreatedAt   time.Time     `json:"created_at"`
+	StartedAt   time.Time     `json:"started_at,omitzero"`
+	FinishedAt  time.Time     `json:"finished_at,omitzero"`
+
+	// Filled in for responses only
+	Exercises []json.RawMessage `json:"exercises,omitempty"` // without their answers
+	Remaining int               `json:"remaining,omitempty"` // seconds left while active
+}
+
+// DuelPlayer is one side of a duel.
+type DuelPlayer struct {
+	UserID       string        `json:"user_id"`
+	Name         string        `json:"name"`
+	Answers      []*DuelAnswer `json:"answers"`
+	Score        int           `json:"score"`   // correct answers
+	Seconds      float64       `json:"seconds"` // from the start to the last answer
+	RatingBefore int           `json:"rating_before"`
+	RatingChange int           `json:"rating_change"` // once finished
+}
+
+// DuelAnswer is a player's answer to one exercise, timed by the server.
+type DuelAnswer struct {
+	ExerciseID string    `json:"exercise_id"`
+	Correct    bool      `json:"correct"`
+	Seconds    float64   `json:"seconds"` // since the duel started
+	AnsweredAt time.Time `json:"answered_at"`
+}
+
+// DuelRating is a user's Elo rating and record.
+type DuelRating struct {
+	ID        string    `json:"-"`
+	UserID    string    `json:"user_id"`
+	Rating    int       `json:"rating"`
+	Wins      int       `json:"wins"`
+	Losses    int       `json:"losses"`
+	Draws     int       `json:"draws"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+var (
+	duelsMutex        sync.Mutex
+	memoryDuels       = make(map[string]*Duel)       // with in-memory storage
+	memoryDuelRatings = make(map[string]*DuelRating) // by user ID, with in-memory storage
+)
+
+// duelLocks serializes changes to each duel, so both players can answer at the same time
+// and two users can't join the same one. Per instance, like the in-memory rate limits.
+// A lock is removed once nobody holds or waits for it, so finished and expired duels
+// don't keep theirs.
+var (
+	duelLocks   = make(map[string]*duelLock)
+	duelLocksMu sync.Mutex
+)
+
+type duelLock struct {
+	mu   sync.Mutex
+	refs int // holders and waiters
+}
+
+func lockDuel(id string) func() {
+	duelLocksMu.Lock()
+	lock, ok := duelLocks[id]
+	if !ok {
+		lock = &duelLock{}
+		duelLocks[id] = lock
+	}
+	lock.refs++
+	duelLocksMu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		duelLocksMu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(duelLocks, id)
+		}
+		duelLocksMu.Unlock()
+	}
+}
+
+// player returns the user's side of the duel, or nil if they aren't in it.
+func (d *Duel) player(userID string) *DuelPlayer {
+	for _, player := range d.Players {
+		if player.UserID == userID {
+			return player
+		}
+	}
+	return nil
+}
+
+// duelPlayerName is how a player is shown to their opponent, private as on the leaderboard.
+func duelPlayerName(user *User) string {
+	if user == nil || user.LeaderboardAnonymous || user.DisplayName == "" {
+		return anonymousDisplayName
+	}
+	return user.DisplayName
+}
+
+// expectedDuelScore is the chance of a player with the rating beating the opponent, by Elo.
+func expectedDuelScore(rating, opponentRating int) float64 {
+	return 1 / (1 + math.Pow(10, float64(opponentRating-rating)/400))
+}
+
+// duelRatingChange is how much a rating moves for a result: 1 for a win, 0.5 for a draw
+// and 0 for a loss.
+func duelRatingChange(rating, opponentRating int, result float64) int {
+	return int(math.Round(duelRatingK * (result - expectedDuelScore(rating, opponentRating))))
+}
+
+func duelFromRecord(record *airtable.Record) *Duel {
+	duel := &Duel{ID: record.ID, Players: []*DuelPlayer{}}
+	if val, ok := record.Fields["TopicID"].(string); ok {
+		duel.TopicID = val
+	}
+	if val, ok := record.Fields["Level"].(string); ok {
+		duel.Level = val
+	}
+	if val, ok := record.Fields["Status"].(string); ok {
+		duel.Status = val
+	}
+	if val, ok := record.Fields["Players"].(string); ok {
+		if err := json.Unmarshal([]byte(val), &duel.Players); err != nil {
+			log.Printf("Warning: invalid players in duel %s: %v", record.ID, err)
+		}
+	}
+	if val, ok := record.Fields["Exercises"].(string); ok && val != "" {
+		duel.ExerciseIDs = strings.Split(val, ",")
+	}
+	if val, ok := record.Fields["WinnerID"].(string); ok {
+		duel.WinnerID = val
+	}
+	for field, t := range map[string]*time.Time{"CreatedAt": &duel.CreatedAt, "StartedAt": &duel.StartedAt, "FinishedAt": &duel.FinishedAt} {
+		if val, ok := record.Fields[field].(string); ok {
+			if parsed, err := time.Parse(time.RFC3339Nano, val); err == nil {
+				*t = parsed
+			}
+		}
+	}
+	return duel
+}
+
+// copyDuel copies a duel, its players and their answers, so stored ones are not shared.
+func copyDuel(duel *Duel) *Duel {
+	d := *duel
+	d.ExerciseIDs = slices.Clone(duel.ExerciseIDs)
+	d.Players = make([]*DuelPlayer, len(duel.Players))
+	for i, player := range duel.Players {
+		p := *player
+		p.Answers = make([]*DuelAnswer, len(player.Answers))
+		for j, answer := range player.Answers {
+			a := *answer
+			p.Answers[j] = &a
+		}
+		d.Players[i] = &p
+	}
+	return &d
+}
+
+// findDuels returns the duels matching a filter, newest first. formula selects them in
+// Airtable and match in memory.
+func findDuels(formula string, match func(*Duel) bool) ([]*Duel, error) {
+	duels := []*Duel{}
+	if airtableBaseID == "" {
+		duelsMutex.Lock()
+		for _, duel := range memoryDuels {
+			if match(duel) {
+				duels = append(duels, copyDuel(duel))
+			}
+		}
+		duelsMutex.Unlock()
+	} else {
+		records, err := getAllRecords(airtableClient.GetTable(airtableBaseID, duelsTableName).GetRecords().WithFilterFormula(formula))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get duels from Airtable: %v", err)
+		}
+		for _, record := range records.Records {
+			duels = append(duels, duelFromRecord(record))
+		}
+	}
+	slices.SortFunc(duels, func(a, b *Duel) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return duels, nil
+}
+
+// getUserDuels returns the duels a user is in, newest first.
+func getUserDuels(userID string) ([]*Duel, error) {
+	return findDuels(fmt.Sprintf("FIND('%s', {PlayerIDs})", userID), func(d *Duel) bool { return d.player(userID) != nil })
+}
+
+// getActiveDuels returns the duels a user is playing, newest first. Their time may be up
+// already, as duels are only finished when next loaded.
+func getActiveDuels(userID string) ([]*Duel, error) {
+	return findDuels(fmt.Sprintf("AND({Status} = '%s', FIND('%s', {PlayerIDs}))", duelStatusActive, userID), func(d *Duel) bool {
+		return d.Status == duelStatusActive && d.player(userID) != nil
+	})
+}
+
+// getWaitingDuels returns the duels waiting for an opponent on a topic, newest first.
+func getWaitingDuels(topicID string) ([]*Duel, error) {
+	return findDuels(fmt.Sprintf("AND({Status} = '%s', {TopicID} = '%s')", duelStatusWaiting, topicID), func(d *Duel) bool {
+		return d.Status == duelStatusWaiting && d.TopicID == topicID
+	})
+}
+
+func getDuel(id string) (*Duel, error) {
+	if airtableBaseID == "" {
+		duelsMutex.Lock()
+		defer duelsMutex.Unlock()
+		if duel, ok := memoryDuels[id]; ok {
+			return copyDuel(duel), nil
+		}
+		return nil, fmt.Errorf("duel %s not found", id)
+	}
+
+	record, err := airtableClient.GetTable(airtableBaseID, duelsTableName).GetRecord(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get duel from Airtable: %v", err)
+	}
+	return duelFromRecord(record), nil
+}
+
+// saveDuel creates a duel, or updates it if it has an ID.
+func saveDuel(duel *Duel) error {
+	if airtableBaseID == "" {
+		duelsMutex.Lock()
+		defer duelsMutex.Unlock()
+		if duel.ID == "" {
+			duel.ID = fmt.Sprintf("duel%d", time.Now().UnixNano())
+		}
+		memoryDuels[duel.ID] = copyDuel(duel)
+		return nil
+	}
+
+	players, err := json.Marshal(duel.Players)
+	if err != nil {
+		return fmt.Errorf("failed to encode duel players: %v", err)
+	}
+	var playerIDs []string
+	for _, player := range duel.Players {
+		playerIDs = append(playerIDs, player.UserID)
+	}
+	fields := map[string]any{
+		"TopicID":   duel.TopicID,
+		"Level":     duel.Level,
+		"Status":    duel.Status,
+		"PlayerIDs": strings.Join(playerIDs, ","),
+		"Players":   string(players),
+		"Exercises": strings.Join(duel.ExerciseIDs, ","),
+		"WinnerID":  duel.WinnerID,
+		// Answers are timed to the millisecond
+		"CreatedAt": duel.CreatedAt.Format(time.RFC3339Nano),
+	}
+	if !duel.StartedAt.IsZero() {
+		fields["StartedAt"] = duel.StartedAt.Format(time.RFC3339Nano)
+	}
+	if !duel.FinishedAt.IsZero() {
+		fields["FinishedAt"] = duel.FinishedAt.Format(time.RFC3339Nano)
+	}
+	table := airtableClient.GetTable(airtableBaseID, duelsTableName)
+	records := &airtable.Records{Records: []*airtable.Record{{ID: duel.ID, Fields: fields}}}
+	if duel.ID != "" {
+		if _, err := table.UpdateRecordsPartial(records); err != nil {
+			return fmt.Errorf("failed to update duel in Airtable: %v", err)
+		}
+		return nil
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return fmt.Errorf("failed to create duel in Airtable: %v", err)
+	}
+	if len(result.Records) > 0 {
+		duel.ID = result.Records[0].ID
+	}
+	return nil
+}
+
+func deleteDuel(id string) error {
+	if airtableBaseID == "" {
+		duelsMutex.Lock()
+		delete(memoryDuels, id)
+		duelsMutex.Unlock()
+		return nil
+	}
+	if _, err := airtableClient.GetTable(airtableBaseID, duelsTableName).DeleteRecords([]string{id}); err != nil {
+		return fmt.Errorf("failed to delete duel from Airtable: %v", err)
+	}
+	return nil
+}
+
+// getDuelRating returns a user's rating, or the initial one if they haven't finished a duel.
+func getDuelRating(userID string) (*DuelRating, error) {
+	if airtableBaseID == "" {
+		duelsMutex.Lock()
+		defer duelsMutex.Unlock()
+		if rating, ok := memoryDuelRatings[userID]; ok {
+			c := *rating
+			return &c, nil
+		}
+		return &DuelRating{UserID: userID, Rating: initialDuelRating}, nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, duelRatingsTableName)
+	records, err := table.GetRecords().WithFilterFormula(fmt.Sprintf("{UserID} = '%s'", userID)).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get duel rating from Airtable: %v", err)
+	}
+	rating := &DuelRating{UserID: userID, Rating: initialDuelRating}
+	if len(records.Records) > 0 {
+		record := records.Records[0]
+		rating.ID = record.ID
+		if val, ok := record.Fields["Rating"].(float64); ok {
+			rating.Rating = int(val)
+		}
+		if val, ok := record.Fields["Wins"].(float64); ok {
+			rating.Wins = int(val)
+		}
+		if val, ok := record.Fields["Losses"].(float64); ok {
+			rating.Losses = int(val)
+		}
+		if val, ok := record.Fields["Draws"].(float64); ok {
+			rating.Draws = int(val)
+		}
+		if val, ok := record.Fields["UpdatedAt"].(string); ok {
+			if t, err := time.Parse(time.RFC3339, val); err == nil {
+				rating.UpdatedAt = t
+			}
+		}
+	}
+	return rating, nil
+}
+
+// saveDuelRating creates or updates a user's rating.
+func saveDuelRating(rating *DuelRating) error {
+	if airtableBaseID == "" {
+		duelsMutex.Lock()
+		defer duelsMutex.Unlock()
+		c := *rating
+		memoryDuelRatings[rating.UserID] = &c
+		return nil
+	}
+
+	fields := map[string]any{
+		"UserID":    rating.UserID,
+		"Rating":    rating.Rating,
+		"Wins":      rating.Wins,
+		"Losses":    rating.Losses,
+		"Draws":     rating.Draws,
+		"UpdatedAt": rating.UpdatedAt.Format(time.RFC3339),
+	}
+	table := airtableClient.GetTable(airtableBaseID, duelRatingsTableName)
+	records := &airtable.Records{Records: []*airtable.Record{{ID: rating.ID, Fields: fields}}}
+	if rating.ID != "" {
+		if _, err := table.UpdateRecordsPartial(records); err != nil {
+			return fmt.Errorf("failed to update duel rating in Airtable: %v", err)
+		}
+		return nil
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return fmt.Errorf("failed to create duel rating in Airtable: %v", err)
+	}
+	if len(result.Records) > 0 {
+		rating.ID = result.Records[0].ID
+	}
+	return nil
+}
+
+// finishDuel decides the winner and moves both players' ratings.
+func finishDuel(duel *Duel, now time.Time) error {
+	duel.Status = duelStatusFinished
+	duel.FinishedAt = now
+	first, second := duel.Players[0], duel.Players[1]
+	for _, player := range duel.Players {
+		if len(player.Answers) < len(duel.ExerciseIDs) {
+			player.Seconds = duelTimeLimit.Seconds() // didn't finish
+		}
+	}
+	result := 0.5 // of the first player
+	switch {
+	case first.Score > second.Score, first.Score == second.Score && first.Seconds < second.Seconds:
+		duel.WinnerID, result = first.UserID, 1
+	case first.Score < second.Score, first.Score == second.Score && first.Seconds > second.Seconds:
+		duel.WinnerID, result = second.UserID, 0
+	}
+
+	ratings := make([]*DuelRating, 2)
+	for i, player := range duel.Players {
+		rating, err := getDuelRating(player.UserID)
+		if err != nil {
+			return err
+		}
+		ratings[i] = rating
+	}
+	first.RatingChange = duelRatingChange(ratings[0].Rating, ratings[1].Rating, result)
+	second.RatingChange = duelRatingChange(ratings[1].Rating, ratings[0].Rating, 1-result)
+	for i, player := range duel.Players {
+		rating := ratings[i]
+		player.RatingBefore = rating.Rating
+		rating.Rating += player.RatingChange
+		switch duel.WinnerID {
+		case "":
+			rating.Draws++
+		case player.UserID:
+			rating.Wins++
+		default:
+			rating.Losses++
+		}
+		rating.UpdatedAt = now
+	}
+	// The duel first, so a failure can't move the ratings twice
+	if err := saveDuel(duel); err != nil {
+		return err
+	}
+	for _, rating := range ratings {
+		if err := saveDuelRating(rating); err != nil {
+			log.Printf("Warning: failed to save the duel rating of %s: %v", rating.UserID, err)
+		}
+	}
+	return nil
+}
+
+// updateDuelStatus expires a duel nobody joined and finishes one that is over. Call it
+// with the duel locked.
+func updateDuelStatus(duel *Duel, now time.Time) error {
+	switch duel.Status {
+	case duelStatusWaiting:
+		if now.Sub(duel.CreatedAt) > duelWaitTimeout {
+			duel.Status = duelStatusExpired
+			return saveDuel(duel)
+		}
+	case duelStatusActive:
+		done := true
+		for _, player := range duel.Players {
+			done = done && len(player.Answers) == len(duel.ExerciseIDs)
+		}
+		if done || now.Sub(duel.StartedAt) > duelTimeLimit {
+			return finishDuel(duel, now)
+		}
+	}
+	return nil
+}
+
+// duelForClient fills in the exercises, without their answers, once the duel has started.
+func duelForClient(duel *Duel, now time.Time) *Duel {
+	d := copyDuel(duel)
+	if d.Status == duelStatusWaiting || d.Status == duelStatusExpired {
+		return d
+	}
+	if d.Status == duelStatusActive {
+		d.Remaining = max(0, int(d.StartedAt.Add(duelTimeLimit).Sub(now).Seconds()))
+	}
+	d.Exercises = []json.RawMessage{}
+	for _, id := range d.ExerciseIDs {
+		ex, err := exerciseByID(id)
+		if err != nil {
+			log.Printf("Warning: failed to get exercise %s of duel %s: %v", id, d.ID, err)
+			continue
+		}
+		d.Exercises = append(d.Exercises, withoutAnswer(servedExercise(ex, nil, nil, difficultyNormal)))
+	}
+	return d
+}
+
+// Handle duels, for logged-in users:
+// GET /api/duels lists the user's duels, newest first, with their rating.
+// POST /api/duels with {"topic_id": "rec...", "count": 5, "level": "B1"} joins the oldest
+// duel waiting on the same topic, level and count, or opens one (201).
+// GET /api/duels/{id} returns one; players poll it to follow the duel. DELETE withdraws a
+// duel nobody joined yet.
+// POST /api/duels/{id}/join joins a waiting duel, e.g. one shared by a friend.
+// POST /api/duels/{id}/answers with {"exercise_id": "rec...", "words": [...]} answers one
+// of the duel's exercises, once.
+func handleDuels(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/duels"), "/"), "/")
+
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			listDuels(w, userID)
+		case http.MethodPost:
+			rateLimited("exercises", func(w http.ResponseWriter, r *http.Request) {
+				requestDuel(w, r, userID)
+			})(w, r)
+		default:
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	unlock := lockDuel(id)
+	defer unlock()
+	duel, err := getDuel(id)
+	if err != nil {
+		writeError(w, "Duel not found", http.StatusNotFound)
+		return
+	}
+	now := time.Now().UTC()
+	if err := updateDuelStatus(duel, now); err != nil {
+		writeError(w, fmt.Sprintf("Failed to update duel: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// Anyone may see a waiting duel, to join it; only its players see it after that
+	if duel.player(userID) == nil && duel.Status != duelStatusWaiting {
+		writeError(w, "Duel not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(duelForClient(duel, now))
+
+	case action == "" && r.Method == http.MethodDelete:
+		if duel.player(userID) == nil {
+			writeError(w, "Only the user who opened a duel can withdraw it", http.StatusForbidden)
+			return
+		}
+		if duel.Status != duelStatusWaiting {
+			writeError(w, "Only a duel nobody joined can be withdrawn", http.StatusConflict)
+			return
+		}
+		if err := deleteDuel(id); err != nil {
+			writeError(w, fmt.Sprintf("Failed to delete duel: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case action == "join" && r.Method == http.MethodPost:
+		if duel.Status != duelStatusWaiting || duel.player(userID) != nil {
+			writeError(w, "The duel can't be joined", http.StatusConflict)
+			return
+		}
+		if err := joinDuel(duel, userID, now); err != nil {
+			writeError(w, fmt.Sprintf("Failed to join duel: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(duelForClient(duel, now))
+
+	case action == "answers" && r.Method == http.MethodPost:
+		rateLimited("answers", func(w http.ResponseWriter, r *http.Request) {
+			answerDuel(w, r, duel, userID, now)
+		})(w, r)
+
+	case action == "" || action == "join" || action == "answers":
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		writeError(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// listDuels returns the user's duels and rating, expiring and finishing the ones that are over.
+func listDuels(w http.ResponseWriter, userID string) {
+	duels, err := getUserDuels(userID)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get duels: %v", err), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().UTC()
+	for i, duel := range duels {
+		if duel.Status != duelStatusWaiting && duel.Status != duelStatusActive {
+			continue
+		}
+		unlock := lockDuel(duel.ID)
+		if current, err := getDuel(duel.ID); err == nil {
+			if err := updateDuelStatus(current, now); err != nil {
+				log.Printf("Warning: failed to update duel %s: %v", duel.ID, err)
+			}
+			duels[i] = current
+		}
+		unlock()
+	}
+	rating, err := getDuelRating(userID)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get duel rating: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"duels": duels, "rating": rating})
+}
+
+// joinDuel adds the user as the second player and starts the duel. Call it with the duel
+// locked.
+func joinDuel(duel *Duel, userID string, now time.Time) error {
+	user, err := dataStore.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Warning: failed to get user %s for a duel: %v", userID, err)
+	}
+	duel.Players = append(duel.Players, &DuelPlayer{UserID: userID, Name: duelPlayerName(user), Answers: []*DuelAnswer{}})
+	duel.Status = duelStatusActive
+	duel.StartedAt = now
+	return saveDuel(duel)
+}
+
+// requestDuel joins a waiting duel on the topic or opens one.
+func requestDuel(w http.ResponseWriter, r *http.Request, userID string) {
+	var req GenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Count == 0 {
+		req.Count = defaultDuelExercises
+	}
+	if req.Count < 1 || req.Count > maxDuelExercises {
+		writeError(w, fmt.Sprintf("count must be between 1 and %d", maxDuelExercises), http.StatusBadRequest)
+		return
+	}
+	topic, err := dataStore.GetTopic(strings.TrimSpace(req.TopicID))
+	if err != nil || !topicInTenant(r.Context(), topic) {
+		writeError(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+	vars := promptVarsFromRequest(GenerateRequest{Level: req.Level})
+	now := time.Now().UTC()
+
+	waiting, err := getWaitingDuels(topic.ID)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get duels: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for _, candidate := range slices.Backward(waiting) { // oldest first
+		if candidate.Level != vars.Level || len(candidate.ExerciseIDs) != req.Count {
+			continue
+		}
+		if candidate.player(userID) != nil && now.Sub(candidate.CreatedAt) <= duelWaitTimeout {
+			// Asking again while waiting returns the user's own duel
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(duelForClient(candidate, now))
+			return
+		}
+		if candidate.player(userID) != nil {
+			continue
+		}
+		joined, err := func() (bool, error) {
+			unlock := lockDuel(candidate.ID)
+			defer unlock()
+			duel, err := getDuel(candidate.ID) // it may have been joined or expired meanwhile
+			if err != nil || updateDuelStatus(duel, now) != nil || duel.Status != duelStatusWaiting {
+				return false, nil
+			}
+			if err := joinDuel(duel, userID, now); err != nil {
+				return false, err
+			}
+			candidate = duel
+			return true, nil
+		}()
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to join duel: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if joined {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(duelForClient(candidate, now))
+			return
+		}
+	}
+
+	// Duels are served normal exercises, whatever the players' own difficulty
+	exercises, err := dataStore.GetExercisesForTopic(topic.ID, getCacheHash(topic.Prompt, vars))
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get exercises: %v", err), http.StatusInternalServerError)
+		return
+	}
+	exercises = withoutRetired(filterExercisesByDifficulty(exercises, difficultyNormal))
+	if len(exercises) < req.Count {
+		writeError(w, fmt.Sprintf("A duel of %d exercises needs as many cached exercises; practise the topic first", req.Count), http.StatusConflict)
+		return
+	}
+	rand.Shuffle(len(exercises), func(i, j int) { exercises[i], exercises[j] = exercises[j], exercises[i] })
+
+	user, err := dataStore.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Warning: failed to get user %s for a duel: %v", userID, err)
+	}
+	duel := &Duel{
+		TopicID:   topic.ID,
+		Level:     vars.Level,
+		Status:    duelStatusWaiting,
+		Players:   []*DuelPlayer{{UserID: userID, Name: duelPlayerName(user), Answers: []*DuelAnswer{}}},
+		CreatedAt: now,
+	}
+	for _, ex := range exercises[:req.Count] {
+		duel.ExerciseIDs = append(duel.ExerciseIDs, ex.AirtableID)
+	}
+	if err := saveDuel(duel); err != nil {
+		writeError(w, fmt.Sprintf("Failed to save duel: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(duelForClient(duel, now))
+}
+
+// answerDuel checks a player's answer to one of the duel's exercises. Call it with the duel
+// locked.
+func answerDuel(w http.ResponseWriter, r *http.Request, duel *Duel, userID string, now time.Time) {
+	var req struct {
+		ExerciseID string   `json:"exercise_id"`
+		Words      []string `json:"words"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Words) > maxAnswerWords {
+		writeError(w, fmt.Sprintf("words can hold at most %d words", maxAnswerWords), http.StatusBadRequest)
+		return
+	}
+	words := sentenceWords(strings.Join(req.Words, " "))
+	if len(words) == 0 {
+		writeError(w, "words is required", http.StatusBadRequest)
+		return
+	}
+	player := duel.player(userID)
+	if player == nil || duel.Status != duelStatusActive {
+		writeError(w, fmt.Sprintf("The duel is %s", duel.Status), http.StatusConflict)
+		return
+	}
+	if !slices.Contains(duel.ExerciseIDs, req.ExerciseID) {
+		writeError(w, fmt.Sprintf("Exercise %s is not part of the duel", req.ExerciseID), http.StatusBadRequest)
+		return
+	}
+	if slices.ContainsFunc(player.Answers, func(a *DuelAnswer) bool { return a.ExerciseID == req.ExerciseID }) {
+		writeError(w, "The exercise is already answered", http.StatusConflict)
+		return
+	}
+	ex, err := exerciseByID(req.ExerciseID)
+	if err != nil {
+		writeError(w, "Exercise not found", http.StatusNotFound)
+		return
+	}
+
+	check := checkAnswer(ex, words)
+	check.Sentence = ex.Sentence // shown whatever the outcome, as there is no second try
+	answer := &DuelAnswer{
+		ExerciseID: ex.AirtableID,
+		Correct:    check.Correct,
+		Seconds:    now.Sub(duel.StartedAt).Round(time.Millisecond).Seconds(),
+		AnsweredAt: now,
+	}
+	player.Answers = append(player.Answers, answer)
+	player.Seconds = answer.Seconds
+	if answer.Correct {
+		player.Score++
+	}
+	if err := saveDuel(duel); err != nil {
+		writeError(w, fmt.Sprintf("Failed to save duel: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := updateDuelStatus(duel, now); err != nil {
+		log.Printf("Warning: failed to finish duel %s: %v", duel.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"answer": answer,
+		"check":  check,
+		"duel":   duelForClient(duel, now),
+	})
+}
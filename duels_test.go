@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestDuelRatingChange(t *testing.T) {
+	tests := []struct {
+		name           string
+		rating         int
+		opponentRating int
+		result         float64
+		want           int
+	}{
+		{"win between equals", 1200, 1200, 1, 16},
+		{"draw between equals", 1200, 1200, 0.5, 0},
+		{"loss between equals", 1200, 1200, 0, -16},
+		{"favourite wins", 1400, 1200, 1, 8},
+		{"underdog wins", 1200, 1400, 1, 24},
+		{"underdog loses", 1200, 1400, 0, -8},
+		{"underdog draws", 1200, 1400, 0.5, 8},
+		{"far stronger player wins", 2000, 1200, 1, 0},
+		{"far weaker player loses", 1200, 2000, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := duelRatingChange(tt.rating, tt.opponentRating, tt.result); got != tt.want {
+				t.Errorf("duelRatingChange(%d, %d, %v) = %d, want %d", tt.rating, tt.opponentRating, tt.result, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDuelRatingChangeIsZeroSum(t *testing.T) {
+	for _, ratings := range [][2]int{{1200, 1200}, {1350, 1200}, {1000, 1600}} {
+		for _, result := range []float64{0, 0.5, 1} {
+			a := duelRatingChange(ratings[0], ratings[1], result)
+			b := duelRatingChange(ratings[1], ratings[0], 1-result)
+			if a+b != 0 {
+				t.Errorf("ratings %v, result %v: changes %d and %d don't cancel out", ratings, result, a, b)
+			}
+		}
+	}
+}
+
+func TestLockDuel(t *testing.T) {
+	var wg sync.WaitGroup
+	inside := 0
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := lockDuel([]string{"recA", "recB"}[i%2])
+			defer unlock()
+			if i%2 == 0 {
+				inside++ // only ever changed with recA locked
+			}
+		}()
+	}
+	wg.Wait()
+
+	if inside != 25 {
+		t.Errorf("%d of 25 increments made it", inside)
+	}
+	duelLocksMu.Lock()
+	defer duelLocksMu.Unlock()
+	if len(duelLocks) != 0 {
+		t.Errorf("%d duel locks left after every holder unlocked", len(duelLocks))
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP configuration for outgoing email
+var (
+	smtpHost     string
+	smtpPort     string
+	smtpUsername string
+	smtpPassword string
+	smtpFrom     string
+	appBaseURL   string
+)
+
+const digestInterval = 7 * 24 * time.Hour
+
+func initMailer() {
+	smtpHost = appConfig.SMTPHost
+	smtpPort = appConfig.SMTPPort
+	smtpUsername = appConfig.SMTPUsername
+	smtpPassword = appConfig.SMTPPassword
+	smtpFrom = appConfig.SMTPFrom
+	appBaseURL = appConfig.AppBaseURL
+
+	if smtpHost == "" || smtpFrom == "" {
+		log.Println("Warning: SMTP_HOST or SMTP_FROM not set. Email notifications will be disabled.")
+		smtpHost = ""
+		return
+	}
+	log.Println("SMTP mailer initialized.")
+}
+
+func mailerEnabled() bool {
+	return smtpHost != ""
+}
+
+func sendEmail(to, subject, body string) error {
+	if !mailerEnabled() {
+		return fmt.Errorf("email is not configured")
+	}
+
+	var auth smtp.Auth
+	if smtpUsername != "" {
+		auth = smtp.PlainAuth("", smtpUsername, smtpPassword, smtpHost)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + smtpFrom,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(smtpHost+":"+smtpPort, auth, smtpFrom, []string{to}, []byte(msg))
+}
+
+// WeeklyDigest summarises a user's last week of practice.
+type WeeklyDigest struct {
+	Exercises        int
+	Sessions         int
+	Accuracy         float64
+	PreviousAccuracy float64
+	Streak           int
+	DueNow           int
+	DueThisWeek      int
+}
+
+func accuracy(sessions []*Session) float64 {
+	exercises, mistakes := 0, 0
+	for _, s := range sessions {
+		exercises += s.Exercises
+		mistakes += s.Mistakes
+	}
+	if exercises == 0 {
+		return 0
+	}
+	return max(0, float64(exercises-mistakes)/float64(exercises)*100)
+}
+
+func buildWeeklyDigest(userID string, now time.Time) (*WeeklyDigest, error) {
+	sessions, err := getUserSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	views, err := dataStore.GetUserExerciseViews(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var thisWeek, lastWeek []*Session
+	for _, s := range sessions {
+		age := now.Sub(s.CompletedAt)
+		if age < digestInterval {
+			thisWeek = append(thisWeek, s)
+		} else if age < 2*digestInterval {
+			lastWeek = append(lastWeek, s)
+		}
+	}
+
+	digest := &WeeklyDigest{
+		Sessions:         len(thisWeek),
+		Accuracy:         accuracy(thisWeek),
+		PreviousAccuracy: accuracy(lastWeek),
+		Streak:           currentStreak(sessions, now),
+	}
+	for _, s := range thisWeek {
+		digest.Exercises += s.Exercises
+	}
+	for _, view := range views {
+		if isDueForReview(view, now) {
+			digest.DueNow++
+		} else if isDueForReview(view, now.Add(digestInterval)) {
+			digest.DueThisWeek++
+		}
+	}
+	return digest, nil
+}
+
+func renderDigestEmail(d *WeeklyDigest, unsubscribeURL string) string {
+	var b strings.Builder
+	b.WriteString("Here is your German practice summary for the past week.\n\n")
+	fmt.Fprintf(&b, "Exercises completed: %d (in %d sessions)\n", d.Exercises, d.Sessions)
+	if d.Exercises > 0 {
+		trend := "steady"
+		if d.PreviousAccuracy > 0 && d.Accuracy > d.PreviousAccuracy+1 {
+			trend = "up"
+		} else if d.PreviousAccuracy > 0 && d.Accuracy < d.PreviousAccuracy-1 {
+			trend = "down"
+		}
+		fmt.Fprintf(&b, "Accuracy: %.0f%% (%s from %.0f%% the week before)\n", d.Accuracy, trend, d.PreviousAccuracy)
+	}
+	if d.Streak > 0 {
+		fmt.Fprintf(&b, "Current streak: %d days. Keep it going!\n", d.Streak)
+	} else {
+		b.WriteString("Current streak: none yet. A short session today starts a new one.\n")
+	}
+	fmt.Fprintf(&b, "Reviews due now: %d\n", d.DueNow)
+	fmt.Fprintf(&b, "Reviews coming due this week: %d\n\n", d.DueThisWeek)
+	fmt.Fprintf(&b, "Practice now: %s/\n\n", appBaseURL)
+	fmt.Fprintf(&b, "To stop receiving these emails, unsubscribe here: %s\n", unsubscribeURL)
+	return b.String()
+}
+
+// sendWeeklyDigests emails every opted-in user whose last digest is at least a week old.
+func sendWeeklyDigests() {
+	subscribers, err := findNotificationSettings("{WeeklyDigest}")
+	if err != nil {
+		log.Printf("Warning: failed to load digest subscribers: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, settings := range subscribers {
+		// Due during quiet hours, it goes out with the first run after they end
+		if now.Sub(settings.LastDigestSentAt) < digestInterval || settings.inQuietHours(now) {
+			continue
+		}
+
+		user, err := dataStore.GetUserByID(settings.UserID)
+		if err != nil || user == nil || user.Email == "" {
+			continue
+		}
+
+		digest, err := buildWeeklyDigest(settings.UserID, now)
+		if err != nil {
+			log.Printf("Warning: failed to build digest for user %s: %v", settings.UserID, err)
+			continue
+		}
+
+		if settings.UnsubscribeToken == "" {
+			settings.UnsubscribeToken = newRandomToken()
+		}
+		unsubscribeURL := fmt.Sprintf("%s/api/notifications/unsubscribe?token=%s", appBaseURL, settings.UnsubscribeToken)
+		if err := sendEmail(user.Email, "Your weekly German practice summary", renderDigestEmail(digest, unsubscribeURL)); err != nil {
+			log.Printf("Warning: failed to send digest to user %s: %v", settings.UserID, err)
+			continue
+		}
+
+		settings.LastDigestSentAt = now
+		if err := saveNotificationSettings(settings); err != nil {
+			log.Printf("Warning: failed to record digest for user %s: %v", settings.UserID, err)
+		}
+	}
+}
+
+// startDigestScheduler checks hourly for users due a weekly digest.
+func startDigestScheduler() {
+	if !mailerEnabled() {
+		return
+	}
+	go func() {
+		for {
+			if featureEnabled(flagWeeklyDigest) {
+				sendWeeklyDigests()
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
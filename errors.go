@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// Every error response has the same shape so clients can handle failures programmatically:
+//
+//	{"error": {"code": "not_found", "message": "Topic not found", "request_id": "9f86d081884c7d65"}}
+//
+// code is stable and machine-readable, message is for people. request_id is also sent in the
+// X-Request-ID header of every response and appears in the server log for server errors.
+type APIError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	Details   any    `json:"details,omitempty"`
+}
+
+type errorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// Default error codes by status. Use writeAPIError for a more specific code.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "gone",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnprocessableEntity:   "unprocessable_entity",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusNotImplemented:        "not_implemented",
+	http.StatusBadGateway:            "upstream_error",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusGatewayTimeout:        "upstream_timeout",
+}
+
+func errorCodeForStatus(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return "internal_error"
+	}
+	return "error"
+}
+
+// writeError replies with a JSON error. It takes the same arguments as http.Error.
+func writeError(w http.ResponseWriter, message string, status int) {
+	writeAPIError(w, status, APIError{Code: errorCodeForStatus(status), Message: message})
+}
+
+// writeAPIError replies with a JSON error, filling in the request ID.
+func writeAPIError(w http.ResponseWriter, status int, apiErr APIError) {
+	apiErr.RequestID = w.Header().Get(requestIDHeader)
+	apiErr.Message = strings.TrimSpace(apiErr.Message)
+	// 503 means a feature is unavailable by configuration, not that something broke
+	if status >= 500 && status != http.StatusServiceUnavailable {
+		log.Printf("Error %d (request %s): %s", status, apiErr.RequestID, apiErr.Message)
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: apiErr})
+}
+
+// statusError carries the HTTP status for a failure in logic shared by the REST handlers and
+// the gRPC service, which maps the status to a gRPC code.
+type statusError struct {
+	status  int
+	message string
+	code    string // overrides the status's default error code
+	details any
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func errorWithStatus(status int, format string, args ...any) error {
+	return &statusError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// writeStatusError replies with the status of a statusError, or 500 for any other error.
+func writeStatusError(w http.ResponseWriter, err error) {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.code
+		if code == "" {
+			code = errorCodeForStatus(statusErr.status)
+		}
+		writeAPIError(w, statusErr.status, APIError{Code: code, Message: statusErr.message, Details: statusErr.details})
+		return
+	}
+	writeError(w, err.Error(), http.StatusInternalServerError)
+}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withRequestID gives every request an ID, reusing a well-formed X-Request-ID from a proxy,
+// and echoes it in the response so error reports can be matched to the server log.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			// With tracing on, the trace ID doubles as the request ID
+			if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.HasTraceID() {
+				requestID = spanContext.TraceID().String()
+			} else {
+				requestID = newRequestID()
+			}
+		}
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("request.id", requestID))
+		w.Header().Set(requestIDHeader, requestID)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Exercise images illustrate an exercise's English hint, for visual learners and to tie new
+// words to what they mean. GET /api/exercises/{id}/image serves an exercise's image: one an
+// admin attached by URL (a stock photo, say), or else one generated by IMAGE_MODEL the first
+// time it is asked for. Generated images are kept in the BACKUP_S3_* bucket under
+// IMAGE_S3_PREFIX, or in memory with in-memory storage, and the ExerciseImages table records
+// which exercise has which image. Like explanations, a generated image belongs to the sentence
+// it was made for: editing the exercise generates a new one. Attached images are kept.
+
+const imagePrompt = `A simple, friendly illustration of this scene for a language learner: %s. No text, letters or captions in the image.`
+
+const (
+	imageSourceGenerated = "generated"
+	imageSourceURL       = "url"
+)
+
+// ExerciseImage is the image of an exercise, generated or attached.
+type ExerciseImage struct {
+	ID          string    `json:"-"`
+	ExerciseID  string    `json:"exercise_id"`
+	Sentence    string    `json:"sentence,omitempty"` // the sentence a generated image was made for
+	Source      string    `json:"source"`             // imageSourceGenerated or imageSourceURL
+	URL         string    `json:"url,omitempty"`      // of an attached image
+	Key         string    `json:"-"`                  // where a generated image is stored
+	ContentType string    `json:"content_type,omitempty"`
+	Model       string    `json:"model,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+var (
+	exerciseImagesMutex   sync.Mutex
+	memoryExerciseImages  = make(map[string]*ExerciseImage) // by exercise ID, with in-memory storage
+	memoryImageData       = make(map[string][]byte)         // generated images by key, with in-memory storage
+	exerciseImagesPending = make(map[string]chan struct{})  // exercise IDs whose image is being generated
+)
+
+func exerciseImageFromRecord(record *airtable.Record) *ExerciseImage {
+	image := &ExerciseImage{ID: record.ID}
+	if val, ok := record.Fields["ExerciseID"].(string); ok {
+		image.ExerciseID = val
+	}
+	if val, ok := record.Fields["Sentence"].(string); ok {
+		image.Sentence = val
+	}
+	if val, ok := record.Fields["Source"].(string); ok {
+		image.Source = val
+	}
+	if val, ok := record.Fields["URL"].(string); ok {
+		image.URL = val
+	}
+	if val, ok := record.Fields["Key"].(string); ok {
+		image.Key = val
+	}
+	if val, ok := record.Fields["ContentType"].(string); ok {
+		image.ContentType = val
+	}
+	if val, ok := record.Fields["Model"].(string); ok {
+		image.Model = val
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			image.CreatedAt = t
+		}
+	}
+	return image
+}
+
+// getExerciseImage returns the image of an exercise, or nil if it has none.
+func getExerciseImage(exerciseID string) (*ExerciseImage, error) {
+	if airtableBaseID == "" {
+		exerciseImagesMutex.Lock()
+		defer exerciseImagesMutex.Unlock()
+		if image, ok := memoryExerciseImages[exerciseID]; ok {
+			c := *image
+			return &c, nil
+		}
+		return nil, nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, exerciseImagesTableName)
+	records, err := table.GetRecords().
+		WithFilterFormula(fmt.Sprintf("{ExerciseID} = '%s'", exerciseID)).
+		MaxRecords(1).
+		Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exercise image from Airtable: %v", err)
+	}
+	if len(records.Records) == 0 {
+		return nil, nil
+	}
+	return exerciseImageFromRecord(records.Records[0]), nil
+}
+
+// saveExerciseImage stores an image, replacing the exercise's previous one.
+func saveExerciseImage(image *ExerciseImage, previous *ExerciseImage) error {
+	if airtableBaseID == "" {
+		exerciseImagesMutex.Lock()
+		defer exerciseImagesMutex.Unlock()
+		c := *image
+		memoryExerciseImages[image.ExerciseID] = &c
+		return nil
+	}
+
+	fields := map[string]any{
+		"ExerciseID":  image.ExerciseID,
+		"Sentence":    image.Sentence,
+		"Source":      image.Source,
+		"URL":         image.URL,
+		"Key":         image.Key,
+		"ContentType": image.ContentType,
+		"Model":       image.Model,
+		"CreatedAt":   image.CreatedAt.Format(time.RFC3339),
+	}
+	table := airtableClient.GetTable(airtableBaseID, exerciseImagesTableName)
+	records := &airtable.Records{Records: []*airtable.Record{{Fields: fields}}}
+	var err error
+	if previous != nil {
+		records.Records[0].ID = previous.ID
+		_, err = table.UpdateRecords(records)
+	} else {
+		_, err = table.AddRecords(records)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save exercise image in Airtable: %v", err)
+	}
+	return nil
+}
+
+// deleteExerciseImage removes an exercise's image and, if it was generated, its data.
+func deleteExerciseImage(image *ExerciseImage) error {
+	if airtableBaseID == "" {
+		exerciseImagesMutex.Lock()
+		delete(memoryExerciseImages, image.ExerciseID)
+		exerciseImagesMutex.Unlock()
+	} else {
+		table := airtableClient.GetTable(airtableBaseID, exerciseImagesTableName)
+		if _, err := table.DeleteRecords([]string{image.ID}); err != nil {
+			return fmt.Errorf("failed to delete exercise image from Airtable: %v", err)
+		}
+	}
+	dropImageData(image.Key)
+	return nil
+}
+
+// imageGenerationAvailable reports whether images can be generated and stored.
+func imageGenerationAvailable() bool {
+	return appConfig.ImageModel != "" && !offlineMode() && (airtableBaseID == "" || s3Enabled())
+}
+
+// storeImageData keeps a generated image and returns its key.
+func storeImageData(exerciseID string, data []byte, contentType string) (string, error) {
+	key := fmt.Sprintf("%s%s-%d", appConfig.ImageS3Prefix, exerciseID, time.Now().UnixNano())
+	if airtableBaseID == "" {
+		exerciseImagesMutex.Lock()
+		memoryImageData[key] = data
+		exerciseImagesMutex.Unlock()
+		return key, nil
+	}
+	if err := s3PutObject(key, data, contentType); err != nil {
+		return "", fmt.Errorf("failed to store image: %v", err)
+	}
+	return key, nil
+}
+
+// dropImageData deletes a generated image that nothing points at any more. Failures are
+// only logged: the image is not served either way.
+func dropImageData(key string) {
+	if key == "" {
+		return
+	}
+	if airtableBaseID == "" {
+		exerciseImagesMutex.Lock()
+		delete(memoryImageData, key)
+		exerciseImagesMutex.Unlock()
+		return
+	}
+	if s3Enabled() {
+		if err := s3DeleteObject(key); err != nil {
+			log.Printf("Warning: failed to delete image %s: %v", key, err)
+		}
+	}
+}
+
+// readImageData returns a generated image.
+func readImageData(image *ExerciseImage) ([]byte, error) {
+	if airtableBaseID == "" {
+		exerciseImagesMutex.Lock()
+		defer exerciseImagesMutex.Unlock()
+		data, ok := memoryImageData[image.Key]
+		if !ok {
+			return nil, fmt.Errorf("image %s not found", image.Key)
+		}
+		return data, nil
+	}
+	if !s3Enabled() {
+		return nil, fmt.Errorf("image storage is not configured")
+	}
+	return s3GetObject(image.Key)
+}
+
+// generateImage asks the model for an illustration of an exercise's English hint.
+func generateImage(ctx context.Context, ex *Exercise) (data []byte, err error) {
+	if offlineMode() {
+		return nil, errOfflineMode
+	}
+	llm := llmProviderFor(ctx)
+	ctx, end := startSpan(ctx, "generate exercise image", attribute.String("exercise.id", ex.AirtableID), attribute.String("llm.model", appConfig.ImageModel))
+	defer func() { end(err) }()
+
+	body := map[string]any{
+		"model":  appConfig.ImageModel,
+		"prompt": fmt.Sprintf(imagePrompt, ex.TranslationHint),
+		"n":      1,
+		"size":   appConfig.ImageSize,
+	}
+	if !strings.HasPrefix(appConfig.ImageModel, "gpt-image") {
+		body["response_format"] = "b64_json" // gpt-image models always answer with base64
+	}
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image request body: %w", err)
+	}
+
+	apiReq, err := http.NewRequestWithContext(ctx, "POST", llm.URL+"/images/generations", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API request for image: %w", err)
+	}
+	apiReq.Header.Set("Content-Type", "application/json")
+	apiReq.Header.Set("Authorization", "Bearer "+llm.APIKey)
+
+	resp, err := llmHTTPClient.Do(apiReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call OpenAI API for image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API response for image: %w", err)
+	}
+	var imageResp struct {
+		Data []struct {
+			B64JSON string `json:"b64_json"`
+		} `json:"data"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error,omitempty"`
+	}
+	if err := json.Unmarshal(respBody, &imageResp); err != nil {
+		return nil, fmt.Errorf("failed to parse API response for image: %w", err)
+	}
+	if imageResp.Error != nil {
+		return nil, fmt.Errorf("API error during image generation: %s", imageResp.Error.Message)
+	}
+	if len(imageResp.Data) == 0 || imageResp.Data[0].B64JSON == "" {
+		return nil, fmt.Errorf("received an empty response from the image API")
+	}
+	data, err = base64.StdEncoding.DecodeString(imageResp.Data[0].B64JSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return data, nil
+}
+
+// imageCurrent reports whether an exercise's image still fits it.
+func imageCurrent(image *ExerciseImage, ex *Exercise) bool {
+	return image != nil && (image.Source == imageSourceURL || image.Sentence == ex.Sentence)
+}
+
+// illustrateExercise generates and stores an image for an exercise, replacing one made for
+// an earlier sentence. Concurrent requests for one exercise wait for a single generation,
+// which counts against the quota of the user who asked for it.
+func illustrateExercise(ctx context.Context, ex *Exercise, userID string) (*ExerciseImage, error) {
+	for {
+		cached, err := getExerciseImage(ex.AirtableID)
+		if err != nil {
+			return nil, err
+		}
+		if imageCurrent(cached, ex) {
+			return cached, nil
+		}
+
+		exerciseImagesMutex.Lock()
+		pending, ok := exerciseImagesPending[ex.AirtableID]
+		if !ok {
+			exerciseImagesPending[ex.AirtableID] = make(chan struct{})
+		}
+		exerciseImagesMutex.Unlock()
+		if ok {
+			select {
+			case <-pending:
+				continue // look again: the image is stored now, unless generating it failed
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		return func() (*ExerciseImage, error) {
+			defer func() {
+				exerciseImagesMutex.Lock()
+				close(exerciseImagesPending[ex.AirtableID])
+				delete(exerciseImagesPending, ex.AirtableID)
+				exerciseImagesMutex.Unlock()
+			}()
+			if offlineMode() {
+				return nil, errOfflineMode
+			}
+			if err := useModelQuota(ctx, userID); err != nil {
+				return nil, err
+			}
+			data, err := generateImage(ctx, ex)
+			if err != nil {
+				return nil, err
+			}
+			contentType := http.DetectContentType(data)
+			key, err := storeImageData(ex.AirtableID, data, contentType)
+			if err != nil {
+				return nil, err
+			}
+			image := &ExerciseImage{
+				ExerciseID:  ex.AirtableID,
+				Sentence:    ex.Sentence,
+				Source:      imageSourceGenerated,
+				Key:         key,
+				ContentType: contentType,
+				Model:       appConfig.ImageModel,
+				CreatedAt:   time.Now().UTC(),
+			}
+			if err := saveExerciseImage(image, cached); err != nil {
+				return nil, err
+			}
+			if cached != nil {
+				dropImageData(cached.Key)
+			}
+			return image, nil
+		}()
+	}
+}
+
+// serveExerciseImage writes an image: a redirect for attached images, the data for generated ones.
+func serveExerciseImage(w http.ResponseWriter, r *http.Request, image *ExerciseImage) {
+	if image.Source == imageSourceURL {
+		http.Redirect(w, r, image.URL, http.StatusFound)
+		return
+	}
+	data, err := readImageData(image)
+	if err != nil {
+		log.Printf("Error reading image of exercise %s: %v", image.ExerciseID, err)
+		writeError(w, "Failed to read the image", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", image.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(data)
+}
+
+// Handle exercise images, for users and guests: GET /api/exercises/{id}/image serves the
+// image, generating it on first request when IMAGE_MODEL is set. Exercises without an image
+// answer 404.
+func handleExerciseImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	exerciseID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/exercises/"), "/image")
+
+	ex, err := exerciseByID(exerciseID)
+	if err != nil {
+		writeError(w, "Exercise not found", http.StatusNotFound)
+		return
+	}
+	image, err := getExerciseImage(ex.AirtableID)
+	if err != nil {
+		log.Printf("Error getting image of exercise %s: %v", exerciseID, err)
+		writeError(w, "Failed to get the image", http.StatusInternalServerError)
+		return
+	}
+	if imageCurrent(image, ex) {
+		serveExerciseImage(w, r, image)
+		return
+	}
+	if !imageGenerationAvailable() || ex.TranslationHint == "" {
+		writeError(w, "Exercise has no image", http.StatusNotFound)
+		return
+	}
+
+	rateLimited("images", func(w http.ResponseWriter, r *http.Request) {
+		image, err := illustrateExercise(r.Context(), ex, getUserIDFromRequest(r))
+		var statusErr *statusError
+		if errors.Is(err, errOfflineMode) {
+			writeOfflineError(w)
+			return
+		}
+		if errors.As(err, &statusErr) {
+			writeStatusError(w, err)
+			return
+		}
+		if err != nil {
+			log.Printf("Error generating image of exercise %s: %v", exerciseID, err)
+			writeError(w, "Failed to generate an image", http.StatusBadGateway)
+			return
+		}
+		serveExerciseImage(w, r, image)
+	})(w, r)
+}
+
+// Handle exercise images (admin): GET /api/admin/exercises/{id}/image returns the image's
+// details, PUT with {"url": "https://..."} attaches an image, and DELETE removes the image,
+// so the next request generates a new one if IMAGE_MODEL is set.
+func handleAdminExerciseImage(w http.ResponseWriter, r *http.Request, exerciseID string) {
+	ex, err := exerciseByID(exerciseID)
+	if err != nil {
+		writeError(w, "Exercise not found", http.StatusNotFound)
+		return
+	}
+	existing, err := getExerciseImage(ex.AirtableID)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get exercise image: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if existing == nil {
+			writeError(w, "Exercise has no image", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(existing)
+
+	case http.MethodPut:
+		var req struct {
+			URL string `json:"url"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.URL = strings.TrimSpace(req.URL)
+		if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			writeError(w, "url must be an http or https URL", http.StatusBadRequest)
+			return
+		}
+		image := &ExerciseImage{
+			ExerciseID: ex.AirtableID,
+			Source:     imageSourceURL,
+			URL:        req.URL,
+			CreatedAt:  time.Now().UTC(),
+		}
+		if err := saveExerciseImage(image, existing); err != nil {
+			writeError(w, fmt.Sprintf("Failed to save exercise image: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			dropImageData(existing.Key)
+		}
+		recordAudit(r, auditExerciseImageSet, "exercise", ex.AirtableID, existing, image)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(image)
+
+	case http.MethodDelete:
+		if existing == nil {
+			writeError(w, "Exercise has no image", http.StatusNotFound)
+			return
+		}
+		if err := deleteExerciseImage(existing); err != nil {
+			writeError(w, fmt.Sprintf("Failed to delete exercise image: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, auditExerciseImageDelete, "exercise", ex.AirtableID, existing, nil)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ExerciseContent is the typed form of an exercise. The exercise JSON stays what the
+// frontend renders; these fields are stored next to it as columns so exercises can be
+// validated, searched, deduplicated and edited field by field. The correct sentence is
+// the answer: Tokens is that sentence split the way the frontend checks it. Distractors are
+// wrong words (a wrong article, a wrong verb form) mixed into the word bank of users who opt
+// in. AlternativeSentences are other valid orderings of the same words ("Heute gehe ich..."
+// for "Ich gehe heute..."), accepted as answers too. Both are only kept in the exercise JSON.
+type ExerciseContent struct {
+	Sentence             string   `json:"sentence"`
+	TranslationHint      string   `json:"translation_hint"`
+	Tokens               []string `json:"tokens"`
+	Conjunction          string   `json:"conjunction,omitempty"`
+	Distractors          []string `json:"distractors,omitempty"`
+	AlternativeSentences []string `json:"alternative_sentences,omitempty"`
+}
+
+const (
+	maxDistractors          = 4
+	maxAlternativeSentences = 5
+)
+
+// Same tokenization as app.js: words (with apostrophes) and single punctuation marks.
+var sentenceTokenPattern = regexp.MustCompile(`[\p{L}\p{N}']+|[^\s\p{L}\p{N}]`)
+
+var wordTokenPattern = regexp.MustCompile(`^[\p{L}\p{N}']+$`)
+
+func tokenizeSentence(sentence string) []string {
+	return sentenceTokenPattern.FindAllString(sentence, -1)
+}
+
+// parseExerciseContent validates an exercise's JSON and extracts its typed fields.
+func parseExerciseContent(exerciseJSON string) (*ExerciseContent, error) {
+	var ex struct {
+		EnglishHint           string          `json:"english_hint"`
+		CorrectGermanSentence string          `json:"correct_german_sentence"`
+		ConjunctionTopic      string          `json:"conjunction_topic"`
+		Distractors           json.RawMessage `json:"distractors"`
+		AlternativeSentences  json.RawMessage `json:"alternative_sentences"`
+	}
+	if err := json.Unmarshal([]byte(exerciseJSON), &ex); err != nil {
+		return nil, fmt.Errorf("exercise must be a JSON object: %v", err)
+	}
+
+	content := &ExerciseContent{
+		Sentence:        strings.Join(strings.Fields(ex.CorrectGermanSentence), " "),
+		TranslationHint: strings.TrimSpace(ex.EnglishHint),
+		Conjunction:     strings.TrimSpace(ex.ConjunctionTopic),
+	}
+	if content.TranslationHint == "" || content.Sentence == "" {
+		return nil, fmt.Errorf("exercise requires english_hint and correct_german_sentence")
+	}
+
+	content.Tokens = tokenizeSentence(content.Sentence)
+	words := 0
+	for _, token := range content.Tokens {
+		if wordTokenPattern.MatchString(token) {
+			words++
+		}
+	}
+	if words < 2 {
+		return nil, fmt.Errorf("correct_german_sentence must have at least two words to scramble")
+	}
+	content.Distractors = cleanDistractors(parseStringList(ex.Distractors), content.Sentence)
+	content.AlternativeSentences = cleanAlternativeSentences(parseStringList(ex.AlternativeSentences), content.Sentence)
+	return content, nil
+}
+
+// parseDistractors reads an exercise's distractors field, ignoring it if it isn't a list of words.
+func parseStringList(raw json.RawMessage) []string {
+	var distractors []string
+	json.Unmarshal(raw, &distractors)
+	return distractors
+}
+
+// cleanDistractors keeps the usable distractors: single words, not in the sentence (ignoring
+// case, so a distractor never fits), without duplicates and at most maxDistractors of them.
+// Models don't always follow instructions, so anything else is dropped rather than rejected.
+func cleanDistractors(distractors []string, sentence string) []string {
+	taken := make(map[string]bool)
+	for _, word := range sentenceWords(sentence) {
+		taken[strings.ToLower(word)] = true
+	}
+	var cleaned []string
+	for _, word := range distractors {
+		word = strings.TrimSpace(word)
+		if !wordTokenPattern.MatchString(word) || taken[strings.ToLower(word)] {
+			continue
+		}
+		taken[strings.ToLower(word)] = true
+		cleaned = append(cleaned, word)
+		if len(cleaned) == maxDistractors {
+			break
+		}
+	}
+	return cleaned
+}
+
+// cleanAlternativeSentences keeps the usable alternative orderings: sentences with exactly
+// the sentence's words (ignoring case), since those are all the learner gets, in a different
+// order than the sentence and each other, and at most maxAlternativeSentences of them. Like
+// distractors, anything else is dropped rather than rejected.
+func cleanAlternativeSentences(alternatives []string, sentence string) []string {
+	words := sentenceWords(sentence)
+	seen := map[string]bool{strings.ToLower(strings.Join(words, " ")): true}
+	var cleaned []string
+	for _, alternative := range alternatives {
+		alternative = strings.Join(strings.Fields(alternative), " ")
+		altWords := sentenceWords(alternative)
+		key := strings.ToLower(strings.Join(altWords, " "))
+		if !sameWords(altWords, words) || seen[key] {
+			continue
+		}
+		seen[key] = true
+		cleaned = append(cleaned, alternative)
+		if len(cleaned) == maxAlternativeSentences {
+			break
+		}
+	}
+	return cleaned
+}
+
+// dedupKey identifies exercises with the same sentence, ignoring case and punctuation.
+func (c *ExerciseContent) dedupKey() string {
+	var words []string
+	for _, token := range c.Tokens {
+		if wordTokenPattern.MatchString(token) {
+			words = append(words, strings.ToLower(token))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// matches reports whether the sentence, hint or conjunction contains the query (case-insensitive).
+func (c *ExerciseContent) matches(query string) bool {
+	query = strings.ToLower(query)
+	return strings.Contains(strings.ToLower(c.Sentence), query) ||
+		strings.Contains(strings.ToLower(c.TranslationHint), query) ||
+		strings.Contains(strings.ToLower(c.Conjunction), query)
+}
+
+// withExerciseContent writes the typed fields back into an exercise's JSON, keeping any
+// other keys the model produced. Tokens are derived from the sentence.
+func withExerciseContent(exerciseJSON string, content *ExerciseContent) (string, error) {
+	fields := make(map[string]any)
+	if err := json.Unmarshal([]byte(exerciseJSON), &fields); err != nil {
+		return "", fmt.Errorf("exercise must be a JSON object: %v", err)
+	}
+	fields["correct_german_sentence"] = content.Sentence
+	fields["english_hint"] = content.TranslationHint
+	if content.Conjunction != "" {
+		fields["conjunction_topic"] = content.Conjunction
+	} else {
+		delete(fields, "conjunction_topic")
+	}
+	if distractors := cleanDistractors(content.Distractors, content.Sentence); len(distractors) > 0 {
+		fields["distractors"] = distractors
+	} else {
+		delete(fields, "distractors")
+	}
+	if alternatives := cleanAlternativeSentences(content.AlternativeSentences, content.Sentence); len(alternatives) > 0 {
+		fields["alternative_sentences"] = alternatives
+	} else {
+		delete(fields, "alternative_sentences")
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// exerciseContentFields returns the Airtable columns for an exercise's typed fields.
+func exerciseContentFields(content *ExerciseContent) map[string]any {
+	return map[string]any{
+		"Sentence":        content.Sentence,
+		"TranslationHint": content.TranslationHint,
+		"Tokens":          strings.Join(content.Tokens, " "),
+		"Conjunction":     content.Conjunction,
+	}
+}
+
+// setContent copies typed fields onto an exercise.
+func (e *Exercise) setContent(content *ExerciseContent) {
+	e.Sentence = content.Sentence
+	e.TranslationHint = content.TranslationHint
+	e.Tokens = content.Tokens
+	e.Conjunction = content.Conjunction
+}
+
+// content returns an exercise's typed fields.
+func (e *Exercise) content() *ExerciseContent {
+	return &ExerciseContent{
+		Sentence:             e.Sentence,
+		TranslationHint:      e.TranslationHint,
+		Tokens:               e.Tokens,
+		Conjunction:          e.Conjunction,
+		Distractors:          e.distractors(),
+		AlternativeSentences: e.alternativeSentences(),
+	}
+}
+
+// distractors returns the exercise's usable distractors, read from its JSON.
+func (e *Exercise) distractors() []string {
+	var ex struct {
+		Distractors json.RawMessage `json:"distractors"`
+	}
+	json.Unmarshal([]byte(e.ExerciseJSON), &ex)
+	return cleanDistractors(parseStringList(ex.Distractors), e.Sentence)
+}
+
+// alternativeSentences returns the exercise's usable alternative orderings, read from its JSON.
+func (e *Exercise) alternativeSentences() []string {
+	var ex struct {
+		AlternativeSentences json.RawMessage `json:"alternative_sentences"`
+	}
+	json.Unmarshal([]byte(e.ExerciseJSON), &ex)
+	return cleanAlternativeSentences(parseStringList(ex.AlternativeSentences), e.Sentence)
+}
+
+// exerciseWithID returns the exercise JSON the frontend renders, with the exercise's ID
+// added so answers can be graded, and only usable distractors. Malformed JSON is returned
+// as stored.
+func exerciseWithID(e *Exercise) json.RawMessage {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(e.ExerciseJSON), &fields); err != nil {
+		return json.RawMessage(e.ExerciseJSON)
+	}
+	fields["id"], _ = json.Marshal(e.AirtableID)
+	if distractors := e.distractors(); len(distractors) > 0 {
+		fields["distractors"], _ = json.Marshal(distractors)
+	} else {
+		delete(fields, "distractors")
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return json.RawMessage(e.ExerciseJSON)
+	}
+	return data
+}
+
+// withoutFields returns a served exercise without the given keys. Malformed JSON is returned as it is.
+func withoutFields(raw json.RawMessage, keys ...string) json.RawMessage {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return raw
+	}
+	for _, key := range keys {
+		delete(fields, key)
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return raw
+	}
+	return data
+}
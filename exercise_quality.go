@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Exercise quality: learners flag exercises they think are broken, and the admin report
+// ranks the exercises that confuse everyone. Each exercise is rated from the answers of
+// every learner: how many last graded it "again" (the wrong-answer rate), how many needed
+// hints for it (the hint rate) and how often it was flagged. Rates only count once enough
+// learners answered the exercise, so one bad day doesn't mark it; flags always count.
+const (
+	maxFlagComment         = 500
+	minQualityLearners     = 3
+	defaultQualityLimit    = 20
+	maxQualityLimit        = 200
+	qualityWrongWeight     = 40 // quality scores: up to 40 for the wrong-answer rate,
+	qualityHintWeight      = 30 // up to 30 for the hint rate,
+	qualityFlagWeight      = 10 // and 10 per flag, up to qualityMaxFlagsCounted
+	qualityMaxFlagsCounted = 3
+)
+
+// Reasons learners can give for flagging an exercise.
+var exerciseFlagReasons = []string{"wrong_answer", "ambiguous", "bad_hint", "other"}
+
+// ExerciseFlag is a learner's report that an exercise is broken.
+type ExerciseFlag struct {
+	ID         string    `json:"id"`
+	OwnerID    string    `json:"-"`
+	ExerciseID string    `json:"exercise_id"`
+	Reason     string    `json:"reason"`
+	Comment    string    `json:"comment,omitempty"`
+	Verified   bool      `json:"-"` // counts toward retiring the exercise, see flagCounts
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ExerciseQuality is how an exercise fares with learners.
+type ExerciseQuality struct {
+	ExerciseID  string         `json:"exercise_id"`
+	TopicID     string         `json:"topic_id"`
+	Sentence    string         `json:"sentence"`
+	Learners    int            `json:"learners"` // learners who answered it
+	Wrong       int            `json:"wrong"`    // of those, last graded "again"
+	WrongRate   float64        `json:"wrong_rate"`
+	Hinted      int            `json:"hinted"`    // of those, needed hints
+	Hints       int            `json:"hints"`     // hinted words, in total
+	HintRate    float64        `json:"hint_rate"` // hinted learners per learner
+	Flags       int            `json:"flags"`
+	Verified    int            `json:"verified_flags"` // of those, from learners who were served it
+	FlagReasons map[string]int `json:"flag_reasons,omitempty"`
+	Comments    []string       `json:"comments,omitempty"` // of the flags, newest first
+	Score       int            `json:"score"`              // 0 to 100, worst first
+	Status      string         `json:"status,omitempty"`   // retired or kept (see exercise_retirement.go)
+	exercise    *Exercise
+}
+
+var (
+	exerciseFlagsMutex  sync.Mutex
+	memoryExerciseFlags []*ExerciseFlag // with in-memory storage
+)
+
+func exerciseFlagFromRecord(record *airtable.Record) *ExerciseFlag {
+	flag := &ExerciseFlag{ID: record.ID}
+	if val, ok := record.Fields["OwnerID"].(string); ok {
+		flag.OwnerID = val
+	}
+	if val, ok := record.Fields["ExerciseID"].(string); ok {
+		flag.ExerciseID = val
+	}
+	if val, ok := record.Fields["Reason"].(string); ok {
+		flag.Reason = val
+	}
+	if val, ok := record.Fields["Comment"].(string); ok {
+		flag.Comment = val
+	}
+	if val, ok := record.Fields["Verified"].(bool); ok {
+		flag.Verified = val
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			flag.CreatedAt = t
+		}
+	}
+	return flag
+}
+
+// getExerciseFlags returns the flags of an exercise, or of every exercise if exerciseID is
+// empty, newest first.
+func getExerciseFlags(exerciseID string) ([]*ExerciseFlag, error) {
+	flags := []*ExerciseFlag{}
+	if airtableBaseID == "" {
+		exerciseFlagsMutex.Lock()
+		for _, flag := range memoryExerciseFlags {
+			if exerciseID == "" || flag.ExerciseID == exerciseID {
+				c := *flag
+				flags = append(flags, &c)
+			}
+		}
+		exerciseFlagsMutex.Unlock()
+	} else {
+		query := airtableClient.GetTable(airtableBaseID, exerciseFlagsTableName).GetRecords()
+		if exerciseID != "" {
+			query = query.WithFilterFormula(fmt.Sprintf("{ExerciseID} = '%s'", exerciseID))
+		}
+		records, err := getAllRecords(query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get exercise flags from Airtable: %v", err)
+		}
+		for _, record := range records.Records {
+			flags = append(flags, exerciseFlagFromRecord(record))
+		}
+	}
+	slices.SortFunc(flags, func(a, b *ExerciseFlag) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return flags, nil
+}
+
+// addExerciseFlag stores a flag.
+func addExerciseFlag(flag *ExerciseFlag) error {
+	if airtableBaseID == "" {
+		exerciseFlagsMutex.Lock()
+		defer exerciseFlagsMutex.Unlock()
+		flag.ID = fmt.Sprintf("flag%d", time.Now().UnixNano())
+		c := *flag
+		memoryExerciseFlags = append(memoryExerciseFlags, &c)
+		return nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, exerciseFlagsTableName)
+	result, err := table.AddRecords(&airtable.Records{Records: []*airtable.Record{{Fields: map[string]any{
+		"OwnerID":    flag.OwnerID,
+		"ExerciseID": flag.ExerciseID,
+		"Reason":     flag.Reason,
+		"Comment":    flag.Comment,
+		"Verified":   flag.Verified,
+		"CreatedAt":  flag.CreatedAt.Format(time.RFC3339),
+	}}}})
+	if err != nil {
+		return fmt.Errorf("failed to record exercise flag in Airtable: %v", err)
+	}
+	if len(result.Records) > 0 {
+		flag.ID = result.Records[0].ID
+	}
+	return nil
+}
+
+// flagCounts reports whether an owner's flag counts toward retiring an exercise: signed-in
+// users' flags do, and guests' only for exercises in their current set or answered before.
+// Every cookie-less request gets a new guest ID, so otherwise one client could flag any
+// exercise as many times as it takes to retire it.
+func flagCounts(ownerID, exerciseID string) bool {
+	if !strings.HasPrefix(ownerID, guestOwnerPrefix) {
+		return true
+	}
+	if views, err := dataStore.GetUserExerciseViews(ownerID); err == nil {
+		if _, ok := views[exerciseID]; ok {
+			return true
+		}
+	}
+	session, err := getCurrentSession(ownerID)
+	return err == nil && session != nil && slices.Contains(session.exerciseIDs(), exerciseID)
+}
+
+// Handle flags, for users and guests: POST /api/exercises/{id}/flag with
+// {"reason": "ambiguous", "comment": "..."} reports a broken exercise. Each learner flags an
+// exercise once; flagging it again returns the first flag.
+func handleExerciseFlag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	exerciseID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/exercises/"), "/flag")
+	var req struct {
+		Reason  string `json:"reason"`
+		Comment string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	reason := strings.ToLower(strings.TrimSpace(req.Reason))
+	if !slices.Contains(exerciseFlagReasons, reason) {
+		writeError(w, "reason must be one of "+strings.Join(exerciseFlagReasons, ", "), http.StatusBadRequest)
+		return
+	}
+	comment := strings.TrimSpace(req.Comment)
+	if utf8.RuneCountInString(comment) > maxFlagComment {
+		writeError(w, fmt.Sprintf("comment must be at most %d characters", maxFlagComment), http.StatusBadRequest)
+		return
+	}
+	ex, err := exerciseByID(exerciseID)
+	if err != nil {
+		writeError(w, "Exercise not found", http.StatusNotFound)
+		return
+	}
+
+	ownerID := getProgressOwnerID(w, r)
+	flags, err := getExerciseFlags(ex.AirtableID)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get flags: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if i := slices.IndexFunc(flags, func(f *ExerciseFlag) bool { return f.OwnerID == ownerID }); i >= 0 {
+		json.NewEncoder(w).Encode(flags[i])
+		return
+	}
+
+	flag := &ExerciseFlag{OwnerID: ownerID, ExerciseID: ex.AirtableID, Reason: reason, Comment: comment,
+		Verified: flagCounts(ownerID, ex.AirtableID), CreatedAt: time.Now().UTC()}
+	if err := addExerciseFlag(flag); err != nil {
+		log.Printf("Error flagging exercise %s: %v", ex.AirtableID, err)
+		writeError(w, "Failed to flag exercise", http.StatusInternalServerError)
+		return
+	}
+	notifyWebhooks(webhookExerciseFlagged, fmt.Sprintf("A learner flagged an exercise as %s: %q", reason, ex.Sentence),
+		map[string]any{"exercise_id": ex.AirtableID, "topic_id": ex.TopicID, "reason": reason, "comment": comment, "flags": len(flags) + 1})
+	if flag.Verified {
+		go retireIfFlagged(ex)
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(flag)
+}
+
+// qualityScore rates how badly an exercise confuses learners, from 0 to 100.
+func qualityScore(q *ExerciseQuality) int {
+	score := float64(qualityFlagWeight * min(q.Flags, qualityMaxFlagsCounted))
+	if q.Learners >= minQualityLearners {
+		score += qualityWrongWeight*q.WrongRate + qualityHintWeight*q.HintRate
+	}
+	return min(100, int(math.Round(score)))
+}
+
+// getExerciseQuality rates the cached exercises, of one topic if topicID is not empty, and
+// returns the ones with problems, worst first, and how many were rated.
+func getExerciseQuality(topicID string) ([]*ExerciseQuality, int, error) {
+	exercises, err := dataStore.ListExercises(topicID)
+	if err != nil {
+		return nil, 0, err
+	}
+	views, err := dataStore.ListUserExerciseViews()
+	if err != nil {
+		return nil, 0, err
+	}
+	hints, err := getExerciseHints("")
+	if err != nil {
+		return nil, 0, err
+	}
+	flags, err := getExerciseFlags("")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	byID := make(map[string]*ExerciseQuality, len(exercises))
+	for _, ex := range exercises {
+		if ex.Sentence == "" {
+			if content, err := parseExerciseContent(ex.ExerciseJSON); err == nil {
+				ex.setContent(content)
+			}
+		}
+		byID[ex.AirtableID] = &ExerciseQuality{ExerciseID: ex.AirtableID, TopicID: ex.TopicID, Sentence: ex.Sentence, exercise: ex}
+	}
+	for _, view := range views {
+		if q, ok := byID[view.ExerciseID]; ok {
+			q.Learners++
+			if view.Grade == gradeAgain {
+				q.Wrong++
+			}
+		}
+	}
+	hinted := make(map[string]bool) // exercise and owner
+	for _, hint := range hints {
+		if q, ok := byID[hint.ExerciseID]; ok {
+			q.Hints++
+			if key := hint.ExerciseID + "/" + hint.OwnerID; !hinted[key] {
+				hinted[key] = true
+				q.Hinted++
+			}
+		}
+	}
+	for _, flag := range flags {
+		if q, ok := byID[flag.ExerciseID]; ok {
+			q.Flags++
+			if flag.Verified {
+				q.Verified++
+			}
+			if q.FlagReasons == nil {
+				q.FlagReasons = make(map[string]int)
+			}
+			q.FlagReasons[flag.Reason]++
+			if flag.Comment != "" {
+				q.Comments = append(q.Comments, flag.Comment)
+			}
+		}
+	}
+
+	report := []*ExerciseQuality{}
+	for _, q := range byID {
+		if q.Learners > 0 {
+			q.WrongRate = math.Round(float64(q.Wrong)/float64(q.Learners)*100) / 100
+			// Learners who needed hints before a view was stored aren't counted as learners
+			q.HintRate = math.Round(math.Min(1, float64(q.Hinted)/float64(q.Learners))*100) / 100
+		}
+		if q.Score = qualityScore(q); q.Score > 0 {
+			report = append(report, q)
+		}
+	}
+	slices.SortFunc(report, func(a, b *ExerciseQuality) int {
+		if a.Score != b.Score {
+			return b.Score - a.Score
+		}
+		if a.Learners != b.Learners {
+			return b.Learners - a.Learners
+		}
+		return strings.Compare(a.ExerciseID, b.ExerciseID)
+	})
+	return report, len(exercises), nil
+}
+
+// Handle the quality report (admin): GET /api/admin/exercise-quality ranks the exercises
+// learners struggle with most, worst first. ?topic_id= limits it to one topic and ?limit=20
+// sets how many are listed.
+func handleAdminExerciseQuality(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := defaultQualityLimit
+	if val := r.URL.Query().Get("limit"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 1 || n > maxQualityLimit {
+			writeError(w, fmt.Sprintf("limit must be between 1 and %d", maxQualityLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	report, rated, err := getExerciseQuality(strings.TrimSpace(r.URL.Query().Get("topic_id")))
+	if err != nil {
+		log.Printf("Error getting exercise quality: %v", err)
+		writeError(w, "Failed to get exercise quality", http.StatusInternalServerError)
+		return
+	}
+	if retirements, err := getExerciseRetirements(); err == nil {
+		for _, q := range report {
+			if retirement, ok := retirements[q.ExerciseID]; ok {
+				q.Status = retirement.Status
+			}
+		}
+	} else {
+		log.Printf("Warning: failed to get exercise retirements: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"rated":        rated,
+		"with_issues":  len(report),
+		"min_learners": minQualityLearners,
+		"exercises":    report[:min(len(report), limit)],
+	})
+}
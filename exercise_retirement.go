@@ -0,0 +1,565 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Exercise retirement: the policy retires exercises that learners keep flagging
+// (EXERCISE_RETIRE_FLAGS) or getting wrong (EXERCISE_RETIRE_WRONG_RATE, a percentage of at
+// least EXERCISE_RETIRE_MIN_LEARNERS learners), as rated by the quality report (see
+// exercise_quality.go). Retired exercises stay cached, so answers to them still count and a
+// generated copy of the same sentence is still skipped, but they are no longer selected for
+// sets, challenges, duels or offline packs. With fewer exercises cached, the next request
+// for the set generates new ones; with EXERCISE_RETIRE_REPLACE the policy generates them
+// right away. Admins override the policy by retiring an exercise themselves or keeping one,
+// which the policy then leaves alone.
+const (
+	retirementRetired = "retired"
+	retirementKept    = "kept"
+
+	retiredByPolicy         = "policy"
+	retirementCheckInterval = time.Hour
+	retiredExercisesTTL     = 5 * time.Minute // how long the retired exercises are cached for selection
+)
+
+// ExerciseRetirement is a retired exercise, or one an admin kept.
+type ExerciseRetirement struct {
+	ID           string    `json:"id"`
+	ExerciseID   string    `json:"exercise_id"`
+	TopicID      string    `json:"topic_id"`
+	Status       string    `json:"status"` // retired or kept
+	Reason       string    `json:"reason,omitempty"`
+	RetiredBy    string    `json:"retired_by"`             // "policy", or the admin's user ID
+	Replacements int       `json:"replacements,omitempty"` // exercises generated in its place
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// RetirementPolicy is the configured policy; zero thresholds are off.
+type RetirementPolicy struct {
+	Enabled     bool `json:"enabled"`
+	MaxFlags    int  `json:"max_flags"`
+	MaxWrong    int  `json:"max_wrong_rate"` // percent
+	MinLearners int  `json:"min_learners"`
+	Replace     bool `json:"replace"`
+}
+
+// RetirementRun is the result of applying the policy.
+type RetirementRun struct {
+	DryRun       bool                  `json:"dry_run"`
+	Rated        int                   `json:"rated"`
+	Retired      []*ExerciseRetirement `json:"retired"`
+	Replacements int                   `json:"replacements"`
+}
+
+var (
+	retirementsMutex  sync.Mutex
+	memoryRetirements = make(map[string]*ExerciseRetirement) // by exercise ID, with in-memory storage
+
+	retiredExercises struct {
+		sync.Mutex
+		ids      map[string]bool
+		loadedAt time.Time
+	}
+	retirementRunning sync.Mutex // one policy run at a time
+)
+
+func retirementPolicy() RetirementPolicy {
+	policy := RetirementPolicy{
+		MaxFlags:    appConfig.ExerciseRetireFlags,
+		MaxWrong:    appConfig.ExerciseRetireWrongRate,
+		MinLearners: appConfig.ExerciseRetireMinLearners,
+		Replace:     appConfig.ExerciseRetireReplace,
+	}
+	policy.Enabled = policy.MaxFlags > 0 || policy.MaxWrong > 0
+	return policy
+}
+
+// retirementReason returns why the policy retires an exercise, or "" if it doesn't.
+func (p RetirementPolicy) retirementReason(q *ExerciseQuality) string {
+	if p.MaxFlags > 0 && q.Verified >= p.MaxFlags {
+		return fmt.Sprintf("flagged by %d learners", q.Verified)
+	}
+	if p.MaxWrong > 0 && q.Learners >= p.MinLearners && q.WrongRate*100 >= float64(p.MaxWrong) {
+		return fmt.Sprintf("%d of %d learners got it wrong", q.Wrong, q.Learners)
+	}
+	return ""
+}
+
+func exerciseRetirementFromRecord(record *airtable.Record) *ExerciseRetirement {
+	retirement := &ExerciseRetirement{ID: record.ID}
+	if val, ok := record.Fields["ExerciseID"].(string); ok {
+		retirement.ExerciseID = val
+	}
+	if val, ok := record.Fields["TopicID"].(string); ok {
+		retirement.TopicID = val
+	}
+	if val, ok := record.Fields["Status"].(string); ok {
+		retirement.Status = val
+	}
+	if val, ok := record.Fields["Reason"].(string); ok {
+		retirement.Reason = val
+	}
+	if val, ok := record.Fields["RetiredBy"].(string); ok {
+		retirement.RetiredBy = val
+	}
+	if val, ok := record.Fields["Replacements"].(float64); ok {
+		retirement.Replacements = int(val)
+	}
+	if val, ok := record.Fields["UpdatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			retirement.UpdatedAt = t
+		}
+	}
+	return retirement
+}
+
+// getExerciseRetirements returns the retired and kept exercises, by exercise ID.
+func getExerciseRetirements() (map[string]*ExerciseRetirement, error) {
+	retirements := make(map[string]*ExerciseRetirement)
+	if airtableBaseID == "" {
+		retirementsMutex.Lock()
+		defer retirementsMutex.Unlock()
+		for exerciseID, retirement := range memoryRetirements {
+			c := *retirement
+			retirements[exerciseID] = &c
+		}
+		return retirements, nil
+	}
+
+	records, err := getAllRecords(airtableClient.GetTable(airtableBaseID, exerciseRetirementsTableName).GetRecords())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exercise retirements from Airtable: %v", err)
+	}
+	for _, record := range records.Records {
+		retirement := exerciseRetirementFromRecord(record)
+		retirements[retirement.ExerciseID] = retirement
+	}
+	return retirements, nil
+}
+
+// saveExerciseRetirement creates or updates a retirement.
+func saveExerciseRetirement(retirement *ExerciseRetirement) error {
+	defer invalidateRetiredExercises()
+	if airtableBaseID == "" {
+		retirementsMutex.Lock()
+		defer retirementsMutex.Unlock()
+		if retirement.ID == "" {
+			retirement.ID = fmt.Sprintf("retirement%d", time.Now().UnixNano())
+		}
+		c := *retirement
+		memoryRetirements[retirement.ExerciseID] = &c
+		return nil
+	}
+
+	fields := map[string]any{
+		"ExerciseID":   retirement.ExerciseID,
+		"TopicID":      retirement.TopicID,
+		"Status":       retirement.Status,
+		"Reason":       retirement.Reason,
+		"RetiredBy":    retirement.RetiredBy,
+		"Replacements": retirement.Replacements,
+		"UpdatedAt":    retirement.UpdatedAt.Format(time.RFC3339),
+	}
+	table := airtableClient.GetTable(airtableBaseID, exerciseRetirementsTableName)
+	records := &airtable.Records{Records: []*airtable.Record{{ID: retirement.ID, Fields: fields}}}
+	if retirement.ID != "" {
+		if _, err := table.UpdateRecordsPartial(records); err != nil {
+			return fmt.Errorf("failed to update exercise retirement in Airtable: %v", err)
+		}
+		return nil
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return fmt.Errorf("failed to record exercise retirement in Airtable: %v", err)
+	}
+	if len(result.Records) > 0 {
+		retirement.ID = result.Records[0].ID
+	}
+	return nil
+}
+
+func deleteExerciseRetirement(retirement *ExerciseRetirement) error {
+	defer invalidateRetiredExercises()
+	if airtableBaseID == "" {
+		retirementsMutex.Lock()
+		delete(memoryRetirements, retirement.ExerciseID)
+		retirementsMutex.Unlock()
+		return nil
+	}
+	if _, err := airtableClient.GetTable(airtableBaseID, exerciseRetirementsTableName).DeleteRecords([]string{retirement.ID}); err != nil {
+		return fmt.Errorf("failed to delete exercise retirement from Airtable: %v", err)
+	}
+	return nil
+}
+
+func invalidateRetiredExercises() {
+	retiredExercises.Lock()
+	retiredExercises.loadedAt = time.Time{}
+	retiredExercises.Unlock()
+}
+
+// retiredExerciseIDs returns the IDs of the retired exercises, cached for a few minutes as
+// every set asks for them. Without the table nothing is retired.
+func retiredExerciseIDs() map[string]bool {
+	retiredExercises.Lock()
+	defer retiredExercises.Unlock()
+	if retiredExercises.ids != nil && time.Since(retiredExercises.loadedAt) < retiredExercisesTTL {
+		return retiredExercises.ids
+	}
+	retirements, err := getExerciseRetirements()
+	if err != nil {
+		log.Printf("Warning: failed to get retired exercises: %v", err)
+		if retiredExercises.ids == nil {
+			retiredExercises.ids = map[string]bool{}
+		}
+		retiredExercises.loadedAt = time.Now() // don't ask again on every request
+		return retiredExercises.ids
+	}
+	ids := make(map[string]bool)
+	for exerciseID, retirement := range retirements {
+		if retirement.Status == retirementRetired {
+			ids[exerciseID] = true
+		}
+	}
+	retiredExercises.ids, retiredExercises.loadedAt = ids, time.Now()
+	return ids
+}
+
+// withoutRetired leaves the retired exercises out of a selection.
+func withoutRetired(exercises []*Exercise) []*Exercise {
+	retired := retiredExerciseIDs()
+	if len(retired) == 0 {
+		return exercises
+	}
+	var active []*Exercise
+	for _, ex := range exercises {
+		if !retired[ex.AirtableID] {
+			active = append(active, ex)
+		}
+	}
+	return active
+}
+
+// replacementVars returns the prompt variables an exercise was generated with, if its
+// topic's prompt still matches one of the levels.
+func replacementVars(topic *Topic, ex *Exercise, count int) (PromptVars, bool) {
+	for _, level := range retentionLevels {
+		if getCacheHash(topic.Prompt, PromptVars{Level: level}) == ex.PromptHash {
+			return promptVarsFromRequest(GenerateRequest{Level: level, Theme: ex.Theme, Difficulty: ex.difficulty(), Count: count}), true
+		}
+	}
+	return PromptVars{}, false
+}
+
+// replaceRetiredExercises generates new exercises for the same topic, level, theme and
+// difficulty as the retired ones, and records how many replaced each. It returns how many
+// were generated.
+func replaceRetiredExercises(ctx context.Context, retired []*ExerciseRetirement, exercises map[string]*Exercise) int {
+	if offlineMode() || !featureEnabled(flagExerciseGeneration) {
+		return 0
+	}
+	type batch struct {
+		topicID, promptHash, theme, difficulty string
+	}
+	var order []batch
+	batches := make(map[batch][]*ExerciseRetirement)
+	for _, retirement := range retired {
+		ex := exercises[retirement.ExerciseID]
+		if ex == nil {
+			continue
+		}
+		key := batch{ex.TopicID, ex.PromptHash, ex.Theme, ex.difficulty()}
+		if batches[key] == nil {
+			order = append(order, key)
+		}
+		batches[key] = append(batches[key], retirement)
+	}
+
+	total := 0
+	for _, key := range order {
+		topic, err := dataStore.GetTopic(key.topicID)
+		if err != nil || topic.Archived {
+			continue
+		}
+		group := batches[key]
+		vars, ok := replacementVars(topic, exercises[group[0].ExerciseID], len(group))
+		if !ok {
+			continue // the prompt changed since, so the topic's current exercises come from elsewhere
+		}
+		generated, err := generateAndCacheExercises(ctx, topic, vars)
+		if err != nil {
+			log.Printf("Error generating replacements for retired exercises of topic %s: %v", topic.ID, err)
+			continue
+		}
+		total += len(generated)
+		// Generated exercises are shared out over the retired ones they replace
+		for i, retirement := range group {
+			retirement.Replacements = len(generated) / len(group)
+			if i < len(generated)%len(group) {
+				retirement.Replacements++
+			}
+			if err := saveExerciseRetirement(retirement); err != nil {
+				log.Printf("Warning: failed to record replacements of exercise %s: %v", retirement.ExerciseID, err)
+			}
+		}
+	}
+	return total
+}
+
+// applyRetirementPolicy retires the exercises that exceed the policy's thresholds, except
+// those already retired or kept, and generates replacements if the policy says so. A dry
+// run only reports what would be retired.
+func applyRetirementPolicy(ctx context.Context, dryRun bool) (*RetirementRun, error) {
+	retirementRunning.Lock()
+	defer retirementRunning.Unlock()
+
+	policy := retirementPolicy()
+	report, rated, err := getExerciseQuality("")
+	if err != nil {
+		return nil, err
+	}
+	existing, err := getExerciseRetirements()
+	if err != nil {
+		return nil, err
+	}
+
+	run := &RetirementRun{DryRun: dryRun, Rated: rated, Retired: []*ExerciseRetirement{}}
+	exercises := make(map[string]*Exercise)
+	for _, q := range report {
+		reason := policy.retirementReason(q)
+		if reason == "" || existing[q.ExerciseID] != nil {
+			continue
+		}
+		retirement := &ExerciseRetirement{ExerciseID: q.ExerciseID, TopicID: q.TopicID, Status: retirementRetired,
+			Reason: reason, RetiredBy: retiredByPolicy, UpdatedAt: time.Now().UTC()}
+		if !dryRun {
+			if err := saveExerciseRetirement(retirement); err != nil {
+				return nil, err
+			}
+		}
+		run.Retired = append(run.Retired, retirement)
+		exercises[q.ExerciseID] = q.exercise
+	}
+	if dryRun || len(run.Retired) == 0 {
+		return run, nil
+	}
+
+	if policy.Replace {
+		run.Replacements = replaceRetiredExercises(ctx, run.Retired, exercises)
+	}
+	reasons := make([]string, len(run.Retired))
+	for i, retirement := range run.Retired {
+		reasons[i] = retirement.ExerciseID + ": " + retirement.Reason
+	}
+	notifyWebhooks(webhookExerciseRetired, fmt.Sprintf("%d exercises were retired for poor quality, %d replacements generated", len(run.Retired), run.Replacements),
+		map[string]any{"count": len(run.Retired), "replacements": run.Replacements, "exercises": reasons})
+	return run, nil
+}
+
+// retireIfFlagged retires a just-flagged exercise once its verified flags reach the
+// policy's threshold, without waiting for the next policy run. It runs as a policy run
+// does, one at a time, so concurrent flags can't retire the exercise twice.
+func retireIfFlagged(ex *Exercise) {
+	policy := retirementPolicy()
+	if policy.MaxFlags == 0 {
+		return
+	}
+	retirementRunning.Lock()
+	defer retirementRunning.Unlock()
+
+	all, err := getExerciseFlags(ex.AirtableID)
+	if err != nil {
+		log.Printf("Error getting flags of exercise %s: %v", ex.AirtableID, err)
+		return
+	}
+	flags := 0
+	for _, flag := range all {
+		if flag.Verified {
+			flags++
+		}
+	}
+	if flags < policy.MaxFlags {
+		return
+	}
+	existing, err := getExerciseRetirements()
+	if err != nil || existing[ex.AirtableID] != nil {
+		return
+	}
+	retirement := &ExerciseRetirement{ExerciseID: ex.AirtableID, TopicID: ex.TopicID, Status: retirementRetired,
+		Reason: fmt.Sprintf("flagged by %d learners", flags), RetiredBy: retiredByPolicy, UpdatedAt: time.Now().UTC()}
+	if err := saveExerciseRetirement(retirement); err != nil {
+		log.Printf("Error retiring flagged exercise %s: %v", ex.AirtableID, err)
+		return
+	}
+	replacements := 0
+	if policy.Replace {
+		replacements = replaceRetiredExercises(context.Background(), []*ExerciseRetirement{retirement}, map[string]*Exercise{ex.AirtableID: ex})
+	}
+	notifyWebhooks(webhookExerciseRetired, fmt.Sprintf("An exercise was retired after %d flags: %q", flags, ex.Sentence),
+		map[string]any{"count": 1, "replacements": replacements, "exercises": []string{ex.AirtableID + ": " + retirement.Reason}})
+}
+
+// startRetirementScheduler applies the retirement policy every hour, when it is enabled.
+func startRetirementScheduler() {
+	if !retirementPolicy().Enabled {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(retirementCheckInterval)
+			run, err := applyRetirementPolicy(context.Background(), false)
+			if err != nil {
+				log.Printf("Error applying the exercise retirement policy: %v", err)
+			} else if len(run.Retired) > 0 {
+				log.Printf("Retired %d exercises, generated %d replacements", len(run.Retired), run.Replacements)
+			}
+		}
+	}()
+}
+
+// Handle exercise retirement (admin):
+// GET /api/admin/exercise-retirements lists the retired and kept exercises, with the policy.
+// POST /api/admin/exercise-retirements/run applies the policy now; {"dry_run": true} only
+// reports what it would retire.
+// PUT /api/admin/exercise-retirements/{exerciseID} with {"status": "retired", "reason": "..."}
+// retires an exercise, and with {"status": "kept"} brings it back and exempts it from the policy.
+// DELETE /api/admin/exercise-retirements/{exerciseID} removes the override: the exercise is
+// served again, and the policy may retire it again.
+func handleAdminExerciseRetirements(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/exercise-retirements"), "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		listExerciseRetirements(w, r)
+	case id == "run" && r.Method == http.MethodPost:
+		runRetirementPolicy(w, r)
+	case id != "" && id != "run" && (r.Method == http.MethodPut || r.Method == http.MethodDelete):
+		overrideExerciseRetirement(w, r, id)
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func listExerciseRetirements(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != retirementRetired && status != retirementKept {
+		writeError(w, "status must be retired or kept", http.StatusBadRequest)
+		return
+	}
+	retirements, err := getExerciseRetirements()
+	if err != nil {
+		log.Printf("Error getting exercise retirements: %v", err)
+		writeError(w, "Failed to get exercise retirements", http.StatusInternalServerError)
+		return
+	}
+	list := []*ExerciseRetirement{}
+	for _, retirement := range retirements {
+		if status == "" || retirement.Status == status {
+			list = append(list, retirement)
+		}
+	}
+	slices.SortFunc(list, func(a, b *ExerciseRetirement) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"policy": retirementPolicy(), "retirements": list})
+}
+
+func runRetirementPolicy(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DryRun bool `json:"dry_run"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if !retirementPolicy().Enabled {
+		writeError(w, "No retirement policy is configured; set EXERCISE_RETIRE_FLAGS or EXERCISE_RETIRE_WRONG_RATE", http.StatusConflict)
+		return
+	}
+	run, err := applyRetirementPolicy(r.Context(), req.DryRun)
+	if err != nil {
+		log.Printf("Error applying the exercise retirement policy: %v", err)
+		writeError(w, "Failed to apply the retirement policy", http.StatusInternalServerError)
+		return
+	}
+	if !run.DryRun && len(run.Retired) > 0 {
+		recordAudit(r, auditExercisesRetire, "exercises", "", nil, run)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run)
+}
+
+func overrideExerciseRetirement(w http.ResponseWriter, r *http.Request, exerciseID string) {
+	retirements, err := getExerciseRetirements()
+	if err != nil {
+		log.Printf("Error getting exercise retirements: %v", err)
+		writeError(w, "Failed to get exercise retirements", http.StatusInternalServerError)
+		return
+	}
+	existing := retirements[exerciseID]
+
+	if r.Method == http.MethodDelete {
+		if existing == nil {
+			writeError(w, "The exercise has no retirement to remove", http.StatusNotFound)
+			return
+		}
+		if err := deleteExerciseRetirement(existing); err != nil {
+			writeError(w, fmt.Sprintf("Failed to remove retirement: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, auditExerciseReinstate, "exercise", exerciseID, existing, nil)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Status != retirementRetired && req.Status != retirementKept {
+		writeError(w, "status must be retired or kept", http.StatusBadRequest)
+		return
+	}
+	ex, err := exerciseByID(exerciseID)
+	if err != nil {
+		writeError(w, "Exercise not found", http.StatusNotFound)
+		return
+	}
+
+	retirement := &ExerciseRetirement{ExerciseID: ex.AirtableID, TopicID: ex.TopicID}
+	var before *ExerciseRetirement
+	if existing != nil {
+		c := *existing
+		retirement, before = existing, &c
+	}
+	retirement.Status = req.Status
+	retirement.Reason = strings.TrimSpace(req.Reason)
+	retirement.RetiredBy = getUserIDFromRequest(r)
+	retirement.UpdatedAt = time.Now().UTC()
+	if err := saveExerciseRetirement(retirement); err != nil {
+		log.Printf("Error saving exercise retirement: %v", err)
+		writeError(w, "Failed to save retirement", http.StatusInternalServerError)
+		return
+	}
+	action := auditExerciseRetire
+	if req.Status == retirementKept {
+		action = auditExerciseKeep
+	}
+	recordAudit(r, action, "exercise", exerciseID, before, retirement)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(retirement)
+}
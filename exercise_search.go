@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	exerciseSearchTTL          = 5 * time.Minute
+	defaultExerciseSearchLimit = 20
+	maxExerciseSearchLimit     = 100
+)
+
+// Full-text search over cached exercises. Airtable has no full-text index, so the exercises
+// are indexed in memory: an inverted index from lower-cased words of the sentence,
+// conjunction and hint to the exercises containing them. It is rebuilt from the store when
+// older than exerciseSearchTTL.
+type exerciseSearchIndex struct {
+	exercises map[string]*Exercise
+	postings  map[string]map[string]int // term -> exercise ID -> weight
+	terms     []string                  // sorted, for prefix queries
+	builtAt   time.Time
+}
+
+var (
+	exerciseSearchMutex sync.Mutex
+	exerciseSearch      *exerciseSearchIndex
+)
+
+type ExerciseSearchResult struct {
+	*Exercise
+	TopicName string `json:"topic_name"`
+	Score     int    `json:"score"`
+}
+
+// searchTerms returns the lower-cased words of a text.
+func searchTerms(text string) []string {
+	var terms []string
+	for _, token := range tokenizeSentence(text) {
+		if wordTokenPattern.MatchString(token) {
+			terms = append(terms, strings.ToLower(token))
+		}
+	}
+	return terms
+}
+
+func buildExerciseSearchIndex(exercises []*Exercise) *exerciseSearchIndex {
+	index := &exerciseSearchIndex{
+		exercises: make(map[string]*Exercise),
+		postings:  make(map[string]map[string]int),
+		builtAt:   time.Now(),
+	}
+	add := func(id, text string, weight int) {
+		for _, term := range searchTerms(text) {
+			if index.postings[term] == nil {
+				index.postings[term] = make(map[string]int)
+			}
+			index.postings[term][id] += weight
+		}
+	}
+	for _, ex := range exercises {
+		index.exercises[ex.ID] = ex
+		// German sentence and conjunction matter most; the English hint helps find topics
+		add(ex.ID, ex.Sentence, 2)
+		add(ex.ID, ex.Conjunction, 2)
+		add(ex.ID, ex.TranslationHint, 1)
+	}
+	for term := range index.postings {
+		index.terms = append(index.terms, term)
+	}
+	sort.Strings(index.terms)
+	return index
+}
+
+// getExerciseSearchIndex returns the current index, rebuilding it when stale or when refresh is set.
+func getExerciseSearchIndex(refresh bool) (*exerciseSearchIndex, error) {
+	exerciseSearchMutex.Lock()
+	defer exerciseSearchMutex.Unlock()
+
+	if exerciseSearch != nil && !refresh && time.Since(exerciseSearch.builtAt) < exerciseSearchTTL {
+		return exerciseSearch, nil
+	}
+	exercises, err := dataStore.ListExercises("")
+	if err != nil {
+		return nil, err
+	}
+	exerciseSearch = buildExerciseSearchIndex(exercises)
+	return exerciseSearch, nil
+}
+
+// matchTerm returns the weights of the exercises matching one query term. A trailing *
+// matches any word with that prefix, e.g. "geh*" finds "gehen" and "geht".
+func (index *exerciseSearchIndex) matchTerm(term string) map[string]int {
+	prefix, isPrefix := strings.CutSuffix(term, "*")
+	if !isPrefix {
+		return index.postings[term]
+	}
+
+	matches := make(map[string]int)
+	for i := sort.SearchStrings(index.terms, prefix); i < len(index.terms) && strings.HasPrefix(index.terms[i], prefix); i++ {
+		for id, weight := range index.postings[index.terms[i]] {
+			matches[id] += weight
+		}
+	}
+	return matches
+}
+
+// queryTerms splits a search query into lower-cased words, keeping a trailing * for prefixes.
+func queryTerms(query string) []string {
+	var terms []string
+	for _, field := range strings.Fields(strings.ToLower(query)) {
+		if term := strings.Trim(field, `"'.,!?;:`); strings.TrimSuffix(term, "*") != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// search returns the exercises containing every query term, best matches first.
+func (index *exerciseSearchIndex) search(query, topicID string) []ExerciseSearchResult {
+	var scores map[string]int
+	for _, term := range queryTerms(query) {
+		matches := index.matchTerm(term)
+		if scores == nil {
+			scores = make(map[string]int, len(matches))
+			for id, weight := range matches {
+				scores[id] = weight
+			}
+			continue
+		}
+		for id := range scores {
+			if weight, ok := matches[id]; ok {
+				scores[id] += weight
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	results := []ExerciseSearchResult{}
+	for id, score := range scores {
+		if ex := index.exercises[id]; topicID == "" || ex.TopicID == topicID {
+			results = append(results, ExerciseSearchResult{Exercise: ex, Score: score})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	return results
+}
+
+// Handle exercise search (admins and teachers): GET /api/exercises/search?q=weil&topic_id=&limit=20
+// All words must match; end a word with * to match by prefix. refresh=true rebuilds the index.
+func handleExerciseSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := requireUser(w, r)
+	if user == nil {
+		return
+	}
+	if !isAdminOrTeacher(user) {
+		writeError(w, "Only admins and teachers can search exercises", http.StatusForbidden)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeError(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	limit := defaultExerciseSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxExerciseSearchLimit {
+			writeError(w, fmt.Sprintf("limit must be between 1 and %d", maxExerciseSearchLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	index, err := getExerciseSearchIndex(r.URL.Query().Get("refresh") == "true")
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to search exercises: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// The index covers every tenant, so only the request's tenant's topics are kept
+	topics, err := tenantTopics(r.Context())
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to search exercises: %v", err), http.StatusInternalServerError)
+		return
+	}
+	topicNames := make(map[string]string)
+	for _, topic := range topics {
+		topicNames[topic.ID] = topic.Name
+	}
+	matches := slices.DeleteFunc(index.search(query, r.URL.Query().Get("topic_id")), func(result ExerciseSearchResult) bool {
+		_, ok := topicNames[result.TopicID]
+		return !ok
+	})
+	results := matches[:min(limit, len(matches))]
+	for i := range results {
+		results[i].TopicName = topicNames[results[i].TopicID]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"query":      query,
+		"total":      len(matches),
+		"results":    results,
+		"indexed_at": index.builtAt,
+	})
+}
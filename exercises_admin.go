@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/mehanizm/airtable"
+)
+
+// ExerciseRequest is the body accepted by the admin exercise endpoints. Updates may send
+// single typed fields (sentence, translation_hint, conjunction, distractors,
+// alternative_sentences) instead of the whole exercise.
+type ExerciseRequest struct {
+	TopicID    string          `json:"topic_id"`
+	PromptHash string          `json:"prompt_hash,omitempty"`
+	Level      string          `json:"level,omitempty"`
+	Theme      string          `json:"theme,omitempty"`
+	Exercise   json.RawMessage `json:"exercise"`
+
+	Sentence        *string   `json:"sentence,omitempty"`
+	TranslationHint *string   `json:"translation_hint,omitempty"`
+	Conjunction     *string   `json:"conjunction,omitempty"`
+	Distractors     *[]string `json:"distractors,omitempty"`
+
+	AlternativeSentences *[]string `json:"alternative_sentences,omitempty"`
+}
+
+// validateExerciseJSON checks that an exercise is a JSON object with the fields the frontend needs.
+func validateExerciseJSON(raw json.RawMessage) error {
+	_, err := parseExerciseContent(string(raw))
+	return err
+}
+
+// applyExerciseFields returns the exercise's JSON with the request's typed fields applied.
+func applyExerciseFields(exercise *Exercise, req *ExerciseRequest) (string, error) {
+	content := exercise.content()
+	if req.Sentence != nil {
+		content.Sentence = *req.Sentence
+	}
+	if req.TranslationHint != nil {
+		content.TranslationHint = *req.TranslationHint
+	}
+	if req.Conjunction != nil {
+		content.Conjunction = strings.TrimSpace(*req.Conjunction)
+	}
+	if req.Distractors != nil {
+		content.Distractors = *req.Distractors
+	}
+	if req.AlternativeSentences != nil {
+		content.AlternativeSentences = *req.AlternativeSentences
+	}
+
+	exerciseJSON, err := withExerciseContent(exercise.ExerciseJSON, content)
+	if err != nil {
+		return "", err
+	}
+	if _, err := parseExerciseContent(exerciseJSON); err != nil {
+		return "", err
+	}
+	return exerciseJSON, nil
+}
+
+func updateExercise(exerciseID, theme, exerciseJSON string) (*Exercise, error) {
+	table := airtableClient.GetTable(airtableBaseID, exercisesTableName)
+	fields := map[string]any{
+		"ExerciseJSON": exerciseJSON,
+	}
+	if theme != "" {
+		fields["Theme"] = theme
+	}
+	if content, err := parseExerciseContent(exerciseJSON); err == nil {
+		for name, value := range exerciseContentFields(content) {
+			fields[name] = value
+		}
+	}
+
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				ID:     exerciseID,
+				Fields: fields,
+			},
+		},
+	}
+	result, err := table.UpdateRecordsPartial(records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update exercise in Airtable: %v", err)
+	}
+	if len(result.Records) == 0 {
+		return nil, fmt.Errorf("no records returned from Airtable")
+	}
+	return exerciseFromRecord(result.Records[0]), nil
+}
+
+func deleteExercise(exerciseID string) error {
+	table := airtableClient.GetTable(airtableBaseID, exercisesTableName)
+	if _, err := table.DeleteRecords([]string{exerciseID}); err != nil {
+		return fmt.Errorf("failed to delete exercise from Airtable: %v", err)
+	}
+	recordDeletedExercises([]string{exerciseID})
+	return nil
+}
+
+// Handle admin exercise CRUD: /api/admin/exercises and /api/admin/exercises/{id}
+func handleAdminExercises(w http.ResponseWriter, r *http.Request) {
+	exerciseID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/exercises"), "/")
+	if id, ok := strings.CutSuffix(exerciseID, "/image"); ok {
+		handleAdminExerciseImage(w, r, id) // see exercise_images.go
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && exerciseID == "":
+		// GET /api/admin/exercises?topic_id=&theme=&prompt_hash=&q=&sort=-created_at&limit=50&cursor=
+		params, err := parseListParams(r, []string{"created_at"}, "-created_at")
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		exercises, err := dataStore.ListExercises(r.URL.Query().Get("topic_id"))
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to list exercises: %v", err), http.StatusInternalServerError)
+			return
+		}
+		exercises = filterExercisesByTheme(exercises, r.URL.Query().Get("theme"))
+		if query := strings.TrimSpace(r.URL.Query().Get("q")); query != "" {
+			var matching []*Exercise
+			for _, ex := range exercises {
+				if ex.content().matches(query) {
+					matching = append(matching, ex)
+				}
+			}
+			exercises = matching
+		}
+		if promptHash := r.URL.Query().Get("prompt_hash"); promptHash != "" {
+			var matching []*Exercise
+			for _, ex := range exercises {
+				if ex.PromptHash == promptHash {
+					matching = append(matching, ex)
+				}
+			}
+			exercises = matching
+		}
+		sortItems(exercises, params, map[string]func(a, b *Exercise) bool{
+			"created_at": func(a, b *Exercise) bool { return a.CreatedAt.Before(b.CreatedAt) },
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(paginate(exercises, params))
+
+	case r.Method == http.MethodGet:
+		exercise, err := dataStore.GetExercise(exerciseID)
+		if err != nil {
+			writeError(w, "Exercise not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(exercise)
+
+	case r.Method == http.MethodPost && exerciseID == "":
+		var req ExerciseRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := validateExerciseJSON(req.Exercise); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		topic, err := dataStore.GetTopic(req.TopicID)
+		if err != nil {
+			writeError(w, "Topic not found", http.StatusNotFound)
+			return
+		}
+
+		// Attach handcrafted exercises to the topic's current prompt so they are served from the cache
+		promptHash := req.PromptHash
+		if promptHash == "" {
+			vars := promptVarsFromRequest(GenerateRequest{Level: req.Level})
+			promptHash = getCacheHash(topic.Prompt, vars)
+		}
+
+		exercise, err := dataStore.CreateExercise(topic.ID, promptHash, strings.TrimSpace(req.Theme), string(req.Exercise))
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to create exercise: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, auditExerciseCreate, "exercise", exercise.AirtableID, nil, exercise)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(exercise)
+
+	case r.Method == http.MethodPut && exerciseID != "":
+		var req ExerciseRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		existing, err := dataStore.GetExercise(exerciseID)
+		if err != nil {
+			writeError(w, "Exercise not found", http.StatusNotFound)
+			return
+		}
+		exerciseJSON := string(req.Exercise)
+		if len(req.Exercise) == 0 {
+			// Per-field edit: apply the typed fields to the stored exercise
+			if exerciseJSON, err = applyExerciseFields(existing, &req); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else if err := validateExerciseJSON(req.Exercise); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		exercise, err := updateExercise(exerciseID, strings.TrimSpace(req.Theme), exerciseJSON)
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to update exercise: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, auditExerciseUpdate, "exercise", exerciseID, existing, exercise)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(exercise)
+
+	case r.Method == http.MethodDelete && exerciseID != "":
+		existing, _ := dataStore.GetExercise(exerciseID)
+		if err := deleteExercise(exerciseID); err != nil {
+			writeError(w, fmt.Sprintf("Failed to delete exercise: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, auditExerciseDelete, "exercise", exerciseID, existing, nil)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// RegenerateRequest is the optional body of POST /api/admin/topics/{id}/regenerate.
+type RegenerateRequest struct {
+	Level string `json:"level,omitempty"`
+	Theme string `json:"theme,omitempty"`
+	Count int    `json:"count,omitempty"`
+	Async bool   `json:"async,omitempty"`
+}
+
+// Topics with a regeneration in progress, so a slow LLM call is not started twice.
+var regeneratingTopics sync.Map
+
+// regenerateExercises deletes the topic's cached exercises for the current prompt hash
+// (only those of vars.Theme when a theme is given) and generates a fresh batch.
+func regenerateExercises(ctx context.Context, topic *Topic, vars PromptVars) (deleted int, generated []*Exercise, err error) {
+	promptHash := getCacheHash(topic.Prompt, vars)
+	cached, err := dataStore.GetExercisesForTopic(topic.ID, promptHash)
+	if err != nil {
+		return 0, nil, err
+	}
+	if vars.Theme != "" {
+		cached = filterExercisesByTheme(cached, vars.Theme)
+	}
+
+	var ids []string
+	for _, ex := range cached {
+		ids = append(ids, ex.AirtableID)
+	}
+	if err := dataStore.DeleteExercises(ids); err != nil {
+		return 0, nil, err
+	}
+	recordDeletedExercises(ids)
+
+	generated, err = generateAndCacheExercises(ctx, topic, vars)
+	if err != nil {
+		return len(ids), nil, err
+	}
+	return len(ids), generated, nil
+}
+
+// Handle admin topic actions: POST /api/admin/topics/{id}/regenerate replaces the topic's
+// cached exercises for the current prompt. With "async": true it returns 202 immediately.
+func handleAdminTopicActions(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/topics/"), "/"), "/")
+	if len(pathParts) != 2 || pathParts[0] == "" || pathParts[1] != "regenerate" {
+		writeError(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RegenerateRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	topic, err := dataStore.GetTopic(pathParts[0])
+	if err != nil {
+		writeError(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+	if topic.Archived {
+		writeError(w, "Topic is archived", http.StatusGone)
+		return
+	}
+
+	if _, running := regeneratingTopics.LoadOrStore(topic.ID, true); running {
+		writeError(w, "Exercises for this topic are already being regenerated", http.StatusConflict)
+		return
+	}
+
+	vars := promptVarsFromRequest(GenerateRequest{Level: req.Level, Theme: req.Theme, Count: req.Count})
+	recordAudit(r, auditTopicRegenerate, "topic", topic.ID, nil, req)
+	if req.Async {
+		// Outlives the request, but stays in its trace
+		ctx := withProgressOwner(context.WithoutCancel(r.Context()), getUserIDFromRequest(r))
+		go func() {
+			defer regeneratingTopics.Delete(topic.ID)
+			deleted, generated, err := regenerateExercises(ctx, topic, vars)
+			if err != nil {
+				log.Printf("Error regenerating exercises for topic %s: %v", topic.ID, err)
+				return
+			}
+			log.Printf("Regenerated exercises for topic %s: %d deleted, %d generated", topic.ID, deleted, len(generated))
+		}()
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	defer regeneratingTopics.Delete(topic.ID)
+	deleted, generated, err := regenerateExercises(withProgressOwner(r.Context(), getUserIDFromRequest(r)), topic, vars)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to regenerate exercises: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"deleted":   deleted,
+		"exercises": generated,
+	})
+}
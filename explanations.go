@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Grammar explanations are generated by the model the first time a learner asks for one
+// and cached per exercise, so every later request is free. An explanation is kept with the
+// sentence it explains: if an admin edits the exercise, the next request generates a new one.
+
+const explanationPrompt = `You are a German teacher. A learner has just tried to build this German sentence from its words:
+
+%s
+
+It translates as: %s
+
+In at most 120 words of plain English, explain why the words are in this order and why the verbs are conjugated as they are.%s Name the grammar rule (e.g. verb-final order after a subordinating conjunction) and point to the words it applies to. Do not use Markdown headings or lists.`
+
+// ExerciseExplanation is the cached grammar explanation of an exercise.
+type ExerciseExplanation struct {
+	ID          string    `json:"-"`
+	ExerciseID  string    `json:"exercise_id"`
+	Sentence    string    `json:"sentence"`
+	Explanation string    `json:"explanation"`
+	Model       string    `json:"model"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+var (
+	explanationsMutex   sync.Mutex
+	memoryExplanations  = make(map[string]*ExerciseExplanation) // by exercise ID, with in-memory storage
+	explanationsPending = make(map[string]chan struct{})        // exercise IDs being explained
+)
+
+func exerciseExplanationFromRecord(record *airtable.Record) *ExerciseExplanation {
+	explanation := &ExerciseExplanation{ID: record.ID}
+	if val, ok := record.Fields["ExerciseID"].(string); ok {
+		explanation.ExerciseID = val
+	}
+	if val, ok := record.Fields["Sentence"].(string); ok {
+		explanation.Sentence = val
+	}
+	if val, ok := record.Fields["Explanation"].(string); ok {
+		explanation.Explanation = val
+	}
+	if val, ok := record.Fields["Model"].(string); ok {
+		explanation.Model = val
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			explanation.CreatedAt = t
+		}
+	}
+	return explanation
+}
+
+// getExerciseExplanation returns the cached explanation of an exercise, or nil if there is none.
+func getExerciseExplanation(exerciseID string) (*ExerciseExplanation, error) {
+	if airtableBaseID == "" {
+		explanationsMutex.Lock()
+		defer explanationsMutex.Unlock()
+		if explanation, ok := memoryExplanations[exerciseID]; ok {
+			c := *explanation
+			return &c, nil
+		}
+		return nil, nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, exerciseExplanationsTableName)
+	records, err := table.GetRecords().
+		WithFilterFormula(fmt.Sprintf("{ExerciseID} = '%s'", exerciseID)).
+		MaxRecords(1).
+		Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exercise explanation from Airtable: %v", err)
+	}
+	if len(records.Records) == 0 {
+		return nil, nil
+	}
+	return exerciseExplanationFromRecord(records.Records[0]), nil
+}
+
+// saveExerciseExplanation stores an explanation, replacing the exercise's previous one.
+func saveExerciseExplanation(explanation *ExerciseExplanation, previous *ExerciseExplanation) error {
+	if airtableBaseID == "" {
+		explanationsMutex.Lock()
+		defer explanationsMutex.Unlock()
+		c := *explanation
+		memoryExplanations[explanation.ExerciseID] = &c
+		return nil
+	}
+
+	fields := map[string]any{
+		"ExerciseID":  explanation.ExerciseID,
+		"Sentence":    explanation.Sentence,
+		"Explanation": explanation.Explanation,
+		"Model":       explanation.Model,
+		"CreatedAt":   explanation.CreatedAt.Format(time.RFC3339),
+	}
+	table := airtableClient.GetTable(airtableBaseID, exerciseExplanationsTableName)
+	records := &airtable.Records{Records: []*airtable.Record{{Fields: fields}}}
+	var err error
+	if previous != nil {
+		records.Records[0].ID = previous.ID
+		_, err = table.UpdateRecords(records)
+	} else {
+		_, err = table.AddRecords(records)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save exercise explanation in Airtable: %v", err)
+	}
+	return nil
+}
+
+// generateExplanation asks the model to explain the grammar of an exercise's sentence.
+func generateExplanation(ctx context.Context, ex *Exercise) (explanation string, err error) {
+	if offlineMode() {
+		return "", errOfflineMode
+	}
+	llm := llmProviderFor(ctx)
+	modelName := llm.Model
+	ctx, end := startSpan(ctx, "explain exercise", attribute.String("exercise.id", ex.AirtableID), attribute.String("llm.model", modelName))
+	defer func() { end(err) }()
+
+	conjunction := ""
+	if ex.Conjunction != "" {
+		conjunction = fmt.Sprintf(" Focus on the conjunction %q.", ex.Conjunction)
+	}
+	reqBody, err := json.Marshal(OpenAIRequest{
+		Model:    modelName,
+		Messages: []Message{{Role: "user", Content: fmt.Sprintf(explanationPrompt, ex.Sentence, ex.TranslationHint, conjunction)}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create explanation request body: %w", err)
+	}
+
+	apiReq, err := http.NewRequestWithContext(ctx, "POST", llm.URL+"/chat/completions", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create API request for explanation: %w", err)
+	}
+	apiReq.Header.Set("Content-Type", "application/json")
+	apiReq.Header.Set("Authorization", "Bearer "+llm.APIKey)
+
+	resp, err := llmHTTPClient.Do(apiReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to call OpenAI API for explanation: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read API response for explanation: %w", err)
+	}
+	var openaiResp OpenAIResponse
+	if err := json.Unmarshal(respBody, &openaiResp); err != nil {
+		return "", fmt.Errorf("failed to parse API response for explanation: %w", err)
+	}
+	if openaiResp.Error != nil {
+		return "", fmt.Errorf("API error during explanation: %s", openaiResp.Error.Message)
+	}
+	if len(openaiResp.Choices) == 0 || strings.TrimSpace(openaiResp.Choices[0].Message.Content) == "" {
+		return "", fmt.Errorf("received an empty response from the explanation API")
+	}
+	return strings.TrimSpace(openaiResp.Choices[0].Message.Content), nil
+}
+
+// explainExercise returns the exercise's cached explanation, generating it if there is none
+// for the current sentence. Concurrent requests for one exercise wait for a single generation.
+// The bool reports whether the explanation came from the cache. Generating one counts
+// against the quota of the user who asked for it.
+func explainExercise(ctx context.Context, ex *Exercise, userID string) (*ExerciseExplanation, bool, error) {
+	for {
+		cached, err := getExerciseExplanation(ex.AirtableID)
+		if err != nil {
+			return nil, false, err
+		}
+		if cached != nil && cached.Sentence == ex.Sentence {
+			return cached, true, nil
+		}
+
+		explanationsMutex.Lock()
+		pending, ok := explanationsPending[ex.AirtableID]
+		if !ok {
+			explanationsPending[ex.AirtableID] = make(chan struct{})
+		}
+		explanationsMutex.Unlock()
+		if ok {
+			select {
+			case <-pending:
+				continue // look again: the explanation is cached now, unless generating it failed
+			case <-ctx.Done():
+				return nil, false, ctx.Err()
+			}
+		}
+
+		explanation, err := func() (*ExerciseExplanation, error) {
+			defer func() {
+				explanationsMutex.Lock()
+				close(explanationsPending[ex.AirtableID])
+				delete(explanationsPending, ex.AirtableID)
+				explanationsMutex.Unlock()
+			}()
+			if offlineMode() {
+				return nil, errOfflineMode
+			}
+			if err := useModelQuota(ctx, userID); err != nil {
+				return nil, err
+			}
+			text, err := generateExplanation(ctx, ex)
+			if err != nil {
+				return nil, err
+			}
+			explanation := &ExerciseExplanation{
+				ExerciseID:  ex.AirtableID,
+				Sentence:    ex.Sentence,
+				Explanation: text,
+				Model:       llmProviderFor(ctx).Model,
+				CreatedAt:   time.Now().UTC(),
+			}
+			if err := saveExerciseExplanation(explanation, cached); err != nil {
+				// The learner still gets the explanation; it is generated again next time
+				log.Printf("Warning: %v", err)
+			}
+			return explanation, nil
+		}()
+		return explanation, false, err
+	}
+}
+
+// Handle grammar explanations, for users and guests: POST /api/exercises/{id}/explain returns
+// { exercise_id, sentence, explanation, model, created_at, cached }. The explanation includes
+// the correct sentence, so it is only given once the user or guest has checked an answer to
+// the exercise or answered it before, unless the client gets the answers anyway.
+func handleExerciseExplain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	exerciseID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/exercises/"), "/explain")
+
+	ex, err := exerciseByID(exerciseID)
+	if err != nil {
+		writeError(w, "Exercise not found", http.StatusNotFound)
+		return
+	}
+	if !servesAnswers(r) {
+		checked, err := answerChecked(getProgressOwnerID(w, r), ex.AirtableID, time.Now())
+		if err != nil {
+			log.Printf("Error looking up answers to exercise %s: %v", exerciseID, err)
+			writeError(w, "Failed to look up your answers", http.StatusInternalServerError)
+			return
+		}
+		if !checked {
+			writeError(w, "Check an answer before asking for the explanation", http.StatusForbidden)
+			return
+		}
+	}
+	if ex.Sentence == "" {
+		writeError(w, "Exercise has no sentence to explain", http.StatusUnprocessableEntity)
+		return
+	}
+
+	explanation, cached, err := explainExercise(r.Context(), ex, getUserIDFromRequest(r))
+	var statusErr *statusError
+	if errors.Is(err, errOfflineMode) {
+		writeOfflineError(w)
+		return
+	}
+	if errors.As(err, &statusErr) {
+		writeStatusError(w, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error explaining exercise %s: %v", exerciseID, err)
+		writeError(w, "Failed to generate an explanation", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		*ExerciseExplanation
+		Cached bool `json:"cached"`
+	}{explanation, cached})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHandleExerciseExplainNeedsCheckedAnswer(t *testing.T) {
+	useMemoryStore(t)
+	config := appConfig
+	t.Cleanup(func() { appConfig = config })
+	appConfig = &Config{OfflineMode: true} // explanations that get past the checks answer 503
+	user := createTestUser(t, "learner-google-id")
+	other := createTestUser(t, "other-google-id")
+	ex, err := dataStore.CreateExercise("recTopic", "hash", "", `{"correct_german_sentence": "Ich lerne Deutsch, weil ich in Berlin wohne.", "english_hint": "I learn German because I live in Berlin."}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	explain := "/api/exercises/" + ex.AirtableID + "/explain"
+
+	if rec := serve(handleExerciseAnswer, user, http.MethodPost, explain, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("before a check: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := serve(handleExerciseAnswer, user, http.MethodPost, "/api/exercises/"+ex.AirtableID+"/check", `{"words": ["Ich"]}`); rec.Code != http.StatusOK {
+		t.Fatalf("check: status %d", rec.Code)
+	}
+	if rec := serve(handleExerciseAnswer, user, http.MethodPost, explain, ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("after a check: status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if rec := serve(handleExerciseAnswer, other, http.MethodPost, explain, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("someone else's check: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	// An exercise answered before can be explained again later
+	if err := dataStore.UpdateUserExerciseViews([]*UserExerciseView{{UserID: other.ID, ExerciseID: ex.AirtableID, RepetitionCounter: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(handleExerciseAnswer, other, http.MethodPost, explain, ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("answered before: status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+const featureFlagRefreshInterval = 30 * time.Second
+
+// Feature flags
+const (
+	flagSRS                = "srs"
+	flagPromptRefinement   = "prompt_refinement"
+	flagExerciseGeneration = "exercise_generation"
+	flagLeaderboard        = "leaderboard"
+	flagMarketplace        = "marketplace"
+	flagWeeklyDigest       = "weekly_digest"
+	flagStudyReminders     = "study_reminders"
+)
+
+// Features can be switched on and off at runtime by admins. Each flag has a default here;
+// a toggled flag is stored in the FeatureFlags table and overrides it. Every instance
+// re-reads the table every featureFlagRefreshInterval, so a toggle takes effect everywhere
+// within that time without a redeploy. Without the table (or in memory mode) toggles last
+// until restart.
+var featureFlagDefinitions = []struct {
+	Name        string
+	Description string
+	Default     bool
+}{
+	{flagSRS, "Spaced repetition: serve exercises that are due for review instead of any cached exercise", true},
+	{flagPromptRefinement, "Refine topic prompts with the model before generating exercises", true},
+	{flagExerciseGeneration, "Generate new exercises when the cache runs out; when off, only cached exercises are served", true},
+	{flagLeaderboard, "Weekly leaderboard API", true},
+	{flagMarketplace, "Topic marketplace API", true},
+	{flagWeeklyDigest, "Weekly progress emails", true},
+	{flagStudyReminders, "Web Push study reminders", true},
+}
+
+type FeatureFlag struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Enabled     bool       `json:"enabled"`
+	Default     bool       `json:"default"`
+	Overridden  bool       `json:"overridden"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+type FeatureFlagRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+type featureFlagOverride struct {
+	recordID  string
+	enabled   bool
+	updatedAt time.Time
+}
+
+var (
+	featureFlagsMutex    sync.RWMutex
+	featureFlagOverrides = make(map[string]featureFlagOverride)
+	featureFlagsStored   bool // the FeatureFlags table could be read at startup; otherwise toggles are kept in memory
+)
+
+func featureFlagDefault(name string) (bool, bool) {
+	for _, def := range featureFlagDefinitions {
+		if def.Name == name {
+			return def.Default, true
+		}
+	}
+	return false, false
+}
+
+// featureEnabled reports whether a feature is currently on.
+func featureEnabled(name string) bool {
+	featureFlagsMutex.RLock()
+	override, ok := featureFlagOverrides[name]
+	featureFlagsMutex.RUnlock()
+	if ok {
+		return override.enabled
+	}
+	enabled, _ := featureFlagDefault(name)
+	return enabled
+}
+
+// requireFeature answers 503 with the code feature_disabled while the feature is off.
+func requireFeature(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !featureEnabled(name) {
+			writeAPIError(w, http.StatusServiceUnavailable, APIError{
+				Code:    "feature_disabled",
+				Message: fmt.Sprintf("The %s feature is currently disabled", name),
+			})
+			return
+		}
+		h(w, r)
+	}
+}
+
+func initFeatureFlags() {
+	if appConfig.OpenAIAPIKey == "" && !appConfig.MockLLM {
+		// Offline with content packs: there is no model to generate with
+		for i := range featureFlagDefinitions {
+			if featureFlagDefinitions[i].Name == flagExerciseGeneration {
+				featureFlagDefinitions[i].Default = false
+			}
+		}
+	}
+	if airtableBaseID == "" {
+		return // In-memory storage
+	}
+	if err := loadFeatureFlags(); err != nil {
+		log.Printf("Warning: failed to load feature flags, using defaults: %v", err)
+		return
+	}
+	featureFlagsStored = true
+}
+
+// startFeatureFlagRefresh picks up toggles made by other instances.
+func startFeatureFlagRefresh() {
+	if !featureFlagsStored {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(featureFlagRefreshInterval)
+			if err := loadFeatureFlags(); err != nil {
+				log.Printf("Warning: failed to refresh feature flags: %v", err)
+			}
+		}
+	}()
+}
+
+// loadFeatureFlags replaces the overrides with the FeatureFlags table. Unknown names are ignored.
+func loadFeatureFlags() error {
+	table := airtableClient.GetTable(airtableBaseID, featureFlagsTableName)
+	records, err := getAllRecords(table.GetRecords())
+	if err != nil {
+		return fmt.Errorf("failed to get feature flags from Airtable: %v", err)
+	}
+
+	overrides := make(map[string]featureFlagOverride)
+	for _, record := range records.Records {
+		name, _ := record.Fields["Name"].(string)
+		if _, known := featureFlagDefault(name); !known {
+			continue
+		}
+		override := featureFlagOverride{recordID: record.ID}
+		if val, ok := record.Fields["Enabled"].(bool); ok {
+			override.enabled = val
+		}
+		if val, ok := record.Fields["UpdatedAt"].(string); ok {
+			if t, err := time.Parse(time.RFC3339, val); err == nil {
+				override.updatedAt = t
+			}
+		}
+		overrides[name] = override
+	}
+
+	featureFlagsMutex.Lock()
+	featureFlagOverrides = overrides
+	featureFlagsMutex.Unlock()
+	return nil
+}
+
+func listFeatureFlags() []*FeatureFlag {
+	featureFlagsMutex.RLock()
+	defer featureFlagsMutex.RUnlock()
+
+	flags := []*FeatureFlag{}
+	for _, def := range featureFlagDefinitions {
+		flag := &FeatureFlag{Name: def.Name, Description: def.Description, Enabled: def.Default, Default: def.Default}
+		if override, ok := featureFlagOverrides[def.Name]; ok {
+			flag.Enabled = override.enabled
+			flag.Overridden = true
+			if !override.updatedAt.IsZero() {
+				updatedAt := override.updatedAt
+				flag.UpdatedAt = &updatedAt
+			}
+		}
+		flags = append(flags, flag)
+	}
+	return flags
+}
+
+// getFeatureFlag returns the current state of a known flag, or nil.
+func getFeatureFlag(name string) *FeatureFlag {
+	for _, flag := range listFeatureFlags() {
+		if flag.Name == name {
+			return flag
+		}
+	}
+	return nil
+}
+
+// setFeatureFlag stores an override for a flag.
+func setFeatureFlag(name string, enabled bool) error {
+	featureFlagsMutex.Lock()
+	defer featureFlagsMutex.Unlock()
+
+	override := featureFlagOverride{recordID: featureFlagOverrides[name].recordID, enabled: enabled, updatedAt: time.Now()}
+	if featureFlagsStored {
+		table := airtableClient.GetTable(airtableBaseID, featureFlagsTableName)
+		record := &airtable.Record{
+			ID: override.recordID,
+			Fields: map[string]any{
+				"Name":      name,
+				"Enabled":   enabled,
+				"UpdatedAt": override.updatedAt.Format(time.RFC3339),
+			},
+		}
+		records := &airtable.Records{Records: []*airtable.Record{record}}
+
+		var result *airtable.Records
+		var err error
+		if override.recordID != "" {
+			result, err = table.UpdateRecordsPartial(records)
+		} else {
+			result, err = table.AddRecords(records)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to store feature flag in Airtable: %v", err)
+		}
+		if len(result.Records) > 0 {
+			override.recordID = result.Records[0].ID
+		}
+	}
+
+	featureFlagOverrides[name] = override
+	return nil
+}
+
+// resetFeatureFlag removes a flag's override so its default applies again.
+func resetFeatureFlag(name string) error {
+	featureFlagsMutex.Lock()
+	defer featureFlagsMutex.Unlock()
+
+	override, ok := featureFlagOverrides[name]
+	if !ok {
+		return nil
+	}
+	if featureFlagsStored && override.recordID != "" {
+		table := airtableClient.GetTable(airtableBaseID, featureFlagsTableName)
+		if _, err := table.DeleteRecords([]string{override.recordID}); err != nil {
+			return fmt.Errorf("failed to delete feature flag from Airtable: %v", err)
+		}
+	}
+	delete(featureFlagOverrides, name)
+	return nil
+}
+
+// Handle feature flags (admin):
+// GET /api/admin/feature-flags lists every flag (?reload=true re-reads the table first),
+// PUT /api/admin/feature-flags/{name} with {"enabled": false} toggles one,
+// DELETE /api/admin/feature-flags/{name} resets it to its default.
+func handleAdminFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/feature-flags"), "/")
+
+	if name == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.URL.Query().Get("reload") == "true" && featureFlagsStored {
+			if err := loadFeatureFlags(); err != nil {
+				writeError(w, fmt.Sprintf("Failed to reload feature flags: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]*FeatureFlag{"flags": listFeatureFlags()})
+		return
+	}
+
+	if _, known := featureFlagDefault(name); !known {
+		writeError(w, "Unknown feature flag", http.StatusNotFound)
+		return
+	}
+
+	before := getFeatureFlag(name)
+	switch r.Method {
+	case http.MethodPut:
+		var req FeatureFlagRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+			writeError(w, "Request body must be {\"enabled\": true|false}", http.StatusBadRequest)
+			return
+		}
+		if err := setFeatureFlag(name, *req.Enabled); err != nil {
+			writeError(w, fmt.Sprintf("Failed to update feature flag: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Feature flag %s set to %v", name, *req.Enabled)
+		recordAudit(r, auditFeatureFlagSet, "feature_flag", name, before, getFeatureFlag(name))
+
+	case http.MethodDelete:
+		if err := resetFeatureFlag(name); err != nil {
+			writeError(w, fmt.Sprintf("Failed to reset feature flag: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Feature flag %s reset to its default", name)
+		recordAudit(r, auditFeatureFlagReset, "feature_flag", name, before, getFeatureFlag(name))
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(getFeatureFlag(name))
+}
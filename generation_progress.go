@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Generation stages pushed over /ws
+const (
+	progressRefining   = "refining_prompt"
+	progressGenerating = "generating"
+	progressCaching    = "caching"
+	progressDone       = "done"
+	progressFailed     = "failed"
+)
+
+const (
+	progressBufferSize   = 32
+	progressWriteTimeout = 10 * time.Second
+)
+
+// ProgressEvent reports how far an exercise generation for the user has got, so the
+// frontend can show real progress during the long model round trips.
+type ProgressEvent struct {
+	Stage   string    `json:"stage"`
+	Message string    `json:"message"`
+	TopicID string    `json:"topic_id,omitempty"`
+	Done    int       `json:"done,omitempty"`
+	Total   int       `json:"total,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Open /ws connections by user. Events are only kept in memory, so with several instances
+// a user sees the progress of generations running on the instance they are connected to.
+var (
+	progressMutex       sync.Mutex
+	progressSubscribers = make(map[string]map[chan ProgressEvent]bool)
+)
+
+type progressOwnerKey struct{}
+
+// withProgressOwner makes generations run with ctx report their progress to the user.
+func withProgressOwner(ctx context.Context, userID string) context.Context {
+	if userID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, progressOwnerKey{}, userID)
+}
+
+// reportProgress sends an event to the connections of the user the generation runs for,
+// if any. Slow connections miss events rather than holding up generation.
+func reportProgress(ctx context.Context, event ProgressEvent) {
+	userID, _ := ctx.Value(progressOwnerKey{}).(string)
+	if userID == "" {
+		return
+	}
+	event.Time = time.Now().UTC()
+
+	progressMutex.Lock()
+	defer progressMutex.Unlock()
+	for ch := range progressSubscribers[userID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func subscribeProgress(userID string) (chan ProgressEvent, func()) {
+	ch := make(chan ProgressEvent, progressBufferSize)
+	progressMutex.Lock()
+	if progressSubscribers[userID] == nil {
+		progressSubscribers[userID] = make(map[chan ProgressEvent]bool)
+	}
+	progressSubscribers[userID][ch] = true
+	progressMutex.Unlock()
+
+	return ch, func() {
+		progressMutex.Lock()
+		delete(progressSubscribers[userID], ch)
+		if len(progressSubscribers[userID]) == 0 {
+			delete(progressSubscribers, userID)
+		}
+		progressMutex.Unlock()
+	}
+}
+
+// checkWebSocketOrigin only accepts connections from pages served by this host, since
+// browsers send cookies with cross-site WebSocket handshakes.
+func checkWebSocketOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil || origin.Host != r.Host {
+		return fmt.Errorf("origin %q not allowed", r.Header.Get("Origin"))
+	}
+	config.Origin = origin
+	return nil
+}
+
+// Handle live generation progress: GET /ws upgrades to a WebSocket that receives a JSON
+// ProgressEvent for each step of the user's exercise generations. Logged-in users only,
+// since guests never trigger generation.
+func handleProgressWebSocket(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	server := websocket.Server{
+		Handshake: checkWebSocketOrigin,
+		Handler: func(ws *websocket.Conn) {
+			events, unsubscribe := subscribeProgress(userID)
+			defer unsubscribe()
+
+			// The client sends nothing; reading only notices when it goes away
+			closed := make(chan struct{})
+			go func() {
+				var discard []byte
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+				close(closed)
+			}()
+
+			for {
+				select {
+				case event := <-events:
+					ws.SetWriteDeadline(time.Now().Add(progressWriteTimeout))
+					if err := websocket.JSON.Send(ws, event); err != nil {
+						log.Printf("Warning: failed to send generation progress: %v", err)
+						return
+					}
+				case <-closed:
+					return
+				}
+			}
+		},
+	}
+	server.ServeHTTP(w, r)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Every exercise generation call a signed-in user causes counts against their daily and
+// monthly quota: USER_DAILY_GENERATIONS and USER_MONTHLY_GENERATIONS (0 is unlimited), unless
+// an admin set other limits for them. Days and months are UTC. The quota is checked before
+// the model is called. A learner over it still gets what is cached for the topic, and the
+// quota_exceeded error only when nothing is; /api/generate always gets the error. The other
+// paid calls count too (see useModelQuota): explanations and images that aren't cached yet,
+// hint translations, topic suggestions, speech answers, conversation messages and writing
+// corrections. Calls made with the user's own API key are free.
+const quotaDayLayout = "2006-01-02"
+
+// UserQuota is a user's generation usage, and the limits an admin set for them.
+type UserQuota struct {
+	ID           string `json:"-"`
+	UserID       string `json:"user_id"`
+	DailyLimit   *int   `json:"daily_limit,omitempty"` // nil uses USER_DAILY_GENERATIONS
+	MonthlyLimit *int   `json:"monthly_limit,omitempty"`
+	Day          string `json:"day,omitempty"` // YYYY-MM-DD of DayCount
+	DayCount     int    `json:"day_count"`
+	Month        string `json:"month,omitempty"` // YYYY-MM of MonthCount
+	MonthCount   int    `json:"month_count"`
+}
+
+// QuotaPeriod is the state of a daily or monthly quota.
+type QuotaPeriod struct {
+	Limit     int       `json:"limit"` // 0 is unlimited
+	Used      int       `json:"used"`
+	Remaining *int      `json:"remaining,omitempty"` // absent when unlimited
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+// QuotaStatus is what GET /api/user/quota returns.
+type QuotaStatus struct {
+	UserID    string      `json:"user_id"`
+	Daily     QuotaPeriod `json:"daily"`
+	Monthly   QuotaPeriod `json:"monthly"`
+	Exhausted bool        `json:"exhausted"`
+}
+
+type UserQuotaRequest struct {
+	DailyLimit   *int `json:"daily_limit"` // negative returns to the default
+	MonthlyLimit *int `json:"monthly_limit"`
+	ResetUsage   bool `json:"reset_usage"`
+}
+
+var (
+	userQuotasMutex  sync.Mutex                    // also serializes quota checks, so concurrent calls can't both take the last one
+	memoryUserQuotas = make(map[string]*UserQuota) // by user ID, with in-memory storage
+)
+
+func userQuotaFromRecord(record *airtable.Record) *UserQuota {
+	quota := &UserQuota{ID: record.ID}
+	if val, ok := record.Fields["UserID"].(string); ok {
+		quota.UserID = val
+	}
+	if val, ok := record.Fields["DailyLimit"].(float64); ok {
+		limit := int(val)
+		quota.DailyLimit = &limit
+	}
+	if val, ok := record.Fields["MonthlyLimit"].(float64); ok {
+		limit := int(val)
+		quota.MonthlyLimit = &limit
+	}
+	if val, ok := record.Fields["Day"].(string); ok {
+		quota.Day = val
+	}
+	if val, ok := record.Fields["DayCount"].(float64); ok {
+		quota.DayCount = int(val)
+	}
+	if val, ok := record.Fields["Month"].(string); ok {
+		quota.Month = val
+	}
+	if val, ok := record.Fields["MonthCount"].(float64); ok {
+		quota.MonthCount = int(val)
+	}
+	return quota
+}
+
+// getUserQuotas returns the quota records of every user who has generated or has own limits.
+func getUserQuotas() ([]*UserQuota, error) {
+	quotas := []*UserQuota{}
+	if airtableBaseID == "" {
+		for _, stored := range memoryUserQuotas {
+			c := *stored
+			quotas = append(quotas, &c)
+		}
+	} else {
+		records, err := getAllRecords(airtableClient.GetTable(airtableBaseID, generationQuotasTableName).GetRecords())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get generation quotas from Airtable: %v", err)
+		}
+		for _, record := range records.Records {
+			quotas = append(quotas, userQuotaFromRecord(record))
+		}
+	}
+	slices.SortFunc(quotas, func(a, b *UserQuota) int { return strings.Compare(a.UserID, b.UserID) })
+	return quotas, nil
+}
+
+// getUserQuota returns the user's quota record, or an empty one if they have none yet.
+// Callers must hold userQuotasMutex.
+func getUserQuota(userID string) (*UserQuota, error) {
+	if airtableBaseID == "" {
+		if stored, ok := memoryUserQuotas[userID]; ok {
+			c := *stored
+			return &c, nil
+		}
+		return &UserQuota{UserID: userID}, nil
+	}
+
+	records, err := airtableClient.GetTable(airtableBaseID, generationQuotasTableName).GetRecords().
+		WithFilterFormula(fmt.Sprintf("{UserID} = '%s'", userID)).MaxRecords(1).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get generation quota from Airtable: %v", err)
+	}
+	if len(records.Records) == 0 {
+		return &UserQuota{UserID: userID}, nil
+	}
+	return userQuotaFromRecord(records.Records[0]), nil
+}
+
+// saveUserQuota creates or updates a quota record. Callers must hold userQuotasMutex.
+func saveUserQuota(quota *UserQuota) error {
+	if airtableBaseID == "" {
+		c := *quota
+		memoryUserQuotas[quota.UserID] = &c
+		return nil
+	}
+
+	fields := map[string]any{
+		"UserID":       quota.UserID,
+		"DailyLimit":   quota.DailyLimit, // nil clears the field
+		"MonthlyLimit": quota.MonthlyLimit,
+		"Day":          quota.Day,
+		"DayCount":     quota.DayCount,
+		"Month":        quota.Month,
+		"MonthCount":   quota.MonthCount,
+	}
+	table := airtableClient.GetTable(airtableBaseID, generationQuotasTableName)
+	records := &airtable.Records{Records: []*airtable.Record{{ID: quota.ID, Fields: fields}}}
+	if quota.ID != "" {
+		if _, err := table.UpdateRecordsPartial(records); err != nil {
+			return fmt.Errorf("failed to update generation quota in Airtable: %v", err)
+		}
+		return nil
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return fmt.Errorf("failed to create generation quota in Airtable: %v", err)
+	}
+	if len(result.Records) > 0 {
+		quota.ID = result.Records[0].ID
+	}
+	return nil
+}
+
+// status works out the quota's limits and usage at now.
+func (q *UserQuota) status(now time.Time) QuotaStatus {
+	now = now.UTC()
+	day := now.Truncate(24 * time.Hour)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	status := QuotaStatus{
+		UserID:  q.UserID,
+		Daily:   QuotaPeriod{Limit: appConfig.UserDailyGenerations, ResetsAt: day.AddDate(0, 0, 1)},
+		Monthly: QuotaPeriod{Limit: appConfig.UserMonthlyGenerations, ResetsAt: month.AddDate(0, 1, 0)},
+	}
+	if q.DailyLimit != nil {
+		status.Daily.Limit = *q.DailyLimit
+	}
+	if q.MonthlyLimit != nil {
+		status.Monthly.Limit = *q.MonthlyLimit
+	}
+	if q.Day == now.Format(quotaDayLayout) {
+		status.Daily.Used = q.DayCount
+	}
+	if q.Month == now.Format(quotaMonthLayout) {
+		status.Monthly.Used = q.MonthCount
+	}
+	for _, period := range []*QuotaPeriod{&status.Daily, &status.Monthly} {
+		if period.Limit > 0 {
+			remaining := max(period.Limit-period.Used, 0)
+			period.Remaining = &remaining
+			status.Exhausted = status.Exhausted || remaining == 0
+		}
+	}
+	return status
+}
+
+// getQuotaStatus returns the user's quota at now.
+func getQuotaStatus(userID string, now time.Time) (QuotaStatus, error) {
+	userQuotasMutex.Lock()
+	defer userQuotasMutex.Unlock()
+	quota, err := getUserQuota(userID)
+	if err != nil {
+		return QuotaStatus{}, err
+	}
+	return quota.status(now), nil
+}
+
+// useUserQuota counts a generation call against the user's quota, or returns a 429
+// quota_exceeded error with the quota's state once it is used up. If the quota can't be
+// read or updated, the call is refused with a 503, so quotas can't be dodged.
+func useUserQuota(userID string, now time.Time) error {
+	userQuotasMutex.Lock()
+	defer userQuotasMutex.Unlock()
+
+	quota, err := getUserQuota(userID)
+	if err != nil {
+		log.Printf("Error checking generation quota of %s: %v", userID, err)
+		return errorWithStatus(http.StatusServiceUnavailable, "Your generation quota can't be checked right now")
+	}
+	status := quota.status(now)
+	if status.Exhausted {
+		period := "daily"
+		if status.Daily.Remaining == nil || *status.Daily.Remaining > 0 {
+			period = "monthly"
+		}
+		return &statusError{
+			status:  http.StatusTooManyRequests,
+			code:    "quota_exceeded",
+			message: fmt.Sprintf("You have used your %s exercise generation quota", period),
+			details: status,
+		}
+	}
+
+	now = now.UTC()
+	quota.Day, quota.DayCount = now.Format(quotaDayLayout), status.Daily.Used+1
+	quota.Month, quota.MonthCount = now.Format(quotaMonthLayout), status.Monthly.Used+1
+	if err := saveUserQuota(quota); err != nil {
+		log.Printf("Error recording generation usage of %s: %v", userID, err)
+		return errorWithStatus(http.StatusServiceUnavailable, "Your generation quota can't be checked right now")
+	}
+	return nil
+}
+
+// useModelQuota counts a paid model call other than exercise generation against the user's
+// quota, like useUserQuota. Guests and calls with the user's own API key aren't counted.
+func useModelQuota(ctx context.Context, userID string) error {
+	if userID == "" || usesOwnAPIKey(ctx) {
+		return nil
+	}
+	return useUserQuota(userID, time.Now())
+}
+
+// updatedLimit applies a requested limit: nil keeps the current one, a negative one returns
+// to the default.
+func updatedLimit(current, requested *int) *int {
+	switch {
+	case requested == nil:
+		return current
+	case *requested < 0:
+		return nil
+	}
+	return requested
+}
+
+// Handle the user's generation quota: GET /api/user/quota
+func handleUserQuota(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	status, err := getQuotaStatus(userID, time.Now())
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get quota: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// Handle generation quotas (admin):
+// GET /api/admin/quotas lists the defaults and every user who generated or has own limits,
+// GET /api/admin/quotas/{userID} returns one user's quota,
+// PUT /api/admin/quotas/{userID} sets their limits: {"daily_limit": 5, "monthly_limit": 50},
+// a negative limit returns to the default, and "reset_usage": true clears the counts.
+func handleAdminQuotas(w http.ResponseWriter, r *http.Request) {
+	userID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/quotas"), "/")
+	now := time.Now()
+
+	if userID == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		userQuotasMutex.Lock()
+		quotas, err := getUserQuotas()
+		userQuotasMutex.Unlock()
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to get quotas: %v", err), http.StatusInternalServerError)
+			return
+		}
+		users := []QuotaStatus{}
+		for _, quota := range quotas {
+			users = append(users, quota.status(now))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"daily_default":   appConfig.UserDailyGenerations,
+			"monthly_default": appConfig.UserMonthlyGenerations,
+			"users":           users,
+		})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		status, err := getQuotaStatus(userID, now)
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to get quota: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+
+	case http.MethodPut:
+		var req UserQuotaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if user, err := dataStore.GetUserByID(userID); err != nil || user == nil {
+			writeError(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		userQuotasMutex.Lock()
+		defer userQuotasMutex.Unlock()
+		quota, err := getUserQuota(userID)
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to get quota: %v", err), http.StatusInternalServerError)
+			return
+		}
+		before := *quota
+		quota.DailyLimit = updatedLimit(quota.DailyLimit, req.DailyLimit)
+		quota.MonthlyLimit = updatedLimit(quota.MonthlyLimit, req.MonthlyLimit)
+		if req.ResetUsage {
+			quota.DayCount, quota.MonthCount = 0, 0
+		}
+		if err := saveUserQuota(quota); err != nil {
+			writeError(w, fmt.Sprintf("Failed to update quota: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, auditUserQuotaSet, "user", userID, &before, quota)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(quota.status(now))
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPaidCallsUseQuota(t *testing.T) {
+	useMemoryStore(t)
+	config := appConfig
+	t.Cleanup(func() { appConfig = config })
+	appConfig = &Config{UserDailyGenerations: 1}
+	user := createTestUser(t, "learner-google-id")
+	ex, err := dataStore.CreateExercise("recTopic", "hash", "", `{"correct_german_sentence": "Ich lerne Deutsch, weil ich in Berlin wohne.", "english_hint": "I learn German because I live in Berlin."}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordAnswerCheck(user.ID, ex.AirtableID, time.Now())
+
+	if err := useUserQuota(user.ID, time.Now()); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		body    string
+	}{
+		{"explanation", handleExerciseAnswer, "/api/exercises/" + ex.AirtableID + "/explain", ""},
+		{"writing correction", handleCorrect, "/api/correct", `{"text": "Gestern ich bin nach Hause gegangen."}`},
+	} {
+		if rec := serve(tt.handler, user, http.MethodPost, tt.target, tt.body); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("%s over the quota: status %d, want %d", tt.name, rec.Code, http.StatusTooManyRequests)
+		}
+	}
+	if status, _ := getQuotaStatus(user.ID, time.Now()); status.Daily.Used != 1 {
+		t.Errorf("%d calls counted, want 1", status.Daily.Used)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+	"golang.org/x/oauth2"
+)
+
+// Google sign-in keeps the user's OAuth tokens, so integrations such as Classroom roster sync
+// or Calendar events can call Google on their behalf later. The login asks for offline
+// access, which Google answers with a refresh token on the first consent only; /auth/google/
+// login?consent=1 asks again, for users whose stored token has none. Tokens are only kept
+// with SECRETS_ENCRYPTION_KEY set, and are encrypted like other secrets (see secrets.go).
+// googleTokenSource refreshes an expired access token and stores the new one. A refresh
+// token Google rejects (revoked, or expired) is deleted, and the user has to sign in again.
+// GOOGLE_EXTRA_SCOPES adds the scopes such integrations need to the login.
+const googleRevokeURL = "https://oauth2.googleapis.com/revoke"
+
+var errGoogleNotConnected = errors.New("the user has not connected their Google account")
+
+// GoogleToken is a user's stored Google OAuth token.
+type GoogleToken struct {
+	ID           string    `json:"-"`
+	UserID       string    `json:"user_id"`
+	AccessToken  string    `json:"-"`
+	RefreshToken string    `json:"-"`
+	TokenType    string    `json:"-"`
+	Expiry       time.Time `json:"expiry"`
+	Scopes       []string  `json:"scopes"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// GoogleConnection is what GET /api/user/google returns.
+type GoogleConnection struct {
+	Connected       bool       `json:"connected"`
+	HasRefreshToken bool       `json:"has_refresh_token"` // false means access ends when the token expires
+	Scopes          []string   `json:"scopes"`
+	Expiry          *time.Time `json:"expiry,omitempty"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+}
+
+var (
+	googleTokensMutex  sync.Mutex // also serializes refreshes, so a refresh token isn't used twice at once
+	memoryGoogleTokens = make(map[string]*GoogleToken)
+)
+
+func googleTokenFromRecord(record *airtable.Record) *GoogleToken {
+	token := &GoogleToken{ID: record.ID}
+	if val, ok := record.Fields["UserID"].(string); ok {
+		token.UserID = val
+	}
+	if val, ok := record.Fields["AccessToken"].(string); ok {
+		token.AccessToken = readSecret(val, googleTokensTableName, "AccessToken", token.UserID)
+	}
+	if val, ok := record.Fields["RefreshToken"].(string); ok {
+		token.RefreshToken = readSecret(val, googleTokensTableName, "RefreshToken", token.UserID)
+	}
+	if val, ok := record.Fields["TokenType"].(string); ok {
+		token.TokenType = val
+	}
+	if val, ok := record.Fields["Expiry"].(string); ok {
+		token.Expiry, _ = time.Parse(time.RFC3339, val)
+	}
+	if val, ok := record.Fields["Scopes"].(string); ok && val != "" {
+		token.Scopes = strings.Fields(val)
+	}
+	if val, ok := record.Fields["UpdatedAt"].(string); ok {
+		token.UpdatedAt, _ = time.Parse(time.RFC3339, val)
+	}
+	return token
+}
+
+// getGoogleToken returns the user's token, or nil if they have none. Callers must hold
+// googleTokensMutex.
+func getGoogleToken(userID string) (*GoogleToken, error) {
+	if airtableBaseID == "" {
+		if stored, ok := memoryGoogleTokens[userID]; ok {
+			c := *stored
+			return &c, nil
+		}
+		return nil, nil
+	}
+
+	records, err := airtableClient.GetTable(airtableBaseID, googleTokensTableName).GetRecords().
+		WithFilterFormula(fmt.Sprintf("{UserID} = '%s'", userID)).MaxRecords(1).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Google token from Airtable: %v", err)
+	}
+	if len(records.Records) == 0 {
+		return nil, nil
+	}
+	return googleTokenFromRecord(records.Records[0]), nil
+}
+
+// saveGoogleToken creates or updates the user's token. Callers must hold googleTokensMutex.
+func saveGoogleToken(token *GoogleToken) error {
+	if airtableBaseID == "" {
+		c := *token
+		memoryGoogleTokens[token.UserID] = &c
+		return nil
+	}
+
+	accessToken, err := sealSecret(token.AccessToken, googleTokensTableName, "AccessToken", token.UserID)
+	if err != nil {
+		return err
+	}
+	refreshToken, err := sealSecret(token.RefreshToken, googleTokensTableName, "RefreshToken", token.UserID)
+	if err != nil {
+		return err
+	}
+	fields := map[string]any{
+		"UserID":       token.UserID,
+		"AccessToken":  accessToken,
+		"RefreshToken": refreshToken,
+		"TokenType":    token.TokenType,
+		"Expiry":       token.Expiry.Format(time.RFC3339),
+		"Scopes":       strings.Join(token.Scopes, " "),
+		"UpdatedAt":    token.UpdatedAt.Format(time.RFC3339),
+	}
+	table := airtableClient.GetTable(airtableBaseID, googleTokensTableName)
+	records := &airtable.Records{Records: []*airtable.Record{{ID: token.ID, Fields: fields}}}
+	if token.ID != "" {
+		if _, err := table.UpdateRecordsPartial(records); err != nil {
+			return fmt.Errorf("failed to update Google token in Airtable: %v", err)
+		}
+		return nil
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return fmt.Errorf("failed to create Google token in Airtable: %v", err)
+	}
+	if len(result.Records) > 0 {
+		token.ID = result.Records[0].ID
+	}
+	return nil
+}
+
+// deleteGoogleToken removes the user's token. Callers must hold googleTokensMutex.
+func deleteGoogleToken(token *GoogleToken) error {
+	if airtableBaseID == "" {
+		delete(memoryGoogleTokens, token.UserID)
+		return nil
+	}
+	table := airtableClient.GetTable(airtableBaseID, googleTokensTableName)
+	if _, err := table.DeleteRecords([]string{token.ID}); err != nil {
+		return fmt.Errorf("failed to delete Google token from Airtable: %v", err)
+	}
+	return nil
+}
+
+// storeGoogleToken keeps the token the user signed in with, or refreshed. Google only sends
+// a refresh token on consent, so a token without one keeps the stored refresh token.
+func storeGoogleToken(userID string, token *oauth2.Token) error {
+	if !secretsEncrypted() {
+		return nil
+	}
+	googleTokensMutex.Lock()
+	defer googleTokensMutex.Unlock()
+	return storeGoogleTokenLocked(userID, token)
+}
+
+func storeGoogleTokenLocked(userID string, token *oauth2.Token) error {
+	stored, err := getGoogleToken(userID)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		stored = &GoogleToken{UserID: userID}
+	}
+	stored.AccessToken = token.AccessToken
+	if token.RefreshToken != "" {
+		stored.RefreshToken = token.RefreshToken
+	}
+	stored.TokenType = token.TokenType
+	stored.Expiry = token.Expiry.UTC()
+	if scope, ok := token.Extra("scope").(string); ok && scope != "" {
+		stored.Scopes = strings.Fields(scope)
+	}
+	stored.UpdatedAt = time.Now().UTC()
+	return saveGoogleToken(stored)
+}
+
+func (t *GoogleToken) oauthToken() *oauth2.Token {
+	return &oauth2.Token{AccessToken: t.AccessToken, RefreshToken: t.RefreshToken, TokenType: t.TokenType, Expiry: t.Expiry}
+}
+
+// storedTokenSource hands out the user's access token, refreshing and storing it once it
+// has expired.
+type storedTokenSource struct {
+	ctx    context.Context
+	userID string
+}
+
+func (s *storedTokenSource) Token() (*oauth2.Token, error) {
+	googleTokensMutex.Lock()
+	defer googleTokensMutex.Unlock()
+	stored, err := getGoogleToken(s.userID)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || (stored.AccessToken == "" && stored.RefreshToken == "") {
+		return nil, errGoogleNotConnected
+	}
+	current := stored.oauthToken()
+	if current.Valid() {
+		return current, nil
+	}
+
+	token, err := googleOauthConfig.TokenSource(s.ctx, current).Token()
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			// Revoked or expired for good: only signing in again helps
+			if err := deleteGoogleToken(stored); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+			return nil, errGoogleNotConnected
+		}
+		return nil, fmt.Errorf("failed to refresh Google token: %v", err)
+	}
+	if err := storeGoogleTokenLocked(s.userID, token); err != nil {
+		log.Printf("Warning: failed to store refreshed Google token of user %s: %v", s.userID, err)
+	}
+	return token, nil
+}
+
+// googleTokenSource returns a token source for calling Google APIs as the user. Tokens are
+// refreshed as needed; errGoogleNotConnected means the user has to sign in with Google again.
+func googleTokenSource(ctx context.Context, userID string) (oauth2.TokenSource, error) {
+	if googleOauthConfig == nil {
+		return nil, fmt.Errorf("Google login is not configured")
+	}
+	source := &storedTokenSource{ctx: ctx, userID: userID}
+	if _, err := source.Token(); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// googleClient returns an HTTP client that calls Google APIs as the user.
+func googleClient(ctx context.Context, userID string) (*http.Client, error) {
+	source, err := googleTokenSource(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.NewClient(ctx, source), nil
+}
+
+// revokeGoogleToken asks Google to invalidate the token, and with a refresh token every
+// access token issued for it.
+func revokeGoogleToken(ctx context.Context, token *GoogleToken) error {
+	value := token.RefreshToken
+	if value == "" {
+		value = token.AccessToken
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleRevokeURL,
+		strings.NewReader(url.Values{"token": {value}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("Google answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Handle the user's Google connection: GET /api/user/google shows whether a token is stored
+// and its scopes, DELETE /api/user/google revokes and removes it.
+func handleUserGoogle(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	googleTokensMutex.Lock()
+	defer googleTokensMutex.Unlock()
+	token, err := getGoogleToken(userID)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get Google connection: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		connection := GoogleConnection{Scopes: []string{}}
+		if token != nil {
+			connection.Connected = true
+			connection.HasRefreshToken = token.RefreshToken != ""
+			connection.Scopes = append(connection.Scopes, token.Scopes...)
+			connection.Expiry, connection.UpdatedAt = &token.Expiry, &token.UpdatedAt
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(connection)
+
+	case http.MethodDelete:
+		if token == nil {
+			writeError(w, "Google account is not connected", http.StatusNotFound)
+			return
+		}
+		if err := revokeGoogleToken(r.Context(), token); err != nil {
+			log.Printf("Warning: failed to revoke Google token of user %s: %v", userID, err)
+		}
+		if err := deleteGoogleToken(token); err != nil {
+			writeError(w, fmt.Sprintf("Failed to disconnect Google: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
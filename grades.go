@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Review grades, as in Anki. Answering an exercise records a view with the grade. The grade
+// scales the (counter^2) days until the next review, and "again" also restarts the schedule
+// the next time the exercise is answered. Views without a grade are scheduled as "good".
+const (
+	gradeAgain = "again"
+	gradeHard  = "hard"
+	gradeGood  = "good"
+	gradeEasy  = "easy"
+)
+
+const maxReviewsPerRequest = 100
+
+// gradeIntervalFactors scale the next review interval by grade. "again" makes the exercise
+// due right away; it is served again from the next day, since answered exercises aren't
+// served again the same day.
+var gradeIntervalFactors = map[string]float64{
+	gradeAgain: 0,
+	gradeHard:  0.5,
+	gradeGood:  1,
+	gradeEasy:  2,
+}
+
+// reviewInterval is how long after its last view an exercise becomes due. Exercises that
+// needed hints come back sooner (see hints.go).
+func reviewInterval(view *UserExerciseView) time.Duration {
+	factor, ok := gradeIntervalFactors[view.Grade]
+	if !ok {
+		factor = 1
+	}
+	factor *= hintIntervalFactor(view.HintCount)
+	days := float64(view.RepetitionCounter*view.RepetitionCounter) * factor
+	return time.Duration(days * float64(24*time.Hour))
+}
+
+// markViewed records that an exercise was answered. A view graded "again" starts over at the
+// first repetition; the grade itself is cleared, because it belonged to the previous answer.
+func markViewed(view *UserExerciseView, now time.Time) {
+	if view.Grade == gradeAgain {
+		view.RepetitionCounter = 1
+	} else {
+		view.RepetitionCounter++
+	}
+	view.Grade = ""
+	view.LastViewed = now
+}
+
+type ReviewGrade struct {
+	ExerciseID    string `json:"exercise_id"`
+	Grade         string `json:"grade"`
+	HintPositions []int  `json:"hint_positions,omitempty"` // words (by position) the learner needed hints for
+}
+
+type ReviewsRequest struct {
+	Reviews []ReviewGrade `json:"reviews"`
+}
+
+// ReviewResult is an exercise's schedule after grading.
+type ReviewResult struct {
+	ExerciseID        string    `json:"exercise_id"`
+	Grade             string    `json:"grade"`
+	RepetitionCounter int       `json:"repetition_counter"`
+	NextReview        time.Time `json:"next_review"`
+}
+
+// Handle answers: POST /api/exercises/reviews with
+// {"reviews": [{"exercise_id": "rec...", "grade": "again|hard|good|easy", "hint_positions": [0, 3]}]}.
+// Each answer advances the exercise's SRS schedule, once a day: answering it again the same
+// day only replaces the grade. Works for guests too.
+func handleExerciseReviews(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReviewsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Reviews) == 0 || len(req.Reviews) > maxReviewsPerRequest {
+		writeError(w, fmt.Sprintf("reviews must hold 1 to %d grades", maxReviewsPerRequest), http.StatusBadRequest)
+		return
+	}
+
+	results, err := gradeExercises(getProgressOwnerID(w, r), req.Reviews)
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"reviews": results})
+}
+
+// gradeExercises records the owner's answers and returns the exercises' new schedules, in
+// the order they were first graded. It backs both the REST and the gRPC review endpoints.
+func gradeExercises(ownerID string, reviews []ReviewGrade) ([]ReviewResult, error) {
+	for _, review := range reviews {
+		if _, ok := gradeIntervalFactors[review.Grade]; !ok {
+			return nil, errorWithStatus(http.StatusBadRequest, "grade must be again, hard, good or easy")
+		}
+		if err := validateHintPositions(review.HintPositions); err != nil {
+			return nil, err
+		}
+	}
+
+	views, err := dataStore.GetUserExerciseViews(ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get user views: %v", err)
+	}
+	served, err := servedExerciseIDs(ownerID)
+	if err != nil {
+		log.Printf("Warning: failed to get current session of %s, accepting answers unchecked: %v", ownerID, err)
+	}
+
+	// Only exercises in the owner's current set or answered before can be graded; a later grade for the same one wins
+	now := time.Now()
+	graded := make(map[string]*UserExerciseView)
+	var order []string
+	for _, review := range reviews {
+		view, ok := graded[review.ExerciseID]
+		if !ok {
+			view, ok = views[review.ExerciseID]
+			if !ok && served != nil && !served[review.ExerciseID] {
+				return nil, errorWithStatus(http.StatusNotFound, "Exercise %s has not been served to you", review.ExerciseID)
+			}
+			if !ok {
+				view = &UserExerciseView{UserID: ownerID, ExerciseID: review.ExerciseID}
+			}
+			if !ok || !viewedToday(view, now) {
+				markViewed(view, now)
+			}
+			order = append(order, review.ExerciseID)
+			graded[review.ExerciseID] = view
+		}
+		view.Grade = review.Grade
+	}
+
+	// Hints are best effort; they only shorten intervals and feed the hint report
+	for _, review := range reviews {
+		if len(review.HintPositions) == 0 {
+			continue
+		}
+		if err := recordExerciseHints(ownerID, review.ExerciseID, review.HintPositions); err != nil {
+			log.Printf("Warning: failed to record hints for %s: %v", ownerID, err)
+		}
+	}
+	applyHintBoost(ownerID, graded)
+
+	var viewsToUpdate []*UserExerciseView
+	results := []ReviewResult{}
+	for _, exerciseID := range order {
+		view := graded[exerciseID]
+		viewsToUpdate = append(viewsToUpdate, view)
+		results = append(results, ReviewResult{
+			ExerciseID:        exerciseID,
+			Grade:             view.Grade,
+			RepetitionCounter: view.RepetitionCounter,
+			NextReview:        nextReviewAt(view).UTC(),
+		})
+	}
+	for start := 0; start < len(viewsToUpdate); start += 10 {
+		if err := dataStore.UpdateUserExerciseViews(viewsToUpdate[start:min(start+10, len(viewsToUpdate))]); err != nil {
+			log.Printf("Error saving review grades for %s: %v", ownerID, err)
+			return nil, fmt.Errorf("Failed to save review grades")
+		}
+	}
+	return results, nil
+}
+
+// servedExerciseIDs returns the IDs of the exercises in the owner's current set. It returns
+// nil with the error when the set can't be read, e.g. without a CurrentSessions table.
+func servedExerciseIDs(ownerID string) (map[string]bool, error) {
+	session, err := getCurrentSession(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	if session != nil {
+		for _, id := range session.exerciseIDs() {
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Grammar checking: with LANGUAGETOOL_URL set, the sentences of each generated batch are
+// sent to a LanguageTool server (POST /check) before they are cached, and sentences with
+// grammar or spelling mistakes are rejected like malformed exercises. Style, punctuation
+// and typography hints don't reject a sentence. All sentences of a batch go in one request,
+// as paragraphs of one text. If LanguageTool can't be reached, the batch is cached
+// unchecked, so an outage doesn't stop generation.
+
+// Issue types that reject a sentence
+var grammarIssueTypes = map[string]bool{"grammar": true, "misspelling": true}
+
+var grammarClient = &http.Client{Timeout: 20 * time.Second}
+
+// GrammarMatch is a problem LanguageTool found. Offset and length count UTF-16 code units.
+type GrammarMatch struct {
+	Message string `json:"message"`
+	Offset  int    `json:"offset"`
+	Length  int    `json:"length"`
+	Rule    struct {
+		ID        string `json:"id"`
+		IssueType string `json:"issueType"`
+	} `json:"rule"`
+}
+
+// checkGrammar checks sentences with LanguageTool. It returns, for each sentence, the first
+// mistake that rejects it, or "" when it has none.
+func checkGrammar(ctx context.Context, sentences []string) (mistakes []string, err error) {
+	ctx, end := startSpan(ctx, "check grammar", attribute.Int("sentence.count", len(sentences)))
+	defer func() { end(err) }()
+
+	// Sentences become paragraphs; starts[i] is where sentence i begins, in UTF-16 units
+	var text []uint16
+	starts := make([]int, len(sentences))
+	for i, sentence := range sentences {
+		if i > 0 {
+			text = append(text, '\n', '\n')
+		}
+		starts[i] = len(text)
+		text = append(text, utf16.Encode([]rune(sentence))...)
+	}
+
+	form := url.Values{"text": {string(utf16.Decode(text))}, "language": {"de-DE"}}
+	if appConfig.LanguageToolUsername != "" {
+		form.Set("username", appConfig.LanguageToolUsername)
+		form.Set("apiKey", appConfig.LanguageToolAPIKey)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", appConfig.LanguageToolURL+"/check", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create grammar check request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := grammarClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call LanguageTool: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LanguageTool response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("LanguageTool returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var result struct {
+		Matches []GrammarMatch `json:"matches"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse LanguageTool response: %w", err)
+	}
+
+	mistakes = make([]string, len(sentences))
+	for _, match := range result.Matches {
+		if !grammarIssueTypes[match.Rule.IssueType] || match.Offset < 0 || match.Offset+match.Length > len(text) {
+			continue
+		}
+		i := len(starts) - 1
+		for i > 0 && starts[i] > match.Offset {
+			i--
+		}
+		if mistakes[i] == "" {
+			excerpt := string(utf16.Decode(text[match.Offset : match.Offset+match.Length]))
+			mistakes[i] = fmt.Sprintf("%s: %q (%s)", strings.TrimSuffix(match.Message, "."), excerpt, match.Rule.ID)
+		}
+	}
+	return mistakes, nil
+}
+
+// validateGeneratedExercises checks a generated batch the way caching does: each exercise
+// must parse, keep to the topic's vocabulary band and, with LanguageTool configured, be free
+// of grammar and spelling mistakes. It returns each exercise's content, or why it is rejected.
+func validateGeneratedExercises(ctx context.Context, topic *Topic, exercises []json.RawMessage) ([]*ExerciseContent, []error) {
+	contents := make([]*ExerciseContent, len(exercises))
+	errs := make([]error, len(exercises))
+	var checked []int
+	var sentences []string
+	for i, exJSON := range exercises {
+		content, err := parseExerciseContent(string(exJSON))
+		if err == nil {
+			err = checkVocabularyBand(content, topic.VocabularyBand)
+		}
+		contents[i], errs[i] = content, err
+		if err == nil {
+			checked = append(checked, i)
+			sentences = append(sentences, content.Sentence)
+		}
+	}
+	if appConfig.LanguageToolURL == "" || len(sentences) == 0 {
+		return contents, errs
+	}
+
+	mistakes, err := checkGrammar(ctx, sentences)
+	if err != nil {
+		log.Printf("Warning: caching generated exercises for topic %s without a grammar check: %v", topic.ID, err)
+		return contents, errs
+	}
+	for j, i := range checked {
+		if mistakes[j] != "" {
+			errs[i] = fmt.Errorf("grammar check failed for %q: %s", sentences[j], mistakes[j])
+		}
+	}
+	return contents, errs
+}
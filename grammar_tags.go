@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Grammar tags name the grammar features an exercise practices, as "kind:value":
+// conjunction:obwohl, tense:perfect, mood:subjunctive or preposition:mit. They are derived
+// from the exercise when it is read: the model's conjunction_topic, plus an analysis of the
+// sentence's words. The analysis only looks at words, not at sentence structure, so it is a
+// heuristic: "als" after a comma is taken for a conjunction, a form of "haben" or "sein" next
+// to a participle for the perfect. Sets can be limited to exercises with given tags, and
+// generation for such a set asks the model for the features.
+const (
+	tagConjunction = "conjunction"
+	tagTense       = "tense"
+	tagMood        = "mood"
+	tagPreposition = "preposition"
+)
+
+const maxGrammarTagFilters = 5
+
+// Connectors tagged wherever they occur. Subordinating conjunctions are only tagged at the
+// start of the sentence or after a comma, because most double as prepositions or adverbs.
+var coordinatingConjunctions = map[string]bool{
+	"und": true, "oder": true, "aber": true, "denn": true, "sondern": true,
+	"deshalb": true, "deswegen": true, "darum": true, "daher": true, "trotzdem": true, "sonst": true,
+}
+
+// Prepositions and their contractions with an article
+var germanPrepositions = map[string]string{
+	"an": "an", "am": "an", "ans": "an", "auf": "auf", "aufs": "auf", "aus": "aus", "bei": "bei", "beim": "bei",
+	"durch": "durch", "für": "für", "gegen": "gegen", "hinter": "hinter", "in": "in", "im": "in", "ins": "in",
+	"mit": "mit", "nach": "nach", "neben": "neben", "ohne": "ohne", "trotz": "trotz", "über": "über", "um": "um",
+	"unter": "unter", "von": "von", "vom": "von", "vor": "vor", "wegen": "wegen", "zu": "zu", "zum": "zu",
+	"zur": "zu", "zwischen": "zwischen",
+}
+
+var (
+	perfectAuxiliaries    = wordSet("habe", "hast", "hat", "haben", "habt", "bin", "bist", "ist", "sind", "seid")
+	pluperfectAuxiliaries = wordSet("hatte", "hattest", "hatten", "hattet", "war", "warst", "waren", "wart")
+	futureAuxiliaries     = wordSet("werde", "wirst", "wird", "werden", "werdet")
+	subjunctiveForms      = wordSet("würde", "würdest", "würden", "würdet", "wäre", "wärst", "wären", "wärt", "hätte", "hättest", "hätten", "hättet", "könnte", "könnten", "müsste", "müssten", "sollte", "sollten")
+	// Irregular and modal preterite forms; regular ones ("lernte") look too much like other words
+	preteriteForms = wordSet("war", "warst", "waren", "wart", "hatte", "hattest", "hatten", "hattet", "konnte", "konnten",
+		"musste", "mussten", "wollte", "wollten", "durfte", "durften", "mochte", "mochten", "ging", "gingen", "kam", "kamen",
+		"sah", "sahen", "gab", "gaben", "wusste", "wussten", "dachte", "dachten", "fand", "fanden", "blieb", "blieben",
+		"fuhr", "fuhren", "las", "lasen", "schrieb", "schrieben", "sprach", "sprachen", "stand", "standen", "saß", "saßen",
+		"lag", "lagen", "nahm", "nahmen", "wurde", "wurden", "brachte", "brachten", "traf", "trafen", "aß", "aßen")
+)
+
+func wordSet(words ...string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, word := range words {
+		set[word] = true
+	}
+	return set
+}
+
+// looksLikeParticiple reports whether a lowercase word looks like a past participle:
+// "gelernt", "gegangen", "aufgestanden", "studiert".
+func looksLikeParticiple(word string) bool {
+	if _, ok := germanPrepositions[word]; ok {
+		return false // "gegen"
+	}
+	if strings.HasSuffix(word, "iert") && len(word) > 5 {
+		return true
+	}
+	i := strings.Index(word, "ge")
+	return i >= 0 && i <= 4 && len(word) > i+5 && (strings.HasSuffix(word, "t") || strings.HasSuffix(word, "en"))
+}
+
+// grammarTags analyzes an exercise's sentence and returns its grammar tags, sorted.
+func grammarTags(content *ExerciseContent) []string {
+	tags := make(map[string]bool)
+	if content.Conjunction != "" {
+		tags[tagConjunction+":"+strings.ToLower(content.Conjunction)] = true
+	}
+
+	var words []string
+	lowercase := make(map[int]bool) // words after the first that may be verbs; nouns are capitalized
+	clauseStart := true
+	for _, token := range tokenizeSentence(content.Sentence) {
+		if !wordTokenPattern.MatchString(token) {
+			clauseStart = token == "," || clauseStart
+			continue
+		}
+		word := strings.ToLower(token)
+		if len(words) > 0 && word == token {
+			lowercase[len(words)] = true
+		}
+		words = append(words, word)
+		if (clauseStart && subordinatingConjunctions[word]) || coordinatingConjunctions[word] {
+			tags[tagConjunction+":"+word] = true
+		}
+		if preposition, ok := germanPrepositions[word]; ok && !(clauseStart && subordinatingConjunctions[word]) {
+			tags[tagPreposition+":"+preposition] = true
+		}
+		clauseStart = false
+	}
+
+	hasParticiple, hasInfinitive := false, false
+	for i, word := range words {
+		if !lowercase[i] {
+			continue
+		}
+		if looksLikeParticiple(word) {
+			hasParticiple = true
+		} else if strings.HasSuffix(word, "en") && !futureAuxiliaries[word] && !perfectAuxiliaries[word] {
+			hasInfinitive = true
+		}
+	}
+	tense := func(name string) { tags[tagTense+":"+name] = true }
+	for _, word := range words {
+		switch {
+		case perfectAuxiliaries[word] && hasParticiple:
+			tense("perfect")
+		case pluperfectAuxiliaries[word] && hasParticiple:
+			tense("pluperfect")
+		case futureAuxiliaries[word] && hasInfinitive:
+			tense("future")
+		case preteriteForms[word]:
+			tense("preterite")
+		}
+		if subjunctiveForms[word] {
+			tags[tagMood+":subjunctive"] = true
+		}
+	}
+	hasTense := false
+	for tag := range tags {
+		hasTense = hasTense || strings.HasPrefix(tag, tagTense+":")
+	}
+	if !hasTense && !tags[tagMood+":subjunctive"] {
+		tense("present")
+	}
+
+	sorted := make([]string, 0, len(tags))
+	for tag := range tags {
+		sorted = append(sorted, tag)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// grammarTags returns the exercise's grammar tags.
+func (e *Exercise) grammarTags() []string {
+	return grammarTags(e.content())
+}
+
+// hasGrammarTag reports whether tags include the filter: a whole tag ("conjunction:obwohl")
+// or just its value ("obwohl"), ignoring case.
+func hasGrammarTag(tags []string, filter string) bool {
+	filter = strings.ToLower(strings.TrimSpace(filter))
+	for _, tag := range tags {
+		_, value, _ := strings.Cut(tag, ":")
+		if tag == filter || value == filter {
+			return true
+		}
+	}
+	return false
+}
+
+// validateGrammarTagFilters normalizes the grammar tags a set is limited to.
+func validateGrammarTagFilters(filters []string) ([]string, error) {
+	if len(filters) > maxGrammarTagFilters {
+		return nil, fmt.Errorf("grammar_tags can hold at most %d tags", maxGrammarTagFilters)
+	}
+	var cleaned []string
+	for _, filter := range filters {
+		if filter = strings.ToLower(strings.TrimSpace(filter)); filter != "" {
+			cleaned = append(cleaned, filter)
+		}
+	}
+	return cleaned, nil
+}
+
+// filterExercisesByGrammarTags returns the exercises with all the given tags. No tags match
+// every exercise.
+func filterExercisesByGrammarTags(exercises []*Exercise, filters []string) []*Exercise {
+	if len(filters) == 0 {
+		return exercises
+	}
+	var filtered []*Exercise
+	for _, ex := range exercises {
+		tags := ex.grammarTags()
+		matches := true
+		for _, filter := range filters {
+			matches = matches && hasGrammarTag(tags, filter)
+		}
+		if matches {
+			filtered = append(filtered, ex)
+		}
+	}
+	return filtered
+}
+
+// grammarTagsInstruction asks the model for sentences with the requested features.
+func grammarTagsInstruction(filters []string) string {
+	var features []string
+	for _, filter := range filters {
+		kind, value, found := strings.Cut(filter, ":")
+		if !found {
+			features = append(features, fmt.Sprintf("the feature %q", filter))
+			continue
+		}
+		features = append(features, fmt.Sprintf("the %s %q", kind, value))
+	}
+	return fmt.Sprintf("Every sentence must use %s.", strings.Join(features, " and "))
+}
+
+// GrammarTagCount is a grammar tag and how many of a topic's exercises have it.
+type GrammarTagCount struct {
+	Tag       string `json:"tag"`
+	Exercises int    `json:"exercises"`
+}
+
+// Handle GET /api/topics/{id}/grammar-tags: the grammar tags of the topic's cached exercises,
+// most common first, to offer as filters.
+func handleTopicGrammarTags(w http.ResponseWriter, r *http.Request, topicID string) {
+	exercises, err := dataStore.ListExercises(topicID)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get exercises: %v", err), http.StatusInternalServerError)
+		return
+	}
+	counts := make(map[string]int)
+	for _, ex := range exercises {
+		for _, tag := range ex.grammarTags() {
+			counts[tag]++
+		}
+	}
+	tags := []GrammarTagCount{}
+	for tag, count := range counts {
+		tags = append(tags, GrammarTagCount{Tag: tag, Exercises: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Exercises != tags[j].Exercises {
+			return tags[i].Exercises > tags[j].Exercises
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"tags": tags})
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"german-conjunctions-trainer/trainerpb"
+)
+
+// gRPC API for native clients, defined in trainerpb/trainer.proto. It is served on its own
+// port (GRPC_PORT) and shares its logic with the REST handlers, so topics, exercise sets,
+// daily limits, current sessions and review grading behave the same over both.
+// Calls authenticate with a personal access token; there are no guests over gRPC.
+
+// HTTP statuses of shared errors (statusError) as gRPC codes
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:      codes.InvalidArgument,
+	http.StatusUnauthorized:    codes.Unauthenticated,
+	http.StatusForbidden:       codes.PermissionDenied,
+	http.StatusNotFound:        codes.NotFound,
+	http.StatusGone:            codes.FailedPrecondition,
+	http.StatusTooManyRequests: codes.ResourceExhausted,
+}
+
+type trainerServer struct {
+	trainerpb.UnimplementedTrainerServer
+}
+
+// startGRPCServer serves the gRPC API in the background when GRPC_PORT is set.
+func startGRPCServer() {
+	if appConfig.GRPCPort == "" {
+		return
+	}
+	listener, err := net.Listen("tcp", ":"+appConfig.GRPCPort)
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC on port %s: %v", appConfig.GRPCPort, err)
+	}
+	server := grpc.NewServer()
+	trainerpb.RegisterTrainerServer(server, &trainerServer{})
+
+	go func() {
+		log.Printf("gRPC server starting on port %s", appConfig.GRPCPort)
+		if err := server.Serve(listener); err != nil {
+			log.Fatalf("gRPC server stopped: %v", err)
+		}
+	}()
+}
+
+// grpcUserID returns the user of the personal access token in the call's "authorization"
+// metadata, or "" for anonymous calls.
+func grpcUserID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if len(auth) >= 7 && strings.EqualFold(auth[:7], "Bearer ") {
+			return authenticateAPIToken(strings.TrimSpace(auth[7:]))
+		}
+	}
+	return ""
+}
+
+func requireGRPCUser(ctx context.Context) (string, error) {
+	userID := grpcUserID(ctx)
+	if userID == "" {
+		return "", status.Error(codes.Unauthenticated, "a valid personal access token is required")
+	}
+	return userID, nil
+}
+
+// grpcError converts an error from the shared logic into a gRPC status.
+func grpcError(err error) error {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		if code, ok := grpcCodes[statusErr.status]; ok {
+			return status.Error(code, statusErr.message)
+		}
+	}
+	log.Printf("gRPC error: %v", err)
+	return status.Error(codes.Internal, err.Error())
+}
+
+func topicMessage(topic *Topic) *trainerpb.Topic {
+	return &trainerpb.Topic{
+		Id:        topic.ID,
+		Name:      topic.Name,
+		Prompt:    topic.Prompt,
+		CreatedAt: timestamppb.New(topic.CreatedAt),
+		UpdatedAt: timestamppb.New(topic.UpdatedAt),
+	}
+}
+
+// exerciseMessage converts an exercise as served by /api/exercises.
+func exerciseMessage(raw json.RawMessage) *trainerpb.Exercise {
+	var ex struct {
+		ID                    string `json:"id"`
+		CorrectGermanSentence string `json:"correct_german_sentence"`
+		EnglishHint           string `json:"english_hint"`
+		ConjunctionTopic      string `json:"conjunction_topic"`
+	}
+	json.Unmarshal(raw, &ex)
+	return &trainerpb.Exercise{
+		Id:                    ex.ID,
+		CorrectGermanSentence: ex.CorrectGermanSentence,
+		EnglishHint:           ex.EnglishHint,
+		ConjunctionTopic:      ex.ConjunctionTopic,
+		Tokens:                tokenizeSentence(ex.CorrectGermanSentence),
+	}
+}
+
+func (s *trainerServer) ListTopics(ctx context.Context, req *trainerpb.ListTopicsRequest) (*trainerpb.ListTopicsResponse, error) {
+	topics, err := getActiveTopics(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	sort.SliceStable(topics, func(i, j int) bool { return topics[i].CreatedAt.Before(topics[j].CreatedAt) })
+
+	query := strings.ToLower(strings.TrimSpace(req.GetQuery()))
+	resp := &trainerpb.ListTopicsResponse{}
+	for _, topic := range topics {
+		if query == "" || strings.Contains(strings.ToLower(topic.Name), query) {
+			resp.Topics = append(resp.Topics, topicMessage(topic))
+		}
+	}
+	return resp, nil
+}
+
+func (s *trainerServer) GetTopic(ctx context.Context, req *trainerpb.GetTopicRequest) (*trainerpb.Topic, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	topic, err := dataStore.GetTopic(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "Topic not found: %v", err)
+	}
+	if !topicInTenant(ctx, topic) {
+		return nil, status.Error(codes.NotFound, "Topic not found")
+	}
+	return topicMessage(topic), nil
+}
+
+func (s *trainerServer) GetExercises(ctx context.Context, req *trainerpb.GetExercisesRequest) (*trainerpb.ExerciseSet, error) {
+	userID, err := requireGRPCUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if policy := rateLimitPolicies["exercises"]; policy != nil {
+		if ok, _ := allowRequest("exercises", "user:"+userID, policy); !ok {
+			return nil, status.Error(codes.ResourceExhausted, "You are making requests too quickly. Please wait a few seconds and try again.")
+		}
+	}
+
+	exercises, limits, err := serveExercises(ctx, GenerateRequest{
+		TopicID: req.GetTopicId(),
+		Level:   req.GetLevel(),
+		Theme:   req.GetTheme(),
+		Count:   int(req.GetCount()),
+	}, userID, userID)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	set := &trainerpb.ExerciseSet{Limits: &trainerpb.DailyLimits{
+		NewLimit:         int32(limits.NewLimit),
+		ReviewLimit:      int32(limits.ReviewLimit),
+		NewRemaining:     int32(limits.NewRemaining),
+		ReviewsRemaining: int32(limits.ReviewsRemaining),
+	}}
+	for _, raw := range exercises {
+		set.Exercises = append(set.Exercises, exerciseMessage(raw))
+	}
+	return set, nil
+}
+
+func (s *trainerServer) SubmitReviews(stream grpc.BidiStreamingServer[trainerpb.Review, trainerpb.ReviewResult]) error {
+	userID, err := requireGRPCUser(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	for {
+		review, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		results, err := gradeExercises(userID, []ReviewGrade{{ExerciseID: review.GetExerciseId(), Grade: review.GetGrade()}})
+		if err != nil {
+			return grpcError(err)
+		}
+		for _, result := range results {
+			err := stream.Send(&trainerpb.ReviewResult{
+				ExerciseId:        result.ExerciseID,
+				Grade:             result.Grade,
+				RepetitionCounter: int32(result.RepetitionCounter),
+				NextReview:        timestamppb.New(result.NextReview),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *trainerServer) WatchGeneration(req *trainerpb.WatchGenerationRequest, stream grpc.ServerStreamingServer[trainerpb.GenerationProgress]) error {
+	userID, err := requireGRPCUser(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	events, unsubscribe := subscribeProgress(userID)
+	defer unsubscribe()
+	for {
+		select {
+		case event := <-events:
+			err := stream.Send(&trainerpb.GenerationProgress{
+				Stage:   event.Stage,
+				Message: event.Message,
+				TopicId: event.TopicID,
+				Done:    int32(event.Done),
+				Total:   int32(event.Total),
+				Time:    timestamppb.New(event.Time),
+			})
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
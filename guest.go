@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Guests get a signed guest_id cookie. Their exercise views and stats are stored in the
+// regular tables under the owner ID "guest:<id>", and merged into the real account when
+// the guest signs in.
+const (
+	guestCookieName  = "guest_id"
+	guestOwnerPrefix = "guest:"
+	guestLifetime    = 90 * 24 * time.Hour
+)
+
+// getGuestIDFromRequest returns the guest ID from a validly signed guest cookie, or "".
+func getGuestIDFromRequest(r *http.Request) string {
+	cookie, err := r.Cookie(guestCookieName)
+	if err != nil {
+		return ""
+	}
+	// The signed value carries the owner prefix, so it can't be replayed as a session cookie
+	ownerID, _, ok := verifySessionValue(cookie.Value)
+	if !ok || !strings.HasPrefix(ownerID, guestOwnerPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(ownerID, guestOwnerPrefix)
+}
+
+// ensureGuestID returns the request's guest ID, issuing a new guest cookie if it has none.
+func ensureGuestID(w http.ResponseWriter, r *http.Request) string {
+	if guestID := getGuestIDFromRequest(r); guestID != "" {
+		return guestID
+	}
+
+	guestID := newRandomToken()
+	cookie := newCookie(guestCookieName, signSessionValue(guestOwnerPrefix+guestID, sessionKeys[0]), time.Now().Add(guestLifetime))
+	cookie.HttpOnly = true
+	http.SetCookie(w, cookie)
+	return guestID
+}
+
+// getProgressOwnerID returns the ID progress is stored under: the user ID when logged in,
+// otherwise the guest owner ID, issuing a guest cookie if needed.
+func getProgressOwnerID(w http.ResponseWriter, r *http.Request) string {
+	if userID := getUserIDFromRequest(r); userID != "" {
+		return userID
+	}
+	return guestOwnerPrefix + ensureGuestID(w, r)
+}
+
+// mergeGuestProgress moves a guest's exercise views and stats into a user's account.
+// Where both have viewed the same exercise, the further-along review state wins.
+func mergeGuestProgress(guestID, userID string) error {
+	ownerID := guestOwnerPrefix + guestID
+
+	guestViews, err := dataStore.GetUserExerciseViews(ownerID)
+	if err != nil {
+		return err
+	}
+	if len(guestViews) > 0 {
+		userViews, err := dataStore.GetUserExerciseViews(userID)
+		if err != nil {
+			return err
+		}
+
+		var toSave []*UserExerciseView
+		var toDelete []string
+		for exerciseID, guestView := range guestViews {
+			userView, exists := userViews[exerciseID]
+			if !exists {
+				guestView.UserID = userID
+				toSave = append(toSave, guestView)
+				continue
+			}
+			userView.RepetitionCounter = max(userView.RepetitionCounter, guestView.RepetitionCounter)
+			if guestView.LastViewed.After(userView.LastViewed) {
+				userView.LastViewed = guestView.LastViewed
+				userView.Grade = guestView.Grade
+			}
+			toSave = append(toSave, userView)
+			toDelete = append(toDelete, guestView.AirtableID)
+		}
+
+		for start := 0; start < len(toSave); start += 10 {
+			if err := dataStore.UpdateUserExerciseViews(toSave[start:min(start+10, len(toSave))]); err != nil {
+				return err
+			}
+		}
+		if err := dataStore.DeleteUserExerciseViews(toDelete); err != nil {
+			return err
+		}
+	}
+
+	if err := reassignStatsEvents(ownerID, userID); err != nil {
+		return err
+	}
+	if err := reassignExerciseHints(ownerID, userID); err != nil {
+		return err
+	}
+	if err := reassignWritingErrors(ownerID, userID); err != nil {
+		return err
+	}
+
+	guestStats, err := dataStore.GetUserStats(ownerID)
+	if err != nil {
+		return err
+	}
+	if guestStats.AirtableRecordID != "" {
+		userStats, err := dataStore.GetUserStats(userID)
+		if err != nil {
+			return err
+		}
+		userStats.TotalExercises += guestStats.TotalExercises
+		userStats.TotalMistakes += guestStats.TotalMistakes
+		userStats.TotalHints += guestStats.TotalHints
+		userStats.TotalTime += guestStats.TotalTime
+		if err := dataStore.UpdateUserStats(userStats); err != nil {
+			return fmt.Errorf("failed to merge guest stats: %v", err)
+		}
+
+		if err := dataStore.DeleteUserStats(guestStats.AirtableRecordID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startUserSession signs the user in, first merging any guest progress made on this browser.
+func startUserSession(w http.ResponseWriter, r *http.Request, userID string) {
+	if guestID := getGuestIDFromRequest(r); guestID != "" {
+		if err := mergeGuestProgress(guestID, userID); err != nil {
+			// Keep the guest cookie so the merge is retried on the next sign-in
+			log.Printf("Warning: failed to merge guest progress into user %s: %v", userID, err)
+		} else {
+			cookie := newCookie(guestCookieName, "", time.Unix(0, 0))
+			cookie.HttpOnly = true
+			http.SetCookie(w, cookie)
+		}
+	}
+	setSessionCookie(w, userID)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	readinessTimeout  = 3 * time.Second
+	readinessCacheTTL = 5 * time.Second // probes arrive every few seconds; Airtable allows 5 requests/s per base
+)
+
+// Health checks for orchestrators. /healthz (liveness) only reports that the process is
+// serving requests. /readyz (readiness) verifies the dependencies a request needs: the
+// data store answers, the tables the app needs are accessible, and optionally the model
+// API is reachable. A failing readiness check answers 503 so traffic is routed elsewhere.
+
+// HealthCheck is the result of one readiness check.
+type HealthCheck struct {
+	Status    string   `json:"status"` // ok, fail or skipped
+	LatencyMS int64    `json:"latency_ms,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Missing   []string `json:"missing,omitempty"`  // schema: required tables that are inaccessible
+	Optional  []string `json:"optional,omitempty"` // schema: optional tables that are inaccessible
+}
+
+type HealthResponse struct {
+	Status    string                  `json:"status"` // ok or unavailable
+	Checks    map[string]*HealthCheck `json:"checks,omitempty"`
+	CheckedAt time.Time               `json:"checked_at"`
+}
+
+var (
+	// Tables that could not be read at startup, with whether they are required. Readiness
+	// re-checks them, so creating a missing table makes the instance ready without a restart.
+	tableAccessMutex  sync.Mutex
+	tableAccessFailed = make(map[string]bool)
+
+	readinessMutex  sync.Mutex
+	readinessCache  *HealthResponse
+	readinessOpenAI bool // whether the cached response includes the OpenAI check
+)
+
+// recordTableAccess remembers the outcome of a table access check.
+func recordTableAccess(tableName string, required bool, err error) {
+	tableAccessMutex.Lock()
+	defer tableAccessMutex.Unlock()
+	if err != nil {
+		tableAccessFailed[tableName] = required
+	} else {
+		delete(tableAccessFailed, tableName)
+	}
+}
+
+func timedCheck(check func(ctx context.Context) error) *HealthCheck {
+	ctx, cancel := context.WithTimeout(context.Background(), readinessTimeout)
+	defer cancel()
+	start := time.Now()
+	err := check(ctx)
+	result := &HealthCheck{Status: "ok", LatencyMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = "fail"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// checkStorage reads a single topic to verify the data store answers.
+func checkStorage() *HealthCheck {
+	if airtableBaseID == "" {
+		return &HealthCheck{Status: "ok"} // In-memory storage
+	}
+	return timedCheck(func(ctx context.Context) error {
+		table := airtableClient.GetTable(airtableBaseID, topicsTableName)
+		if _, err := table.GetRecords().MaxRecords(1).ReturnFields("Name").DoContext(ctx); err != nil {
+			return fmt.Errorf("failed to read from Airtable: %v", err)
+		}
+		return nil
+	})
+}
+
+// checkSchema re-checks the tables that were inaccessible at startup, unless recheck is false
+// because Airtable is unreachable anyway. Only required tables fail the check; missing optional
+// tables are listed because they disable features.
+func checkSchema(recheck bool) *HealthCheck {
+	if airtableBaseID == "" {
+		return &HealthCheck{Status: "skipped"}
+	}
+	tableAccessMutex.Lock()
+	failed := make(map[string]bool, len(tableAccessFailed))
+	for name, required := range tableAccessFailed {
+		failed[name] = required
+	}
+	tableAccessMutex.Unlock()
+
+	result := &HealthCheck{Status: "ok"}
+	for name, required := range failed {
+		if !recheck {
+			if required {
+				result.Missing = append(result.Missing, name)
+			} else {
+				result.Optional = append(result.Optional, name)
+			}
+			continue
+		}
+		check := timedCheck(func(ctx context.Context) error {
+			_, err := airtableClient.GetTable(airtableBaseID, name).GetRecords().MaxRecords(1).DoContext(ctx)
+			return err
+		})
+		if check.Status == "ok" {
+			recordTableAccess(name, required, nil)
+			continue
+		}
+		if required {
+			result.Missing = append(result.Missing, name)
+		} else {
+			result.Optional = append(result.Optional, name)
+		}
+	}
+	sort.Strings(result.Missing)
+	sort.Strings(result.Optional)
+	if len(result.Missing) > 0 {
+		result.Status = "fail"
+		result.Error = "required tables are inaccessible"
+	}
+	return result
+}
+
+// checkOpenAI lists the models to verify the model API is reachable with the configured key.
+func checkOpenAI() *HealthCheck {
+	if appConfig.MockLLM || appConfig.OfflineMode {
+		return &HealthCheck{Status: "skipped"}
+	}
+	return timedCheck(func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, appConfig.OpenAIURL+"/models", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+appConfig.OpenAIAPIKey)
+		resp, err := llmHTTPClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to reach the model API: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("model API answered %s", resp.Status)
+		}
+		return nil
+	})
+}
+
+// checkReadiness runs the readiness checks, reusing a result younger than readinessCacheTTL.
+func checkReadiness(includeOpenAI bool) *HealthResponse {
+	readinessMutex.Lock()
+	defer readinessMutex.Unlock()
+	if readinessCache != nil && time.Since(readinessCache.CheckedAt) < readinessCacheTTL && readinessOpenAI == includeOpenAI {
+		return readinessCache
+	}
+
+	storage := checkStorage()
+	response := &HealthResponse{
+		Status: "ok",
+		Checks: map[string]*HealthCheck{
+			"storage": storage,
+			"schema":  checkSchema(storage.Status == "ok"),
+		},
+		CheckedAt: time.Now().UTC(),
+	}
+	if includeOpenAI {
+		response.Checks["openai"] = checkOpenAI()
+	}
+	for _, check := range response.Checks {
+		if check.Status == "fail" {
+			response.Status = "unavailable"
+		}
+	}
+
+	readinessCache = response
+	readinessOpenAI = includeOpenAI
+	return response
+}
+
+// Handle liveness: GET /healthz (and the older /health)
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(HealthResponse{Status: "ok", CheckedAt: time.Now().UTC()})
+}
+
+// Handle readiness: GET /readyz, with ?openai=true to check the model API too
+// (on by default with READINESS_CHECK_OPENAI=true)
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	includeOpenAI := appConfig.ReadinessCheckOpenAI
+	if value := r.URL.Query().Get("openai"); value != "" {
+		includeOpenAI = value == "true"
+	}
+
+	response := checkReadiness(includeOpenAI)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if response.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
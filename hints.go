@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Hints are recorded per word: which exercise and which word position (counting words
+// only, not punctuation) the learner needed a hint for. Exercises a learner needed hints
+// for come back sooner, and the report shows which grammar patterns lean on hints most.
+const (
+	maxHintsPerReview   = 50
+	hintIntervalStep    = 0.25 // each hint shortens the review interval by this much more
+	maxHintBoostHints   = 4    // ... up to this many hints, which halves it
+	defaultHintsLimit   = 10
+	maxHintsReportLimit = 100
+)
+
+// ExerciseHint is one hinted word.
+type ExerciseHint struct {
+	ID         string
+	OwnerID    string
+	ExerciseID string
+	Position   int
+	CreatedAt  time.Time
+}
+
+// HintPattern is a grammar pattern (an exercise's conjunction, within its topic) and how
+// much the learner relied on hints for it.
+type HintPattern struct {
+	TopicID     string  `json:"topic_id"`
+	TopicName   string  `json:"topic_name"`
+	Conjunction string  `json:"conjunction"`
+	Hints       int     `json:"hints"`
+	Exercises   int     `json:"exercises"` // exercises that needed hints
+	Answered    int     `json:"answered"`  // exercises of the pattern answered
+	HintRate    float64 `json:"hint_rate"` // hints per answered exercise
+}
+
+// HintedExercise is an exercise the learner needed hints for, with the hinted words.
+type HintedExercise struct {
+	ExerciseID  string       `json:"exercise_id"`
+	TopicID     string       `json:"topic_id"`
+	Sentence    string       `json:"sentence"`
+	Conjunction string       `json:"conjunction"`
+	Hints       int          `json:"hints"`
+	Words       []HintedWord `json:"words"`
+}
+
+type HintedWord struct {
+	Position int    `json:"position"`
+	Word     string `json:"word"`
+	Hints    int    `json:"hints"`
+}
+
+var (
+	exerciseHintsMutex  sync.Mutex
+	memoryExerciseHints []*ExerciseHint // with in-memory storage
+)
+
+func exerciseHintFromRecord(record *airtable.Record) *ExerciseHint {
+	hint := &ExerciseHint{ID: record.ID}
+	if val, ok := record.Fields["OwnerID"].(string); ok {
+		hint.OwnerID = val
+	}
+	if val, ok := record.Fields["ExerciseID"].(string); ok {
+		hint.ExerciseID = val
+	}
+	if val, ok := record.Fields["Position"].(float64); ok {
+		hint.Position = int(val)
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			hint.CreatedAt = t
+		}
+	}
+	return hint
+}
+
+// recordExerciseHints stores the hinted word positions of one answer.
+func recordExerciseHints(ownerID, exerciseID string, positions []int) error {
+	now := time.Now().UTC()
+	if airtableBaseID == "" {
+		exerciseHintsMutex.Lock()
+		defer exerciseHintsMutex.Unlock()
+		for _, position := range positions {
+			memoryExerciseHints = append(memoryExerciseHints, &ExerciseHint{
+				OwnerID:    ownerID,
+				ExerciseID: exerciseID,
+				Position:   position,
+				CreatedAt:  now,
+			})
+		}
+		return nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, exerciseHintsTableName)
+	for start := 0; start < len(positions); start += 10 {
+		records := &airtable.Records{}
+		for _, position := range positions[start:min(start+10, len(positions))] {
+			records.Records = append(records.Records, &airtable.Record{
+				Fields: map[string]any{
+					"OwnerID":    ownerID,
+					"ExerciseID": exerciseID,
+					"Position":   position,
+					"CreatedAt":  now.Format(time.RFC3339),
+				},
+			})
+		}
+		if _, err := table.AddRecords(records); err != nil {
+			return fmt.Errorf("failed to record exercise hints in Airtable: %v", err)
+		}
+	}
+	return nil
+}
+
+// getExerciseHints returns the owner's hints, or every owner's if ownerID is empty.
+func getExerciseHints(ownerID string) ([]*ExerciseHint, error) {
+	if airtableBaseID == "" {
+		exerciseHintsMutex.Lock()
+		defer exerciseHintsMutex.Unlock()
+		var hints []*ExerciseHint
+		for _, hint := range memoryExerciseHints {
+			if ownerID == "" || hint.OwnerID == ownerID {
+				c := *hint
+				hints = append(hints, &c)
+			}
+		}
+		return hints, nil
+	}
+
+	query := airtableClient.GetTable(airtableBaseID, exerciseHintsTableName).GetRecords()
+	if ownerID != "" {
+		query = query.WithFilterFormula(fmt.Sprintf("{OwnerID} = '%s'", ownerID))
+	}
+	records, err := getAllRecords(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exercise hints from Airtable: %v", err)
+	}
+	var hints []*ExerciseHint
+	for _, record := range records.Records {
+		hints = append(hints, exerciseHintFromRecord(record))
+	}
+	return hints, nil
+}
+
+// reassignExerciseHints moves every hint of one owner to another, when a guest signs in.
+func reassignExerciseHints(fromOwnerID, toOwnerID string) error {
+	if airtableBaseID == "" {
+		exerciseHintsMutex.Lock()
+		defer exerciseHintsMutex.Unlock()
+		for _, hint := range memoryExerciseHints {
+			if hint.OwnerID == fromOwnerID {
+				hint.OwnerID = toOwnerID
+			}
+		}
+		return nil
+	}
+
+	hints, err := getExerciseHints(fromOwnerID)
+	if err != nil {
+		return err
+	}
+	table := airtableClient.GetTable(airtableBaseID, exerciseHintsTableName)
+	for start := 0; start < len(hints); start += 10 {
+		records := &airtable.Records{}
+		for _, hint := range hints[start:min(start+10, len(hints))] {
+			records.Records = append(records.Records, &airtable.Record{
+				ID:     hint.ID,
+				Fields: map[string]any{"OwnerID": toOwnerID},
+			})
+		}
+		if _, err := table.UpdateRecords(records); err != nil {
+			return fmt.Errorf("failed to reassign exercise hints: %v", err)
+		}
+	}
+	return nil
+}
+
+// hintIntervalFactor scales the review interval of an exercise that needed hints.
+func hintIntervalFactor(hints int) float64 {
+	return 1 / (1 + hintIntervalStep*float64(min(hints, maxHintBoostHints)))
+}
+
+// applyHintBoost sets the hint counts of the owner's views, which shortens their review
+// intervals. Without hint data (e.g. no ExerciseHints table) the views are left as they are.
+func applyHintBoost(ownerID string, views map[string]*UserExerciseView) {
+	hints, err := getExerciseHints(ownerID)
+	if err != nil {
+		log.Printf("Warning: failed to get exercise hints of %s: %v", ownerID, err)
+		return
+	}
+	counts := make(map[string]int)
+	for _, hint := range hints {
+		counts[hint.ExerciseID]++
+	}
+	for exerciseID, view := range views {
+		view.HintCount = counts[exerciseID]
+	}
+}
+
+// validateHintPositions checks the hinted word positions of a review.
+func validateHintPositions(positions []int) error {
+	if len(positions) > maxHintsPerReview {
+		return errorWithStatus(http.StatusBadRequest, "hint_positions can hold at most %d positions", maxHintsPerReview)
+	}
+	for _, position := range positions {
+		if position < 0 {
+			return errorWithStatus(http.StatusBadRequest, "hint_positions must not be negative")
+		}
+	}
+	return nil
+}
+
+// sentenceWords returns the words of a sentence, without punctuation, as positions count them.
+func sentenceWords(sentence string) []string {
+	var words []string
+	for _, token := range tokenizeSentence(sentence) {
+		if wordTokenPattern.MatchString(token) {
+			words = append(words, token)
+		}
+	}
+	return words
+}
+
+// getHintReport returns the owner's grammar patterns by hints needed, and the limit most
+// hinted exercises.
+func getHintReport(ownerID string, limit int) ([]*HintPattern, []*HintedExercise, error) {
+	hints, err := getExerciseHints(ownerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	exercises, err := dataStore.ListExercises("")
+	if err != nil {
+		return nil, nil, err
+	}
+	views, err := dataStore.GetUserExerciseViews(ownerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	topics, err := dataStore.GetAllTopics()
+	if err != nil {
+		return nil, nil, err
+	}
+	topicNames := make(map[string]string)
+	for _, topic := range topics {
+		topicNames[topic.ID] = topic.Name
+	}
+
+	byID := make(map[string]*Exercise)
+	for _, ex := range exercises {
+		byID[ex.AirtableID] = ex
+	}
+	patternKey := func(ex *Exercise) string {
+		return ex.TopicID + "|" + strings.ToLower(ex.Conjunction)
+	}
+
+	patterns := make(map[string]*HintPattern)
+	pattern := func(ex *Exercise) *HintPattern {
+		key := patternKey(ex)
+		if patterns[key] == nil {
+			patterns[key] = &HintPattern{TopicID: ex.TopicID, TopicName: topicNames[ex.TopicID], Conjunction: ex.Conjunction}
+		}
+		return patterns[key]
+	}
+
+	hinted := make(map[string]*HintedExercise)
+	positions := make(map[string]map[int]int)
+	for _, hint := range hints {
+		ex, ok := byID[hint.ExerciseID]
+		if !ok {
+			continue // the exercise has since been deleted
+		}
+		h, ok := hinted[hint.ExerciseID]
+		if !ok {
+			h = &HintedExercise{ExerciseID: ex.AirtableID, TopicID: ex.TopicID, Sentence: ex.Sentence, Conjunction: ex.Conjunction}
+			hinted[hint.ExerciseID] = h
+			positions[hint.ExerciseID] = make(map[int]int)
+			pattern(ex).Exercises++
+		}
+		h.Hints++
+		positions[hint.ExerciseID][hint.Position]++
+		pattern(ex).Hints++
+	}
+	for exerciseID := range views {
+		if ex, ok := byID[exerciseID]; ok && patterns[patternKey(ex)] != nil {
+			pattern(ex).Answered++
+		}
+	}
+
+	patternList := []*HintPattern{}
+	for _, p := range patterns {
+		if p.Answered > 0 {
+			p.HintRate = float64(p.Hints) / float64(p.Answered)
+		}
+		patternList = append(patternList, p)
+	}
+	sort.Slice(patternList, func(i, j int) bool {
+		if patternList[i].Hints != patternList[j].Hints {
+			return patternList[i].Hints > patternList[j].Hints
+		}
+		return patternList[i].HintRate > patternList[j].HintRate
+	})
+
+	exerciseList := []*HintedExercise{}
+	for exerciseID, h := range hinted {
+		words := sentenceWords(h.Sentence)
+		for position, count := range positions[exerciseID] {
+			word := HintedWord{Position: position, Hints: count}
+			if position < len(words) {
+				word.Word = words[position]
+			}
+			h.Words = append(h.Words, word)
+		}
+		sort.Slice(h.Words, func(i, j int) bool { return h.Words[i].Position < h.Words[j].Position })
+		exerciseList = append(exerciseList, h)
+	}
+	sort.Slice(exerciseList, func(i, j int) bool {
+		if exerciseList[i].Hints != exerciseList[j].Hints {
+			return exerciseList[i].Hints > exerciseList[j].Hints
+		}
+		return exerciseList[i].ExerciseID < exerciseList[j].ExerciseID
+	})
+	if len(exerciseList) > limit {
+		exerciseList = exerciseList[:limit]
+	}
+	return patternList, exerciseList, nil
+}
+
+// Handle the hint report: GET /api/user/hints returns the owner's grammar patterns, most
+// hints first, and the ?limit=10 exercises they needed most hints for, with the hinted words.
+func handleUserHints(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ownerID := getProgressOwnerID(w, r)
+
+	limit := defaultHintsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxHintsReportLimit {
+			writeError(w, fmt.Sprintf("limit must be between 1 and %d", maxHintsReportLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	patterns, exercises, err := getHintReport(ownerID, limit)
+	if err != nil {
+		log.Printf("Error getting hint report: %v", err)
+		writeError(w, "Failed to get hint report", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"patterns": patterns, "exercises": exercises})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultLeaderboardSize = 20
+	maxLeaderboardSize     = 100
+	anonymousDisplayName   = "Anonymous learner"
+)
+
+type LeaderboardEntry struct {
+	Rank        int     `json:"rank"`
+	DisplayName string  `json:"display_name"`
+	Exercises   int     `json:"exercises"`
+	Accuracy    float64 `json:"accuracy"`
+	IsYou       bool    `json:"is_you,omitempty"`
+}
+
+// getWeeklyLeaderboard ranks opted-in users by exercises completed in the last 7 days,
+// breaking ties by accuracy. Anonymous users are listed without their display name.
+func getWeeklyLeaderboard(viewerID string, now time.Time) ([]*LeaderboardEntry, error) {
+	users, err := listUsers("{LeaderboardOptIn}")
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := getSessionsSince(now.Add(-7 * 24 * time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	byUser := make(map[string][]*Session)
+	for _, s := range sessions {
+		byUser[s.UserID] = append(byUser[s.UserID], s)
+	}
+
+	entries := []*LeaderboardEntry{}
+	for _, user := range users {
+		userSessions := byUser[user.ID]
+		if len(userSessions) == 0 {
+			continue
+		}
+
+		entry := &LeaderboardEntry{
+			DisplayName: user.DisplayName,
+			Accuracy:    accuracy(userSessions),
+			IsYou:       user.ID == viewerID,
+		}
+		for _, s := range userSessions {
+			entry.Exercises += s.Exercises
+		}
+		if user.LeaderboardAnonymous || entry.DisplayName == "" {
+			entry.DisplayName = anonymousDisplayName
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Exercises != entries[j].Exercises {
+			return entries[i].Exercises > entries[j].Exercises
+		}
+		return entries[i].Accuracy > entries[j].Accuracy
+	})
+	for i, entry := range entries {
+		entry.Rank = i + 1
+	}
+	return entries, nil
+}
+
+// Handle the weekly leaderboard: GET /api/leaderboard?limit=20
+func handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := defaultLeaderboardSize
+	if val, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && val > 0 {
+		limit = min(val, maxLeaderboardSize)
+	}
+
+	entries, err := getWeeklyLeaderboard(getUserIDFromRequest(r), time.Now())
+	if err != nil {
+		writeError(w, "Failed to get leaderboard", http.StatusInternalServerError)
+		return
+	}
+
+	// Always include the viewer's own entry, even outside the top positions
+	n := min(limit, len(entries))
+	top := append([]*LeaderboardEntry{}, entries[:n]...)
+	for _, entry := range entries[n:] {
+		if entry.IsYou {
+			top = append(top, entry)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]*LeaderboardEntry{"leaderboard": top})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exercise generation goes through an ordered chain of providers, LLM_FALLBACK, e.g.
+// "openai,gemini,cached". When a provider fails (a network error, an error response or
+// unusable content), the next one is tried. A provider that answers 429 is skipped until its
+// Retry-After has passed. With "cached" last, a set whose generation failed everywhere is
+// served from the cache instead of failing; without it, the request fails with 502.
+// Gemini is called through its OpenAI-compatible endpoint. Prompt refinement, explanations
+// and the other model features only use OpenAI. A tenant with its own OpenAI key uses only
+// that key (see tenants.go), and so does a user with their own key (see user_api_keys.go).
+const (
+	providerOpenAI = "openai"
+	providerGemini = "gemini"
+	providerCached = "cached"
+
+	defaultProviderCooldown = 30 * time.Second
+)
+
+// llmProvider is an OpenAI-compatible chat completions API.
+type llmProvider struct {
+	Name   string
+	URL    string
+	APIKey string
+	Model  string
+}
+
+var (
+	providerCooldownsMutex sync.Mutex
+	providerCooldowns      = make(map[string]time.Time) // rate-limited providers, until when they are skipped
+)
+
+// generationProviders returns the model providers of the fallback chain, in order. A user or
+// tenant with its own key only generates with that key.
+func generationProviders(ctx context.Context) []llmProvider {
+	if provider, ok := userLLMProvider(ctx); ok {
+		return []llmProvider{provider}
+	}
+	if tenant := tenantFromContext(ctx); tenant != nil && tenant.OpenAIAPIKey != "" {
+		return []llmProvider{tenant.llmProvider()}
+	}
+	var providers []llmProvider
+	for _, name := range appConfig.LLMFallback {
+		switch name {
+		case providerOpenAI:
+			providers = append(providers, llmProvider{Name: name, URL: appConfig.OpenAIURL, APIKey: appConfig.OpenAIAPIKey, Model: appConfig.ModelName})
+		case providerGemini:
+			providers = append(providers, llmProvider{Name: name, URL: appConfig.GeminiURL, APIKey: appConfig.GeminiAPIKey, Model: appConfig.GeminiModel})
+		}
+	}
+	return providers
+}
+
+// fallsBackToCache reports whether sets are served from the cache when generation fails.
+func fallsBackToCache() bool {
+	chain := appConfig.LLMFallback
+	return len(chain) > 0 && chain[len(chain)-1] == providerCached
+}
+
+func providerCoolingDown(name string, now time.Time) bool {
+	providerCooldownsMutex.Lock()
+	defer providerCooldownsMutex.Unlock()
+	return now.Before(providerCooldowns[name])
+}
+
+func coolDownProvider(name string, retryAfter string) {
+	cooldown := defaultProviderCooldown
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		cooldown = time.Duration(seconds) * time.Second
+	}
+	providerCooldownsMutex.Lock()
+	providerCooldowns[name] = time.Now().Add(cooldown)
+	providerCooldownsMutex.Unlock()
+}
+
+// completeWithFallback sends the chat request to each provider of the chain in turn, and
+// returns the first reply whose content passes check (if given), its raw response body and
+// the provider that gave it. The request's Model is set per provider.
+func completeWithFallback(ctx context.Context, req OpenAIRequest, check func(content string) error) (content string, respBody []byte, provider llmProvider, err error) {
+	var errs []string
+	now := time.Now()
+	for _, p := range generationProviders(ctx) {
+		if providerCoolingDown(p.Name, now) {
+			errs = append(errs, p.Name+": rate limited, skipped")
+			continue
+		}
+		content, respBody, err := complete(ctx, p, req)
+		if err == nil && check != nil {
+			err = check(content)
+		}
+		if err == nil {
+			trace.SpanFromContext(ctx).SetAttributes(attribute.String("llm.provider", p.Name), attribute.String("llm.model", p.Model))
+			return content, respBody, p, nil
+		}
+		if ctx.Err() != nil {
+			return "", nil, llmProvider{}, ctx.Err()
+		}
+		log.Printf("Warning: generation with %s failed: %v", p.Name, err)
+		errs = append(errs, fmt.Sprintf("%s: %v", p.Name, err))
+	}
+	return "", nil, llmProvider{}, fmt.Errorf("every provider failed: %s", strings.Join(errs, "; "))
+}
+
+// complete sends a chat request to one provider.
+func complete(ctx context.Context, p llmProvider, req OpenAIRequest) (string, []byte, error) {
+	req.Model = p.Model
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create request body: %w", err)
+	}
+	apiReq, err := http.NewRequestWithContext(ctx, "POST", p.URL+"/chat/completions", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create API request: %w", err)
+	}
+	apiReq.Header.Set("Content-Type", "application/json")
+	apiReq.Header.Set("Authorization", "Bearer "+p.APIKey)
+
+	resp, err := llmHTTPClient.Do(apiReq)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to call API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		coolDownProvider(p.Name, resp.Header.Get("Retry-After"))
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read API response: %w", err)
+	}
+	var openaiResp OpenAIResponse
+	if err := json.Unmarshal(respBody, &openaiResp); err != nil {
+		return "", nil, fmt.Errorf("failed to parse API response (status %d): %w", resp.StatusCode, err)
+	}
+	if openaiResp.Error != nil {
+		return "", nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, openaiResp.Error.Message)
+	}
+	if resp.StatusCode >= 300 {
+		return "", nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+	if len(openaiResp.Choices) == 0 || openaiResp.Choices[0].Message.Content == "" {
+		return "", nil, fmt.Errorf("received an empty response")
+	}
+	return openaiResp.Choices[0].Message.Content, respBody, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const defaultMockLLMFixtures = "fixtures"
+
+// With MOCK_LLM=true, requests to the chat completions API never leave the process: a fake
+// transport answers them from fixture files, so tests, CI and offline demos run without an
+// OpenAI key or network access. Each fixture is a JSON file in MOCK_LLM_FIXTURES (default
+// ./fixtures) shaped like a model response: {"exercises": [...]}.
+
+// mockLLMTransport answers chat completion requests. Exercise requests (JSON response format)
+// get a fixture picked by hashing the prompt, so the same prompt always gets the same
+// exercises, except hint translations, which get their hints back marked as translated. Text
+// requests (prompt refinements, grammar explanations) get their prompt back unchanged,
+// conversations and writing corrections a canned reply, image requests a blank image, and transcriptions the
+// recording read as text.
+type mockLLMTransport struct {
+	fixtures []json.RawMessage
+}
+
+func initMockLLM() {
+	if !appConfig.MockLLM {
+		return
+	}
+
+	dir := appConfig.MockLLMFixtures
+	fixtures, err := loadMockLLMFixtures(dir)
+	if err != nil {
+		log.Fatalf("Failed to load MOCK_LLM fixtures: %v", err)
+	}
+
+	llmHTTPClient = &http.Client{Transport: &mockLLMTransport{fixtures: fixtures}}
+	log.Printf("⚠️  MOCK_LLM enabled: exercises come from %d fixture file(s) in %s, OpenAI is never called", len(fixtures), dir)
+}
+
+// loadMockLLMFixtures reads every *.json file in dir, in name order.
+func loadMockLLMFixtures(dir string) ([]json.RawMessage, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.json files in %s", dir)
+	}
+	sort.Strings(paths)
+
+	var fixtures []json.RawMessage
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var fixture struct {
+			Exercises []json.RawMessage `json:"exercises"`
+		}
+		if err := json.Unmarshal(data, &fixture); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if len(fixture.Exercises) == 0 {
+			return nil, fmt.Errorf("%s: no exercises", path)
+		}
+		for i, ex := range fixture.Exercises {
+			if err := validateExerciseJSON(ex); err != nil {
+				return nil, fmt.Errorf("%s: exercise %d: %v", path, i+1, err)
+			}
+		}
+		fixtures = append(fixtures, data)
+	}
+	return fixtures, nil
+}
+
+func (t *mockLLMTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Path, "/images/generations") {
+		body, _ := json.Marshal(map[string]any{"data": []map[string]string{{"b64_json": mockImagePNG}}})
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(bytes.NewReader(body)),
+			Request:    req,
+		}, nil
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/audio/transcriptions") {
+		return mockTranscription(req)
+	}
+
+	var chatReq OpenAIRequest
+	if req.Body != nil {
+		defer req.Body.Close()
+		if err := json.NewDecoder(req.Body).Decode(&chatReq); err != nil {
+			return nil, fmt.Errorf("mock LLM: invalid request: %v", err)
+		}
+	}
+	prompt := ""
+	if len(chatReq.Messages) > 0 {
+		prompt = chatReq.Messages[len(chatReq.Messages)-1].Content
+	}
+
+	content := prompt
+	if len(chatReq.Messages) > 0 && strings.HasPrefix(chatReq.Messages[0].Content, conversationPrompt) {
+		content = mockConversationReply(prompt)
+	} else if language, ok := strings.CutPrefix(prompt, strings.Split(hintTranslationPrompt, "%s")[0]); ok {
+		content = mockHintTranslations(prompt, strings.SplitN(language, ".", 2)[0])
+	} else if strings.HasPrefix(prompt, writingCorrectionPrompt) {
+		content = mockWritingCorrection(prompt[strings.LastIndex(prompt, "\n\n")+2:])
+	} else if strings.HasPrefix(prompt, topicSuggestionPrompt) {
+		content = mockTopicSuggestions
+	} else if chatReq.ResponseFormat != nil && chatReq.ResponseFormat.Type == "json_object" {
+		h := fnv.New32a()
+		h.Write([]byte(prompt))
+		content = string(t.fixtures[h.Sum32()%uint32(len(t.fixtures))])
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"model": chatReq.Model,
+		"choices": []map[string]any{
+			{"message": map[string]string{"role": "assistant", "content": content}},
+		},
+	})
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// mockHintTranslations answers a hint translation request with its hints, prefixed with the language.
+func mockHintTranslations(prompt, language string) string {
+	var hints struct {
+		Hints []map[string]string `json:"hints"`
+	}
+	json.Unmarshal([]byte(prompt[strings.LastIndex(prompt, "\n\n")+2:]), &hints)
+	for _, hint := range hints.Hints {
+		hint["hint"] = fmt.Sprintf("[%s] %s", language, hint["hint"])
+	}
+	data, _ := json.Marshal(hints)
+	return string(data)
+}
+
+// mockTranscription hears the uploaded recording as text: a test can upload the words it
+// wants heard.
+func mockTranscription(req *http.Request) (*http.Response, error) {
+	if err := req.ParseMultipartForm(maxSpeechAudioSize); err != nil {
+		return nil, fmt.Errorf("mock LLM: invalid transcription request: %v", err)
+	}
+	file, _, err := req.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("mock LLM: transcription request has no file: %v", err)
+	}
+	defer file.Close()
+	text, _ := io.ReadAll(file)
+	body, _ := json.Marshal(map[string]any{
+		"text":     string(text),
+		"segments": []map[string]float64{{"avg_logprob": -0.1}},
+	})
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// mockConversationReply answers a conversation: the opening, or the learner's message
+// corrected to start with a capital letter when it doesn't.
+func mockConversationReply(message string) string {
+	reply := map[string]any{"correction": nil, "reply": "Hallo! Wie geht es dir heute?"}
+	if message != conversationOpening {
+		reply["reply"] = "Interessant! Erzähl mir mehr davon."
+		if first, _ := utf8.DecodeRuneInString(message); unicode.IsLower(first) {
+			reply["correction"] = map[string]string{
+				"corrected":   strings.ToUpper(string(first)) + message[utf8.RuneLen(first):],
+				"explanation": "Sentences start with a capital letter.",
+			}
+		}
+	}
+	data, _ := json.Marshal(reply)
+	return string(data)
+}
+
+// mockWritingCorrection finds two mistakes: a text not starting with a capital letter (a
+// spelling mistake), and "weil ich habe" (the word order after "weil"). Other texts have none.
+func mockWritingCorrection(text string) string {
+	corrected := text
+	mistakes := []map[string]string{}
+	if first, _ := utf8.DecodeRuneInString(text); unicode.IsLower(first) {
+		word, _, _ := strings.Cut(text, " ")
+		capitalized := strings.ToUpper(string(first)) + word[utf8.RuneLen(first):]
+		corrected = capitalized + text[len(word):]
+		mistakes = append(mistakes, map[string]string{
+			"category": "spelling", "original": word, "corrected": capitalized,
+			"explanation": "Sentences start with a capital letter.", "conjunction": "",
+		})
+	}
+	if strings.Contains(corrected, "weil ich habe") {
+		corrected = strings.ReplaceAll(corrected, "weil ich habe", "weil ich ... habe")
+		mistakes = append(mistakes, map[string]string{
+			"category": "word_order", "original": "weil ich habe", "corrected": "weil ich ... habe",
+			"explanation": "After weil, the verb goes to the end.", "conjunction": "weil",
+		})
+	}
+	data, _ := json.Marshal(map[string]any{"corrected": corrected, "errors": mistakes})
+	return string(data)
+}
+
+// mockImagePNG answers every image generation request: a 1x1 PNG, base64-encoded.
+const mockImagePNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg=="
+
+// mockTopicSuggestions answers every topic suggestion request.
+const mockTopicSuggestions = `{"suggestions": [
+	{"name": "Weil vs. denn", "prompt": "Generate 10 sentences that join two clauses with weil or denn, alternating between them, so the learner practices verb-final order after weil and main-clause order after denn.", "rationale": "You often put the verb in second position after weil."},
+	{"name": "Nebensatz first", "prompt": "Generate 10 sentences that start with a subordinate clause (with dass, wenn, obwohl or weil), so that the main clause begins with its verb.", "rationale": "You miss the inverted verb when the sentence starts with a subordinate clause."}
+]}`
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+const magicLinkLifetime = 15 * time.Minute
+
+// Magic links carry "email|nonce|expiry", signed with a key derived from the session key.
+// For an existing user the nonce is also stored on the user until it expires, so asking
+// again resends the same link, and it is cleared on first use, so the link works only once.
+// Links for new addresses create the user when they are used; that user has no nonce, so
+// the link can't be used again. Each address gets at most one email per magic_email rate
+// limit interval.
+
+// magicLinkMu serializes sign-ins, so two uses of a link can't both pass its nonce check or
+// both create the user.
+var magicLinkMu sync.Mutex
+
+func getUserByEmail(email string) (*User, error) {
+	users, err := listUsers(fmt.Sprintf("LOWER({Email}) = '%s'", strings.ToLower(email)))
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil // Not found
+	}
+	return users[0], nil
+}
+
+func createUserWithEmail(email string) (*User, error) {
+	table := airtableClient.GetTable(airtableBaseID, usersTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				Fields: map[string]any{
+					"Email": email,
+				},
+			},
+		},
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return nil, err
+	}
+	return userFromRecord(result.Records[0]), nil
+}
+
+// magicLinkKey derives the key links are signed with from a session key, so a link can't
+// pass for a session cookie or the other way round.
+func magicLinkKey(sessionKey []byte) []byte {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte("magiclink"))
+	return mac.Sum(nil)
+}
+
+func newMagicLinkToken(email, nonce string, expires time.Time) string {
+	payload := fmt.Sprintf("%s|%s|%d", email, nonce, expires.Unix())
+	return base64.RawURLEncoding.EncodeToString([]byte(signSessionValue(payload, magicLinkKey(sessionKeys[0]))))
+}
+
+// parseMagicLinkToken verifies a token's signature and expiry and returns its email address
+// and nonce.
+func parseMagicLinkToken(token string, now time.Time) (string, string, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", "", false
+	}
+	signed := string(raw)
+	i := strings.LastIndex(signed, ".")
+	if i < 0 || !slices.ContainsFunc(sessionKeys, func(key []byte) bool {
+		return hmac.Equal([]byte(signSessionValue(signed[:i], magicLinkKey(key))), raw)
+	}) {
+		return "", "", false
+	}
+	parts := strings.Split(signed[:i], "|")
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// sendMagicLink emails a one-time login link. An existing user's live link is sent again.
+func sendMagicLink(email string, now time.Time) error {
+	user, err := getUserByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	nonce, expires := newRandomToken(), now.Add(magicLinkLifetime)
+	if user != nil {
+		if user.MagicLinkNonce != "" && now.Before(user.MagicLinkExpires) {
+			nonce, expires = user.MagicLinkNonce, user.MagicLinkExpires
+		} else if _, err := updateUserFields(user.ID, map[string]any{"MagicLinkNonce": nonce, "MagicLinkExpires": expires.Format(time.RFC3339)}); err != nil {
+			return err
+		}
+	}
+
+	link := fmt.Sprintf("%s/auth/magic?token=%s", appBaseURL, newMagicLinkToken(email, nonce, expires))
+	body := fmt.Sprintf("Use this link to sign in to the German Conjunctions Trainer:\n\n%s\n\n"+
+		"The link expires in %d minutes and can only be used once. If you didn't request it, you can ignore this email.\n",
+		link, int(math.Ceil(expires.Sub(now).Minutes())))
+	return sendEmail(email, "Your sign-in link", body)
+}
+
+// consumeMagicLink returns the user a link signs in, creating it for a new address, and
+// uses up the link. It returns nil if the link was already used or replaced.
+func consumeMagicLink(email, nonce string) (*User, error) {
+	magicLinkMu.Lock()
+	defer magicLinkMu.Unlock()
+
+	user, err := getUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		if user, err = createUserWithEmail(email); err != nil {
+			return nil, fmt.Errorf("failed to create user: %v", err)
+		}
+		notifyWebhooks(webhookUserSignup, "New user signed up by email", map[string]string{"user_id": user.ID, "method": "email"})
+		return user, nil
+	}
+	if user.MagicLinkNonce == "" || user.MagicLinkNonce != nonce {
+		return nil, nil
+	}
+	if _, err := updateUserFields(user.ID, map[string]any{"MagicLinkNonce": ""}); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Handle magic link requests: POST /api/auth/magic-link { "email": "..." }
+func handleMagicLinkRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !mailerEnabled() {
+		writeError(w, "Email login is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	addr, err := mail.ParseAddress(strings.TrimSpace(req.Email))
+	if err != nil || addr.Address != strings.TrimSpace(req.Email) || strings.ContainsAny(addr.Address, `'"\|`) {
+		writeError(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+	if policy := rateLimitPolicies["magic_email"]; policy != nil {
+		if ok, retryAfter := allowRequest("magic_email", "email:"+strings.ToLower(addr.Address), policy); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeError(w, "A sign-in link was just sent to this address. Please check your email, or try again later.", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	if err := sendMagicLink(addr.Address, time.Now()); err != nil {
+		log.Printf("Error sending magic link: %v", err)
+		writeError(w, "Failed to send sign-in link", http.StatusInternalServerError)
+		return
+	}
+
+	// Same response whether or not the account already existed
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// The link opens a confirmation page that signs in with a POST, so link scanners in
+// email clients that prefetch URLs don't use up the one-time link.
+var magicLinkConfirmPage = template.Must(template.New("magic").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Sign in</title></head>
+<body style="font-family: sans-serif; text-align: center; margin-top: 4em;">
+<form method="POST" action="/auth/magic">
+<input type="hidden" name="token" value="{{.}}">
+<button type="submit" style="font-size: 1.2em; padding: 0.5em 1.5em;">Sign in to German Conjunctions Trainer</button>
+</form>
+</body></html>`))
+
+// Handle magic link sign-in: GET /auth/magic?token=... shows a confirmation page, POST establishes the session
+func handleMagicLinkLogin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		magicLinkConfirmPage.Execute(w, r.URL.Query().Get("token"))
+		return
+	case http.MethodPost:
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email, nonce, ok := parseMagicLinkToken(r.FormValue("token"), time.Now())
+	if !ok {
+		writeError(w, "This sign-in link is invalid or has expired. Please request a new one.", http.StatusBadRequest)
+		return
+	}
+
+	user, err := consumeMagicLink(email, nonce)
+	if err != nil {
+		log.Printf("Error signing in with a magic link: %v", err)
+		writeError(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		writeError(w, "This sign-in link has already been used. Please request a new one.", http.StatusBadRequest)
+		return
+	}
+
+	startUserSession(w, r, user.ID)
+	http.Redirect(w, r, tenantHome(r), http.StatusSeeOther)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestParseMagicLinkToken(t *testing.T) {
+	useMemoryStore(t)
+	now := time.Now()
+	token := newMagicLinkToken("anna@example.com", "nonce", now.Add(magicLinkLifetime))
+
+	email, nonce, ok := parseMagicLinkToken(token, now)
+	if !ok || email != "anna@example.com" || nonce != "nonce" {
+		t.Fatalf("parseMagicLinkToken() = %q, %q, %v", email, nonce, ok)
+	}
+	if _, _, ok := parseMagicLinkToken(token, now.Add(magicLinkLifetime+time.Second)); ok {
+		t.Error("an expired link was accepted")
+	}
+
+	// Session cookies and sign-in links are signed with different keys
+	cookie := signSessionValue("anna@example.com|nonce|9999999999", sessionKeys[0])
+	if _, _, ok := parseMagicLinkToken(base64.RawURLEncoding.EncodeToString([]byte(cookie)), now); ok {
+		t.Error("a session cookie passed for a sign-in link")
+	}
+	raw, _ := base64.RawURLEncoding.DecodeString(token)
+	if _, _, ok := verifySessionValue(string(raw)); ok {
+		t.Error("a sign-in link passed for a session cookie")
+	}
+}
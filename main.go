@@ -8,28 +8,38 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	mrand "math/rand"
 	"net"
 	"net/http"
-	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
-	"github.com/mehanizm/airtable"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	oauth2v2 "google.golang.org/api/oauth2/v2"
-	"golang.org/x/time/rate"
 )
 
 type GenerateRequest struct {
 	TopicID string `json:"topic_id"`
+	Level   string `json:"level,omitempty"`
+	Theme   string `json:"theme,omitempty"`
+	Count   int    `json:"count,omitempty"`
+	// easy, normal or hard (see difficulty.go); empty for the user's default
+	Difficulty string `json:"difficulty,omitempty"`
+	// Only exercises with all these grammar tags, e.g. "conjunction:obwohl" (see grammar_tags.go)
+	GrammarTags []string `json:"grammar_tags,omitempty"`
+	// Swap the rest of the set for harder exercises after a perfect start (see difficulty.go)
+	Escalate bool `json:"escalate,omitempty"`
 }
 
 type Topic struct {
@@ -38,13 +48,28 @@ type Topic struct {
 	Prompt      string    `json:"prompt"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	Archived    bool      `json:"archived"`
+
+	// Prompt refinement settings: refinement can be turned off for carefully engineered
+	// prompts, or use a custom meta-prompt instead of the default one.
+	RefinementDisabled bool   `json:"refinement_disabled"`
+	MetaPrompt         string `json:"meta_prompt,omitempty"`
+
+	// Generated sentences only use the VocabularyBand most frequent lemmas; 0 for no limit
+	VocabularyBand int `json:"vocabulary_band,omitempty"`
+
+	// The tenant (school) the topic belongs to; empty for the instance's own topics
+	TenantID string `json:"tenant_id,omitempty"`
 }
 
 type PromptVersion struct {
 	ID        string    `json:"id"`
 	TopicID   string    `json:"topic_id"`
+	Name      string    `json:"name,omitempty"` // the topic's name when the version was saved
 	Prompt    string    `json:"prompt"`
 	Version   int       `json:"version"`
+	Pinned    bool      `json:"pinned"`
+	Label     string    `json:"label,omitempty"` // e.g. "good v3 - fixed hints"
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -53,8 +78,15 @@ type Exercise struct {
 	AirtableID   string    `json:"airtable_id"`
 	TopicID      string    `json:"topic_id"`
 	PromptHash   string    `json:"prompt_hash"`
+	Theme        string    `json:"theme,omitempty"`
 	ExerciseJSON string    `json:"exercise_json"`
 	CreatedAt    time.Time `json:"created_at"`
+
+	// Typed fields parsed from ExerciseJSON (see exercise_model.go)
+	Sentence        string   `json:"sentence,omitempty"`
+	TranslationHint string   `json:"translation_hint,omitempty"`
+	Tokens          []string `json:"tokens,omitempty"`
+	Conjunction     string   `json:"conjunction,omitempty"`
 }
 
 type UserExerciseView struct {
@@ -64,6 +96,9 @@ type UserExerciseView struct {
 	ExerciseID        string    `json:"exercise_id"`
 	LastViewed        time.Time `json:"last_viewed"`
 	RepetitionCounter int       `json:"repetition_counter"`
+	Grade             string    `json:"grade,omitempty"` // the learner's grade for the latest serving, see grades.go
+	CreatedAt         time.Time `json:"created_at"`      // when the exercise was first answered; zero until stored
+	HintCount         int       `json:"-"`               // hints needed for the exercise, set by applyHintBoost; not stored
 }
 
 
@@ -73,9 +108,23 @@ type TopicRequest struct {
 }
 
 type User struct {
-	ID         string `json:"id"`
-	GoogleID   string `json:"google_id"`
-	AirtableID string `json:"airtable_id"`
+	ID                   string    `json:"id"`
+	GoogleID             string    `json:"google_id"`
+	Email                string    `json:"email,omitempty"`
+	DisplayName          string    `json:"display_name,omitempty"`
+	LeaderboardOptIn     bool      `json:"leaderboard_opt_in"`
+	LeaderboardAnonymous bool      `json:"leaderboard_anonymous"`
+	DailyNewLimit        *int      `json:"daily_new_limit,omitempty"`
+	DailyReviewLimit     *int      `json:"daily_review_limit,omitempty"`
+	NativeLanguage       string    `json:"native_language,omitempty"` // ISO 639-1 code, see native_hints.go
+	Distractors          bool      `json:"distractors"`               // opted in to wrong words in the word bank
+	Difficulty           string    `json:"difficulty,omitempty"`      // default difficulty, see difficulty.go
+	CalendarToken        string    `json:"-"`
+	PublicProfile        bool      `json:"public_profile"` // progress shared at /u/{PublicSlug}
+	PublicSlug           string    `json:"-"`
+	MagicLinkNonce       string    `json:"-"`
+	MagicLinkExpires     time.Time `json:"-"`
+	AirtableID           string    `json:"airtable_id"`
 }
 
 type UserStats struct {
@@ -93,6 +142,11 @@ type UpdateTopicRequest struct {
 	Prompt string `json:"prompt"`
 }
 
+type TopicRefinementRequest struct {
+	RefinementDisabled bool   `json:"refinement_disabled"`
+	MetaPrompt         string `json:"meta_prompt"`
+}
+
 type ResponseFormat struct {
 	Type string `json:"type"`
 }
@@ -121,25 +175,15 @@ type OpenAIResponse struct {
 	} `json:"error,omitempty"`
 }
 
-// Airtable configuration
 var (
-	airtableClient   *airtable.Client
-	airtableBaseID   string
-	topicsMutex      sync.RWMutex
-	
-	// Table names
-	topicsTableName            = "Topics"
-	versionsTableName          = "PromptVersions"
-	usersTableName             = "Users"
-	userStatsTableName         = "UserStats"
-	exercisesTableName         = "Exercises"
-	userExerciseViewsTableName = "UserExerciseViews"
-
 	// For observability
 	lastRefinedPrompt      string
 	lastRefinedPromptMutex sync.RWMutex
 )
 
+// llmHTTPClient sends chat completion requests; MOCK_LLM swaps in a fake transport.
+var llmHTTPClient = &http.Client{}
+
 // Google OAuth2 configuration
 var (
 	googleOauthConfig *oauth2.Config
@@ -147,24 +191,23 @@ var (
 	googleAdminID     string
 )
 
-
-// Rate limiting
-var (
-	clients = make(map[string]*client)
-	mu      sync.Mutex
-)
-
-type client struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
-}
-
+// getClientIP returns the caller's address. X-Forwarded-For is only believed when the request
+// comes from a trusted proxy (TRUSTED_PROXIES), and then the client is the right-most hop
+// that isn't one: everything left of it was sent by the client and could be made up.
 func getClientIP(r *http.Request) string {
-	ip := r.Header.Get("X-Forwarded-For")
-	if ip != "" {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !isTrustedProxy(ip) {
 		return ip
 	}
-	ip, _, _ = net.SplitHostPort(r.RemoteAddr)
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hop := strings.TrimSpace(hops[i]); hop != "" && !isTrustedProxy(hop) {
+			return hop
+		}
+	}
 	return ip
 }
 
@@ -185,132 +228,10 @@ Here is the prompt to refine:
 
 
 
-// Initialize Airtable client
-func initStorage() {
-	airtableToken := os.Getenv("AIRTABLE_TOKEN")
-	airtableBaseID = os.Getenv("AIRTABLE_BASE_ID")
-	
-	if airtableToken == "" {
-		log.Fatal("AIRTABLE_TOKEN environment variable is required")
-	}
-	if airtableBaseID == "" {
-		log.Fatal("AIRTABLE_BASE_ID environment variable is required")
-	}
-	
-	airtableClient = airtable.NewClient(airtableToken)
-	log.Printf("Airtable integration initialized with base ID: %s", airtableBaseID)
-	
-	// Verify and setup tables
-	err := setupAirtableTables()
-	if err != nil {
-		log.Printf("Warning: Could not setup Airtable tables: %v", err)
-	}
-	
-	// Check permissions
-	checkAirtablePermissions()
-}
-
-// Setup Airtable tables if they don't exist or verify their structure
-func setupAirtableTables() error {
-	log.Printf("Setting up Airtable tables...")
-	
-	// Try to create the tables using Airtable's API
-	err := createAirtableTables()
-	if err != nil {
-		log.Printf("Could not auto-create tables: %v", err)
-		return err
-	}
-	
-	return nil
-}
-
-// Create Airtable tables using the Metadata API
-func createAirtableTables() error {
-	// Note: Airtable's table creation via API requires Base Schema API access
-	// For now, we'll provide instructions for manual creation
-
-	log.Printf("Please manually create these tables in your Airtable base:")
-	log.Printf("")
-	log.Printf("📋 Table 1: 'Topics'")
-	log.Printf("   • Name: Single line text")
-	log.Printf("   • Prompt: Long text")
-	log.Printf("   • CreatedAt: Single line text (optional)")
-	log.Printf("   • UpdatedAt: Single line text (optional)")
-	log.Printf("")
-	log.Printf("📋 Table 2: 'PromptVersions'")
-	log.Printf("   • TopicID: Single line text")
-	log.Printf("   • Prompt: Long text")
-	log.Printf("   • Version: Number")
-	log.Printf("   • CreatedAt: Single line text (optional)")
-	log.Printf("")
-	log.Printf("📋 Table 3: 'Exercises'")
-	log.Printf("   • TopicID: Single line text (Link to 'Topics' table is recommended)")
-	log.Printf("   • PromptHash: Single line text")
-	log.Printf("   • ExerciseJSON: Long text")
-	log.Printf("   • CreatedAt: Created time (Airtable managed)")
-	log.Printf("")
-	log.Printf("📋 Table 4: 'UserExerciseViews'")
-	log.Printf("   • UserID: Single line text (Link to 'Users' table is recommended)")
-	log.Printf("   • ExerciseID: Single line text (Link to 'Exercises' table is recommended)")
-	log.Printf("   • LastViewed: Date and time")
-	log.Printf("   • RepetitionCounter: Number (Default to 0)")
-	log.Printf("   • NextReview: Formula (Optional, for debugging). Formula: DATEADD({LastViewed}, POWER({RepetitionCounter}, 2), 'days')")
-	log.Printf("")
-	log.Printf("💡 Tip: The timestamp fields (CreatedAt, UpdatedAt) are optional.")
-	log.Printf("💡 The app will work with just the required fields if timestamps are missing.")
-	log.Printf("")
-
-	return fmt.Errorf("manual table creation required")
-}
-
-// Check Airtable permissions for all tables
-func checkAirtablePermissions() {
-	log.Printf("Checking Airtable permissions...")
-
-	tables := []struct {
-		name        string
-		required    bool
-		description string
-	}{
-		{topicsTableName, true, "Core functionality will be severely limited."},
-		{versionsTableName, false, "Version history will be disabled."},
-		{usersTableName, false, "User authentication will be disabled."},
-		{userStatsTableName, false, "User statistics will not be saved."},
-		{exercisesTableName, true, "Core functionality of serving exercises will be disabled."},
-		{userExerciseViewsTableName, false, "SRS functionality will be disabled for authenticated users."},
-	}
-
-	for _, table := range tables {
-		checkTableAccess(table.name, table.required, table.description)
-	}
-}
-
-func checkTableAccess(tableName string, required bool, consequence string) {
-	table := airtableClient.GetTable(airtableBaseID, tableName)
-	_, err := table.GetRecords().Do() // Check without max records for compatibility
-
-	if err != nil {
-		prefix := "⚠️"
-		if required {
-			prefix = "❌"
-		}
-
-		if strings.Contains(err.Error(), "status 403") || strings.Contains(err.Error(), "INVALID_PERMISSIONS") {
-			log.Printf("%s No access to '%s' table. Check token permissions. %s", prefix, tableName, consequence)
-		} else if strings.Contains(err.Error(), "status 404") {
-			log.Printf("%s '%s' table not found. Please create it manually. %s", prefix, tableName, consequence)
-		} else {
-			log.Printf("⚠️  %s table access error: %v", tableName, err)
-		}
-	} else {
-		log.Printf("✅ %s table access: OK", tableName)
-	}
-}
-
 // Initialize with default topics
 func initializeDefaultTopics() {
 	// Check if we already have topics (to avoid duplicating on restart)
-	existingTopics, err := getAllTopics()
+	existingTopics, err := dataStore.GetAllTopics()
 	if err != nil {
 		log.Printf("Warning: Could not check existing topics: %v", err)
 		log.Printf("Attempting to create default topics anyway...")
@@ -363,7 +284,7 @@ Return ONLY the JSON object, with no other text or explanations.`,
 
 	log.Printf("Initializing %d default topics...", len(defaultTopics))
 	for _, defaultTopic := range defaultTopics {
-		topic, err := createTopic(defaultTopic.name, defaultTopic.prompt)
+		topic, err := createTopic(defaultTopic.name, defaultTopic.prompt, "")
 		if err != nil {
 			log.Printf("Error creating default topic '%s': %v", defaultTopic.name, err)
 		} else {
@@ -372,513 +293,135 @@ Return ONLY the JSON object, with no other text or explanations.`,
 	}
 }
 
-// Data access functions using Airtable
-func createTopic(name, prompt string) (*Topic, error) {
-	table := airtableClient.GetTable(airtableBaseID, topicsTableName)
-	now := time.Now().Format(time.RFC3339)
-	
-	// Try with timestamp fields first, fallback to just required fields
-	fields := map[string]any{
-		"Name":   name,
-		"Prompt": prompt,
-	}
-	
-	// Try to add timestamp fields if they exist
-	records := &airtable.Records{
-		Records: []*airtable.Record{
-			{
-				Fields: map[string]any{
-					"Name":      name,
-					"Prompt":    prompt,
-					"CreatedAt": now,
-					"UpdatedAt": now,
-				},
-			},
-		},
-	}
-	
-	result, err := table.AddRecords(records)
-	if err != nil {
-		// If it failed due to unknown fields, try with minimal fields
-		if strings.Contains(err.Error(), "UNKNOWN_FIELD_NAME") {
-			log.Printf("Timestamp fields not found, creating with minimal fields")
-			records.Records[0].Fields = fields
-			result, err = table.AddRecords(records)
-		}
-		
-		if err != nil {
-			return nil, fmt.Errorf("failed to create topic in Airtable: %v", err)
-		}
-	}
-	
-	if len(result.Records) == 0 {
-		return nil, fmt.Errorf("no records returned from Airtable")
-	}
-	
-	topic := &Topic{
-		ID:        result.Records[0].ID,
-		Name:      name,
-		Prompt:    prompt,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-	
-	// Create initial version
-	err = addPromptVersion(topic.ID, prompt)
-	if err != nil {
-		log.Printf("Warning: Failed to create initial version: %v", err)
-	}
-	
-	return topic, nil
+func getPromptHash(prompt string) string {
+	hash := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(hash[:])
 }
 
-func getAllTopics() ([]*Topic, error) {
-	table := airtableClient.GetTable(airtableBaseID, topicsTableName)
-	
-	records, err := table.GetRecords().Do()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get topics from Airtable: %v", err)
-	}
-	
-	var topics []*Topic
-	for _, record := range records.Records {
-		topic := &Topic{
-			ID: record.ID,
-		}
-		
-		if name, ok := record.Fields["Name"].(string); ok {
-			topic.Name = name
-		}
-		if prompt, ok := record.Fields["Prompt"].(string); ok {
-			topic.Prompt = prompt
-		}
-		if createdAt, ok := record.Fields["CreatedAt"].(string); ok {
-			if t, err := time.Parse(time.RFC3339, createdAt); err == nil {
-				topic.CreatedAt = t
-			}
-		}
-		if updatedAt, ok := record.Fields["UpdatedAt"].(string); ok {
-			if t, err := time.Parse(time.RFC3339, updatedAt); err == nil {
-				topic.UpdatedAt = t
-			}
-		}
-		
-		topics = append(topics, topic)
-	}
-	
-	// Sort by creation time
-	sort.Slice(topics, func(i, j int) bool {
-		return topics[i].CreatedAt.Before(topics[j].CreatedAt)
-	})
-	
-	return topics, nil
-}
+// Prompt template variables. Topic prompts may contain {{level}}, {{count}}
+// and {{vocab_theme}} placeholders that are filled in at generation time.
+const (
+	defaultLevel         = "B1"
+	defaultVocabTheme    = "everyday life"
+	defaultExerciseCount = 10
+	minExerciseCount     = 5
+	maxExerciseCount     = 30
+)
 
-func getTopic(topicID string) (*Topic, error) {
-	table := airtableClient.GetTable(airtableBaseID, topicsTableName)
-	
-	record, err := table.GetRecord(topicID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get topic from Airtable: %v", err)
-	}
-	
-	topic := &Topic{
-		ID: record.ID,
-	}
-	
-	if name, ok := record.Fields["Name"].(string); ok {
-		topic.Name = name
-	}
-	if prompt, ok := record.Fields["Prompt"].(string); ok {
-		topic.Prompt = prompt
-	}
-	if createdAt, ok := record.Fields["CreatedAt"].(string); ok {
-		if t, err := time.Parse(time.RFC3339, createdAt); err == nil {
-			topic.CreatedAt = t
-		}
-	}
-	if updatedAt, ok := record.Fields["UpdatedAt"].(string); ok {
-		if t, err := time.Parse(time.RFC3339, updatedAt); err == nil {
-			topic.UpdatedAt = t
-		}
-	}
-	
-	return topic, nil
+type PromptVars struct {
+	Level       string
+	Theme       string
+	Count       int
+	Difficulty  string
+	GrammarTags []string
 }
 
-func updateTopic(topicID, name, prompt string) (*Topic, error) {
-	table := airtableClient.GetTable(airtableBaseID, topicsTableName)
-	now := time.Now().Format(time.RFC3339)
-
-	// First add the new version
-	err := addPromptVersion(topicID, prompt)
-	if err != nil {
-		log.Printf("Warning: Failed to create version: %v", err)
+// promptVarsFromRequest builds the template variables for a request, applying defaults.
+func promptVarsFromRequest(req GenerateRequest) PromptVars {
+	vars := PromptVars{
+		Level: strings.TrimSpace(req.Level),
+		Theme: strings.TrimSpace(req.Theme),
+		Count: req.Count,
 	}
-
-	// Clean up old versions (keep only last 10)
-	versions, err := getVersions(topicID)
-	if err == nil && len(versions) > 10 {
-		versionsTable := airtableClient.GetTable(airtableBaseID, versionsTableName)
-		oldVersions := versions[:len(versions)-10] // Keep last 10
-		var oldVersionIDs []string
-		for _, oldVersion := range oldVersions {
-			oldVersionIDs = append(oldVersionIDs, oldVersion.ID)
-		}
-		versionsTable.DeleteRecords(oldVersionIDs)
+	vars.Difficulty, _ = validateDifficulty(req.Difficulty)
+	vars.GrammarTags, _ = validateGrammarTagFilters(req.GrammarTags)
+	if vars.Difficulty == "" {
+		vars.Difficulty = difficultyNormal
 	}
-
-	// Prepare fields for update
-	fields := map[string]any{
-		"Prompt":    prompt,
-		"UpdatedAt": now,
+	if vars.Count == 0 {
+		vars.Count = defaultExerciseCount
+	} else if vars.Count < minExerciseCount {
+		vars.Count = minExerciseCount
+	} else if vars.Count > maxExerciseCount {
+		vars.Count = maxExerciseCount
 	}
-	if name != "" {
-		fields["Name"] = name
+	if vars.Level == "" {
+		vars.Level = defaultLevel
 	}
-
-	records := &airtable.Records{
-		Records: []*airtable.Record{
-			{
-				ID:     topicID,
-				Fields: fields,
-			},
-		},
-	}
-
-	_, err = table.UpdateRecords(records)
-	if err != nil {
-		if strings.Contains(err.Error(), "UNKNOWN_FIELD_NAME") {
-			log.Printf("UpdatedAt field not found, updating with minimal fields")
-			delete(fields, "UpdatedAt")
-			records.Records[0].Fields = fields
-			_, err = table.UpdateRecords(records)
-		}
-
-		if err != nil {
-			return nil, fmt.Errorf("failed to update topic in Airtable: %v", err)
-		}
-	}
-
-	return getTopic(topicID)
+	return vars
 }
 
-func deleteTopic(topicID string) error {
-	// First delete all versions for this topic
-	versions, err := getVersions(topicID)
-	if err == nil && len(versions) > 0 {
-		versionsTable := airtableClient.GetTable(airtableBaseID, versionsTableName)
-		var versionIDs []string
-		for _, version := range versions {
-			versionIDs = append(versionIDs, version.ID)
-		}
-		versionsTable.DeleteRecords(versionIDs)
+// renderPrompt replaces the known placeholders in a prompt. Unknown placeholders are left untouched.
+func renderPrompt(prompt string, vars PromptVars) string {
+	theme := vars.Theme
+	if theme == "" {
+		theme = defaultVocabTheme
 	}
-	
-	// Then delete the topic
-	table := airtableClient.GetTable(airtableBaseID, topicsTableName)
-	_, err = table.DeleteRecords([]string{topicID})
-	if err != nil {
-		return fmt.Errorf("failed to delete topic from Airtable: %v", err)
+	difficulty := vars.Difficulty
+	if difficulty == "" {
+		difficulty = difficultyNormal
 	}
-	
-	return nil
-}
-
-func getVersions(topicID string) ([]*PromptVersion, error) {
-	table := airtableClient.GetTable(airtableBaseID, versionsTableName)
-	
-	records, err := table.GetRecords().
-		WithFilterFormula(fmt.Sprintf("{TopicID} = '%s'", topicID)).
-		Do()
-	
-	if err != nil {
-		// Check for permission errors
-		if strings.Contains(err.Error(), "status 403") || strings.Contains(err.Error(), "INVALID_PERMISSIONS") {
-			log.Printf("No read access to PromptVersions table. Version history unavailable.")
-			return []*PromptVersion{}, nil // Return empty slice instead of error
-		}
-		return nil, fmt.Errorf("failed to get versions from Airtable: %v", err)
+	replacements := []string{
+		"{{level}}", vars.Level,
+		"{{vocab_theme}}", theme,
+		"{{difficulty}}", difficulty,
 	}
-	
-	var versions []*PromptVersion
-	for _, record := range records.Records {
-		version := &PromptVersion{
-			ID: record.ID,
-		}
-		
-		if topicIDField, ok := record.Fields["TopicID"].(string); ok {
-			version.TopicID = topicIDField
-		}
-		if prompt, ok := record.Fields["Prompt"].(string); ok {
-			version.Prompt = prompt
-		}
-		if versionNum, ok := record.Fields["Version"].(float64); ok {
-			version.Version = int(versionNum)
-		}
-		if createdAt, ok := record.Fields["CreatedAt"].(string); ok {
-			if t, err := time.Parse(time.RFC3339, createdAt); err == nil {
-				version.CreatedAt = t
-			}
-		}
-		
-		versions = append(versions, version)
+	if vars.Count > 0 {
+		replacements = append(replacements, "{{count}}", strconv.Itoa(vars.Count))
 	}
-	
-	// Sort by version number
-	sort.Slice(versions, func(i, j int) bool {
-		return versions[i].Version < versions[j].Version
-	})
-	
-	return versions, nil
+	return strings.NewReplacer(replacements...).Replace(prompt)
 }
 
-func getVersion(versionID string) (*PromptVersion, error) {
-	table := airtableClient.GetTable(airtableBaseID, versionsTableName)
-	
-	record, err := table.GetRecord(versionID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get version from Airtable: %v", err)
-	}
-	
-	version := &PromptVersion{
-		ID: record.ID,
-	}
-	
-	if topicID, ok := record.Fields["TopicID"].(string); ok {
-		version.TopicID = topicID
-	}
-	if prompt, ok := record.Fields["Prompt"].(string); ok {
-		version.Prompt = prompt
-	}
-	if versionNum, ok := record.Fields["Version"].(float64); ok {
-		version.Version = int(versionNum)
-	}
-	if createdAt, ok := record.Fields["CreatedAt"].(string); ok {
-		if t, err := time.Parse(time.RFC3339, createdAt); err == nil {
-			version.CreatedAt = t
-		}
-	}
-	
-	return version, nil
-}
+// Appended to prompts that don't ask for distractors themselves (see exercise_model.go)
+const distractorsInstruction = `For each exercise, also add "distractors": a list of 2 to 4 single words that look plausible but are wrong in the sentence, such as a wrong article, case ending or verb form of a word in it (e.g. "lernen" for "lernt", "den" for "der").`
 
-func addPromptVersion(topicID, prompt string) error {
-	// Get existing versions to determine next version number
-	versions, err := getVersions(topicID)
-	if err != nil {
-		// Check if it's a permission error
-		if strings.Contains(err.Error(), "status 403") || strings.Contains(err.Error(), "INVALID_PERMISSIONS") {
-			log.Printf("No access to PromptVersions table. Please check Airtable token permissions.")
-			log.Printf("The token needs read/write access to both 'Topics' and 'PromptVersions' tables.")
-			return nil // Don't fail the topic creation due to version permission issues
-		}
-		if !strings.Contains(err.Error(), "status 404") {
-			return err
-		}
-	}
+// Appended to prompts that don't ask for alternative orderings themselves (see answer_check.go)
+const alternativeSentencesInstruction = `If the words of a sentence can also be put in another correct order (e.g. "Heute gehe ich ins Kino." for "Ich gehe heute ins Kino."), add "alternative_sentences": a list of those orderings, using exactly the same words. Leave it out otherwise.`
 
-	nextVersion := 1
-	if len(versions) > 0 {
-		nextVersion = versions[len(versions)-1].Version + 1
+// renderGenerationPrompt renders a prompt for sending to the LLM. Prompts that do not
+// use the {{count}}, {{vocab_theme}} or {{difficulty}} placeholders get explicit instructions
+// appended so the model still honours the requested batch size, vocabulary theme and
+// difficulty. Prompts that don't mention distractors are asked for them, except for easy sets,
+// and prompts that don't mention alternative_sentences are asked for other valid word orders.
+// Sets limited to grammar tags ask for sentences with those features.
+func renderGenerationPrompt(prompt string, vars PromptVars) string {
+	rendered := renderPrompt(prompt, vars)
+	if !strings.Contains(prompt, "{{count}}") && vars.Count > 0 {
+		rendered += fmt.Sprintf("\n\nGenerate exactly %d exercises.", vars.Count)
 	}
-
-	table := airtableClient.GetTable(airtableBaseID, versionsTableName)
-	now := time.Now().Format(time.RFC3339)
-
-	// Try with timestamp field first, fallback to minimal fields
-	records := &airtable.Records{
-		Records: []*airtable.Record{
-			{
-				Fields: map[string]any{
-					"TopicID":   topicID,
-					"Prompt":    prompt,
-					"Version":   nextVersion,
-					"CreatedAt": now,
-				},
-			},
-		},
+	if !strings.Contains(prompt, "{{vocab_theme}}") && vars.Theme != "" {
+		rendered += fmt.Sprintf("\n\nUse vocabulary related to the theme \"%s\".", vars.Theme)
 	}
-
-	_, err = table.AddRecords(records)
-	if err != nil {
-		// Check for permission errors
-		if strings.Contains(err.Error(), "status 403") || strings.Contains(err.Error(), "INVALID_PERMISSIONS") {
-			log.Printf("No write access to PromptVersions table. Skipping version creation.")
-			return nil // Don't fail the topic creation
-		}
-
-		// If it failed due to unknown fields, try with minimal fields
-		if strings.Contains(err.Error(), "UNKNOWN_FIELD_NAME") {
-			log.Printf("CreatedAt field not found in PromptVersions, creating with minimal fields")
-			records.Records[0].Fields = map[string]any{
-				"TopicID": topicID,
-				"Prompt":  prompt,
-				"Version": nextVersion,
-			}
-			_, err = table.AddRecords(records)
-		}
-
-		if err != nil {
-			// Final check for permissions before failing
-			if strings.Contains(err.Error(), "status 403") || strings.Contains(err.Error(), "INVALID__PERMISSIONS") {
-				log.Printf("Cannot create version due to permissions. Continuing without version tracking.")
-				return nil
-			}
-			return fmt.Errorf("failed to create version in Airtable: %v", err)
-		}
-	}
-
-	return nil
-}
-
-func getPromptHash(prompt string) string {
-	hash := sha256.Sum256([]byte(prompt))
-	return hex.EncodeToString(hash[:])
-}
-
-func createExercise(topicID, promptHash, exerciseJSON string) (*Exercise, error) {
-	table := airtableClient.GetTable(airtableBaseID, exercisesTableName)
-	records := &airtable.Records{
-		Records: []*airtable.Record{
-			{
-				Fields: map[string]any{
-					"TopicID":      topicID,
-					"PromptHash":   promptHash,
-					"ExerciseJSON": exerciseJSON,
-				},
-			},
-		},
+	if instruction := difficultyInstructions[vars.Difficulty]; instruction != "" && !strings.Contains(prompt, "{{difficulty}}") {
+		rendered += "\n\n" + instruction
 	}
-
-	result, err := table.AddRecords(records)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create exercise in Airtable: %v", err)
+	if !strings.Contains(prompt, "distractors") && vars.Difficulty != difficultyEasy {
+		rendered += "\n\n" + distractorsInstruction
 	}
-
-	if len(result.Records) == 0 {
-		return nil, fmt.Errorf("no records returned from Airtable")
+	if !strings.Contains(prompt, "alternative_sentences") {
+		rendered += "\n\n" + alternativeSentencesInstruction
 	}
-
-	rec := result.Records[0]
-	exercise := &Exercise{
-		AirtableID:   rec.ID,
-		TopicID:      topicID,
-		PromptHash:   promptHash,
-		ExerciseJSON: exerciseJSON,
-		CreatedAt:    time.Now(), // Approximate, actual time is on Airtable
+	if len(vars.GrammarTags) > 0 {
+		rendered += "\n\n" + grammarTagsInstruction(vars.GrammarTags)
 	}
-	return exercise, nil
+	return rendered
 }
 
-func getExercisesForTopic(topicID, promptHash string) ([]*Exercise, error) {
-	table := airtableClient.GetTable(airtableBaseID, exercisesTableName)
-	formula := fmt.Sprintf("AND({TopicID} = '%s', {PromptHash} = '%s')", topicID, promptHash)
-
-	records, err := table.GetRecords().WithFilterFormula(formula).Do()
-	if err != nil {
-		if strings.Contains(err.Error(), "NOT_FOUND") {
-			return []*Exercise{}, nil // Return empty slice if table not found
-		}
-		return nil, fmt.Errorf("failed to get exercises from Airtable: %v", err)
-	}
-
-	var exercises []*Exercise
-	for _, record := range records.Records {
-		exercise := &Exercise{
-			AirtableID: record.ID,
-		}
-		if val, ok := record.Fields["TopicID"].(string); ok {
-			exercise.TopicID = val
-		}
-		if val, ok := record.Fields["PromptHash"].(string); ok {
-			exercise.PromptHash = val
-		}
-		if val, ok := record.Fields["ExerciseJSON"].(string); ok {
-			exercise.ExerciseJSON = val
-		}
-		if val, ok := record.Fields["CreatedAt"].(string); ok {
-			if t, err := time.Parse(time.RFC3339, val); err == nil {
-				exercise.CreatedAt = t
-			}
-		}
-		exercises = append(exercises, exercise)
-	}
-	return exercises, nil
-}
-
-func getUserExerciseViews(userID string) (map[string]*UserExerciseView, error) {
-	table := airtableClient.GetTable(airtableBaseID, userExerciseViewsTableName)
-	formula := fmt.Sprintf("{UserID} = '%s'", userID)
-
-	records, err := table.GetRecords().WithFilterFormula(formula).Do()
-	if err != nil {
-		if strings.Contains(err.Error(), "NOT_FOUND") {
-			return make(map[string]*UserExerciseView), nil // Return empty map if table not found
-		}
-		return nil, fmt.Errorf("failed to get user exercise views from Airtable: %v", err)
-	}
-
-	views := make(map[string]*UserExerciseView)
-	for _, record := range records.Records {
-		view := &UserExerciseView{
-			AirtableID: record.ID,
-		}
-		if val, ok := record.Fields["UserID"].(string); ok {
-			view.UserID = val
-		}
-		if val, ok := record.Fields["ExerciseID"].(string); ok {
-			view.ExerciseID = val
-		}
-		if val, ok := record.Fields["LastViewed"].(string); ok {
-			if t, err := time.Parse(time.RFC3339, val); err == nil {
-				view.LastViewed = t
-			}
-		}
-		if val, ok := record.Fields["RepetitionCounter"].(float64); ok {
-			view.RepetitionCounter = int(val)
-		}
-		views[view.ExerciseID] = view
-	}
-	return views, nil
+// getCacheHash returns the hash used to key cached exercises. Only the level is resolved:
+// the theme is stored on each exercise row and the count only affects the batch size.
+func getCacheHash(prompt string, vars PromptVars) string {
+	return getPromptHash(strings.ReplaceAll(prompt, "{{level}}", vars.Level))
 }
 
-func updateUserExerciseViews(viewsToUpdate []*UserExerciseView) error {
-	table := airtableClient.GetTable(airtableBaseID, userExerciseViewsTableName)
-	var recordsToCreate []*airtable.Record
-	var recordsToUpdate []*airtable.Record
-
-	for _, view := range viewsToUpdate {
-		fields := map[string]any{
-			"UserID":            view.UserID,
-			"ExerciseID":        view.ExerciseID,
-			"LastViewed":        view.LastViewed.Format(time.RFC3339),
-			"RepetitionCounter": view.RepetitionCounter,
-		}
-		if view.AirtableID == "" {
-			recordsToCreate = append(recordsToCreate, &airtable.Record{Fields: fields})
-		} else {
-			recordsToUpdate = append(recordsToUpdate, &airtable.Record{ID: view.AirtableID, Fields: fields})
-		}
-	}
-
-	if len(recordsToCreate) > 0 {
-		if _, err := table.AddRecords(&airtable.Records{Records: recordsToCreate}); err != nil {
-			return fmt.Errorf("failed to create user exercise views: %v", err)
-		}
+// filterExercisesByTheme returns the exercises generated for the given theme.
+// An empty theme matches every exercise.
+func filterExercisesByTheme(exercises []*Exercise, theme string) []*Exercise {
+	if theme == "" {
+		return exercises
 	}
-	if len(recordsToUpdate) > 0 {
-		if _, err := table.UpdateRecords(&airtable.Records{Records: recordsToUpdate}); err != nil {
-			return fmt.Errorf("failed to update user exercise views: %v", err)
+	var filtered []*Exercise
+	for _, ex := range exercises {
+		if strings.EqualFold(ex.Theme, theme) {
+			filtered = append(filtered, ex)
 		}
 	}
-	return nil
+	return filtered
 }
 
 func initOAuth() {
-	googleClientID := os.Getenv("GOOGLE_CLIENT_ID")
-	googleClientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
-	redirectURL := os.Getenv("GOOGLE_REDIRECT_URL")
+	googleClientID := appConfig.GoogleClientID
+	googleClientSecret := appConfig.GoogleClientSecret
+	redirectURL := appConfig.GoogleRedirectURL
 
 	if googleClientID == "" || googleClientSecret == "" || redirectURL == "" {
 		log.Println("Warning: GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET, or GOOGLE_REDIRECT_URL not set. Google login will be disabled.")
@@ -894,12 +437,12 @@ func initOAuth() {
 		RedirectURL:  redirectURL,
 		ClientID:     googleClientID,
 		ClientSecret: googleClientSecret,
-		Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
+		Scopes:       append([]string{"https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"}, appConfig.GoogleExtraScopes...),
 		Endpoint:     google.Endpoint,
 	}
 	log.Println("Google OAuth initialized.")
 
-	googleAdminID = os.Getenv("GOOGLE_ADMIN_ID")
+	googleAdminID = appConfig.GoogleAdminID
 	if googleAdminID == "" {
 		log.Println("Warning: GOOGLE_ADMIN_ID not set. Admin features will be disabled.")
 	} else {
@@ -908,176 +451,212 @@ func initOAuth() {
 }
 
 func main() {
+	// Load and validate configuration from the environment
+	initConfig()
+
 	// Initialize storage backend
 	initStorage()
 
+	// Answer LLM requests from fixtures when MOCK_LLM=true
+	initMockLLM()
+	initTracing()
+
 	// Initialize Google OAuth
 	initOAuth()
+
+	// Initialize outgoing email and push notifications
+	initMailer()
+	initSessionCookies()
+	initWebPush()
+	initMarketplace()
+	initS3()
+	initBackups()
+	initSecrets()
+	initExerciseRetention()
+	initFeatureFlags()
 	
 	// Initialize default topics
 	initializeDefaultTopics()
-
-	// Cleanup old clients every 10 minutes
-	go func() {
-		for {
-			time.Sleep(10 * time.Minute)
-			mu.Lock()
-			for ip, c := range clients {
-				if time.Since(c.lastSeen) > 30*time.Minute {
-					delete(clients, ip)
-				}
-			}
-			mu.Unlock()
-		}
-	}()
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
-	// Custom handler for index.html with cache-busting
-	http.HandleFunc("/", handleIndex)
-
-	// Serve static files with cache headers
-	http.HandleFunc("/app.js", handleJS)
-	http.HandleFunc("/privacy.html", handlePrivacy)
-	http.HandleFunc("/favicon.svg", handleFavicon)
-	http.HandleFunc("/favicon-32x32.svg", handleFavicon32)
+	initializeDefaultAchievements()
+	initContentPacks()
+
+	// Configure rate limit policies and the limiter store
+	initRateLimits()
+
+	// Send weekly progress emails and study reminders to opted-in users
+	startDigestScheduler()
+	startReminderScheduler()
+	startBackupScheduler()
+	startExerciseRetentionScheduler()
+	startRetirementScheduler()
+	startDeletedExercisesPruner()
+	startFeatureFlagRefresh()
+	startAnalyticsFlusher()
+	startWebhookSummaryScheduler()
+
+	// Serve the gRPC API for native clients when GRPC_PORT is set
+	startGRPCServer()
+
+	port := appConfig.Port
+
+	// The embedded frontend, with content-hashed asset links (static.go)
+	initStaticAssets()
+	http.HandleFunc("/", handleStatic)
 	http.HandleFunc("/favicon.ico", handleFaviconICO) // Fallback for older browsers
 	
 	// API endpoints
-	http.HandleFunc("/api/generate", handleGenerate) // Will be deprecated for frontend use
-	http.HandleFunc("/api/exercises", handleExercises)
+	http.HandleFunc("/api/generate", rateLimited("generate", requireOnline(requireFeature(flagExerciseGeneration, handleGenerate)))) // Will be deprecated for frontend use
+	http.HandleFunc("/api/exercises", rateLimited("exercises", handleExercises))
+	http.HandleFunc("/api/exercises/mix", rateLimited("exercises", handleDailyMix))
+	http.HandleFunc("/api/exercises/search", handleExerciseSearch)
+	http.HandleFunc("/api/exercises/reviews", handleExerciseReviews)
+	http.HandleFunc("/api/exercises/", rateLimited("answers", handleExerciseAnswer))
+	http.HandleFunc("/api/sessions/current", handleCurrentSession)
+	http.HandleFunc("/api/sync", rateLimited("delta_sync", handleSync))
+	http.HandleFunc("/api/sync/pull", rateLimited("sync", handleSyncPull))
+	http.HandleFunc("/api/sync/push", rateLimited("sync", handleSyncPush))
 	http.HandleFunc("/api/topics", handleTopics)
 	http.HandleFunc("/api/topics/", handleTopicByID)
+	http.HandleFunc("/api/topics/export", tenantAdminOnly(handleTopicsExport))
+	http.HandleFunc("/api/topics/import", rateLimited("import", tenantAdminOnly(handleTopicsImport)))
+	http.HandleFunc("/api/topics/validate", rateLimited("generate", tenantAdminOnly(handleTopicsValidate)))
 	http.HandleFunc("/api/versions/", handleVersions)
 	http.HandleFunc("/api/last-refined-prompt", handleGetLastRefinedPrompt)
 
+	// Admin endpoints
+	http.HandleFunc("/api/admin/exercises", adminOnly(handleAdminExercises))
+	http.HandleFunc("/api/admin/exercises/", adminOnly(handleAdminExercises))
+	http.HandleFunc("/api/admin/topics/", adminOnly(requireOnline(handleAdminTopicActions)))
+	http.HandleFunc("/api/admin/backup", adminOnly(handleAdminBackup))
+	http.HandleFunc("/api/admin/backup/s3", adminOnly(handleAdminBackup))
+	http.HandleFunc("/api/admin/restore", adminOnly(handleAdminRestore))
+	http.HandleFunc("/api/admin/content-packs", adminOnly(handleAdminContentPacks))
+	http.HandleFunc("/api/admin/exercise-quality", adminOnly(handleAdminExerciseQuality))
+	http.HandleFunc("/api/admin/exercise-retirements", adminOnly(handleAdminExerciseRetirements))
+	http.HandleFunc("/api/admin/exercise-retirements/", adminOnly(handleAdminExerciseRetirements))
+	http.HandleFunc("/api/admin/slow-queries", adminOnly(handleAdminSlowQueries))
+	http.HandleFunc("/api/admin/refined-prompts", adminOnly(handleAdminRefinedPrompts))
+	http.HandleFunc("/api/admin/cache-retention", adminOnly(handleAdminCacheRetention))
+	http.HandleFunc("/api/admin/config", adminOnly(handleAdminConfig))
+	http.HandleFunc("/api/admin/feature-flags", adminOnly(handleAdminFeatureFlags))
+	http.HandleFunc("/api/admin/feature-flags/", adminOnly(handleAdminFeatureFlags))
+	http.HandleFunc("/api/admin/analytics", adminOnly(handleAdminAnalytics))
+	http.HandleFunc("/api/admin/analytics/", adminOnly(handleAdminAnalytics))
+	http.HandleFunc("/api/admin/audit", adminOnly(handleAdminAudit))
+	http.HandleFunc("/api/admin/webhooks", adminOnly(handleAdminWebhooks))
+	http.HandleFunc("/api/admin/webhooks/", adminOnly(handleAdminWebhooks))
+	http.HandleFunc("/api/admin/topic-suggestions", adminOnly(handleAdminTopicSuggestions))
+	http.HandleFunc("/api/admin/topic-suggestions/", adminOnly(handleAdminTopicSuggestions))
+	http.HandleFunc("/api/admin/tenants", adminOnly(handleAdminTenants))
+	http.HandleFunc("/api/admin/tenants/", adminOnly(handleAdminTenants))
+	http.HandleFunc("/api/admin/quotas", adminOnly(handleAdminQuotas))
+	http.HandleFunc("/api/admin/secrets/reencrypt", adminOnly(handleAdminSecretsReencrypt))
+	http.HandleFunc("/api/admin/quotas/", adminOnly(handleAdminQuotas))
+
 	// Auth endpoints
 	http.HandleFunc("/auth/google/login", handleGoogleLogin)
 	http.HandleFunc("/auth/google/callback", handleGoogleCallback)
 	http.HandleFunc("/api/auth/status", handleAuthStatus)
+	http.HandleFunc("/api/mode", handleMode)
 	http.HandleFunc("/auth/logout", handleLogout)
+	http.HandleFunc("/auth/magic", handleMagicLinkLogin)
+	http.HandleFunc("/api/auth/magic-link", rateLimited("magiclink", handleMagicLinkRequest))
 	http.HandleFunc("/api/auth/is_admin", handleIsAdmin)
+	http.HandleFunc("/api/csrf-token", handleCSRFToken)
 
 	// User stats and settings endpoints
 	http.HandleFunc("/api/user/stats", handleUserStats)
+	http.HandleFunc("/api/user/stats/increment", handleUserStatsIncrement)
+	http.HandleFunc("/api/user/stats/history", handleUserStatsHistory)
+	http.HandleFunc("/api/user/stats/topics", handleUserTopicStats)
+	http.HandleFunc("/api/user/stats/topics/", handleUserTopicStats)
 	http.HandleFunc("/api/user/settings", handleUserSettings)
+	http.HandleFunc("/api/user/progress", rateLimited("progress", handleUserProgress))
+	http.HandleFunc("/api/user/hints", rateLimited("progress", handleUserHints))
+	http.HandleFunc("/api/user/mastery", rateLimited("progress", handleUserMastery))
+	http.HandleFunc("/api/user/topic-suggestions", handleUserTopicSuggestions)
+	http.HandleFunc("/api/challenges", handleChallenges)
+	http.HandleFunc("/api/challenges/", handleChallenges)
+	http.HandleFunc("/api/duels", handleDuels)
+	http.HandleFunc("/api/duels/", handleDuels)
+	http.HandleFunc("/api/conversations", handleConversations)
+	http.HandleFunc("/api/conversations/", handleConversations)
+	http.HandleFunc("/api/correct", rateLimited("correct", handleCorrect))
+	http.HandleFunc("/api/user/quota", handleUserQuota)
+	http.HandleFunc("/api/user/api-key", handleUserAPIKey)
+	http.HandleFunc("/api/user/google", handleUserGoogle)
+	http.HandleFunc("/api/user/sessions", handleUserSessions)
+	http.HandleFunc("/api/user/activity-heatmap", handleUserActivityHeatmap)
+	http.HandleFunc("/api/user/achievements", handleUserAchievements)
+	http.HandleFunc("/api/user/notifications", handleUserNotifications)
+	http.HandleFunc("/api/user/profile", handleUserProfile)
+	http.HandleFunc("/api/user/calendar", handleUserCalendar)
+	http.HandleFunc("/api/user/public-profile", handleUserPublicProfile)
+	http.HandleFunc("/api/user/tokens", handleUserTokens)
+	http.HandleFunc("/api/user/tokens/", handleUserTokens)
+	http.HandleFunc("/api/user/", rateLimited("calendar", handleReviewCalendarFeed)) // /api/user/{token}/reviews.ics
+	http.HandleFunc("/api/classes", handleClasses)
+	http.HandleFunc("/api/user/assignments", handleUserAssignments)
+	http.HandleFunc("/api/classes/", handleClasses)
+	http.HandleFunc("/u/", rateLimited("profile", handlePublicProfilePage))
+	http.HandleFunc("/api/profiles/", rateLimited("profile", handlePublicProfile))
+	http.HandleFunc("/api/leaderboard", rateLimited("leaderboard", requireFeature(flagLeaderboard, handleLeaderboard)))
+	http.HandleFunc("/api/marketplace", rateLimited("marketplace", requireFeature(flagMarketplace, handleMarketplace)))
+	http.HandleFunc("/api/marketplace/", rateLimited("marketplace", requireFeature(flagMarketplace, handleMarketplace)))
+	http.HandleFunc("/api/notifications/unsubscribe", handleUnsubscribe)
+	http.HandleFunc("/api/push/vapid-public-key", handleVAPIDPublicKey)
+	http.HandleFunc("/api/user/push/subscriptions", handlePushSubscriptions)
 	
-	// Health check endpoint
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	// Health check endpoints: liveness and readiness
+	http.HandleFunc("/health", handleHealthz)
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
 
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
-}
+	// Live generation progress (WebSocket)
+	http.HandleFunc("/ws", handleProgressWebSocket)
 
-func getFilePath(filename string) string {
-	// Check if running in Docker (files in static/ directory)
-	if _, err := os.Stat("static/" + filename); err == nil {
-		return "static/" + filename
-	}
-	// Otherwise use current directory
-	return filename
+	log.Printf("Server starting on port %s", port)
+	log.Fatal(http.ListenAndServe(":"+port, withTracing(http.DefaultServeMux, withCompression(withRequestID(withTenant(withUserAPIKey(refreshSessionCookies(csrfProtect(http.DefaultServeMux)))))))))
 }
 
-func handleIndex(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
+// renderMetaPrompt builds the refinement request for a prompt. A custom meta-prompt
+// marks where the prompt goes with {{prompt}}; without it the prompt is appended.
+func renderMetaPrompt(customMetaPrompt, originalPrompt string) string {
+	if strings.TrimSpace(customMetaPrompt) == "" {
+		return fmt.Sprintf(metaPrompt, originalPrompt)
 	}
-	
-	// Read the HTML file
-	filePath := getFilePath("index.html")
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
-	}
-	
-	// Replace the cache-busting parameter with current timestamp
-	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
-	htmlContent := string(content)
-	htmlContent = strings.ReplaceAll(htmlContent, "app.js?v=20250821001", fmt.Sprintf("app.js?v=%s", timestamp))
-	
-	// Set headers to prevent caching of the HTML file itself
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	w.Header().Set("Pragma", "no-cache")
-	w.Header().Set("Expires", "0")
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	
-	w.Write([]byte(htmlContent))
-}
-
-func handleJS(w http.ResponseWriter, r *http.Request) {
-	// Read the JS file
-	filePath := getFilePath("app.js")
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
+	if strings.Contains(customMetaPrompt, "{{prompt}}") {
+		return strings.ReplaceAll(customMetaPrompt, "{{prompt}}", originalPrompt)
 	}
-	
-	// Set headers for JS file - allow caching but with versioning
-	w.Header().Set("Content-Type", "application/javascript")
-	w.Header().Set("Cache-Control", "public, max-age=31536000") // Cache for 1 year
-	
-	w.Write(content)
+	return customMetaPrompt + "\n\n" + originalPrompt
 }
 
-func handlePrivacy(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, getFilePath("privacy.html"))
-}
-
-func handleFavicon(w http.ResponseWriter, r *http.Request) {
-	// Read the SVG file
-	filePath := getFilePath("favicon.svg")
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		http.Error(w, "Favicon not found", http.StatusNotFound)
-		return
+// refineTopicPrompt applies the topic's refinement settings to a rendered prompt. It returns
+// the prompt to send and whether it was refined; on errors the rendered prompt is used as is.
+func refineTopicPrompt(ctx context.Context, topic *Topic, renderedPrompt, apiKey, openaiURL, modelName string) (string, bool) {
+	if topic.RefinementDisabled || !featureEnabled(flagPromptRefinement) {
+		return renderedPrompt, false
 	}
-	
-	// Set headers for SVG favicon - allow caching
-	w.Header().Set("Content-Type", "image/svg+xml")
-	w.Header().Set("Cache-Control", "public, max-age=31536000") // Cache for 1 year
-	
-	w.Write(content)
-}
-
-func handleFavicon32(w http.ResponseWriter, r *http.Request) {
-	// Read the 32x32 SVG file
-	filePath := getFilePath("favicon-32x32.svg")
-	content, err := os.ReadFile(filePath)
+	reportProgress(ctx, ProgressEvent{Stage: progressRefining, Message: "Refining prompt", TopicID: topic.ID})
+	finalPrompt, err := refinePrompt(ctx, renderMetaPrompt(topic.MetaPrompt, renderedPrompt), apiKey, openaiURL, modelName)
 	if err != nil {
-		http.Error(w, "Favicon not found", http.StatusNotFound)
-		return
+		log.Printf("Error refining prompt, falling back to original: %v", err)
+		return renderedPrompt, false
 	}
-	
-	// Set headers for SVG favicon - allow caching
-	w.Header().Set("Content-Type", "image/svg+xml")
-	w.Header().Set("Cache-Control", "public, max-age=31536000") // Cache for 1 year
-	
-	w.Write(content)
-}
-
-func handleFaviconICO(w http.ResponseWriter, r *http.Request) {
-	// Redirect .ico requests to SVG favicon for better quality
-	http.Redirect(w, r, "/favicon.svg", http.StatusMovedPermanently)
+	return finalPrompt, true
 }
 
-// refinePrompt takes a prompt and uses the meta-prompt to refine it.
-func refinePrompt(originalPrompt, apiKey, openaiURL, modelName string) (string, error) {
+// refinePrompt sends a rendered meta-prompt to the model and returns the refined prompt.
+func refinePrompt(ctx context.Context, refineRequest, apiKey, openaiURL, modelName string) (refinedPrompt string, err error) {
 	log.Println("Refining prompt...")
+	ctx, end := startSpan(ctx, "refine prompt", attribute.String("llm.model", modelName))
+	defer func() { end(err) }()
 
 	// 1. Create the request to refine the prompt
 	refineMessages := []Message{
 		{
 			Role:    "user",
-			Content: fmt.Sprintf(metaPrompt, originalPrompt),
+			Content: refineRequest,
 		},
 	}
 
@@ -1093,8 +672,8 @@ func refinePrompt(originalPrompt, apiKey, openaiURL, modelName string) (string,
 	}
 
 	// 2. Make the request to the OpenAI API
-	client := &http.Client{}
-	apiReq, err := http.NewRequest("POST", openaiURL+"/chat/completions", bytes.NewBuffer(reqBody))
+	client := llmHTTPClient
+	apiReq, err := http.NewRequestWithContext(ctx, "POST", openaiURL+"/chat/completions", bytes.NewBuffer(reqBody))
 	if err != nil {
 		return "", fmt.Errorf("failed to create API request for refining: %w", err)
 	}
@@ -1126,14 +705,14 @@ func refinePrompt(originalPrompt, apiKey, openaiURL, modelName string) (string,
 		return "", fmt.Errorf("received an empty response from the refining API")
 	}
 
-	refinedPrompt := openaiResp.Choices[0].Message.Content
+	refinedPrompt = openaiResp.Choices[0].Message.Content
 	log.Println("Successfully refined prompt.")
 	return refinedPrompt, nil
 }
 
 func handleExercises(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -1147,101 +726,193 @@ func handleExercises(w http.ResponseWriter, r *http.Request) {
 
 	var req GenerateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	topic, err := getTopic(req.TopicID)
+	exercises, limits, err := serveExercises(r.Context(), req, getUserIDFromRequest(r), getProgressOwnerID(w, r))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Topic not found: %v", err), http.StatusNotFound)
+		writeStatusError(w, err)
 		return
 	}
 
-	promptHash := getPromptHash(topic.Prompt)
-	userID := getUserIDFromRequest(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"exercises": exercisesForClient(r, exercises),
+		"limits":    limits,
+	})
+}
 
-	allExercises, err := getExercisesForTopic(req.TopicID, promptHash)
+// serveExercises picks a set of exercises for the owner, generating new ones for signed-in
+// users when the cache falls short, and starts it as the owner's current session. It backs
+// both POST /api/exercises and the gRPC service.
+func serveExercises(ctx context.Context, req GenerateRequest, userID, ownerID string) ([]json.RawMessage, DailyLimits, error) {
+	end := startStoreSpan(ctx, "GetTopic")
+	topic, err := dataStore.GetTopic(req.TopicID)
+	end(err)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get exercises: %v", err), http.StatusInternalServerError)
-		return
+		return nil, DailyLimits{}, errorWithStatus(http.StatusNotFound, "Topic not found: %v", err)
+	}
+	if !topicInTenant(ctx, topic) {
+		return nil, DailyLimits{}, errorWithStatus(http.StatusNotFound, "Topic not found")
+	}
+	if topic.Archived {
+		return nil, DailyLimits{}, errorWithStatus(http.StatusGone, "Topic is archived")
 	}
 
-	var finalExercises []*Exercise
-	if userID == "" {
-		// Guest user logic - only serve from cache, never generate.
-		finalExercises = getRandomExercises(allExercises, 10)
-	} else {
-		// Authenticated user SRS logic
-		userViews, err := getUserExerciseViews(userID)
+	// Daily limits and difficulty are the user's own, or the defaults for guests
+	var user *User
+	if userID != "" {
+		end = startStoreSpan(ctx, "GetUserByID")
+		user, err = dataStore.GetUserByID(userID)
+		end(err)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to get user views: %v", err), http.StatusInternalServerError)
-			return
+			log.Printf("Warning: failed to get user %s for daily limits: %v", userID, err)
 		}
+	}
+	if req.Difficulty, err = requestDifficulty(req.Difficulty, user); err != nil {
+		return nil, DailyLimits{}, errorWithStatus(http.StatusBadRequest, "%v", err)
+	}
+	if req.GrammarTags, err = validateGrammarTagFilters(req.GrammarTags); err != nil {
+		return nil, DailyLimits{}, errorWithStatus(http.StatusBadRequest, "%v", err)
+	}
 
-		eligibleExercises := getEligibleExercisesForSRS(allExercises, userViews)
-		if len(eligibleExercises) < 10 {
-			newlyGenerated, err := generateAndCacheExercises(topic)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Failed to generate exercises: %v", err), http.StatusInternalServerError)
-				return
-			}
-			allExercises = append(allExercises, newlyGenerated...)
-			eligibleExercises = getEligibleExercisesForSRS(allExercises, userViews)
-		}
+	vars := promptVarsFromRequest(req)
+	promptHash := getCacheHash(topic.Prompt, vars)
 
-		finalExercises = getRandomExercises(eligibleExercises, 10)
-
-		// Update views for the selected exercises
-		var viewsToUpdate []*UserExerciseView
-		now := time.Now()
-		for _, ex := range finalExercises {
-			view, exists := userViews[ex.AirtableID]
-			if !exists {
-				view = &UserExerciseView{
-					UserID:     userID,
-					ExerciseID: ex.AirtableID,
-				}
+	end = startStoreSpan(ctx, "GetExercisesForTopic")
+	allExercises, err := dataStore.GetExercisesForTopic(req.TopicID, promptHash)
+	end(err)
+	if err != nil {
+		return nil, DailyLimits{}, fmt.Errorf("Failed to get exercises: %v", err)
+	}
+	allExercises = filterExercisesByDifficulty(filterExercisesByTheme(allExercises, vars.Theme), vars.Difficulty)
+	allExercises = withoutRetired(filterExercisesByGrammarTags(allExercises, vars.GrammarTags))
+
+	// SRS logic, for guests too so their progress can be merged when they sign in
+	end = startStoreSpan(ctx, "GetUserExerciseViews")
+	userViews, err := dataStore.GetUserExerciseViews(ownerID)
+	end(err)
+	if err != nil {
+		return nil, DailyLimits{}, fmt.Errorf("Failed to get user views: %v", err)
+	}
+	applyHintBoost(ownerID, userViews)
+
+	now := time.Now()
+	limits := getDailyLimits(user, userViews, now)
+
+	// With SRS switched off any cached exercise is eligible; views are still recorded
+	srsEnabled := featureEnabled(flagSRS)
+	eligibleExercises := allExercises
+	if srsEnabled {
+		eligibleExercises = getEligibleExercisesForSRS(allExercises, userViews)
+	}
+	// Only new exercises are generated, so the cache falls short when it lacks new ones the limits allow
+	unseen, _ := splitNewExercises(eligibleExercises, userViews, now)
+	cacheHit := len(unseen) >= newExercisesWanted(eligibleExercises, userViews, vars.Count, limits, now)
+	// Guests, everyone while generation is switched off or the server is offline, and a school
+	// that used up its generation quota are only served from cache
+	generate := !cacheHit && userID != "" && !offlineMode() && featureEnabled(flagExerciseGeneration) && generationQuotaLeft(ctx, now)
+	if generate && !usesOwnAPIKey(ctx) {
+		if err := useUserQuota(userID, now); err != nil {
+			// Over their own quota, learners get what is cached, and the error only when nothing is
+			if len(eligibleExercises) == 0 {
+				return nil, DailyLimits{}, err
 			}
-			view.LastViewed = now
-			view.RepetitionCounter++
-			viewsToUpdate = append(viewsToUpdate, view)
-		}
-		if err := updateUserExerciseViews(viewsToUpdate); err != nil {
-			log.Printf("Warning: failed to update user exercise views: %v", err)
-			// Don't block user, just log the error
+			generate = false
+		}
+	}
+	if generate {
+		newlyGenerated, err := generateAndCacheExercises(withProgressOwner(ctx, userID), topic, vars)
+		switch {
+		case err == nil:
+		case !fallsBackToCache():
+			return nil, DailyLimits{}, errorWithStatus(http.StatusBadGateway, "Failed to generate exercises: %v", err)
+		case len(allExercises) == 0:
+			return nil, DailyLimits{}, errorWithStatus(http.StatusServiceUnavailable, "Exercises can't be generated right now and none are cached for this topic")
+		default:
+			// The cached-only end of the fallback chain: the set is served from what is cached
+			log.Printf("Warning: serving cached exercises for topic %s, generation failed: %v", topic.ID, err)
+		}
+		// The model doesn't always use the features asked for
+		allExercises = append(allExercises, filterExercisesByGrammarTags(newlyGenerated, vars.GrammarTags)...)
+		eligibleExercises = allExercises
+		if srsEnabled {
+			eligibleExercises = getEligibleExercisesForSRS(allExercises, userViews)
 		}
 	}
 
-	// Prepare response
+	finalExercises := selectExercises(allExercises, eligibleExercises, userViews, vars.Count, limits, now)
+
+	// Views are only recorded once an exercise is answered (POST /api/exercises/reviews),
+	// so exercises in an abandoned set stay due
+	recordEvent(AnalyticsEvent{Type: eventExercisesServed, UserID: ownerID, TopicID: topic.ID, Count: len(finalExercises), CacheHit: cacheHit})
+
+	// Prepare response, with hints in the learner's native language if they set one
+	var hints map[string]string
+	if user != nil && user.NativeLanguage != "" && len(finalExercises) > 0 {
+		hints = nativeHints(ctx, user, finalExercises)
+	}
 	var responseExercises []json.RawMessage
 	for _, ex := range finalExercises {
-		responseExercises = append(responseExercises, []byte(ex.ExerciseJSON))
+		responseExercises = append(responseExercises, servedExercise(ex, user, hints, vars.Difficulty))
+	}
+	// Keep the set so it can be resumed, and so its exercises can be answered
+	if len(responseExercises) > 0 {
+		end = startStoreSpan(ctx, "StartCurrentSession")
+		err = startCurrentSession(ownerID, topic.ID, responseExercises, req.Escalate)
+		end(err)
+		if err != nil {
+			log.Printf("Warning: failed to save current session: %v", err)
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string][]json.RawMessage{"exercises": responseExercises})
+	return responseExercises, limits, nil
 }
 
-func generateAndCacheExercises(topic *Topic) ([]*Exercise, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	openaiURL := os.Getenv("OPENAI_URL")
-	if openaiURL == "" {
-		openaiURL = "https://api.openai.com/v1"
+// servedExercise renders an exercise for a set: with its ID, with the hint in the user's native
+// language if hints has one, and with distractors only if a set of the difficulty gets them.
+func servedExercise(ex *Exercise, user *User, hints map[string]string, difficulty string) json.RawMessage {
+	raw := exerciseWithID(ex)
+	if hint, ok := hints[ex.AirtableID]; ok {
+		raw = withNativeHint(raw, hint, user.NativeLanguage)
 	}
-	modelName := os.Getenv("MODEL_NAME")
-	if modelName == "" {
-		modelName = "gpt-3.5-turbo-1106"
+	// Distractors are mixed into the word bank of hard sets, and of normal ones for learners who opted in
+	if !servesDistractors(user, difficulty) {
+		raw = withoutFields(raw, "distractors")
 	}
+	return raw
+}
 
-	finalPrompt, err := refinePrompt(topic.Prompt, apiKey, openaiURL, modelName)
-	if err != nil {
-		log.Printf("Error refining prompt, falling back to original: %v", err)
-		finalPrompt = topic.Prompt
-	} else {
-		lastRefinedPromptMutex.Lock()
-		lastRefinedPrompt = finalPrompt
-		lastRefinedPromptMutex.Unlock()
+// generateAndCacheExercises generates exercises for the topic with its tenant's key, counting
+// the call against the tenant's quota.
+func generateAndCacheExercises(ctx context.Context, topic *Topic, vars PromptVars) (newlyGenerated []*Exercise, err error) {
+	ctx = withTenantContext(ctx, topicTenant(topic))
+	if err := useGenerationQuota(ctx); err != nil {
+		return nil, err
 	}
+	llm := llmProviderFor(ctx)
+	apiKey, openaiURL, modelName := llm.APIKey, llm.URL, llm.Model
+
+	ctx, end := startSpan(ctx, "generate exercises",
+		attribute.String("topic.id", topic.ID), attribute.String("llm.model", modelName), attribute.Int("exercise.count", vars.Count))
+	defer func() {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("exercise.generated", len(newlyGenerated)))
+		end(err)
+		if err != nil {
+			recordEvent(AnalyticsEvent{Type: eventGenerationFailed, TopicID: topic.ID})
+			reportProgress(ctx, ProgressEvent{Stage: progressFailed, Message: "Generation failed", TopicID: topic.ID})
+			notifyWebhooks(webhookGenerationFailed, fmt.Sprintf("Exercise generation failed for topic %s: %v", topic.Name, err),
+				map[string]string{"topic_id": topic.ID, "topic_name": topic.Name, "error": err.Error()})
+		} else {
+			recordEvent(AnalyticsEvent{Type: eventGeneration, TopicID: topic.ID, Count: len(newlyGenerated)})
+			reportProgress(ctx, ProgressEvent{Stage: progressDone, Message: fmt.Sprintf("Generated %d exercises", len(newlyGenerated)),
+				TopicID: topic.ID, Done: len(newlyGenerated), Total: len(newlyGenerated)})
+		}
+	}()
+
+	renderedPrompt := withVocabularyBand(renderGenerationPrompt(topic.Prompt, vars), topic.VocabularyBand)
+	finalPrompt, refined := refineTopicPrompt(ctx, topic, renderedPrompt, apiKey, openaiURL, modelName)
 
 	openaiReq := OpenAIRequest{
 		Model:          modelName,
@@ -1249,48 +920,78 @@ func generateAndCacheExercises(topic *Topic) ([]*Exercise, error) {
 		ResponseFormat: &ResponseFormat{Type: "json_object"},
 	}
 
-	reqBody, _ := json.Marshal(openaiReq)
-	client := &http.Client{}
-	apiReq, _ := http.NewRequest("POST", openaiURL+"/chat/completions", bytes.NewBuffer(reqBody))
-	apiReq.Header.Set("Content-Type", "application/json")
-	apiReq.Header.Set("Authorization", "Bearer "+apiKey)
-
-	resp, err := client.Do(apiReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to call OpenAI API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
-	var openaiResp OpenAIResponse
-	json.Unmarshal(respBody, &openaiResp)
-
-	if openaiResp.Error != nil {
-		return nil, fmt.Errorf("API error: %s", openaiResp.Error.Message)
-	}
-
-	if len(openaiResp.Choices) == 0 || openaiResp.Choices[0].Message.Content == "" {
-		return nil, fmt.Errorf("received an empty response from OpenAI")
-	}
-
-	// The actual content is a JSON string inside the response.
+	reportProgress(ctx, ProgressEvent{Stage: progressGenerating, Message: "Generating exercises", TopicID: topic.ID, Total: vars.Count})
+
+	// The actual content is a JSON string inside the response. A provider whose reply
+	// doesn't parse is skipped like a failing one.
 	var exerciseData struct {
 		Exercises []json.RawMessage `json:"exercises"`
 	}
-	if err := json.Unmarshal([]byte(openaiResp.Choices[0].Message.Content), &exerciseData); err != nil {
-		return nil, fmt.Errorf("failed to parse exercises from OpenAI response: %w", err)
+	_, _, provider, err := completeWithFallback(ctx, openaiReq, func(content string) error {
+		if err := json.Unmarshal([]byte(content), &exerciseData); err != nil {
+			return fmt.Errorf("failed to parse exercises from response: %w", err)
+		}
+		if len(exerciseData.Exercises) == 0 {
+			return fmt.Errorf("no exercises in response")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	promptHash := getCacheHash(topic.Prompt, vars)
+
+	// Skip malformed exercises and sentences that are already cached for this prompt
+	seen := make(map[string]bool)
+	endStore := startStoreSpan(ctx, "GetExercisesForTopic")
+	cached, cachedErr := dataStore.GetExercisesForTopic(topic.ID, promptHash)
+	endStore(cachedErr)
+	for _, ex := range cached {
+		seen[ex.content().dedupKey()] = true
 	}
 
-	promptHash := getPromptHash(topic.Prompt)
-	var newlyGenerated []*Exercise
-	for _, exJSON := range exerciseData.Exercises {
-		exercise, err := createExercise(topic.ID, promptHash, string(exJSON))
+	var rejected []string
+	contents, errs := validateGeneratedExercises(ctx, topic, exerciseData.Exercises)
+	for i, exJSON := range exerciseData.Exercises {
+		reportProgress(ctx, ProgressEvent{Stage: progressCaching, Message: fmt.Sprintf("Cached %d/%d", len(newlyGenerated), len(exerciseData.Exercises)),
+			TopicID: topic.ID, Done: len(newlyGenerated), Total: len(exerciseData.Exercises)})
+		content, err := contents[i], errs[i]
+		if err != nil {
+			log.Printf("Warning: skipping invalid generated exercise: %v", err)
+			rejected = append(rejected, err.Error())
+			continue
+		}
+		key := content.dedupKey()
+		if seen[key] {
+			log.Printf("Skipping duplicate generated exercise: %s", content.Sentence)
+			continue
+		}
+		seen[key] = true
+
+		endStore = startStoreSpan(ctx, "CreateExercise")
+		exercise, err := dataStore.CreateExercise(topic.ID, promptHash, vars.Theme, withDifficulty(string(exJSON), vars.Difficulty))
+		endStore(err)
 		if err != nil {
 			log.Printf("Warning: failed to cache exercise: %v", err)
 			continue
 		}
 		newlyGenerated = append(newlyGenerated, exercise)
 	}
+	if len(rejected) > 0 {
+		notifyWebhooks(webhookExerciseFlagged, fmt.Sprintf("%d generated exercises for topic %s failed validation and were not cached", len(rejected), topic.Name),
+			map[string]any{"topic_id": topic.ID, "topic_name": topic.Name, "count": len(rejected), "errors": rejected})
+	}
+
+	if refined {
+		recordRefinedPrompt(RefinedPrompt{
+			TopicID:        topic.ID,
+			Model:          provider.Model,
+			OriginalPrompt: renderedPrompt,
+			RefinedPrompt:  finalPrompt,
+			ExerciseCount:  len(newlyGenerated),
+		})
+	}
 
 	return newlyGenerated, nil
 }
@@ -1300,20 +1001,49 @@ func getEligibleExercisesForSRS(allExercises []*Exercise, userViews map[string]*
 	now := time.Now()
 	for _, ex := range allExercises {
 		view, seen := userViews[ex.AirtableID]
-		if !seen {
-			eligible = append(eligible, ex)
-			continue
-		}
-		// SRS logic: next review date is (counter^2) days after last view
-		daysSinceView := now.Sub(view.LastViewed).Hours() / 24
-		nextReviewInDays := float64(view.RepetitionCounter * view.RepetitionCounter)
-		if daysSinceView >= nextReviewInDays {
+		if !seen || isDueForReview(view, now) {
 			eligible = append(eligible, ex)
 		}
 	}
 	return eligible
 }
 
+// getSoonestDueExercises returns up to count exercises not in exclude, those closest to
+// (or furthest past) their next review first.
+func getSoonestDueExercises(allExercises, exclude []*Exercise, userViews map[string]*UserExerciseView, count int) []*Exercise {
+	excluded := make(map[string]bool, len(exclude))
+	for _, ex := range exclude {
+		excluded[ex.AirtableID] = true
+	}
+	var candidates []*Exercise
+	for _, ex := range allExercises {
+		if !excluded[ex.AirtableID] {
+			candidates = append(candidates, ex)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return nextReviewTime(userViews[candidates[i].AirtableID]).Before(nextReviewTime(userViews[candidates[j].AirtableID]))
+	})
+	if len(candidates) > count {
+		candidates = candidates[:count]
+	}
+	return candidates
+}
+
+// nextReviewTime is when a viewed exercise is next due; unseen exercises are due now.
+func nextReviewTime(view *UserExerciseView) time.Time {
+	if view == nil {
+		return time.Time{}
+	}
+	return nextReviewAt(view)
+}
+
+// isDueForReview applies the SRS schedule: the next review is (counter^2) days after the last
+// view, scaled by the learner's grade.
+func isDueForReview(view *UserExerciseView, now time.Time) bool {
+	return !now.Before(nextReviewAt(view))
+}
+
 func getRandomExercises(exercises []*Exercise, count int) []*Exercise {
 	if len(exercises) <= count {
 		return exercises
@@ -1325,16 +1055,25 @@ func getRandomExercises(exercises []*Exercise, count int) []*Exercise {
 }
 
 func getUserIDFromRequest(r *http.Request) string {
-	cookie, err := r.Cookie("user_id")
+	// A bearer token takes precedence, and never falls back to the cookie
+	if secret, ok := bearerToken(r); ok {
+		return authenticateAPIToken(secret)
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
 	if err != nil {
 		return "" // No cookie, so not logged in
 	}
-	return cookie.Value
+	userID, _, ok := verifySessionValue(cookie.Value)
+	if !ok || strings.HasPrefix(userID, guestOwnerPrefix) {
+		return "" // Unsigned, tampered or guest cookie
+	}
+	return userID
 }
 
 func handleGenerate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -1348,71 +1087,38 @@ func handleGenerate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Rate limiting
-	ip := getClientIP(r)
-	mu.Lock()
-	if _, found := clients[ip]; !found {
-		// Allow 1 request every 3 seconds, with a burst of 1.
-		clients[ip] = &client{limiter: rate.NewLimiter(rate.Every(3*time.Second), 1)}
-	}
-	clients[ip].lastSeen = time.Now()
-	if !clients[ip].limiter.Allow() {
-		mu.Unlock()
-		// Return a JSON error message
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusTooManyRequests)
-		json.NewEncoder(w).Encode(map[string]any{
-			"error": map[string]string{
-				"message": "You are making requests too quickly. Please wait a few seconds and try again.",
-			},
-		})
-		return
-	}
-	mu.Unlock()
-
-	// Get configuration from environment
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		http.Error(w, "OpenAI API key not configured", http.StatusInternalServerError)
-		return
-	}
-
-	openaiURL := os.Getenv("OPENAI_URL")
-	if openaiURL == "" {
-		openaiURL = "https://api.openai.com/v1"
-	}
-	
-	modelName := os.Getenv("MODEL_NAME")
-	if modelName == "" {
-		modelName = "gpt-3.5-turbo-1106"
-	}
-
 	// Parse request
 	var req GenerateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
 	// Get topic and its prompt
-	topic, err := getTopic(req.TopicID)
-	if err != nil {
-		http.Error(w, "Topic not found", http.StatusNotFound)
+	ctx := r.Context()
+	end := startStoreSpan(ctx, "GetTopic")
+	topic, err := dataStore.GetTopic(req.TopicID)
+	end(err)
+	if err != nil || !topicInTenant(ctx, topic) {
+		writeError(w, "Topic not found", http.StatusNotFound)
 		return
 	}
-
-	// Refine the prompt
-	finalPrompt, err := refinePrompt(topic.Prompt, apiKey, openaiURL, modelName)
-	if err != nil {
-		// If refining fails, log the error and fall back to the original prompt
-		log.Printf("Error refining prompt, falling back to original: %v", err)
-		finalPrompt = topic.Prompt
-	} else {
-		// Store the last successfully refined prompt for observability
-		lastRefinedPromptMutex.Lock()
-		lastRefinedPrompt = finalPrompt
-		lastRefinedPromptMutex.Unlock()
+	if userID := getUserIDFromRequest(r); userID != "" && !usesOwnAPIKey(ctx) {
+		if err := useUserQuota(userID, time.Now()); err != nil {
+			writeStatusError(w, err)
+			return
+		}
+	}
+	if err := useGenerationQuota(ctx); err != nil {
+		writeAPIError(w, http.StatusTooManyRequests, APIError{Code: "quota_exceeded", Message: err.Error()})
+		return
 	}
+	llm := llmProviderFor(ctx)
+	apiKey, openaiURL, modelName := llm.APIKey, llm.URL, llm.Model
+
+	// Resolve template variables, then refine the prompt
+	renderedPrompt := withVocabularyBand(renderGenerationPrompt(topic.Prompt, promptVarsFromRequest(req)), topic.VocabularyBand)
+	finalPrompt, refined := refineTopicPrompt(ctx, topic, renderedPrompt, apiKey, openaiURL, modelName)
 
 	// Create OpenAI request with the (potentially refined) prompt
 	openaiReq := OpenAIRequest{
@@ -1426,68 +1132,40 @@ func handleGenerate(w http.ResponseWriter, r *http.Request) {
 		ResponseFormat: &ResponseFormat{Type: "json_object"},
 	}
 
-	// Marshal request
-	reqBody, err := json.Marshal(openaiReq)
-	if err != nil {
-		http.Error(w, "Failed to create request", http.StatusInternalServerError)
-		return
-	}
-
-	// Make request to OpenAI API
-	client := &http.Client{}
-	apiReq, err := http.NewRequest("POST", openaiURL+"/chat/completions", bytes.NewBuffer(reqBody))
-	if err != nil {
-		http.Error(w, "Failed to create API request", http.StatusInternalServerError)
-		return
-	}
-
-	apiReq.Header.Set("Content-Type", "application/json")
-	apiReq.Header.Set("Authorization", "Bearer "+apiKey)
-
-	resp, err := client.Do(apiReq)
+	// Call the providers of the fallback chain until one answers
+	content, respBody, provider, err := completeWithFallback(ctx, openaiReq, nil)
 	if err != nil {
-		http.Error(w, "Failed to call OpenAI API", http.StatusInternalServerError)
+		recordEvent(AnalyticsEvent{Type: eventGenerationFailed, TopicID: topic.ID})
+		writeAPIError(w, http.StatusBadGateway, APIError{Code: "upstream_error", Message: err.Error()})
 		return
 	}
-	defer resp.Body.Close()
 
-	// Read response
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, "Failed to read API response", http.StatusInternalServerError)
-		return
+	var exerciseData struct {
+		Exercises []json.RawMessage `json:"exercises"`
 	}
+	json.Unmarshal([]byte(content), &exerciseData)
 
-	// Parse response to check for errors
-	var openaiResp OpenAIResponse
-	if err := json.Unmarshal(respBody, &openaiResp); err != nil {
-		http.Error(w, "Failed to parse API response", http.StatusInternalServerError)
-		return
+	// Store the successfully refined prompt for observability, with the number of exercises it produced
+	if refined {
+		recordRefinedPrompt(RefinedPrompt{
+			TopicID:        topic.ID,
+			Model:          provider.Model,
+			OriginalPrompt: renderedPrompt,
+			RefinedPrompt:  finalPrompt,
+			ExerciseCount:  len(exerciseData.Exercises),
+		})
 	}
 
-	// Check for API errors
-	if openaiResp.Error != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(resp.StatusCode)
-		errorResp := map[string]any{
-			"error": map[string]string{
-				"message": openaiResp.Error.Message,
-				"type":    openaiResp.Error.Type,
-			},
-		}
-		json.NewEncoder(w).Encode(errorResp)
-		return
-	}
+	recordEvent(AnalyticsEvent{Type: eventGeneration, TopicID: topic.ID, Count: len(exerciseData.Exercises)})
 
 	// Forward successful response
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.StatusCode)
 	w.Write(respBody)
 }
 
 func handleGetLastRefinedPrompt(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -1502,7 +1180,7 @@ func handleGetLastRefinedPrompt(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
 
@@ -1520,33 +1198,59 @@ func handleTopics(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		topicsList, err := getAllTopics()
+		// GET /api/topics?q=&include_archived=true&sort=name&limit=50&cursor=
+		params, err := parseListParams(r, []string{"created_at", "updated_at", "name"}, "created_at")
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to get topics: %v", err), http.StatusInternalServerError)
+			writeError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string][]*Topic{"topics": topicsList})
+
+		var topicsList []*Topic
+		if r.URL.Query().Get("include_archived") == "true" {
+			topicsList, err = tenantTopics(r.Context())
+		} else {
+			topicsList, err = getActiveTopics(r.Context())
+		}
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to get topics: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q"))); query != "" {
+			var matching []*Topic
+			for _, topic := range topicsList {
+				if strings.Contains(strings.ToLower(topic.Name), query) {
+					matching = append(matching, topic)
+				}
+			}
+			topicsList = matching
+		}
+		sortItems(topicsList, params, map[string]func(a, b *Topic) bool{
+			"created_at": func(a, b *Topic) bool { return a.CreatedAt.Before(b.CreatedAt) },
+			"updated_at": func(a, b *Topic) bool { return a.UpdatedAt.Before(b.UpdatedAt) },
+			"name":       func(a, b *Topic) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) },
+		})
+
+		writeJSONWithETag(w, r, paginate(topicsList, params))
 
 	case http.MethodPost:
-		adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		tenantAdminOnly(func(w http.ResponseWriter, r *http.Request) {
 			var req TopicRequest
 			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				writeError(w, "Invalid request body", http.StatusBadRequest)
 				return
 			}
 
 			if req.Name == "" || req.Prompt == "" {
-				http.Error(w, "Name and prompt are required", http.StatusBadRequest)
+				writeError(w, "Name and prompt are required", http.StatusBadRequest)
 				return
 			}
 
-			topic, err := createTopic(req.Name, req.Prompt)
+			topic, err := createTopic(req.Name, req.Prompt, tenantIDFromContext(r.Context()))
 			if err != nil {
-				http.Error(w, fmt.Sprintf("Failed to create topic: %v", err), http.StatusInternalServerError)
+				writeError(w, fmt.Sprintf("Failed to create topic: %v", err), http.StatusInternalServerError)
 				return
 			}
+			recordAudit(r, auditTopicCreate, "topic", topic.ID, nil, topic)
 
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusCreated)
@@ -1554,53 +1258,75 @@ func handleTopics(w http.ResponseWriter, r *http.Request) {
 		}).ServeHTTP(w, r)
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 func handleUserStats(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("user_id")
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	userID := cookie.Value
+	// Guests' stats are kept under their guest ID until they sign in
+	userID := getProgressOwnerID(w, r)
 
 	switch r.Method {
 	case http.MethodGet:
-		stats, err := getUserStats(userID)
+		stats, xp, err := getStatsTotals(userID)
 		if err != nil {
-			http.Error(w, "Failed to get user stats", http.StatusInternalServerError)
+			writeError(w, "Failed to get user stats", http.StatusInternalServerError)
 			return
 		}
-		json.NewEncoder(w).Encode(stats)
+		json.NewEncoder(w).Encode(struct {
+			*UserStats
+			XPProgress
+		}{stats, xp})
 	case http.MethodPost:
-		var stats UserStats
-		if err := json.NewDecoder(r.Body).Decode(&stats); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		// The body holds one finished set, which is capped and recorded as a stats event like
+		// an increment. Clients that may retry should use /api/user/stats/increment, which
+		// takes an idempotency key.
+		var set struct {
+			TopicID string `json:"topic_id"`
+			UserStats
+		}
+		if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if set.TotalExercises < 0 || set.TotalMistakes < 0 || set.TotalHints < 0 || set.TotalTime < 0 {
+			writeError(w, "Invalid stats values", http.StatusBadRequest)
+			return
+		}
+		event := &StatsEvent{
+			OwnerID:   userID,
+			TopicID:   set.TopicID,
+			Exercises: set.TotalExercises,
+			Mistakes:  set.TotalMistakes,
+			Hints:     set.TotalHints,
+			TimeSpent: set.TotalTime,
+		}
+		err := recordCappedStats(event, time.Now())
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			writeStatusError(w, err)
 			return
 		}
-		stats.UserID = userID
-		if err := updateUserStats(&stats); err != nil {
-			http.Error(w, "Failed to update user stats", http.StatusInternalServerError)
+		if err != nil {
+			log.Printf("Error recording stats: %v", err)
+			writeError(w, "Failed to update user stats", http.StatusInternalServerError)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 func handleUserSettings(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("user_id")
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	userID := cookie.Value
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -1608,184 +1334,38 @@ func handleUserSettings(w http.ResponseWriter, r *http.Request) {
 		LastTopicID string `json:"last_topic_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	if err := updateUserSetting(userID, settings.LastTopicID); err != nil {
-		http.Error(w, "Failed to update user settings", http.StatusInternalServerError)
+	if err := dataStore.UpdateUserSetting(userID, settings.LastTopicID); err != nil {
+		writeError(w, "Failed to update user settings", http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func getUserByGoogleID(googleID string) (*User, error) {
-	table := airtableClient.GetTable(airtableBaseID, usersTableName)
-	records, err := table.GetRecords().WithFilterFormula(fmt.Sprintf("{GoogleID} = '%s'", googleID)).Do()
-	if err != nil {
-		return nil, err
-	}
-
-	if len(records.Records) == 0 {
-		return nil, nil // Not found
-	}
-
-	record := records.Records[0]
-	return &User{
-		ID:         record.ID,
-		GoogleID:   record.Fields["GoogleID"].(string),
-		AirtableID: record.ID,
-	}, nil
-}
-
-func createUser(googleID string) (*User, error) {
-	table := airtableClient.GetTable(airtableBaseID, usersTableName)
-	records := &airtable.Records{
-		Records: []*airtable.Record{
-			{
-				Fields: map[string]any{
-					"GoogleID": googleID,
-				},
-			},
-		},
-	}
-	result, err := table.AddRecords(records)
-	if err != nil {
-		return nil, err
-	}
-
-	record := result.Records[0]
-	return &User{
-		ID:         record.ID,
-		GoogleID:   record.Fields["GoogleID"].(string),
-		AirtableID: record.ID,
-	}, nil
-}
-
-func getUserStats(userID string) (*UserStats, error) {
-	table := airtableClient.GetTable(airtableBaseID, userStatsTableName)
-	records, err := table.GetRecords().WithFilterFormula(fmt.Sprintf("{UserID} = '%s'", userID)).Do()
-	if err != nil {
-		return nil, err
-	}
-
-	if len(records.Records) == 0 {
-		return &UserStats{UserID: userID}, nil // Return empty stats if not found
-	}
-
-	record := records.Records[0]
-	stats := &UserStats{
-		UserID:           userID,
-		AirtableRecordID: record.ID,
-	}
-
-	if val, ok := record.Fields["TotalExercises"].(float64); ok {
-		stats.TotalExercises = int(val)
-	}
-	if val, ok := record.Fields["TotalMistakes"].(float64); ok {
-		stats.TotalMistakes = int(val)
-	}
-	if val, ok := record.Fields["TotalHints"].(float64); ok {
-		stats.TotalHints = int(val)
-	}
-	if val, ok := record.Fields["TotalTime"].(float64); ok {
-		stats.TotalTime = int(val)
-	}
-	if val, ok := record.Fields["LastTopicID"].(string); ok {
-		stats.LastTopicID = val
-	}
-
-	return stats, nil
-}
-
-func updateUserStats(stats *UserStats) error {
-	table := airtableClient.GetTable(airtableBaseID, userStatsTableName)
-	fields := map[string]any{
-		"UserID":         stats.UserID,
-		"TotalExercises": stats.TotalExercises,
-		"TotalMistakes":  stats.TotalMistakes,
-		"TotalHints":     stats.TotalHints,
-		"TotalTime":      stats.TotalTime,
-	}
-
-	if stats.AirtableRecordID != "" {
-		// Update existing record
-		records := &airtable.Records{
-			Records: []*airtable.Record{
-				{
-					ID:     stats.AirtableRecordID,
-					Fields: fields,
-				},
-			},
-		}
-		_, err := table.UpdateRecords(records)
-		return err
-	}
-
-	// Create new record
-	records := &airtable.Records{
-		Records: []*airtable.Record{
-			{
-				Fields: fields,
-			},
-		},
-	}
-	_, err := table.AddRecords(records)
-	return err
-}
-
-func updateUserSetting(userID, lastTopicID string) error {
-	stats, err := getUserStats(userID)
-	if err != nil {
-		return err
-	}
-
-	stats.LastTopicID = lastTopicID
-
-	table := airtableClient.GetTable(airtableBaseID, userStatsTableName)
-	fields := map[string]any{
-		"UserID":      userID,
-		"LastTopicID": lastTopicID,
-	}
-
-	if stats.AirtableRecordID != "" {
-		// Update existing record
-		records := &airtable.Records{
-			Records: []*airtable.Record{
-				{
-					ID:     stats.AirtableRecordID,
-					Fields: fields,
-				},
-			},
-		}
-		_, err := table.UpdateRecords(records)
-		return err
-	}
-
-	// Create new record
-	records := &airtable.Records{
-		Records: []*airtable.Record{
-			{
-				Fields: fields,
-			},
-		},
-	}
-	_, err = table.AddRecords(records)
-	return err
-}
-
 func handleGoogleLogin(w http.ResponseWriter, r *http.Request) {
 	if googleOauthConfig == nil {
-		http.Error(w, "Google login is not configured", http.StatusInternalServerError)
+		writeError(w, "Google login is not configured", http.StatusServiceUnavailable)
 		return
 	}
-	url := googleOauthConfig.AuthCodeURL(oauthStateString)
+	// Offline access gets a refresh token, kept only when it can be encrypted; consent=1
+	// asks for consent again, since Google only sends a refresh token on consent
+	var opts []oauth2.AuthCodeOption
+	if secretsEncrypted() {
+		opts = append(opts, oauth2.AccessTypeOffline, oauth2.SetAuthURLParam("include_granted_scopes", "true"))
+		if r.URL.Query().Get("consent") == "1" {
+			opts = append(opts, oauth2.ApprovalForce)
+		}
+	}
+	url := googleOauthConfig.AuthCodeURL(oauthStateString, opts...)
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
 func handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 	if googleOauthConfig == nil {
-		http.Error(w, "Google login is not configured", http.StatusInternalServerError)
+		writeError(w, "Google login is not configured", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -1820,111 +1400,90 @@ func handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get or create user in Airtable
-	user, err := getUserByGoogleID(userinfo.Id)
+	user, err := dataStore.GetUserByGoogleID(userinfo.Id)
 	if err != nil {
 		log.Printf("Unable to get user by google ID: %v", err)
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 	if user == nil {
-		user, err = createUser(userinfo.Id)
+		user, err = dataStore.CreateUser(userinfo.Id)
 		if err != nil {
 			log.Printf("Unable to create user: %v", err)
 			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 			return
 		}
+		notifyWebhooks(webhookUserSignup, "New user signed up with Google", map[string]string{"user_id": user.ID, "method": "google"})
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "user_id",
-		Value:    user.ID,
-		HttpOnly: true,
-		Path:     "/",
-		Expires:  time.Now().Add(30 * 24 * time.Hour), // 30 days
-	})
+	if userinfo.Email != "" && userinfo.Email != user.Email {
+		if err := dataStore.SetUserEmail(user.ID, userinfo.Email); err != nil {
+			log.Printf("Warning: unable to store user email: %v", err)
+		}
+	}
+
+	if err := storeGoogleToken(user.ID, token); err != nil {
+		log.Printf("Warning: unable to store Google token: %v", err)
+	}
 
-	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	startUserSession(w, r, user.ID)
+
+	http.Redirect(w, r, tenantHome(r), http.StatusTemporaryRedirect)
 }
 
 func handleAuthStatus(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("user_id")
-	if err != nil {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
 		json.NewEncoder(w).Encode(map[string]any{"logged_in": false})
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]any{"logged_in": true, "user_id": cookie.Value})
+	json.NewEncoder(w).Encode(map[string]any{"logged_in": true, "user_id": userID})
 }
 
 func handleLogout(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "user_id",
-		Value:    "",
-		HttpOnly: true,
-		Path:     "/",
-		Expires:  time.Unix(0, 0),
-	})
-	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	clearSessionCookie(w)
+	http.Redirect(w, r, tenantHome(r), http.StatusTemporaryRedirect)
 }
 
 func handleIsAdmin(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	// On a tenant, its admin manages its topics too
 	isAdmin := false
-	if googleAdminID != "" {
-		userID := getUserIDFromRequest(r)
-		if userID != "" {
-			user, err := getUserByID(userID)
-			if err == nil && user != nil && user.GoogleID == googleAdminID {
-				isAdmin = true
-			}
+	if userID := getUserIDFromRequest(r); userID != "" {
+		user, err := dataStore.GetUserByID(userID)
+		if err == nil && user != nil && (isAdminUser(user) || isTenantAdmin(user, tenantFromContext(r.Context()))) {
+			isAdmin = true
 		}
 	}
 
 	json.NewEncoder(w).Encode(map[string]bool{"is_admin": isAdmin})
 }
 
-func getUserByID(userID string) (*User, error) {
-	table := airtableClient.GetTable(airtableBaseID, usersTableName)
-	record, err := table.GetRecord(userID)
-	if err != nil {
-		return nil, err
-	}
-
-	if record == nil {
-		return nil, nil // Not found
-	}
-
-	return &User{
-		ID:         record.ID,
-		GoogleID:   record.Fields["GoogleID"].(string),
-		AirtableID: record.ID,
-	}, nil
-}
-
 func adminOnly(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if googleAdminID == "" {
-			http.Error(w, "Admin features are not configured", http.StatusForbidden)
+			writeError(w, "Admin features are not configured", http.StatusForbidden)
 			return
 		}
 
 		userID := getUserIDFromRequest(r)
 		if userID == "" {
-			http.Error(w, "You must be logged in to perform this action", http.StatusUnauthorized)
+			writeError(w, "You must be logged in to perform this action", http.StatusUnauthorized)
 			return
 		}
 
-		user, err := getUserByID(userID)
+		user, err := dataStore.GetUserByID(userID)
 		if err != nil || user == nil {
 			log.Printf("Error getting user for admin check (userID: %s): %v", userID, err)
-			http.Error(w, "Could not verify user credentials", http.StatusInternalServerError)
+			writeError(w, "Could not verify user credentials", http.StatusInternalServerError)
 			return
 		}
 
 		if user.GoogleID != googleAdminID {
 			log.Printf("Admin access denied for user (googleID: %s)", user.GoogleID)
-			http.Error(w, "You do not have permission to perform this action", http.StatusForbidden)
+			writeError(w, "You do not have permission to perform this action", http.StatusForbidden)
 			return
 		}
 
@@ -1936,7 +1495,7 @@ func adminOnly(h http.HandlerFunc) http.HandlerFunc {
 func handleTopicByID(w http.ResponseWriter, r *http.Request) {
 	// Enable CORS
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 	
 	if r.Method == http.MethodOptions {
@@ -1944,63 +1503,148 @@ func handleTopicByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Extract topic ID from path
-	topicID := strings.TrimPrefix(r.URL.Path, "/api/topics/")
+	// Extract topic ID from path: /api/topics/{topicID}, /api/topics/{topicID}/restore, /api/topics/{topicID}/duplicate
+	// /api/topics/{topicID}/refinement, /api/topics/{topicID}/vocabulary or /api/topics/{topicID}/grammar-tags
+	pathParts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/topics/"), "/")
+	topicID := pathParts[0]
 	if topicID == "" {
-		http.Error(w, "Topic ID required", http.StatusBadRequest)
+		writeError(w, "Topic ID required", http.StatusBadRequest)
+		return
+	}
+	if topic, err := dataStore.GetTopic(topicID); err == nil && !topicInTenant(r.Context(), topic) {
+		writeError(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+
+	if len(pathParts) > 1 {
+		if pathParts[1] == "restore" && r.Method == http.MethodPost {
+			tenantAdminOnly(func(w http.ResponseWriter, r *http.Request) {
+				before, _ := dataStore.GetTopic(topicID)
+				topic, err := dataStore.SetTopicArchived(topicID, false)
+				if err != nil {
+					writeError(w, fmt.Sprintf("Failed to restore topic: %v", err), http.StatusInternalServerError)
+					return
+				}
+				recordAudit(r, auditTopicRestore, "topic", topicID, before, topic)
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(topic)
+			}).ServeHTTP(w, r)
+			return
+		}
+		if pathParts[1] == "duplicate" && r.Method == http.MethodPost {
+			tenantAdminOnly(func(w http.ResponseWriter, r *http.Request) {
+				handleTopicDuplicate(w, r, topicID)
+			}).ServeHTTP(w, r)
+			return
+		}
+		if pathParts[1] == "refinement" && r.Method == http.MethodPut {
+			tenantAdminOnly(func(w http.ResponseWriter, r *http.Request) {
+				var req TopicRefinementRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeError(w, "Invalid request body", http.StatusBadRequest)
+					return
+				}
+				before, _ := dataStore.GetTopic(topicID)
+				topic, err := dataStore.SetTopicRefinement(topicID, req.RefinementDisabled, strings.TrimSpace(req.MetaPrompt))
+				if err != nil {
+					writeError(w, fmt.Sprintf("Failed to update refinement settings: %v", err), http.StatusInternalServerError)
+					return
+				}
+				recordAudit(r, auditTopicRefinement, "topic", topicID, before, topic)
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(topic)
+			}).ServeHTTP(w, r)
+			return
+		}
+		if pathParts[1] == "grammar-tags" && r.Method == http.MethodGet {
+			handleTopicGrammarTags(w, r, topicID)
+			return
+		}
+		if pathParts[1] == "vocabulary" && r.Method == http.MethodPut {
+			tenantAdminOnly(func(w http.ResponseWriter, r *http.Request) {
+				handleTopicVocabulary(w, r, topicID)
+			}).ServeHTTP(w, r)
+			return
+		}
+		http.NotFound(w, r)
 		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
-		topic, err := getTopic(topicID)
+		topic, err := dataStore.GetTopic(topicID)
 		if err != nil {
-			http.Error(w, "Topic not found", http.StatusNotFound)
+			writeError(w, "Topic not found", http.StatusNotFound)
 			return
 		}
-		
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(topic)
+		writeJSONWithETag(w, r, topic)
 
 	case http.MethodPut:
-		adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		tenantAdminOnly(func(w http.ResponseWriter, r *http.Request) {
 			var req UpdateTopicRequest
 			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				writeError(w, "Invalid request body", http.StatusBadRequest)
 				return
 			}
 
 			if req.Prompt == "" {
-				http.Error(w, "Prompt is required", http.StatusBadRequest)
+				writeError(w, "Prompt is required", http.StatusBadRequest)
 				return
 			}
 
-			topic, err := updateTopic(topicID, req.Name, req.Prompt)
+			before, _ := dataStore.GetTopic(topicID)
+			topic, err := dataStore.UpdateTopic(topicID, req.Name, req.Prompt)
 			if err != nil {
-				http.Error(w, fmt.Sprintf("Failed to update topic: %v", err), http.StatusInternalServerError)
+				writeError(w, fmt.Sprintf("Failed to update topic: %v", err), http.StatusInternalServerError)
 				return
 			}
+			recordAudit(r, auditTopicUpdate, "topic", topicID, before, topic)
 
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(topic)
 		}).ServeHTTP(w, r)
 
 	case http.MethodDelete:
-		adminOnly(func(w http.ResponseWriter, r *http.Request) {
-			err := deleteTopic(topicID)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Failed to delete topic: %v", err), http.StatusInternalServerError)
-				return
+		tenantAdminOnly(func(w http.ResponseWriter, r *http.Request) {
+			// Topics are archived by default; ?permanent=true removes an archived topic and its
+			// versions, keeping a snapshot of both in the audit log
+			before, _ := dataStore.GetTopic(topicID)
+			if r.URL.Query().Get("permanent") == "true" {
+				if before == nil || !before.Archived {
+					writeError(w, "Archive the topic before deleting it permanently", http.StatusConflict)
+					return
+				}
+				versions, err := dataStore.GetVersions(topicID)
+				if err != nil {
+					writeError(w, fmt.Sprintf("Failed to get versions: %v", err), http.StatusInternalServerError)
+					return
+				}
+				if err := dataStore.DeleteTopic(topicID); err != nil {
+					writeError(w, fmt.Sprintf("Failed to delete topic: %v", err), http.StatusInternalServerError)
+					return
+				}
+				recordAudit(r, auditTopicDelete, "topic", topicID, map[string]any{"topic": before, "versions": versions}, nil)
+			} else {
+				topic, err := dataStore.SetTopicArchived(topicID, true)
+				if err != nil {
+					writeError(w, fmt.Sprintf("Failed to archive topic: %v", err), http.StatusInternalServerError)
+					return
+				}
+				recordAudit(r, auditTopicArchive, "topic", topicID, before, topic)
 			}
 
 			w.WriteHeader(http.StatusNoContent)
 		}).ServeHTTP(w, r)
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+const maxVersionLabelLength = 100
+
 // Handle prompt versions
 func handleVersions(w http.ResponseWriter, r *http.Request) {
 	// Enable CORS
@@ -2016,64 +1660,160 @@ func handleVersions(w http.ResponseWriter, r *http.Request) {
 	// Extract topic ID from path: /api/versions/{topicID} or /api/versions/{topicID}/restore/{versionID}
 	pathParts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/versions/"), "/")
 	if len(pathParts) < 1 || pathParts[0] == "" {
-		http.Error(w, "Topic ID required", http.StatusBadRequest)
+		writeError(w, "Topic ID required", http.StatusBadRequest)
 		return
 	}
 	
 	topicID := pathParts[0]
+	if topic, err := dataStore.GetTopic(topicID); err == nil && !topicInTenant(r.Context(), topic) {
+		writeError(w, "Topic not found", http.StatusNotFound)
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
-		versions, err := getVersions(topicID)
+		// GET /api/versions/{topicID}?pinned=true&sort=-version&limit=50&cursor=
+		params, err := parseListParams(r, []string{"version", "created_at"}, "version")
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		versions, err := dataStore.GetVersions(topicID)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to get versions: %v", err), http.StatusInternalServerError)
+			writeError(w, fmt.Sprintf("Failed to get versions: %v", err), http.StatusInternalServerError)
 			return
 		}
-		
+		if pinned := r.URL.Query().Get("pinned"); pinned != "" {
+			var matching []*PromptVersion
+			for _, version := range versions {
+				if version.Pinned == (pinned == "true") {
+					matching = append(matching, version)
+				}
+			}
+			versions = matching
+		}
+		sortItems(versions, params, map[string]func(a, b *PromptVersion) bool{
+			"version":    func(a, b *PromptVersion) bool { return a.Version < b.Version },
+			"created_at": func(a, b *PromptVersion) bool { return a.CreatedAt.Before(b.CreatedAt) },
+		})
+
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string][]*PromptVersion{"versions": versions})
+		json.NewEncoder(w).Encode(paginate(versions, params))
 
 	case http.MethodPost:
-		adminOnly(func(w http.ResponseWriter, r *http.Request) {
-			// Restore version: POST /api/versions/{topicID}/restore/{versionID}
+		tenantAdminOnly(func(w http.ResponseWriter, r *http.Request) {
+			// Pin or unpin version: POST /api/versions/{topicID}/pin/{versionID} (or /unpin/)
+			if len(pathParts) >= 3 && (pathParts[1] == "pin" || pathParts[1] == "unpin") {
+				version, err := dataStore.GetVersion(pathParts[2])
+				if err != nil {
+					writeError(w, "Version not found", http.StatusNotFound)
+					return
+				}
+				if version.TopicID != topicID {
+					writeError(w, "Version does not belong to this topic", http.StatusBadRequest)
+					return
+				}
+
+				before := version
+				version, err = dataStore.SetVersionPinned(version.ID, pathParts[1] == "pin")
+				if err != nil {
+					writeError(w, fmt.Sprintf("Failed to update version: %v", err), http.StatusInternalServerError)
+					return
+				}
+				action := auditVersionUnpin
+				if pathParts[1] == "pin" {
+					action = auditVersionPin
+				}
+				recordAudit(r, action, "version", version.ID, before, version)
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(version)
+				return
+			}
+
+			// Label version: POST /api/versions/{topicID}/label/{versionID} { "label" }, "" removes the label
+			if len(pathParts) >= 3 && pathParts[1] == "label" {
+				var req struct {
+					Label string `json:"label"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					writeError(w, "Invalid request body", http.StatusBadRequest)
+					return
+				}
+				label := strings.TrimSpace(req.Label)
+				if utf8.RuneCountInString(label) > maxVersionLabelLength {
+					writeError(w, fmt.Sprintf("Label must be at most %d characters", maxVersionLabelLength), http.StatusBadRequest)
+					return
+				}
+				version, err := dataStore.GetVersion(pathParts[2])
+				if err != nil {
+					writeError(w, "Version not found", http.StatusNotFound)
+					return
+				}
+				if version.TopicID != topicID {
+					writeError(w, "Version does not belong to this topic", http.StatusBadRequest)
+					return
+				}
+
+				before := version
+				version, err = dataStore.SetVersionLabel(version.ID, label)
+				if err != nil {
+					writeError(w, fmt.Sprintf("Failed to update version: %v", err), http.StatusInternalServerError)
+					return
+				}
+				recordAudit(r, auditVersionLabel, "version", version.ID, before, version)
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(version)
+				return
+			}
+
+			// Restore version: POST /api/versions/{topicID}/restore/{versionID}, with ?restore_name=true
+			// also restoring the topic's name at that version
 			if len(pathParts) < 3 || pathParts[1] != "restore" {
-				http.Error(w, "Invalid restore path", http.StatusBadRequest)
+				writeError(w, "Invalid restore path", http.StatusBadRequest)
 				return
 			}
 
 			versionID := pathParts[2]
 
-			versionToRestore, err := getVersion(versionID)
+			versionToRestore, err := dataStore.GetVersion(versionID)
 			if err != nil {
-				http.Error(w, "Version not found", http.StatusNotFound)
+				writeError(w, "Version not found", http.StatusNotFound)
 				return
 			}
 
 			// Verify the version belongs to the requested topic
 			if versionToRestore.TopicID != topicID {
-				http.Error(w, "Version does not belong to this topic", http.StatusBadRequest)
+				writeError(w, "Version does not belong to this topic", http.StatusBadRequest)
 				return
 			}
 
-			// Get the current topic name to preserve it
-			currentTopic, err := getTopic(topicID)
+			// Get the current topic name to preserve it, unless the version's name is restored too.
+			// Versions saved before names were kept have none.
+			currentTopic, err := dataStore.GetTopic(topicID)
 			if err != nil {
-				http.Error(w, "Failed to get current topic", http.StatusNotFound)
+				writeError(w, "Failed to get current topic", http.StatusNotFound)
 				return
 			}
+			name := currentTopic.Name
+			if r.URL.Query().Get("restore_name") == "true" && versionToRestore.Name != "" {
+				name = versionToRestore.Name
+			}
 
 			// Update topic with restored prompt (this will automatically create a new version)
-			topic, err := updateTopic(topicID, currentTopic.Name, versionToRestore.Prompt)
+			topic, err := dataStore.UpdateTopic(topicID, name, versionToRestore.Prompt)
 			if err != nil {
-				http.Error(w, fmt.Sprintf("Failed to restore version: %v", err), http.StatusInternalServerError)
+				writeError(w, fmt.Sprintf("Failed to restore version: %v", err), http.StatusInternalServerError)
 				return
 			}
+			recordAudit(r, auditVersionRestore, "topic", topicID, currentTopic, topic)
 
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(topic)
 		}).ServeHTTP(w, r)
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
\ No newline at end of file
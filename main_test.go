@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useMemoryStore points the app at a fresh in-memory store with rate limits off, as with
+// STORAGE=memory, and signs session cookies with a test key.
+func useMemoryStore(t *testing.T) {
+	t.Helper()
+	store, policies, keys, admin := dataStore, rateLimitPolicies, sessionKeys, googleAdminID
+	t.Cleanup(func() {
+		dataStore, rateLimitPolicies, sessionKeys, googleAdminID = store, policies, keys, admin
+		memoryDuels = make(map[string]*Duel)
+		memoryChallenges = make(map[string]*Challenge)
+		memoryAuditLog = nil
+		answerChecks = make(map[string]time.Time)
+		memoryStatsEvents = nil
+		memoryUserQuotas = make(map[string]*UserQuota)
+	})
+	dataStore = newMemoryStore()
+	rateLimitPolicies = map[string]*RateLimitPolicy{}
+	sessionKeys = [][]byte{[]byte("test session key")}
+	googleAdminID = ""
+}
+
+// createTestUser adds a user with the given Google ID to the store.
+func createTestUser(t *testing.T, googleID string) *User {
+	t.Helper()
+	user, err := dataStore.CreateUser(googleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// serve runs a request through a handler, signed in as the user unless it is nil.
+func serve(h http.HandlerFunc, user *User, method, target, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if user != nil {
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: signSessionValue(user.ID, sessionKeys[0])})
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestHandleTopics(t *testing.T) {
+	useMemoryStore(t)
+	admin := createTestUser(t, "admin-google-id")
+	googleAdminID = admin.GoogleID
+	learner := createTestUser(t, "learner-google-id")
+
+	body := `{"name": "Weil", "prompt": "Sentences with weil"}`
+	if rec := serve(handleTopics, learner, http.MethodPost, "/api/topics", body); rec.Code != http.StatusForbidden {
+		t.Errorf("learner: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := serve(handleTopics, admin, http.MethodPost, "/api/topics", `{"name": "Weil"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("no prompt: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec := serve(handleTopics, admin, http.MethodPost, "/api/topics", body)
+	var topic Topic
+	if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &topic) != nil || topic.ID == "" {
+		t.Fatalf("create: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	// The topic is kept in the memory store and listed for anyone
+	if stored, err := dataStore.GetTopic(topic.ID); err != nil || stored.Prompt != "Sentences with weil" {
+		t.Fatalf("stored topic %+v, error %v", stored, err)
+	}
+	rec = serve(handleTopics, nil, http.MethodGet, "/api/topics", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), topic.ID) {
+		t.Errorf("list: status %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestHandleTopicByIDDelete(t *testing.T) {
+	useMemoryStore(t)
+	admin := createTestUser(t, "admin-google-id")
+	googleAdminID = admin.GoogleID
+	learner := createTestUser(t, "learner-google-id")
+	topic, err := dataStore.InsertTopic("Weil", "Sentences with weil", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := "/api/topics/" + topic.ID
+
+	if rec := serve(handleTopicByID, learner, http.MethodDelete, target, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("learner: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := serve(handleTopicByID, admin, http.MethodDelete, target+"?permanent=true", ""); rec.Code != http.StatusConflict {
+		t.Errorf("permanent delete of an active topic: status %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	if rec := serve(handleTopicByID, admin, http.MethodDelete, target, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("archive: status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if archived, err := dataStore.GetTopic(topic.ID); err != nil || !archived.Archived {
+		t.Fatalf("after archiving: topic %+v, error %v", archived, err)
+	}
+
+	if rec := serve(handleTopicByID, admin, http.MethodDelete, target+"?permanent=true", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("permanent delete: status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if _, err := dataStore.GetTopic(topic.ID); err == nil {
+		t.Error("topic still exists after a permanent delete")
+	}
+	if n := len(memoryAuditLog); n != 2 || memoryAuditLog[1].Action != auditTopicDelete || memoryAuditLog[1].Before == nil {
+		t.Errorf("audit log has %d entries, want the archive and a delete with a snapshot", n)
+	}
+}
+
+func TestHandleUserStatsPost(t *testing.T) {
+	useMemoryStore(t)
+	user := createTestUser(t, "learner-google-id")
+	set := `{"total_exercises": 1000000, "total_mistakes": 1, "total_hints": 0, "total_time": 9223372036854775807}`
+
+	if rec := serve(handleUserStats, user, http.MethodPost, "/api/user/stats", set); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("nothing answered: status %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	view := &UserExerciseView{UserID: user.ID, ExerciseID: "recA", RepetitionCounter: 1, LastViewed: time.Now()}
+	if err := dataStore.UpdateUserExerciseViews([]*UserExerciseView{view}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(handleUserStats, user, http.MethodPost, "/api/user/stats", set); rec.Code != http.StatusOK {
+		t.Fatalf("set: status %d, body %s", rec.Code, rec.Body)
+	}
+	stats, _, err := getStatsTotals(user.ID)
+	if err != nil || stats.TotalExercises != 1 || stats.TotalTime > int(currentSessionTTL.Seconds()) {
+		t.Errorf("totals %+v, error %v; want the set capped", stats, err)
+	}
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mehanizm/airtable"
+)
+
+const maxListingDescriptionLen = 500
+
+// When set, browsing and cloning use the marketplace hosted by another deployment
+// instead of this one.
+var marketplaceURL string
+
+var marketplaceClient = &http.Client{Timeout: 10 * time.Second}
+
+// Listing is a topic published to the marketplace.
+type Listing struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Prompt      string    `json:"prompt,omitempty"`
+	Description string    `json:"description"`
+	Level       string    `json:"level,omitempty"`
+	Tags        []string  `json:"tags"`
+	AuthorID    string    `json:"-"`
+	AuthorName  string    `json:"author_name"`
+	PublishedAt time.Time `json:"published_at"`
+	Downloads   int       `json:"downloads"`
+	RatingSum   int       `json:"-"`
+	RatingCount int       `json:"rating_count"`
+	Rating      float64   `json:"rating"`
+}
+
+type PublishRequest struct {
+	TopicID     string   `json:"topic_id"`
+	Description string   `json:"description"`
+	Level       string   `json:"level"`
+	Tags        []string `json:"tags"`
+}
+
+func initMarketplace() {
+	marketplaceURL = appConfig.MarketplaceURL
+	if marketplaceURL != "" {
+		log.Printf("Using remote topic marketplace at %s", marketplaceURL)
+	}
+}
+
+func isAdminUser(user *User) bool {
+	return googleAdminID != "" && user != nil && user.GoogleID == googleAdminID
+}
+
+// isAdminOrTeacher reports whether the user is the admin or teaches at least one class.
+func isAdminOrTeacher(user *User) bool {
+	if isAdminUser(user) {
+		return true
+	}
+	taught, err := findClasses(fmt.Sprintf("{TeacherID} = '%s'", user.ID))
+	return err == nil && len(taught) > 0
+}
+
+func listingFromRecord(record *airtable.Record) *Listing {
+	listing := &Listing{
+		ID:   record.ID,
+		Tags: []string{},
+	}
+	if val, ok := record.Fields["Name"].(string); ok {
+		listing.Name = val
+	}
+	if val, ok := record.Fields["Prompt"].(string); ok {
+		listing.Prompt = val
+	}
+	if val, ok := record.Fields["Description"].(string); ok {
+		listing.Description = val
+	}
+	if val, ok := record.Fields["Level"].(string); ok {
+		listing.Level = val
+	}
+	if val, ok := record.Fields["Tags"].(string); ok {
+		listing.Tags = splitIDs(val)
+	}
+	if val, ok := record.Fields["AuthorID"].(string); ok {
+		listing.AuthorID = val
+	}
+	if val, ok := record.Fields["AuthorName"].(string); ok {
+		listing.AuthorName = val
+	}
+	if val, ok := record.Fields["PublishedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			listing.PublishedAt = t
+		}
+	}
+	if val, ok := record.Fields["Downloads"].(float64); ok {
+		listing.Downloads = int(val)
+	}
+	if val, ok := record.Fields["RatingSum"].(float64); ok {
+		listing.RatingSum = int(val)
+	}
+	if val, ok := record.Fields["RatingCount"].(float64); ok {
+		listing.RatingCount = int(val)
+	}
+	if listing.RatingCount > 0 {
+		listing.Rating = float64(listing.RatingSum) / float64(listing.RatingCount)
+	}
+	return listing
+}
+
+func getLocalListings() ([]*Listing, error) {
+	table := airtableClient.GetTable(airtableBaseID, marketplaceListingsTableName)
+	records, err := getAllRecords(table.GetRecords())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get marketplace listings from Airtable: %v", err)
+	}
+
+	listings := []*Listing{}
+	for _, record := range records.Records {
+		listings = append(listings, listingFromRecord(record))
+	}
+	return listings, nil
+}
+
+func getLocalListing(id string) (*Listing, error) {
+	table := airtableClient.GetTable(airtableBaseID, marketplaceListingsTableName)
+	record, err := table.GetRecord(id)
+	if err != nil {
+		if strings.Contains(err.Error(), "NOT_FOUND") || strings.Contains(err.Error(), "status 404") {
+			return nil, nil // Not found
+		}
+		return nil, fmt.Errorf("failed to get marketplace listing from Airtable: %v", err)
+	}
+	return listingFromRecord(record), nil
+}
+
+func updateListingFields(id string, fields map[string]any) error {
+	table := airtableClient.GetTable(airtableBaseID, marketplaceListingsTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				ID:     id,
+				Fields: fields,
+			},
+		},
+	}
+	if _, err := table.UpdateRecordsPartial(records); err != nil {
+		return fmt.Errorf("failed to update marketplace listing: %v", err)
+	}
+	return nil
+}
+
+// searchListings filters listings by a free-text query and sorts them by
+// "popular" (downloads, the default), "rating" or "new".
+func searchListings(listings []*Listing, query, order string) []*Listing {
+	query = strings.ToLower(strings.TrimSpace(query))
+	result := []*Listing{}
+	for _, l := range listings {
+		if query != "" {
+			text := strings.ToLower(l.Name + " " + l.Description + " " + l.Level + " " + strings.Join(l.Tags, " "))
+			if !strings.Contains(text, query) {
+				continue
+			}
+		}
+		result = append(result, l)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		switch order {
+		case "rating":
+			if result[i].Rating != result[j].Rating {
+				return result[i].Rating > result[j].Rating
+			}
+			return result[i].RatingCount > result[j].RatingCount
+		case "new":
+			return result[i].PublishedAt.After(result[j].PublishedAt)
+		default:
+			return result[i].Downloads > result[j].Downloads
+		}
+	})
+	return result
+}
+
+func publishListing(topic *Topic, author *User, req PublishRequest) (*Listing, error) {
+	authorName := author.DisplayName
+	if authorName == "" {
+		authorName = anonymousDisplayName
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, marketplaceListingsTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				Fields: map[string]any{
+					"Name":        topic.Name,
+					"Prompt":      topic.Prompt,
+					"Description": req.Description,
+					"Level":       req.Level,
+					"Tags":        strings.Join(req.Tags, ","),
+					"AuthorID":    author.ID,
+					"AuthorName":  authorName,
+					"PublishedAt": time.Now().Format(time.RFC3339),
+					"Downloads":   0,
+				},
+			},
+		},
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish listing: %v", err)
+	}
+	if len(result.Records) == 0 {
+		return nil, fmt.Errorf("no records returned from Airtable")
+	}
+	return listingFromRecord(result.Records[0]), nil
+}
+
+// rateListing stores the user's 1-5 rating, replacing any earlier one, and updates the listing's totals.
+func rateListing(listing *Listing, userID string, rating int) (*Listing, error) {
+	table := airtableClient.GetTable(airtableBaseID, marketplaceRatingsTableName)
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(fmt.Sprintf("{ListingID} = '%s'", listing.ID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ratings from Airtable: %v", err)
+	}
+
+	sum, count := rating, 1
+	existingID := ""
+	for _, record := range records.Records {
+		if val, _ := record.Fields["UserID"].(string); val == userID {
+			existingID = record.ID
+			continue
+		}
+		if val, ok := record.Fields["Rating"].(float64); ok {
+			sum += int(val)
+			count++
+		}
+	}
+
+	fields := map[string]any{"ListingID": listing.ID, "UserID": userID, "Rating": rating}
+	if existingID != "" {
+		_, err = table.UpdateRecordsPartial(&airtable.Records{Records: []*airtable.Record{{ID: existingID, Fields: fields}}})
+	} else {
+		_, err = table.AddRecords(&airtable.Records{Records: []*airtable.Record{{Fields: fields}}})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save rating: %v", err)
+	}
+
+	if err := updateListingFields(listing.ID, map[string]any{"RatingSum": sum, "RatingCount": count}); err != nil {
+		return nil, err
+	}
+	listing.RatingSum, listing.RatingCount = sum, count
+	listing.Rating = float64(sum) / float64(count)
+	return listing, nil
+}
+
+// downloadLocalListing returns a listing with its prompt and counts the download.
+func downloadLocalListing(id string) (*Listing, error) {
+	listing, err := getLocalListing(id)
+	if err != nil || listing == nil {
+		return listing, err
+	}
+	listing.Downloads++
+	if err := updateListingFields(listing.ID, map[string]any{"Downloads": listing.Downloads}); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return listing, nil
+}
+
+// fetchRemoteMarketplace calls the remote marketplace and decodes its JSON response into out.
+// A 404 leaves out untouched.
+func fetchRemoteMarketplace(method, path string, out any) error {
+	req, err := http.NewRequest(method, marketplaceURL+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := marketplaceClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach marketplace: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("marketplace returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func browseListings(query, order string) ([]*Listing, error) {
+	if marketplaceURL != "" {
+		listings := []*Listing{}
+		params := url.Values{"q": {query}, "sort": {order}}
+		err := fetchRemoteMarketplace(http.MethodGet, "/api/marketplace?"+params.Encode(), &listings)
+		return listings, err
+	}
+
+	listings, err := getLocalListings()
+	if err != nil {
+		return nil, err
+	}
+	listings = searchListings(listings, query, order)
+	for _, l := range listings {
+		l.Prompt = "" // Included only when viewing a single listing
+	}
+	return listings, nil
+}
+
+func getListing(id string) (*Listing, error) {
+	if marketplaceURL != "" {
+		var listing *Listing
+		err := fetchRemoteMarketplace(http.MethodGet, "/api/marketplace/"+url.PathEscape(id), &listing)
+		return listing, err
+	}
+	return getLocalListing(id)
+}
+
+func downloadListing(id string) (*Listing, error) {
+	if marketplaceURL != "" {
+		var listing *Listing
+		err := fetchRemoteMarketplace(http.MethodPost, "/api/marketplace/"+url.PathEscape(id)+"/download", &listing)
+		return listing, err
+	}
+	return downloadLocalListing(id)
+}
+
+// cloneListing creates a topic of the request's tenant from a listing. If a topic with the
+// same name already exists, the copy gets a numbered name.
+func cloneListing(ctx context.Context, listing *Listing) (*Topic, error) {
+	topics, err := tenantTopics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool)
+	for _, t := range topics {
+		existing[strings.ToLower(t.Name)] = true
+	}
+
+	name := listing.Name
+	for n := 2; existing[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s (%d)", listing.Name, n)
+	}
+	return createTopic(name, listing.Prompt, tenantIDFromContext(ctx))
+}
+
+// Handle the topic marketplace:
+//
+//	GET    /api/marketplace?q=&sort=popular|rating|new - browse listings (public)
+//	POST   /api/marketplace                            - publish a topic (admin or teacher)
+//	GET    /api/marketplace/{id}                       - listing with prompt (public)
+//	DELETE /api/marketplace/{id}                       - unpublish (author or admin)
+//	POST   /api/marketplace/{id}/rate                  - rate 1-5 { "rating" } (logged in)
+//	POST   /api/marketplace/{id}/download              - fetch a listing for cloning and count the download (public)
+//	POST   /api/marketplace/{id}/clone                 - create a local topic from a listing (admin)
+//
+// Browse, view and clone use MARKETPLACE_URL when it is set; the other endpoints
+// always act on this deployment's own marketplace.
+func handleMarketplace(w http.ResponseWriter, r *http.Request) {
+	// Public endpoints are readable by other deployments and browsers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/marketplace"), "/")
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		listings, err := browseListings(r.URL.Query().Get("q"), r.URL.Query().Get("sort"))
+		if err != nil {
+			log.Printf("Error browsing marketplace: %v", err)
+			writeError(w, "Failed to get marketplace listings", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listings)
+
+	case len(parts) == 0 && r.Method == http.MethodPost:
+		handlePublishListing(w, r)
+
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		listing, err := getListing(parts[0])
+		if err != nil {
+			writeError(w, "Failed to get listing", http.StatusBadGateway)
+			return
+		}
+		if listing == nil {
+			writeError(w, "Listing not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listing)
+
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		user := requireUser(w, r)
+		if user == nil {
+			return
+		}
+		listing, err := getLocalListing(parts[0])
+		if err != nil {
+			writeError(w, "Failed to get listing", http.StatusInternalServerError)
+			return
+		}
+		if listing == nil {
+			writeError(w, "Listing not found", http.StatusNotFound)
+			return
+		}
+		if listing.AuthorID != user.ID && !isAdminUser(user) {
+			writeError(w, "Only the author or an admin can unpublish this topic", http.StatusForbidden)
+			return
+		}
+		table := airtableClient.GetTable(airtableBaseID, marketplaceListingsTableName)
+		if _, err := table.DeleteRecords([]string{listing.ID}); err != nil {
+			writeError(w, "Failed to unpublish listing", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(parts) == 2 && parts[1] == "rate" && r.Method == http.MethodPost:
+		user := requireUser(w, r)
+		if user == nil {
+			return
+		}
+		var req struct {
+			Rating int `json:"rating"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Rating < 1 || req.Rating > 5 {
+			writeError(w, "rating must be between 1 and 5", http.StatusBadRequest)
+			return
+		}
+		listing, err := getLocalListing(parts[0])
+		if err != nil {
+			writeError(w, "Failed to get listing", http.StatusInternalServerError)
+			return
+		}
+		if listing == nil {
+			writeError(w, "Listing not found", http.StatusNotFound)
+			return
+		}
+		listing, err = rateListing(listing, user.ID, req.Rating)
+		if err != nil {
+			writeError(w, "Failed to save rating", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listing)
+
+	case len(parts) == 2 && parts[1] == "download" && r.Method == http.MethodPost:
+		listing, err := downloadLocalListing(parts[0])
+		if err != nil {
+			writeError(w, "Failed to get listing", http.StatusInternalServerError)
+			return
+		}
+		if listing == nil {
+			writeError(w, "Listing not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listing)
+
+	case len(parts) == 2 && parts[1] == "clone" && r.Method == http.MethodPost:
+		tenantAdminOnly(func(w http.ResponseWriter, r *http.Request) {
+			listing, err := downloadListing(parts[0])
+			if err != nil {
+				log.Printf("Error downloading marketplace listing: %v", err)
+				writeError(w, "Failed to get listing", http.StatusBadGateway)
+				return
+			}
+			if listing == nil || listing.Prompt == "" {
+				writeError(w, "Listing not found", http.StatusNotFound)
+				return
+			}
+			topic, err := cloneListing(r.Context(), listing)
+			if err != nil {
+				writeError(w, "Failed to create topic", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(topic)
+		})(w, r)
+
+	default:
+		writeError(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// requireUser returns the logged-in user, or writes an error response and returns nil.
+func requireUser(w http.ResponseWriter, r *http.Request) *User {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+	user, err := dataStore.GetUserByID(userID)
+	if err != nil || user == nil {
+		writeError(w, "Could not verify user credentials", http.StatusInternalServerError)
+		return nil
+	}
+	return user
+}
+
+func handlePublishListing(w http.ResponseWriter, r *http.Request) {
+	user := requireUser(w, r)
+	if user == nil {
+		return
+	}
+	if !isAdminOrTeacher(user) {
+		writeError(w, "Only admins and teachers can publish topics", http.StatusForbidden)
+		return
+	}
+
+	var req PublishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Description = strings.TrimSpace(req.Description)
+	if utf8.RuneCountInString(req.Description) > maxListingDescriptionLen {
+		writeError(w, fmt.Sprintf("Description must be at most %d characters", maxListingDescriptionLen), http.StatusBadRequest)
+		return
+	}
+	var tags []string
+	for _, tag := range req.Tags {
+		if tag = strings.ToLower(strings.TrimSpace(strings.ReplaceAll(tag, ",", " "))); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	req.Tags = tags
+
+	topic, err := dataStore.GetTopic(req.TopicID)
+	if err != nil || !topicInTenant(r.Context(), topic) || topic.Archived {
+		writeError(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+
+	listing, err := publishListing(topic, user, req)
+	if err != nil {
+		writeError(w, "Failed to publish topic", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(listing)
+}
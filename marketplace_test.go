@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHandlePublishListingOtherTenant(t *testing.T) {
+	useMemoryStore(t)
+	admin := createTestUser(t, "admin-google-id")
+	googleAdminID = admin.GoogleID
+	topic, err := dataStore.InsertTopic("Weil", "Sentences with weil", "recOtherTenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The instance's admins can't publish a tenant's topic, or learn that it exists
+	body := `{"topic_id": "` + topic.ID + `", "level": "B1"}`
+	if rec := serve(handlePublishListing, admin, http.MethodPost, "/api/marketplace", body); rec.Code != http.StatusNotFound {
+		t.Errorf("other tenant's topic: status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := serve(handlePublishListing, admin, http.MethodPost, "/api/marketplace", `{"topic_id": "recMissing", "level": "B1"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown topic: status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Mastery scores how well a learner knows each grammar feature (see grammar_tags.go), from
+// their answers: the topic-level progress can't tell "weil" is mastered while "nachdem" still
+// fails. Each answered exercise scores its latest grade, scaled by how far its repetitions
+// have come towards masteredRepetitionThreshold and down for the hints it needed. A tag's
+// score is the average over the answered exercises with the tag, from 0 to 100.
+
+// Scores of the latest grade; exercises answered before grades existed count as good
+var gradeMasteryScores = map[string]float64{
+	gradeAgain: 0,
+	gradeHard:  0.5,
+	gradeGood:  0.85,
+	gradeEasy:  1,
+	"":         0.85,
+}
+
+const (
+	masteredScore        = 80 // from this score, with enough answers, a tag is mastered
+	weakScore            = 50 // below this score a tag is weak
+	minMasteredExercises = 3  // answered exercises a tag needs to count as mastered
+)
+
+const (
+	masteryLevelWeak     = "weak"
+	masteryLevelLearning = "learning"
+	masteryLevelMastered = "mastered"
+)
+
+// TagMastery is a learner's mastery of one grammar tag.
+type TagMastery struct {
+	Tag      string `json:"tag"`      // e.g. "conjunction:weil"
+	Kind     string `json:"kind"`     // conjunction, tense, mood or preposition
+	Value    string `json:"value"`    // e.g. "weil"
+	Answered int    `json:"answered"` // answered exercises with the tag
+	Failing  int    `json:"failing"`  // of those, last graded "again"
+	Mastered int    `json:"mastered"` // of those, mastered as in /api/user/progress
+	Score    int    `json:"score"`    // 0 to 100
+	Level    string `json:"level"`    // weak, learning or mastered
+}
+
+// exerciseMastery scores one answered exercise, from 0 to 1.
+func exerciseMastery(view *UserExerciseView) float64 {
+	score, ok := gradeMasteryScores[view.Grade]
+	if !ok {
+		score = gradeMasteryScores[gradeGood]
+	}
+	progress := math.Min(1, float64(view.RepetitionCounter)/masteredRepetitionThreshold)
+	return score * progress * hintIntervalFactor(view.HintCount)
+}
+
+// getMasteryReport returns the owner's mastery of each grammar tag of the exercises they
+// answered, weakest first. kind and topicID limit the report when not empty.
+func getMasteryReport(ownerID, kind, topicID string) ([]*TagMastery, error) {
+	exercises, err := dataStore.ListExercises(topicID)
+	if err != nil {
+		return nil, err
+	}
+	views, err := dataStore.GetUserExerciseViews(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	applyHintBoost(ownerID, views)
+
+	byTag := make(map[string]*TagMastery)
+	totals := make(map[string]float64)
+	for _, ex := range exercises {
+		view, ok := views[ex.AirtableID]
+		if !ok {
+			continue
+		}
+		score := exerciseMastery(view)
+		for _, tag := range ex.grammarTags() {
+			tagKind, value, _ := strings.Cut(tag, ":")
+			if kind != "" && tagKind != kind {
+				continue
+			}
+			m, ok := byTag[tag]
+			if !ok {
+				m = &TagMastery{Tag: tag, Kind: tagKind, Value: value}
+				byTag[tag] = m
+			}
+			m.Answered++
+			if view.Grade == gradeAgain {
+				m.Failing++
+			}
+			if view.RepetitionCounter >= masteredRepetitionThreshold && view.Grade != gradeAgain {
+				m.Mastered++
+			}
+			totals[tag] += score
+		}
+	}
+
+	report := []*TagMastery{}
+	for tag, m := range byTag {
+		m.Score = int(math.Round(100 * totals[tag] / float64(m.Answered)))
+		switch {
+		case m.Score >= masteredScore && m.Answered >= minMasteredExercises:
+			m.Level = masteryLevelMastered
+		case m.Score < weakScore:
+			m.Level = masteryLevelWeak
+		default:
+			m.Level = masteryLevelLearning
+		}
+		report = append(report, m)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Score != report[j].Score {
+			return report[i].Score < report[j].Score
+		}
+		if report[i].Answered != report[j].Answered {
+			return report[i].Answered > report[j].Answered
+		}
+		return report[i].Tag < report[j].Tag
+	})
+	return report, nil
+}
+
+// Handle the mastery report: GET /api/user/mastery returns the owner's mastery of each
+// grammar tag, weakest first. ?kind=conjunction limits it to one kind of tag and
+// ?topic_id= to one topic's exercises.
+func handleUserMastery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ownerID := getProgressOwnerID(w, r)
+
+	query := r.URL.Query()
+	kind := strings.ToLower(strings.TrimSpace(query.Get("kind")))
+	switch kind {
+	case "", tagConjunction, tagTense, tagMood, tagPreposition:
+	default:
+		writeError(w, "kind must be conjunction, tense, mood or preposition", http.StatusBadRequest)
+		return
+	}
+
+	tags, err := getMasteryReport(ownerID, kind, strings.TrimSpace(query.Get("topic_id")))
+	if err != nil {
+		log.Printf("Error getting mastery report: %v", err)
+		writeError(w, "Failed to get mastery report", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"tags": tags})
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore is a map-backed Store for local development without Airtable (STORAGE=memory).
+// It returns copies, so callers can modify results the way they would Airtable responses.
+type memoryStore struct {
+	mu        sync.Mutex
+	nextID    int
+	topics    map[string]*Topic
+	versions  map[string]*PromptVersion
+	exercises map[string]*Exercise
+	views     map[string]*UserExerciseView
+	viewsAt   map[string]time.Time // when each view was last written, by record ID
+	users     map[string]*User
+	stats     map[string]*UserStats // keyed by record ID
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		topics:    make(map[string]*Topic),
+		versions:  make(map[string]*PromptVersion),
+		exercises: make(map[string]*Exercise),
+		views:     make(map[string]*UserExerciseView),
+		viewsAt:   make(map[string]time.Time),
+		users:     make(map[string]*User),
+		stats:     make(map[string]*UserStats),
+	}
+}
+
+// newID returns a record ID in Airtable's "rec..." style. Callers must hold m.mu.
+func (m *memoryStore) newID() string {
+	m.nextID++
+	return fmt.Sprintf("rec%014d", m.nextID)
+}
+
+func (m *memoryStore) InsertTopic(name, prompt, tenantID string) (*Topic, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	topic := &Topic{ID: m.newID(), Name: name, Prompt: prompt, TenantID: tenantID, CreatedAt: now, UpdatedAt: now}
+	m.topics[topic.ID] = topic
+	c := *topic
+	return &c, nil
+}
+
+func (m *memoryStore) GetAllTopics() ([]*Topic, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var topics []*Topic
+	for _, t := range m.topics {
+		c := *t
+		topics = append(topics, &c)
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].CreatedAt.Before(topics[j].CreatedAt)
+	})
+	return topics, nil
+}
+
+func (m *memoryStore) GetTopic(topicID string) (*Topic, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	topic, ok := m.topics[topicID]
+	if !ok {
+		return nil, fmt.Errorf("topic %s not found", topicID)
+	}
+	c := *topic
+	return &c, nil
+}
+
+func (m *memoryStore) UpdateTopic(topicID, name, prompt string) (*Topic, error) {
+	current, err := m.GetTopic(topicID)
+	if err != nil {
+		return nil, err
+	}
+	versionName := name
+	if versionName == "" {
+		versionName = current.Name
+	}
+	m.AddPromptVersion(topicID, versionName, prompt)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var versions []*PromptVersion
+	for _, v := range m.versions {
+		if v.TopicID == topicID {
+			versions = append(versions, v)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	for _, v := range versionsToPrune(versions, promptVersionsToKeep) {
+		delete(m.versions, v.ID)
+	}
+
+	topic := m.topics[topicID]
+	if name != "" {
+		topic.Name = name
+	}
+	topic.Prompt = prompt
+	topic.UpdatedAt = time.Now()
+	c := *topic
+	return &c, nil
+}
+
+func (m *memoryStore) SetTopicArchived(topicID string, archived bool) (*Topic, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	topic, ok := m.topics[topicID]
+	if !ok {
+		return nil, fmt.Errorf("topic %s not found", topicID)
+	}
+	topic.Archived = archived
+	c := *topic
+	return &c, nil
+}
+
+func (m *memoryStore) SetTopicRefinement(topicID string, disabled bool, metaPrompt string) (*Topic, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	topic, ok := m.topics[topicID]
+	if !ok {
+		return nil, fmt.Errorf("topic %s not found", topicID)
+	}
+	topic.RefinementDisabled = disabled
+	topic.MetaPrompt = metaPrompt
+	c := *topic
+	return &c, nil
+}
+
+func (m *memoryStore) SetTopicVocabularyBand(topicID string, band int) (*Topic, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	topic, ok := m.topics[topicID]
+	if !ok {
+		return nil, fmt.Errorf("topic %s not found", topicID)
+	}
+	topic.VocabularyBand = band
+	c := *topic
+	return &c, nil
+}
+
+func (m *memoryStore) DeleteTopic(topicID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.topics[topicID]; !ok {
+		return fmt.Errorf("topic %s not found", topicID)
+	}
+	for id, v := range m.versions {
+		if v.TopicID == topicID {
+			delete(m.versions, id)
+		}
+	}
+	delete(m.topics, topicID)
+	return nil
+}
+
+func (m *memoryStore) GetVersions(topicID string) ([]*PromptVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var versions []*PromptVersion
+	for _, v := range m.versions {
+		if v.TopicID == topicID {
+			c := *v
+			versions = append(versions, &c)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions, nil
+}
+
+func (m *memoryStore) GetVersion(versionID string) (*PromptVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	version, ok := m.versions[versionID]
+	if !ok {
+		return nil, fmt.Errorf("version %s not found", versionID)
+	}
+	c := *version
+	return &c, nil
+}
+
+func (m *memoryStore) SetVersionPinned(versionID string, pinned bool) (*PromptVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	version, ok := m.versions[versionID]
+	if !ok {
+		return nil, fmt.Errorf("version %s not found", versionID)
+	}
+	version.Pinned = pinned
+	c := *version
+	return &c, nil
+}
+
+func (m *memoryStore) SetVersionLabel(versionID, label string) (*PromptVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	version, ok := m.versions[versionID]
+	if !ok {
+		return nil, fmt.Errorf("version %s not found", versionID)
+	}
+	version.Label = label
+	c := *version
+	return &c, nil
+}
+
+func (m *memoryStore) AddPromptVersion(topicID, name, prompt string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	next := 1
+	for _, v := range m.versions {
+		if v.TopicID == topicID && v.Version >= next {
+			next = v.Version + 1
+		}
+	}
+	version := &PromptVersion{ID: m.newID(), TopicID: topicID, Name: name, Prompt: prompt, Version: next, CreatedAt: time.Now()}
+	m.versions[version.ID] = version
+	return nil
+}
+
+func (m *memoryStore) CreateExercise(topicID, promptHash, theme, exerciseJSON string) (*Exercise, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.newID()
+	exercise := &Exercise{
+		ID:           id,
+		AirtableID:   id,
+		TopicID:      topicID,
+		PromptHash:   promptHash,
+		Theme:        theme,
+		ExerciseJSON: exerciseJSON,
+		CreatedAt:    time.Now(),
+	}
+	if content, err := parseExerciseContent(exerciseJSON); err == nil {
+		exercise.setContent(content)
+	}
+	m.exercises[id] = exercise
+	c := *exercise
+	return &c, nil
+}
+
+func (m *memoryStore) GetExercisesForTopic(topicID, promptHash string) ([]*Exercise, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var exercises []*Exercise
+	for _, e := range m.exercises {
+		if e.TopicID == topicID && e.PromptHash == promptHash {
+			c := *e
+			exercises = append(exercises, &c)
+		}
+	}
+	sort.Slice(exercises, func(i, j int) bool { return exercises[i].ID < exercises[j].ID })
+	return exercises, nil
+}
+
+func (m *memoryStore) GetUserExerciseViews(userID string) (map[string]*UserExerciseView, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	views := make(map[string]*UserExerciseView)
+	for _, v := range m.views {
+		if v.UserID == userID {
+			c := *v
+			views[v.ExerciseID] = &c
+		}
+	}
+	return views, nil
+}
+
+func (m *memoryStore) UpdateUserExerciseViews(views []*UserExerciseView) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, view := range views {
+		c := *view
+		if c.AirtableID == "" {
+			c.AirtableID = m.newID()
+			c.CreatedAt = time.Now()
+		}
+		m.views[c.AirtableID] = &c
+		m.viewsAt[c.AirtableID] = time.Now()
+	}
+	return nil
+}
+
+func (m *memoryStore) GetUserExerciseViewsChangedSince(userID string, since time.Time) ([]*UserExerciseView, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var views []*UserExerciseView
+	for id, v := range m.views {
+		if v.UserID == userID && m.viewsAt[id].After(since) {
+			c := *v
+			views = append(views, &c)
+		}
+	}
+	return views, nil
+}
+
+func (m *memoryStore) ListUserExerciseViews() ([]*UserExerciseView, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var views []*UserExerciseView
+	for _, v := range m.views {
+		c := *v
+		views = append(views, &c)
+	}
+	return views, nil
+}
+
+func (m *memoryStore) GetExercise(exerciseID string) (*Exercise, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.exercises[exerciseID]
+	if !ok {
+		return nil, fmt.Errorf("exercise %s not found", exerciseID)
+	}
+	c := *e
+	return &c, nil
+}
+
+func (m *memoryStore) ListExercises(topicID string) ([]*Exercise, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var exercises []*Exercise
+	for _, e := range m.exercises {
+		if topicID == "" || e.TopicID == topicID {
+			c := *e
+			exercises = append(exercises, &c)
+		}
+	}
+	sort.Slice(exercises, func(i, j int) bool { return exercises[i].ID < exercises[j].ID })
+	return exercises, nil
+}
+
+// ListExercisesChangedSince returns the exercises cached after since; exercises are not
+// edited in memory.
+func (m *memoryStore) ListExercisesChangedSince(since time.Time) ([]*Exercise, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var exercises []*Exercise
+	for _, e := range m.exercises {
+		if e.CreatedAt.After(since) {
+			c := *e
+			exercises = append(exercises, &c)
+		}
+	}
+	sort.Slice(exercises, func(i, j int) bool { return exercises[i].ID < exercises[j].ID })
+	return exercises, nil
+}
+
+func (m *memoryStore) DeleteExercises(exerciseIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range exerciseIDs {
+		delete(m.exercises, id)
+	}
+	return nil
+}
+
+func (m *memoryStore) DeleteUserExerciseViews(viewIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range viewIDs {
+		delete(m.views, id)
+		delete(m.viewsAt, id)
+	}
+	return nil
+}
+
+func (m *memoryStore) GetUserByID(userID string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, nil // Not found
+	}
+	c := *user
+	return &c, nil
+}
+
+func (m *memoryStore) GetUserByGoogleID(googleID string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.GoogleID == googleID {
+			c := *user
+			return &c, nil
+		}
+	}
+	return nil, nil // Not found
+}
+
+func (m *memoryStore) CreateUser(googleID string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.newID()
+	user := &User{ID: id, AirtableID: id, GoogleID: googleID}
+	m.users[id] = user
+	c := *user
+	return &c, nil
+}
+
+func (m *memoryStore) SetUserEmail(userID, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	user.Email = email
+	return nil
+}
+
+func (m *memoryStore) GetUserStats(userID string) (*UserStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stats := range m.stats {
+		if stats.UserID == userID {
+			c := *stats
+			return &c, nil
+		}
+	}
+	return &UserStats{UserID: userID}, nil // Return empty stats if not found
+}
+
+func (m *memoryStore) UpdateUserStats(stats *UserStats) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := *stats
+	if c.AirtableRecordID == "" {
+		c.AirtableRecordID = m.newID()
+	}
+	m.stats[c.AirtableRecordID] = &c
+	return nil
+}
+
+func (m *memoryStore) UpdateUserSetting(userID, lastTopicID string) error {
+	stats, err := m.GetUserStats(userID)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stats.AirtableRecordID == "" {
+		stats.AirtableRecordID = m.newID()
+	}
+	stats.LastTopicID = lastTopicID
+	m.stats[stats.AirtableRecordID] = stats
+	return nil
+}
+
+func (m *memoryStore) DeleteUserStats(recordID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.stats, recordID)
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTopics(t *testing.T) {
+	store := newMemoryStore()
+	topic, err := store.InsertTopic("Weil", "Sentences with weil", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Returned topics are copies, so callers can't change the stored one
+	topic.Name = "Changed"
+	if stored, err := store.GetTopic(topic.ID); err != nil || stored.Name != "Weil" {
+		t.Fatalf("GetTopic() = %+v, %v; want the stored name", stored, err)
+	}
+
+	if _, err := store.SetTopicArchived(topic.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := store.GetTopic(topic.ID); !stored.Archived {
+		t.Error("topic not archived")
+	}
+
+	if err := store.DeleteTopic(topic.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetTopic(topic.ID); err == nil {
+		t.Error("GetTopic() found a deleted topic")
+	}
+	if topics, _ := store.GetAllTopics(); len(topics) != 0 {
+		t.Errorf("GetAllTopics() = %d topics, want none", len(topics))
+	}
+}
+
+func TestMemoryStoreExercises(t *testing.T) {
+	store := newMemoryStore()
+	ex, err := store.CreateExercise("recTopic", "hash", "travel", `{"correct_german_sentence": "Ich bleibe, weil es regnet.", "english_hint": "I stay because it rains."}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Sentence != "Ich bleibe, weil es regnet." {
+		t.Errorf("typed fields not set: sentence %q", ex.Sentence)
+	}
+	if _, err := store.CreateExercise("recOther", "hash", "", `{}`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		list    func() ([]*Exercise, error)
+		wantIDs int
+	}{
+		{"all exercises", func() ([]*Exercise, error) { return store.ListExercises("") }, 2},
+		{"one topic", func() ([]*Exercise, error) { return store.ListExercises("recTopic") }, 1},
+		{"topic and prompt", func() ([]*Exercise, error) { return store.GetExercisesForTopic("recTopic", "hash") }, 1},
+		{"other prompt", func() ([]*Exercise, error) { return store.GetExercisesForTopic("recTopic", "other") }, 0},
+		{"changed since", func() ([]*Exercise, error) { return store.ListExercisesChangedSince(time.Now().Add(-time.Minute)) }, 2},
+		{"changed later", func() ([]*Exercise, error) { return store.ListExercisesChangedSince(time.Now().Add(time.Minute)) }, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exercises, err := tt.list()
+			if err != nil || len(exercises) != tt.wantIDs {
+				t.Errorf("got %d exercises, error %v; want %d", len(exercises), err, tt.wantIDs)
+			}
+		})
+	}
+
+	if got, err := store.GetExercise(ex.AirtableID); err != nil || got.ExerciseJSON != ex.ExerciseJSON {
+		t.Errorf("GetExercise() = %+v, %v", got, err)
+	}
+	if err := store.DeleteExercises([]string{ex.AirtableID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetExercise(ex.AirtableID); err == nil {
+		t.Error("GetExercise() found a deleted exercise")
+	}
+}
+
+func TestMemoryStoreUserExerciseViews(t *testing.T) {
+	store := newMemoryStore()
+	start := time.Now().Add(-time.Second)
+	views := []*UserExerciseView{
+		{UserID: "recUser", ExerciseID: "recA", RepetitionCounter: 1, LastViewed: time.Now()},
+		{UserID: "recUser", ExerciseID: "recB", RepetitionCounter: 2, LastViewed: time.Now()},
+		{UserID: "recOther", ExerciseID: "recA", RepetitionCounter: 1, LastViewed: time.Now()},
+	}
+	if err := store.UpdateUserExerciseViews(views); err != nil {
+		t.Fatal(err)
+	}
+
+	own, err := store.GetUserExerciseViews("recUser")
+	if err != nil || len(own) != 2 || own["recB"].RepetitionCounter != 2 {
+		t.Fatalf("GetUserExerciseViews() = %v, %v", own, err)
+	}
+	if own["recA"].AirtableID == "" || own["recA"].CreatedAt.IsZero() {
+		t.Error("new view got no ID or creation time")
+	}
+
+	// Updating a stored view replaces it instead of adding another
+	own["recA"].RepetitionCounter = 3
+	if err := store.UpdateUserExerciseViews([]*UserExerciseView{own["recA"]}); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ := store.ListUserExerciseViews(); len(all) != 3 {
+		t.Errorf("ListUserExerciseViews() = %d views, want 3", len(all))
+	}
+	if changed, _ := store.GetUserExerciseViewsChangedSince("recUser", start); len(changed) != 2 {
+		t.Errorf("GetUserExerciseViewsChangedSince() = %d views, want 2", len(changed))
+	}
+
+	if err := store.DeleteUserExerciseViews([]string{own["recA"].AirtableID}); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := store.GetUserExerciseViews("recUser"); len(left) != 1 || left["recA"] != nil {
+		t.Errorf("after deleting: %v", left)
+	}
+}
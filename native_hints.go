@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Learners can set a native language in their profile. Exercises served to them carry the
+// translation hint in that language as well as in English: "native_hint" and
+// "native_language". Hints are translated by the model the first time an exercise is served
+// in a language, all missing ones of a set in one request, and cached per exercise and
+// language. A failed translation only means the set is served with English hints.
+
+// Native languages learners can choose, by ISO 639-1 code
+var nativeLanguages = map[string]string{
+	"ar": "Arabic", "cs": "Czech", "el": "Greek", "es": "Spanish", "fa": "Persian", "fr": "French",
+	"hi": "Hindi", "hu": "Hungarian", "it": "Italian", "ja": "Japanese", "ko": "Korean", "nl": "Dutch",
+	"pl": "Polish", "pt": "Portuguese", "ro": "Romanian", "ru": "Russian", "sq": "Albanian", "sr": "Serbian",
+	"tr": "Turkish", "uk": "Ukrainian", "vi": "Vietnamese", "zh": "Chinese",
+}
+
+// hintTranslationPrompt starts every translation request; the mock LLM recognizes it by this.
+const hintTranslationPrompt = "Translate these hints for German learners from English into %s."
+
+const hintTranslationInstructions = ` Each hint is the meaning of a German sentence the learner has to build; translate it naturally, keeping its meaning. Reply with a JSON object {"hints": [{"id": "...", "hint": "..."}]}, one entry per input hint, with the same ids.
+
+%s`
+
+// HintTranslation is an exercise's translation hint in one language. EnglishHint is the hint
+// it was translated from: if an admin edits the exercise, the hint is translated again.
+type HintTranslation struct {
+	ID          string
+	ExerciseID  string
+	Language    string
+	EnglishHint string
+	Hint        string
+	CreatedAt   time.Time
+}
+
+var (
+	hintTranslationsMutex  sync.Mutex
+	memoryHintTranslations = make(map[string]*HintTranslation) // by exercise ID and language, with in-memory storage
+)
+
+func hintTranslationKey(exerciseID, language string) string {
+	return exerciseID + "|" + language
+}
+
+func hintTranslationFromRecord(record *airtable.Record) *HintTranslation {
+	translation := &HintTranslation{ID: record.ID}
+	if val, ok := record.Fields["ExerciseID"].(string); ok {
+		translation.ExerciseID = val
+	}
+	if val, ok := record.Fields["Language"].(string); ok {
+		translation.Language = val
+	}
+	if val, ok := record.Fields["EnglishHint"].(string); ok {
+		translation.EnglishHint = val
+	}
+	if val, ok := record.Fields["Hint"].(string); ok {
+		translation.Hint = val
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			translation.CreatedAt = t
+		}
+	}
+	return translation
+}
+
+// getHintTranslations returns the cached translations of the exercises into a language, by exercise ID.
+func getHintTranslations(exerciseIDs []string, language string) (map[string]*HintTranslation, error) {
+	translations := make(map[string]*HintTranslation)
+	if len(exerciseIDs) == 0 {
+		return translations, nil
+	}
+	if airtableBaseID == "" {
+		hintTranslationsMutex.Lock()
+		defer hintTranslationsMutex.Unlock()
+		for _, exerciseID := range exerciseIDs {
+			if translation, ok := memoryHintTranslations[hintTranslationKey(exerciseID, language)]; ok {
+				c := *translation
+				translations[exerciseID] = &c
+			}
+		}
+		return translations, nil
+	}
+
+	conditions := make([]string, len(exerciseIDs))
+	for i, exerciseID := range exerciseIDs {
+		conditions[i] = fmt.Sprintf("{ExerciseID} = '%s'", exerciseID)
+	}
+	formula := fmt.Sprintf("AND({Language} = '%s', OR(%s))", language, strings.Join(conditions, ", "))
+	table := airtableClient.GetTable(airtableBaseID, hintTranslationsTableName)
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(formula))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hint translations from Airtable: %v", err)
+	}
+	for _, record := range records.Records {
+		translation := hintTranslationFromRecord(record)
+		translations[translation.ExerciseID] = translation
+	}
+	return translations, nil
+}
+
+// saveHintTranslations stores new translations and updates the stale ones (those with an ID).
+func saveHintTranslations(translations []*HintTranslation) error {
+	if airtableBaseID == "" {
+		hintTranslationsMutex.Lock()
+		defer hintTranslationsMutex.Unlock()
+		for _, translation := range translations {
+			c := *translation
+			memoryHintTranslations[hintTranslationKey(translation.ExerciseID, translation.Language)] = &c
+		}
+		return nil
+	}
+
+	var added, updated []*airtable.Record
+	for _, translation := range translations {
+		record := &airtable.Record{
+			ID: translation.ID,
+			Fields: map[string]any{
+				"ExerciseID":  translation.ExerciseID,
+				"Language":    translation.Language,
+				"EnglishHint": translation.EnglishHint,
+				"Hint":        translation.Hint,
+				"CreatedAt":   translation.CreatedAt.Format(time.RFC3339),
+			},
+		}
+		if translation.ID != "" {
+			updated = append(updated, record)
+		} else {
+			added = append(added, record)
+		}
+	}
+	table := airtableClient.GetTable(airtableBaseID, hintTranslationsTableName)
+	for start := 0; start < len(added); start += 10 {
+		if _, err := table.AddRecords(&airtable.Records{Records: added[start:min(start+10, len(added))]}); err != nil {
+			return fmt.Errorf("failed to store hint translations in Airtable: %v", err)
+		}
+	}
+	for start := 0; start < len(updated); start += 10 {
+		if _, err := table.UpdateRecords(&airtable.Records{Records: updated[start:min(start+10, len(updated))]}); err != nil {
+			return fmt.Errorf("failed to update hint translations in Airtable: %v", err)
+		}
+	}
+	return nil
+}
+
+// translateHints asks the model to translate English hints, by exercise ID, into a language.
+// Hints missing from the reply are left out.
+func translateHints(ctx context.Context, hints map[string]string, language string) (translated map[string]string, err error) {
+	llm := llmProviderFor(ctx)
+	modelName := llm.Model
+	ctx, end := startSpan(ctx, "translate hints",
+		attribute.String("language", language), attribute.String("llm.model", modelName), attribute.Int("hint.count", len(hints)))
+	defer func() { end(err) }()
+
+	type hintEntry struct {
+		ID   string `json:"id"`
+		Hint string `json:"hint"`
+	}
+	input := struct {
+		Hints []hintEntry `json:"hints"`
+	}{}
+	for exerciseID, hint := range hints {
+		input.Hints = append(input.Hints, hintEntry{ID: exerciseID, Hint: hint})
+	}
+	inputJSON, _ := json.Marshal(input)
+	prompt := fmt.Sprintf(hintTranslationPrompt, nativeLanguages[language]) + fmt.Sprintf(hintTranslationInstructions, inputJSON)
+
+	reqBody, err := json.Marshal(OpenAIRequest{
+		Model:          modelName,
+		Messages:       []Message{{Role: "user", Content: prompt}},
+		ResponseFormat: &ResponseFormat{Type: "json_object"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create translation request body: %w", err)
+	}
+	apiReq, err := http.NewRequestWithContext(ctx, "POST", llm.URL+"/chat/completions", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API request for translation: %w", err)
+	}
+	apiReq.Header.Set("Content-Type", "application/json")
+	apiReq.Header.Set("Authorization", "Bearer "+llm.APIKey)
+
+	resp, err := llmHTTPClient.Do(apiReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call OpenAI API for translation: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API response for translation: %w", err)
+	}
+	var openaiResp OpenAIResponse
+	if err := json.Unmarshal(respBody, &openaiResp); err != nil {
+		return nil, fmt.Errorf("failed to parse API response for translation: %w", err)
+	}
+	if openaiResp.Error != nil {
+		return nil, fmt.Errorf("API error during translation: %s", openaiResp.Error.Message)
+	}
+	if len(openaiResp.Choices) == 0 || openaiResp.Choices[0].Message.Content == "" {
+		return nil, fmt.Errorf("received an empty response from the translation API")
+	}
+
+	var output struct {
+		Hints []hintEntry `json:"hints"`
+	}
+	if err := json.Unmarshal([]byte(openaiResp.Choices[0].Message.Content), &output); err != nil {
+		return nil, fmt.Errorf("failed to parse translated hints: %w", err)
+	}
+	translated = make(map[string]string)
+	for _, entry := range output.Hints {
+		if _, ok := hints[entry.ID]; ok && strings.TrimSpace(entry.Hint) != "" {
+			translated[entry.ID] = strings.TrimSpace(entry.Hint)
+		}
+	}
+	return translated, nil
+}
+
+// nativeHints returns the exercises' hints in the user's native language, by exercise ID,
+// translating and caching the ones not translated yet. Translating counts against the user's
+// quota. On errors, offline and over the quota, it returns what it has.
+func nativeHints(ctx context.Context, user *User, exercises []*Exercise) map[string]string {
+	language := user.NativeLanguage
+	ids := make([]string, len(exercises))
+	for i, ex := range exercises {
+		ids[i] = ex.AirtableID
+	}
+	cached, err := getHintTranslations(ids, language)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		cached = make(map[string]*HintTranslation)
+	}
+
+	hints := make(map[string]string)
+	missing := make(map[string]string)
+	for _, ex := range exercises {
+		if ex.TranslationHint == "" {
+			continue
+		}
+		if translation, ok := cached[ex.AirtableID]; ok && translation.EnglishHint == ex.TranslationHint {
+			hints[ex.AirtableID] = translation.Hint
+		} else {
+			missing[ex.AirtableID] = ex.TranslationHint
+		}
+	}
+	if len(missing) == 0 || offlineMode() {
+		return hints
+	}
+	if err := useModelQuota(ctx, user.ID); err != nil {
+		return hints
+	}
+
+	translated, err := translateHints(ctx, missing, language)
+	if err != nil {
+		log.Printf("Warning: failed to translate hints into %s: %v", language, err)
+		return hints
+	}
+	var translations []*HintTranslation
+	now := time.Now().UTC()
+	for exerciseID, hint := range translated {
+		hints[exerciseID] = hint
+		translation := &HintTranslation{ExerciseID: exerciseID, Language: language, EnglishHint: missing[exerciseID], Hint: hint, CreatedAt: now}
+		if stale, ok := cached[exerciseID]; ok {
+			translation.ID = stale.ID
+		}
+		translations = append(translations, translation)
+	}
+	if err := saveHintTranslations(translations); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return hints
+}
+
+// withNativeHint adds a native-language hint to a served exercise.
+func withNativeHint(raw json.RawMessage, hint, language string) json.RawMessage {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return raw
+	}
+	fields["native_hint"], _ = json.Marshal(hint)
+	fields["native_language"], _ = json.Marshal(language)
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return raw
+	}
+	return data
+}
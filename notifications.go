@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	_ "time/tzdata" // the container image has no zoneinfo
+
+	"github.com/mehanizm/airtable"
+)
+
+// NotificationSettings holds a user's notification preferences.
+type NotificationSettings struct {
+	AirtableID         string    `json:"-"`
+	UserID             string    `json:"user_id"`
+	WeeklyDigest       bool      `json:"weekly_digest"`
+	PushReminders      bool      `json:"push_reminders"`
+	ReminderHour       int       `json:"reminder_hour"`
+	ReminderDays       []string  `json:"reminder_days"`
+	Timezone           string    `json:"timezone"` // IANA name; reminder hour, days and quiet hours are local to it
+	QuietHoursStart    int       `json:"quiet_hours_start"`
+	QuietHoursEnd      int       `json:"quiet_hours_end"` // equal to the start for no quiet hours
+	UnsubscribeToken   string    `json:"-"`
+	LastDigestSentAt   time.Time `json:"-"`
+	LastReminderSentAt time.Time `json:"-"`
+}
+
+// Reminders and digests go out in the user's timezone, and never during their quiet hours:
+// a digest that falls due then waits until they end, and a reminder hour inside them is
+// rejected. Settings saved before quiet hours existed have none, so their reminders keep
+// firing; settings without a timezone use UTC.
+const (
+	defaultReminderHour    = 18
+	defaultQuietHoursStart = 22
+	defaultQuietHoursEnd   = 8
+)
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseReminderDays normalises a list of weekday names, rejecting unknown ones.
+func parseReminderDays(days []string) ([]string, error) {
+	selected := make(map[string]bool)
+	for _, day := range days {
+		day = strings.ToLower(strings.TrimSpace(day))
+		if len(day) > 3 {
+			day = day[:3]
+		}
+		if !contains(weekdayNames, day) {
+			return nil, fmt.Errorf("unknown weekday %q", day)
+		}
+		selected[day] = true
+	}
+
+	// Keep the days in calendar order
+	var result []string
+	for _, day := range weekdayNames {
+		if selected[day] {
+			result = append(result, day)
+		}
+	}
+	return result, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func newNotificationSettings(userID string) *NotificationSettings {
+	return &NotificationSettings{
+		UserID:          userID,
+		ReminderHour:    defaultReminderHour,
+		ReminderDays:    append([]string{}, weekdayNames...),
+		Timezone:        "UTC",
+		QuietHoursStart: defaultQuietHoursStart,
+		QuietHoursEnd:   defaultQuietHoursEnd,
+	}
+}
+
+// parseTimezone checks an IANA timezone name; empty means UTC.
+func parseTimezone(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "UTC", nil
+	}
+	if _, err := time.LoadLocation(name); err != nil || name == "Local" {
+		return "", fmt.Errorf("unknown timezone %q", name)
+	}
+	return name, nil
+}
+
+// localTime returns now in the user's timezone.
+func (s *NotificationSettings) localTime(now time.Time) time.Time {
+	location, err := time.LoadLocation(s.Timezone)
+	if err != nil || s.Timezone == "" {
+		location = time.UTC
+	}
+	return now.In(location)
+}
+
+// quietAt reports whether the local hour falls in the user's quiet hours.
+func (s *NotificationSettings) quietAt(hour int) bool {
+	start, end := s.QuietHoursStart, s.QuietHoursEnd
+	switch {
+	case start == end:
+		return false
+	case start < end:
+		return hour >= start && hour < end
+	default: // overnight, e.g. 22 to 8
+		return hour >= start || hour < end
+	}
+}
+
+// inQuietHours reports whether nothing may be sent to the user at now.
+func (s *NotificationSettings) inQuietHours(now time.Time) bool {
+	return s.quietAt(s.localTime(now).Hour())
+}
+
+func notificationSettingsFromRecord(record *airtable.Record) *NotificationSettings {
+	settings := newNotificationSettings("")
+	settings.AirtableID = record.ID
+	settings.QuietHoursStart, settings.QuietHoursEnd = 0, 0 // none unless stored
+	if val, ok := record.Fields["UserID"].(string); ok {
+		settings.UserID = val
+	}
+	if val, ok := record.Fields["WeeklyDigest"].(bool); ok {
+		settings.WeeklyDigest = val
+	}
+	if val, ok := record.Fields["PushReminders"].(bool); ok {
+		settings.PushReminders = val
+	}
+	if val, ok := record.Fields["ReminderHour"].(float64); ok {
+		settings.ReminderHour = int(val)
+	}
+	if val, ok := record.Fields["ReminderDays"].(string); ok {
+		if days, err := parseReminderDays(strings.Split(val, ",")); err == nil {
+			settings.ReminderDays = days
+		}
+	}
+	if val, ok := record.Fields["Timezone"].(string); ok {
+		if timezone, err := parseTimezone(val); err == nil {
+			settings.Timezone = timezone
+		}
+	}
+	if val, ok := record.Fields["QuietHoursStart"].(float64); ok {
+		settings.QuietHoursStart = int(val)
+	}
+	if val, ok := record.Fields["QuietHoursEnd"].(float64); ok {
+		settings.QuietHoursEnd = int(val)
+	}
+	if val, ok := record.Fields["LastReminderSentAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			settings.LastReminderSentAt = t
+		}
+	}
+	if val, ok := record.Fields["UnsubscribeToken"].(string); ok {
+		settings.UnsubscribeToken = val
+	}
+	if val, ok := record.Fields["LastDigestSentAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			settings.LastDigestSentAt = t
+		}
+	}
+	return settings
+}
+
+func findNotificationSettings(formula string) ([]*NotificationSettings, error) {
+	table := airtableClient.GetTable(airtableBaseID, notificationSettingsTableName)
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(formula))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notification settings from Airtable: %v", err)
+	}
+
+	var settings []*NotificationSettings
+	for _, record := range records.Records {
+		settings = append(settings, notificationSettingsFromRecord(record))
+	}
+	return settings, nil
+}
+
+// getNotificationSettings returns the user's preferences, or the defaults if none are stored.
+func getNotificationSettings(userID string) (*NotificationSettings, error) {
+	settings, err := findNotificationSettings(fmt.Sprintf("{UserID} = '%s'", userID))
+	if err != nil {
+		return nil, err
+	}
+	if len(settings) == 0 {
+		return newNotificationSettings(userID), nil
+	}
+	return settings[0], nil
+}
+
+func getNotificationSettingsByToken(token string) (*NotificationSettings, error) {
+	// Tokens are 16 bytes in hex (see newRandomToken), so anything else can't match and must not reach the formula
+	if b, err := hex.DecodeString(token); err != nil || len(b) != 16 {
+		return nil, nil
+	}
+	settings, err := findNotificationSettings(fmt.Sprintf("{UnsubscribeToken} = '%s'", token))
+	if err != nil {
+		return nil, err
+	}
+	if len(settings) == 0 {
+		return nil, nil // Not found
+	}
+	return settings[0], nil
+}
+
+func saveNotificationSettings(settings *NotificationSettings) error {
+	table := airtableClient.GetTable(airtableBaseID, notificationSettingsTableName)
+	if settings.UnsubscribeToken == "" {
+		settings.UnsubscribeToken = newRandomToken()
+	}
+
+	fields := map[string]any{
+		"UserID":           settings.UserID,
+		"WeeklyDigest":     settings.WeeklyDigest,
+		"PushReminders":    settings.PushReminders,
+		"ReminderHour":     settings.ReminderHour,
+		"ReminderDays":     strings.Join(settings.ReminderDays, ","),
+		"Timezone":         settings.Timezone,
+		"QuietHoursStart":  settings.QuietHoursStart,
+		"QuietHoursEnd":    settings.QuietHoursEnd,
+		"UnsubscribeToken": settings.UnsubscribeToken,
+	}
+	if !settings.LastDigestSentAt.IsZero() {
+		fields["LastDigestSentAt"] = settings.LastDigestSentAt.Format(time.RFC3339)
+	}
+	if !settings.LastReminderSentAt.IsZero() {
+		fields["LastReminderSentAt"] = settings.LastReminderSentAt.Format(time.RFC3339)
+	}
+
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				ID:     settings.AirtableID,
+				Fields: fields,
+			},
+		},
+	}
+
+	if settings.AirtableID != "" {
+		_, err := table.UpdateRecordsPartial(records)
+		return err
+	}
+
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return err
+	}
+	if len(result.Records) > 0 {
+		settings.AirtableID = result.Records[0].ID
+	}
+	return nil
+}
+
+// Handle notification preferences: GET returns them, PUT updates them
+func handleUserNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := getNotificationSettings(userID)
+	if err != nil {
+		writeError(w, "Failed to get notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			WeeklyDigest    *bool    `json:"weekly_digest"`
+			PushReminders   *bool    `json:"push_reminders"`
+			ReminderHour    *int     `json:"reminder_hour"`
+			ReminderDays    []string `json:"reminder_days"`
+			Timezone        *string  `json:"timezone"`
+			QuietHoursStart *int     `json:"quiet_hours_start"`
+			QuietHoursEnd   *int     `json:"quiet_hours_end"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.WeeklyDigest != nil {
+			settings.WeeklyDigest = *req.WeeklyDigest
+		}
+		if req.PushReminders != nil {
+			settings.PushReminders = *req.PushReminders
+		}
+		if req.ReminderHour != nil {
+			if *req.ReminderHour < 0 || *req.ReminderHour > 23 {
+				writeError(w, "reminder_hour must be between 0 and 23", http.StatusBadRequest)
+				return
+			}
+			settings.ReminderHour = *req.ReminderHour
+		}
+		if req.ReminderDays != nil {
+			days, err := parseReminderDays(req.ReminderDays)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			settings.ReminderDays = days
+		}
+		if req.Timezone != nil {
+			timezone, err := parseTimezone(*req.Timezone)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			settings.Timezone = timezone
+		}
+		for _, hour := range []*int{req.QuietHoursStart, req.QuietHoursEnd} {
+			if hour != nil && (*hour < 0 || *hour > 23) {
+				writeError(w, "quiet hours must be between 0 and 23", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.QuietHoursStart != nil {
+			settings.QuietHoursStart = *req.QuietHoursStart
+		}
+		if req.QuietHoursEnd != nil {
+			settings.QuietHoursEnd = *req.QuietHoursEnd
+		}
+		if settings.PushReminders && settings.quietAt(settings.ReminderHour) {
+			writeError(w, fmt.Sprintf("reminder_hour %d is within quiet hours (%d to %d)", settings.ReminderHour, settings.QuietHoursStart, settings.QuietHoursEnd), http.StatusBadRequest)
+			return
+		}
+		if err := saveNotificationSettings(settings); err != nil {
+			writeError(w, "Failed to update notification settings", http.StatusInternalServerError)
+			return
+		}
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// Handle unsubscribe links from notification emails: GET /api/notifications/unsubscribe?token=...
+func handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeError(w, "Token required", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := getNotificationSettingsByToken(token)
+	if err != nil {
+		writeError(w, "Failed to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+	if settings == nil {
+		writeError(w, "Invalid unsubscribe link", http.StatusNotFound)
+		return
+	}
+
+	settings.WeeklyDigest = false
+	if err := saveNotificationSettings(settings); err != nil {
+		writeError(w, "Failed to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("You have been unsubscribed from the weekly progress email."))
+}
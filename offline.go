@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// OFFLINE_MODE runs the app without any model API, for deployments with no internet egress,
+// such as a Raspberry Pi in a classroom. Exercises are only served from the cache: those
+// loaded from content packs (see content_packs.go), imported with topics, restored from a
+// backup, or generated before the instance went offline. Endpoints that exist to call the
+// model answer 503 with the code offline_mode; features that only enrich an answer (hint
+// translations, grammar explanations not cached yet) are left out instead. OPENAI_API_KEY
+// is not needed. GET /api/mode tells the web app which of these features are available.
+var errOfflineMode = errors.New("the model API is not available in offline mode")
+
+// AppMode is what GET /api/mode returns.
+type AppMode struct {
+	Offline            bool `json:"offline"`
+	ExerciseGeneration bool `json:"exercise_generation"` // new exercises are generated when the cache runs out
+	Explanations       bool `json:"explanations"`        // explanations can be generated; cached ones are always served
+	HintTranslations   bool `json:"hint_translations"`
+	TopicSuggestions   bool `json:"topic_suggestions"`
+	SpeechAnswers      bool `json:"speech_answers"` // answers can be spoken, see speech.go
+}
+
+// offlineMode reports whether the model API must not be called.
+func offlineMode() bool {
+	return appConfig.OfflineMode
+}
+
+// requireOnline answers 503 with the code offline_mode in offline mode.
+func requireOnline(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if offlineMode() {
+			writeOfflineError(w)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func writeOfflineError(w http.ResponseWriter) {
+	writeAPIError(w, http.StatusServiceUnavailable, APIError{
+		Code:    "offline_mode",
+		Message: "This server runs offline and only serves stored exercises",
+	})
+}
+
+// Handle the app mode: GET /api/mode
+func handleMode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	online := !offlineMode()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AppMode{
+		Offline:            !online,
+		ExerciseGeneration: online && featureEnabled(flagExerciseGeneration),
+		Explanations:       online,
+		HintTranslations:   online,
+		TopicSuggestions:   online,
+		SpeechAnswers:      online,
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// Page is the envelope returned by list endpoints. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      int    `json:"total"`
+}
+
+// ListParams are the paging and sorting parameters shared by list endpoints:
+// ?limit=50&cursor=...&sort=-created_at. offset may be used instead of cursor.
+type ListParams struct {
+	Limit  int
+	Offset int
+	Sort   string
+	Desc   bool
+}
+
+// Cursors are opaque to clients; they currently encode the offset of the next page.
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("o:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(data), "o:") {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	offset, err := strconv.Atoi(string(data[2:]))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	return offset, nil
+}
+
+// parseListParams reads limit, cursor or offset, and sort, which must be one of sortFields,
+// optionally prefixed with - for descending order.
+func parseListParams(r *http.Request, sortFields []string, defaultSort string) (ListParams, error) {
+	query := r.URL.Query()
+	params := ListParams{Limit: defaultPageLimit}
+
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return params, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		params.Limit = n
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		offset, err := decodeCursor(cursor)
+		if err != nil {
+			return params, err
+		}
+		params.Offset = offset
+	} else if value := query.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return params, fmt.Errorf("offset must be a non-negative number")
+		}
+		params.Offset = n
+	}
+
+	sortParam := query.Get("sort")
+	if sortParam == "" {
+		sortParam = defaultSort
+	}
+	params.Sort, params.Desc = strings.CutPrefix(sortParam, "-")
+	if !slices.Contains(sortFields, params.Sort) {
+		return params, fmt.Errorf("sort must be one of %s (prefix with - for descending)", strings.Join(sortFields, ", "))
+	}
+	return params, nil
+}
+
+// sortItems orders items by the requested field. Ties keep their original order.
+func sortItems[T any](items []T, params ListParams, less map[string]func(a, b T) bool) {
+	byField := less[params.Sort]
+	if byField == nil {
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if params.Desc {
+			return byField(items[j], items[i])
+		}
+		return byField(items[i], items[j])
+	})
+}
+
+// paginate returns the requested page of already filtered and sorted items.
+func paginate[T any](items []T, params ListParams) Page[T] {
+	page := Page[T]{Items: []T{}, Total: len(items)}
+	if params.Offset >= len(items) {
+		return page
+	}
+	end := min(params.Offset+params.Limit, len(items))
+	page.Items = items[params.Offset:end]
+	if end < len(items) {
+		page.NextCursor = encodeCursor(end)
+	}
+	return page
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mehanizm/airtable"
+)
+
+const maxDisplayNameLength = 40
+
+// ProfileRequest updates the user's public profile and study settings. Nil fields are left unchanged.
+type ProfileRequest struct {
+	DisplayName          *string `json:"display_name"`
+	LeaderboardOptIn     *bool   `json:"leaderboard_opt_in"`
+	LeaderboardAnonymous *bool   `json:"leaderboard_anonymous"`
+	DailyNewLimit        *int    `json:"daily_new_limit"`
+	DailyReviewLimit     *int    `json:"daily_review_limit"`
+	NativeLanguage       *string `json:"native_language"` // empty for English hints only
+	Distractors          *bool   `json:"distractors"`     // wrong words in the word bank
+	Difficulty           *string `json:"difficulty"`      // easy, normal or hard
+}
+
+func updateUserFields(userID string, fields map[string]any) (*User, error) {
+	table := airtableClient.GetTable(airtableBaseID, usersTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				ID:     userID,
+				Fields: fields,
+			},
+		},
+	}
+
+	result, err := table.UpdateRecordsPartial(records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user in Airtable: %v", err)
+	}
+	if len(result.Records) == 0 {
+		return nil, fmt.Errorf("no records returned from Airtable")
+	}
+	return userFromRecord(result.Records[0]), nil
+}
+
+func listUsers(formula string) ([]*User, error) {
+	table := airtableClient.GetTable(airtableBaseID, usersTableName)
+	query := table.GetRecords()
+	if formula != "" {
+		query = query.WithFilterFormula(formula)
+	}
+
+	records, err := getAllRecords(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users from Airtable: %v", err)
+	}
+
+	var users []*User
+	for _, record := range records.Records {
+		users = append(users, userFromRecord(record))
+	}
+	return users, nil
+}
+
+// Handle the user's profile: GET returns it, PUT updates display name, leaderboard privacy, daily limits,
+// native language, distractors and difficulty
+func handleUserProfile(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		user, err := dataStore.GetUserByID(userID)
+		if err != nil || user == nil {
+			writeError(w, "Failed to get profile", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(user)
+
+	case http.MethodPut:
+		var req ProfileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		fields := map[string]any{}
+		if req.DisplayName != nil {
+			name := strings.TrimSpace(*req.DisplayName)
+			if utf8.RuneCountInString(name) > maxDisplayNameLength {
+				writeError(w, fmt.Sprintf("Display name must be at most %d characters", maxDisplayNameLength), http.StatusBadRequest)
+				return
+			}
+			fields["DisplayName"] = name
+		}
+		if req.LeaderboardOptIn != nil {
+			fields["LeaderboardOptIn"] = *req.LeaderboardOptIn
+		}
+		if req.LeaderboardAnonymous != nil {
+			fields["LeaderboardAnonymous"] = *req.LeaderboardAnonymous
+		}
+		if req.Distractors != nil {
+			fields["Distractors"] = *req.Distractors
+		}
+		if req.Difficulty != nil {
+			difficulty, err := validateDifficulty(*req.Difficulty)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			fields["Difficulty"] = difficulty
+		}
+		for field, limit := range map[string]*int{"DailyNewLimit": req.DailyNewLimit, "DailyReviewLimit": req.DailyReviewLimit} {
+			if limit == nil {
+				continue
+			}
+			if *limit < 0 || *limit > maxDailyLimit {
+				writeError(w, fmt.Sprintf("Daily limits must be between 0 and %d", maxDailyLimit), http.StatusBadRequest)
+				return
+			}
+			fields[field] = *limit
+		}
+		if req.NativeLanguage != nil {
+			language := strings.ToLower(strings.TrimSpace(*req.NativeLanguage))
+			if _, ok := nativeLanguages[language]; !ok && language != "" {
+				writeError(w, fmt.Sprintf("Unsupported native language %q", *req.NativeLanguage), http.StatusBadRequest)
+				return
+			}
+			fields["NativeLanguage"] = language
+		}
+		if len(fields) == 0 {
+			writeError(w, "No profile fields to update", http.StatusBadRequest)
+			return
+		}
+
+		user, err := updateUserFields(userID, fields)
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to update profile: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(user)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
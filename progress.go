@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// An exercise counts as mastered once it has been reviewed this many times,
+// which puts its next review at least 25 days out, unless it was last graded "again".
+const masteredRepetitionThreshold = 5
+
+type TopicProgress struct {
+	TopicID   string `json:"topic_id"`
+	TopicName string `json:"topic_name"`
+	Total     int    `json:"total"`
+	Seen      int    `json:"seen"`
+	Due       int    `json:"due"`
+	New       int    `json:"new"`
+	Mastered  int    `json:"mastered"`
+}
+
+// getUserProgress summarises a user's SRS state for every active topic. Counts are over
+// the exercises cached for each topic's current prompt at the given level.
+func getUserProgress(ctx context.Context, userID, level string) ([]*TopicProgress, error) {
+	topics, err := getActiveTopics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	exercises, err := dataStore.ListExercises("")
+	if err != nil {
+		return nil, err
+	}
+	userViews, err := dataStore.GetUserExerciseViews(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := promptVarsFromRequest(GenerateRequest{Level: level})
+	byTopic := make(map[string]*TopicProgress)
+	currentHash := make(map[string]string)
+	progress := []*TopicProgress{}
+	for _, topic := range topics {
+		p := &TopicProgress{TopicID: topic.ID, TopicName: topic.Name}
+		byTopic[topic.ID] = p
+		currentHash[topic.ID] = getCacheHash(topic.Prompt, vars)
+		progress = append(progress, p)
+	}
+
+	now := time.Now()
+	for _, ex := range exercises {
+		p, ok := byTopic[ex.TopicID]
+		if !ok || ex.PromptHash != currentHash[ex.TopicID] {
+			continue
+		}
+
+		p.Total++
+		view, seen := userViews[ex.AirtableID]
+		if !seen {
+			p.New++
+			continue
+		}
+		p.Seen++
+		if isDueForReview(view, now) {
+			p.Due++
+		}
+		if view.RepetitionCounter >= masteredRepetitionThreshold && view.Grade != gradeAgain {
+			p.Mastered++
+		}
+	}
+
+	return progress, nil
+}
+
+func handleUserProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	progress, err := getUserProgress(r.Context(), userID, r.URL.Query().Get("level"))
+	if err != nil {
+		writeError(w, "Failed to get user progress", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]*TopicProgress{"topics": progress})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Admins can check a prompt before saving it. Linting looks for what generation relies on:
+// the JSON keys the exercises are parsed from, and the word "JSON", without which OpenAI
+// rejects the json_object response format. A dry run sends the rendered prompt once, with
+// the topic's refinement settings, and returns the exercises without caching them. Dry runs
+// count against generation quotas like any other generation.
+const (
+	issueError   = "error"   // generation would fail or produce nothing usable
+	issueWarning = "warning" // generation works, but probably not as intended
+
+	charsPerToken          = 4 // rough average for English prompts with OpenAI tokenizers
+	longPromptTokens       = 4000
+	promptPlaceholderNames = "level, count, vocab_theme, difficulty"
+)
+
+var (
+	promptPlaceholder  = regexp.MustCompile(`\{\{\s*([^{}]*?)\s*\}\}`)
+	knownPlaceholders  = map[string]bool{"level": true, "count": true, "vocab_theme": true, "difficulty": true}
+	requiredPromptKeys = []string{"exercises", "correct_german_sentence", "english_hint"}
+)
+
+// PromptValidateRequest is a prompt to check, with the template variables of a generation.
+// TopicID, if set, supplies the refinement settings for a dry run.
+type PromptValidateRequest struct {
+	GenerateRequest
+	Prompt string `json:"prompt"`
+	DryRun bool   `json:"dry_run"`
+}
+
+type PromptIssue struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// PromptSample is an exercise the dry run generated, with the reason it would not be cached.
+type PromptSample struct {
+	Exercise json.RawMessage `json:"exercise"`
+	Error    string          `json:"error,omitempty"`
+}
+
+type PromptDryRun struct {
+	Model         string          `json:"model,omitempty"`
+	RefinedPrompt string          `json:"refined_prompt,omitempty"`
+	Exercises     []*PromptSample `json:"exercises"`
+	Valid         int             `json:"valid"`
+	Invalid       int             `json:"invalid"`
+	DurationMS    int64           `json:"duration_ms"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// PromptValidation is what POST /api/topics/validate returns.
+type PromptValidation struct {
+	Valid           bool          `json:"valid"` // no errors
+	Issues          []PromptIssue `json:"issues"`
+	RenderedPrompt  string        `json:"rendered_prompt"`
+	EstimatedTokens int           `json:"estimated_tokens"`
+	DryRun          *PromptDryRun `json:"dry_run,omitempty"`
+}
+
+// estimateTokens approximates the number of tokens in a text.
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+// lintPrompt checks a topic prompt, and the prompt rendered from it, for problems.
+func lintPrompt(prompt, rendered string) []PromptIssue {
+	issues := []PromptIssue{}
+	add := func(severity, format string, args ...any) {
+		issues = append(issues, PromptIssue{Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if strings.TrimSpace(prompt) == "" {
+		add(issueError, "The prompt is empty")
+		return issues
+	}
+	for _, key := range requiredPromptKeys {
+		if !strings.Contains(prompt, key) {
+			add(issueError, "The prompt does not mention %q, which generated exercises are read from", key)
+		}
+	}
+	if !strings.Contains(strings.ToLower(rendered), "json") {
+		add(issueError, "The prompt must ask for JSON, or the model API rejects the request")
+	}
+	if !strings.Contains(prompt, "conjunction_topic") {
+		add(issueWarning, `The prompt does not mention "conjunction_topic", so exercises can't be searched by conjunction`)
+	}
+	for _, match := range promptPlaceholder.FindAllStringSubmatch(prompt, -1) {
+		if !knownPlaceholders[match[1]] {
+			add(issueWarning, "Unknown placeholder %s is sent as it is; known ones are %s", match[0], promptPlaceholderNames)
+		}
+	}
+	if tokens := estimateTokens(rendered); tokens > longPromptTokens {
+		add(issueWarning, "The rendered prompt is about %d tokens, which makes every generation slow and expensive", tokens)
+	}
+	return issues
+}
+
+// dryRunPrompt generates one set from the topic's prompt and checks each exercise the way
+// caching would, without storing anything.
+func dryRunPrompt(r *http.Request, topic *Topic, vars PromptVars) *PromptDryRun {
+	ctx := r.Context()
+	dryRun := &PromptDryRun{Exercises: []*PromptSample{}}
+	started := time.Now()
+	defer func() { dryRun.DurationMS = time.Since(started).Milliseconds() }()
+
+	llm := llmProviderFor(ctx)
+	renderedPrompt := withVocabularyBand(renderGenerationPrompt(topic.Prompt, vars), topic.VocabularyBand)
+	finalPrompt, refined := refineTopicPrompt(ctx, topic, renderedPrompt, llm.APIKey, llm.URL, llm.Model)
+	if refined {
+		dryRun.RefinedPrompt = finalPrompt
+	}
+
+	var exerciseData struct {
+		Exercises []json.RawMessage `json:"exercises"`
+	}
+	openaiReq := OpenAIRequest{
+		Messages:       []Message{{Role: "user", Content: finalPrompt}},
+		ResponseFormat: &ResponseFormat{Type: "json_object"},
+	}
+	_, _, provider, err := completeWithFallback(ctx, openaiReq, func(content string) error {
+		if err := json.Unmarshal([]byte(content), &exerciseData); err != nil {
+			return fmt.Errorf("failed to parse exercises from response: %w", err)
+		}
+		if len(exerciseData.Exercises) == 0 {
+			return fmt.Errorf("no exercises in response")
+		}
+		return nil
+	})
+	if err != nil {
+		dryRun.Error = err.Error()
+		return dryRun
+	}
+	dryRun.Model = provider.Model
+
+	seen := make(map[string]bool)
+	contents, errs := validateGeneratedExercises(ctx, topic, exerciseData.Exercises)
+	for i, exJSON := range exerciseData.Exercises {
+		sample := &PromptSample{Exercise: exJSON}
+		if content, err := contents[i], errs[i]; err != nil {
+			sample.Error = err.Error()
+		} else if seen[content.dedupKey()] {
+			sample.Error = "duplicate of an earlier exercise"
+		} else {
+			seen[content.dedupKey()] = true
+		}
+		if sample.Error != "" {
+			dryRun.Invalid++
+		} else {
+			dryRun.Valid++
+		}
+		dryRun.Exercises = append(dryRun.Exercises, sample)
+	}
+	return dryRun
+}
+
+// Handle prompt validation: POST /api/topics/validate { "prompt", "level", "theme", "count",
+// "difficulty", "topic_id", "dry_run" }
+func handleTopicsValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PromptValidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	vars := promptVarsFromRequest(req.GenerateRequest)
+	rendered := renderGenerationPrompt(req.Prompt, vars)
+	result := &PromptValidation{
+		Issues:          lintPrompt(req.Prompt, rendered),
+		RenderedPrompt:  rendered,
+		EstimatedTokens: estimateTokens(rendered),
+	}
+	result.Valid = true
+	for _, issue := range result.Issues {
+		if issue.Severity == issueError {
+			result.Valid = false
+		}
+	}
+
+	if req.DryRun && offlineMode() {
+		writeOfflineError(w)
+		return
+	}
+	if req.DryRun && result.Valid {
+		ctx := r.Context()
+		topic := &Topic{Name: "(dry run)", Prompt: req.Prompt}
+		if req.TopicID != "" {
+			saved, err := dataStore.GetTopic(req.TopicID)
+			if err != nil || !topicInTenant(ctx, saved) {
+				writeError(w, "Topic not found", http.StatusNotFound)
+				return
+			}
+			topic.ID, topic.RefinementDisabled, topic.MetaPrompt = saved.ID, saved.RefinementDisabled, saved.MetaPrompt
+			topic.VocabularyBand = saved.VocabularyBand
+		}
+		if userID := getUserIDFromRequest(r); userID != "" && !usesOwnAPIKey(ctx) {
+			if err := useUserQuota(userID, time.Now()); err != nil {
+				writeStatusError(w, err)
+				return
+			}
+		}
+		if err := useGenerationQuota(ctx); err != nil {
+			writeAPIError(w, http.StatusTooManyRequests, APIError{Code: "quota_exceeded", Message: err.Error()})
+			return
+		}
+		result.DryRun = dryRunPrompt(r, topic, vars)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
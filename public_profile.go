@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A user can share their progress at /u/{slug}: streak, level, badges and exercises
+// completed, without their name, email or anything else personal. The page is off until
+// the user turns it on. The slug is random, is kept while the page is off, so turning it
+// back on restores the old link, and can be replaced to invalidate links already shared.
+// Unknown slugs and disabled pages both answer 404, so a slug reveals nothing on its own.
+var publicSlugPattern = regexp.MustCompile(`^[a-z2-7]{16}$`)
+
+// PublicProfileSettings is what GET /api/user/public-profile returns.
+type PublicProfileSettings struct {
+	Enabled bool   `json:"enabled"`
+	Slug    string `json:"slug,omitempty"`
+	URL     string `json:"url,omitempty"`
+}
+
+// PublicBadge is an unlocked achievement as shown on a public profile.
+type PublicBadge struct {
+	Key         string    `json:"key"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	UnlockedAt  time.Time `json:"unlocked_at"`
+}
+
+// PublicProfile is a user's shared progress.
+type PublicProfile struct {
+	Slug               string         `json:"slug"`
+	Streak             int            `json:"streak"`
+	Level              int            `json:"level"`
+	XP                 int            `json:"xp"`
+	ExercisesCompleted int            `json:"exercises_completed"`
+	Badges             []*PublicBadge `json:"badges"`
+}
+
+// newPublicSlug returns a random slug of 16 lowercase base32 characters.
+func newPublicSlug() string {
+	b := make([]byte, 10)
+	rand.Read(b)
+	return strings.ToLower(base32.StdEncoding.EncodeToString(b))
+}
+
+func publicProfileURL(slug string) string {
+	return fmt.Sprintf("%s/u/%s", appBaseURL, slug)
+}
+
+func publicProfileSettings(user *User) PublicProfileSettings {
+	settings := PublicProfileSettings{Enabled: user.PublicProfile && user.PublicSlug != ""}
+	if user.PublicSlug != "" {
+		settings.Slug, settings.URL = user.PublicSlug, publicProfileURL(user.PublicSlug)
+	}
+	return settings
+}
+
+// getUserByPublicSlug looks up the owner of an enabled public profile.
+func getUserByPublicSlug(slug string) (*User, error) {
+	// Anything but a well-formed slug can't match and must not reach the formula
+	if !publicSlugPattern.MatchString(slug) {
+		return nil, nil
+	}
+	users, err := listUsers(fmt.Sprintf("AND({PublicSlug} = '%s', {PublicProfile})", slug))
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil // Not found, or not shared
+	}
+	return users[0], nil
+}
+
+// getPublicProfile collects the progress a user's public profile shows.
+func getPublicProfile(user *User, now time.Time) (*PublicProfile, error) {
+	sessions, err := getUserSessions(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	stats, xp, err := getStatsTotals(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	unlocks, err := getUserUnlocks(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &PublicProfile{
+		Slug:               user.PublicSlug,
+		Streak:             currentStreak(sessions, now),
+		Level:              xp.Level,
+		XP:                 xp.XP,
+		ExercisesCompleted: stats.TotalExercises,
+		Badges:             []*PublicBadge{},
+	}
+	for _, definition := range getAchievementDefinitions() {
+		if unlockedAt, ok := unlocks[definition.Key]; ok {
+			profile.Badges = append(profile.Badges, &PublicBadge{
+				Key:         definition.Key,
+				Name:        definition.Name,
+				Description: definition.Description,
+				UnlockedAt:  unlockedAt,
+			})
+		}
+	}
+	return profile, nil
+}
+
+// Handle the user's public profile: GET returns whether it is on and its link, PUT { "enabled" }
+// turns it on or off (creating a slug on first use), POST replaces the slug, invalidating the old link
+func handleUserPublicProfile(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := dataStore.GetUserByID(userID)
+	if err != nil || user == nil {
+		writeError(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+
+	fields := map[string]any{}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		fields["PublicProfile"] = *req.Enabled
+		if *req.Enabled && user.PublicSlug == "" {
+			fields["PublicSlug"] = newPublicSlug()
+		}
+	case http.MethodPost:
+		fields["PublicSlug"] = newPublicSlug()
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if len(fields) > 0 {
+		if user, err = updateUserFields(userID, fields); err != nil {
+			writeError(w, fmt.Sprintf("Failed to update public profile: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(publicProfileSettings(user))
+}
+
+// lookupPublicProfile answers 404 for unknown or disabled slugs, and writes any other error.
+func lookupPublicProfile(w http.ResponseWriter, r *http.Request, slug string) *PublicProfile {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+	user, err := getUserByPublicSlug(slug)
+	if err != nil {
+		writeError(w, "Failed to get profile", http.StatusInternalServerError)
+		return nil
+	}
+	if user == nil {
+		writeError(w, "Profile not found", http.StatusNotFound)
+		return nil
+	}
+	profile, err := getPublicProfile(user, time.Now())
+	if err != nil {
+		writeError(w, "Failed to get profile", http.StatusInternalServerError)
+		return nil
+	}
+	return profile
+}
+
+var publicProfilePage = template.Must(template.New("profile").Parse(`<!DOCTYPE html>
+<html lang="en"><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Level {{.Level}} German learner</title>
+<meta property="og:title" content="Level {{.Level}} German learner">
+<meta property="og:description" content="{{.Streak}}-day streak, {{.ExercisesCompleted}} exercises completed on German Conjunctions Trainer">
+</head>
+<body style="font-family: sans-serif; max-width: 32em; margin: 3em auto; padding: 0 1em; color: #4A4A4A;">
+<h1>Level {{.Level}} German learner</h1>
+<p><strong>{{.Streak}}</strong>-day streak &middot; <strong>{{.ExercisesCompleted}}</strong> exercises completed &middot; <strong>{{.XP}}</strong> XP</p>
+<h2>Badges</h2>
+{{if .Badges}}<ul>{{range .Badges}}
+<li><strong>{{.Name}}</strong> &ndash; {{.Description}}</li>{{end}}
+</ul>{{else}}<p>No badges yet.</p>{{end}}
+<p><a href="/">Practise German conjunctions too</a></p>
+</body></html>`))
+
+// Handle a public profile page: GET /u/{slug}
+func handlePublicProfilePage(w http.ResponseWriter, r *http.Request) {
+	profile := lookupPublicProfile(w, r, strings.TrimPrefix(r.URL.Path, "/u/"))
+	if profile == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	publicProfilePage.Execute(w, profile)
+}
+
+// Handle a public profile as JSON: GET /api/profiles/{slug}
+func handlePublicProfile(w http.ResponseWriter, r *http.Request) {
+	profile := lookupPublicProfile(w, r, strings.TrimPrefix(r.URL.Path, "/api/profiles/"))
+	if profile == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"github.com/mehanizm/airtable"
+)
+
+// Web Push (VAPID) configuration
+var (
+	vapidPublicKey  string
+	vapidPrivateKey string
+	vapidSubject    string
+)
+
+// PushSubscription is a browser push subscription registered by a user.
+type PushSubscription struct {
+	AirtableID string    `json:"-"`
+	UserID     string    `json:"-"`
+	Endpoint   string    `json:"endpoint"`
+	P256dh     string    `json:"p256dh"`
+	Auth       string    `json:"auth"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func initWebPush() {
+	vapidPublicKey = appConfig.VAPIDPublicKey
+	vapidPrivateKey = appConfig.VAPIDPrivateKey
+	vapidSubject = appConfig.VAPIDSubject
+
+	if vapidPublicKey == "" || vapidPrivateKey == "" {
+		log.Println("Warning: VAPID_PUBLIC_KEY or VAPID_PRIVATE_KEY not set. Push notifications will be disabled.")
+		vapidPublicKey = ""
+		return
+	}
+	log.Println("Web Push initialized.")
+}
+
+func webPushEnabled() bool {
+	return vapidPublicKey != ""
+}
+
+func pushSubscriptionFromRecord(record *airtable.Record) *PushSubscription {
+	sub := &PushSubscription{
+		AirtableID: record.ID,
+	}
+	if val, ok := record.Fields["UserID"].(string); ok {
+		sub.UserID = val
+	}
+	if val, ok := record.Fields["Endpoint"].(string); ok {
+		sub.Endpoint = val
+	}
+	if val, ok := record.Fields["P256dh"].(string); ok {
+		sub.P256dh = val
+	}
+	if val, ok := record.Fields["Auth"].(string); ok {
+		sub.Auth = readSecret(val, pushSubscriptionsTableName, "Auth", sub.Endpoint)
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			sub.CreatedAt = t
+		}
+	}
+	return sub
+}
+
+func getPushSubscriptions(userID string) ([]*PushSubscription, error) {
+	table := airtableClient.GetTable(airtableBaseID, pushSubscriptionsTableName)
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(fmt.Sprintf("{UserID} = '%s'", userID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get push subscriptions from Airtable: %v", err)
+	}
+
+	var subs []*PushSubscription
+	for _, record := range records.Records {
+		subs = append(subs, pushSubscriptionFromRecord(record))
+	}
+	return subs, nil
+}
+
+func addPushSubscription(sub *PushSubscription) error {
+	existing, err := getPushSubscriptions(sub.UserID)
+	if err != nil {
+		return err
+	}
+	for _, e := range existing {
+		if e.Endpoint == sub.Endpoint {
+			return nil // Already registered
+		}
+	}
+
+	auth, err := sealSecret(sub.Auth, pushSubscriptionsTableName, "Auth", sub.Endpoint)
+	if err != nil {
+		return err
+	}
+	table := airtableClient.GetTable(airtableBaseID, pushSubscriptionsTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				Fields: map[string]any{
+					"UserID":    sub.UserID,
+					"Endpoint":  sub.Endpoint,
+					"P256dh":    sub.P256dh,
+					"Auth":      auth,
+					"CreatedAt": sub.CreatedAt.Format(time.RFC3339),
+				},
+			},
+		},
+	}
+	if _, err := table.AddRecords(records); err != nil {
+		return fmt.Errorf("failed to save push subscription: %v", err)
+	}
+	return nil
+}
+
+func deletePushSubscriptions(ids []string) error {
+	table := airtableClient.GetTable(airtableBaseID, pushSubscriptionsTableName)
+	for start := 0; start < len(ids); start += 10 {
+		end := min(start+10, len(ids))
+		if _, err := table.DeleteRecords(ids[start:end]); err != nil {
+			return fmt.Errorf("failed to delete push subscriptions: %v", err)
+		}
+	}
+	return nil
+}
+
+// sendPush delivers a notification to all of a user's subscriptions, removing
+// subscriptions the push service reports as expired.
+func sendPush(userID, title, body string) (int, error) {
+	subs, err := getPushSubscriptions(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	payload, _ := json.Marshal(map[string]string{
+		"title": title,
+		"body":  body,
+		"url":   appBaseURL + "/",
+	})
+
+	sent := 0
+	var expired []string
+	for _, sub := range subs {
+		// Subscriptions stored before endpoints were validated are skipped, not sent to
+		if err := validateUserKeyURL(sub.Endpoint); err != nil {
+			log.Printf("Warning: skipping push subscription with an invalid endpoint: %v", err)
+			continue
+		}
+		resp, err := webpush.SendNotification(payload, &webpush.Subscription{
+			Endpoint: sub.Endpoint,
+			Keys:     webpush.Keys{P256dh: sub.P256dh, Auth: sub.Auth},
+		}, &webpush.Options{
+			Subscriber:      vapidSubject,
+			VAPIDPublicKey:  vapidPublicKey,
+			VAPIDPrivateKey: vapidPrivateKey,
+			TTL:             int((12 * time.Hour).Seconds()),
+		})
+		if err != nil {
+			log.Printf("Warning: failed to send push notification: %v", err)
+			continue
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+			expired = append(expired, sub.AirtableID)
+		case resp.StatusCode >= 400:
+			log.Printf("Warning: push service returned status %d", resp.StatusCode)
+		default:
+			sent++
+		}
+	}
+
+	if len(expired) > 0 {
+		if err := deletePushSubscriptions(expired); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	return sent, nil
+}
+
+// reminderDue reports whether a user's reminder schedule matches the given time in their
+// timezone, outside their quiet hours, and no reminder has been sent yet that local day.
+func reminderDue(settings *NotificationSettings, now time.Time) bool {
+	local := settings.localTime(now)
+	if !settings.PushReminders || local.Hour() != settings.ReminderHour || settings.quietAt(local.Hour()) {
+		return false
+	}
+	if !contains(settings.ReminderDays, weekdayNames[local.Weekday()]) {
+		return false
+	}
+	return settings.localTime(settings.LastReminderSentAt).Format(time.DateOnly) != local.Format(time.DateOnly)
+}
+
+// countDueReviews returns how many of the user's exercises are due for review.
+func countDueReviews(userID string, now time.Time) (int, error) {
+	views, err := dataStore.GetUserExerciseViews(userID)
+	if err != nil {
+		return 0, err
+	}
+	due := 0
+	for _, view := range views {
+		if isDueForReview(view, now) {
+			due++
+		}
+	}
+	return due, nil
+}
+
+// sendStudyReminders pushes a reminder to users whose schedule matches now and who have reviews due.
+func sendStudyReminders() {
+	subscribers, err := findNotificationSettings("{PushReminders}")
+	if err != nil {
+		log.Printf("Warning: failed to load reminder subscribers: %v", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	for _, settings := range subscribers {
+		if !reminderDue(settings, now) {
+			continue
+		}
+
+		due, err := countDueReviews(settings.UserID, now)
+		if err != nil {
+			log.Printf("Warning: failed to count due reviews for user %s: %v", settings.UserID, err)
+			continue
+		}
+		if due == 0 {
+			continue
+		}
+
+		body := fmt.Sprintf("You have %d exercises ready for review.", due)
+		if _, err := sendPush(settings.UserID, "Time to practise German", body); err != nil {
+			log.Printf("Warning: failed to send reminder to user %s: %v", settings.UserID, err)
+			continue
+		}
+
+		settings.LastReminderSentAt = now
+		if err := saveNotificationSettings(settings); err != nil {
+			log.Printf("Warning: failed to record reminder for user %s: %v", settings.UserID, err)
+		}
+	}
+}
+
+// startReminderScheduler checks every 15 minutes for users due a study reminder.
+func startReminderScheduler() {
+	if !webPushEnabled() {
+		return
+	}
+	go func() {
+		for {
+			if featureEnabled(flagStudyReminders) {
+				sendStudyReminders()
+			}
+			time.Sleep(15 * time.Minute)
+		}
+	}()
+}
+
+// Handle the VAPID public key needed by the browser to subscribe: GET /api/push/vapid-public-key
+func handleVAPIDPublicKey(w http.ResponseWriter, r *http.Request) {
+	if !webPushEnabled() {
+		writeError(w, "Push notifications are not configured", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"public_key": vapidPublicKey})
+}
+
+// Handle push subscriptions: POST registers a browser subscription, DELETE removes one
+func handlePushSubscriptions(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !webPushEnabled() {
+		writeError(w, "Push notifications are not configured", http.StatusNotFound)
+		return
+	}
+
+	// Body follows the browser's PushSubscription.toJSON() format
+	var req webpush.Subscription
+	if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Endpoint == "" {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		if req.Keys.P256dh == "" || req.Keys.Auth == "" {
+			writeError(w, "Subscription keys are required", http.StatusBadRequest)
+			return
+		}
+		// The server POSTs to the endpoint, so it must be a public push service
+		if err := validateUserKeyURL(req.Endpoint); err != nil {
+			writeError(w, "Invalid endpoint: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		sub := &PushSubscription{
+			UserID:    userID,
+			Endpoint:  req.Endpoint,
+			P256dh:    req.Keys.P256dh,
+			Auth:      req.Keys.Auth,
+			CreatedAt: time.Now(),
+		}
+		if err := addPushSubscription(sub); err != nil {
+			writeError(w, "Failed to save subscription", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodDelete:
+		subs, err := getPushSubscriptions(userID)
+		if err != nil {
+			writeError(w, "Failed to get subscriptions", http.StatusInternalServerError)
+			return
+		}
+		var ids []string
+		for _, sub := range subs {
+			if sub.Endpoint == req.Endpoint {
+				ids = append(ids, sub.AirtableID)
+			}
+		}
+		if err := deletePushSubscriptions(ids); err != nil {
+			writeError(w, "Failed to delete subscription", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
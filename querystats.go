@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxRecentSlowQueries = 50
+
+// Airtable has no indexes to tune, so instead every Airtable API call is timed. Calls are
+// grouped by method, table and filter formula (with literal values masked), and calls
+// slower than SLOW_QUERY_THRESHOLD are logged and kept for the admin report.
+var slowQueryThreshold time.Duration
+
+type QueryStats struct {
+	Query     string  `json:"query"`
+	Calls     int     `json:"calls"`
+	SlowCalls int     `json:"slow_calls"`
+	Errors    int     `json:"errors"`
+	TotalMs   float64 `json:"total_ms"`
+	AverageMs float64 `json:"average_ms"`
+	MaxMs     float64 `json:"max_ms"`
+	totalTime time.Duration
+	maxTime   time.Duration
+}
+
+type SlowQuery struct {
+	Query      string    `json:"query"`
+	At         time.Time `json:"at"`
+	DurationMs float64   `json:"duration_ms"`
+	Status     int       `json:"status"`
+}
+
+var (
+	queryStatsMutex   sync.Mutex
+	queryStats        = make(map[string]*QueryStats)
+	recentSlowQueries []SlowQuery
+	queryStatsSince   = time.Now()
+)
+
+// timingTransport records the duration of each request it sends.
+type timingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *timingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	recordQuery(describeQuery(req), time.Since(start), status, err != nil || status >= 400)
+	return resp, err
+}
+
+func initQueryStats() {
+	slowQueryThreshold = time.Duration(appConfig.SlowQueryThreshold)
+	airtableClient.SetCustomClient(&http.Client{Transport: &timingTransport{next: http.DefaultTransport}})
+}
+
+var formulaLiteral = regexp.MustCompile(`'(?:[^'\\]|\\.)*'|"(?:[^"\\]|\\.)*"|\b\d+(?:\.\d+)?\b`)
+
+// describeQuery names a request by method, table and masked filter formula,
+// e.g. "GET Exercises AND({TopicID} = ?, {PromptHash} = ?)".
+func describeQuery(req *http.Request) string {
+	// Paths look like /v0/{baseID}/{table}[/{recordID}]
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	table := "?"
+	if len(parts) >= 3 {
+		table, _ = url.PathUnescape(parts[2])
+	}
+	desc := req.Method + " " + table
+	if len(parts) >= 4 {
+		desc += " by ID"
+	}
+	if formula := req.URL.Query().Get("filterByFormula"); formula != "" {
+		desc += " " + formulaLiteral.ReplaceAllString(formula, "?")
+	}
+	return desc
+}
+
+func recordQuery(query string, duration time.Duration, status int, failed bool) {
+	queryStatsMutex.Lock()
+	defer queryStatsMutex.Unlock()
+
+	stats, ok := queryStats[query]
+	if !ok {
+		stats = &QueryStats{Query: query}
+		queryStats[query] = stats
+	}
+	stats.Calls++
+	stats.totalTime += duration
+	if duration > stats.maxTime {
+		stats.maxTime = duration
+	}
+	if failed {
+		stats.Errors++
+	}
+
+	if duration >= slowQueryThreshold {
+		stats.SlowCalls++
+		log.Printf("🐢 Slow Airtable call (%s): %s", duration.Round(time.Millisecond), query)
+		recentSlowQueries = append(recentSlowQueries, SlowQuery{
+			Query:      query,
+			At:         time.Now(),
+			DurationMs: float64(duration.Microseconds()) / 1000,
+			Status:     status,
+		})
+		if len(recentSlowQueries) > maxRecentSlowQueries {
+			recentSlowQueries = recentSlowQueries[1:]
+		}
+	}
+}
+
+// Handle the query report (admin): GET /api/admin/slow-queries, DELETE resets the counters
+func handleAdminSlowQueries(w http.ResponseWriter, r *http.Request) {
+	queryStatsMutex.Lock()
+	defer queryStatsMutex.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		queries := []*QueryStats{}
+		for _, stats := range queryStats {
+			s := *stats
+			s.TotalMs = float64(s.totalTime.Microseconds()) / 1000
+			s.AverageMs = s.TotalMs / float64(s.Calls)
+			s.MaxMs = float64(s.maxTime.Microseconds()) / 1000
+			queries = append(queries, &s)
+		}
+		// Most total time first: the best candidates for caching or narrower filters
+		sort.Slice(queries, func(i, j int) bool { return queries[i].TotalMs > queries[j].TotalMs })
+
+		recent := make([]SlowQuery, len(recentSlowQueries))
+		for i, q := range recentSlowQueries {
+			recent[len(recent)-1-i] = q // Newest first
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"since":        queryStatsSince,
+			"threshold_ms": slowQueryThreshold.Milliseconds(),
+			"queries":      queries,
+			"recent_slow":  recent,
+		})
+
+	case http.MethodDelete:
+		queryStats = make(map[string]*QueryStats)
+		recentSlowQueries = nil
+		queryStatsSince = time.Now()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitPolicy allows one request per Interval with bursts of up to Burst requests.
+type RateLimitPolicy struct {
+	Interval time.Duration
+	Burst    int
+}
+
+// Default policies per route group. Each can be overridden with RATE_LIMIT_<NAME>=<interval>:<burst>,
+// e.g. RATE_LIMIT_GENERATE=5s:1, or disabled with RATE_LIMIT_<NAME>=off.
+var rateLimitPolicies = map[string]*RateLimitPolicy{
+	"generate":     {Interval: 3 * time.Second, Burst: 1},
+	"exercises":    {Interval: 2 * time.Second, Burst: 3},
+	"import":       {Interval: 10 * time.Second, Burst: 1},
+	"progress":     {Interval: time.Second, Burst: 5},
+	"leaderboard":  {Interval: time.Second, Burst: 5},
+	"calendar":     {Interval: 10 * time.Second, Burst: 3},
+	"profile":      {Interval: time.Second, Burst: 5},
+	"magiclink":    {Interval: 30 * time.Second, Burst: 3},
+	"magic_email":  {Interval: time.Minute, Burst: 1},
+	"marketplace":  {Interval: time.Second, Burst: 5},
+	"answers":      {Interval: time.Second, Burst: 10},
+	"explain":      {Interval: 5 * time.Second, Burst: 3},
+	"suggest":      {Interval: time.Minute, Burst: 2},
+	"sync":         {Interval: 5 * time.Second, Burst: 3},
+	"delta_sync":   {Interval: time.Second, Burst: 10},
+	"images":       {Interval: 10 * time.Second, Burst: 5},
+	"speech":       {Interval: 3 * time.Second, Burst: 5},
+	"conversation": {Interval: 2 * time.Second, Burst: 5},
+	"correct":      {Interval: 5 * time.Second, Burst: 3},
+}
+
+func parseRateLimitPolicy(value string) (*RateLimitPolicy, error) {
+	if strings.EqualFold(value, "off") {
+		return nil, nil
+	}
+	interval, burst, ok := strings.Cut(value, ":")
+	if !ok {
+		burst = "1"
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid interval %q", interval)
+	}
+	b, err := strconv.Atoi(burst)
+	if err != nil || b < 1 {
+		return nil, fmt.Errorf("invalid burst %q", burst)
+	}
+	return &RateLimitPolicy{Interval: d, Burst: b}, nil
+}
+
+// rateLimitStore tracks request allowances. The in-memory store is process-local;
+// the Redis store shares limits across app instances.
+type rateLimitStore interface {
+	// Allow takes a request from the allowance for id. When rejected, it also
+	// returns how long until the next request is allowed.
+	Allow(id string, policy *RateLimitPolicy) (bool, time.Duration, error)
+}
+
+var rateLimiter rateLimitStore
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	clients map[string]*client
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	store := &memoryRateLimitStore{clients: make(map[string]*client)}
+
+	// Cleanup old clients every 10 minutes
+	go func() {
+		for {
+			time.Sleep(10 * time.Minute)
+			store.mu.Lock()
+			for id, c := range store.clients {
+				if time.Since(c.lastSeen) > 30*time.Minute {
+					delete(store.clients, id)
+				}
+			}
+			store.mu.Unlock()
+		}
+	}()
+	return store
+}
+
+func (s *memoryRateLimitStore) Allow(id string, policy *RateLimitPolicy) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, found := s.clients[id]
+	if !found {
+		c = &client{limiter: rate.NewLimiter(rate.Every(policy.Interval), policy.Burst)}
+		s.clients[id] = c
+	}
+	c.lastSeen = time.Now()
+
+	reservation := c.limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return false, delay, nil
+	}
+	return true, 0, nil
+}
+
+// gcraScript implements the generic cell rate algorithm, equivalent to a token bucket.
+// The key holds the theoretical arrival time (TAT) in microseconds of Redis server time,
+// so all app instances share one clock.
+var gcraScript = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+if tat < now then tat = now end
+local newTat = tat + interval
+local retryAfter = newTat - now - interval * burst
+if retryAfter > 0 then
+	return {0, retryAfter}
+end
+redis.call("SET", KEYS[1], newTat, "PX", math.ceil((newTat - now) / 1000))
+return {1, 0}
+`)
+
+type redisRateLimitStore struct {
+	client *redis.Client
+}
+
+func (s *redisRateLimitStore) Allow(id string, policy *RateLimitPolicy) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	result, err := gcraScript.Run(ctx, s.client, []string{"ratelimit:" + id},
+		policy.Interval.Microseconds(), policy.Burst).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Microsecond, nil
+}
+
+// initRateLimits selects the limiter store and applies the per-route overrides from the configuration.
+func initRateLimits() {
+	rateLimiter = newMemoryRateLimitStore()
+	if appConfig.RedisURL != "" {
+		opts, _ := redis.ParseURL(appConfig.RedisURL)
+		rateLimiter = &redisRateLimitStore{client: redis.NewClient(opts)}
+		log.Println("Using Redis for rate limiting.")
+	}
+
+	for name, value := range appConfig.RateLimits {
+		rateLimitPolicies[name], _ = parseRateLimitPolicy(value)
+	}
+	trustedProxies = nil
+	for _, value := range appConfig.TrustedProxies {
+		if prefix, err := parseTrustedProxy(value); err == nil {
+			trustedProxies = append(trustedProxies, prefix)
+		}
+	}
+}
+
+// trustedProxies are the reverse proxies whose X-Forwarded-For header is believed.
+var trustedProxies []netip.Prefix
+
+// parseTrustedProxy reads a TRUSTED_PROXIES entry: an IP address or a CIDR range.
+func parseTrustedProxy(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitKey identifies the caller: the user ID when logged in, the client IP otherwise.
+func rateLimitKey(r *http.Request) string {
+	if userID := getUserIDFromRequest(r); userID != "" {
+		return "user:" + userID
+	}
+	return "ip:" + getClientIP(r)
+}
+
+// allowRequest takes a request from the caller's allowance for the named policy.
+// If the store is unavailable the request is let through rather than failing the API.
+func allowRequest(name, key string, policy *RateLimitPolicy) (bool, time.Duration) {
+	ok, retryAfter, err := rateLimiter.Allow(name+"|"+key, policy)
+	if err != nil {
+		log.Printf("Warning: rate limiter unavailable: %v", err)
+		return true, 0
+	}
+	return ok, retryAfter
+}
+
+// rateLimited wraps a handler with the named rate limit policy.
+func rateLimited(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		policy := rateLimitPolicies[name]
+		if policy == nil {
+			h(w, r)
+			return
+		}
+
+		if ok, retryAfter := allowRequest(name, rateLimitKey(r), policy); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeError(w, "You are making requests too quickly. Please wait a few seconds and try again.", http.StatusTooManyRequests)
+			return
+		}
+		h(w, r)
+	}
+}
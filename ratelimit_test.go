@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseRateLimitPolicy(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    *RateLimitPolicy
+		wantErr bool
+	}{
+		{value: "5s:1", want: &RateLimitPolicy{Interval: 5 * time.Second, Burst: 1}},
+		{value: "500ms:10", want: &RateLimitPolicy{Interval: 500 * time.Millisecond, Burst: 10}},
+		{value: "1m", want: &RateLimitPolicy{Interval: time.Minute, Burst: 1}},
+		{value: "off"},
+		{value: "OFF"},
+		{value: "", wantErr: true},
+		{value: "5:1", wantErr: true},
+		{value: "0s:1", wantErr: true},
+		{value: "-1s:1", wantErr: true},
+		{value: "5s:0", wantErr: true},
+		{value: "5s:x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseRateLimitPolicy(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRateLimitPolicy(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("parseRateLimitPolicy(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// testRateLimitStore checks a store against the policy's token bucket: a burst of requests
+// is allowed at once, the next waits for one interval, and callers have separate allowances.
+func testRateLimitStore(t *testing.T, store rateLimitStore, prefix string) {
+	tests := []struct {
+		name   string
+		policy *RateLimitPolicy
+	}{
+		{"one at a time", &RateLimitPolicy{Interval: time.Hour, Burst: 1}},
+		{"burst of three", &RateLimitPolicy{Interval: time.Hour, Burst: 3}},
+		{"burst of ten", &RateLimitPolicy{Interval: 30 * time.Minute, Burst: 10}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := fmt.Sprintf("%s|test%d|user:a", prefix, i)
+			for n := range tt.policy.Burst {
+				if ok, _, err := store.Allow(id, tt.policy); err != nil || !ok {
+					t.Fatalf("request %d of the burst: allowed %v, error %v", n+1, ok, err)
+				}
+			}
+
+			ok, retryAfter, err := store.Allow(id, tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				t.Fatal("request after the burst was allowed")
+			}
+			if retryAfter <= tt.policy.Interval-time.Minute || retryAfter > tt.policy.Interval {
+				t.Errorf("retry after %v, want about %v", retryAfter, tt.policy.Interval)
+			}
+
+			other := fmt.Sprintf("%s|test%d|user:b", prefix, i)
+			if ok, _, err := store.Allow(other, tt.policy); err != nil || !ok {
+				t.Errorf("another caller: allowed %v, error %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	testRateLimitStore(t, newMemoryRateLimitStore(), "memory")
+}
+
+// The GCRA script runs in Redis, so this needs a server: set TEST_REDIS_URL to run it.
+func TestRedisRateLimitStore(t *testing.T) {
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL not set")
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redis.NewClient(opts)
+	defer client.Close()
+	testRateLimitStore(t, &redisRateLimitStore{client: client}, fmt.Sprintf("redis%d", time.Now().UnixNano()))
+}
+
+func TestGetClientIP(t *testing.T) {
+	defer func(proxies []netip.Prefix) { trustedProxies = proxies }(trustedProxies)
+	trustedProxies = nil
+	for _, value := range []string{"10.0.0.0/8", "192.0.2.1"} {
+		prefix, err := parseTrustedProxy(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trustedProxies = append(trustedProxies, prefix)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:5000", want: "203.0.113.7"},
+		{name: "untrusted sender's header is ignored", remoteAddr: "203.0.113.7:5000", forwardedFor: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "behind a trusted proxy", remoteAddr: "10.1.2.3:5000", forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "made-up hops left of the client", remoteAddr: "10.1.2.3:5000", forwardedFor: []string{"1.1.1.1, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chain of trusted proxies", remoteAddr: "10.1.2.3:5000", forwardedFor: []string{"198.51.100.1, 192.0.2.1, 10.9.9.9"}, want: "198.51.100.1"},
+		{name: "several headers", remoteAddr: "10.1.2.3:5000", forwardedFor: []string{"1.1.1.1", "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "only trusted hops", remoteAddr: "10.1.2.3:5000", forwardedFor: []string{"10.0.0.1"}, want: "10.1.2.3"},
+		{name: "trusted proxy without the header", remoteAddr: "192.0.2.1:5000", want: "192.0.2.1"},
+		{name: "IPv6", remoteAddr: "[2001:db8::1]:5000", forwardedFor: []string{"198.51.100.1"}, want: "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if got := getClientIP(req); got != tt.want {
+				t.Errorf("getClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxy(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "10.0.0.0/8", want: "10.0.0.0/8"},
+		{value: "10.1.2.3/8", want: "10.0.0.0/8"},
+		{value: "192.0.2.1", want: "192.0.2.1/32"},
+		{value: "::ffff:192.0.2.1", want: "192.0.2.1/32"},
+		{value: "2001:db8::/32", want: "2001:db8::/32"},
+		{value: "proxy.internal", wantErr: true},
+		{value: "10.0.0.0/40", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseTrustedProxy(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTrustedProxy(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("parseTrustedProxy(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
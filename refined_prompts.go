@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+const (
+	defaultRefinedPromptsPageSize = 20
+	maxRefinedPromptsPageSize     = 100
+)
+
+// RefinedPrompt is one prompt refinement, kept so quality regressions can be traced
+// back to the prompt that was actually sent to the model.
+type RefinedPrompt struct {
+	ID             string    `json:"id"`
+	TopicID        string    `json:"topic_id"`
+	Model          string    `json:"model"`
+	OriginalPrompt string    `json:"original_prompt"`
+	RefinedPrompt  string    `json:"refined_prompt"`
+	ExerciseCount  int       `json:"exercise_count"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func refinedPromptFromRecord(record *airtable.Record) *RefinedPrompt {
+	entry := &RefinedPrompt{ID: record.ID}
+	if val, ok := record.Fields["TopicID"].(string); ok {
+		entry.TopicID = val
+	}
+	if val, ok := record.Fields["Model"].(string); ok {
+		entry.Model = val
+	}
+	if val, ok := record.Fields["OriginalPrompt"].(string); ok {
+		entry.OriginalPrompt = val
+	}
+	if val, ok := record.Fields["RefinedPrompt"].(string); ok {
+		entry.RefinedPrompt = val
+	}
+	if val, ok := record.Fields["ExerciseCount"].(float64); ok {
+		entry.ExerciseCount = int(val)
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			entry.CreatedAt = t
+		}
+	}
+	return entry
+}
+
+// recordRefinedPrompt keeps the refinement as the last refined prompt and stores it in
+// the RefinedPrompts table in the background. Failures are only logged.
+func recordRefinedPrompt(entry RefinedPrompt) {
+	lastRefinedPromptMutex.Lock()
+	lastRefinedPrompt = entry.RefinedPrompt
+	lastRefinedPromptMutex.Unlock()
+
+	if airtableBaseID == "" {
+		return // In-memory storage
+	}
+	go func() {
+		table := airtableClient.GetTable(airtableBaseID, refinedPromptsTableName)
+		records := &airtable.Records{
+			Records: []*airtable.Record{
+				{
+					Fields: map[string]any{
+						"TopicID":        entry.TopicID,
+						"Model":          entry.Model,
+						"OriginalPrompt": entry.OriginalPrompt,
+						"RefinedPrompt":  entry.RefinedPrompt,
+						"ExerciseCount":  entry.ExerciseCount,
+						"CreatedAt":      time.Now().Format(time.RFC3339),
+					},
+				},
+			},
+		}
+		if _, err := table.AddRecords(records); err != nil {
+			log.Printf("Warning: failed to store refined prompt: %v", err)
+		}
+	}()
+}
+
+// listRefinedPrompts returns one page of refinements, newest first, and the offset of the next page.
+func listRefinedPrompts(topicID string, pageSize int, offset string) ([]*RefinedPrompt, string, error) {
+	table := airtableClient.GetTable(airtableBaseID, refinedPromptsTableName)
+	query := table.GetRecords().
+		PageSize(pageSize).
+		WithSort(struct {
+			FieldName string
+			Direction string
+		}{"CreatedAt", "desc"})
+	if topicID != "" {
+		query = query.WithFilterFormula(fmt.Sprintf("{TopicID} = '%s'", topicID))
+	}
+	if offset != "" {
+		query = query.WithOffset(offset)
+	}
+
+	records, err := query.Do()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get refined prompts from Airtable: %v", err)
+	}
+
+	entries := []*RefinedPrompt{}
+	for _, record := range records.Records {
+		entries = append(entries, refinedPromptFromRecord(record))
+	}
+	return entries, records.Offset, nil
+}
+
+// Handle refined prompt history (admin): GET /api/admin/refined-prompts?topic_id=&page_size=20&offset=
+// Pass the returned offset to fetch the next page; it is empty on the last page.
+func handleAdminRefinedPrompts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pageSize := defaultRefinedPromptsPageSize
+	if value := r.URL.Query().Get("page_size"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxRefinedPromptsPageSize {
+			writeError(w, fmt.Sprintf("page_size must be between 1 and %d", maxRefinedPromptsPageSize), http.StatusBadRequest)
+			return
+		}
+		pageSize = n
+	}
+
+	entries, offset, err := listRefinedPrompts(r.URL.Query().Get("topic_id"), pageSize, r.URL.Query().Get("offset"))
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to list refined prompts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"refined_prompts": entries,
+		"offset":          offset,
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultExerciseRetentionDays = 30
+
+// Levels whose cache hashes count as current. Exercises cached for other level strings
+// are treated as superseded.
+var retentionLevels = []string{"A1", "A2", "B1", "B2", "C1", "C2"}
+
+// Cached exercises are keyed by prompt hash, so editing a topic's prompt leaves the old
+// exercises behind. An exercise is expired when its hash no longer matches its topic's
+// current prompt (or the topic was deleted) and it is either older than the retention
+// period or not in any user's SRS rotation. Archived topics keep their exercises.
+var exerciseRetentionDays int // 0 disables the daily automatic cleanup
+
+type RetentionReport struct {
+	RetentionDays int            `json:"retention_days"`
+	Exercises     int            `json:"exercises"`
+	Expired       int            `json:"expired"`
+	ExpiredViews  int            `json:"expired_views"`
+	ByTopic       map[string]int `json:"by_topic"`
+	Deleted       bool           `json:"deleted"`
+	expiredIDs    []string
+	viewIDs       []string
+}
+
+func initExerciseRetention() {
+	exerciseRetentionDays = appConfig.ExerciseRetentionDays
+}
+
+// currentCacheHashes returns the prompt hashes each topic's cached exercises may have today.
+func currentCacheHashes(topics []*Topic) map[string]map[string]bool {
+	hashes := make(map[string]map[string]bool)
+	for _, topic := range topics {
+		current := make(map[string]bool)
+		for _, level := range retentionLevels {
+			current[getCacheHash(topic.Prompt, PromptVars{Level: level})] = true
+		}
+		hashes[topic.ID] = current
+	}
+	return hashes
+}
+
+// findExpiredExercises builds the retention report without deleting anything.
+func findExpiredExercises(retentionDays int, now time.Time) (*RetentionReport, error) {
+	topics, err := dataStore.GetAllTopics()
+	if err != nil {
+		return nil, err
+	}
+	exercises, err := dataStore.ListExercises("")
+	if err != nil {
+		return nil, err
+	}
+	table := airtableClient.GetTable(airtableBaseID, userExerciseViewsTableName)
+	views, err := getAllRecords(table.GetRecords().ReturnFields("ExerciseID"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exercise views from Airtable: %v", err)
+	}
+
+	viewsByExercise := make(map[string][]string)
+	for _, record := range views.Records {
+		if exerciseID, ok := record.Fields["ExerciseID"].(string); ok {
+			viewsByExercise[exerciseID] = append(viewsByExercise[exerciseID], record.ID)
+		}
+	}
+	topicNames := make(map[string]string)
+	for _, topic := range topics {
+		topicNames[topic.ID] = topic.Name
+	}
+
+	hashes := currentCacheHashes(topics)
+	cutoff := now.AddDate(0, 0, -retentionDays)
+	report := &RetentionReport{
+		RetentionDays: retentionDays,
+		Exercises:     len(exercises),
+		ByTopic:       make(map[string]int),
+	}
+	for _, ex := range exercises {
+		if current, ok := hashes[ex.TopicID]; ok && current[ex.PromptHash] {
+			continue
+		}
+		inRotation := len(viewsByExercise[ex.ID]) > 0
+		// Exercises without a creation time are only expired once nobody is reviewing them
+		old := !ex.CreatedAt.IsZero() && ex.CreatedAt.Before(cutoff)
+		if inRotation && !old {
+			continue
+		}
+
+		name := topicNames[ex.TopicID]
+		if name == "" {
+			name = "(deleted topic " + ex.TopicID + ")"
+		}
+		report.ByTopic[name]++
+		report.expiredIDs = append(report.expiredIDs, ex.ID)
+		report.viewIDs = append(report.viewIDs, viewsByExercise[ex.ID]...)
+	}
+	report.Expired = len(report.expiredIDs)
+	report.ExpiredViews = len(report.viewIDs)
+	return report, nil
+}
+
+// expireExercises deletes the expired exercises and the exercise views that point at them.
+func expireExercises(retentionDays int, now time.Time) (*RetentionReport, error) {
+	report, err := findExpiredExercises(retentionDays, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := dataStore.DeleteExercises(report.expiredIDs); err != nil {
+		return nil, err
+	}
+	recordDeletedExercises(report.expiredIDs)
+	if err := dataStore.DeleteUserExerciseViews(report.viewIDs); err != nil {
+		return nil, err
+	}
+	report.Deleted = true
+	return report, nil
+}
+
+// startExerciseRetentionScheduler expires stale cached exercises once a day.
+func startExerciseRetentionScheduler() {
+	if exerciseRetentionDays == 0 {
+		return
+	}
+	go func() {
+		for {
+			report, err := expireExercises(exerciseRetentionDays, time.Now())
+			if err != nil {
+				log.Printf("Error expiring cached exercises: %v", err)
+			} else if report.Expired > 0 {
+				log.Printf("Expired %d cached exercises and %d exercise views", report.Expired, report.ExpiredViews)
+			}
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
+
+// Handle exercise cache retention (admin): GET /api/admin/cache-retention?days=30 previews
+// what would be expired, POST deletes it. days defaults to EXERCISE_RETENTION_DAYS, or 30.
+func handleAdminCacheRetention(w http.ResponseWriter, r *http.Request) {
+	days := exerciseRetentionDays
+	if days == 0 {
+		days = defaultExerciseRetentionDays
+	}
+	if value := r.URL.Query().Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, "days must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	var report *RetentionReport
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		report, err = findExpiredExercises(days, time.Now())
+	case http.MethodPost:
+		report, err = expireExercises(days, time.Now())
+		if err == nil {
+			recordAudit(r, auditExercisesPurge, "exercises", "", nil, report)
+		}
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		log.Printf("Error applying exercise retention: %v", err)
+		writeError(w, fmt.Sprintf("Failed to apply exercise retention: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// The Airtable schema ships inside the binary, so setup instructions and permission
+// checks work no matter which directory the server is started from.
+//
+//go:embed schema.json
+var schemaJSON []byte
+
+// TableSchema describes an Airtable table the app expects.
+type TableSchema struct {
+	Name        string        `json:"name"`
+	Required    bool          `json:"required"`
+	Note        string        `json:"note"`
+	Consequence string        `json:"consequence"`
+	Fields      []FieldSchema `json:"fields"`
+}
+
+type FieldSchema struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Note string `json:"note"`
+}
+
+var airtableSchema = mustLoadAirtableSchema()
+
+func mustLoadAirtableSchema() []TableSchema {
+	var tables []TableSchema
+	if err := json.Unmarshal(schemaJSON, &tables); err != nil {
+		log.Fatalf("Invalid embedded schema.json: %v", err)
+	}
+	return tables
+}
+
+// allTableNames lists the table names the code uses; each must be described in schema.json.
+func allTableNames() []string {
+	return []string{
+		topicsTableName, versionsTableName, usersTableName, userStatsTableName, exercisesTableName,
+		userExerciseViewsTableName, sessionsTableName, achievementsTableName, userAchievementsTableName,
+		notificationSettingsTableName, pushSubscriptionsTableName, apiTokensTableName, classesTableName,
+		classMembersTableName, assignmentsTableName, marketplaceListingsTableName, marketplaceRatingsTableName,
+		refinedPromptsTableName, featureFlagsTableName, analyticsEventsTableName, auditLogTableName,
+		currentSessionsTableName, webhooksTableName, statsEventsTableName, exerciseHintsTableName,
+		exerciseExplanationsTableName, hintTranslationsTableName, topicSuggestionsTableName, tenantsTableName,
+		generationQuotasTableName,
+		userAPIKeysTableName,
+		googleTokensTableName,
+		deletedExercisesTableName,
+		exerciseImagesTableName,
+		conversationsTableName,
+		writingErrorsTableName,
+		challengesTableName,
+		duelsTableName,
+		duelRatingsTableName,
+		exerciseFlagsTableName,
+		exerciseRetirementsTableName,
+	}
+}
+
+// validateAirtableSchema reports table names used in code that schema.json doesn't describe.
+func validateAirtableSchema() error {
+	known := make(map[string]bool)
+	for _, table := range airtableSchema {
+		known[table.Name] = true
+	}
+	var missing []string
+	for _, name := range allTableNames() {
+		if !known[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("tables missing from schema.json: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (f FieldSchema) String() string {
+	if f.Note != "" {
+		return fmt.Sprintf("%s: %s (%s)", f.Name, f.Type, f.Note)
+	}
+	return fmt.Sprintf("%s: %s", f.Name, f.Type)
+}
+
+// logTableInstructions prints the tables and fields to create in Airtable.
+func logTableInstructions() {
+	log.Printf("Please manually create these tables in your Airtable base:")
+	log.Printf("")
+	for i, table := range airtableSchema {
+		heading := fmt.Sprintf("📋 Table %d: '%s'", i+1, table.Name)
+		if table.Note != "" {
+			heading += " (" + table.Note + ")"
+		}
+		log.Print(heading)
+		for _, field := range table.Fields {
+			log.Printf("   • %s", field)
+		}
+		log.Printf("")
+	}
+	log.Printf("💡 Tip: The timestamp fields (CreatedAt, UpdatedAt) are optional.")
+	log.Printf("💡 The app will work with just the required fields if timestamps are missing.")
+	log.Printf("")
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Secrets in sensitiveColumns are encrypted before they are written to Airtable, with
+// AES-256-GCM under the master key SECRETS_ENCRYPTION_KEY. SECRETS_ENCRYPTION_KEY_FILE reads
+// it from a file instead, e.g. a Docker or Kubernetes secret, or one a KMS agent writes.
+// Encrypted values start with secretPrefix. Values without it were written before a key was
+// set, are read as they are, and are encrypted the next time they are saved. The column, and
+// for some columns a field of the same row, is authenticated with each value, so a value
+// copied elsewhere does not decrypt. Keys in SECRETS_ENCRYPTION_KEY_PREVIOUS still decrypt;
+// POST /api/admin/secrets/reencrypt rewrites every stored secret with the current key.
+const secretPrefix = "enc:v1:"
+
+// sensitiveColumn is a column whose values are encrypted at rest.
+type sensitiveColumn struct {
+	table   string
+	field   string
+	boundTo string // field of the same row authenticated with the value, if any
+}
+
+var sensitiveColumns = []sensitiveColumn{
+	{table: tenantsTableName, field: "OpenAIAPIKey"},
+	{table: webhooksTableName, field: "Secret"},
+	{table: pushSubscriptionsTableName, field: "Auth", boundTo: "Endpoint"},
+	{table: userAPIKeysTableName, field: "EncryptedKey", boundTo: "UserID"},
+	{table: googleTokensTableName, field: "AccessToken", boundTo: "UserID"},
+	{table: googleTokensTableName, field: "RefreshToken", boundTo: "UserID"},
+}
+
+// SecretsReport is what re-encrypting one column did.
+type SecretsReport struct {
+	Table       string `json:"table"`
+	Field       string `json:"field"`
+	Reencrypted int    `json:"reencrypted"`
+	Current     int    `json:"current"` // already encrypted with the current key
+	Failed      int    `json:"failed"`  // encrypted with an unknown key
+	Error       string `json:"error,omitempty"`
+}
+
+var secretCiphers []cipher.AEAD // the current key first, then the previous ones
+
+// initSecrets applies the master keys, which loadConfig has already validated.
+func initSecrets() {
+	secretCiphers = nil
+	if appConfig.SecretsEncryptionKey == "" {
+		if airtableBaseID != "" {
+			log.Println("Warning: SECRETS_ENCRYPTION_KEY is not set, so API keys and webhook secrets are stored in Airtable unencrypted")
+		}
+		return
+	}
+	for _, encoded := range append([]string{appConfig.SecretsEncryptionKey}, appConfig.SecretsEncryptionKeyPrevious...) {
+		key, _ := base64.StdEncoding.DecodeString(encoded)
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			log.Fatalf("Invalid secrets encryption key: %v", err)
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			log.Fatalf("Invalid secrets encryption key: %v", err)
+		}
+		secretCiphers = append(secretCiphers, gcm)
+	}
+}
+
+// secretsEncrypted reports whether secrets are encrypted before they are stored.
+func secretsEncrypted() bool {
+	return len(secretCiphers) > 0
+}
+
+func secretAdditionalData(table, field, bound string) []byte {
+	return []byte(table + "." + field + ":" + bound)
+}
+
+// sealSecret encrypts a value of table.field; bound is the value of the column's boundTo
+// field. Empty values, and every value while no master key is set, are returned as they are.
+func sealSecret(value, table, field, bound string) (string, error) {
+	if value == "" || !secretsEncrypted() {
+		return value, nil
+	}
+	gcm := secretCiphers[0]
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to encrypt %s.%s: %v", table, field, err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), secretAdditionalData(table, field, bound))
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openSecret decrypts a value sealSecret returned, trying the previous keys after the current
+// one. It also reports whether the value needs re-encrypting with the current key.
+func openSecret(stored, table, field, bound string) (value string, stale bool, err error) {
+	encoded, ok := strings.CutPrefix(stored, secretPrefix)
+	if !ok {
+		return stored, stored != "" && secretsEncrypted(), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false, fmt.Errorf("%s.%s is malformed", table, field)
+	}
+	for i, gcm := range secretCiphers {
+		if len(data) < gcm.NonceSize() {
+			break
+		}
+		plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], secretAdditionalData(table, field, bound))
+		if err == nil {
+			return string(plain), i > 0, nil
+		}
+	}
+	return "", false, fmt.Errorf("%s.%s can't be decrypted with SECRETS_ENCRYPTION_KEY or SECRETS_ENCRYPTION_KEY_PREVIOUS", table, field)
+}
+
+// readSecret decrypts a stored value for use, logging and returning "" if that fails.
+func readSecret(stored, table, field, bound string) string {
+	value, _, err := openSecret(stored, table, field, bound)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return ""
+	}
+	return value
+}
+
+// boundSecretFields returns the values of the fields a row's secrets are bound to.
+func boundSecretFields(table string, fields map[string]any) map[string]string {
+	bound := make(map[string]string)
+	for _, column := range sensitiveColumns {
+		if column.table == table && column.boundTo != "" {
+			bound[column.boundTo], _ = fields[column.boundTo].(string)
+		}
+	}
+	return bound
+}
+
+// resealRemappedSecrets re-encrypts a restored row's secrets whose bound field was rewritten
+// to a new record ID, as they are authenticated with the old value. bound holds the values
+// before the rewrite. Secrets that don't decrypt are left as they are, and logged.
+func resealRemappedSecrets(table string, bound map[string]string, fields map[string]any) {
+	for _, column := range sensitiveColumns {
+		if column.table != table || column.boundTo == "" {
+			continue
+		}
+		stored, _ := fields[column.field].(string)
+		current, _ := fields[column.boundTo].(string)
+		if stored == "" || current == bound[column.boundTo] {
+			continue
+		}
+		value, _, err := openSecret(stored, column.table, column.field, bound[column.boundTo])
+		if err != nil {
+			log.Printf("Warning: restoring %v", err)
+			continue
+		}
+		sealed, err := sealSecret(value, column.table, column.field, current)
+		if err != nil {
+			log.Printf("Warning: restoring %v", err)
+			continue
+		}
+		fields[column.field] = sealed
+	}
+}
+
+// reencryptColumn rewrites the column's values that are plaintext or encrypted with a
+// previous key.
+func reencryptColumn(column sensitiveColumn) *SecretsReport {
+	report := &SecretsReport{Table: column.table, Field: column.field}
+	table := airtableClient.GetTable(airtableBaseID, column.table)
+	records, err := getAllRecords(table.GetRecords())
+	if err != nil {
+		report.Error = fmt.Sprintf("failed to read %s from Airtable: %v", column.table, err)
+		return report
+	}
+
+	var updates []*airtable.Record
+	for _, record := range records.Records {
+		stored, _ := record.Fields[column.field].(string)
+		bound, _ := record.Fields[column.boundTo].(string)
+		if stored == "" {
+			continue
+		}
+		value, stale, err := openSecret(stored, column.table, column.field, bound)
+		switch {
+		case err != nil:
+			report.Failed++
+			continue
+		case !stale:
+			report.Current++
+			continue
+		}
+		sealed, err := sealSecret(value, column.table, column.field, bound)
+		if err != nil {
+			report.Failed++
+			continue
+		}
+		updates = append(updates, &airtable.Record{ID: record.ID, Fields: map[string]any{column.field: sealed}})
+	}
+	for start := 0; start < len(updates); start += 10 {
+		end := min(start+10, len(updates))
+		if _, err := table.UpdateRecordsPartial(&airtable.Records{Records: updates[start:end]}); err != nil {
+			report.Error = fmt.Sprintf("failed to update %s in Airtable: %v", column.table, err)
+			return report
+		}
+		report.Reencrypted += end - start
+	}
+	return report
+}
+
+// Handle re-encrypting stored secrets (admin): POST /api/admin/secrets/reencrypt encrypts
+// plaintext values and those under a previous key with the current key, for every column.
+func handleAdminSecretsReencrypt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !secretsEncrypted() {
+		writeError(w, "SECRETS_ENCRYPTION_KEY is not set", http.StatusBadRequest)
+		return
+	}
+	if airtableBaseID == "" {
+		writeError(w, "Secrets are only stored at rest with Airtable storage", http.StatusBadRequest)
+		return
+	}
+
+	reports := []*SecretsReport{}
+	for _, column := range sensitiveColumns {
+		reports = append(reports, reencryptColumn(column))
+	}
+	recordAudit(r, auditSecretsReencrypt, "secrets", "", nil, reports)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"columns": reports})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookieName = "user_id"
+	sessionLifetime   = 30 * 24 * time.Hour
+)
+
+// Session cookie configuration
+var (
+	// sessionKeys holds the signing keys. The first key signs new cookies; the others are
+	// previous keys that are still accepted, so keys can be rotated without logging users out.
+	sessionKeys    [][]byte
+	cookieSecure   bool
+	cookieSameSite http.SameSite
+)
+
+func initSessionCookies() {
+	if secret := appConfig.SessionSecret; secret != "" {
+		sessionKeys = append(sessionKeys, []byte(secret))
+	} else {
+		log.Println("Warning: SESSION_SECRET not set. Using a random key; users will be logged out on restart.")
+		key := make([]byte, 32)
+		rand.Read(key)
+		sessionKeys = append(sessionKeys, key)
+	}
+	for _, secret := range appConfig.SessionSecretPrevious {
+		sessionKeys = append(sessionKeys, []byte(secret))
+	}
+
+	cookieSecure = appConfig.CookieSecure
+	switch appConfig.CookieSameSite {
+	case "strict":
+		cookieSameSite = http.SameSiteStrictMode
+	case "none":
+		cookieSameSite = http.SameSiteNoneMode
+	default:
+		cookieSameSite = http.SameSiteLaxMode
+	}
+}
+
+// newRandomToken returns 16 random bytes in hex, for secrets and identifiers that must not
+// be guessable: unsubscribe and calendar links, API tokens, CSRF tokens and guest IDs.
+func newRandomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func signSessionValue(value string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySessionValue checks a signed cookie value against all accepted keys. It returns
+// the original value and whether it was signed with the current key.
+func verifySessionValue(signed string) (string, bool, bool) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", false, false
+	}
+	value := signed[:i]
+	for n, key := range sessionKeys {
+		if hmac.Equal([]byte(signSessionValue(value, key)), []byte(signed)) {
+			return value, n == 0, true
+		}
+	}
+	return "", false, false
+}
+
+// newCookie returns a cookie with the configured Secure and SameSite attributes.
+func newCookie(name, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   cookieSecure,
+		SameSite: cookieSameSite,
+	}
+}
+
+func setSessionCookie(w http.ResponseWriter, userID string) {
+	cookie := newCookie(sessionCookieName, signSessionValue(userID, sessionKeys[0]), time.Now().Add(sessionLifetime))
+	cookie.HttpOnly = true
+	http.SetCookie(w, cookie)
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	cookie := newCookie(sessionCookieName, "", time.Unix(0, 0))
+	cookie.HttpOnly = true
+	http.SetCookie(w, cookie)
+}
+
+// refreshSessionCookies re-signs session cookies that were signed with a previous key,
+// so they keep working once that key is retired.
+func refreshSessionCookies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			if userID, current, ok := verifySessionValue(cookie.Value); ok && !current {
+				setSessionCookie(w, userID)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// An unfinished set can be resumed for a day after it was last answered.
+const currentSessionTTL = 24 * time.Hour
+
+// CurrentSession is a set of exercises in progress. Serving exercises records their views
+// for SRS, so a learner who closes the tab mid-set continues with the same exercises
+// rather than fetching new ones. Each owner (user or guest) has at most one.
+type CurrentSession struct {
+	ID        string            `json:"-"`
+	OwnerID   string            `json:"-"`
+	TopicID   string            `json:"topic_id"`
+	Exercises []json.RawMessage `json:"exercises"` // as served by /api/exercises, with their IDs
+	Answered  []string          `json:"answered"`  // IDs of the exercises answered so far
+	Mistakes  int               `json:"mistakes"`
+	Hints     int               `json:"hints"`
+	TimeSpent int               `json:"time_spent"`
+	StartedAt time.Time         `json:"started_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	// Escalate is set while the set may still step up to harder exercises (see difficulty.go);
+	// Escalated reports, in the response to saving progress, that it just did.
+	Escalate  bool `json:"escalate"`
+	Escalated bool `json:"escalated,omitempty"`
+
+	escalateChanged bool // Escalate was cleared and needs saving
+}
+
+type CurrentSessionProgress struct {
+	Answered  []string `json:"answered"`
+	Mistakes  int      `json:"mistakes"`
+	Hints     int      `json:"hints"`
+	TimeSpent int      `json:"time_spent"`
+}
+
+var (
+	currentSessionsMutex  sync.Mutex
+	memoryCurrentSessions = make(map[string]*CurrentSession) // with in-memory storage, by owner
+)
+
+// exerciseIDs returns the IDs of the session's exercises, in the order they were served.
+func (s *CurrentSession) exerciseIDs() []string {
+	ids := make([]string, 0, len(s.Exercises))
+	for _, raw := range s.Exercises {
+		var exercise struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(raw, &exercise)
+		ids = append(ids, exercise.ID)
+	}
+	return ids
+}
+
+// finished reports whether every exercise has been answered.
+func (s *CurrentSession) finished() bool {
+	for _, id := range s.exerciseIDs() {
+		if !slices.Contains(s.Answered, id) {
+			return false
+		}
+	}
+	return true
+}
+
+func currentSessionFromRecord(record *airtable.Record) *CurrentSession {
+	session := &CurrentSession{ID: record.ID, Answered: []string{}}
+	if val, ok := record.Fields["OwnerID"].(string); ok {
+		session.OwnerID = val
+	}
+	if val, ok := record.Fields["TopicID"].(string); ok {
+		session.TopicID = val
+	}
+	if val, ok := record.Fields["Exercises"].(string); ok {
+		json.Unmarshal([]byte(val), &session.Exercises)
+	}
+	if val, ok := record.Fields["Answered"].(string); ok && val != "" {
+		session.Answered = strings.Split(val, ",")
+	}
+	if val, ok := record.Fields["Mistakes"].(float64); ok {
+		session.Mistakes = int(val)
+	}
+	if val, ok := record.Fields["Hints"].(float64); ok {
+		session.Hints = int(val)
+	}
+	if val, ok := record.Fields["TimeSpent"].(float64); ok {
+		session.TimeSpent = int(val)
+	}
+	if val, ok := record.Fields["StartedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			session.StartedAt = t
+		}
+	}
+	if val, ok := record.Fields["UpdatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			session.UpdatedAt = t
+		}
+	}
+	if val, ok := record.Fields["Escalate"].(bool); ok {
+		session.Escalate = val
+	}
+	return session
+}
+
+// getCurrentSession returns the owner's session in progress, or nil if there is none or it
+// has expired.
+func getCurrentSession(ownerID string) (*CurrentSession, error) {
+	var session *CurrentSession
+	if airtableBaseID == "" {
+		currentSessionsMutex.Lock()
+		if stored, ok := memoryCurrentSessions[ownerID]; ok {
+			c := *stored
+			session = &c
+		}
+		currentSessionsMutex.Unlock()
+	} else {
+		table := airtableClient.GetTable(airtableBaseID, currentSessionsTableName)
+		records, err := table.GetRecords().WithFilterFormula(fmt.Sprintf("{OwnerID} = '%s'", ownerID)).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current session from Airtable: %v", err)
+		}
+		for _, record := range records.Records {
+			candidate := currentSessionFromRecord(record)
+			if session == nil || candidate.UpdatedAt.After(session.UpdatedAt) {
+				session = candidate
+			}
+		}
+	}
+
+	if session == nil || time.Since(session.UpdatedAt) > currentSessionTTL {
+		return nil, nil
+	}
+	return session, nil
+}
+
+// saveCurrentSession creates or updates the owner's session.
+func saveCurrentSession(session *CurrentSession) error {
+	session.UpdatedAt = time.Now().UTC()
+	if airtableBaseID == "" {
+		currentSessionsMutex.Lock()
+		c := *session
+		memoryCurrentSessions[session.OwnerID] = &c
+		currentSessionsMutex.Unlock()
+		return nil
+	}
+
+	exercises, err := json.Marshal(session.Exercises)
+	if err != nil {
+		return err
+	}
+	fields := map[string]any{
+		"OwnerID":   session.OwnerID,
+		"TopicID":   session.TopicID,
+		"Exercises": string(exercises),
+		"Answered":  strings.Join(session.Answered, ","),
+		"Mistakes":  session.Mistakes,
+		"Hints":     session.Hints,
+		"TimeSpent": session.TimeSpent,
+		"StartedAt": session.StartedAt.Format(time.RFC3339),
+		"UpdatedAt": session.UpdatedAt.Format(time.RFC3339),
+	}
+	// Only sets that escalate need the Escalate column
+	if session.Escalate || session.escalateChanged {
+		fields["Escalate"] = session.Escalate
+	}
+	table := airtableClient.GetTable(airtableBaseID, currentSessionsTableName)
+	if session.ID != "" {
+		records := &airtable.Records{Records: []*airtable.Record{{ID: session.ID, Fields: fields}}}
+		if _, err := table.UpdateRecords(records); err != nil {
+			return fmt.Errorf("failed to update current session in Airtable: %v", err)
+		}
+		return nil
+	}
+	result, err := table.AddRecords(&airtable.Records{Records: []*airtable.Record{{Fields: fields}}})
+	if err != nil {
+		return fmt.Errorf("failed to create current session in Airtable: %v", err)
+	}
+	if len(result.Records) > 0 {
+		session.ID = result.Records[0].ID
+	}
+	return nil
+}
+
+// deleteCurrentSession removes the owner's session, if any.
+func deleteCurrentSession(ownerID string) error {
+	if airtableBaseID == "" {
+		currentSessionsMutex.Lock()
+		delete(memoryCurrentSessions, ownerID)
+		currentSessionsMutex.Unlock()
+		return nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, currentSessionsTableName)
+	records, err := table.GetRecords().WithFilterFormula(fmt.Sprintf("{OwnerID} = '%s'", ownerID)).ReturnFields("OwnerID").Do()
+	if err != nil {
+		return fmt.Errorf("failed to get current session from Airtable: %v", err)
+	}
+	var ids []string
+	for _, record := range records.Records {
+		ids = append(ids, record.ID)
+	}
+	for start := 0; start < len(ids); start += 10 {
+		if _, err := table.DeleteRecords(ids[start:min(start+10, len(ids))]); err != nil {
+			return fmt.Errorf("failed to delete current session: %v", err)
+		}
+	}
+	return nil
+}
+
+// startCurrentSession replaces the owner's session with a newly served set of exercises,
+// which may step up to harder exercises if escalate is set.
+func startCurrentSession(ownerID, topicID string, exercises []json.RawMessage, escalate bool) error {
+	existing, err := getCurrentSession(ownerID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	session := &CurrentSession{
+		OwnerID:   ownerID,
+		TopicID:   topicID,
+		Exercises: exercises,
+		Answered:  []string{},
+		StartedAt: now,
+		Escalate:  escalate && topicID != "",
+	}
+	if existing != nil {
+		session.ID = existing.ID
+	}
+	return saveCurrentSession(session)
+}
+
+// Handle the session in progress, for users and guests:
+// GET /api/sessions/current returns it, or 404 when there is none to resume.
+// PUT /api/sessions/current saves progress: {"answered": ["rec..."], "mistakes": 1, "hints": 0, "time_spent": 42}.
+// DELETE /api/sessions/current discards it, e.g. once the set is complete.
+func handleCurrentSession(w http.ResponseWriter, r *http.Request) {
+	ownerID := getProgressOwnerID(w, r)
+
+	switch r.Method {
+	case http.MethodGet:
+		session, err := getCurrentSession(ownerID)
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to get current session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if session == nil || session.finished() {
+			writeError(w, "No session in progress", http.StatusNotFound)
+			return
+		}
+		session.Exercises = exercisesForClient(r, session.Exercises)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(session)
+
+	case http.MethodPut:
+		var progress CurrentSessionProgress
+		if err := json.NewDecoder(r.Body).Decode(&progress); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if progress.Mistakes < 0 || progress.Hints < 0 || progress.TimeSpent < 0 {
+			writeError(w, "Invalid session values", http.StatusBadRequest)
+			return
+		}
+		session, err := getCurrentSession(ownerID)
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to get current session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if session == nil {
+			writeError(w, "No session in progress", http.StatusNotFound)
+			return
+		}
+		ids := session.exerciseIDs()
+		answered := []string{}
+		for _, id := range progress.Answered {
+			if !slices.Contains(ids, id) {
+				writeError(w, fmt.Sprintf("Exercise %s is not part of the session", id), http.StatusBadRequest)
+				return
+			}
+			if !slices.Contains(answered, id) {
+				answered = append(answered, id)
+			}
+		}
+		session.Answered = answered
+		session.Mistakes = progress.Mistakes
+		session.Hints = progress.Hints
+		session.TimeSpent = progress.TimeSpent
+		// A set that escalates steps up once, after a perfect start, or not at all
+		escalated := false
+		if session.Escalate && len(answered) >= escalateAfterAnswers {
+			session.Escalate = false
+			session.escalateChanged = true
+			if session.Mistakes == 0 && session.Hints == 0 {
+				var user *User
+				if userID := getUserIDFromRequest(r); userID != "" {
+					user, _ = dataStore.GetUserByID(userID)
+				}
+				var err error
+				if escalated, err = escalateSession(r.Context(), session, user, time.Now()); err != nil {
+					log.Printf("Warning: failed to escalate the session of %s: %v", ownerID, err)
+				}
+			}
+		}
+		if err := saveCurrentSession(session); err != nil {
+			writeError(w, fmt.Sprintf("Failed to save current session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		session.Escalated = escalated
+		session.Exercises = exercisesForClient(r, session.Exercises)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(session)
+
+	case http.MethodDelete:
+		if err := deleteCurrentSession(ownerID); err != nil {
+			writeError(w, fmt.Sprintf("Failed to delete current session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Session is a completed practice session as reported by the client.
+type Session struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	TopicID     string    `json:"topic_id"`
+	Exercises   int       `json:"exercises"`
+	Mistakes    int       `json:"mistakes"`
+	Hints       int       `json:"hints"`
+	TimeSpent   int       `json:"time_spent"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+func createSession(session *Session) (*Session, error) {
+	table := airtableClient.GetTable(airtableBaseID, sessionsTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				Fields: map[string]any{
+					"UserID":      session.UserID,
+					"TopicID":     session.TopicID,
+					"Exercises":   session.Exercises,
+					"Mistakes":    session.Mistakes,
+					"Hints":       session.Hints,
+					"TimeSpent":   session.TimeSpent,
+					"CompletedAt": session.CompletedAt.Format(time.RFC3339),
+				},
+			},
+		},
+	}
+
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session in Airtable: %v", err)
+	}
+	if len(result.Records) == 0 {
+		return nil, fmt.Errorf("no records returned from Airtable")
+	}
+
+	session.ID = result.Records[0].ID
+	return session, nil
+}
+
+// getUserSessions returns a user's sessions, oldest first.
+func getUserSessions(userID string) ([]*Session, error) {
+	table := airtableClient.GetTable(airtableBaseID, sessionsTableName)
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(fmt.Sprintf("{UserID} = '%s'", userID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions from Airtable: %v", err)
+	}
+
+	var sessions []*Session
+	for _, record := range records.Records {
+		sessions = append(sessions, sessionFromRecord(record))
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CompletedAt.Before(sessions[j].CompletedAt)
+	})
+	return sessions, nil
+}
+
+// getSessionsSince returns every user's sessions completed after the given time.
+func getSessionsSince(since time.Time) ([]*Session, error) {
+	table := airtableClient.GetTable(airtableBaseID, sessionsTableName)
+	formula := fmt.Sprintf("IS_AFTER({CompletedAt}, '%s')", since.UTC().Format(time.RFC3339))
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(formula))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions from Airtable: %v", err)
+	}
+
+	var sessions []*Session
+	for _, record := range records.Records {
+		sessions = append(sessions, sessionFromRecord(record))
+	}
+	return sessions, nil
+}
+
+func sessionFromRecord(record *airtable.Record) *Session {
+	session := &Session{
+		ID: record.ID,
+	}
+	if val, ok := record.Fields["UserID"].(string); ok {
+		session.UserID = val
+	}
+	if val, ok := record.Fields["TopicID"].(string); ok {
+		session.TopicID = val
+	}
+	if val, ok := record.Fields["Exercises"].(float64); ok {
+		session.Exercises = int(val)
+	}
+	if val, ok := record.Fields["Mistakes"].(float64); ok {
+		session.Mistakes = int(val)
+	}
+	if val, ok := record.Fields["Hints"].(float64); ok {
+		session.Hints = int(val)
+	}
+	if val, ok := record.Fields["TimeSpent"].(float64); ok {
+		session.TimeSpent = int(val)
+	}
+	if val, ok := record.Fields["CompletedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			session.CompletedAt = t
+		}
+	}
+	return session
+}
+
+// answeredSinceLastSession counts the exercises the user answered after their latest
+// recorded session, looking back at most currentSessionTTL.
+func answeredSinceLastSession(userID string, now time.Time) (int, error) {
+	since := now.Add(-currentSessionTTL)
+	sessions, err := getUserSessions(userID)
+	if err != nil {
+		return 0, err
+	}
+	if len(sessions) > 0 && sessions[len(sessions)-1].CompletedAt.After(since) {
+		since = sessions[len(sessions)-1].CompletedAt
+	}
+	views, err := dataStore.GetUserExerciseViews(userID)
+	if err != nil {
+		return 0, err
+	}
+	answered := 0
+	for _, view := range views {
+		if view.LastViewed.After(since) {
+			answered++
+		}
+	}
+	return answered, nil
+}
+
+// Handle practice sessions: GET lists the user's sessions, POST records a completed one
+func handleUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := getUserSessions(userID)
+		if err != nil {
+			writeError(w, "Failed to get sessions", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]*Session{"sessions": sessions})
+
+	case http.MethodPost:
+		var session Session
+		if err := json.NewDecoder(r.Body).Decode(&session); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if session.Exercises <= 0 || session.Exercises > maxExerciseCount || session.Mistakes < 0 || session.Mistakes > session.Exercises ||
+			session.Hints < 0 || session.TimeSpent < 0 {
+			writeError(w, "Invalid session values", http.StatusBadRequest)
+			return
+		}
+		session.UserID = userID
+		session.CompletedAt = time.Now()
+
+		// Sessions feed achievements, assignments and the leaderboard, so they count no more
+		// exercises than the user answered since their previous session
+		answered, err := answeredSinceLastSession(userID, session.CompletedAt)
+		if err != nil {
+			writeError(w, "Failed to record session", http.StatusInternalServerError)
+			return
+		}
+		if answered == 0 {
+			writeError(w, "No exercises were answered since the last session", http.StatusUnprocessableEntity)
+			return
+		}
+		session.Exercises = min(session.Exercises, answered)
+		session.Mistakes = min(session.Mistakes, session.Exercises)
+
+		if _, err := createSession(&session); err != nil {
+			writeError(w, "Failed to record session", http.StatusInternalServerError)
+			return
+		}
+
+		unlocked, err := evaluateAchievements(userID)
+		if err != nil {
+			// Achievements are best effort, the session itself has been recorded
+			log.Printf("Warning: failed to evaluate achievements: %v", err)
+			unlocked = []*Achievement{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{
+			"session":               session,
+			"unlocked_achievements": unlocked,
+		})
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+const heatmapDays = 365
+
+// HeatmapDay is one day of the activity heatmap.
+type HeatmapDay struct {
+	Date      string `json:"date"` // YYYY-MM-DD in the requested timezone
+	Sessions  int    `json:"sessions"`
+	Exercises int    `json:"exercises"`
+	TimeSpent int    `json:"time_spent"`
+	Level     int    `json:"level"` // 0 for no practice, 1-4 by exercises relative to the busiest day
+}
+
+// ActivityHeatmap is GET /api/user/activity-heatmap's response.
+type ActivityHeatmap struct {
+	Timezone      string       `json:"timezone"`
+	From          string       `json:"from"`
+	To            string       `json:"to"`
+	Days          []HeatmapDay `json:"days"` // every day from From to To, oldest first
+	ActiveDays    int          `json:"active_days"`
+	TotalSessions int          `json:"total_sessions"`
+	MaxExercises  int          `json:"max_exercises"`
+}
+
+// getUserSessionsSince returns a user's sessions completed after the given time.
+func getUserSessionsSince(userID string, since time.Time) ([]*Session, error) {
+	table := airtableClient.GetTable(airtableBaseID, sessionsTableName)
+	formula := fmt.Sprintf("AND({UserID} = '%s', IS_AFTER({CompletedAt}, '%s'))", userID, since.UTC().Format(time.RFC3339))
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(formula))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions from Airtable: %v", err)
+	}
+
+	var sessions []*Session
+	for _, record := range records.Records {
+		sessions = append(sessions, sessionFromRecord(record))
+	}
+	return sessions, nil
+}
+
+// activityHeatmap counts the sessions per day of the heatmapDays days up to now, in location.
+func activityHeatmap(sessions []*Session, now time.Time, location *time.Location) *ActivityHeatmap {
+	today := now.In(location)
+	first := time.Date(today.Year(), today.Month(), today.Day()-(heatmapDays-1), 0, 0, 0, 0, location)
+	heatmap := &ActivityHeatmap{
+		Timezone: location.String(),
+		From:     first.Format(time.DateOnly),
+		To:       today.Format(time.DateOnly),
+		Days:     make([]HeatmapDay, heatmapDays),
+	}
+	index := make(map[string]int, heatmapDays)
+	for i := range heatmap.Days {
+		date := first.AddDate(0, 0, i).Format(time.DateOnly)
+		heatmap.Days[i].Date = date
+		index[date] = i
+	}
+
+	for _, session := range sessions {
+		i, ok := index[session.CompletedAt.In(location).Format(time.DateOnly)]
+		if !ok {
+			continue
+		}
+		day := &heatmap.Days[i]
+		day.Sessions++
+		day.Exercises += session.Exercises
+		day.TimeSpent += session.TimeSpent
+		heatmap.TotalSessions++
+	}
+	for _, day := range heatmap.Days {
+		if day.Sessions > 0 {
+			heatmap.ActiveDays++
+		}
+		heatmap.MaxExercises = max(heatmap.MaxExercises, day.Exercises)
+	}
+	for i := range heatmap.Days {
+		day := &heatmap.Days[i]
+		if day.Sessions > 0 {
+			// Rounded up, so any practice shows; a day with sessions but no exercises is level 1
+			day.Level = max(1, (4*day.Exercises+heatmap.MaxExercises-1)/max(1, heatmap.MaxExercises))
+		}
+	}
+	return heatmap
+}
+
+// Handle the activity heatmap: GET /api/user/activity-heatmap?tz=Europe/Berlin returns
+// per-day practice counts for the last 365 days, in tz or the user's notification timezone
+func handleUserActivityHeatmap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	timezone := r.URL.Query().Get("tz")
+	if timezone == "" {
+		if settings, err := getNotificationSettings(userID); err == nil {
+			timezone = settings.Timezone
+		}
+	}
+	timezone, err := parseTimezone(timezone)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	location, _ := time.LoadLocation(timezone)
+
+	now := time.Now()
+	// A day of margin covers timezones ahead of UTC
+	sessions, err := getUserSessionsSince(userID, now.AddDate(0, 0, -(heatmapDays+1)))
+	if err != nil {
+		writeError(w, "Failed to get sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(activityHeatmap(sessions, now, location))
+}
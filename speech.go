@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Speech answers let learners say the sentence out loud instead of placing its words.
+// POST /api/exercises/{id}/speak takes the recording and sends it to a speech-to-text
+// service with Whisper's API (POST /audio/transcriptions): STT_URL, or the model API the
+// request would use for generation. The transcript is then aligned with the accepted
+// orderings of the sentence, word by word, as a check of the spoken words.
+//
+// Whisper gives no pronunciation score, so confidence is the recognizer's own: the mean
+// probability of its transcript, from the segments' average log probabilities. Unclear
+// speech is heard with less confidence, or as different words.
+
+const maxSpeechAudioSize = 10 << 20
+
+// SpeechWord is a heard word and whether it belongs where it was said.
+type SpeechWord struct {
+	Word    string `json:"word"`
+	Correct bool   `json:"correct"`
+}
+
+// SpeechCheck is the result of POST /api/exercises/{id}/speak. As with AnswerCheck, the
+// sentence is only included once the answer is correct.
+type SpeechCheck struct {
+	Transcript   string       `json:"transcript"`
+	Correct      bool         `json:"correct"`
+	WordAccuracy float64      `json:"word_accuracy"`        // 1 minus the word error rate, at least 0
+	Confidence   *float64     `json:"confidence,omitempty"` // how sure the recognizer was, 0 to 1, if it says
+	Words        []SpeechWord `json:"words"`
+	Missing      int          `json:"missing"` // words of the sentence that were not heard
+	Sentence     string       `json:"sentence,omitempty"`
+}
+
+// Transcription is what the speech-to-text service heard.
+type Transcription struct {
+	Text     string `json:"text"`
+	Segments []struct {
+		AvgLogprob float64 `json:"avg_logprob"`
+	} `json:"segments"`
+}
+
+// sttProvider returns the URL and key of the speech-to-text service for the request.
+func sttProvider(ctx context.Context) (url, apiKey string) {
+	if appConfig.STTURL != "" {
+		return appConfig.STTURL, appConfig.STTAPIKey
+	}
+	llm := llmProviderFor(ctx)
+	return llm.URL, llm.APIKey
+}
+
+// transcribe sends a recording to the speech-to-text service, asking for German.
+func transcribe(ctx context.Context, audio []byte, filename string) (transcription *Transcription, err error) {
+	if offlineMode() {
+		return nil, errOfflineMode
+	}
+	ctx, end := startSpan(ctx, "transcribe answer", attribute.String("stt.model", appConfig.STTModel))
+	defer func() { end(err) }()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transcription request body: %w", err)
+	}
+	part.Write(audio)
+	form.WriteField("model", appConfig.STTModel)
+	form.WriteField("language", "de")
+	form.WriteField("response_format", "verbose_json")
+	form.Close()
+
+	url, apiKey := sttProvider(ctx)
+	apiReq, err := http.NewRequestWithContext(ctx, "POST", url+"/audio/transcriptions", &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API request for transcription: %w", err)
+	}
+	apiReq.Header.Set("Content-Type", form.FormDataContentType())
+	if apiKey != "" {
+		apiReq.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := llmHTTPClient.Do(apiReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call the speech-to-text API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API response for transcription: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr OpenAIResponse
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Error != nil {
+			return nil, fmt.Errorf("API error during transcription: %s", apiErr.Error.Message)
+		}
+		return nil, fmt.Errorf("speech-to-text API returned status %d", resp.StatusCode)
+	}
+	transcription = &Transcription{}
+	if err := json.Unmarshal(respBody, transcription); err != nil {
+		return nil, fmt.Errorf("failed to parse API response for transcription: %w", err)
+	}
+	return transcription, nil
+}
+
+// confidence returns the mean probability of the transcript's segments, or nil without any.
+func (t *Transcription) confidence() *float64 {
+	if len(t.Segments) == 0 {
+		return nil
+	}
+	sum := 0.0
+	for _, segment := range t.Segments {
+		sum += math.Exp(segment.AvgLogprob)
+	}
+	c := math.Round(sum/float64(len(t.Segments))*1000) / 1000
+	return &c
+}
+
+// alignWords aligns heard words with an ordering by edit distance, ignoring case. It returns
+// each heard word marked correct if it matches its place, the words of the ordering that
+// were not heard, and the number of edits.
+func alignWords(heard, ordering []string) ([]SpeechWord, int, int) {
+	// dist[i][j] is the edit distance between heard[i:] and ordering[j:]
+	dist := make([][]int, len(heard)+1)
+	for i := range dist {
+		dist[i] = make([]int, len(ordering)+1)
+	}
+	for i := len(heard); i >= 0; i-- {
+		for j := len(ordering); j >= 0; j-- {
+			switch {
+			case i == len(heard):
+				dist[i][j] = len(ordering) - j
+			case j == len(ordering):
+				dist[i][j] = len(heard) - i
+			default:
+				substitution := dist[i+1][j+1]
+				if !strings.EqualFold(heard[i], ordering[j]) {
+					substitution++
+				}
+				dist[i][j] = min(substitution, dist[i+1][j]+1, dist[i][j+1]+1)
+			}
+		}
+	}
+
+	words := make([]SpeechWord, 0, len(heard))
+	missing := 0
+	i, j := 0, 0
+	for i < len(heard) || j < len(ordering) {
+		switch {
+		case i < len(heard) && j < len(ordering) && strings.EqualFold(heard[i], ordering[j]) && dist[i][j] == dist[i+1][j+1]:
+			words = append(words, SpeechWord{Word: heard[i], Correct: true})
+			i, j = i+1, j+1
+		case i < len(heard) && j < len(ordering) && dist[i][j] == dist[i+1][j+1]+1:
+			words = append(words, SpeechWord{Word: heard[i]}) // said instead of ordering[j]
+			i, j = i+1, j+1
+		case i < len(heard) && dist[i][j] == dist[i+1][j]+1:
+			words = append(words, SpeechWord{Word: heard[i]}) // said in addition
+			i++
+		default:
+			missing++
+			j++
+		}
+	}
+	return words, missing, dist[0][0]
+}
+
+// checkSpeech scores a transcript against the exercise's accepted orderings, using the one
+// it is closest to.
+func checkSpeech(ex *Exercise, transcription *Transcription) SpeechCheck {
+	heard := sentenceWords(transcription.Text)
+	check := SpeechCheck{Transcript: strings.TrimSpace(transcription.Text), Confidence: transcription.confidence()}
+	bestEdits := -1
+	for _, ordering := range acceptedOrderings(ex) {
+		words, missing, edits := alignWords(heard, ordering)
+		if bestEdits >= 0 && edits >= bestEdits {
+			continue
+		}
+		bestEdits = edits
+		check.Words, check.Missing = words, missing
+		check.WordAccuracy = 0
+		if len(ordering) > 0 {
+			check.WordAccuracy = math.Round(max(0, 1-float64(edits)/float64(len(ordering)))*1000) / 1000
+		}
+		check.Correct = edits == 0 && len(ordering) > 0
+	}
+	if check.Correct {
+		check.Sentence = ex.Sentence
+	}
+	return check
+}
+
+// Handle spoken answers, for users and guests: POST /api/exercises/{id}/speak with the
+// recording as the "audio" field of a multipart form, or as the request body (any format
+// the speech-to-text service reads: webm, ogg, mp3, wav, m4a, ...). Returns a SpeechCheck.
+func handleExerciseSpeech(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if offlineMode() {
+		writeOfflineError(w)
+		return
+	}
+	exerciseID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/exercises/"), "/speak")
+	r.Body = http.MaxBytesReader(w, r.Body, maxSpeechAudioSize)
+
+	body := io.Reader(r.Body)
+	filename := "answer.webm"
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("audio")
+		if err != nil {
+			writeError(w, "Missing audio recording", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body, filename = file, header.Filename
+	} else if ext := audioExtension(r.Header.Get("Content-Type")); ext != "" {
+		filename = "answer." + ext
+	}
+	audio, err := io.ReadAll(body)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Audio recording must be at most %d MB", maxSpeechAudioSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(audio) == 0 {
+		writeError(w, "Missing audio recording", http.StatusBadRequest)
+		return
+	}
+
+	ex, err := exerciseByID(exerciseID)
+	if err != nil {
+		writeError(w, "Exercise not found", http.StatusNotFound)
+		return
+	}
+	if ex.Sentence == "" {
+		writeError(w, "Exercise has no sentence to say", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// With STT_URL the recording goes to the server's speech service, which a user's own key
+	// doesn't pay for
+	if userID := getUserIDFromRequest(r); appConfig.STTURL != "" && userID != "" {
+		err = useUserQuota(userID, time.Now())
+	} else {
+		err = useModelQuota(r.Context(), userID)
+	}
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	transcription, err := transcribe(r.Context(), audio, filename)
+	if errors.Is(err, errOfflineMode) {
+		writeOfflineError(w)
+		return
+	}
+	if err != nil {
+		log.Printf("Error transcribing answer to exercise %s: %v", exerciseID, err)
+		writeError(w, "Failed to transcribe the recording", http.StatusBadGateway)
+		return
+	}
+	recordAnswerCheck(getProgressOwnerID(w, r), ex.AirtableID, time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(checkSpeech(ex, transcription))
+}
+
+// audioExtension returns the file extension for an audio content type, which Whisper uses to
+// tell the format, or "" for unknown ones.
+func audioExtension(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(strings.ToLower(mediaType)) {
+	case "audio/webm":
+		return "webm"
+	case "audio/ogg":
+		return "ogg"
+	case "audio/mpeg", "audio/mp3":
+		return "mp3"
+	case "audio/wav", "audio/x-wav", "audio/wave":
+		return "wav"
+	case "audio/mp4", "audio/m4a", "audio/x-m4a":
+		return "m4a"
+	case "audio/flac":
+		return "flac"
+	}
+	return ""
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The frontend is embedded in the binary, so the binary alone is a complete deployment.
+// STATIC_DIR serves it from a directory instead, e.g. a separately built frontend; the
+// directory is rescanned at most once a second, so edits show up on the next reload.
+// Each asset gets a content hash, and index.html is rewritten to link local assets as
+// e.g. /app.js?v=<hash>. A link with the current hash is cached for a year; the page
+// itself, and assets requested without their current hash, are revalidated with their
+// ETag on every load, so a new release shows up immediately.
+//
+// Paths that match no file, have no extension and are not under /api/ get index.html, so
+// the frontend can route on the client and deep links survive a reload.
+//
+//go:embed index.html app.js privacy.html favicon.svg favicon-32x32.svg
+var staticFiles embed.FS
+
+const staticRescanInterval = time.Second
+
+// staticAsset is a frontend file ready to serve.
+type staticAsset struct {
+	content     []byte
+	contentType string
+	hash        string // first 16 hex digits of the content's SHA-256
+}
+
+var (
+	staticMutex     sync.Mutex
+	staticAssets    map[string]*staticAsset // by URL path; "/" is the rendered index.html
+	staticSignature string                  // names, sizes and times of STATIC_DIR's files when loaded
+	staticCheckedAt time.Time
+
+	// Links in index.html to local files that may get a content hash
+	assetLink = regexp.MustCompile(`(src|href)="/?([^"?#:]+\.(?:js|css|svg|png|jpg|ico|webp|woff2?|json))(\?v=[^"]*)?"`)
+)
+
+func newStaticAsset(name string, content []byte) *staticAsset {
+	sum := sha256.Sum256(content)
+	contentType := mime.TypeByExtension(path.Ext(name))
+	switch path.Ext(name) {
+	case ".js":
+		contentType = "application/javascript" // not text/javascript on systems with an old mime.types
+	case ".html":
+		contentType = "text/html; charset=utf-8"
+	case "":
+		contentType = "application/octet-stream"
+	}
+	return &staticAsset{content: content, contentType: contentType, hash: hex.EncodeToString(sum[:8])}
+}
+
+// staticFS returns the frontend's files: STATIC_DIR, or the embedded ones.
+func staticFS() fs.FS {
+	if appConfig.StaticDir != "" {
+		return os.DirFS(appConfig.StaticDir)
+	}
+	return staticFiles
+}
+
+// loadStaticAssets reads every file of fsys except hidden ones, and renders index.html with hashed links.
+func loadStaticAssets(fsys fs.FS) (map[string]*staticAsset, error) {
+	assets := make(map[string]*staticAsset)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		assets["/"+name] = newStaticAsset(name, content)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	index, ok := assets["/index.html"]
+	if !ok {
+		return nil, fmt.Errorf("index.html is missing")
+	}
+	rendered := assetLink.ReplaceAllFunc(index.content, func(link []byte) []byte {
+		m := assetLink.FindSubmatch(link)
+		asset, ok := assets["/"+string(m[2])]
+		if !ok {
+			return link
+		}
+		return []byte(string(m[1]) + `="/` + string(m[2]) + "?v=" + asset.hash + `"`)
+	})
+	assets["/"] = newStaticAsset("index.html", rendered)
+	delete(assets, "/index.html")
+	return assets, nil
+}
+
+// staticDirSignature describes STATIC_DIR's files, so changes can be noticed without reading them.
+func staticDirSignature() string {
+	var signature strings.Builder
+	fs.WalkDir(os.DirFS(appConfig.StaticDir), ".", func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				fmt.Fprintf(&signature, "%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+			}
+		}
+		return nil
+	})
+	return signature.String()
+}
+
+// initStaticAssets loads the frontend, exiting if it has no index.html.
+func initStaticAssets() {
+	assets, err := loadStaticAssets(staticFS())
+	if err != nil {
+		log.Fatalf("Failed to load the frontend: %v", err)
+	}
+	staticAssets = assets
+	if appConfig.StaticDir != "" {
+		staticSignature = staticDirSignature()
+		staticCheckedAt = time.Now()
+		log.Printf("Serving the frontend from %s", appConfig.StaticDir)
+	}
+}
+
+// currentStaticAssets returns the loaded assets, first reloading STATIC_DIR if it changed.
+func currentStaticAssets() map[string]*staticAsset {
+	staticMutex.Lock()
+	defer staticMutex.Unlock()
+	if appConfig.StaticDir == "" || time.Since(staticCheckedAt) < staticRescanInterval {
+		return staticAssets
+	}
+	staticCheckedAt = time.Now()
+	if signature := staticDirSignature(); signature != staticSignature {
+		// A failed reload, e.g. halfway through a deployment, keeps serving the previous files
+		if assets, err := loadStaticAssets(staticFS()); err != nil {
+			log.Printf("Warning: failed to reload %s: %v", appConfig.StaticDir, err)
+		} else {
+			staticAssets, staticSignature = assets, signature
+		}
+	}
+	return staticAssets
+}
+
+// handleStatic serves the frontend: the page at /, its assets, and the page again for
+// client-side routes.
+func handleStatic(w http.ResponseWriter, r *http.Request) {
+	assets := currentStaticAssets()
+	asset, ok := assets[r.URL.Path]
+	if !ok {
+		if strings.HasPrefix(r.URL.Path, "/api/") || path.Ext(r.URL.Path) != "" {
+			writeError(w, "Not found", http.StatusNotFound)
+			return
+		}
+		asset = assets["/"]
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	etag := `"` + asset.hash + `"`
+	w.Header().Set("ETag", etag)
+	if asset != assets["/"] && r.URL.Query().Get("v") == asset.hash {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", asset.contentType)
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(asset.content)
+}
+
+func handleFaviconICO(w http.ResponseWriter, r *http.Request) {
+	// Redirect .ico requests to SVG favicon for better quality
+	http.Redirect(w, r, "/favicon.svg", http.StatusMovedPermanently)
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Stats are kept as one event per finished set, so learners can chart them over time.
+// The UserStats row still holds the totals recorded before events were kept (and the last
+// topic); the totals served by /api/user/stats are that row plus the sum of the events.
+
+// Groupings of the stats history
+var statsHistoryGroups = map[string]bool{"event": true, "day": true, "week": true, "month": true}
+
+// StatsEvent is the exercises, mistakes, hints and time (in seconds) of one finished set.
+type StatsEvent struct {
+	ID        string    `json:"-"`
+	OwnerID   string    `json:"-"`
+	TopicID   string    `json:"topic_id,omitempty"`
+	Exercises int       `json:"exercises"`
+	Mistakes  int       `json:"mistakes"`
+	Hints     int       `json:"hints"`
+	TimeSpent int       `json:"time_spent"`
+	CreatedAt time.Time `json:"created_at"`
+
+	IdempotencyKey string `json:"-"` // set by /api/user/stats/increment, so retries count once
+}
+
+// StatsHistoryRow is one line of the exported history: a single set, or the sets of a day,
+// week or month.
+type StatsHistoryRow struct {
+	Date      string  `json:"date"`
+	Sets      int     `json:"sets"`
+	Exercises int     `json:"exercises"`
+	Mistakes  int     `json:"mistakes"`
+	Hints     int     `json:"hints"`
+	TimeSpent int     `json:"time_spent"`
+	Accuracy  float64 `json:"accuracy"`
+}
+
+// TopicStats is the sum of an owner's finished sets in one topic.
+type TopicStats struct {
+	TopicID   string  `json:"topic_id"`
+	TopicName string  `json:"topic_name"`
+	Sets      int     `json:"sets"`
+	Exercises int     `json:"exercises"`
+	Mistakes  int     `json:"mistakes"`
+	Hints     int     `json:"hints"`
+	TimeSpent int     `json:"time_spent"`
+	Accuracy  float64 `json:"accuracy"`
+}
+
+// StatsIncrement is the body of POST /api/user/stats/increment: one finished set's deltas.
+type StatsIncrement struct {
+	IdempotencyKey string `json:"idempotency_key"`
+	TopicID        string `json:"topic_id"`
+	Exercises      int    `json:"exercises"`
+	Mistakes       int    `json:"mistakes"`
+	Hints          int    `json:"hints"`
+	TimeSpent      int    `json:"time_spent"`
+}
+
+const maxIdempotencyKeyLength = 100
+
+// Keys are looked up in a formula, so they are limited to characters that need no quoting
+var idempotencyKeyPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+var (
+	statsEventsMutex  sync.Mutex
+	memoryStatsEvents []*StatsEvent // with in-memory storage
+
+	// Serializes capping and recording sets, and the check for an idempotency key, on this instance
+	statsIncrementMutex sync.Mutex
+)
+
+func statsEventFromRecord(record *airtable.Record) *StatsEvent {
+	event := &StatsEvent{ID: record.ID}
+	if val, ok := record.Fields["OwnerID"].(string); ok {
+		event.OwnerID = val
+	}
+	if val, ok := record.Fields["TopicID"].(string); ok {
+		event.TopicID = val
+	}
+	if val, ok := record.Fields["Exercises"].(float64); ok {
+		event.Exercises = int(val)
+	}
+	if val, ok := record.Fields["Mistakes"].(float64); ok {
+		event.Mistakes = int(val)
+	}
+	if val, ok := record.Fields["Hints"].(float64); ok {
+		event.Hints = int(val)
+	}
+	if val, ok := record.Fields["TimeSpent"].(float64); ok {
+		event.TimeSpent = int(val)
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			event.CreatedAt = t
+		}
+	}
+	if val, ok := record.Fields["IdempotencyKey"].(string); ok {
+		event.IdempotencyKey = val
+	}
+	return event
+}
+
+func addStatsEvent(event *StatsEvent) error {
+	event.CreatedAt = time.Now().UTC()
+	if airtableBaseID == "" {
+		statsEventsMutex.Lock()
+		defer statsEventsMutex.Unlock()
+		c := *event
+		memoryStatsEvents = append(memoryStatsEvents, &c)
+		return nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, statsEventsTableName)
+	fields := map[string]any{
+		"OwnerID":   event.OwnerID,
+		"TopicID":   event.TopicID,
+		"Exercises": event.Exercises,
+		"Mistakes":  event.Mistakes,
+		"Hints":     event.Hints,
+		"TimeSpent": event.TimeSpent,
+		"CreatedAt": event.CreatedAt.Format(time.RFC3339),
+	}
+	if event.IdempotencyKey != "" {
+		fields["IdempotencyKey"] = event.IdempotencyKey
+	}
+	records := &airtable.Records{
+		Records: []*airtable.Record{{Fields: fields}},
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return fmt.Errorf("failed to create stats event in Airtable: %v", err)
+	}
+	if len(result.Records) > 0 {
+		event.ID = result.Records[0].ID
+	}
+	return nil
+}
+
+// getStatsEvents returns the owner's events in [from, to), oldest first. A zero from or
+// to leaves that end open.
+func getStatsEvents(ownerID string, from, to time.Time) ([]*StatsEvent, error) {
+	var events []*StatsEvent
+	if airtableBaseID == "" {
+		statsEventsMutex.Lock()
+		for _, event := range memoryStatsEvents {
+			if event.OwnerID == ownerID {
+				c := *event
+				events = append(events, &c)
+			}
+		}
+		statsEventsMutex.Unlock()
+	} else {
+		table := airtableClient.GetTable(airtableBaseID, statsEventsTableName)
+		records, err := getAllRecords(table.GetRecords().WithFilterFormula(fmt.Sprintf("{OwnerID} = '%s'", ownerID)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get stats events from Airtable: %v", err)
+		}
+		for _, record := range records.Records {
+			events = append(events, statsEventFromRecord(record))
+		}
+	}
+
+	inRange := events[:0]
+	for _, event := range events {
+		if (from.IsZero() || !event.CreatedAt.Before(from)) && (to.IsZero() || event.CreatedAt.Before(to)) {
+			inRange = append(inRange, event)
+		}
+	}
+	sort.Slice(inRange, func(i, j int) bool { return inRange[i].CreatedAt.Before(inRange[j].CreatedAt) })
+	return inRange, nil
+}
+
+// findStatsEvent returns the owner's event with the idempotency key, or nil if there is none.
+func findStatsEvent(ownerID, key string) (*StatsEvent, error) {
+	if airtableBaseID == "" {
+		statsEventsMutex.Lock()
+		defer statsEventsMutex.Unlock()
+		for _, event := range memoryStatsEvents {
+			if event.OwnerID == ownerID && event.IdempotencyKey == key {
+				c := *event
+				return &c, nil
+			}
+		}
+		return nil, nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, statsEventsTableName)
+	records, err := table.GetRecords().
+		WithFilterFormula(fmt.Sprintf("AND({OwnerID} = '%s', {IdempotencyKey} = '%s')", ownerID, key)).
+		MaxRecords(1).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats event from Airtable: %v", err)
+	}
+	if len(records.Records) == 0 {
+		return nil, nil
+	}
+	return statsEventFromRecord(records.Records[0]), nil
+}
+
+// latestStatsEvent returns the owner's newest event, or nil if there is none.
+func latestStatsEvent(ownerID string) (*StatsEvent, error) {
+	if airtableBaseID == "" {
+		statsEventsMutex.Lock()
+		defer statsEventsMutex.Unlock()
+		var latest *StatsEvent
+		for _, event := range memoryStatsEvents {
+			if event.OwnerID == ownerID && (latest == nil || event.CreatedAt.After(latest.CreatedAt)) {
+				latest = event
+			}
+		}
+		if latest == nil {
+			return nil, nil
+		}
+		c := *latest
+		return &c, nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, statsEventsTableName)
+	records, err := table.GetRecords().
+		WithFilterFormula(fmt.Sprintf("{OwnerID} = '%s'", ownerID)).
+		WithSort(struct {
+			FieldName string
+			Direction string
+		}{"CreatedAt", "desc"}).
+		MaxRecords(1).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats events from Airtable: %v", err)
+	}
+	if len(records.Records) == 0 {
+		return nil, nil
+	}
+	return statsEventFromRecord(records.Records[0]), nil
+}
+
+// reassignStatsEvents moves every event of one owner to another, when a guest signs in.
+func reassignStatsEvents(fromOwnerID, toOwnerID string) error {
+	if airtableBaseID == "" {
+		statsEventsMutex.Lock()
+		defer statsEventsMutex.Unlock()
+		for _, event := range memoryStatsEvents {
+			if event.OwnerID == fromOwnerID {
+				event.OwnerID = toOwnerID
+			}
+		}
+		return nil
+	}
+
+	events, err := getStatsEvents(fromOwnerID, time.Time{}, time.Time{})
+	if err != nil {
+		return err
+	}
+	table := airtableClient.GetTable(airtableBaseID, statsEventsTableName)
+	for start := 0; start < len(events); start += 10 {
+		records := &airtable.Records{}
+		for _, event := range events[start:min(start+10, len(events))] {
+			records.Records = append(records.Records, &airtable.Record{
+				ID:     event.ID,
+				Fields: map[string]any{"OwnerID": toOwnerID},
+			})
+		}
+		if _, err := table.UpdateRecords(records); err != nil {
+			return fmt.Errorf("failed to reassign stats events: %v", err)
+		}
+	}
+	return nil
+}
+
+// getStatsTotals returns the owner's UserStats row with the events added to its totals,
+// and the XP they earned. If the events can't be read (e.g. the StatsEvents table is
+// missing), the row alone is counted.
+func getStatsTotals(ownerID string) (*UserStats, XPProgress, error) {
+	stats, err := dataStore.GetUserStats(ownerID)
+	if err != nil {
+		return nil, XPProgress{}, err
+	}
+	events, err := getStatsEvents(ownerID, time.Time{}, time.Time{})
+	if err != nil {
+		log.Printf("Warning: failed to get stats events for %s: %v", ownerID, err)
+	}
+	xp := xpLevel(computeXP(stats, events))
+	for _, event := range events {
+		stats.TotalExercises += event.Exercises
+		stats.TotalMistakes += event.Mistakes
+		stats.TotalHints += event.Hints
+		stats.TotalTime += event.TimeSpent
+	}
+	return stats, xp, nil
+}
+
+// recordStats records a finished set as an event. If that fails, the set is added to the
+// UserStats row instead, so the totals stay right even without the StatsEvents table.
+func recordStats(event *StatsEvent) error {
+	err := addStatsEvent(event)
+	if err == nil {
+		return nil
+	}
+	log.Printf("Warning: failed to record stats event, adding it to the totals: %v", err)
+
+	stats, err := dataStore.GetUserStats(event.OwnerID)
+	if err != nil {
+		return err
+	}
+	stats.TotalExercises += event.Exercises
+	stats.TotalMistakes += event.Mistakes
+	stats.TotalHints += event.Hints
+	stats.TotalTime += event.TimeSpent
+	return dataStore.UpdateUserStats(stats)
+}
+
+// capStatsEvent limits a set to what the server saw, since sets feed the totals, XP and
+// public profiles: no more exercises than the owner answered since their previous set
+// (looking back at most currentSessionTTL), no more time than passed since then, and at most
+// maxAnswerWords mistakes and hints per exercise. It answers 422 if nothing was answered.
+func capStatsEvent(event *StatsEvent, now time.Time) error {
+	since := now.Add(-currentSessionTTL)
+	latest, err := latestStatsEvent(event.OwnerID)
+	if err != nil {
+		// Without the StatsEvents table sets go to the totals, so there is no previous one
+		log.Printf("Warning: failed to get the latest stats event for %s: %v", event.OwnerID, err)
+	}
+	if latest != nil && latest.CreatedAt.After(since) {
+		since = latest.CreatedAt
+	}
+
+	views, err := dataStore.GetUserExerciseViewsChangedSince(event.OwnerID, since)
+	if err != nil {
+		return err
+	}
+	answered := 0
+	for _, view := range views {
+		if view.LastViewed.After(since) {
+			answered++
+		}
+	}
+	if answered == 0 {
+		return errorWithStatus(http.StatusUnprocessableEntity, "No exercises were answered since the last set")
+	}
+	event.Exercises = min(event.Exercises, answered)
+	event.Mistakes = min(event.Mistakes, event.Exercises*maxAnswerWords)
+	event.Hints = min(event.Hints, event.Exercises*maxAnswerWords)
+	event.TimeSpent = min(event.TimeSpent, int(now.Sub(since).Seconds()))
+	return nil
+}
+
+// incrementStats records a finished set under an idempotency key, capped by capStatsEvent.
+// It returns false without recording anything if the owner already recorded a set with
+// that key.
+func incrementStats(event *StatsEvent, now time.Time) (bool, error) {
+	statsIncrementMutex.Lock()
+	defer statsIncrementMutex.Unlock()
+
+	existing, err := findStatsEvent(event.OwnerID, event.IdempotencyKey)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		return false, nil
+	}
+	if err := capStatsEvent(event, now); err != nil {
+		return false, err
+	}
+	return true, addStatsEvent(event)
+}
+
+// recordCappedStats records a finished set without an idempotency key, capped by
+// capStatsEvent, for the older POST /api/user/stats.
+func recordCappedStats(event *StatsEvent, now time.Time) error {
+	statsIncrementMutex.Lock()
+	defer statsIncrementMutex.Unlock()
+
+	if err := capStatsEvent(event, now); err != nil {
+		return err
+	}
+	return recordStats(event)
+}
+
+// Handle stats increments: POST /api/user/stats/increment adds a finished set's deltas to
+// the owner's stats and returns the new totals, like GET /api/user/stats. The key (in the
+// body or an Idempotency-Key header) makes retries and duplicate submissions count once:
+// a repeated key answers 200 with the current totals instead of 201.
+func handleUserStatsIncrement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ownerID := getProgressOwnerID(w, r)
+
+	var increment StatsIncrement
+	if err := json.NewDecoder(r.Body).Decode(&increment); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if increment.IdempotencyKey == "" {
+		increment.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	}
+	if len(increment.IdempotencyKey) > maxIdempotencyKeyLength || !idempotencyKeyPattern.MatchString(increment.IdempotencyKey) {
+		writeError(w, fmt.Sprintf("An idempotency key of up to %d letters, digits, '.', '_', ':' or '-' is required", maxIdempotencyKeyLength), http.StatusBadRequest)
+		return
+	}
+	if increment.Exercises < 0 || increment.Mistakes < 0 || increment.Hints < 0 || increment.TimeSpent < 0 {
+		writeError(w, "Invalid stats values", http.StatusBadRequest)
+		return
+	}
+
+	created, err := incrementStats(&StatsEvent{
+		OwnerID:        ownerID,
+		TopicID:        increment.TopicID,
+		Exercises:      increment.Exercises,
+		Mistakes:       increment.Mistakes,
+		Hints:          increment.Hints,
+		TimeSpent:      increment.TimeSpent,
+		IdempotencyKey: increment.IdempotencyKey,
+	}, time.Now())
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		writeStatusError(w, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error incrementing stats: %v", err)
+		writeError(w, "Failed to update user stats", http.StatusInternalServerError)
+		return
+	}
+
+	stats, xp, err := getStatsTotals(ownerID)
+	if err != nil {
+		writeError(w, "Failed to get user stats", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(struct {
+		*UserStats
+		XPProgress
+	}{stats, xp})
+}
+
+// statsPeriod returns the start of the period an event falls in, as a date.
+func statsPeriod(t time.Time, group string) string {
+	t = t.UTC()
+	switch group {
+	case "day":
+		return t.Format(analyticsDateLayout)
+	case "week":
+		// Weeks start on Monday
+		offset := (int(t.Weekday()) + 6) % 7
+		return t.AddDate(0, 0, -offset).Format(analyticsDateLayout)
+	case "month":
+		return t.Format("2006-01") + "-01"
+	default:
+		return t.Format(time.RFC3339)
+	}
+}
+
+// statsHistory turns events (oldest first) into rows, one per event or per period.
+func statsHistory(events []*StatsEvent, group string) []StatsHistoryRow {
+	rows := []StatsHistoryRow{}
+	for _, event := range events {
+		date := statsPeriod(event.CreatedAt, group)
+		if len(rows) == 0 || group == "event" || rows[len(rows)-1].Date != date {
+			rows = append(rows, StatsHistoryRow{Date: date})
+		}
+		row := &rows[len(rows)-1]
+		row.Sets++
+		row.Exercises += event.Exercises
+		row.Mistakes += event.Mistakes
+		row.Hints += event.Hints
+		row.TimeSpent += event.TimeSpent
+	}
+	for i := range rows {
+		rows[i].Accuracy = statsAccuracy(rows[i].Exercises, rows[i].Mistakes)
+	}
+	return rows
+}
+
+// statsAccuracy is the share of answers that were right first time.
+func statsAccuracy(exercises, mistakes int) float64 {
+	if exercises+mistakes == 0 {
+		return 0
+	}
+	return float64(exercises) / float64(exercises+mistakes)
+}
+
+// Handle the stats history: GET /api/user/stats/history returns the owner's finished sets,
+// optionally limited to ?from=2024-01-01&to=2024-06-30 and grouped by ?group=day|week|month,
+// as JSON or, with ?format=csv, as a CSV download.
+func handleUserStatsHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ownerID := getProgressOwnerID(w, r)
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		writeError(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+	group := query.Get("group")
+	if group == "" {
+		group = "event"
+	}
+	if !statsHistoryGroups[group] {
+		writeError(w, "group must be event, day, week or month", http.StatusBadRequest)
+		return
+	}
+	var from, to time.Time
+	if value := query.Get("from"); value != "" {
+		t, err := time.Parse(analyticsDateLayout, value)
+		if err != nil {
+			writeError(w, "from must be a date like 2024-01-31", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	if value := query.Get("to"); value != "" {
+		t, err := time.Parse(analyticsDateLayout, value)
+		if err != nil {
+			writeError(w, "to must be a date like 2024-01-31", http.StatusBadRequest)
+			return
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		writeError(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+
+	events, err := getStatsEvents(ownerID, from, to)
+	if err != nil {
+		log.Printf("Error getting stats history: %v", err)
+		writeError(w, "Failed to get stats history", http.StatusInternalServerError)
+		return
+	}
+	rows := statsHistory(events, group)
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"group": group, "history": rows})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="stats-history.csv"`)
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"date", "sets", "exercises", "mistakes", "hints", "time_spent", "accuracy"})
+	for _, row := range rows {
+		writer.Write([]string{
+			row.Date,
+			strconv.Itoa(row.Sets),
+			strconv.Itoa(row.Exercises),
+			strconv.Itoa(row.Mistakes),
+			strconv.Itoa(row.Hints),
+			strconv.Itoa(row.TimeSpent),
+			strconv.FormatFloat(row.Accuracy, 'f', 4, 64),
+		})
+	}
+	writer.Flush()
+}
+
+// getTopicStats sums the owner's stats events per topic, most time spent first. Sets
+// recorded without a topic, and totals from before events were kept, are left out.
+func getTopicStats(ownerID string) ([]*TopicStats, error) {
+	events, err := getStatsEvents(ownerID, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	topics, err := dataStore.GetAllTopics()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string)
+	for _, topic := range topics {
+		names[topic.ID] = topic.Name
+	}
+
+	byTopic := make(map[string]*TopicStats)
+	stats := []*TopicStats{}
+	for _, event := range events {
+		if event.TopicID == "" {
+			continue
+		}
+		s, ok := byTopic[event.TopicID]
+		if !ok {
+			s = &TopicStats{TopicID: event.TopicID, TopicName: names[event.TopicID]}
+			byTopic[event.TopicID] = s
+			stats = append(stats, s)
+		}
+		s.Sets++
+		s.Exercises += event.Exercises
+		s.Mistakes += event.Mistakes
+		s.Hints += event.Hints
+		s.TimeSpent += event.TimeSpent
+	}
+	for _, s := range stats {
+		s.Accuracy = statsAccuracy(s.Exercises, s.Mistakes)
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].TimeSpent > stats[j].TimeSpent })
+	return stats, nil
+}
+
+// Handle per-topic stats: GET /api/user/stats/topics lists the owner's stats for every
+// topic they practised, GET /api/user/stats/topics/{id} returns one topic's (zero if unpractised).
+func handleUserTopicStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ownerID := getProgressOwnerID(w, r)
+	topicID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/user/stats/topics"), "/")
+
+	stats, err := getTopicStats(ownerID)
+	if err != nil {
+		log.Printf("Error getting topic stats: %v", err)
+		writeError(w, "Failed to get topic stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if topicID == "" {
+		json.NewEncoder(w).Encode(map[string][]*TopicStats{"topics": stats})
+		return
+	}
+	for _, s := range stats {
+		if s.TopicID == topicID {
+			json.NewEncoder(w).Encode(s)
+			return
+		}
+	}
+	topic, err := dataStore.GetTopic(topicID)
+	if err != nil {
+		writeError(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(&TopicStats{TopicID: topic.ID, TopicName: topic.Name})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHandleUserStatsIncrement(t *testing.T) {
+	useMemoryStore(t)
+	user := createTestUser(t, "learner-google-id")
+	increment := `{"idempotency_key": "set-1", "exercises": 1000000, "mistakes": 3, "hints": 9223372036854775807, "time_spent": 9223372036854775807}`
+
+	if rec := serve(handleUserStatsIncrement, user, http.MethodPost, "/api/user/stats/increment", increment); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("nothing answered: status %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+
+	views := []*UserExerciseView{
+		{UserID: user.ID, ExerciseID: "recA", RepetitionCounter: 1, LastViewed: time.Now()},
+		{UserID: user.ID, ExerciseID: "recB", RepetitionCounter: 1, LastViewed: time.Now()},
+	}
+	if err := dataStore.UpdateUserExerciseViews(views); err != nil {
+		t.Fatal(err)
+	}
+	rec := serve(handleUserStatsIncrement, user, http.MethodPost, "/api/user/stats/increment", increment)
+	var stats UserStats
+	if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &stats) != nil {
+		t.Fatalf("increment: status %d, body %s", rec.Code, rec.Body)
+	}
+	// Only the two answered exercises count, and no more time than a day
+	if stats.TotalExercises != 2 || stats.TotalMistakes != 3 || stats.TotalHints != 2*maxAnswerWords || stats.TotalTime > int(currentSessionTTL.Seconds()) {
+		t.Errorf("totals %+v, want the set capped", stats)
+	}
+
+	if rec := serve(handleUserStatsIncrement, user, http.MethodPost, "/api/user/stats/increment", increment); rec.Code != http.StatusOK {
+		t.Errorf("repeated key: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := serve(handleUserStatsIncrement, user, http.MethodPost, "/api/user/stats/increment", `{"idempotency_key": "set-2", "exercises": 5}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("nothing answered since the last set: status %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	if rec := serve(handleUserStatsIncrement, user, http.MethodPost, "/api/user/stats/increment", `{"idempotency_key": "x') OR ('1", "exercises": 1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("key with quotes: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if events, _ := getStatsEvents(user.ID, time.Time{}, time.Time{}); len(events) != 1 {
+		t.Errorf("%d events recorded, want 1", len(events))
+	}
+}
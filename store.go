@@ -0,0 +1,1349 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Airtable data layer for topics, prompt versions, exercises, exercise views and users.
+// Feature-specific tables (sessions, classes, tokens, ...) keep their access code next
+// to their handlers.
+
+// Airtable configuration
+var (
+	airtableClient *airtable.Client
+	airtableBaseID string
+	topicsMutex    sync.RWMutex
+
+	// Table names
+	topicsTableName               = "Topics"
+	versionsTableName             = "PromptVersions"
+	usersTableName                = "Users"
+	userStatsTableName            = "UserStats"
+	exercisesTableName            = "Exercises"
+	userExerciseViewsTableName    = "UserExerciseViews"
+	sessionsTableName             = "Sessions"
+	achievementsTableName         = "Achievements"
+	userAchievementsTableName     = "UserAchievements"
+	notificationSettingsTableName = "NotificationSettings"
+	pushSubscriptionsTableName    = "PushSubscriptions"
+	apiTokensTableName            = "APITokens"
+	classesTableName              = "Classes"
+	classMembersTableName         = "ClassMembers"
+	assignmentsTableName          = "Assignments"
+	marketplaceListingsTableName  = "MarketplaceListings"
+	marketplaceRatingsTableName   = "MarketplaceRatings"
+	refinedPromptsTableName       = "RefinedPrompts"
+	featureFlagsTableName         = "FeatureFlags"
+	analyticsEventsTableName      = "AnalyticsEvents"
+	auditLogTableName             = "AuditLog"
+	currentSessionsTableName      = "CurrentSessions"
+	webhooksTableName             = "Webhooks"
+	statsEventsTableName          = "StatsEvents"
+	exerciseHintsTableName        = "ExerciseHints"
+	exerciseExplanationsTableName = "ExerciseExplanations"
+	hintTranslationsTableName     = "HintTranslations"
+	topicSuggestionsTableName     = "TopicSuggestions"
+	tenantsTableName              = "Tenants"
+	generationQuotasTableName     = "GenerationQuotas"
+	userAPIKeysTableName          = "UserAPIKeys"
+	googleTokensTableName         = "GoogleTokens"
+	deletedExercisesTableName     = "DeletedExercises"
+	exerciseImagesTableName       = "ExerciseImages"
+	conversationsTableName        = "Conversations"
+	writingErrorsTableName        = "WritingErrors"
+	challengesTableName           = "Challenges"
+	duelsTableName                = "Duels"
+	duelRatingsTableName          = "DuelRatings"
+	exerciseFlagsTableName        = "ExerciseFlags"
+	exerciseRetirementsTableName  = "ExerciseRetirements"
+)
+
+// Number of prompt versions kept per topic (PROMPT_VERSIONS_KEEP, 0 keeps all).
+// Pinned versions are kept in addition to these.
+var promptVersionsToKeep int
+
+// TopicStore stores topics and their prompt version history.
+type TopicStore interface {
+	InsertTopic(name, prompt, tenantID string) (*Topic, error)
+	GetAllTopics() ([]*Topic, error)
+	GetTopic(topicID string) (*Topic, error)
+	UpdateTopic(topicID, name, prompt string) (*Topic, error)
+	SetTopicArchived(topicID string, archived bool) (*Topic, error)
+	SetTopicRefinement(topicID string, disabled bool, metaPrompt string) (*Topic, error)
+	SetTopicVocabularyBand(topicID string, band int) (*Topic, error)
+	DeleteTopic(topicID string) error
+	GetVersions(topicID string) ([]*PromptVersion, error)
+	GetVersion(versionID string) (*PromptVersion, error)
+	AddPromptVersion(topicID, name, prompt string) error
+	SetVersionPinned(versionID string, pinned bool) (*PromptVersion, error)
+	SetVersionLabel(versionID, label string) (*PromptVersion, error)
+}
+
+// ExerciseStore stores cached exercises and each user's SRS view history.
+type ExerciseStore interface {
+	CreateExercise(topicID, promptHash, theme, exerciseJSON string) (*Exercise, error)
+	GetExercisesForTopic(topicID, promptHash string) ([]*Exercise, error)
+	GetExercise(exerciseID string) (*Exercise, error)
+	ListExercises(topicID string) ([]*Exercise, error)
+	DeleteExercises(exerciseIDs []string) error
+	ListExercisesChangedSince(since time.Time) ([]*Exercise, error)
+	GetUserExerciseViews(userID string) (map[string]*UserExerciseView, error)
+	GetUserExerciseViewsChangedSince(userID string, since time.Time) ([]*UserExerciseView, error)
+	ListUserExerciseViews() ([]*UserExerciseView, error)
+	UpdateUserExerciseViews(views []*UserExerciseView) error
+	DeleteUserExerciseViews(viewIDs []string) error
+}
+
+// UserStore stores user accounts and their aggregate stats.
+type UserStore interface {
+	GetUserByID(userID string) (*User, error)
+	GetUserByGoogleID(googleID string) (*User, error)
+	CreateUser(googleID string) (*User, error)
+	SetUserEmail(userID, email string) error
+	GetUserStats(userID string) (*UserStats, error)
+	UpdateUserStats(stats *UserStats) error
+	UpdateUserSetting(userID, lastTopicID string) error
+	DeleteUserStats(recordID string) error
+}
+
+type Store interface {
+	TopicStore
+	ExerciseStore
+	UserStore
+}
+
+// dataStore is the Airtable store, or an in-memory store when STORAGE=memory.
+var dataStore Store = airtableStore{}
+
+type airtableStore struct{}
+
+// createTopic creates a topic of the tenant ("" for the instance) and records its first
+// prompt version.
+func createTopic(name, prompt, tenantID string) (*Topic, error) {
+	topic, err := dataStore.InsertTopic(name, prompt, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create initial version
+	err = dataStore.AddPromptVersion(topic.ID, name, prompt)
+	if err != nil {
+		log.Printf("Warning: Failed to create initial version: %v", err)
+	}
+
+	return topic, nil
+}
+
+// getActiveTopics returns the topics of the request's tenant that have not been archived.
+func getActiveTopics(ctx context.Context) ([]*Topic, error) {
+	topics, err := tenantTopics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var active []*Topic
+	for _, topic := range topics {
+		if !topic.Archived {
+			active = append(active, topic)
+		}
+	}
+	return active, nil
+}
+
+// Initialize Airtable client
+func initStorage() {
+	promptVersionsToKeep = appConfig.PromptVersionsKeep
+
+	if appConfig.Storage == "memory" {
+		dataStore = newMemoryStore()
+		airtableClient = airtable.NewClient("")
+		log.Printf("⚠️  Using in-memory storage: topics, exercises and users are lost on restart, and features backed by other Airtable tables are unavailable")
+		return
+	}
+
+	airtableToken := appConfig.AirtableToken
+	airtableBaseID = appConfig.AirtableBaseID
+
+	if err := validateAirtableSchema(); err != nil {
+		log.Fatalf("Invalid Airtable schema: %v", err)
+	}
+
+	airtableClient = airtable.NewClient(airtableToken)
+	dataStore = newCachedTopicStore(dataStore)
+	initQueryStats()
+	log.Printf("Airtable integration initialized with base ID: %s", airtableBaseID)
+
+	// Verify and setup tables
+	err := setupAirtableTables()
+	if err != nil {
+		log.Printf("Warning: Could not setup Airtable tables: %v", err)
+	}
+
+	// Check permissions
+	checkAirtablePermissions()
+}
+
+// Setup Airtable tables if they don't exist or verify their structure
+func setupAirtableTables() error {
+	log.Printf("Setting up Airtable tables...")
+
+	// Try to create the tables using Airtable's API
+	err := createAirtableTables()
+	if err != nil {
+		log.Printf("Could not auto-create tables: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Create Airtable tables using the Metadata API
+func createAirtableTables() error {
+	// Note: Airtable's table creation via API requires Base Schema API access
+	// For now, we'll provide instructions for manual creation
+	logTableInstructions()
+
+	return fmt.Errorf("manual table creation required")
+}
+
+// Check Airtable permissions for all tables
+func checkAirtablePermissions() {
+	log.Printf("Checking Airtable permissions...")
+
+	for _, table := range airtableSchema {
+		checkTableAccess(table.Name, table.Required, table.Consequence)
+	}
+}
+
+func checkTableAccess(tableName string, required bool, consequence string) {
+	table := airtableClient.GetTable(airtableBaseID, tableName)
+	_, err := table.GetRecords().Do() // Check without max records for compatibility
+	recordTableAccess(tableName, required, err)
+
+	if err != nil {
+		prefix := "⚠️"
+		if required {
+			prefix = "❌"
+		}
+
+		if strings.Contains(err.Error(), "status 403") || strings.Contains(err.Error(), "INVALID_PERMISSIONS") {
+			log.Printf("%s No access to '%s' table. Check token permissions. %s", prefix, tableName, consequence)
+		} else if strings.Contains(err.Error(), "status 404") {
+			log.Printf("%s '%s' table not found. Please create it manually. %s", prefix, tableName, consequence)
+		} else {
+			log.Printf("⚠️  %s table access error: %v", tableName, err)
+		}
+	} else {
+		log.Printf("✅ %s table access: OK", tableName)
+	}
+}
+
+// Data access functions using Airtable
+
+// InsertTopic creates the topic record without recording a prompt version.
+func (s airtableStore) InsertTopic(name, prompt, tenantID string) (*Topic, error) {
+	table := airtableClient.GetTable(airtableBaseID, topicsTableName)
+	now := time.Now().Format(time.RFC3339)
+
+	// Try with timestamp fields first, fallback to just required fields
+	fields := map[string]any{
+		"Name":   name,
+		"Prompt": prompt,
+	}
+
+	// Try to add timestamp fields if they exist
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				Fields: map[string]any{
+					"Name":      name,
+					"Prompt":    prompt,
+					"CreatedAt": now,
+					"UpdatedAt": now,
+				},
+			},
+		},
+	}
+	// Only tenants' topics need the TenantID field
+	if tenantID != "" {
+		fields["TenantID"] = tenantID
+		records.Records[0].Fields["TenantID"] = tenantID
+	}
+
+	result, err := table.AddRecords(records)
+	if err != nil {
+		// If it failed due to unknown fields, try with minimal fields
+		if strings.Contains(err.Error(), "UNKNOWN_FIELD_NAME") {
+			log.Printf("Timestamp fields not found, creating with minimal fields")
+			records.Records[0].Fields = fields
+			result, err = table.AddRecords(records)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to create topic in Airtable: %v", err)
+		}
+	}
+
+	if len(result.Records) == 0 {
+		return nil, fmt.Errorf("no records returned from Airtable")
+	}
+
+	topic := &Topic{
+		ID:        result.Records[0].ID,
+		Name:      name,
+		Prompt:    prompt,
+		TenantID:  tenantID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	return topic, nil
+}
+
+func (s airtableStore) GetAllTopics() ([]*Topic, error) {
+	table := airtableClient.GetTable(airtableBaseID, topicsTableName)
+
+	records, err := table.GetRecords().Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get topics from Airtable: %v", err)
+	}
+
+	var topics []*Topic
+	for _, record := range records.Records {
+		topics = append(topics, topicFromRecord(record))
+	}
+
+	// Sort by creation time
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].CreatedAt.Before(topics[j].CreatedAt)
+	})
+
+	return topics, nil
+}
+
+func (s airtableStore) GetTopic(topicID string) (*Topic, error) {
+	table := airtableClient.GetTable(airtableBaseID, topicsTableName)
+
+	record, err := table.GetRecord(topicID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get topic from Airtable: %v", err)
+	}
+
+	return topicFromRecord(record), nil
+}
+
+func topicFromRecord(record *airtable.Record) *Topic {
+	topic := &Topic{
+		ID: record.ID,
+	}
+
+	if name, ok := record.Fields["Name"].(string); ok {
+		topic.Name = name
+	}
+	if prompt, ok := record.Fields["Prompt"].(string); ok {
+		topic.Prompt = prompt
+	}
+	if createdAt, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, createdAt); err == nil {
+			topic.CreatedAt = t
+		}
+	}
+	if updatedAt, ok := record.Fields["UpdatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, updatedAt); err == nil {
+			topic.UpdatedAt = t
+		}
+	}
+	if archived, ok := record.Fields["Archived"].(bool); ok {
+		topic.Archived = archived
+	}
+	if disabled, ok := record.Fields["RefinementDisabled"].(bool); ok {
+		topic.RefinementDisabled = disabled
+	}
+	if metaPrompt, ok := record.Fields["MetaPrompt"].(string); ok {
+		topic.MetaPrompt = metaPrompt
+	}
+	if band, ok := record.Fields["VocabularyBand"].(float64); ok {
+		topic.VocabularyBand = int(band)
+	}
+	if tenantID, ok := record.Fields["TenantID"].(string); ok {
+		topic.TenantID = tenantID
+	}
+
+	return topic
+}
+
+// SetTopicArchived archives or restores a topic. Archived topics keep their versions,
+// cached exercises and user progress but are hidden from the default topic listing.
+func (s airtableStore) SetTopicArchived(topicID string, archived bool) (*Topic, error) {
+	table := airtableClient.GetTable(airtableBaseID, topicsTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				ID: topicID,
+				Fields: map[string]any{
+					"Archived": archived,
+				},
+			},
+		},
+	}
+
+	result, err := table.UpdateRecordsPartial(records)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNKNOWN_FIELD_NAME") {
+			return nil, fmt.Errorf("the Topics table needs an 'Archived' checkbox field to archive topics")
+		}
+		return nil, fmt.Errorf("failed to update topic in Airtable: %v", err)
+	}
+	if len(result.Records) == 0 {
+		return nil, fmt.Errorf("no records returned from Airtable")
+	}
+
+	return topicFromRecord(result.Records[0]), nil
+}
+
+// SetTopicRefinement turns prompt refinement off for a topic, or sets a custom meta-prompt
+// (empty uses the default one).
+func (s airtableStore) SetTopicRefinement(topicID string, disabled bool, metaPrompt string) (*Topic, error) {
+	table := airtableClient.GetTable(airtableBaseID, topicsTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				ID: topicID,
+				Fields: map[string]any{
+					"RefinementDisabled": disabled,
+					"MetaPrompt":         metaPrompt,
+				},
+			},
+		},
+	}
+
+	result, err := table.UpdateRecordsPartial(records)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNKNOWN_FIELD_NAME") {
+			return nil, fmt.Errorf("the Topics table needs 'RefinementDisabled' (checkbox) and 'MetaPrompt' (long text) fields to change refinement settings")
+		}
+		return nil, fmt.Errorf("failed to update topic in Airtable: %v", err)
+	}
+	if len(result.Records) == 0 {
+		return nil, fmt.Errorf("no records returned from Airtable")
+	}
+
+	return topicFromRecord(result.Records[0]), nil
+}
+
+// SetTopicVocabularyBand limits a topic's generated sentences to the band most frequent
+// lemmas (0 for no limit).
+func (s airtableStore) SetTopicVocabularyBand(topicID string, band int) (*Topic, error) {
+	table := airtableClient.GetTable(airtableBaseID, topicsTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				ID:     topicID,
+				Fields: map[string]any{"VocabularyBand": band},
+			},
+		},
+	}
+
+	result, err := table.UpdateRecordsPartial(records)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNKNOWN_FIELD_NAME") {
+			return nil, fmt.Errorf("the Topics table needs a 'VocabularyBand' (number) field to set a vocabulary band")
+		}
+		return nil, fmt.Errorf("failed to update topic in Airtable: %v", err)
+	}
+	if len(result.Records) == 0 {
+		return nil, fmt.Errorf("no records returned from Airtable")
+	}
+
+	return topicFromRecord(result.Records[0]), nil
+}
+
+func (s airtableStore) UpdateTopic(topicID, name, prompt string) (*Topic, error) {
+	table := airtableClient.GetTable(airtableBaseID, topicsTableName)
+	now := time.Now().Format(time.RFC3339)
+
+	// First add the new version, with the name the topic will have
+	versionName := name
+	if versionName == "" {
+		if current, err := s.GetTopic(topicID); err == nil {
+			versionName = current.Name
+		}
+	}
+	err := s.AddPromptVersion(topicID, versionName, prompt)
+	if err != nil {
+		log.Printf("Warning: Failed to create version: %v", err)
+	}
+
+	// Clean up old versions, keeping the configured number plus pinned ones
+	versions, err := s.GetVersions(topicID)
+	if err == nil {
+		versionsTable := airtableClient.GetTable(airtableBaseID, versionsTableName)
+		oldVersions := versionsToPrune(versions, promptVersionsToKeep)
+		for start := 0; start < len(oldVersions); start += 10 {
+			var oldVersionIDs []string
+			for _, oldVersion := range oldVersions[start:min(start+10, len(oldVersions))] {
+				oldVersionIDs = append(oldVersionIDs, oldVersion.ID)
+			}
+			versionsTable.DeleteRecords(oldVersionIDs)
+		}
+	}
+
+	// Prepare fields for update
+	fields := map[string]any{
+		"Prompt":    prompt,
+		"UpdatedAt": now,
+	}
+	if name != "" {
+		fields["Name"] = name
+	}
+
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				ID:     topicID,
+				Fields: fields,
+			},
+		},
+	}
+
+	_, err = table.UpdateRecordsPartial(records)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNKNOWN_FIELD_NAME") {
+			log.Printf("UpdatedAt field not found, updating with minimal fields")
+			delete(fields, "UpdatedAt")
+			records.Records[0].Fields = fields
+			_, err = table.UpdateRecordsPartial(records)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to update topic in Airtable: %v", err)
+		}
+	}
+
+	return s.GetTopic(topicID)
+}
+
+func (s airtableStore) DeleteTopic(topicID string) error {
+	// First delete all versions for this topic
+	versions, err := s.GetVersions(topicID)
+	if err == nil && len(versions) > 0 {
+		versionsTable := airtableClient.GetTable(airtableBaseID, versionsTableName)
+		var versionIDs []string
+		for _, version := range versions {
+			versionIDs = append(versionIDs, version.ID)
+		}
+		versionsTable.DeleteRecords(versionIDs)
+	}
+
+	// Then delete the topic
+	table := airtableClient.GetTable(airtableBaseID, topicsTableName)
+	_, err = table.DeleteRecords([]string{topicID})
+	if err != nil {
+		return fmt.Errorf("failed to delete topic from Airtable: %v", err)
+	}
+
+	return nil
+}
+
+func (s airtableStore) GetVersions(topicID string) ([]*PromptVersion, error) {
+	table := airtableClient.GetTable(airtableBaseID, versionsTableName)
+
+	records, err := table.GetRecords().
+		WithFilterFormula(fmt.Sprintf("{TopicID} = '%s'", topicID)).
+		Do()
+
+	if err != nil {
+		// Check for permission errors
+		if strings.Contains(err.Error(), "status 403") || strings.Contains(err.Error(), "INVALID_PERMISSIONS") {
+			log.Printf("No read access to PromptVersions table. Version history unavailable.")
+			return []*PromptVersion{}, nil // Return empty slice instead of error
+		}
+		return nil, fmt.Errorf("failed to get versions from Airtable: %v", err)
+	}
+
+	var versions []*PromptVersion
+	for _, record := range records.Records {
+		versions = append(versions, versionFromRecord(record))
+	}
+
+	// Sort by version number
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+
+	return versions, nil
+}
+
+func (s airtableStore) GetVersion(versionID string) (*PromptVersion, error) {
+	table := airtableClient.GetTable(airtableBaseID, versionsTableName)
+
+	record, err := table.GetRecord(versionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version from Airtable: %v", err)
+	}
+
+	return versionFromRecord(record), nil
+}
+
+func versionFromRecord(record *airtable.Record) *PromptVersion {
+	version := &PromptVersion{
+		ID: record.ID,
+	}
+
+	if topicID, ok := record.Fields["TopicID"].(string); ok {
+		version.TopicID = topicID
+	}
+	if name, ok := record.Fields["Name"].(string); ok {
+		version.Name = name
+	}
+	if prompt, ok := record.Fields["Prompt"].(string); ok {
+		version.Prompt = prompt
+	}
+	if versionNum, ok := record.Fields["Version"].(float64); ok {
+		version.Version = int(versionNum)
+	}
+	if pinned, ok := record.Fields["Pinned"].(bool); ok {
+		version.Pinned = pinned
+	}
+	if label, ok := record.Fields["Label"].(string); ok {
+		version.Label = label
+	}
+	if createdAt, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, createdAt); err == nil {
+			version.CreatedAt = t
+		}
+	}
+	return version
+}
+
+// SetVersionPinned pins or unpins a prompt version. Pinned versions are never pruned.
+func (s airtableStore) SetVersionPinned(versionID string, pinned bool) (*PromptVersion, error) {
+	table := airtableClient.GetTable(airtableBaseID, versionsTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				ID: versionID,
+				Fields: map[string]any{
+					"Pinned": pinned,
+				},
+			},
+		},
+	}
+
+	if _, err := table.UpdateRecordsPartial(records); err != nil {
+		if strings.Contains(err.Error(), "UNKNOWN_FIELD_NAME") {
+			return nil, fmt.Errorf("the PromptVersions table needs a 'Pinned' checkbox field to pin versions")
+		}
+		return nil, fmt.Errorf("failed to update version in Airtable: %v", err)
+	}
+	return s.GetVersion(versionID)
+}
+
+// SetVersionLabel names a prompt version; an empty label removes the name.
+func (s airtableStore) SetVersionLabel(versionID, label string) (*PromptVersion, error) {
+	table := airtableClient.GetTable(airtableBaseID, versionsTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				ID: versionID,
+				Fields: map[string]any{
+					"Label": label,
+				},
+			},
+		},
+	}
+
+	if _, err := table.UpdateRecordsPartial(records); err != nil {
+		if strings.Contains(err.Error(), "UNKNOWN_FIELD_NAME") {
+			return nil, fmt.Errorf("the PromptVersions table needs a 'Label' text field to label versions")
+		}
+		return nil, fmt.Errorf("failed to update version in Airtable: %v", err)
+	}
+	return s.GetVersion(versionID)
+}
+
+// versionsToPrune returns the versions (sorted oldest first) that fall outside the newest
+// keep versions and are not pinned. keep 0 keeps every version.
+func versionsToPrune(versions []*PromptVersion, keep int) []*PromptVersion {
+	if keep == 0 || len(versions) <= keep {
+		return nil
+	}
+	var prune []*PromptVersion
+	for _, version := range versions[:len(versions)-keep] {
+		if !version.Pinned {
+			prune = append(prune, version)
+		}
+	}
+	return prune
+}
+
+// AddPromptVersion records the topic's prompt, and its name at the time, as the next version.
+func (s airtableStore) AddPromptVersion(topicID, name, prompt string) error {
+	// Get existing versions to determine next version number
+	versions, err := s.GetVersions(topicID)
+	if err != nil {
+		// Check if it's a permission error
+		if strings.Contains(err.Error(), "status 403") || strings.Contains(err.Error(), "INVALID_PERMISSIONS") {
+			log.Printf("No access to PromptVersions table. Please check Airtable token permissions.")
+			log.Printf("The token needs read/write access to both 'Topics' and 'PromptVersions' tables.")
+			return nil // Don't fail the topic creation due to version permission issues
+		}
+		if !strings.Contains(err.Error(), "status 404") {
+			return err
+		}
+	}
+
+	nextVersion := 1
+	if len(versions) > 0 {
+		nextVersion = versions[len(versions)-1].Version + 1
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, versionsTableName)
+	now := time.Now().Format(time.RFC3339)
+
+	// Try with the name and timestamp fields first, fallback to fewer fields
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				Fields: map[string]any{
+					"TopicID":   topicID,
+					"Name":      name,
+					"Prompt":    prompt,
+					"Version":   nextVersion,
+					"CreatedAt": now,
+				},
+			},
+		},
+	}
+
+	_, err = table.AddRecords(records)
+	if err != nil {
+		// Check for permission errors
+		if strings.Contains(err.Error(), "status 403") || strings.Contains(err.Error(), "INVALID_PERMISSIONS") {
+			log.Printf("No write access to PromptVersions table. Skipping version creation.")
+			return nil // Don't fail the topic creation
+		}
+
+		// If it failed due to unknown fields, retry without the optional ones
+		fields := records.Records[0].Fields
+		for _, missing := range [][]string{{"Name"}, {"CreatedAt"}, {"Name", "CreatedAt"}} {
+			if err == nil || !strings.Contains(err.Error(), "UNKNOWN_FIELD_NAME") {
+				break
+			}
+			log.Printf("Retrying PromptVersions record without %s", strings.Join(missing, " and "))
+			records.Records[0].Fields = maps.Clone(fields)
+			for _, field := range missing {
+				delete(records.Records[0].Fields, field)
+			}
+			_, err = table.AddRecords(records)
+		}
+
+		if err != nil {
+			// Final check for permissions before failing
+			if strings.Contains(err.Error(), "status 403") || strings.Contains(err.Error(), "INVALID__PERMISSIONS") {
+				log.Printf("Cannot create version due to permissions. Continuing without version tracking.")
+				return nil
+			}
+			return fmt.Errorf("failed to create version in Airtable: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// getAllRecords fetches every page of a query. Airtable returns at most 100 records per request.
+func getAllRecords(query *airtable.GetRecordsConfig) (*airtable.Records, error) {
+	all := &airtable.Records{}
+	for {
+		page, err := query.Do()
+		if err != nil {
+			return nil, err
+		}
+		all.Records = append(all.Records, page.Records...)
+		if page.Offset == "" {
+			return all, nil
+		}
+		query = query.WithOffset(page.Offset)
+	}
+}
+
+func (s airtableStore) CreateExercise(topicID, promptHash, theme, exerciseJSON string) (*Exercise, error) {
+	table := airtableClient.GetTable(airtableBaseID, exercisesTableName)
+	fields := map[string]any{
+		"TopicID":      topicID,
+		"PromptHash":   promptHash,
+		"ExerciseJSON": exerciseJSON,
+	}
+	if theme != "" {
+		fields["Theme"] = theme
+	}
+	content, contentErr := parseExerciseContent(exerciseJSON)
+	if contentErr == nil {
+		for name, value := range exerciseContentFields(content) {
+			fields[name] = value
+		}
+	}
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				Fields: fields,
+			},
+		},
+	}
+
+	result, err := table.AddRecords(records)
+	if err != nil {
+		// If the Theme or typed columns are missing, store the exercise without them
+		if strings.Contains(err.Error(), "UNKNOWN_FIELD_NAME") && len(fields) > 3 {
+			log.Printf("Optional fields not found in Exercises, creating with minimal fields")
+			records.Records[0].Fields = map[string]any{
+				"TopicID":      topicID,
+				"PromptHash":   promptHash,
+				"ExerciseJSON": exerciseJSON,
+			}
+			result, err = table.AddRecords(records)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to create exercise in Airtable: %v", err)
+		}
+	}
+
+	if len(result.Records) == 0 {
+		return nil, fmt.Errorf("no records returned from Airtable")
+	}
+
+	rec := result.Records[0]
+	exercise := &Exercise{
+		ID:           rec.ID,
+		AirtableID:   rec.ID,
+		TopicID:      topicID,
+		PromptHash:   promptHash,
+		Theme:        theme,
+		ExerciseJSON: exerciseJSON,
+		CreatedAt:    time.Now(), // Approximate, actual time is on Airtable
+	}
+	if contentErr == nil {
+		exercise.setContent(content)
+	}
+	return exercise, nil
+}
+
+func (s airtableStore) GetExercisesForTopic(topicID, promptHash string) ([]*Exercise, error) {
+	table := airtableClient.GetTable(airtableBaseID, exercisesTableName)
+	formula := fmt.Sprintf("AND({TopicID} = '%s', {PromptHash} = '%s')", topicID, promptHash)
+
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(formula))
+	if err != nil {
+		if strings.Contains(err.Error(), "NOT_FOUND") {
+			return []*Exercise{}, nil // Return empty slice if table not found
+		}
+		return nil, fmt.Errorf("failed to get exercises from Airtable: %v", err)
+	}
+
+	var exercises []*Exercise
+	for _, record := range records.Records {
+		exercises = append(exercises, exerciseFromRecord(record))
+	}
+	return exercises, nil
+}
+
+func (s airtableStore) GetExercise(exerciseID string) (*Exercise, error) {
+	table := airtableClient.GetTable(airtableBaseID, exercisesTableName)
+	record, err := table.GetRecord(exerciseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exercise from Airtable: %v", err)
+	}
+	return exerciseFromRecord(record), nil
+}
+
+// ListExercises returns every cached exercise of a topic, or of all topics when topicID is empty.
+func (s airtableStore) ListExercises(topicID string) ([]*Exercise, error) {
+	table := airtableClient.GetTable(airtableBaseID, exercisesTableName)
+	query := table.GetRecords()
+	if topicID != "" {
+		query = query.WithFilterFormula(fmt.Sprintf("{TopicID} = '%s'", topicID))
+	}
+
+	records, err := getAllRecords(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list exercises from Airtable: %v", err)
+	}
+
+	var exercises []*Exercise
+	for _, record := range records.Records {
+		exercises = append(exercises, exerciseFromRecord(record))
+	}
+	return exercises, nil
+}
+
+// changedSinceFormula matches records created or edited after since.
+func changedSinceFormula(since time.Time) string {
+	ts := since.UTC().Format(time.RFC3339)
+	return fmt.Sprintf("OR(IS_AFTER(CREATED_TIME(), '%s'), IS_AFTER(LAST_MODIFIED_TIME(), '%s'))", ts, ts)
+}
+
+// ListExercisesChangedSince returns the exercises cached or edited after since.
+func (s airtableStore) ListExercisesChangedSince(since time.Time) ([]*Exercise, error) {
+	table := airtableClient.GetTable(airtableBaseID, exercisesTableName)
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(changedSinceFormula(since)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changed exercises from Airtable: %v", err)
+	}
+
+	var exercises []*Exercise
+	for _, record := range records.Records {
+		exercises = append(exercises, exerciseFromRecord(record))
+	}
+	return exercises, nil
+}
+
+func exerciseFromRecord(record *airtable.Record) *Exercise {
+	exercise := &Exercise{
+		ID:         record.ID,
+		AirtableID: record.ID,
+	}
+	if val, ok := record.Fields["TopicID"].(string); ok {
+		exercise.TopicID = val
+	}
+	if val, ok := record.Fields["PromptHash"].(string); ok {
+		exercise.PromptHash = val
+	}
+	if val, ok := record.Fields["Theme"].(string); ok {
+		exercise.Theme = val
+	}
+	if val, ok := record.Fields["ExerciseJSON"].(string); ok {
+		exercise.ExerciseJSON = val
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			exercise.CreatedAt = t
+		}
+	}
+	if val, ok := record.Fields["Sentence"].(string); ok {
+		exercise.Sentence = val
+	}
+	if val, ok := record.Fields["TranslationHint"].(string); ok {
+		exercise.TranslationHint = val
+	}
+	if val, ok := record.Fields["Tokens"].(string); ok {
+		exercise.Tokens = strings.Fields(val)
+	}
+	if val, ok := record.Fields["Conjunction"].(string); ok {
+		exercise.Conjunction = val
+	}
+	// Exercises cached before the typed columns existed are parsed from their JSON
+	if exercise.Sentence == "" {
+		if content, err := parseExerciseContent(exercise.ExerciseJSON); err == nil {
+			exercise.setContent(content)
+		}
+	}
+	return exercise
+}
+
+func (s airtableStore) GetUserExerciseViews(userID string) (map[string]*UserExerciseView, error) {
+	table := airtableClient.GetTable(airtableBaseID, userExerciseViewsTableName)
+	formula := fmt.Sprintf("{UserID} = '%s'", userID)
+
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(formula))
+	if err != nil {
+		if strings.Contains(err.Error(), "NOT_FOUND") {
+			return make(map[string]*UserExerciseView), nil // Return empty map if table not found
+		}
+		return nil, fmt.Errorf("failed to get user exercise views from Airtable: %v", err)
+	}
+
+	views := make(map[string]*UserExerciseView)
+	for _, record := range records.Records {
+		view := viewFromRecord(record)
+		views[view.ExerciseID] = view
+	}
+	return views, nil
+}
+
+// GetUserExerciseViewsChangedSince returns the user's views created or updated after since.
+func (s airtableStore) GetUserExerciseViewsChangedSince(userID string, since time.Time) ([]*UserExerciseView, error) {
+	table := airtableClient.GetTable(airtableBaseID, userExerciseViewsTableName)
+	formula := fmt.Sprintf("AND({UserID} = '%s', %s)", userID, changedSinceFormula(since))
+
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(formula))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed user exercise views from Airtable: %v", err)
+	}
+
+	var views []*UserExerciseView
+	for _, record := range records.Records {
+		views = append(views, viewFromRecord(record))
+	}
+	return views, nil
+}
+
+// ListUserExerciseViews returns every user's views, for reports across learners.
+func (s airtableStore) ListUserExerciseViews() ([]*UserExerciseView, error) {
+	table := airtableClient.GetTable(airtableBaseID, userExerciseViewsTableName)
+	records, err := getAllRecords(table.GetRecords())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user exercise views from Airtable: %v", err)
+	}
+
+	var views []*UserExerciseView
+	for _, record := range records.Records {
+		views = append(views, viewFromRecord(record))
+	}
+	return views, nil
+}
+
+func viewFromRecord(record *airtable.Record) *UserExerciseView {
+	view := &UserExerciseView{
+		AirtableID: record.ID,
+	}
+	if t, err := time.Parse(time.RFC3339, record.CreatedTime); err == nil {
+		view.CreatedAt = t
+	}
+	if val, ok := record.Fields["UserID"].(string); ok {
+		view.UserID = val
+	}
+	if val, ok := record.Fields["ExerciseID"].(string); ok {
+		view.ExerciseID = val
+	}
+	if val, ok := record.Fields["LastViewed"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			view.LastViewed = t
+		}
+	}
+	if val, ok := record.Fields["RepetitionCounter"].(float64); ok {
+		view.RepetitionCounter = int(val)
+	}
+	if val, ok := record.Fields["Grade"].(string); ok {
+		view.Grade = val
+	}
+	return view
+}
+
+func (s airtableStore) UpdateUserExerciseViews(viewsToUpdate []*UserExerciseView) error {
+	table := airtableClient.GetTable(airtableBaseID, userExerciseViewsTableName)
+	var recordsToCreate []*airtable.Record
+	var recordsToUpdate []*airtable.Record
+
+	for _, view := range viewsToUpdate {
+		fields := map[string]any{
+			"UserID":            view.UserID,
+			"ExerciseID":        view.ExerciseID,
+			"LastViewed":        view.LastViewed.Format(time.RFC3339),
+			"RepetitionCounter": view.RepetitionCounter,
+			"Grade":             view.Grade,
+		}
+		if view.AirtableID == "" {
+			recordsToCreate = append(recordsToCreate, &airtable.Record{Fields: fields})
+		} else {
+			recordsToUpdate = append(recordsToUpdate, &airtable.Record{ID: view.AirtableID, Fields: fields})
+		}
+	}
+
+	if len(recordsToCreate) > 0 {
+		_, err := table.AddRecords(&airtable.Records{Records: recordsToCreate})
+		if isUnknownGradeField(err) {
+			withoutGrade(recordsToCreate)
+			_, err = table.AddRecords(&airtable.Records{Records: recordsToCreate})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create user exercise views: %v", err)
+		}
+	}
+	if len(recordsToUpdate) > 0 {
+		_, err := table.UpdateRecords(&airtable.Records{Records: recordsToUpdate})
+		if isUnknownGradeField(err) {
+			withoutGrade(recordsToUpdate)
+			_, err = table.UpdateRecords(&airtable.Records{Records: recordsToUpdate})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update user exercise views: %v", err)
+		}
+	}
+	return nil
+}
+
+// The Grade column is optional: without it views are stored ungraded and scheduled as "good".
+func isUnknownGradeField(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNKNOWN_FIELD_NAME")
+}
+
+func withoutGrade(records []*airtable.Record) {
+	log.Printf("Grade field not found in UserExerciseViews, saving views without grades")
+	for _, record := range records {
+		delete(record.Fields, "Grade")
+	}
+}
+
+func (s airtableStore) DeleteExercises(exerciseIDs []string) error {
+	table := airtableClient.GetTable(airtableBaseID, exercisesTableName)
+	for start := 0; start < len(exerciseIDs); start += 10 {
+		if _, err := table.DeleteRecords(exerciseIDs[start:min(start+10, len(exerciseIDs))]); err != nil {
+			return fmt.Errorf("failed to delete exercises: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s airtableStore) DeleteUserExerciseViews(viewIDs []string) error {
+	table := airtableClient.GetTable(airtableBaseID, userExerciseViewsTableName)
+	for start := 0; start < len(viewIDs); start += 10 {
+		if _, err := table.DeleteRecords(viewIDs[start:min(start+10, len(viewIDs))]); err != nil {
+			return fmt.Errorf("failed to delete user exercise views: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s airtableStore) GetUserByGoogleID(googleID string) (*User, error) {
+	table := airtableClient.GetTable(airtableBaseID, usersTableName)
+	records, err := table.GetRecords().WithFilterFormula(fmt.Sprintf("{GoogleID} = '%s'", googleID)).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records.Records) == 0 {
+		return nil, nil // Not found
+	}
+
+	return userFromRecord(records.Records[0]), nil
+}
+
+func (s airtableStore) CreateUser(googleID string) (*User, error) {
+	table := airtableClient.GetTable(airtableBaseID, usersTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				Fields: map[string]any{
+					"GoogleID": googleID,
+				},
+			},
+		},
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return nil, err
+	}
+
+	return userFromRecord(result.Records[0]), nil
+}
+
+func (s airtableStore) GetUserStats(userID string) (*UserStats, error) {
+	table := airtableClient.GetTable(airtableBaseID, userStatsTableName)
+	records, err := table.GetRecords().WithFilterFormula(fmt.Sprintf("{UserID} = '%s'", userID)).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records.Records) == 0 {
+		return &UserStats{UserID: userID}, nil // Return empty stats if not found
+	}
+
+	record := records.Records[0]
+	stats := &UserStats{
+		UserID:           userID,
+		AirtableRecordID: record.ID,
+	}
+
+	if val, ok := record.Fields["TotalExercises"].(float64); ok {
+		stats.TotalExercises = int(val)
+	}
+	if val, ok := record.Fields["TotalMistakes"].(float64); ok {
+		stats.TotalMistakes = int(val)
+	}
+	if val, ok := record.Fields["TotalHints"].(float64); ok {
+		stats.TotalHints = int(val)
+	}
+	if val, ok := record.Fields["TotalTime"].(float64); ok {
+		stats.TotalTime = int(val)
+	}
+	if val, ok := record.Fields["LastTopicID"].(string); ok {
+		stats.LastTopicID = val
+	}
+
+	return stats, nil
+}
+
+func (s airtableStore) UpdateUserStats(stats *UserStats) error {
+	table := airtableClient.GetTable(airtableBaseID, userStatsTableName)
+	fields := map[string]any{
+		"UserID":         stats.UserID,
+		"TotalExercises": stats.TotalExercises,
+		"TotalMistakes":  stats.TotalMistakes,
+		"TotalHints":     stats.TotalHints,
+		"TotalTime":      stats.TotalTime,
+	}
+
+	if stats.AirtableRecordID != "" {
+		// Update existing record
+		records := &airtable.Records{
+			Records: []*airtable.Record{
+				{
+					ID:     stats.AirtableRecordID,
+					Fields: fields,
+				},
+			},
+		}
+		_, err := table.UpdateRecords(records)
+		return err
+	}
+
+	// Create new record
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				Fields: fields,
+			},
+		},
+	}
+	_, err := table.AddRecords(records)
+	return err
+}
+
+func (s airtableStore) DeleteUserStats(recordID string) error {
+	table := airtableClient.GetTable(airtableBaseID, userStatsTableName)
+	if _, err := table.DeleteRecords([]string{recordID}); err != nil {
+		return fmt.Errorf("failed to delete user stats: %v", err)
+	}
+	return nil
+}
+
+func (s airtableStore) UpdateUserSetting(userID, lastTopicID string) error {
+	stats, err := s.GetUserStats(userID)
+	if err != nil {
+		return err
+	}
+
+	stats.LastTopicID = lastTopicID
+
+	table := airtableClient.GetTable(airtableBaseID, userStatsTableName)
+	fields := map[string]any{
+		"UserID":      userID,
+		"LastTopicID": lastTopicID,
+	}
+
+	if stats.AirtableRecordID != "" {
+		// Update existing record
+		records := &airtable.Records{
+			Records: []*airtable.Record{
+				{
+					ID:     stats.AirtableRecordID,
+					Fields: fields,
+				},
+			},
+		}
+		_, err := table.UpdateRecords(records)
+		return err
+	}
+
+	// Create new record
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				Fields: fields,
+			},
+		},
+	}
+	_, err = table.AddRecords(records)
+	return err
+}
+
+func (s airtableStore) GetUserByID(userID string) (*User, error) {
+	table := airtableClient.GetTable(airtableBaseID, usersTableName)
+	record, err := table.GetRecord(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if record == nil {
+		return nil, nil // Not found
+	}
+
+	return userFromRecord(record), nil
+}
+
+func userFromRecord(record *airtable.Record) *User {
+	user := &User{
+		ID:         record.ID,
+		AirtableID: record.ID,
+	}
+	if val, ok := record.Fields["GoogleID"].(string); ok {
+		user.GoogleID = val
+	}
+	if val, ok := record.Fields["Email"].(string); ok {
+		user.Email = val
+	}
+	if val, ok := record.Fields["DisplayName"].(string); ok {
+		user.DisplayName = val
+	}
+	if val, ok := record.Fields["LeaderboardOptIn"].(bool); ok {
+		user.LeaderboardOptIn = val
+	}
+	if val, ok := record.Fields["LeaderboardAnonymous"].(bool); ok {
+		user.LeaderboardAnonymous = val
+	}
+	if val, ok := record.Fields["DailyNewLimit"].(float64); ok {
+		limit := int(val)
+		user.DailyNewLimit = &limit
+	}
+	if val, ok := record.Fields["DailyReviewLimit"].(float64); ok {
+		limit := int(val)
+		user.DailyReviewLimit = &limit
+	}
+	if val, ok := record.Fields["NativeLanguage"].(string); ok {
+		user.NativeLanguage = val
+	}
+	if val, ok := record.Fields["Distractors"].(bool); ok {
+		user.Distractors = val
+	}
+	if val, ok := record.Fields["Difficulty"].(string); ok {
+		user.Difficulty = val
+	}
+	if val, ok := record.Fields["CalendarToken"].(string); ok {
+		user.CalendarToken = val
+	}
+	if val, ok := record.Fields["MagicLinkNonce"].(string); ok {
+		user.MagicLinkNonce = val
+	}
+	if val, ok := record.Fields["MagicLinkExpires"].(string); ok {
+		user.MagicLinkExpires, _ = time.Parse(time.RFC3339, val)
+	}
+	if val, ok := record.Fields["PublicProfile"].(bool); ok {
+		user.PublicProfile = val
+	}
+	if val, ok := record.Fields["PublicSlug"].(string); ok {
+		user.PublicSlug = val
+	}
+	return user
+}
+
+// SetUserEmail stores the user's email address, used for notifications.
+func (s airtableStore) SetUserEmail(userID, email string) error {
+	table := airtableClient.GetTable(airtableBaseID, usersTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				ID: userID,
+				Fields: map[string]any{
+					"Email": email,
+				},
+			},
+		},
+	}
+	if _, err := table.UpdateRecordsPartial(records); err != nil {
+		if strings.Contains(err.Error(), "UNKNOWN_FIELD_NAME") {
+			return nil // Email field is optional
+		}
+		return fmt.Errorf("failed to update user email in Airtable: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Offline sync lets the web app practise without a connection, e.g. on the subway. GET
+// /api/sync/pull downloads the exercises due over the next days; POST /api/sync/push later
+// uploads the answers with the time they were given on the device. Pushed answers are
+// applied in answer order, and the last write wins: an answer older than the exercise's last
+// answer on the server (given online, or on another device) is skipped as stale. Pushing the
+// same answers again changes nothing, so a client can retry a push that timed out. Only
+// cached exercises are pulled; nothing is generated.
+const (
+	defaultSyncCount = 50
+	maxSyncCount     = 200
+	defaultSyncDays  = 1
+	maxSyncDays      = 14
+	maxSyncResults   = 500
+	maxSyncClockSkew = 5 * time.Minute // answers from further in the future are dated now
+
+	syncApplied  = "applied"
+	syncStale    = "stale"
+	syncNotFound = "not_found"
+)
+
+// SyncExercise is a pulled exercise. DueAt is unset for exercises the user has never answered.
+type SyncExercise struct {
+	TopicID  string          `json:"topic_id"`
+	DueAt    *time.Time      `json:"due_at,omitempty"`
+	Exercise json.RawMessage `json:"exercise"`
+}
+
+// SyncPull is what GET /api/sync/pull returns.
+type SyncPull struct {
+	ServerTime time.Time       `json:"server_time"`
+	DueUntil   time.Time       `json:"due_until"`
+	Exercises  []*SyncExercise `json:"exercises"`
+}
+
+// SyncResult is an answer given offline. AnsweredAt is the device's time of the answer.
+type SyncResult struct {
+	ReviewGrade
+	AnsweredAt time.Time `json:"answered_at"`
+}
+
+type SyncPushRequest struct {
+	Results []SyncResult `json:"results"`
+}
+
+// SyncPushResult is what became of a pushed answer and, if it was applied, the exercise's
+// schedule after the push.
+type SyncPushResult struct {
+	ExerciseID        string     `json:"exercise_id"`
+	AnsweredAt        time.Time  `json:"answered_at"`
+	Status            string     `json:"status"`
+	Grade             string     `json:"grade,omitempty"`
+	RepetitionCounter int        `json:"repetition_counter,omitempty"`
+	NextReview        *time.Time `json:"next_review,omitempty"`
+}
+
+// syncTopics returns the topics to pull: the requested ones, or every active topic.
+func syncTopics(ctx context.Context, topicIDs []string) ([]*Topic, error) {
+	if len(topicIDs) == 0 {
+		return getActiveTopics(ctx)
+	}
+	var topics []*Topic
+	for _, id := range topicIDs {
+		topic, err := dataStore.GetTopic(id)
+		if err != nil || !topicInTenant(ctx, topic) || topic.Archived {
+			return nil, errorWithStatus(http.StatusNotFound, "Topic not found: %s", id)
+		}
+		topics = append(topics, topic)
+	}
+	return topics, nil
+}
+
+// pullExercises picks up to count cached exercises for the user to answer offline until
+// dueUntil: the reviews due by then, soonest first, then new exercises within the daily new
+// limit of each day covered. Exercises in a running duel or challenge are left out, and the
+// answers are stripped unless withAnswers is set.
+func pullExercises(ctx context.Context, user *User, topics []*Topic, req GenerateRequest, count, days int, withAnswers bool, now time.Time) (*SyncPull, error) {
+	views, err := dataStore.GetUserExerciseViews(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get user views: %v", err)
+	}
+	inPlay, err := exercisesInPlay(user.ID, now)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get running games: %v", err)
+	}
+	applyHintBoost(user.ID, views)
+	if req.Difficulty, err = requestDifficulty(req.Difficulty, user); err != nil {
+		return nil, errorWithStatus(http.StatusBadRequest, "%v", err)
+	}
+	if req.GrammarTags, err = validateGrammarTagFilters(req.GrammarTags); err != nil {
+		return nil, errorWithStatus(http.StatusBadRequest, "%v", err)
+	}
+	vars := promptVarsFromRequest(req)
+	dueUntil := now.Add(time.Duration(days) * 24 * time.Hour)
+
+	var due, unseen []*Exercise
+	topicOf := make(map[string]string)
+	for _, topic := range topics {
+		exercises, err := dataStore.GetExercisesForTopic(topic.ID, getCacheHash(topic.Prompt, vars))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get exercises: %v", err)
+		}
+		exercises = withoutRetired(filterExercisesByDifficulty(filterExercisesByTheme(exercises, vars.Theme), vars.Difficulty))
+		for _, ex := range filterExercisesByGrammarTags(exercises, vars.GrammarTags) {
+			if inPlay[ex.AirtableID] {
+				continue
+			}
+			topicOf[ex.AirtableID] = topic.ID
+			if view, ok := views[ex.AirtableID]; !ok {
+				unseen = append(unseen, ex)
+			} else if !nextReviewAt(view).After(dueUntil) {
+				due = append(due, ex)
+			}
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return nextReviewAt(views[due[i].AirtableID]).Before(nextReviewAt(views[due[j].AirtableID]))
+	})
+
+	limits := getDailyLimits(user, views, now)
+	selected := append([]*Exercise{}, due[:min(len(due), count)]...)
+	newAllowed := limits.NewRemaining + limits.NewLimit*(days-1)
+	selected = append(selected, getRandomExercises(unseen, min(count-len(selected), newAllowed))...)
+
+	var hints map[string]string
+	if user.NativeLanguage != "" && len(selected) > 0 {
+		hints = nativeHints(ctx, user, selected)
+	}
+	pull := &SyncPull{ServerTime: now.UTC(), DueUntil: dueUntil.UTC(), Exercises: []*SyncExercise{}}
+	for _, ex := range selected {
+		raw := exerciseWithID(ex)
+		if !withAnswers {
+			raw = withoutAnswer(raw)
+		}
+		if hint, ok := hints[ex.AirtableID]; ok {
+			raw = withNativeHint(raw, hint, user.NativeLanguage)
+		}
+		if !servesDistractors(user, vars.Difficulty) {
+			raw = withoutFields(raw, "distractors")
+		}
+		synced := &SyncExercise{TopicID: topicOf[ex.AirtableID], Exercise: raw}
+		if view, ok := views[ex.AirtableID]; ok {
+			dueAt := nextReviewAt(view).UTC()
+			synced.DueAt = &dueAt
+		}
+		pull.Exercises = append(pull.Exercises, synced)
+	}
+	return pull, nil
+}
+
+// pushResults applies answers given offline to the user's schedule, oldest first, and returns
+// what became of each in the order they were pushed. An answer on the same UTC day as the
+// exercise's last one only replaces its grade, like answering again online the same day.
+func pushResults(ownerID string, results []SyncResult, now time.Time) ([]*SyncPushResult, error) {
+	for i, result := range results {
+		if _, ok := gradeIntervalFactors[result.Grade]; !ok {
+			return nil, errorWithStatus(http.StatusBadRequest, "grade must be again, hard, good or easy")
+		}
+		if err := validateHintPositions(result.HintPositions); err != nil {
+			return nil, err
+		}
+		if result.ExerciseID == "" || result.AnsweredAt.IsZero() {
+			return nil, errorWithStatus(http.StatusBadRequest, "every result needs an exercise_id and answered_at")
+		}
+		if result.AnsweredAt.After(now.Add(maxSyncClockSkew)) {
+			results[i].AnsweredAt = now
+		}
+	}
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return results[order[i]].AnsweredAt.Before(results[order[j]].AnsweredAt) })
+
+	views, err := dataStore.GetUserExerciseViews(ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get user views: %v", err)
+	}
+
+	pushed := make([]*SyncPushResult, len(results))
+	changed := make(map[string]*UserExerciseView)
+	var changedOrder []string
+	exists := make(map[string]bool)
+	for _, i := range order {
+		result := results[i]
+		outcome := &SyncPushResult{ExerciseID: result.ExerciseID, AnsweredAt: result.AnsweredAt.UTC()}
+		pushed[i] = outcome
+
+		view, ok := views[result.ExerciseID]
+		if !ok {
+			known, checked := exists[result.ExerciseID]
+			if !checked {
+				_, err := exerciseByID(result.ExerciseID)
+				known = err == nil
+				exists[result.ExerciseID] = known
+			}
+			if !known {
+				outcome.Status = syncNotFound
+				continue
+			}
+			view = &UserExerciseView{UserID: ownerID, ExerciseID: result.ExerciseID}
+			views[result.ExerciseID] = view
+		}
+		if result.AnsweredAt.Before(view.LastViewed) {
+			outcome.Status = syncStale
+			continue
+		}
+
+		if view.LastViewed.IsZero() || view.LastViewed.UTC().Truncate(24*time.Hour).Before(result.AnsweredAt.UTC().Truncate(24*time.Hour)) {
+			markViewed(view, result.AnsweredAt)
+		} else {
+			view.LastViewed = result.AnsweredAt
+		}
+		view.Grade = result.Grade
+		if _, ok := changed[result.ExerciseID]; !ok {
+			changedOrder = append(changedOrder, result.ExerciseID)
+		}
+		changed[result.ExerciseID] = view
+
+		// Hints are best effort; they only shorten intervals and feed the hint report
+		if len(result.HintPositions) > 0 {
+			if err := recordExerciseHints(ownerID, result.ExerciseID, result.HintPositions); err != nil {
+				log.Printf("Warning: failed to record hints for %s: %v", ownerID, err)
+			}
+		}
+		outcome.Status = syncApplied
+	}
+	applyHintBoost(ownerID, changed)
+
+	var viewsToUpdate []*UserExerciseView
+	for _, exerciseID := range changedOrder {
+		viewsToUpdate = append(viewsToUpdate, changed[exerciseID])
+	}
+	for start := 0; start < len(viewsToUpdate); start += 10 {
+		if err := dataStore.UpdateUserExerciseViews(viewsToUpdate[start:min(start+10, len(viewsToUpdate))]); err != nil {
+			log.Printf("Error saving synced answers for %s: %v", ownerID, err)
+			return nil, fmt.Errorf("Failed to save synced answers")
+		}
+	}
+
+	// Applied answers report the exercise's schedule after the whole push
+	for _, outcome := range pushed {
+		if view, ok := changed[outcome.ExerciseID]; ok && outcome.Status == syncApplied {
+			nextReview := nextReviewAt(view).UTC()
+			outcome.Grade, outcome.RepetitionCounter, outcome.NextReview = view.Grade, view.RepetitionCounter, &nextReview
+		}
+	}
+	return pushed, nil
+}
+
+// Handle pulling exercises for offline practice: GET /api/sync/pull?topic_id=&level=&theme=
+// &difficulty=&grammar_tag=&count=50&days=1. topic_id may be repeated; without it every active
+// topic is pulled. grammar_tag may be repeated too, for exercises with all the tags.
+func handleSyncPull(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, err := dataStore.GetUserByID(userID)
+	if err != nil || user == nil {
+		writeError(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	count, days := defaultSyncCount, defaultSyncDays
+	if value := query.Get("count"); value != "" {
+		if count, err = strconv.Atoi(value); err != nil || count < 1 || count > maxSyncCount {
+			writeError(w, fmt.Sprintf("count must be between 1 and %d", maxSyncCount), http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("days"); value != "" {
+		if days, err = strconv.Atoi(value); err != nil || days < 1 || days > maxSyncDays {
+			writeError(w, fmt.Sprintf("days must be between 1 and %d", maxSyncDays), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var topicIDs []string
+	for _, id := range query["topic_id"] {
+		if id = strings.TrimSpace(id); id != "" {
+			topicIDs = append(topicIDs, id)
+		}
+	}
+	topics, err := syncTopics(r.Context(), topicIDs)
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	req := GenerateRequest{Level: query.Get("level"), Theme: query.Get("theme"), Difficulty: query.Get("difficulty"), GrammarTags: query["grammar_tag"]}
+	pull, err := pullExercises(r.Context(), user, topics, req, count, days, servesAnswers(r), time.Now())
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pull)
+}
+
+// Handle uploading answers given offline: POST /api/sync/push with
+// {"results": [{"exercise_id", "grade", "hint_positions", "answered_at"}]}.
+func handleSyncPush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req SyncPushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Results) == 0 || len(req.Results) > maxSyncResults {
+		writeError(w, fmt.Sprintf("results must hold 1 to %d answers", maxSyncResults), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	results, err := pushResults(userID, req.Results, now)
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"server_time": now.UTC(), "results": results})
+}
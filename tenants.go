@@ -0,0 +1,648 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// One instance can host several schools as tenants. A tenant is reached on its own domain
+// (matched against the request's Host) or under /t/{slug}/ on the instance's domain; visiting
+// /t/{slug}/ remembers the tenant in a cookie, so the frontend's /api calls stay with it until
+// the instance's own home page is visited again. Requests that match no tenant are the
+// instance's own, as before tenants existed.
+//
+// A tenant has its own topics (with their exercises and prompt versions), an admin who may
+// manage them, optionally its own OpenAI key, and a monthly quota of generation calls. User
+// accounts are shared: the same login works on every tenant. Instance-wide admin features
+// (backups, analytics, feature flags, webhooks, ...) stay with the instance's admin.
+const (
+	tenantCookieName = "tenant"
+	tenantPathPrefix = "/t/"
+	tenantCacheTTL   = 30 * time.Second
+	quotaMonthLayout = "2006-01"
+)
+
+var (
+	tenantSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,39}$`)
+
+	errQuotaExceeded = errors.New("this school has used its exercise generation quota for the month")
+)
+
+// Tenant is a school hosted by this instance, managed under /api/admin/tenants.
+type Tenant struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Domain       string    `json:"domain,omitempty"`
+	Slug         string    `json:"slug,omitempty"`
+	AdminEmail   string    `json:"admin_email,omitempty"`
+	OpenAIAPIKey string    `json:"-"`
+	HasOpenAIKey bool      `json:"has_openai_key"`
+	OpenAIURL    string    `json:"openai_url,omitempty"` // empty uses OPENAI_URL
+	ModelName    string    `json:"model_name,omitempty"` // empty uses MODEL_NAME
+	MonthlyQuota int       `json:"monthly_quota"`        // generation calls per calendar month (UTC); 0 is unlimited
+	UsageMonth   string    `json:"usage_month,omitempty"`
+	UsageCount   int       `json:"usage_count"` // generation calls in UsageMonth
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type TenantRequest struct {
+	Name         string  `json:"name"`
+	Domain       *string `json:"domain"`
+	Slug         *string `json:"slug"`
+	AdminEmail   *string `json:"admin_email"`
+	OpenAIAPIKey *string `json:"openai_api_key"` // "" removes it, so the instance's key is used
+	OpenAIURL    *string `json:"openai_url"`
+	ModelName    *string `json:"model_name"`
+	MonthlyQuota *int    `json:"monthly_quota"`
+}
+
+type tenantContextKey struct{}
+
+var (
+	tenantsMutex   sync.Mutex
+	memoryTenants  = make(map[string]*Tenant) // with in-memory storage
+	cachedTenants  []*Tenant                  // with Airtable storage; nil until loaded
+	tenantsLoadAt  time.Time
+	tenantUseMutex sync.Mutex // serializes quota checks, so concurrent calls can't both take the last one
+)
+
+func tenantFromRecord(record *airtable.Record) *Tenant {
+	tenant := &Tenant{ID: record.ID}
+	if val, ok := record.Fields["Name"].(string); ok {
+		tenant.Name = val
+	}
+	if val, ok := record.Fields["Domain"].(string); ok {
+		tenant.Domain = val
+	}
+	if val, ok := record.Fields["Slug"].(string); ok {
+		tenant.Slug = val
+	}
+	if val, ok := record.Fields["AdminEmail"].(string); ok {
+		tenant.AdminEmail = val
+	}
+	if val, ok := record.Fields["OpenAIAPIKey"].(string); ok {
+		tenant.OpenAIAPIKey = readSecret(val, tenantsTableName, "OpenAIAPIKey", "")
+	}
+	if val, ok := record.Fields["OpenAIURL"].(string); ok {
+		tenant.OpenAIURL = val
+	}
+	if val, ok := record.Fields["ModelName"].(string); ok {
+		tenant.ModelName = val
+	}
+	if val, ok := record.Fields["MonthlyQuota"].(float64); ok {
+		tenant.MonthlyQuota = int(val)
+	}
+	if val, ok := record.Fields["UsageMonth"].(string); ok {
+		tenant.UsageMonth = val
+	}
+	if val, ok := record.Fields["UsageCount"].(float64); ok {
+		tenant.UsageCount = int(val)
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			tenant.CreatedAt = t
+		}
+	}
+	tenant.HasOpenAIKey = tenant.OpenAIAPIKey != ""
+	return tenant
+}
+
+// loadTenants reads every tenant from storage.
+func loadTenants() ([]*Tenant, error) {
+	tenants := []*Tenant{}
+	if airtableBaseID == "" {
+		tenantsMutex.Lock()
+		for _, stored := range memoryTenants {
+			c := *stored
+			tenants = append(tenants, &c)
+		}
+		tenantsMutex.Unlock()
+	} else {
+		table := airtableClient.GetTable(airtableBaseID, tenantsTableName)
+		records, err := getAllRecords(table.GetRecords())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tenants from Airtable: %v", err)
+		}
+		for _, record := range records.Records {
+			tenants = append(tenants, tenantFromRecord(record))
+		}
+	}
+	slices.SortFunc(tenants, func(a, b *Tenant) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return tenants, nil
+}
+
+// getTenants returns every tenant. Every request looks its tenant up, so with Airtable
+// storage they are kept in memory for tenantCacheTTL; a missing Tenants table means none.
+// A failed reload keeps the tenants loaded before, so tenant hosts don't fall back to the
+// instance's topics while Airtable is unavailable.
+func getTenants() []*Tenant {
+	if airtableBaseID == "" {
+		tenants, _ := loadTenants()
+		return tenants
+	}
+	tenantsMutex.Lock()
+	defer tenantsMutex.Unlock()
+	if cachedTenants == nil || time.Since(tenantsLoadAt) > tenantCacheTTL {
+		tenants, err := loadTenants()
+		switch {
+		case err == nil:
+			cachedTenants = tenants
+		case cachedTenants == nil:
+			log.Printf("Warning: %v", err)
+			cachedTenants = []*Tenant{}
+		default:
+			log.Printf("Warning: %v; keeping the tenants loaded before", err)
+		}
+		tenantsLoadAt = time.Now()
+	}
+	copies := make([]*Tenant, len(cachedTenants))
+	for i, tenant := range cachedTenants {
+		c := *tenant
+		copies[i] = &c
+	}
+	return copies
+}
+
+// invalidateTenantCache makes the next getTenants reload, keeping the cached tenants
+// in case that fails.
+func invalidateTenantCache() {
+	tenantsMutex.Lock()
+	tenantsLoadAt = time.Time{}
+	tenantsMutex.Unlock()
+}
+
+func getTenant(id string) *Tenant {
+	for _, tenant := range getTenants() {
+		if tenant.ID == id {
+			return tenant
+		}
+	}
+	return nil
+}
+
+// saveTenant creates or updates a tenant.
+func saveTenant(tenant *Tenant) error {
+	tenant.HasOpenAIKey = tenant.OpenAIAPIKey != ""
+	if airtableBaseID == "" {
+		tenantsMutex.Lock()
+		if tenant.ID == "" {
+			tenant.ID = fmt.Sprintf("tenant%d", time.Now().UnixNano())
+		}
+		c := *tenant
+		memoryTenants[tenant.ID] = &c
+		tenantsMutex.Unlock()
+		return nil
+	}
+	defer invalidateTenantCache()
+
+	apiKey, err := sealSecret(tenant.OpenAIAPIKey, tenantsTableName, "OpenAIAPIKey", "")
+	if err != nil {
+		return err
+	}
+	fields := map[string]any{
+		"Name":         tenant.Name,
+		"Domain":       tenant.Domain,
+		"Slug":         tenant.Slug,
+		"AdminEmail":   tenant.AdminEmail,
+		"OpenAIAPIKey": apiKey,
+		"OpenAIURL":    tenant.OpenAIURL,
+		"ModelName":    tenant.ModelName,
+		"MonthlyQuota": tenant.MonthlyQuota,
+		"UsageMonth":   tenant.UsageMonth,
+		"UsageCount":   tenant.UsageCount,
+		"CreatedAt":    tenant.CreatedAt.Format(time.RFC3339),
+	}
+	table := airtableClient.GetTable(airtableBaseID, tenantsTableName)
+	records := &airtable.Records{Records: []*airtable.Record{{ID: tenant.ID, Fields: fields}}}
+	if tenant.ID != "" {
+		if _, err := table.UpdateRecordsPartial(records); err != nil {
+			return fmt.Errorf("failed to update tenant in Airtable: %v", err)
+		}
+		return nil
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return fmt.Errorf("failed to create tenant in Airtable: %v", err)
+	}
+	if len(result.Records) > 0 {
+		tenant.ID = result.Records[0].ID
+	}
+	return nil
+}
+
+func deleteTenant(id string) error {
+	if airtableBaseID == "" {
+		tenantsMutex.Lock()
+		delete(memoryTenants, id)
+		tenantsMutex.Unlock()
+		return nil
+	}
+	defer invalidateTenantCache()
+	table := airtableClient.GetTable(airtableBaseID, tenantsTableName)
+	if _, err := table.DeleteRecords([]string{id}); err != nil {
+		return fmt.Errorf("failed to delete tenant: %v", err)
+	}
+	return nil
+}
+
+// withTenantContext returns ctx carrying the tenant; nil is the instance itself.
+func withTenantContext(ctx context.Context, tenant *Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// tenantFromContext returns the request's tenant, or nil for the instance itself.
+func tenantFromContext(ctx context.Context) *Tenant {
+	tenant, _ := ctx.Value(tenantContextKey{}).(*Tenant)
+	return tenant
+}
+
+// tenantIDFromContext returns the ID of the request's tenant, "" for the instance itself.
+func tenantIDFromContext(ctx context.Context) string {
+	if tenant := tenantFromContext(ctx); tenant != nil {
+		return tenant.ID
+	}
+	return ""
+}
+
+// withTenant resolves the request's tenant and strips a /t/{slug} prefix from the path.
+func withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenants := getTenants()
+		if len(tenants) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		host := requestHost(r)
+		var tenant *Tenant
+		for _, t := range tenants {
+			if t.Domain != "" && t.Domain == host {
+				tenant = t
+			}
+		}
+
+		if rest, ok := strings.CutPrefix(r.URL.Path, tenantPathPrefix); ok && tenant == nil {
+			slug, path, hasPath := strings.Cut(rest, "/")
+			tenant = tenantBySlug(tenants, slug)
+			if tenant == nil {
+				writeError(w, "School not found", http.StatusNotFound)
+				return
+			}
+			if !hasPath {
+				http.Redirect(w, r, tenantPathPrefix+slug+"/", http.StatusMovedPermanently)
+				return
+			}
+			cookie := newCookie(tenantCookieName, slug, time.Now().Add(sessionLifetime))
+			cookie.HttpOnly = true
+			http.SetCookie(w, cookie)
+			u := *r.URL
+			u.Path, u.RawPath = "/"+path, ""
+			r = r.Clone(r.Context())
+			r.URL = &u
+		} else if tenant == nil {
+			if cookie, err := r.Cookie(tenantCookieName); err == nil {
+				if r.URL.Path == "/" {
+					// The instance's own home page leaves the tenant
+					cookie := newCookie(tenantCookieName, "", time.Unix(0, 0))
+					cookie.HttpOnly = true
+					http.SetCookie(w, cookie)
+				} else {
+					tenant = tenantBySlug(tenants, cookie.Value)
+				}
+			}
+		}
+
+		if tenant != nil {
+			r = r.WithContext(withTenantContext(r.Context(), tenant))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestHost returns the request's host name, lowercased and without a port.
+func requestHost(r *http.Request) string {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
+
+func tenantBySlug(tenants []*Tenant, slug string) *Tenant {
+	for _, t := range tenants {
+		if t.Slug != "" && t.Slug == slug {
+			return t
+		}
+	}
+	return nil
+}
+
+// tenantHome is the home page of the request's tenant, where logging in and out lead back to.
+func tenantHome(r *http.Request) string {
+	if tenant := tenantFromContext(r.Context()); tenant != nil && tenant.Slug != "" && tenant.Domain != requestHost(r) {
+		return tenantPathPrefix + tenant.Slug + "/"
+	}
+	return "/"
+}
+
+// topicInTenant reports whether the topic belongs to the request's tenant (or, without one,
+// to the instance). Other tenants' topics are treated as if they didn't exist.
+func topicInTenant(ctx context.Context, topic *Topic) bool {
+	return topic != nil && topic.TenantID == tenantIDFromContext(ctx)
+}
+
+// tenantTopics returns the topics of the request's tenant, archived ones included.
+func tenantTopics(ctx context.Context) ([]*Topic, error) {
+	topics, err := dataStore.GetAllTopics()
+	if err != nil {
+		return nil, err
+	}
+	var own []*Topic
+	for _, topic := range topics {
+		if topicInTenant(ctx, topic) {
+			own = append(own, topic)
+		}
+	}
+	return own, nil
+}
+
+// topicTenant returns the tenant a topic belongs to, nil for the instance's topics.
+func topicTenant(topic *Topic) *Tenant {
+	if topic.TenantID == "" {
+		return nil
+	}
+	tenant := getTenant(topic.TenantID)
+	if tenant == nil {
+		log.Printf("Warning: topic %s belongs to unknown tenant %s", topic.ID, topic.TenantID)
+	}
+	return tenant
+}
+
+// isTenantAdmin reports whether the user is the admin of the tenant, matched by email.
+func isTenantAdmin(user *User, tenant *Tenant) bool {
+	return user != nil && tenant != nil && tenant.AdminEmail != "" && user.Email != "" && strings.EqualFold(user.Email, tenant.AdminEmail)
+}
+
+// tenantAdminOnly is adminOnly for the request's tenant: its admin may pass as well as the
+// instance's admin.
+func tenantAdminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant := tenantFromContext(r.Context())
+		if tenant == nil || tenant.AdminEmail == "" {
+			adminOnly(h).ServeHTTP(w, r)
+			return
+		}
+		user := requireUser(w, r)
+		if user == nil {
+			return
+		}
+		if !isTenantAdmin(user, tenant) && !isAdminUser(user) {
+			writeError(w, "You do not have permission to perform this action", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+// llmProvider returns the tenant's own OpenAI settings.
+func (t *Tenant) llmProvider() llmProvider {
+	p := llmProvider{Name: providerOpenAI + ":" + t.ID, URL: t.OpenAIURL, APIKey: t.OpenAIAPIKey, Model: t.ModelName}
+	if p.URL == "" {
+		p.URL = appConfig.OpenAIURL
+	}
+	if p.Model == "" {
+		p.Model = appConfig.ModelName
+	}
+	return p
+}
+
+// llmProviderFor returns the OpenAI settings to use for the request: the user's own key if
+// they set one (see user_api_keys.go), then the tenant's if it has one, otherwise the instance's.
+func llmProviderFor(ctx context.Context) llmProvider {
+	if provider, ok := userLLMProvider(ctx); ok {
+		return provider
+	}
+	if tenant := tenantFromContext(ctx); tenant != nil && tenant.OpenAIAPIKey != "" {
+		return tenant.llmProvider()
+	}
+	return llmProvider{Name: providerOpenAI, URL: appConfig.OpenAIURL, APIKey: appConfig.OpenAIAPIKey, Model: appConfig.ModelName}
+}
+
+// monthlyUsage returns the tenant's generation calls in the month of now.
+func (t *Tenant) monthlyUsage(now time.Time) int {
+	if t.UsageMonth != now.UTC().Format(quotaMonthLayout) {
+		return 0
+	}
+	return t.UsageCount
+}
+
+// generationQuotaLeft reports whether the request's tenant may still generate this month.
+// Users with their own key are not counted.
+func generationQuotaLeft(ctx context.Context, now time.Time) bool {
+	tenant := tenantFromContext(ctx)
+	return tenant == nil || usesOwnAPIKey(ctx) || tenant.MonthlyQuota == 0 || tenant.monthlyUsage(now) < tenant.MonthlyQuota
+}
+
+// useGenerationQuota counts a generation call against the monthly quota of the request's
+// tenant, or returns errQuotaExceeded once it is used up. Behind a load balancer each
+// instance counts from the stored total, so concurrent calls may overshoot slightly.
+func useGenerationQuota(ctx context.Context) error {
+	if tenantFromContext(ctx) == nil || usesOwnAPIKey(ctx) {
+		return nil
+	}
+	tenantUseMutex.Lock()
+	defer tenantUseMutex.Unlock()
+
+	invalidateTenantCache()
+	tenant := getTenant(tenantIDFromContext(ctx))
+	if tenant == nil {
+		return nil
+	}
+	now := time.Now()
+	used := tenant.monthlyUsage(now)
+	if tenant.MonthlyQuota > 0 && used >= tenant.MonthlyQuota {
+		return errQuotaExceeded
+	}
+	tenant.UsageMonth, tenant.UsageCount = now.UTC().Format(quotaMonthLayout), used+1
+	if err := saveTenant(tenant); err != nil {
+		log.Printf("Warning: failed to record generation usage of tenant %s: %v", tenant.Name, err)
+	}
+	return nil
+}
+
+// applyTenantRequest validates a create or update request and applies it to tenant.
+func applyTenantRequest(tenant *Tenant, req TenantRequest) error {
+	if req.Name != "" || tenant.Name == "" {
+		tenant.Name = strings.TrimSpace(req.Name)
+	}
+	if tenant.Name == "" || len(tenant.Name) > 100 {
+		return fmt.Errorf("name is required and must be at most 100 characters")
+	}
+	if req.Domain != nil {
+		tenant.Domain = strings.ToLower(strings.TrimSpace(*req.Domain))
+		if tenant.Domain != "" {
+			if u, err := url.Parse("https://" + tenant.Domain); err != nil || u.Host != tenant.Domain || u.Port() != "" {
+				return fmt.Errorf("domain must be a host name like school.example.com")
+			}
+		}
+	}
+	if req.Slug != nil {
+		tenant.Slug = strings.TrimSpace(*req.Slug)
+		if tenant.Slug != "" && !tenantSlugPattern.MatchString(tenant.Slug) {
+			return fmt.Errorf("slug must be 2-40 lowercase letters, digits and dashes")
+		}
+	}
+	if tenant.Domain == "" && tenant.Slug == "" {
+		return fmt.Errorf("a domain or a slug is required")
+	}
+	if req.AdminEmail != nil {
+		tenant.AdminEmail = strings.TrimSpace(*req.AdminEmail)
+		if tenant.AdminEmail != "" {
+			if addr, err := mail.ParseAddress(tenant.AdminEmail); err != nil || addr.Address != tenant.AdminEmail {
+				return fmt.Errorf("admin_email must be an email address")
+			}
+		}
+	}
+	if req.OpenAIAPIKey != nil {
+		tenant.OpenAIAPIKey = strings.TrimSpace(*req.OpenAIAPIKey)
+	}
+	if req.OpenAIURL != nil {
+		tenant.OpenAIURL = strings.TrimRight(strings.TrimSpace(*req.OpenAIURL), "/")
+		if tenant.OpenAIURL != "" {
+			if u, err := url.Parse(tenant.OpenAIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("openai_url must be an http(s) URL")
+			}
+		}
+	}
+	if req.ModelName != nil {
+		tenant.ModelName = strings.TrimSpace(*req.ModelName)
+	}
+	if req.MonthlyQuota != nil {
+		if *req.MonthlyQuota < 0 {
+			return fmt.Errorf("monthly_quota must not be negative")
+		}
+		tenant.MonthlyQuota = *req.MonthlyQuota
+	}
+
+	for _, other := range getTenants() {
+		if other.ID == tenant.ID {
+			continue
+		}
+		if tenant.Domain != "" && other.Domain == tenant.Domain {
+			return fmt.Errorf("domain %s is already used by %s", tenant.Domain, other.Name)
+		}
+		if tenant.Slug != "" && other.Slug == tenant.Slug {
+			return fmt.Errorf("slug %s is already used by %s", tenant.Slug, other.Name)
+		}
+	}
+	return nil
+}
+
+// Handle tenants (instance admin):
+// GET /api/admin/tenants lists them,
+// POST /api/admin/tenants creates one: {"name": "Goethe School", "slug": "goethe", "admin_email": "admin@goethe.example", "openai_api_key": "sk-...", "monthly_quota": 500},
+// PUT /api/admin/tenants/{id} changes the given fields, DELETE /api/admin/tenants/{id} removes one without topics.
+// The OpenAI key is never returned, only has_openai_key.
+func handleAdminTenants(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/tenants"), "/")
+
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"tenants": getTenants()})
+
+		case http.MethodPost:
+			var req TenantRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			tenant := &Tenant{CreatedAt: time.Now().UTC()}
+			if err := applyTenantRequest(tenant, req); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := saveTenant(tenant); err != nil {
+				writeError(w, fmt.Sprintf("Failed to create tenant: %v", err), http.StatusInternalServerError)
+				return
+			}
+			recordAudit(r, auditTenantCreate, "tenant", tenant.ID, nil, tenant)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(tenant)
+
+		default:
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	tenant := getTenant(id)
+	if tenant == nil {
+		writeError(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tenant)
+
+	case http.MethodPut:
+		var req TenantRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		before := *tenant
+		if err := applyTenantRequest(tenant, req); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := saveTenant(tenant); err != nil {
+			writeError(w, fmt.Sprintf("Failed to update tenant: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, auditTenantUpdate, "tenant", tenant.ID, &before, tenant)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tenant)
+
+	case http.MethodDelete:
+		// Topics would otherwise fall back to the instance, so they have to be deleted first
+		topics, err := tenantTopics(withTenantContext(r.Context(), tenant))
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to get topics: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if len(topics) > 0 {
+			writeError(w, fmt.Sprintf("Tenant still has %d topics; delete them first", len(topics)), http.StatusConflict)
+			return
+		}
+		if err := deleteTenant(tenant.ID); err != nil {
+			writeError(w, fmt.Sprintf("Failed to delete tenant: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, auditTenantDelete, "tenant", tenant.ID, tenant, nil)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
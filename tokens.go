@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+const (
+	apiTokenPrefix   = "gct_"
+	maxTokenNameLen  = 60
+	tokenCacheTTL    = time.Minute
+	tokenTouchPeriod = time.Hour
+)
+
+// APIToken is a personal access token. Only a hash of the secret is stored.
+type APIToken struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"-"`
+	Name       string    `json:"name"`
+	Hint       string    `json:"hint"`
+	TokenHash  string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// Recently verified tokens, so authenticated API calls don't each hit Airtable
+var (
+	tokenCache   = make(map[string]*cachedToken)
+	tokenCacheMu sync.Mutex
+)
+
+type cachedToken struct {
+	token    *APIToken
+	cachedAt time.Time
+}
+
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func apiTokenFromRecord(record *airtable.Record) *APIToken {
+	token := &APIToken{
+		ID: record.ID,
+	}
+	if val, ok := record.Fields["UserID"].(string); ok {
+		token.UserID = val
+	}
+	if val, ok := record.Fields["Name"].(string); ok {
+		token.Name = val
+	}
+	if val, ok := record.Fields["Hint"].(string); ok {
+		token.Hint = val
+	}
+	if val, ok := record.Fields["TokenHash"].(string); ok {
+		token.TokenHash = val
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			token.CreatedAt = t
+		}
+	}
+	if val, ok := record.Fields["LastUsedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			token.LastUsedAt = t
+		}
+	}
+	return token
+}
+
+func findAPITokens(formula string) ([]*APIToken, error) {
+	table := airtableClient.GetTable(airtableBaseID, apiTokensTableName)
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(formula))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API tokens from Airtable: %v", err)
+	}
+
+	tokens := []*APIToken{}
+	for _, record := range records.Records {
+		tokens = append(tokens, apiTokenFromRecord(record))
+	}
+	return tokens, nil
+}
+
+func getUserAPITokens(userID string) ([]*APIToken, error) {
+	return findAPITokens(fmt.Sprintf("{UserID} = '%s'", userID))
+}
+
+// createAPIToken stores a new token and returns it along with the secret, which is
+// shown to the user once and never stored.
+func createAPIToken(userID, name string) (*APIToken, string, error) {
+	secret := apiTokenPrefix + newRandomToken() + newRandomToken()
+	token := &APIToken{
+		UserID:    userID,
+		Name:      name,
+		Hint:      secret[len(secret)-4:],
+		TokenHash: hashAPIToken(secret),
+		CreatedAt: time.Now(),
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, apiTokensTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				Fields: map[string]any{
+					"UserID":    token.UserID,
+					"Name":      token.Name,
+					"Hint":      token.Hint,
+					"TokenHash": token.TokenHash,
+					"CreatedAt": token.CreatedAt.Format(time.RFC3339),
+				},
+			},
+		},
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to save API token: %v", err)
+	}
+	if len(result.Records) > 0 {
+		token.ID = result.Records[0].ID
+	}
+	return token, secret, nil
+}
+
+func deleteAPIToken(token *APIToken) error {
+	table := airtableClient.GetTable(airtableBaseID, apiTokensTableName)
+	if _, err := table.DeleteRecords([]string{token.ID}); err != nil {
+		return fmt.Errorf("failed to delete API token: %v", err)
+	}
+
+	tokenCacheMu.Lock()
+	delete(tokenCache, token.TokenHash)
+	tokenCacheMu.Unlock()
+	return nil
+}
+
+func touchAPIToken(id string, usedAt time.Time) {
+	table := airtableClient.GetTable(airtableBaseID, apiTokensTableName)
+	records := &airtable.Records{
+		Records: []*airtable.Record{
+			{
+				ID:     id,
+				Fields: map[string]any{"LastUsedAt": usedAt.Format(time.RFC3339)},
+			},
+		},
+	}
+	if _, err := table.UpdateRecordsPartial(records); err != nil {
+		log.Printf("Warning: failed to update API token last use: %v", err)
+	}
+}
+
+// authenticateAPIToken returns the user ID owning a bearer token secret, or "" if it is invalid.
+func authenticateAPIToken(secret string) string {
+	if !strings.HasPrefix(secret, apiTokenPrefix) || airtableClient == nil {
+		return ""
+	}
+	hash := hashAPIToken(secret)
+
+	tokenCacheMu.Lock()
+	cached, found := tokenCache[hash]
+	tokenCacheMu.Unlock()
+
+	var token *APIToken
+	if found && time.Since(cached.cachedAt) < tokenCacheTTL {
+		token = cached.token
+	} else {
+		tokens, err := findAPITokens(fmt.Sprintf("{TokenHash} = '%s'", hash))
+		if err != nil {
+			log.Printf("Error verifying API token: %v", err)
+			return ""
+		}
+		if len(tokens) == 0 {
+			return ""
+		}
+		token = tokens[0]
+		tokenCacheMu.Lock()
+		tokenCache[hash] = &cachedToken{token: token, cachedAt: time.Now()}
+		tokenCacheMu.Unlock()
+	}
+
+	// Record usage, at most once per period to keep Airtable writes down
+	tokenCacheMu.Lock()
+	touch := time.Since(token.LastUsedAt) > tokenTouchPeriod
+	if touch {
+		token.LastUsedAt = time.Now()
+	}
+	usedAt := token.LastUsedAt
+	tokenCacheMu.Unlock()
+	if touch {
+		go touchAPIToken(token.ID, usedAt)
+	}
+	return token.UserID
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header, if present.
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[7:]), true
+}
+
+// Handle personal access tokens: GET lists them, POST creates one, DELETE /api/user/tokens/{id} revokes one.
+// Only the browser session manages tokens, so a leaked token can't mint others or outlive its revocation.
+func handleUserTokens(w http.ResponseWriter, r *http.Request) {
+	if _, ok := bearerToken(r); ok {
+		writeError(w, "Tokens can only be managed while signed in", http.StatusForbidden)
+		return
+	}
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tokenID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/user/tokens"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && tokenID == "":
+		tokens, err := getUserAPITokens(userID)
+		if err != nil {
+			writeError(w, "Failed to get tokens", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tokens)
+
+	case r.Method == http.MethodPost && tokenID == "":
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len(req.Name) > maxTokenNameLen {
+			writeError(w, fmt.Sprintf("Token name is required and must be at most %d characters", maxTokenNameLen), http.StatusBadRequest)
+			return
+		}
+
+		token, secret, err := createAPIToken(userID, req.Name)
+		if err != nil {
+			writeError(w, "Failed to create token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{
+			"token":  token,
+			"secret": secret, // Only returned once
+		})
+
+	case r.Method == http.MethodDelete && tokenID != "":
+		tokens, err := getUserAPITokens(userID)
+		if err != nil {
+			writeError(w, "Failed to get tokens", http.StatusInternalServerError)
+			return
+		}
+		for _, token := range tokens {
+			if token.ID == tokenID {
+				if err := deleteAPIToken(token); err != nil {
+					writeError(w, "Failed to revoke token", http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeError(w, "Token not found", http.StatusNotFound)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The frontend polls the topic list, so with Airtable storage topics are kept in memory
+// instead of being read on every request. Every topic mutation made through the store, and
+// restoring a backup, drops the cache. Other instances drop theirs after topicCacheTTL at
+// the latest, so behind a load balancer an edit can take that long to show everywhere.
+// Topic responses also carry an ETag, so unchanged listings are answered with 304.
+const topicCacheTTL = 30 * time.Second
+
+// cachedTopicStore is a Store whose topic reads are served from memory.
+type cachedTopicStore struct {
+	Store
+
+	mu       sync.Mutex
+	topics   []*Topic // nil until loaded
+	loadedAt time.Time
+}
+
+func newCachedTopicStore(store Store) *cachedTopicStore {
+	return &cachedTopicStore{Store: store}
+}
+
+// cachedTopics returns the cached topics, loading them if they are missing or stale.
+func (s *cachedTopicStore) cachedTopics() ([]*Topic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.topics == nil || time.Since(s.loadedAt) > topicCacheTTL {
+		topics, err := s.Store.GetAllTopics()
+		if err != nil {
+			return nil, err
+		}
+		s.topics = topics
+		if s.topics == nil {
+			s.topics = []*Topic{}
+		}
+		s.loadedAt = time.Now()
+	}
+	return s.topics, nil
+}
+
+func (s *cachedTopicStore) invalidate() {
+	s.mu.Lock()
+	s.topics = nil
+	s.mu.Unlock()
+}
+
+// GetAllTopics returns copies, so callers can't change the cached topics.
+func (s *cachedTopicStore) GetAllTopics() ([]*Topic, error) {
+	topics, err := s.cachedTopics()
+	if err != nil {
+		return nil, err
+	}
+	copies := make([]*Topic, len(topics))
+	for i, topic := range topics {
+		c := *topic
+		copies[i] = &c
+	}
+	return copies, nil
+}
+
+func (s *cachedTopicStore) GetTopic(topicID string) (*Topic, error) {
+	topics, err := s.cachedTopics()
+	if err != nil {
+		return nil, err
+	}
+	for _, topic := range topics {
+		if topic.ID == topicID {
+			c := *topic
+			return &c, nil
+		}
+	}
+	// Not cached: let the store report why
+	return s.Store.GetTopic(topicID)
+}
+
+func (s *cachedTopicStore) InsertTopic(name, prompt, tenantID string) (*Topic, error) {
+	defer s.invalidate()
+	return s.Store.InsertTopic(name, prompt, tenantID)
+}
+
+func (s *cachedTopicStore) UpdateTopic(topicID, name, prompt string) (*Topic, error) {
+	defer s.invalidate()
+	return s.Store.UpdateTopic(topicID, name, prompt)
+}
+
+func (s *cachedTopicStore) SetTopicArchived(topicID string, archived bool) (*Topic, error) {
+	defer s.invalidate()
+	return s.Store.SetTopicArchived(topicID, archived)
+}
+
+func (s *cachedTopicStore) SetTopicRefinement(topicID string, disabled bool, metaPrompt string) (*Topic, error) {
+	defer s.invalidate()
+	return s.Store.SetTopicRefinement(topicID, disabled, metaPrompt)
+}
+
+func (s *cachedTopicStore) SetTopicVocabularyBand(topicID string, band int) (*Topic, error) {
+	defer s.invalidate()
+	return s.Store.SetTopicVocabularyBand(topicID, band)
+}
+
+func (s *cachedTopicStore) DeleteTopic(topicID string) error {
+	defer s.invalidate()
+	return s.Store.DeleteTopic(topicID)
+}
+
+// invalidateTopicCache drops the cached topics after they were changed outside the store.
+func invalidateTopicCache() {
+	if store, ok := dataStore.(*cachedTopicStore); ok {
+		store.invalidate()
+	}
+}
+
+// writeJSONWithETag writes v as JSON with an ETag of its content, or 304 Not Modified if
+// the request's If-None-Match already has it. Browsers revalidate every time (no-cache).
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
+
+// etagMatches reports whether an If-None-Match header lists the ETag, compared weakly.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
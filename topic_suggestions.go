@@ -0,0 +1,604 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"sync"
+
+	"github.com/mehanizm/airtable"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Topic suggestions turn a learner's recent weak spots into new topics. The learner's
+// answers from the last weakPatternWindow are grouped into grammar patterns (an
+// exercise's conjunction within its topic), and the patterns with the most answers graded
+// again or hard, the most hints and the most mistakes in corrected writing (see
+// writing.go), go to the model, which proposes new topic prompts for them. Suggestions are kept until an admin accepts one, which creates the topic,
+// or dismisses it. Topics are shared by everyone, so only admins can accept.
+const (
+	weakPatternWindow   = 30 * 24 * time.Hour
+	maxWeakPatterns     = 5
+	maxPatternExamples  = 3
+	maxTopicSuggestions = 3
+
+	suggestionPending   = "pending"
+	suggestionAccepted  = "accepted"
+	suggestionDismissed = "dismissed"
+)
+
+// topicSuggestionPrompt starts every suggestion request; the mock LLM recognizes it by this.
+const topicSuggestionPrompt = "Propose new exercise topics for a German learner, targeting the grammar they keep getting wrong."
+
+const topicSuggestionInstructions = `
+
+The learner's weak spots over the last 30 days, weakest first:
+%s
+Existing topics, which must not be repeated: %s.
+
+Propose 2 or 3 topics. For each, give a short topic name and a prompt for the exercise generator, in the style of this existing prompt:
+
+%s
+
+Reply with a JSON object {"suggestions": [{"name": "...", "prompt": "...", "rationale": "..."}]}. The rationale tells the learner in one sentence which of their mistakes the topic targets.`
+
+// WeakPattern is a grammar pattern the learner struggled with recently.
+type WeakPattern struct {
+	TopicID     string   `json:"topic_id"`
+	TopicName   string   `json:"topic_name"`
+	Conjunction string   `json:"conjunction"`
+	Answered    int      `json:"answered"`
+	Weak        int      `json:"weak"` // answers graded again or hard
+	Hints       int      `json:"hints"`
+	Writing     int      `json:"writing"`            // mistakes in corrected writing
+	Category    string   `json:"category,omitempty"` // for writing mistakes without a conjunction
+	Examples    []string `json:"examples"`           // sentences of weak answers, or mistaken words
+}
+
+// TopicSuggestion is a topic the model proposed for a learner's weak spots.
+type TopicSuggestion struct {
+	ID        string    `json:"id"`
+	OwnerID   string    `json:"owner_id"`
+	Name      string    `json:"name"`
+	Prompt    string    `json:"prompt"`
+	Rationale string    `json:"rationale"`
+	Patterns  string    `json:"patterns"` // the weak patterns it was proposed for
+	Status    string    `json:"status"`
+	TopicID   string    `json:"topic_id,omitempty"`  // the topic created when accepted
+	TenantID  string    `json:"tenant_id,omitempty"` // the school it was suggested on, whose topic it becomes
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var (
+	topicSuggestionsMutex  sync.Mutex
+	memoryTopicSuggestions = make(map[string]*TopicSuggestion) // with in-memory storage
+)
+
+func topicSuggestionFromRecord(record *airtable.Record) *TopicSuggestion {
+	suggestion := &TopicSuggestion{ID: record.ID}
+	if val, ok := record.Fields["OwnerID"].(string); ok {
+		suggestion.OwnerID = val
+	}
+	if val, ok := record.Fields["Name"].(string); ok {
+		suggestion.Name = val
+	}
+	if val, ok := record.Fields["Prompt"].(string); ok {
+		suggestion.Prompt = val
+	}
+	if val, ok := record.Fields["Rationale"].(string); ok {
+		suggestion.Rationale = val
+	}
+	if val, ok := record.Fields["Patterns"].(string); ok {
+		suggestion.Patterns = val
+	}
+	if val, ok := record.Fields["Status"].(string); ok {
+		suggestion.Status = val
+	}
+	if val, ok := record.Fields["TopicID"].(string); ok {
+		suggestion.TopicID = val
+	}
+	if val, ok := record.Fields["TenantID"].(string); ok {
+		suggestion.TenantID = val
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			suggestion.CreatedAt = t
+		}
+	}
+	return suggestion
+}
+
+// getTopicSuggestions returns suggestions, newest first, optionally only one owner's or
+// with one status.
+func getTopicSuggestions(ownerID, status string) ([]*TopicSuggestion, error) {
+	suggestions := []*TopicSuggestion{}
+	if airtableBaseID == "" {
+		topicSuggestionsMutex.Lock()
+		for _, suggestion := range memoryTopicSuggestions {
+			if (ownerID == "" || suggestion.OwnerID == ownerID) && (status == "" || suggestion.Status == status) {
+				c := *suggestion
+				suggestions = append(suggestions, &c)
+			}
+		}
+		topicSuggestionsMutex.Unlock()
+	} else {
+		var conditions []string
+		if ownerID != "" {
+			conditions = append(conditions, fmt.Sprintf("{OwnerID} = '%s'", ownerID))
+		}
+		if status != "" {
+			conditions = append(conditions, fmt.Sprintf("{Status} = '%s'", status))
+		}
+		query := airtableClient.GetTable(airtableBaseID, topicSuggestionsTableName).GetRecords()
+		if len(conditions) > 0 {
+			query = query.WithFilterFormula(fmt.Sprintf("AND(%s)", strings.Join(conditions, ", ")))
+		}
+		records, err := getAllRecords(query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get topic suggestions from Airtable: %v", err)
+		}
+		for _, record := range records.Records {
+			suggestions = append(suggestions, topicSuggestionFromRecord(record))
+		}
+	}
+	slices.SortFunc(suggestions, func(a, b *TopicSuggestion) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return suggestions, nil
+}
+
+func getTopicSuggestion(id string) (*TopicSuggestion, error) {
+	if airtableBaseID == "" {
+		topicSuggestionsMutex.Lock()
+		defer topicSuggestionsMutex.Unlock()
+		if suggestion, ok := memoryTopicSuggestions[id]; ok {
+			c := *suggestion
+			return &c, nil
+		}
+		return nil, fmt.Errorf("topic suggestion %s not found", id)
+	}
+
+	record, err := airtableClient.GetTable(airtableBaseID, topicSuggestionsTableName).GetRecord(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get topic suggestion from Airtable: %v", err)
+	}
+	return topicSuggestionFromRecord(record), nil
+}
+
+// saveTopicSuggestion creates a suggestion, or updates it if it has an ID.
+func saveTopicSuggestion(suggestion *TopicSuggestion) error {
+	if airtableBaseID == "" {
+		topicSuggestionsMutex.Lock()
+		defer topicSuggestionsMutex.Unlock()
+		if suggestion.ID == "" {
+			suggestion.ID = fmt.Sprintf("suggestion%d", time.Now().UnixNano())
+		}
+		c := *suggestion
+		memoryTopicSuggestions[suggestion.ID] = &c
+		return nil
+	}
+
+	fields := map[string]any{
+		"OwnerID":   suggestion.OwnerID,
+		"Name":      suggestion.Name,
+		"Prompt":    suggestion.Prompt,
+		"Rationale": suggestion.Rationale,
+		"Patterns":  suggestion.Patterns,
+		"Status":    suggestion.Status,
+		"TopicID":   suggestion.TopicID,
+		"CreatedAt": suggestion.CreatedAt.Format(time.RFC3339),
+	}
+	if suggestion.TenantID != "" {
+		fields["TenantID"] = suggestion.TenantID
+	}
+	table := airtableClient.GetTable(airtableBaseID, topicSuggestionsTableName)
+	records := &airtable.Records{Records: []*airtable.Record{{ID: suggestion.ID, Fields: fields}}}
+	if suggestion.ID != "" {
+		if _, err := table.UpdateRecordsPartial(records); err != nil {
+			return fmt.Errorf("failed to update topic suggestion in Airtable: %v", err)
+		}
+		return nil
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return fmt.Errorf("failed to create topic suggestion in Airtable: %v", err)
+	}
+	if len(result.Records) > 0 {
+		suggestion.ID = result.Records[0].ID
+	}
+	return nil
+}
+
+// getWeakPatterns returns the owner's grammar patterns with answers graded again or hard,
+// hints or writing mistakes in the last weakPatternWindow: the maxWeakPatterns weakest,
+// weakest first. A writing mistake about a conjunction counts towards the pattern of that
+// conjunction with the most answers; other writing mistakes are grouped by category.
+func getWeakPatterns(ownerID string, now time.Time) ([]*WeakPattern, error) {
+	views, err := dataStore.GetUserExerciseViews(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	hints, err := getExerciseHints(ownerID)
+	if err != nil {
+		log.Printf("Warning: failed to get exercise hints of %s: %v", ownerID, err)
+	}
+	exercises, err := dataStore.ListExercises("")
+	if err != nil {
+		return nil, err
+	}
+	topics, err := dataStore.GetAllTopics()
+	if err != nil {
+		return nil, err
+	}
+	topicNames := make(map[string]string)
+	for _, topic := range topics {
+		topicNames[topic.ID] = topic.Name
+	}
+	byID := make(map[string]*Exercise)
+	for _, ex := range exercises {
+		byID[ex.AirtableID] = ex
+	}
+
+	since := now.Add(-weakPatternWindow)
+	patterns := make(map[string]*WeakPattern)
+	pattern := func(ex *Exercise) *WeakPattern {
+		key := ex.TopicID + "|" + strings.ToLower(ex.Conjunction)
+		if patterns[key] == nil {
+			patterns[key] = &WeakPattern{TopicID: ex.TopicID, TopicName: topicNames[ex.TopicID], Conjunction: ex.Conjunction, Examples: []string{}}
+		}
+		return patterns[key]
+	}
+	for exerciseID, view := range views {
+		ex, ok := byID[exerciseID]
+		if !ok || view.LastViewed.Before(since) {
+			continue
+		}
+		p := pattern(ex)
+		p.Answered++
+		if view.Grade == gradeAgain || view.Grade == gradeHard {
+			p.Weak++
+			if len(p.Examples) < maxPatternExamples {
+				p.Examples = append(p.Examples, ex.Sentence)
+			}
+		}
+	}
+	for _, hint := range hints {
+		if ex, ok := byID[hint.ExerciseID]; ok && !hint.CreatedAt.Before(since) {
+			pattern(ex).Hints++
+		}
+	}
+	writingErrors, err := getWritingErrors(ownerID, since)
+	if err != nil {
+		log.Printf("Warning: failed to get writing errors of %s: %v", ownerID, err)
+	}
+	for _, writingError := range writingErrors {
+		var p *WeakPattern
+		if writingError.Conjunction != "" {
+			for _, candidate := range patterns {
+				if strings.EqualFold(candidate.Conjunction, writingError.Conjunction) && (p == nil || candidate.Answered > p.Answered ||
+					candidate.Answered == p.Answered && candidate.TopicID < p.TopicID) {
+					p = candidate
+				}
+			}
+		}
+		if p == nil {
+			key := "|" + strings.ToLower(writingError.Conjunction)
+			if writingError.Conjunction == "" {
+				key = "writing|" + writingError.Category
+			}
+			if patterns[key] == nil {
+				patterns[key] = &WeakPattern{Conjunction: writingError.Conjunction, Examples: []string{}}
+				if writingError.Conjunction == "" {
+					patterns[key].Category = writingError.Category
+				}
+			}
+			p = patterns[key]
+		}
+		p.Writing++
+		if len(p.Examples) < maxPatternExamples && writingError.Original != "" {
+			p.Examples = append(p.Examples, writingError.Original)
+		}
+	}
+
+	var weak []*WeakPattern
+	for _, p := range patterns {
+		if p.Weak > 0 || p.Hints > 0 || p.Writing > 0 {
+			weak = append(weak, p)
+		}
+	}
+	sort.Slice(weak, func(i, j int) bool {
+		if a, b := weak[i].Weak+weak[i].Hints+weak[i].Writing, weak[j].Weak+weak[j].Hints+weak[j].Writing; a != b {
+			return a > b
+		}
+		return weak[i].TopicID+weak[i].Conjunction+weak[i].Category < weak[j].TopicID+weak[j].Conjunction+weak[j].Category
+	})
+	if len(weak) > maxWeakPatterns {
+		weak = weak[:maxWeakPatterns]
+	}
+	return weak, nil
+}
+
+// describeWeakPattern is a pattern as the model and admins read it.
+func describeWeakPattern(p *WeakPattern) string {
+	name := p.TopicName
+	switch {
+	case p.Category != "":
+		name = strings.ReplaceAll(p.Category, "_", " ") + " in free writing"
+	case p.TopicName == "":
+		name = fmt.Sprintf("%q in free writing", p.Conjunction)
+	case p.Conjunction != "":
+		name = fmt.Sprintf("%q in %s", p.Conjunction, p.TopicName)
+	}
+	return name
+}
+
+// suggestTopics asks the model for topics targeting the weak patterns. The example prompt
+// is that of the weakest pattern's topic.
+func suggestTopics(ctx context.Context, patterns []*WeakPattern) (suggestions []*TopicSuggestion, err error) {
+	llm := llmProviderFor(ctx)
+	modelName := llm.Model
+	ctx, end := startSpan(ctx, "suggest topics", attribute.String("llm.model", modelName), attribute.Int("pattern.count", len(patterns)))
+	defer func() { end(err) }()
+
+	topics, err := getActiveTopics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get topics: %v", err)
+	}
+	var names []string
+	example := ""
+	for _, topic := range topics {
+		names = append(names, fmt.Sprintf("%q", topic.Name))
+		if topic.ID == patterns[0].TopicID || example == "" {
+			example = topic.Prompt
+		}
+	}
+	var weakSpots strings.Builder
+	for _, p := range patterns {
+		fmt.Fprintf(&weakSpots, "- %s: %d of %d answers graded again or hard, %d hints, %d writing mistakes", describeWeakPattern(p), p.Weak, p.Answered, p.Hints, p.Writing)
+		if len(p.Examples) > 0 {
+			fmt.Fprintf(&weakSpots, "; e.g. %q", strings.Join(p.Examples, `", "`))
+		}
+		weakSpots.WriteString("\n")
+	}
+	prompt := topicSuggestionPrompt + fmt.Sprintf(topicSuggestionInstructions, weakSpots.String(), strings.Join(names, ", "), example)
+
+	reqBody, err := json.Marshal(OpenAIRequest{
+		Model:          modelName,
+		Messages:       []Message{{Role: "user", Content: prompt}},
+		ResponseFormat: &ResponseFormat{Type: "json_object"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create suggestion request body: %w", err)
+	}
+	apiReq, err := http.NewRequestWithContext(ctx, "POST", llm.URL+"/chat/completions", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API request for suggestions: %w", err)
+	}
+	apiReq.Header.Set("Content-Type", "application/json")
+	apiReq.Header.Set("Authorization", "Bearer "+llm.APIKey)
+
+	resp, err := llmHTTPClient.Do(apiReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call OpenAI API for suggestions: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API response for suggestions: %w", err)
+	}
+	var openaiResp OpenAIResponse
+	if err := json.Unmarshal(respBody, &openaiResp); err != nil {
+		return nil, fmt.Errorf("failed to parse API response for suggestions: %w", err)
+	}
+	if openaiResp.Error != nil {
+		return nil, fmt.Errorf("API error during suggestions: %s", openaiResp.Error.Message)
+	}
+	if len(openaiResp.Choices) == 0 || openaiResp.Choices[0].Message.Content == "" {
+		return nil, fmt.Errorf("received an empty response from the suggestion API")
+	}
+
+	var output struct {
+		Suggestions []struct {
+			Name      string `json:"name"`
+			Prompt    string `json:"prompt"`
+			Rationale string `json:"rationale"`
+		} `json:"suggestions"`
+	}
+	if err := json.Unmarshal([]byte(openaiResp.Choices[0].Message.Content), &output); err != nil {
+		return nil, fmt.Errorf("failed to parse topic suggestions: %w", err)
+	}
+	for _, s := range output.Suggestions {
+		name, topicPrompt := strings.TrimSpace(s.Name), strings.TrimSpace(s.Prompt)
+		if name == "" || topicPrompt == "" {
+			continue
+		}
+		suggestions = append(suggestions, &TopicSuggestion{Name: name, Prompt: topicPrompt, Rationale: strings.TrimSpace(s.Rationale)})
+		if len(suggestions) == maxTopicSuggestions {
+			break
+		}
+	}
+	if len(suggestions) == 0 {
+		return nil, fmt.Errorf("the model proposed no usable topics")
+	}
+	return suggestions, nil
+}
+
+// Handle the user's topic suggestions: GET /api/user/topic-suggestions lists them, newest
+// first; POST analyzes the user's recent mistakes and asks the model for 2-3 new topics,
+// returning { patterns, suggestions }. It answers 422 when there are no recent mistakes.
+func handleUserTopicSuggestions(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		suggestions, err := getTopicSuggestions(userID, "")
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to get topic suggestions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"suggestions": suggestions})
+
+	case http.MethodPost:
+		if offlineMode() {
+			writeOfflineError(w)
+			return
+		}
+		rateLimited("suggest", func(w http.ResponseWriter, r *http.Request) {
+			patterns, err := getWeakPatterns(userID, time.Now())
+			if err != nil {
+				writeError(w, fmt.Sprintf("Failed to analyze mistakes: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if len(patterns) == 0 {
+				writeError(w, "No recent mistakes or hints to suggest topics for", http.StatusUnprocessableEntity)
+				return
+			}
+
+			if err := useModelQuota(r.Context(), userID); err != nil {
+				writeStatusError(w, err)
+				return
+			}
+			suggestions, err := suggestTopics(r.Context(), patterns)
+			if err != nil {
+				log.Printf("Error suggesting topics for %s: %v", userID, err)
+				writeError(w, "Failed to suggest topics", http.StatusBadGateway)
+				return
+			}
+			var described []string
+			for _, p := range patterns {
+				described = append(described, describeWeakPattern(p))
+			}
+			now := time.Now().UTC()
+			var names []string
+			for _, suggestion := range suggestions {
+				suggestion.OwnerID = userID
+				suggestion.TenantID = tenantIDFromContext(r.Context())
+				suggestion.Patterns = strings.Join(described, ", ")
+				suggestion.Status = suggestionPending
+				suggestion.CreatedAt = now
+				if err := saveTopicSuggestion(suggestion); err != nil {
+					writeError(w, fmt.Sprintf("Failed to save topic suggestion: %v", err), http.StatusInternalServerError)
+					return
+				}
+				names = append(names, suggestion.Name)
+			}
+			notifyWebhooks(webhookTopicSuggested, fmt.Sprintf("%d new topics suggested: %s", len(suggestions), strings.Join(names, ", ")),
+				map[string]any{"suggestions": suggestions})
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]any{"patterns": patterns, "suggestions": suggestions})
+		})(w, r)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Handle topic suggestions (admin): GET /api/admin/topic-suggestions?status=pending lists
+// them (status=all for every one), POST /api/admin/topic-suggestions/{id}/accept creates the
+// topic, optionally with {"name": "...", "prompt": "..."} edited, and
+// POST /api/admin/topic-suggestions/{id}/dismiss dismisses one.
+func handleAdminTopicSuggestions(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/topic-suggestions"), "/"), "/")
+
+	if id == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "":
+			status = suggestionPending
+		case "all":
+			status = ""
+		case suggestionPending, suggestionAccepted, suggestionDismissed:
+		default:
+			writeError(w, "status must be pending, accepted, dismissed or all", http.StatusBadRequest)
+			return
+		}
+		suggestions, err := getTopicSuggestions("", status)
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to get topic suggestions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"suggestions": suggestions})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if action != "accept" && action != "dismiss" {
+		writeError(w, "Not found", http.StatusNotFound)
+		return
+	}
+	suggestion, err := getTopicSuggestion(id)
+	if err != nil {
+		writeError(w, "Topic suggestion not found", http.StatusNotFound)
+		return
+	}
+	if suggestion.Status != suggestionPending {
+		writeError(w, fmt.Sprintf("Topic suggestion is already %s", suggestion.Status), http.StatusConflict)
+		return
+	}
+	before := *suggestion
+
+	if action == "dismiss" {
+		suggestion.Status = suggestionDismissed
+		if err := saveTopicSuggestion(suggestion); err != nil {
+			writeError(w, fmt.Sprintf("Failed to dismiss topic suggestion: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, auditTopicSuggestionDismiss, "topic_suggestion", suggestion.ID, before, suggestion)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(suggestion)
+		return
+	}
+
+	var req TopicRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	name, prompt := suggestion.Name, suggestion.Prompt
+	if strings.TrimSpace(req.Name) != "" {
+		name = strings.TrimSpace(req.Name)
+	}
+	if strings.TrimSpace(req.Prompt) != "" {
+		prompt = req.Prompt
+	}
+	topic, err := createTopic(name, prompt, suggestion.TenantID)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to create topic: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, auditTopicCreate, "topic", topic.ID, nil, topic)
+
+	suggestion.Status = suggestionAccepted
+	suggestion.TopicID = topic.ID
+	if err := saveTopicSuggestion(suggestion); err != nil {
+		log.Printf("Warning: topic %s created, but failed to mark suggestion %s accepted: %v", topic.ID, suggestion.ID, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{"topic": topic, "suggestion": suggestion})
+}
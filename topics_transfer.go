@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TopicExport is a topic together with its prompt history and, optionally, its cached exercises.
+type TopicExport struct {
+	Name      string           `json:"name"`
+	Prompt    string           `json:"prompt"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	Versions  []*PromptVersion `json:"versions"`
+	Exercises []*Exercise      `json:"exercises,omitempty"`
+
+	RefinementDisabled bool   `json:"refinement_disabled,omitempty"`
+	MetaPrompt         string `json:"meta_prompt,omitempty"`
+	VocabularyBand     int    `json:"vocabulary_band,omitempty"`
+}
+
+type TopicsExport struct {
+	ExportedAt time.Time     `json:"exported_at"`
+	Topics     []TopicExport `json:"topics"`
+}
+
+type TopicsImportResult struct {
+	Imported  []*Topic `json:"imported"`
+	Skipped   []string `json:"skipped"`
+	Exercises int      `json:"exercises"`
+}
+
+// exportTopics exports the topics of the request's tenant.
+func exportTopics(ctx context.Context, includeExercises bool) (*TopicsExport, error) {
+	topics, err := tenantTopics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &TopicsExport{
+		ExportedAt: time.Now(),
+		Topics:     []TopicExport{},
+	}
+	for _, topic := range topics {
+		versions, err := dataStore.GetVersions(topic.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export versions for topic '%s': %v", topic.Name, err)
+		}
+
+		item := TopicExport{
+			Name:      topic.Name,
+			Prompt:    topic.Prompt,
+			CreatedAt: topic.CreatedAt,
+			UpdatedAt: topic.UpdatedAt,
+			Versions:  versions,
+
+			RefinementDisabled: topic.RefinementDisabled,
+			MetaPrompt:         topic.MetaPrompt,
+			VocabularyBand:     topic.VocabularyBand,
+		}
+		if includeExercises {
+			item.Exercises, err = dataStore.ListExercises(topic.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to export exercises for topic '%s': %v", topic.Name, err)
+			}
+		}
+		export.Topics = append(export.Topics, item)
+	}
+	return export, nil
+}
+
+func validateTopicsImport(data *TopicsExport) error {
+	for _, item := range data.Topics {
+		if item.Name == "" || item.Prompt == "" {
+			return fmt.Errorf("topic name and prompt are required")
+		}
+	}
+	return nil
+}
+
+// importTopics creates the given topics for the request's tenant, replaying their version
+// history in order. Topics whose name already exists are skipped.
+func importTopics(ctx context.Context, data *TopicsExport, includeExercises bool) (*TopicsImportResult, error) {
+	existing, err := tenantTopics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existingNames := make(map[string]bool)
+	for _, topic := range existing {
+		existingNames[strings.ToLower(topic.Name)] = true
+	}
+
+	result := &TopicsImportResult{
+		Imported: []*Topic{},
+		Skipped:  []string{},
+	}
+	for _, item := range data.Topics {
+		if existingNames[strings.ToLower(item.Name)] {
+			result.Skipped = append(result.Skipped, item.Name)
+			continue
+		}
+
+		topic, err := dataStore.InsertTopic(item.Name, item.Prompt, tenantIDFromContext(ctx))
+		if err != nil {
+			return result, err
+		}
+		existingNames[strings.ToLower(item.Name)] = true
+
+		lastPrompt := ""
+		for _, version := range item.Versions {
+			name := version.Name
+			if name == "" {
+				name = item.Name
+			}
+			if err := dataStore.AddPromptVersion(topic.ID, name, version.Prompt); err != nil {
+				log.Printf("Warning: Failed to import version %d of topic '%s': %v", version.Version, item.Name, err)
+			}
+			lastPrompt = version.Prompt
+		}
+		if lastPrompt != item.Prompt {
+			if err := dataStore.AddPromptVersion(topic.ID, item.Name, item.Prompt); err != nil {
+				log.Printf("Warning: Failed to create current version of topic '%s': %v", item.Name, err)
+			}
+		}
+
+		if item.RefinementDisabled || item.MetaPrompt != "" {
+			if updated, err := dataStore.SetTopicRefinement(topic.ID, item.RefinementDisabled, item.MetaPrompt); err != nil {
+				log.Printf("Warning: Failed to import refinement settings of topic '%s': %v", item.Name, err)
+			} else {
+				topic = updated
+			}
+		}
+		if item.VocabularyBand != 0 && validateVocabularyBand(item.VocabularyBand) == nil {
+			if updated, err := dataStore.SetTopicVocabularyBand(topic.ID, item.VocabularyBand); err != nil {
+				log.Printf("Warning: Failed to import vocabulary band of topic '%s': %v", item.Name, err)
+			} else {
+				topic = updated
+			}
+		}
+
+		if includeExercises {
+			for _, ex := range item.Exercises {
+				if _, err := dataStore.CreateExercise(topic.ID, ex.PromptHash, ex.Theme, ex.ExerciseJSON); err != nil {
+					log.Printf("Warning: Failed to import exercise for topic '%s': %v", item.Name, err)
+					continue
+				}
+				result.Exercises++
+			}
+		}
+
+		result.Imported = append(result.Imported, topic)
+	}
+	return result, nil
+}
+
+// Handle topic export: GET /api/topics/export. Cached exercises are only exported with
+// ?include_exercises=true, as for topic duplication.
+func handleTopicsExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	export, err := exportTopics(r.Context(), r.URL.Query().Get("include_exercises") == "true")
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to export topics: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("topics-%s.json", export.ExportedAt.Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	json.NewEncoder(w).Encode(export)
+}
+
+// Handle topic import: POST /api/topics/import. Exercises in the file are only imported
+// with ?include_exercises=true, as for export and topic duplication.
+func handleTopicsImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data TopicsExport
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateTopicsImport(&data); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := importTopics(r.Context(), &data, r.URL.Query().Get("include_exercises") == "true")
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to import topics: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, auditTopicsImport, "topics", "", nil, result)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// TopicDuplicateResult is the copy of a topic and the number of cached exercises copied with it.
+type TopicDuplicateResult struct {
+	Topic     *Topic `json:"topic"`
+	Exercises int    `json:"exercises"`
+}
+
+// duplicateTopicName returns "<name> (copy)", or "<name> (copy N)" if that is taken too.
+func duplicateTopicName(name string, existing []*Topic) string {
+	taken := make(map[string]bool)
+	for _, topic := range existing {
+		taken[strings.ToLower(topic.Name)] = true
+	}
+	candidate := name + " (copy)"
+	for n := 2; taken[strings.ToLower(candidate)]; n++ {
+		candidate = fmt.Sprintf("%s (copy %d)", name, n)
+	}
+	return candidate
+}
+
+// duplicateTopic creates a new topic of the original's tenant with its prompt and refinement
+// settings, and a history of its own starting at version 1. The original is left untouched.
+// With includeExercises, the exercises cached for the original's current prompt are copied,
+// so the copy can serve them before it generates any.
+func duplicateTopic(ctx context.Context, original *Topic, name string, includeExercises bool) (*TopicDuplicateResult, error) {
+	if name == "" {
+		existing, err := tenantTopics(ctx)
+		if err != nil {
+			return nil, err
+		}
+		name = duplicateTopicName(original.Name, existing)
+	}
+
+	topic, err := createTopic(name, original.Prompt, original.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	result := &TopicDuplicateResult{Topic: topic}
+	if original.RefinementDisabled || original.MetaPrompt != "" {
+		if updated, err := dataStore.SetTopicRefinement(topic.ID, original.RefinementDisabled, original.MetaPrompt); err != nil {
+			log.Printf("Warning: Failed to copy refinement settings of topic '%s': %v", original.Name, err)
+		} else {
+			result.Topic = updated
+		}
+	}
+	if original.VocabularyBand != 0 {
+		if updated, err := dataStore.SetTopicVocabularyBand(topic.ID, original.VocabularyBand); err != nil {
+			log.Printf("Warning: Failed to copy vocabulary band of topic '%s': %v", original.Name, err)
+		} else {
+			result.Topic = updated
+		}
+	}
+
+	if includeExercises {
+		exercises, err := dataStore.ListExercises(original.ID)
+		if err != nil {
+			return result, err
+		}
+		current := currentCacheHashes([]*Topic{original})[original.ID]
+		for _, ex := range exercises {
+			if !current[ex.PromptHash] {
+				continue
+			}
+			if _, err := dataStore.CreateExercise(topic.ID, ex.PromptHash, ex.Theme, ex.ExerciseJSON); err != nil {
+				log.Printf("Warning: Failed to copy exercise %s to topic '%s': %v", ex.ID, name, err)
+				continue
+			}
+			result.Exercises++
+		}
+	}
+	return result, nil
+}
+
+// Handle topic duplication: POST /api/topics/{id}/duplicate?include_exercises=true with an
+// optional { "name" }
+func handleTopicDuplicate(w http.ResponseWriter, r *http.Request, topicID string) {
+	original, err := dataStore.GetTopic(topicID)
+	if err != nil {
+		writeError(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := duplicateTopic(r.Context(), original, strings.TrimSpace(req.Name), r.URL.Query().Get("include_exercises") == "true")
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to duplicate topic: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, auditTopicDuplicate, "topic", result.Topic.ID, original, result)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenTelemetry tracing. When OTEL_EXPORTER_OTLP_ENDPOINT is set, every request gets a span
+// named after its route, with child spans for the data store calls on the exercise path and
+// for each chat completion request, exported over OTLP/HTTP. The exporter reads the other
+// standard OTEL_* variables (headers, sampler) itself. Without an endpoint the global tracer
+// is a no-op and the spans cost next to nothing.
+var tracer = otel.Tracer("german-conjunctions-trainer")
+
+func initTracing() {
+	if appConfig.OTLPEndpoint == "" {
+		return
+	}
+
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to create OTLP trace exporter: %v", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(appConfig.OTelServiceName),
+	))
+	if err != nil {
+		log.Fatalf("Failed to create trace resource: %v", err)
+	}
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	))
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	// Trace outgoing chat completion requests (MOCK_LLM's transport included)
+	llmHTTPClient.Transport = otelhttp.NewTransport(llmHTTPClient.Transport,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return "llm " + r.Method + " " + r.URL.Path
+		}))
+	log.Printf("OpenTelemetry tracing enabled, exporting to %s as %s", appConfig.OTLPEndpoint, appConfig.OTelServiceName)
+}
+
+// withTracing starts a server span for each request except health probes and names it after
+// the matched route, e.g. "POST /api/exercises", rather than the raw path with IDs in it.
+func withTracing(mux *http.ServeMux, next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.request",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			if _, pattern := mux.Handler(r); pattern != "" {
+				return r.Method + " " + pattern
+			}
+			return r.Method
+		}),
+		// Health probes arrive every few seconds and would drown out real traffic, and a
+		// WebSocket would be one span lasting as long as the page stays open
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			switch r.URL.Path {
+			case "/health", "/healthz", "/readyz", "/ws":
+				return false
+			}
+			return true
+		}))
+}
+
+// startSpan starts a child span of ctx. Call end with the operation's error, or nil, when it is done.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func(error)) {
+	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// startStoreSpan starts a span for a data store call, named e.g. "store GetExercisesForTopic".
+func startStoreSpan(ctx context.Context, method string) func(error) {
+	_, end := startSpan(ctx, "store "+method, attribute.String("db.system", appConfig.Storage))
+	return end
+}
@@ -0,0 +1,887 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.7
+// 	protoc        (unknown)
+// source: trainerpb/trainer.proto
+
+package trainerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Topic struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Prompt        string                 `protobuf:"bytes,3,opt,name=prompt,proto3" json:"prompt,omitempty"`
+	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Topic) Reset() {
+	*x = Topic{}
+	mi := &file_trainerpb_trainer_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Topic) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Topic) ProtoMessage() {}
+
+func (x *Topic) ProtoReflect() protoreflect.Message {
+	mi := &file_trainerpb_trainer_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Topic.ProtoReflect.Descriptor instead.
+func (*Topic) Descriptor() ([]byte, []int) {
+	return file_trainerpb_trainer_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Topic) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Topic) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Topic) GetPrompt() string {
+	if x != nil {
+		return x.Prompt
+	}
+	return ""
+}
+
+func (x *Topic) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Topic) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+type ListTopicsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Only topics whose name contains the query, ignoring case.
+	Query         string `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTopicsRequest) Reset() {
+	*x = ListTopicsRequest{}
+	mi := &file_trainerpb_trainer_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTopicsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTopicsRequest) ProtoMessage() {}
+
+func (x *ListTopicsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_trainerpb_trainer_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTopicsRequest.ProtoReflect.Descriptor instead.
+func (*ListTopicsRequest) Descriptor() ([]byte, []int) {
+	return file_trainerpb_trainer_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListTopicsRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+type ListTopicsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Topics        []*Topic               `protobuf:"bytes,1,rep,name=topics,proto3" json:"topics,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTopicsResponse) Reset() {
+	*x = ListTopicsResponse{}
+	mi := &file_trainerpb_trainer_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTopicsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTopicsResponse) ProtoMessage() {}
+
+func (x *ListTopicsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_trainerpb_trainer_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTopicsResponse.ProtoReflect.Descriptor instead.
+func (*ListTopicsResponse) Descriptor() ([]byte, []int) {
+	return file_trainerpb_trainer_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListTopicsResponse) GetTopics() []*Topic {
+	if x != nil {
+		return x.Topics
+	}
+	return nil
+}
+
+type GetTopicRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTopicRequest) Reset() {
+	*x = GetTopicRequest{}
+	mi := &file_trainerpb_trainer_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTopicRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTopicRequest) ProtoMessage() {}
+
+func (x *GetTopicRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_trainerpb_trainer_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTopicRequest.ProtoReflect.Descriptor instead.
+func (*GetTopicRequest) Descriptor() ([]byte, []int) {
+	return file_trainerpb_trainer_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetTopicRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type GetExercisesRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	TopicId string                 `protobuf:"bytes,1,opt,name=topic_id,json=topicId,proto3" json:"topic_id,omitempty"`
+	// CEFR level, A1-C2 (default B1).
+	Level string `protobuf:"bytes,2,opt,name=level,proto3" json:"level,omitempty"`
+	// Optional theme for the sentences, such as travel.
+	Theme string `protobuf:"bytes,3,opt,name=theme,proto3" json:"theme,omitempty"`
+	// Number of exercises, 5-30 (default 10).
+	Count         int32 `protobuf:"varint,4,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetExercisesRequest) Reset() {
+	*x = GetExercisesRequest{}
+	mi := &file_trainerpb_trainer_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetExercisesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetExercisesRequest) ProtoMessage() {}
+
+func (x *GetExercisesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_trainerpb_trainer_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetExercisesRequest.ProtoReflect.Descriptor instead.
+func (*GetExercisesRequest) Descriptor() ([]byte, []int) {
+	return file_trainerpb_trainer_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetExercisesRequest) GetTopicId() string {
+	if x != nil {
+		return x.TopicId
+	}
+	return ""
+}
+
+func (x *GetExercisesRequest) GetLevel() string {
+	if x != nil {
+		return x.Level
+	}
+	return ""
+}
+
+func (x *GetExercisesRequest) GetTheme() string {
+	if x != nil {
+		return x.Theme
+	}
+	return ""
+}
+
+func (x *GetExercisesRequest) GetCount() int32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type Exercise struct {
+	state                 protoimpl.MessageState `protogen:"open.v1"`
+	Id                    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	CorrectGermanSentence string                 `protobuf:"bytes,2,opt,name=correct_german_sentence,json=correctGermanSentence,proto3" json:"correct_german_sentence,omitempty"`
+	EnglishHint           string                 `protobuf:"bytes,3,opt,name=english_hint,json=englishHint,proto3" json:"english_hint,omitempty"`
+	ConjunctionTopic      string                 `protobuf:"bytes,4,opt,name=conjunction_topic,json=conjunctionTopic,proto3" json:"conjunction_topic,omitempty"`
+	// The sentence split into words and punctuation marks, as answers are checked.
+	Tokens        []string `protobuf:"bytes,5,rep,name=tokens,proto3" json:"tokens,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Exercise) Reset() {
+	*x = Exercise{}
+	mi := &file_trainerpb_trainer_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Exercise) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Exercise) ProtoMessage() {}
+
+func (x *Exercise) ProtoReflect() protoreflect.Message {
+	mi := &file_trainerpb_trainer_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Exercise.ProtoReflect.Descriptor instead.
+func (*Exercise) Descriptor() ([]byte, []int) {
+	return file_trainerpb_trainer_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Exercise) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Exercise) GetCorrectGermanSentence() string {
+	if x != nil {
+		return x.CorrectGermanSentence
+	}
+	return ""
+}
+
+func (x *Exercise) GetEnglishHint() string {
+	if x != nil {
+		return x.EnglishHint
+	}
+	return ""
+}
+
+func (x *Exercise) GetConjunctionTopic() string {
+	if x != nil {
+		return x.ConjunctionTopic
+	}
+	return ""
+}
+
+func (x *Exercise) GetTokens() []string {
+	if x != nil {
+		return x.Tokens
+	}
+	return nil
+}
+
+// DailyLimits is what is left of today's (UTC) new exercises and reviews.
+type DailyLimits struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	NewLimit         int32                  `protobuf:"varint,1,opt,name=new_limit,json=newLimit,proto3" json:"new_limit,omitempty"`
+	ReviewLimit      int32                  `protobuf:"varint,2,opt,name=review_limit,json=reviewLimit,proto3" json:"review_limit,omitempty"`
+	NewRemaining     int32                  `protobuf:"varint,3,opt,name=new_remaining,json=newRemaining,proto3" json:"new_remaining,omitempty"`
+	ReviewsRemaining int32                  `protobuf:"varint,4,opt,name=reviews_remaining,json=reviewsRemaining,proto3" json:"reviews_remaining,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *DailyLimits) Reset() {
+	*x = DailyLimits{}
+	mi := &file_trainerpb_trainer_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DailyLimits) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DailyLimits) ProtoMessage() {}
+
+func (x *DailyLimits) ProtoReflect() protoreflect.Message {
+	mi := &file_trainerpb_trainer_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DailyLimits.ProtoReflect.Descriptor instead.
+func (*DailyLimits) Descriptor() ([]byte, []int) {
+	return file_trainerpb_trainer_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DailyLimits) GetNewLimit() int32 {
+	if x != nil {
+		return x.NewLimit
+	}
+	return 0
+}
+
+func (x *DailyLimits) GetReviewLimit() int32 {
+	if x != nil {
+		return x.ReviewLimit
+	}
+	return 0
+}
+
+func (x *DailyLimits) GetNewRemaining() int32 {
+	if x != nil {
+		return x.NewRemaining
+	}
+	return 0
+}
+
+func (x *DailyLimits) GetReviewsRemaining() int32 {
+	if x != nil {
+		return x.ReviewsRemaining
+	}
+	return 0
+}
+
+type ExerciseSet struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Exercises     []*Exercise            `protobuf:"bytes,1,rep,name=exercises,proto3" json:"exercises,omitempty"`
+	Limits        *DailyLimits           `protobuf:"bytes,2,opt,name=limits,proto3" json:"limits,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExerciseSet) Reset() {
+	*x = ExerciseSet{}
+	mi := &file_trainerpb_trainer_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExerciseSet) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExerciseSet) ProtoMessage() {}
+
+func (x *ExerciseSet) ProtoReflect() protoreflect.Message {
+	mi := &file_trainerpb_trainer_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExerciseSet.ProtoReflect.Descriptor instead.
+func (*ExerciseSet) Descriptor() ([]byte, []int) {
+	return file_trainerpb_trainer_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ExerciseSet) GetExercises() []*Exercise {
+	if x != nil {
+		return x.Exercises
+	}
+	return nil
+}
+
+func (x *ExerciseSet) GetLimits() *DailyLimits {
+	if x != nil {
+		return x.Limits
+	}
+	return nil
+}
+
+type Review struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	ExerciseId string                 `protobuf:"bytes,1,opt,name=exercise_id,json=exerciseId,proto3" json:"exercise_id,omitempty"`
+	// again, hard, good or easy.
+	Grade         string `protobuf:"bytes,2,opt,name=grade,proto3" json:"grade,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Review) Reset() {
+	*x = Review{}
+	mi := &file_trainerpb_trainer_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Review) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Review) ProtoMessage() {}
+
+func (x *Review) ProtoReflect() protoreflect.Message {
+	mi := &file_trainerpb_trainer_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Review.ProtoReflect.Descriptor instead.
+func (*Review) Descriptor() ([]byte, []int) {
+	return file_trainerpb_trainer_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Review) GetExerciseId() string {
+	if x != nil {
+		return x.ExerciseId
+	}
+	return ""
+}
+
+func (x *Review) GetGrade() string {
+	if x != nil {
+		return x.Grade
+	}
+	return ""
+}
+
+// ReviewResult is an exercise's schedule after answering it.
+type ReviewResult struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	ExerciseId        string                 `protobuf:"bytes,1,opt,name=exercise_id,json=exerciseId,proto3" json:"exercise_id,omitempty"`
+	Grade             string                 `protobuf:"bytes,2,opt,name=grade,proto3" json:"grade,omitempty"`
+	RepetitionCounter int32                  `protobuf:"varint,3,opt,name=repetition_counter,json=repetitionCounter,proto3" json:"repetition_counter,omitempty"`
+	NextReview        *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=next_review,json=nextReview,proto3" json:"next_review,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *ReviewResult) Reset() {
+	*x = ReviewResult{}
+	mi := &file_trainerpb_trainer_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReviewResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReviewResult) ProtoMessage() {}
+
+func (x *ReviewResult) ProtoReflect() protoreflect.Message {
+	mi := &file_trainerpb_trainer_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReviewResult.ProtoReflect.Descriptor instead.
+func (*ReviewResult) Descriptor() ([]byte, []int) {
+	return file_trainerpb_trainer_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ReviewResult) GetExerciseId() string {
+	if x != nil {
+		return x.ExerciseId
+	}
+	return ""
+}
+
+func (x *ReviewResult) GetGrade() string {
+	if x != nil {
+		return x.Grade
+	}
+	return ""
+}
+
+func (x *ReviewResult) GetRepetitionCounter() int32 {
+	if x != nil {
+		return x.RepetitionCounter
+	}
+	return 0
+}
+
+func (x *ReviewResult) GetNextReview() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NextReview
+	}
+	return nil
+}
+
+type WatchGenerationRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchGenerationRequest) Reset() {
+	*x = WatchGenerationRequest{}
+	mi := &file_trainerpb_trainer_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchGenerationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchGenerationRequest) ProtoMessage() {}
+
+func (x *WatchGenerationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_trainerpb_trainer_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchGenerationRequest.ProtoReflect.Descriptor instead.
+func (*WatchGenerationRequest) Descriptor() ([]byte, []int) {
+	return file_trainerpb_trainer_proto_rawDescGZIP(), []int{10}
+}
+
+type GenerationProgress struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// refining_prompt, generating, caching, done or failed.
+	Stage         string                 `protobuf:"bytes,1,opt,name=stage,proto3" json:"stage,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	TopicId       string                 `protobuf:"bytes,3,opt,name=topic_id,json=topicId,proto3" json:"topic_id,omitempty"`
+	Done          int32                  `protobuf:"varint,4,opt,name=done,proto3" json:"done,omitempty"`
+	Total         int32                  `protobuf:"varint,5,opt,name=total,proto3" json:"total,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GenerationProgress) Reset() {
+	*x = GenerationProgress{}
+	mi := &file_trainerpb_trainer_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GenerationProgress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenerationProgress) ProtoMessage() {}
+
+func (x *GenerationProgress) ProtoReflect() protoreflect.Message {
+	mi := &file_trainerpb_trainer_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GenerationProgress.ProtoReflect.Descriptor instead.
+func (*GenerationProgress) Descriptor() ([]byte, []int) {
+	return file_trainerpb_trainer_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *GenerationProgress) GetStage() string {
+	if x != nil {
+		return x.Stage
+	}
+	return ""
+}
+
+func (x *GenerationProgress) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *GenerationProgress) GetTopicId() string {
+	if x != nil {
+		return x.TopicId
+	}
+	return ""
+}
+
+func (x *GenerationProgress) GetDone() int32 {
+	if x != nil {
+		return x.Done
+	}
+	return 0
+}
+
+func (x *GenerationProgress) GetTotal() int32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *GenerationProgress) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+var File_trainerpb_trainer_proto protoreflect.FileDescriptor
+
+const file_trainerpb_trainer_proto_rawDesc = "" +
+	"\n" +
+	"\x17trainerpb/trainer.proto\x12\n" +
+	"trainer.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xb9\x01\n" +
+	"\x05Topic\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x16\n" +
+	"\x06prompt\x18\x03 \x01(\tR\x06prompt\x129\n" +
+	"\n" +
+	"created_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"updated_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\")\n" +
+	"\x11ListTopicsRequest\x12\x14\n" +
+	"\x05query\x18\x01 \x01(\tR\x05query\"?\n" +
+	"\x12ListTopicsResponse\x12)\n" +
+	"\x06topics\x18\x01 \x03(\v2\x11.trainer.v1.TopicR\x06topics\"!\n" +
+	"\x0fGetTopicRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"r\n" +
+	"\x13GetExercisesRequest\x12\x19\n" +
+	"\btopic_id\x18\x01 \x01(\tR\atopicId\x12\x14\n" +
+	"\x05level\x18\x02 \x01(\tR\x05level\x12\x14\n" +
+	"\x05theme\x18\x03 \x01(\tR\x05theme\x12\x14\n" +
+	"\x05count\x18\x04 \x01(\x05R\x05count\"\xba\x01\n" +
+	"\bExercise\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x126\n" +
+	"\x17correct_german_sentence\x18\x02 \x01(\tR\x15correctGermanSentence\x12!\n" +
+	"\fenglish_hint\x18\x03 \x01(\tR\venglishHint\x12+\n" +
+	"\x11conjunction_topic\x18\x04 \x01(\tR\x10conjunctionTopic\x12\x16\n" +
+	"\x06tokens\x18\x05 \x03(\tR\x06tokens\"\x9f\x01\n" +
+	"\vDailyLimits\x12\x1b\n" +
+	"\tnew_limit\x18\x01 \x01(\x05R\bnewLimit\x12!\n" +
+	"\freview_limit\x18\x02 \x01(\x05R\vreviewLimit\x12#\n" +
+	"\rnew_remaining\x18\x03 \x01(\x05R\fnewRemaining\x12+\n" +
+	"\x11reviews_remaining\x18\x04 \x01(\x05R\x10reviewsRemaining\"r\n" +
+	"\vExerciseSet\x122\n" +
+	"\texercises\x18\x01 \x03(\v2\x14.trainer.v1.ExerciseR\texercises\x12/\n" +
+	"\x06limits\x18\x02 \x01(\v2\x17.trainer.v1.DailyLimitsR\x06limits\"?\n" +
+	"\x06Review\x12\x1f\n" +
+	"\vexercise_id\x18\x01 \x01(\tR\n" +
+	"exerciseId\x12\x14\n" +
+	"\x05grade\x18\x02 \x01(\tR\x05grade\"\xb1\x01\n" +
+	"\fReviewResult\x12\x1f\n" +
+	"\vexercise_id\x18\x01 \x01(\tR\n" +
+	"exerciseId\x12\x14\n" +
+	"\x05grade\x18\x02 \x01(\tR\x05grade\x12-\n" +
+	"\x12repetition_counter\x18\x03 \x01(\x05R\x11repetitionCounter\x12;\n" +
+	"\vnext_review\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"nextReview\"\x18\n" +
+	"\x16WatchGenerationRequest\"\xb9\x01\n" +
+	"\x12GenerationProgress\x12\x14\n" +
+	"\x05stage\x18\x01 \x01(\tR\x05stage\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12\x19\n" +
+	"\btopic_id\x18\x03 \x01(\tR\atopicId\x12\x12\n" +
+	"\x04done\x18\x04 \x01(\x05R\x04done\x12\x14\n" +
+	"\x05total\x18\x05 \x01(\x05R\x05total\x12.\n" +
+	"\x04time\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\x04time2\xf8\x02\n" +
+	"\aTrainer\x12K\n" +
+	"\n" +
+	"ListTopics\x12\x1d.trainer.v1.ListTopicsRequest\x1a\x1e.trainer.v1.ListTopicsResponse\x12:\n" +
+	"\bGetTopic\x12\x1b.trainer.v1.GetTopicRequest\x1a\x11.trainer.v1.Topic\x12H\n" +
+	"\fGetExercises\x12\x1f.trainer.v1.GetExercisesRequest\x1a\x17.trainer.v1.ExerciseSet\x12A\n" +
+	"\rSubmitReviews\x12\x12.trainer.v1.Review\x1a\x18.trainer.v1.ReviewResult(\x010\x01\x12W\n" +
+	"\x0fWatchGeneration\x12\".trainer.v1.WatchGenerationRequest\x1a\x1e.trainer.v1.GenerationProgress0\x01B'Z%german-conjunctions-trainer/trainerpbb\x06proto3"
+
+var (
+	file_trainerpb_trainer_proto_rawDescOnce sync.Once
+	file_trainerpb_trainer_proto_rawDescData []byte
+)
+
+func file_trainerpb_trainer_proto_rawDescGZIP() []byte {
+	file_trainerpb_trainer_proto_rawDescOnce.Do(func() {
+		file_trainerpb_trainer_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_trainerpb_trainer_proto_rawDesc), len(file_trainerpb_trainer_proto_rawDesc)))
+	})
+	return file_trainerpb_trainer_proto_rawDescData
+}
+
+var file_trainerpb_trainer_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_trainerpb_trainer_proto_goTypes = []any{
+	(*Topic)(nil),                  // 0: trainer.v1.Topic
+	(*ListTopicsRequest)(nil),      // 1: trainer.v1.ListTopicsRequest
+	(*ListTopicsResponse)(nil),     // 2: trainer.v1.ListTopicsResponse
+	(*GetTopicRequest)(nil),        // 3: trainer.v1.GetTopicRequest
+	(*GetExercisesRequest)(nil),    // 4: trainer.v1.GetExercisesRequest
+	(*Exercise)(nil),               // 5: trainer.v1.Exercise
+	(*DailyLimits)(nil),            // 6: trainer.v1.DailyLimits
+	(*ExerciseSet)(nil),            // 7: trainer.v1.ExerciseSet
+	(*Review)(nil),                 // 8: trainer.v1.Review
+	(*ReviewResult)(nil),           // 9: trainer.v1.ReviewResult
+	(*WatchGenerationRequest)(nil), // 10: trainer.v1.WatchGenerationRequest
+	(*GenerationProgress)(nil),     // 11: trainer.v1.GenerationProgress
+	(*timestamppb.Timestamp)(nil),  // 12: google.protobuf.Timestamp
+}
+var file_trainerpb_trainer_proto_depIdxs = []int32{
+	12, // 0: trainer.v1.Topic.created_at:type_name -> google.protobuf.Timestamp
+	12, // 1: trainer.v1.Topic.updated_at:type_name -> google.protobuf.Timestamp
+	0,  // 2: trainer.v1.ListTopicsResponse.topics:type_name -> trainer.v1.Topic
+	5,  // 3: trainer.v1.ExerciseSet.exercises:type_name -> trainer.v1.Exercise
+	6,  // 4: trainer.v1.ExerciseSet.limits:type_name -> trainer.v1.DailyLimits
+	12, // 5: trainer.v1.ReviewResult.next_review:type_name -> google.protobuf.Timestamp
+	12, // 6: trainer.v1.GenerationProgress.time:type_name -> google.protobuf.Timestamp
+	1,  // 7: trainer.v1.Trainer.ListTopics:input_type -> trainer.v1.ListTopicsRequest
+	3,  // 8: trainer.v1.Trainer.GetTopic:input_type -> trainer.v1.GetTopicRequest
+	4,  // 9: trainer.v1.Trainer.GetExercises:input_type -> trainer.v1.GetExercisesRequest
+	8,  // 10: trainer.v1.Trainer.SubmitReviews:input_type -> trainer.v1.Review
+	10, // 11: trainer.v1.Trainer.WatchGeneration:input_type -> trainer.v1.WatchGenerationRequest
+	2,  // 12: trainer.v1.Trainer.ListTopics:output_type -> trainer.v1.ListTopicsResponse
+	0,  // 13: trainer.v1.Trainer.GetTopic:output_type -> trainer.v1.Topic
+	7,  // 14: trainer.v1.Trainer.GetExercises:output_type -> trainer.v1.ExerciseSet
+	9,  // 15: trainer.v1.Trainer.SubmitReviews:output_type -> trainer.v1.ReviewResult
+	11, // 16: trainer.v1.Trainer.WatchGeneration:output_type -> trainer.v1.GenerationProgress
+	12, // [12:17] is the sub-list for method output_type
+	7,  // [7:12] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_trainerpb_trainer_proto_init() }
+func file_trainerpb_trainer_proto_init() {
+	if File_trainerpb_trainer_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_trainerpb_trainer_proto_rawDesc), len(file_trainerpb_trainer_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_trainerpb_trainer_proto_goTypes,
+		DependencyIndexes: file_trainerpb_trainer_proto_depIdxs,
+		MessageInfos:      file_trainerpb_trainer_proto_msgTypes,
+	}.Build()
+	File_trainerpb_trainer_proto = out.File
+	file_trainerpb_trainer_proto_goTypes = nil
+	file_trainerpb_trainer_proto_depIdxs = nil
+}
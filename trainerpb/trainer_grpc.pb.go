@@ -0,0 +1,297 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: trainerpb/trainer.proto
+
+package trainerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Trainer_ListTopics_FullMethodName      = "/trainer.v1.Trainer/ListTopics"
+	Trainer_GetTopic_FullMethodName        = "/trainer.v1.Trainer/GetTopic"
+	Trainer_GetExercises_FullMethodName    = "/trainer.v1.Trainer/GetExercises"
+	Trainer_SubmitReviews_FullMethodName   = "/trainer.v1.Trainer/SubmitReviews"
+	Trainer_WatchGeneration_FullMethodName = "/trainer.v1.Trainer/WatchGeneration"
+)
+
+// TrainerClient is the client API for Trainer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Trainer serves topics, exercises and review submission to native clients. It mirrors
+// the REST API: calls authenticate with a personal access token in the "authorization"
+// metadata ("Bearer gct_..."), and only the topic calls work without one.
+type TrainerClient interface {
+	// ListTopics returns the active topics, oldest first.
+	ListTopics(ctx context.Context, in *ListTopicsRequest, opts ...grpc.CallOption) (*ListTopicsResponse, error)
+	// GetTopic returns a topic by ID.
+	GetTopic(ctx context.Context, in *GetTopicRequest, opts ...grpc.CallOption) (*Topic, error)
+	// GetExercises returns a set of exercises, due reviews first, and starts it as the
+	// current session, like POST /api/exercises. Generating new exercises can take a
+	// minute; WatchGeneration reports its progress.
+	GetExercises(ctx context.Context, in *GetExercisesRequest, opts ...grpc.CallOption) (*ExerciseSet, error)
+	// SubmitReviews records answers as they are given. Each review is answered with the
+	// exercise's new schedule once it is saved.
+	SubmitReviews(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[Review, ReviewResult], error)
+	// WatchGeneration streams the progress of the caller's exercise generations until the
+	// call is cancelled.
+	WatchGeneration(ctx context.Context, in *WatchGenerationRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GenerationProgress], error)
+}
+
+type trainerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTrainerClient(cc grpc.ClientConnInterface) TrainerClient {
+	return &trainerClient{cc}
+}
+
+func (c *trainerClient) ListTopics(ctx context.Context, in *ListTopicsRequest, opts ...grpc.CallOption) (*ListTopicsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListTopicsResponse)
+	err := c.cc.Invoke(ctx, Trainer_ListTopics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainerClient) GetTopic(ctx context.Context, in *GetTopicRequest, opts ...grpc.CallOption) (*Topic, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Topic)
+	err := c.cc.Invoke(ctx, Trainer_GetTopic_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainerClient) GetExercises(ctx context.Context, in *GetExercisesRequest, opts ...grpc.CallOption) (*ExerciseSet, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ExerciseSet)
+	err := c.cc.Invoke(ctx, Trainer_GetExercises_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainerClient) SubmitReviews(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[Review, ReviewResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Trainer_ServiceDesc.Streams[0], Trainer_SubmitReviews_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Review, ReviewResult]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Trainer_SubmitReviewsClient = grpc.BidiStreamingClient[Review, ReviewResult]
+
+func (c *trainerClient) WatchGeneration(ctx context.Context, in *WatchGenerationRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GenerationProgress], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Trainer_ServiceDesc.Streams[1], Trainer_WatchGeneration_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchGenerationRequest, GenerationProgress]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Trainer_WatchGenerationClient = grpc.ServerStreamingClient[GenerationProgress]
+
+// TrainerServer is the server API for Trainer service.
+// All implementations must embed UnimplementedTrainerServer
+// for forward compatibility.
+//
+// Trainer serves topics, exercises and review submission to native clients. It mirrors
+// the REST API: calls authenticate with a personal access token in the "authorization"
+// metadata ("Bearer gct_..."), and only the topic calls work without one.
+type TrainerServer interface {
+	// ListTopics returns the active topics, oldest first.
+	ListTopics(context.Context, *ListTopicsRequest) (*ListTopicsResponse, error)
+	// GetTopic returns a topic by ID.
+	GetTopic(context.Context, *GetTopicRequest) (*Topic, error)
+	// GetExercises returns a set of exercises, due reviews first, and starts it as the
+	// current session, like POST /api/exercises. Generating new exercises can take a
+	// minute; WatchGeneration reports its progress.
+	GetExercises(context.Context, *GetExercisesRequest) (*ExerciseSet, error)
+	// SubmitReviews records answers as they are given. Each review is answered with the
+	// exercise's new schedule once it is saved.
+	SubmitReviews(grpc.BidiStreamingServer[Review, ReviewResult]) error
+	// WatchGeneration streams the progress of the caller's exercise generations until the
+	// call is cancelled.
+	WatchGeneration(*WatchGenerationRequest, grpc.ServerStreamingServer[GenerationProgress]) error
+	mustEmbedUnimplementedTrainerServer()
+}
+
+// UnimplementedTrainerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTrainerServer struct{}
+
+func (UnimplementedTrainerServer) ListTopics(context.Context, *ListTopicsRequest) (*ListTopicsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTopics not implemented")
+}
+func (UnimplementedTrainerServer) GetTopic(context.Context, *GetTopicRequest) (*Topic, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTopic not implemented")
+}
+func (UnimplementedTrainerServer) GetExercises(context.Context, *GetExercisesRequest) (*ExerciseSet, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetExercises not implemented")
+}
+func (UnimplementedTrainerServer) SubmitReviews(grpc.BidiStreamingServer[Review, ReviewResult]) error {
+	return status.Errorf(codes.Unimplemented, "method SubmitReviews not implemented")
+}
+func (UnimplementedTrainerServer) WatchGeneration(*WatchGenerationRequest, grpc.ServerStreamingServer[GenerationProgress]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchGeneration not implemented")
+}
+func (UnimplementedTrainerServer) mustEmbedUnimplementedTrainerServer() {}
+func (UnimplementedTrainerServer) testEmbeddedByValue()                 {}
+
+// UnsafeTrainerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TrainerServer will
+// result in compilation errors.
+type UnsafeTrainerServer interface {
+	mustEmbedUnimplementedTrainerServer()
+}
+
+func RegisterTrainerServer(s grpc.ServiceRegistrar, srv TrainerServer) {
+	// If the following call panics, it indicates UnimplementedTrainerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Trainer_ServiceDesc, srv)
+}
+
+func _Trainer_ListTopics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTopicsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainerServer).ListTopics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Trainer_ListTopics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainerServer).ListTopics(ctx, req.(*ListTopicsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Trainer_GetTopic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTopicRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainerServer).GetTopic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Trainer_GetTopic_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainerServer).GetTopic(ctx, req.(*GetTopicRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Trainer_GetExercises_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetExercisesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainerServer).GetExercises(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Trainer_GetExercises_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainerServer).GetExercises(ctx, req.(*GetExercisesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Trainer_SubmitReviews_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TrainerServer).SubmitReviews(&grpc.GenericServerStream[Review, ReviewResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Trainer_SubmitReviewsServer = grpc.BidiStreamingServer[Review, ReviewResult]
+
+func _Trainer_WatchGeneration_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchGenerationRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TrainerServer).WatchGeneration(m, &grpc.GenericServerStream[WatchGenerationRequest, GenerationProgress]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Trainer_WatchGenerationServer = grpc.ServerStreamingServer[GenerationProgress]
+
+// Trainer_ServiceDesc is the grpc.ServiceDesc for Trainer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Trainer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "trainer.v1.Trainer",
+	HandlerType: (*TrainerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListTopics",
+			Handler:    _Trainer_ListTopics_Handler,
+		},
+		{
+			MethodName: "GetTopic",
+			Handler:    _Trainer_GetTopic_Handler,
+		},
+		{
+			MethodName: "GetExercises",
+			Handler:    _Trainer_GetExercises_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubmitReviews",
+			Handler:       _Trainer_SubmitReviews_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchGeneration",
+			Handler:       _Trainer_WatchGeneration_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "trainerpb/trainer.proto",
+}
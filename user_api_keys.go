@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+// Users can bring their own API key, so heavy users pay for their own model calls. This
+// needs SECRETS_ENCRYPTION_KEY: the key is encrypted like other secrets (see secrets.go),
+// even with in-memory storage, and is never returned, only its last characters. Every model
+// call made for the user then goes to their provider with their key: generation without the
+// LLM_FALLBACK chain, refinement, explanations, hint translations and topic suggestions.
+// Those calls count against neither the user's generation quota nor their tenant's. A key is
+// checked by listing the provider's models before it is saved; one that stops working later
+// makes generation fail with 502 until it is replaced or removed.
+var userKeyProviderURLs = map[string]string{
+	providerOpenAI: "https://api.openai.com/v1",
+	providerGemini: "https://generativelanguage.googleapis.com/v1beta/openai",
+}
+
+// UserAPIKey is a user's own model provider settings.
+type UserAPIKey struct {
+	ID           string    `json:"-"`
+	UserID       string    `json:"user_id"`
+	Provider     string    `json:"provider"` // openai or gemini
+	URL          string    `json:"url"`      // OpenAI-compatible API, the provider's by default
+	Model        string    `json:"model"`
+	EncryptedKey string    `json:"-"`
+	KeyHint      string    `json:"key_hint"` // the key's last 4 characters
+	ValidatedAt  time.Time `json:"validated_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// UserAPIKeyRequest sets the user's key. Without api_key the stored key is kept, and
+// checked again with the new settings. Empty url and model use the provider's defaults.
+type UserAPIKeyRequest struct {
+	APIKey   string `json:"api_key"`
+	Provider string `json:"provider"`
+	URL      string `json:"url"`
+	Model    string `json:"model"`
+}
+
+var (
+	userAPIKeysMutex  sync.Mutex
+	memoryUserAPIKeys = make(map[string]*UserAPIKey) // by user ID, with in-memory storage
+)
+
+func userAPIKeyFromRecord(record *airtable.Record) *UserAPIKey {
+	key := &UserAPIKey{ID: record.ID}
+	if val, ok := record.Fields["UserID"].(string); ok {
+		key.UserID = val
+	}
+	if val, ok := record.Fields["Provider"].(string); ok {
+		key.Provider = val
+	}
+	if val, ok := record.Fields["URL"].(string); ok {
+		key.URL = val
+	}
+	if val, ok := record.Fields["Model"].(string); ok {
+		key.Model = val
+	}
+	if val, ok := record.Fields["EncryptedKey"].(string); ok {
+		key.EncryptedKey = val
+	}
+	if val, ok := record.Fields["KeyHint"].(string); ok {
+		key.KeyHint = val
+	}
+	if val, ok := record.Fields["ValidatedAt"].(string); ok {
+		key.ValidatedAt, _ = time.Parse(time.RFC3339, val)
+	}
+	if val, ok := record.Fields["UpdatedAt"].(string); ok {
+		key.UpdatedAt, _ = time.Parse(time.RFC3339, val)
+	}
+	return key
+}
+
+// getUserAPIKey returns the user's key settings, or nil if they have none.
+func getUserAPIKey(userID string) (*UserAPIKey, error) {
+	if airtableBaseID == "" {
+		userAPIKeysMutex.Lock()
+		defer userAPIKeysMutex.Unlock()
+		if stored, ok := memoryUserAPIKeys[userID]; ok {
+			c := *stored
+			return &c, nil
+		}
+		return nil, nil
+	}
+
+	records, err := airtableClient.GetTable(airtableBaseID, userAPIKeysTableName).GetRecords().
+		WithFilterFormula(fmt.Sprintf("{UserID} = '%s'", userID)).MaxRecords(1).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key from Airtable: %v", err)
+	}
+	if len(records.Records) == 0 {
+		return nil, nil
+	}
+	return userAPIKeyFromRecord(records.Records[0]), nil
+}
+
+// saveUserAPIKey creates or updates the user's key settings.
+func saveUserAPIKey(key *UserAPIKey) error {
+	if airtableBaseID == "" {
+		userAPIKeysMutex.Lock()
+		defer userAPIKeysMutex.Unlock()
+		c := *key
+		memoryUserAPIKeys[key.UserID] = &c
+		return nil
+	}
+
+	fields := map[string]any{
+		"UserID":       key.UserID,
+		"Provider":     key.Provider,
+		"URL":          key.URL,
+		"Model":        key.Model,
+		"EncryptedKey": key.EncryptedKey,
+		"KeyHint":      key.KeyHint,
+		"ValidatedAt":  key.ValidatedAt.Format(time.RFC3339),
+		"UpdatedAt":    key.UpdatedAt.Format(time.RFC3339),
+	}
+	table := airtableClient.GetTable(airtableBaseID, userAPIKeysTableName)
+	records := &airtable.Records{Records: []*airtable.Record{{ID: key.ID, Fields: fields}}}
+	if key.ID != "" {
+		if _, err := table.UpdateRecordsPartial(records); err != nil {
+			return fmt.Errorf("failed to update API key in Airtable: %v", err)
+		}
+		return nil
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return fmt.Errorf("failed to create API key in Airtable: %v", err)
+	}
+	if len(result.Records) > 0 {
+		key.ID = result.Records[0].ID
+	}
+	return nil
+}
+
+func deleteUserAPIKey(key *UserAPIKey) error {
+	if airtableBaseID == "" {
+		userAPIKeysMutex.Lock()
+		defer userAPIKeysMutex.Unlock()
+		delete(memoryUserAPIKeys, key.UserID)
+		return nil
+	}
+	table := airtableClient.GetTable(airtableBaseID, userAPIKeysTableName)
+	if _, err := table.DeleteRecords([]string{key.ID}); err != nil {
+		return fmt.Errorf("failed to delete API key from Airtable: %v", err)
+	}
+	return nil
+}
+
+// encryptUserKey encrypts a user's API key, bound to their ID (see secrets.go).
+func encryptUserKey(userID, apiKey string) (string, error) {
+	return sealSecret(apiKey, userAPIKeysTableName, "EncryptedKey", userID)
+}
+
+func decryptUserKey(userID, encrypted string) (string, error) {
+	apiKey, _, err := openSecret(encrypted, userAPIKeysTableName, "EncryptedKey", userID)
+	return apiKey, err
+}
+
+// llmProvider returns the provider to call with the user's decrypted key.
+func (k *UserAPIKey) llmProvider(apiKey string) llmProvider {
+	return llmProvider{Name: k.Provider + ":user:" + k.UserID, URL: k.URL, APIKey: apiKey, Model: k.Model}
+}
+
+// userKeyLookup finds the user's own key the first time a model call of the request needs
+// it, so requests that call no model don't pay for the lookup.
+type userKeyLookup struct {
+	r        *http.Request
+	once     sync.Once
+	provider *llmProvider
+}
+
+type userKeyLookupKey struct{}
+
+// withUserAPIKey lets model calls of the request use the signed-in user's own key.
+func withUserAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !secretsEncrypted() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		lookup := &userKeyLookup{r: r}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKeyLookupKey{}, lookup)))
+	})
+}
+
+// userLLMProvider returns the provider of the request's user if they set their own key.
+// A key that can't be read is logged, and the server's providers are used instead.
+func userLLMProvider(ctx context.Context) (llmProvider, bool) {
+	lookup, _ := ctx.Value(userKeyLookupKey{}).(*userKeyLookup)
+	if lookup == nil {
+		return llmProvider{}, false
+	}
+	lookup.once.Do(func() {
+		userID := getUserIDFromRequest(lookup.r)
+		if userID == "" {
+			return
+		}
+		key, err := getUserAPIKey(userID)
+		if err != nil || key == nil {
+			if err != nil {
+				log.Printf("Warning: failed to get the API key of user %s: %v", userID, err)
+			}
+			return
+		}
+		apiKey, err := decryptUserKey(userID, key.EncryptedKey)
+		if err != nil {
+			log.Printf("Warning: failed to read the API key of user %s: %v", userID, err)
+			return
+		}
+		provider := key.llmProvider(apiKey)
+		lookup.provider = &provider
+	})
+	if lookup.provider == nil {
+		return llmProvider{}, false
+	}
+	return *lookup.provider, true
+}
+
+// usesOwnAPIKey reports whether model calls of the request go to the user's own key, and so
+// are not counted against quotas.
+func usesOwnAPIKey(ctx context.Context) bool {
+	_, ok := userLLMProvider(ctx)
+	return ok
+}
+
+// validateUserKeyURL checks a user-supplied API URL. It must be https, and must not name a
+// loopback or private address, so users can't make the server call internal services.
+func validateUserKeyURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return fmt.Errorf("url must be an https URL")
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil && (ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()) {
+		return fmt.Errorf("url must not point at a private address")
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return fmt.Errorf("url must not point at a private address")
+	}
+	return nil
+}
+
+// checkUserKey lists the provider's models with the key, to catch typos before generation
+// fails. MOCK_LLM skips the check.
+func checkUserKey(ctx context.Context, p llmProvider) error {
+	if appConfig.MockLLM {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL+"/models", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	resp, err := llmHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s", p.URL)
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("the provider rejected the API key")
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("the provider answered %s", resp.Status)
+	}
+	return nil
+}
+
+// applyUserAPIKeyRequest works out the new settings, and the plain key to use with them.
+func applyUserAPIKeyRequest(key *UserAPIKey, req UserAPIKeyRequest) (string, error) {
+	apiKey := strings.TrimSpace(req.APIKey)
+	if apiKey == "" {
+		if key.EncryptedKey == "" {
+			return "", fmt.Errorf("api_key is required")
+		}
+		stored, err := decryptUserKey(key.UserID, key.EncryptedKey)
+		if err != nil {
+			return "", fmt.Errorf("the stored key can't be read, send api_key again")
+		}
+		apiKey = stored
+	}
+
+	switch provider := strings.ToLower(strings.TrimSpace(req.Provider)); provider {
+	case "":
+		if key.Provider == "" {
+			key.Provider = providerOpenAI
+		}
+	case providerOpenAI, providerGemini:
+		key.Provider = provider
+	default:
+		return "", fmt.Errorf("provider must be openai or gemini")
+	}
+	key.URL = strings.TrimRight(strings.TrimSpace(req.URL), "/")
+	if key.URL == "" {
+		key.URL = userKeyProviderURLs[key.Provider]
+	} else if err := validateUserKeyURL(key.URL); err != nil {
+		return "", err
+	}
+	key.Model = strings.TrimSpace(req.Model)
+	if key.Model == "" {
+		key.Model = appConfig.ModelName
+		if key.Provider == providerGemini {
+			key.Model = appConfig.GeminiModel
+		}
+	}
+	return apiKey, nil
+}
+
+// Handle the user's own API key:
+// GET /api/user/api-key returns the settings (404 if none),
+// PUT /api/user/api-key sets them: {"api_key": "sk-...", "provider": "openai", "url": "", "model": ""},
+// DELETE /api/user/api-key removes the key, going back to the server's.
+func handleUserAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromRequest(r)
+	if userID == "" {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !secretsEncrypted() {
+		writeError(w, "Own API keys are not enabled on this server", http.StatusServiceUnavailable)
+		return
+	}
+
+	key, err := getUserAPIKey(userID)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get API key: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if key == nil {
+			writeError(w, "No API key set", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(key)
+
+	case http.MethodPut:
+		var req UserAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if key == nil {
+			key = &UserAPIKey{UserID: userID}
+		}
+		apiKey, err := applyUserAPIKeyRequest(key, req)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := checkUserKey(r.Context(), key.llmProvider(apiKey)); err != nil {
+			writeError(w, fmt.Sprintf("The API key could not be verified: %v", err), http.StatusUnprocessableEntity)
+			return
+		}
+
+		if key.EncryptedKey, err = encryptUserKey(userID, apiKey); err != nil {
+			writeError(w, fmt.Sprintf("Failed to save API key: %v", err), http.StatusInternalServerError)
+			return
+		}
+		key.KeyHint = apiKey[max(0, len(apiKey)-4):]
+		key.ValidatedAt = time.Now().UTC()
+		key.UpdatedAt = key.ValidatedAt
+		if err := saveUserAPIKey(key); err != nil {
+			writeError(w, fmt.Sprintf("Failed to save API key: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(key)
+
+	case http.MethodDelete:
+		if key == nil {
+			writeError(w, "No API key set", http.StatusNotFound)
+			return
+		}
+		if err := deleteUserAPIKey(key); err != nil {
+			writeError(w, fmt.Sprintf("Failed to delete API key: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
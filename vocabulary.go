@@ -0,0 +1,206 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Vocabulary bands limit a topic's generated sentences to the most frequent German words:
+// with a band of 2000, every word of a sentence must be a form of one of the 2000 most
+// frequent lemmas. The band is asked for in the generation prompt, and since models don't
+// always keep to it, each generated sentence is also checked against vocabulary_de.txt, an
+// embedded frequency list. Sentences with a word outside the band are rejected like
+// malformed exercises. Exercises cached before a band was set are kept; regenerating the
+// topic replaces them.
+//
+// The list has lemmas, so words are matched to them with a few inflection rules: endings
+// are stripped (Kindern, machte, schönsten), ge-...-t participles and umlauts are undone
+// (gemacht, Häuser), and separable verbs are joined (aufgestanden, anzurufen). Irregular
+// forms are listed next to their lemma. The rules are lenient: a word that happens to look
+// like an inflected frequent one passes.
+
+//go:embed vocabulary_de.txt
+var vocabularyList string
+
+const minVocabularyBand = 200
+
+// vocabularyLemmas maps each lowercase form (and lemma) to its lemma, vocabularyRanks each
+// lemma to its rank, from 1
+var vocabularyLemmas, vocabularyRanks, vocabularySize = parseVocabulary(vocabularyList)
+
+// Endings stripped to find a lemma, longest first
+var inflectionSuffixes = []string{"sten", "test", "ste", "tet", "ten", "est", "te", "et", "st", "en", "em", "er", "es", "e", "n", "s", "t"}
+
+var separablePrefixes = []string{
+	"zurück", "zusammen", "weiter", "vorbei", "heraus", "hinaus", "herein", "hinein", "mit", "nach",
+	"auf", "aus", "ein", "fest", "fort", "weg", "vor", "los", "ab", "an", "bei", "her", "hin", "zu",
+}
+
+// parseVocabulary reads the frequency list. A form listed under several lemmas belongs to
+// the most frequent one.
+func parseVocabulary(list string) (map[string]string, map[string]int, int) {
+	lemmas := make(map[string]string)
+	ranks := make(map[string]int)
+	size := 0
+	for _, line := range strings.Split(list, "\n") {
+		forms := strings.Fields(strings.ToLower(line))
+		if len(forms) == 0 || strings.HasPrefix(forms[0], "#") {
+			continue
+		}
+		size++
+		lemma := forms[0]
+		if _, ok := ranks[lemma]; !ok {
+			ranks[lemma] = size
+		}
+		for _, form := range forms {
+			if _, ok := lemmas[form]; !ok {
+				lemmas[form] = lemma
+			}
+		}
+	}
+	return lemmas, ranks, size
+}
+
+// validateVocabularyBand checks a topic's band: 0 for none, or at least minVocabularyBand
+// and at most the size of the frequency list.
+func validateVocabularyBand(band int) error {
+	if band != 0 && (band < minVocabularyBand || band > vocabularySize) {
+		return fmt.Errorf("vocabulary band must be between %d and %d, or 0 for none", minVocabularyBand, vocabularySize)
+	}
+	return nil
+}
+
+// withoutUmlauts undoes the umlauts of plurals and comparatives (Häuser, größer).
+func withoutUmlauts(word string) string {
+	return strings.NewReplacer("ä", "a", "ö", "o", "ü", "u").Replace(word)
+}
+
+// lemmaCandidates returns the words a form could be inflected from.
+func lemmaCandidates(word string) []string {
+	stems := []string{word}
+	if rest, ok := strings.CutPrefix(word, "ge"); ok && utf8.RuneCountInString(rest) > 3 {
+		stems = append(stems, rest) // gemacht, gearbeitet
+	}
+	for _, stem := range stems {
+		for _, suffix := range inflectionSuffixes {
+			if rest, ok := strings.CutSuffix(stem, suffix); ok && utf8.RuneCountInString(rest) >= 2 {
+				stems = append(stems, rest)
+			}
+		}
+	}
+	var candidates []string
+	for _, stem := range stems {
+		for _, candidate := range []string{stem, stem + "en", stem + "n", stem + "e"} {
+			candidates = append(candidates, candidate)
+			if plain := withoutUmlauts(candidate); plain != candidate {
+				candidates = append(candidates, plain)
+			}
+		}
+	}
+	return candidates
+}
+
+// lemmaOf returns the most frequent lemma a word could be a form of, or "".
+func lemmaOf(word string) string {
+	best := ""
+	for _, candidate := range lemmaCandidates(word) {
+		if lemma, ok := vocabularyLemmas[candidate]; ok && (best == "" || vocabularyRanks[lemma] < vocabularyRanks[best]) {
+			best = lemma
+		}
+	}
+	return best
+}
+
+// wordRank returns the frequency rank of a word's lemma, or 0 if it isn't in the list.
+func wordRank(word string) int {
+	word = strings.Trim(strings.TrimSuffix(strings.ToLower(word), "'s"), "'")
+	if lemma := lemmaOf(word); lemma != "" {
+		return vocabularyRanks[lemma]
+	}
+	// A separable verb with its prefix attached: aufgestanden, anzurufen
+	for _, prefix := range separablePrefixes {
+		rest, ok := strings.CutPrefix(word, prefix)
+		if !ok || utf8.RuneCountInString(rest) < 3 {
+			continue
+		}
+		for _, verb := range []string{rest, strings.TrimPrefix(rest, "zu")} {
+			if lemma := lemmaOf(verb); lemma != "" {
+				if rank, ok := vocabularyRanks[prefix+lemma]; ok {
+					return rank
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// wordsOutsideBand returns the words of a sentence whose lemma is not among the band most
+// frequent, once each. Numbers and single letters are allowed.
+func wordsOutsideBand(sentence string, band int) []string {
+	var outside []string
+	seen := make(map[string]bool)
+	for _, word := range sentenceWords(sentence) {
+		if utf8.RuneCountInString(word) < 2 || strings.ContainsAny(word, "0123456789") || seen[strings.ToLower(word)] {
+			continue
+		}
+		if rank := wordRank(word); rank == 0 || rank > band {
+			outside = append(outside, word)
+			seen[strings.ToLower(word)] = true
+		}
+	}
+	return outside
+}
+
+// checkVocabularyBand rejects an exercise whose sentence has words outside the topic's band.
+func checkVocabularyBand(content *ExerciseContent, band int) error {
+	if band == 0 {
+		return nil
+	}
+	if outside := wordsOutsideBand(content.Sentence, band); len(outside) > 0 {
+		return fmt.Errorf("sentence uses words outside the %d most frequent: %s", band, strings.Join(outside, ", "))
+	}
+	return nil
+}
+
+// withVocabularyBand appends the band to a rendered generation prompt.
+func withVocabularyBand(prompt string, band int) string {
+	if band == 0 {
+		return prompt
+	}
+	return prompt + fmt.Sprintf("\n\nUse only the %d most frequent German words (counting all forms of a word as one) in correct_german_sentence, "+
+		"and no names of people: write \"mein Freund\" or \"die Lehrerin\" instead.", band)
+}
+
+// Handle a topic's vocabulary band: PUT /api/topics/{id}/vocabulary with {"band": 2000}
+// limits its generated sentences to the 2000 most frequent lemmas; {"band": 0} removes the limit.
+func handleTopicVocabulary(w http.ResponseWriter, r *http.Request, topicID string) {
+	var req struct {
+		Band int `json:"band"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateVocabularyBand(req.Band); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	before, err := dataStore.GetTopic(topicID)
+	if err != nil {
+		writeError(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+	topic, err := dataStore.SetTopicVocabularyBand(topicID, req.Band)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to update vocabulary band: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, auditTopicVocabulary, "topic", topicID, before, topic)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(topic)
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+)
+
+const (
+	maxWebhookNameLen       = 60
+	webhookSignatureHeader  = "X-Webhook-Signature"
+	webhookSummaryCheckTick = time.Hour
+)
+
+// Webhook events
+const (
+	webhookGenerationFailed = "generation_failed" // an exercise generation call failed
+	webhookExerciseFlagged  = "exercise_flagged"  // generated exercises failed validation, or a learner flagged one
+	webhookUserSignup       = "user_signup"       // a new account was created
+	webhookDailySummary     = "daily_summary"     // yesterday's usage totals, sent once a day
+	webhookTopicSuggested   = "topic_suggested"   // the model proposed topics for a learner's weak spots
+	webhookExerciseRetired  = "exercise_retired"  // the retirement policy retired exercises
+)
+
+var webhookEvents = []string{webhookGenerationFailed, webhookExerciseFlagged, webhookUserSignup, webhookDailySummary, webhookTopicSuggested, webhookExerciseRetired}
+
+// Webhook formats: Slack and Discord incoming webhooks get a chat message, json gets the
+// whole event, signed with the webhook's secret if it has one.
+const (
+	webhookFormatJSON    = "json"
+	webhookFormatSlack   = "slack"
+	webhookFormatDiscord = "discord"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// Webhook is an outgoing notification of admin events, managed under /api/admin/webhooks.
+type Webhook struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	URL           string    `json:"url"`
+	Format        string    `json:"format"`
+	Events        []string  `json:"events"`
+	Enabled       bool      `json:"enabled"`
+	Secret        string    `json:"-"`
+	HasSecret     bool      `json:"has_secret"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastSummaryAt time.Time `json:"last_summary_at,omitzero"`
+}
+
+type WebhookRequest struct {
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Format  string   `json:"format"`
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled"`
+	Secret  *string  `json:"secret"` // json format only; "" removes it
+}
+
+// WebhookEvent is what a json webhook receives. Text is the message posted to Slack and Discord.
+type WebhookEvent struct {
+	Event     string    `json:"event"`
+	Text      string    `json:"text"`
+	Data      any       `json:"data,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var (
+	webhooksMutex  sync.Mutex
+	memoryWebhooks = make(map[string]*Webhook) // with in-memory storage
+)
+
+func webhookFromRecord(record *airtable.Record) *Webhook {
+	webhook := &Webhook{ID: record.ID, Events: []string{}}
+	if val, ok := record.Fields["Name"].(string); ok {
+		webhook.Name = val
+	}
+	if val, ok := record.Fields["URL"].(string); ok {
+		webhook.URL = val
+	}
+	if val, ok := record.Fields["Format"].(string); ok {
+		webhook.Format = val
+	}
+	if val, ok := record.Fields["Events"].(string); ok && val != "" {
+		webhook.Events = strings.Split(val, ",")
+	}
+	if val, ok := record.Fields["Enabled"].(bool); ok {
+		webhook.Enabled = val
+	}
+	if val, ok := record.Fields["Secret"].(string); ok {
+		webhook.Secret = readSecret(val, webhooksTableName, "Secret", "")
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			webhook.CreatedAt = t
+		}
+	}
+	if val, ok := record.Fields["LastSummaryAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			webhook.LastSummaryAt = t
+		}
+	}
+	webhook.HasSecret = webhook.Secret != ""
+	return webhook
+}
+
+func getWebhooks() ([]*Webhook, error) {
+	webhooks := []*Webhook{}
+	if airtableBaseID == "" {
+		webhooksMutex.Lock()
+		for _, stored := range memoryWebhooks {
+			c := *stored
+			webhooks = append(webhooks, &c)
+		}
+		webhooksMutex.Unlock()
+	} else {
+		table := airtableClient.GetTable(airtableBaseID, webhooksTableName)
+		records, err := getAllRecords(table.GetRecords())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get webhooks from Airtable: %v", err)
+		}
+		for _, record := range records.Records {
+			webhooks = append(webhooks, webhookFromRecord(record))
+		}
+	}
+	slices.SortFunc(webhooks, func(a, b *Webhook) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return webhooks, nil
+}
+
+func getWebhook(id string) (*Webhook, error) {
+	webhooks, err := getWebhooks()
+	if err != nil {
+		return nil, err
+	}
+	for _, webhook := range webhooks {
+		if webhook.ID == id {
+			return webhook, nil
+		}
+	}
+	return nil, nil
+}
+
+// saveWebhook creates or updates a webhook.
+func saveWebhook(webhook *Webhook) error {
+	webhook.HasSecret = webhook.Secret != ""
+	if airtableBaseID == "" {
+		webhooksMutex.Lock()
+		if webhook.ID == "" {
+			webhook.ID = fmt.Sprintf("webhook%d", time.Now().UnixNano())
+		}
+		c := *webhook
+		memoryWebhooks[webhook.ID] = &c
+		webhooksMutex.Unlock()
+		return nil
+	}
+
+	secret, err := sealSecret(webhook.Secret, webhooksTableName, "Secret", "")
+	if err != nil {
+		return err
+	}
+	fields := map[string]any{
+		"Name":      webhook.Name,
+		"URL":       webhook.URL,
+		"Format":    webhook.Format,
+		"Events":    strings.Join(webhook.Events, ","),
+		"Enabled":   webhook.Enabled,
+		"Secret":    secret,
+		"CreatedAt": webhook.CreatedAt.Format(time.RFC3339),
+	}
+	if !webhook.LastSummaryAt.IsZero() {
+		fields["LastSummaryAt"] = webhook.LastSummaryAt.Format(time.RFC3339)
+	}
+	table := airtableClient.GetTable(airtableBaseID, webhooksTableName)
+	records := &airtable.Records{Records: []*airtable.Record{{ID: webhook.ID, Fields: fields}}}
+	if webhook.ID != "" {
+		if _, err := table.UpdateRecordsPartial(records); err != nil {
+			return fmt.Errorf("failed to update webhook in Airtable: %v", err)
+		}
+		return nil
+	}
+	result, err := table.AddRecords(records)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook in Airtable: %v", err)
+	}
+	if len(result.Records) > 0 {
+		webhook.ID = result.Records[0].ID
+	}
+	return nil
+}
+
+func deleteWebhook(id string) error {
+	if airtableBaseID == "" {
+		webhooksMutex.Lock()
+		delete(memoryWebhooks, id)
+		webhooksMutex.Unlock()
+		return nil
+	}
+	table := airtableClient.GetTable(airtableBaseID, webhooksTableName)
+	if _, err := table.DeleteRecords([]string{id}); err != nil {
+		return fmt.Errorf("failed to delete webhook: %v", err)
+	}
+	return nil
+}
+
+// webhookPayload renders an event in the webhook's format.
+func webhookPayload(webhook *Webhook, event WebhookEvent) ([]byte, error) {
+	switch webhook.Format {
+	case webhookFormatSlack:
+		return json.Marshal(map[string]string{"text": event.Text})
+	case webhookFormatDiscord:
+		return json.Marshal(map[string]string{"content": event.Text})
+	default:
+		return json.Marshal(event)
+	}
+}
+
+// deliverWebhook posts an event to one webhook. json payloads are signed with an HMAC-SHA256
+// of the body in X-Webhook-Signature ("sha256=<hex>") when the webhook has a secret.
+func deliverWebhook(webhook *Webhook, event WebhookEvent) error {
+	body, err := webhookPayload(webhook, event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if webhook.Format == webhookFormatJSON && webhook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(webhook.Secret))
+		mac.Write(body)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyWebhooks sends an event to every enabled webhook subscribed to it, in the background
+// so the request that caused it doesn't wait. Failed deliveries are logged, not retried.
+func notifyWebhooks(eventType, text string, data any) {
+	event := WebhookEvent{Event: eventType, Text: text, Data: data, CreatedAt: time.Now().UTC()}
+	go func() {
+		webhooks, err := getWebhooks()
+		if err != nil {
+			log.Printf("Warning: failed to load webhooks for %s: %v", eventType, err)
+			return
+		}
+		for _, webhook := range webhooks {
+			if !webhook.Enabled || !slices.Contains(webhook.Events, eventType) {
+				continue
+			}
+			if err := deliverWebhook(webhook, event); err != nil {
+				log.Printf("Warning: failed to deliver %s to webhook %s: %v", eventType, webhook.Name, err)
+			}
+		}
+	}()
+}
+
+// startWebhookSummaryScheduler sends yesterday's (UTC) usage totals to daily_summary
+// webhooks, checking hourly for webhooks that haven't had one today.
+func startWebhookSummaryScheduler() {
+	go func() {
+		for {
+			sendDailySummaries(time.Now().UTC())
+			time.Sleep(webhookSummaryCheckTick)
+		}
+	}()
+}
+
+func sendDailySummaries(now time.Time) {
+	webhooks, err := getWebhooks()
+	if err != nil {
+		log.Printf("Warning: failed to load webhooks for daily summaries: %v", err)
+		return
+	}
+	today := now.Truncate(24 * time.Hour)
+	var due []*Webhook
+	for _, webhook := range webhooks {
+		if webhook.Enabled && slices.Contains(webhook.Events, webhookDailySummary) && webhook.LastSummaryAt.Before(today) {
+			due = append(due, webhook)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	yesterday := today.AddDate(0, 0, -1)
+	events, err := getAnalyticsEvents(yesterday, today)
+	if err != nil {
+		log.Printf("Warning: failed to build daily summary: %v", err)
+		return
+	}
+	totals := AnalyticsDay{Date: yesterday.Format(analyticsDateLayout)}
+	for _, event := range events {
+		totals.add(event)
+	}
+	text := fmt.Sprintf("Usage on %s: %d active users, %d exercises served (%.0f%% from cache), %d exercises generated, %d failed generations",
+		totals.Date, totals.ActiveUsers, totals.ExercisesServed, totals.CacheHitRate*100, totals.ExercisesGenerated, totals.GenerationFailures)
+	event := WebhookEvent{Event: webhookDailySummary, Text: text, Data: totals, CreatedAt: now}
+
+	for _, webhook := range due {
+		if err := deliverWebhook(webhook, event); err != nil {
+			log.Printf("Warning: failed to deliver daily summary to webhook %s: %v", webhook.Name, err)
+			continue
+		}
+		webhook.LastSummaryAt = now
+		if err := saveWebhook(webhook); err != nil {
+			log.Printf("Warning: failed to record daily summary for webhook %s: %v", webhook.Name, err)
+		}
+	}
+}
+
+// applyWebhookRequest validates a create or update request and applies it to webhook.
+func applyWebhookRequest(webhook *Webhook, req WebhookRequest) error {
+	if req.Name != "" || webhook.Name == "" {
+		webhook.Name = strings.TrimSpace(req.Name)
+	}
+	if webhook.Name == "" || len(webhook.Name) > maxWebhookNameLen {
+		return fmt.Errorf("name is required and must be at most %d characters", maxWebhookNameLen)
+	}
+	if req.URL != "" || webhook.URL == "" {
+		u, err := url.Parse(req.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url must be an http(s) URL")
+		}
+		webhook.URL = req.URL
+	}
+	if req.Format != "" || webhook.Format == "" {
+		switch req.Format {
+		case webhookFormatJSON, webhookFormatSlack, webhookFormatDiscord:
+			webhook.Format = req.Format
+		case "":
+			webhook.Format = webhookFormatJSON
+		default:
+			return fmt.Errorf("format must be json, slack or discord")
+		}
+	}
+	if req.Events != nil {
+		if len(req.Events) == 0 {
+			return fmt.Errorf("events must name at least one of %s", strings.Join(webhookEvents, ", "))
+		}
+		events := []string{}
+		for _, event := range req.Events {
+			if !slices.Contains(webhookEvents, event) {
+				return fmt.Errorf("unknown event %q; events are %s", event, strings.Join(webhookEvents, ", "))
+			}
+			if !slices.Contains(events, event) {
+				events = append(events, event)
+			}
+		}
+		webhook.Events = events
+	} else if len(webhook.Events) == 0 {
+		return fmt.Errorf("events must name at least one of %s", strings.Join(webhookEvents, ", "))
+	}
+	if req.Enabled != nil {
+		webhook.Enabled = *req.Enabled
+	}
+	if req.Secret != nil {
+		webhook.Secret = *req.Secret
+	}
+	return nil
+}
+
+// Handle webhooks (admin):
+// GET /api/admin/webhooks lists them,
+// POST /api/admin/webhooks creates one: {"name": "Ops", "url": "https://hooks.slack.com/...", "format": "slack", "events": ["generation_failed"]},
+// PUT /api/admin/webhooks/{id} changes the given fields, DELETE /api/admin/webhooks/{id} removes one,
+// POST /api/admin/webhooks/{id}/test sends a test event and reports whether it was delivered.
+func handleAdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/webhooks"), "/"), "/")
+	id := parts[0]
+
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			webhooks, err := getWebhooks()
+			if err != nil {
+				writeError(w, fmt.Sprintf("Failed to get webhooks: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"webhooks": webhooks, "events": webhookEvents})
+
+		case http.MethodPost:
+			var req WebhookRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			// Summaries start the day after the webhook is created
+			now := time.Now().UTC()
+			webhook := &Webhook{Enabled: true, CreatedAt: now, LastSummaryAt: now}
+			if err := applyWebhookRequest(webhook, req); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := saveWebhook(webhook); err != nil {
+				writeError(w, fmt.Sprintf("Failed to create webhook: %v", err), http.StatusInternalServerError)
+				return
+			}
+			recordAudit(r, auditWebhookCreate, "webhook", webhook.ID, nil, webhook)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(webhook)
+
+		default:
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	webhook, err := getWebhook(id)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to get webhook: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if webhook == nil {
+		writeError(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	if len(parts) > 1 {
+		if parts[1] != "test" || len(parts) > 2 {
+			writeError(w, "Not found", http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		event := WebhookEvent{Event: "test", Text: fmt.Sprintf("Test notification for webhook %s", webhook.Name), CreatedAt: time.Now().UTC()}
+		if err := deliverWebhook(webhook, event); err != nil {
+			writeError(w, fmt.Sprintf("Delivery failed: %v", err), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"delivered": true})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(webhook)
+
+	case http.MethodPut:
+		var req WebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		before := *webhook
+		if err := applyWebhookRequest(webhook, req); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := saveWebhook(webhook); err != nil {
+			writeError(w, fmt.Sprintf("Failed to update webhook: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, auditWebhookUpdate, "webhook", webhook.ID, &before, webhook)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(webhook)
+
+	case http.MethodDelete:
+		if err := deleteWebhook(webhook.ID); err != nil {
+			writeError(w, fmt.Sprintf("Failed to delete webhook: %v", err), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, auditWebhookDelete, "webhook", webhook.ID, webhook, nil)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mehanizm/airtable"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Writing correction: POST /api/correct takes a paragraph the learner wrote in German and
+// has the model correct it, listing each mistake with a category from writingErrorCategories.
+// The mistakes are recorded in the WritingErrors table for the progress owner, and count
+// towards the weak patterns that topic suggestions are made for (see getWeakPatterns): a
+// mistake with a conjunction joins that conjunction's pattern, others are grouped by category.
+const (
+	maxWritingLength = 2000
+	maxWritingErrors = 30
+)
+
+// Error categories the model must choose from; anything else is recorded as "other".
+var writingErrorCategories = []string{
+	"word_order", "conjunction", "verb_form", "case", "gender", "agreement", "preposition",
+	"spelling", "punctuation", "vocabulary", "other",
+}
+
+// writingCorrectionPrompt starts every correction request; the mock LLM recognizes it by this.
+const writingCorrectionPrompt = "Correct this German text written by a language learner."
+
+const writingCorrectionInstructions = `
+
+Reply with a JSON object {"corrected": "...", "errors": [{"category": "...", "original": "...", "corrected": "...", "explanation": "...", "conjunction": "..."}]}. "corrected" is the whole text with every mistake fixed and nothing else changed. List each mistake once: "original" is the wrong words as written, "corrected" their correction, and "explanation" one short English sentence on the rule. "category" is one of %s. "conjunction" is the conjunction involved, if the mistake is about a conjunction or the word order it causes (e.g. the verb not at the end after "weil"); otherwise "". If there are no mistakes, "errors" is empty.
+
+The text:
+
+%s`
+
+// WritingCorrection is what POST /api/correct returns.
+type WritingCorrection struct {
+	Original   string                `json:"original"`
+	Corrected  string                `json:"corrected"`
+	Errors     []*WritingErrorDetail `json:"errors"`
+	Categories map[string]int        `json:"categories"` // number of errors per category
+}
+
+// WritingErrorDetail is one mistake in a corrected text.
+type WritingErrorDetail struct {
+	Category    string `json:"category"`
+	Original    string `json:"original"`
+	Corrected   string `json:"corrected"`
+	Explanation string `json:"explanation"`
+	Conjunction string `json:"conjunction,omitempty"`
+}
+
+// WritingError is a recorded mistake, for the weakness analysis.
+type WritingError struct {
+	ID          string
+	OwnerID     string
+	Category    string
+	Conjunction string
+	Original    string
+	Corrected   string
+	CreatedAt   time.Time
+}
+
+var (
+	writingErrorsMutex  sync.Mutex
+	memoryWritingErrors []*WritingError // with in-memory storage
+)
+
+func writingErrorFromRecord(record *airtable.Record) *WritingError {
+	writingError := &WritingError{ID: record.ID}
+	if val, ok := record.Fields["OwnerID"].(string); ok {
+		writingError.OwnerID = val
+	}
+	if val, ok := record.Fields["Category"].(string); ok {
+		writingError.Category = val
+	}
+	if val, ok := record.Fields["Conjunction"].(string); ok {
+		writingError.Conjunction = val
+	}
+	if val, ok := record.Fields["Original"].(string); ok {
+		writingError.Original = val
+	}
+	if val, ok := record.Fields["Corrected"].(string); ok {
+		writingError.Corrected = val
+	}
+	if val, ok := record.Fields["CreatedAt"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			writingError.CreatedAt = t
+		}
+	}
+	return writingError
+}
+
+// recordWritingErrors stores the mistakes of one corrected text.
+func recordWritingErrors(ownerID string, details []*WritingErrorDetail) error {
+	now := time.Now().UTC()
+	if airtableBaseID == "" {
+		writingErrorsMutex.Lock()
+		defer writingErrorsMutex.Unlock()
+		for _, detail := range details {
+			memoryWritingErrors = append(memoryWritingErrors, &WritingError{
+				OwnerID:     ownerID,
+				Category:    detail.Category,
+				Conjunction: detail.Conjunction,
+				Original:    detail.Original,
+				Corrected:   detail.Corrected,
+				CreatedAt:   now,
+			})
+		}
+		return nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, writingErrorsTableName)
+	for start := 0; start < len(details); start += 10 {
+		records := &airtable.Records{}
+		for _, detail := range details[start:min(start+10, len(details))] {
+			records.Records = append(records.Records, &airtable.Record{
+				Fields: map[string]any{
+					"OwnerID":     ownerID,
+					"Category":    detail.Category,
+					"Conjunction": detail.Conjunction,
+					"Original":    detail.Original,
+					"Corrected":   detail.Corrected,
+					"CreatedAt":   now.Format(time.RFC3339),
+				},
+			})
+		}
+		if _, err := table.AddRecords(records); err != nil {
+			return fmt.Errorf("failed to record writing errors in Airtable: %v", err)
+		}
+	}
+	return nil
+}
+
+// getWritingErrors returns the owner's recorded mistakes made after since.
+func getWritingErrors(ownerID string, since time.Time) ([]*WritingError, error) {
+	var writingErrors []*WritingError
+	if airtableBaseID == "" {
+		writingErrorsMutex.Lock()
+		defer writingErrorsMutex.Unlock()
+		for _, writingError := range memoryWritingErrors {
+			if writingError.OwnerID == ownerID && writingError.CreatedAt.After(since) {
+				c := *writingError
+				writingErrors = append(writingErrors, &c)
+			}
+		}
+		return writingErrors, nil
+	}
+
+	table := airtableClient.GetTable(airtableBaseID, writingErrorsTableName)
+	formula := fmt.Sprintf("AND({OwnerID} = '%s', IS_AFTER({CreatedAt}, '%s'))", ownerID, since.UTC().Format(time.RFC3339))
+	records, err := getAllRecords(table.GetRecords().WithFilterFormula(formula))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get writing errors from Airtable: %v", err)
+	}
+	for _, record := range records.Records {
+		writingErrors = append(writingErrors, writingErrorFromRecord(record))
+	}
+	return writingErrors, nil
+}
+
+// reassignWritingErrors moves every writing error of one owner to another, when a guest signs in.
+func reassignWritingErrors(fromOwnerID, toOwnerID string) error {
+	if airtableBaseID == "" {
+		writingErrorsMutex.Lock()
+		defer writingErrorsMutex.Unlock()
+		for _, writingError := range memoryWritingErrors {
+			if writingError.OwnerID == fromOwnerID {
+				writingError.OwnerID = toOwnerID
+			}
+		}
+		return nil
+	}
+
+	writingErrors, err := getWritingErrors(fromOwnerID, time.Time{})
+	if err != nil {
+		return err
+	}
+	table := airtableClient.GetTable(airtableBaseID, writingErrorsTableName)
+	for start := 0; start < len(writingErrors); start += 10 {
+		records := &airtable.Records{}
+		for _, writingError := range writingErrors[start:min(start+10, len(writingErrors))] {
+			records.Records = append(records.Records, &airtable.Record{
+				ID:     writingError.ID,
+				Fields: map[string]any{"OwnerID": toOwnerID},
+			})
+		}
+		if _, err := table.UpdateRecords(records); err != nil {
+			return fmt.Errorf("failed to reassign writing errors: %v", err)
+		}
+	}
+	return nil
+}
+
+// correctWriting asks the model to correct a text and categorize its mistakes.
+func correctWriting(ctx context.Context, text string) (correction *WritingCorrection, err error) {
+	if offlineMode() {
+		return nil, errOfflineMode
+	}
+	llm := llmProviderFor(ctx)
+	ctx, end := startSpan(ctx, "correct writing", attribute.String("llm.model", llm.Model), attribute.Int("text.length", len(text)))
+	defer func() { end(err) }()
+
+	prompt := writingCorrectionPrompt + fmt.Sprintf(writingCorrectionInstructions, strings.Join(writingErrorCategories, ", "), text)
+	content, _, err := complete(ctx, llm, OpenAIRequest{
+		Messages:       []Message{{Role: "user", Content: prompt}},
+		ResponseFormat: &ResponseFormat{Type: "json_object"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get correction: %w", err)
+	}
+
+	correction = &WritingCorrection{}
+	if err := json.Unmarshal([]byte(content), correction); err != nil {
+		return nil, fmt.Errorf("failed to parse correction: %w", err)
+	}
+	correction.Original = text
+	correction.Corrected = strings.TrimSpace(correction.Corrected)
+	if correction.Corrected == "" {
+		return nil, fmt.Errorf("received an empty correction")
+	}
+
+	details := []*WritingErrorDetail{}
+	correction.Categories = make(map[string]int)
+	for _, detail := range correction.Errors {
+		if detail == nil || len(details) == maxWritingErrors {
+			continue
+		}
+		detail.Category = strings.ToLower(strings.TrimSpace(detail.Category))
+		if !slices.Contains(writingErrorCategories, detail.Category) {
+			detail.Category = "other"
+		}
+		detail.Original = strings.TrimSpace(detail.Original)
+		detail.Corrected = strings.TrimSpace(detail.Corrected)
+		detail.Explanation = strings.TrimSpace(detail.Explanation)
+		detail.Conjunction = strings.ToLower(strings.TrimSpace(detail.Conjunction))
+		details = append(details, detail)
+		correction.Categories[detail.Category]++
+	}
+	correction.Errors = details
+	return correction, nil
+}
+
+// Handle writing correction, for users and guests: POST /api/correct with
+// {"text": "Gestern ich bin ..."} returns a WritingCorrection.
+func handleCorrect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		writeError(w, "text is required", http.StatusBadRequest)
+		return
+	}
+	if len([]rune(text)) > maxWritingLength {
+		writeError(w, fmt.Sprintf("text must be at most %d characters", maxWritingLength), http.StatusBadRequest)
+		return
+	}
+	if offlineMode() {
+		writeOfflineError(w)
+		return
+	}
+	if err := useModelQuota(r.Context(), getUserIDFromRequest(r)); err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	ownerID := getProgressOwnerID(w, r)
+
+	correction, err := correctWriting(r.Context(), text)
+	if errors.Is(err, errOfflineMode) {
+		writeOfflineError(w)
+		return
+	}
+	if err != nil {
+		log.Printf("Error correcting writing of %s: %v", ownerID, err)
+		writeError(w, "Failed to correct the text", http.StatusBadGateway)
+		return
+	}
+	if err := recordWritingErrors(ownerID, correction.Errors); err != nil {
+		// The learner still gets the correction; only the weakness analysis misses it
+		log.Printf("Warning: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(correction)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"time"
+)
+
+// XP is computed on the server from the stats events, so every device shows the same
+// points and level. Totals from before events were kept earn the base points only.
+const (
+	xpPerCorrectAnswer = 10 // per exercise answered right first time
+	xpNoHintBonus      = 25 // per finished set that used no hints
+	xpStreakBonus      = 5  // per day of the streak, for the first set of each day
+	xpMaxStreakBonus   = 50
+	xpLevelStep        = 100 // level n+1 takes xpLevelStep*n more XP than level n
+)
+
+// XPProgress is a learner's XP and level. LevelXP is the XP the current level started at,
+// NextLevelXP where the next one starts.
+type XPProgress struct {
+	XP          int `json:"xp"`
+	Level       int `json:"level"`
+	LevelXP     int `json:"level_xp"`
+	NextLevelXP int `json:"next_level_xp"`
+}
+
+// correctAnswers estimates the answers right first time: every exercise is answered
+// eventually, and each mistake means it wasn't.
+func correctAnswers(exercises, mistakes int) int {
+	return max(exercises-mistakes, 0)
+}
+
+// computeXP adds up the XP of the baseline totals and the events (oldest first).
+func computeXP(baseline *UserStats, events []*StatsEvent) int {
+	xp := correctAnswers(baseline.TotalExercises, baseline.TotalMistakes) * xpPerCorrectAnswer
+
+	days := make(map[string]bool)
+	for _, event := range events {
+		xp += correctAnswers(event.Exercises, event.Mistakes) * xpPerCorrectAnswer
+		if event.Exercises > 0 && event.Hints == 0 {
+			xp += xpNoHintBonus
+		}
+
+		day := event.CreatedAt.UTC()
+		if days[day.Format(time.DateOnly)] {
+			continue
+		}
+		days[day.Format(time.DateOnly)] = true
+		streak := 0
+		for days[day.AddDate(0, 0, -streak-1).Format(time.DateOnly)] {
+			streak++
+		}
+		// The first day of a streak earns nothing extra
+		xp += min(streak*xpStreakBonus, xpMaxStreakBonus)
+	}
+	return xp
+}
+
+// xpLevel returns the level for an amount of XP. Level 1 starts at 0 XP, level 2 at 100,
+// level 3 at 300, level 4 at 600 and so on.
+func xpLevel(xp int) XPProgress {
+	progress := XPProgress{XP: xp, Level: 1, NextLevelXP: xpLevelStep}
+	for xp >= progress.NextLevelXP {
+		progress.LevelXP = progress.NextLevelXP
+		progress.Level++
+		progress.NextLevelXP += xpLevelStep * progress.Level
+	}
+	return progress
+}